@@ -45,7 +45,7 @@ int main(void) {
     if (result == 0) {
         printf("Success! PDF saved to '%s'\n", filename);
     } else {
-        fprintf(stderr, "Error: Failed to save PDF\n");
+        fprintf(stderr, "Error: Failed to save PDF: %s\n", pdf_last_error());
     }
 
     /* Free the handle */
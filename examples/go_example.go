@@ -25,16 +25,22 @@ typedef struct PdfHandle PdfHandle;
 PdfHandle* pdf_create_simple(const char* text, double font_size);
 size_t pdf_get_data(const PdfHandle* handle, const uint8_t** out_data);
 int pdf_save_to_file(const PdfHandle* handle, const char* path);
+const char* pdf_last_error(void);
 void pdf_free(PdfHandle* handle);
 const char* pdf_version(void);
 */
 import "C"
 import (
 	"fmt"
+	"runtime"
 	"unsafe"
 )
 
 func main() {
+	// pdf_last_error is per OS thread, so keep this goroutine on one thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
 	fmt.Println("rust-pdf Go Example")
 	fmt.Println("===================\n")
 
@@ -70,7 +76,7 @@ func main() {
 	if result == 0 {
 		fmt.Println("Success! PDF saved.")
 	} else {
-		fmt.Println("Error: Failed to save PDF")
+		fmt.Printf("Error: Failed to save PDF: %s\n", C.GoString(C.pdf_last_error()))
 	}
 
 	fmt.Println("\nDone.")
@@ -15,21 +15,2385 @@
 extern "C" {
 #endif
 
-/* Opaque handle to a PDF document */
+/*
+ * Opaque handle to a PDF document.
+ *
+ * A handle may be passed between threads but must not be used by two
+ * threads at the same time. A call made while another call is using the
+ * handle fails with PDF_ERR_BUSY (or NULL / 0 for functions that do not
+ * return an error code). Use pdf_clone() to give each thread its own copy.
+ *
+ * Every function checks the handle before using it: a NULL handle, or one
+ * that has already been freed, fails with PDF_ERR_INVALID_ARGUMENT (or
+ * NULL / 0) and sets pdf_last_error() instead of crashing. Detecting a freed
+ * handle is best effort, since its memory may be reused by a new handle.
+ */
 typedef struct PdfHandle PdfHandle;
 
 /*
- * Create a simple PDF with text.
+ * Receives a chunk of serialized PDF bytes from pdf_write_to_callback.
+ * The chunk is only valid during the call. Return 0 to continue, or
+ * nonzero to abort serialization.
+ */
+typedef int (*PdfWriteCallback)(const uint8_t* chunk, size_t len, void* user_data);
+
+/*
+ * Receives the progress of a long operation set up with
+ * pdf_set_progress_callback: the fraction done from 0.0 to 1.0 and the
+ * stage ("merge", "serialize" or "render"). Return 0 to continue, or
+ * nonzero to cancel the operation.
+ */
+typedef int (*PdfProgressCallback)(double fraction, const char* stage, void* user_data);
+
+/* Page size presets */
+typedef enum PdfPageSize {
+    PDF_PAGE_LETTER = 0, /* 612 x 792 points */
+    PDF_PAGE_A4     = 1, /* 595.28 x 841.89 points */
+    PDF_PAGE_LEGAL  = 2, /* 612 x 1008 points */
+    PDF_PAGE_A3     = 3, /* 841.89 x 1190.55 points */
+    PDF_PAGE_A5     = 4  /* 419.53 x 595.28 points */
+} PdfPageSize;
+
+/* A color stop for pdf_set_fill_linear_gradient and pdf_set_fill_radial_gradient */
+typedef struct PdfGradientStop {
+    double offset; /* position along the gradient, 0.0 to 1.0 */
+    double r;      /* color components, 0.0 to 1.0 (clamped) */
+    double g;
+    double b;
+} PdfGradientStop;
+
+/* Error codes (pdf_last_error() returns a message describing the failure) */
+#define PDF_OK                     0
+#define PDF_ERR_INVALID_ARGUMENT  -1
+#define PDF_ERR_PAGE_OUT_OF_RANGE -2
+#define PDF_ERR_INVALID_IMAGE     -3
+#define PDF_ERR_UNSUPPORTED       -4
+#define PDF_ERR_INVALID_FONT      -5
+#define PDF_ERR_BUSY              -6
+#define PDF_ERR_ABORTED           -7
+#define PDF_ERR_DATA_TOO_LONG     -8 /* data exceeds the symbol's capacity */
+#define PDF_ERR_CANCELLED         -9 /* the call's cancel flag was set */
+
+/* Text alignment for pdf_add_text_aligned */
+#define PDF_ALIGN_LEFT    0
+#define PDF_ALIGN_CENTER  1
+#define PDF_ALIGN_RIGHT   2
+#define PDF_ALIGN_JUSTIFY 3 /* last line of each paragraph is left-aligned */
+
+/* Page orientations */
+#define PDF_ORIENTATION_PORTRAIT  0
+#define PDF_ORIENTATION_LANDSCAPE 1
+
+/* Text scripts for pdf_add_text_script */
+#define PDF_SCRIPT_NORMAL      0
+#define PDF_SCRIPT_SUPERSCRIPT 1 /* as in x² */
+#define PDF_SCRIPT_SUBSCRIPT   2 /* as in H₂O */
+
+/* Font id of the built-in Helvetica, for pdf_measure_text */
+#define PDF_FONT_DEFAULT (-1)
+
+/* List styles for pdf_add_list */
+#define PDF_LIST_BULLET   0
+#define PDF_LIST_DASH     1
+#define PDF_LIST_NUMBERED 2 /* 1., 2., 3., ... */
+
+/* Barcode symbologies for pdf_add_barcode */
+#define PDF_BARCODE_CODE128 0 /* all ASCII characters */
+
+/* QR code error correction levels for pdf_add_qrcode */
+#define PDF_QR_ECC_LOW      0 /* ~7% recovery */
+#define PDF_QR_ECC_MEDIUM   1 /* ~15% recovery */
+#define PDF_QR_ECC_QUARTILE 2 /* ~25% recovery */
+#define PDF_QR_ECC_HIGH     3 /* ~30% recovery */
+
+/* Associated-file relationships for pdf_attach_file */
+#define PDF_AF_NONE        0 /* plain attachment */
+#define PDF_AF_SOURCE      1
+#define PDF_AF_DATA        2
+#define PDF_AF_ALTERNATIVE 3 /* e.g. ZUGFeRD / Factur-X invoice XML */
+#define PDF_AF_SUPPLEMENT  4
+#define PDF_AF_UNSPECIFIED 5
+
+/* PDF/A conformance levels for pdf_set_pdfa_mode */
+#define PDF_PDFA_NONE 0
+#define PDF_PDFA_1B   1
+#define PDF_PDFA_2B   2
+
+/* Zoom modes for pdf_set_open_action; any positive value is a percentage */
+#define PDF_ZOOM_DEFAULT    0
+#define PDF_ZOOM_FIT_PAGE  -1
+#define PDF_ZOOM_FIT_WIDTH -2
+
+/* Page boundaries for pdf_set_page_box */
+#define PDF_BOX_CROP  0 /* region displayed and printed */
+#define PDF_BOX_BLEED 1 /* region clipped to in production, with bleed */
+#define PDF_BOX_TRIM  2 /* finished page size */
+#define PDF_BOX_ART   3 /* extent of meaningful content */
+
+/* Resource types for pdf_add_resource */
+#define PDF_RESOURCE_EXT_GSTATE  0 /* graphics states, applied by gs */
+#define PDF_RESOURCE_COLOR_SPACE 1 /* color spaces, selected by cs and CS */
+#define PDF_RESOURCE_PATTERN     2 /* patterns */
+#define PDF_RESOURCE_SHADING     3 /* shadings, painted by sh */
+#define PDF_RESOURCE_FONT        4 /* fonts, selected by Tf */
+#define PDF_RESOURCE_PROPERTIES  5 /* marked-content property lists */
+
+/* Printer's marks for pdf_add_printer_marks (combine with |) */
+#define PDF_MARKS_CROP         (1 << 0) /* cut lines at the trim box corners */
+#define PDF_MARKS_REGISTRATION (1 << 1) /* targets for lining up the plates */
+#define PDF_MARKS_COLOR_BAR    (1 << 2) /* process color and gray patches */
+#define PDF_MARKS_ALL          (PDF_MARKS_CROP | PDF_MARKS_REGISTRATION | PDF_MARKS_COLOR_BAR)
+
+/* Decorations for pdf_add_text_decorated (combine with |) */
+#define PDF_DECORATION_UNDERLINE     (1 << 0)
+#define PDF_DECORATION_STRIKETHROUGH (1 << 1)
+
+/* Flags for pdf_add_number and pdf_add_currency (combine with |) */
+#define PDF_NUMBER_PARENTHESES  (1 << 0) /* negative values in parentheses */
+#define PDF_NUMBER_SYMBOL_AFTER (1 << 1) /* currency symbol after the digits */
+
+/* Page layouts for pdf_set_open_action */
+#define PDF_LAYOUT_DEFAULT          0
+#define PDF_LAYOUT_SINGLE_PAGE      1
+#define PDF_LAYOUT_ONE_COLUMN       2
+#define PDF_LAYOUT_TWO_COLUMN_LEFT  3
+#define PDF_LAYOUT_TWO_COLUMN_RIGHT 4
+#define PDF_LAYOUT_TWO_PAGE_LEFT    5 /* PDF 1.5 */
+#define PDF_LAYOUT_TWO_PAGE_RIGHT   6 /* PDF 1.5, like a printed spread */
+
+/* Encryption algorithms for pdf_set_encryption */
+#define PDF_ENCRYPTION_RC4_128 0
+#define PDF_ENCRYPTION_AES_256 1
+
+/* Permission flags for pdf_set_encryption (combine with |) */
+#define PDF_PERMISSION_PRINT              (1 << 2)
+#define PDF_PERMISSION_MODIFY             (1 << 3)
+#define PDF_PERMISSION_COPY               (1 << 4)
+#define PDF_PERMISSION_ANNOTATE           (1 << 5)
+#define PDF_PERMISSION_FILL_FORMS         (1 << 8)
+#define PDF_PERMISSION_EXTRACT            (1 << 9)
+#define PDF_PERMISSION_ASSEMBLE           (1 << 10)
+#define PDF_PERMISSION_PRINT_HIGH_QUALITY (1 << 11)
+
+/*
+ * Create a simple PDF with text on a US Letter page.
+ *
+ * Parameters:
+ *   text      - The text content (null-terminated UTF-8 string)
+ *   font_size - Font size in points (e.g., 12.0, 24.0)
+ *
+ * Returns:
+ *   A handle to the PDF document, or NULL on failure.
+ *   Must be freed with pdf_free().
+ */
+PdfHandle* pdf_create_simple(const char* text, double font_size);
+
+/*
+ * Create a simple PDF with text on a page of the given size.
+ *
+ * Parameters:
+ *   text      - The text content (null-terminated UTF-8 string)
+ *   font_size - Font size in points
+ *   page_size - One of the PdfPageSize presets
+ *
+ * Returns:
+ *   A handle to the PDF document, or NULL on failure.
+ *   Must be freed with pdf_free().
+ */
+PdfHandle* pdf_create_simple_sized(const char* text, double font_size,
+                                   PdfPageSize page_size);
+
+/*
+ * Create a PDF with one US Letter page per string, each laid out like
+ * pdf_create_simple.
+ *
+ * Parameters:
+ *   texts      - Array of text_count strings (null-terminated UTF-8); a
+ *                NULL entry produces a blank page
+ *   text_count - Number of entries in texts
+ *   font_size  - Font size in points
+ *
+ * Returns:
+ *   A handle to the PDF document, or NULL on failure (including an entry
+ *   that is not valid UTF-8).
+ *   Must be freed with pdf_free().
+ */
+PdfHandle* pdf_create_multipage(const char* const* texts, size_t text_count,
+                                double font_size);
+
+/*
+ * Create an empty PDF document with no pages.
+ * Nothing is allocated beyond the handle, so one handle per request is cheap.
+ *
+ * Returns:
+ *   A handle to the PDF document, or NULL on failure.
+ *   Must be freed with pdf_free().
+ */
+PdfHandle* pdf_create_empty(void);
+
+/*
+ * Create an independent deep copy of a document, including its pages,
+ * loaded fonts, orientation and color state. Either handle may be freed
+ * first without affecting the other.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_* or pdf_clone
+ *
+ * Returns:
+ *   A new handle, or NULL on failure (including when handle is in use
+ *   by another thread). Must be freed with pdf_free().
+ */
+PdfHandle* pdf_clone(const PdfHandle* handle);
+
+/*
+ * Append a new page to the document.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   width  - Page width in points (0 for US Letter)
+ *   height - Page height in points (0 for US Letter)
+ *
+ * Returns:
+ *   The zero-based index of the new page, or a negative error code.
+ */
+int pdf_add_page(PdfHandle* handle, double width, double height);
+
+/*
+ * Move a page to another position, shifting the pages in between. Links,
+ * bookmarks and named destinations keep pointing at the same pages.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   from_index - Zero-based index of the page to move
+ *   to_index   - Zero-based index the page has afterwards
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_UNSUPPORTED
+ *   for an original page of a handle from pdf_open_for_update.
+ */
+int pdf_move_page(PdfHandle* handle, int from_index, int to_index);
+
+/*
+ * Delete a page; later pages move up by one. Links, bookmarks and named
+ * destinations that pointed at it go to the page taking its place, or
+ * the new last page, without their scroll positions. Bookmarks and named
+ * destinations are removed with the last page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based index of the page to delete
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_UNSUPPORTED
+ *   for an original page of a handle from pdf_open_for_update.
+ */
+int pdf_delete_page(PdfHandle* handle, int page_index);
+
+/*
+ * Append every page of an existing PDF to the document. Object numbers
+ * of the input are renumbered and resources shared by its pages are
+ * written once. The appended pages accept text and drawing like any
+ * other page. Text fields, checkboxes, combo boxes and list boxes are
+ * kept and can be filled with pdf_fill_form_field; a field named like one
+ * already in the document is renamed by appending "_2", or the first
+ * higher number that is free. Other annotations and form fields, and
+ * bookmarks, are not copied. Requires the "parser" feature.
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   data     - Bytes of the PDF file to append
+ *   data_len - Length of data in bytes
+ *
+ * Returns:
+ *   The number of pages appended, PDF_ERR_INVALID_ARGUMENT if the input
+ *   is not a valid PDF, or PDF_ERR_UNSUPPORTED if it is encrypted, uses
+ *   an unsupported feature, or the library was built without the parser.
+ */
+int pdf_append_pdf(PdfHandle* handle, const uint8_t* data, size_t data_len);
+
+/*
+ * Append every page of an existing PDF like pdf_append_pdf, also reporting
+ * how many form fields were renamed because their names were taken.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   data        - Bytes of the PDF file to append
+ *   data_len    - Length of data in bytes
+ *   out_renamed - Receives the number of fields renamed (may be NULL)
+ *
+ * Returns:
+ *   As pdf_append_pdf.
+ */
+int pdf_append_pdf_renamed(PdfHandle* handle, const uint8_t* data, size_t data_len,
+                           int* out_renamed);
+
+/*
+ * Append every page of the PDF file at a path like pdf_append_pdf_renamed,
+ * reading the file in the library so the caller need not load it. The file
+ * is mapped into memory, so only the parts holding the objects the pages
+ * use are loaded (it is read whole where memory maps are not supported).
+ *
+ * Precondition: no process may write to or truncate the file until the call
+ * returns. On systems with memory maps, reading a mapped file that has been
+ * truncated raises SIGBUS, which terminates the process, and other changes
+ * can make the pages read inconsistent.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   path        - Path of the PDF file to append
+ *   out_renamed - Receives the number of fields renamed (may be NULL)
+ *
+ * Returns:
+ *   As pdf_append_pdf, or PDF_ERR_INVALID_ARGUMENT if the file cannot be read.
+ */
+int pdf_append_pdf_from_path(PdfHandle* handle, const char* path, int* out_renamed);
+
+/*
+ * Turn recovery of damaged files on or off for the pdf_append_pdf
+ * functions, pdf_add_nup and pdf_import_page_as_xobject. With recovery on,
+ * a file whose cross-reference table cannot be read, or lists offsets
+ * where its objects are not, is read by scanning it for objects instead
+ * of being rejected. Recovery is off by default.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Non-zero to recover damaged files, 0 to reject them
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_recovery_mode(PdfHandle* handle, int enabled);
+
+/*
+ * Tell whether the file read by the last import call was damaged and
+ * recovered as described under pdf_set_recovery_mode.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *
+ * Returns:
+ *   1 if it was recovered, 0 if not, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_last_import_recovered(PdfHandle* handle);
+
+/*
+ * Append the pages of an existing PDF tiled rows x cols per page, left to
+ * right and top to bottom, e.g. 2 x 2 to proof four pages on one sheet.
+ * Each new page has the size of the first page placed on it. Source pages
+ * are centered in their cells and shrunk to fit without changing their
+ * proportions, with a 10 point gutter between and around the cells. Form
+ * fields and other annotations are not copied. Requires the "parser"
+ * feature.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   source_data - Bytes of the PDF file to tile
+ *   source_len  - Length of source_data in bytes
+ *   rows        - Cells down each page (at least 1)
+ *   cols        - Cells across each page (at least 1)
+ *
+ * Returns:
+ *   The number of pages appended, PDF_ERR_INVALID_ARGUMENT for an empty
+ *   grid or an input that is not a valid PDF, or PDF_ERR_UNSUPPORTED as
+ *   for pdf_append_pdf.
+ */
+int pdf_add_nup(PdfHandle* handle, const uint8_t* source_data, size_t source_len,
+                int rows, int cols);
+
+/*
+ * Copy one page of an existing PDF so it can be drawn on any page with
+ * pdf_draw_xobject, such as a letterhead. The page and its resources are
+ * written once however often it is drawn. Requires the "parser" feature.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   source_data - Bytes of the PDF file holding the page
+ *   source_len  - Length of source_data in bytes
+ *   source_page - Index of the page to copy (0-based)
+ *
+ * Returns:
+ *   An xobject id (>= 0), PDF_ERR_PAGE_OUT_OF_RANGE if the file has no such
+ *   page, PDF_ERR_INVALID_ARGUMENT if the input is not a valid PDF, or
+ *   PDF_ERR_UNSUPPORTED as for pdf_append_pdf.
+ */
+int pdf_import_page_as_xobject(PdfHandle* handle, const uint8_t* source_data,
+                               size_t source_len, int source_page);
+
+/*
+ * Draw a page copied with pdf_import_page_as_xobject.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Page to draw on
+ *   xobject_id - Id returned by pdf_import_page_as_xobject
+ *   x, y       - Position of the copied page's lower-left corner, in points
+ *   scale      - Size relative to the original (1.0 for the same size)
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_INVALID_ARGUMENT
+ *   for an unknown id or a scale that is not positive, or
+ *   PDF_ERR_UNSUPPORTED without the parser.
+ */
+int pdf_draw_xobject(PdfHandle* handle, int page_index, int xobject_id,
+                     double x, double y, double scale);
+
+/*
+ * Open an existing PDF for an incremental update. The handle starts with
+ * one blank page over each page of the input. Text and drawing added to
+ * those pages, and any new pages, are appended after the original bytes
+ * with a new cross-reference section when the document is written, so
+ * the original file and any signature in it stay intact. Encryption,
+ * bookmarks, named destinations, attachments and PDF/A cannot be applied
+ * to such a handle.
+ * Requires the "parser" feature.
+ *
+ * Parameters:
+ *   data     - Bytes of the PDF file to update
+ *   data_len - Length of data in bytes
+ *
+ * Returns:
+ *   A handle to free with pdf_free, or NULL if the input is not a valid
+ *   PDF or the library was built without the parser; see pdf_last_error.
+ */
+PdfHandle* pdf_open_for_update(const uint8_t* data, size_t data_len);
+
+/*
+ * Set the value of a text field, such as one on a page added by
+ * pdf_append_pdf. The field's appearance is regenerated from the new
+ * value when the document is written.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   field_name - Fully qualified field name, parent names joined by dots
+ *   value      - New value (UTF-8)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT if there is no such
+ *   field, it is not a text field, or the value exceeds its maximum length.
+ */
+int pdf_fill_form_field(PdfHandle* handle, const char* field_name, const char* value);
+
+/*
+ * Draw the values of all text fields and checkboxes into the page
+ * content and remove the fields, so the values can no longer be edited.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *
+ * Returns:
+ *   The number of fields flattened, or a negative error code.
+ */
+int pdf_flatten_form(PdfHandle* handle);
+
+/*
+ * Add a fillable text field to a page. The field starts empty and is
+ * listed in the document's AcroForm.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   name       - Field name, unique in the document and without periods
+ *   x, y       - Lower-left corner in points
+ *   width      - Field width in points
+ *   height     - Field height in points
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE for a bad page index, or
+ *   PDF_ERR_INVALID_ARGUMENT for an invalid or duplicate name or size.
+ */
+int pdf_add_text_field(PdfHandle* handle, int page_index, const char* name,
+                       double x, double y, double width, double height);
+
+/*
+ * Add a square checkbox to a page. The box starts unchecked and is
+ * listed in the document's AcroForm.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   name       - Field name, unique in the document and without periods
+ *   x, y       - Lower-left corner in points
+ *   size       - Width and height of the box in points
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE for a bad page index, or
+ *   PDF_ERR_INVALID_ARGUMENT for an invalid or duplicate name or size.
+ */
+int pdf_add_checkbox(PdfHandle* handle, int page_index, const char* name,
+                     double x, double y, double size);
+
+/*
+ * Embed a file in the document. The file is listed in the /EmbeddedFiles
+ * name tree and shown in the viewer's attachment panel.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   filename     - File name, unique among the attachments
+ *   mime_type    - MIME type such as "text/xml", or NULL
+ *   data         - File contents
+ *   data_len     - Length of data in bytes
+ *   description  - Description shown with the file, or NULL
+ *   relationship - One of the PDF_AF_* constants; anything but PDF_AF_NONE
+ *                  also lists the file as an associated file of the document
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for a missing or
+ *   duplicate file name or an unknown relationship.
+ */
+int pdf_attach_file(PdfHandle* handle, const char* filename, const char* mime_type,
+                    const uint8_t* data, size_t data_len, const char* description,
+                    int relationship);
+
+/*
+ * Set the orientation of pages added after this call.
+ * Existing pages are not changed.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   orientation - PDF_ORIENTATION_PORTRAIT or PDF_ORIENTATION_LANDSCAPE
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_orientation(PdfHandle* handle, int orientation);
+
+/*
+ * Set the orientation of an existing page by swapping its width and
+ * height. Calling it again with the same orientation has no effect.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   page_index  - Zero-based page index
+ *   orientation - PDF_ORIENTATION_PORTRAIT or PDF_ORIENTATION_LANDSCAPE
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE if the page does not
+ *   exist, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_page_orientation(PdfHandle* handle, int page_index, int orientation);
+
+/*
+ * Set the clockwise rotation with which viewers display a page, written
+ * as its /Rotate entry. Unlike pdf_rotate, this does not transform the
+ * content: coordinates for drawing on the page stay unchanged.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   degrees    - Rotation in degrees, a multiple of 90; negative values
+ *                turn the page counterclockwise
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT if degrees is not a
+ *   multiple of 90, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_set_page_rotation(PdfHandle* handle, int page_index, int degrees);
+
+/*
+ * Set a print boundary of a page, written as its /CropBox, /BleedBox,
+ * /TrimBox or /ArtBox entry. The box must lie within the page's media box;
+ * resizing the page afterwards so that it no longer does makes
+ * serialization fail.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   box_type      - One of the PDF_BOX_* constants
+ *   x, y          - Lower-left corner of the box
+ *   width, height - Size of the box; both 0 remove it
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT for an unknown box type or
+ *   a box outside the media box, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_set_page_box(PdfHandle* handle, int page_index, int box_type,
+                     double x, double y, double width, double height);
+
+/*
+ * Fill the whole media box of a page with a color beneath all of its
+ * content, including content drawn after this call and the content of
+ * imported or appended pages.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   r, g, b    - Color components (0.0 to 1.0)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_set_page_background(PdfHandle* handle, int page_index, double r, double g, double b);
+
+/*
+ * Remove the background set by pdf_set_page_background from a page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_clear_page_background(PdfHandle* handle, int page_index);
+
+/*
+ * Set the PDF version written in the file header (the default is 1.7).
+ * Features the version lacks, such as transparency before 1.4 or AES-256
+ * encryption before 1.7, make serialization fail instead.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   major, minor - 1.0 through 1.7, or 2.0
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for any other version.
+ */
+int pdf_set_version(PdfHandle* handle, int major, int minor);
+
+/*
+ * Set how viewers show the document when it opens. The zoom applies to the
+ * first page. Documents with bookmarks keep showing the bookmark panel;
+ * others open with no panel.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   zoom_mode   - PDF_ZOOM_FIT_PAGE, PDF_ZOOM_FIT_WIDTH, a percentage such
+ *                 as 150, or PDF_ZOOM_DEFAULT to leave it to the viewer
+ *   layout_mode - One of the PDF_LAYOUT_* constants
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for an unknown mode.
+ */
+int pdf_set_open_action(PdfHandle* handle, int zoom_mode, int layout_mode);
+
+/*
+ * Set the natural language of the document's text (/Lang), which screen
+ * readers use to pick a voice and text to speech rules.
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   lang_tag - BCP 47 language tag such as "en-US" or "ar-EG", or NULL or
+ *              "" to remove the language
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT if the tag is not a
+ *   well-formed language tag.
+ */
+int pdf_set_document_language(PdfHandle* handle, const char* lang_tag);
+
+/*
+ * Begin a structure element inside the innermost tag still open. Content
+ * drawn on the page until the matching pdf_end_tag belongs to it, and the
+ * document is written as tagged PDF with a structure tree. Grouping types
+ * ("Sect", "Div", "L", "Table", "TR", ...) hold only the tags begun inside
+ * them.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Page the element's content is drawn on
+ *   tag_type   - Standard structure type, such as "H1", "P" or "Figure"
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for an unknown type.
+ */
+int pdf_begin_tag(PdfHandle* handle, int page_index, const char* tag_type);
+
+/*
+ * End the innermost tag begun with pdf_begin_tag.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Page the tag's content was drawn on
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT when no tag is open or
+ *   its content is on another page.
+ */
+int pdf_end_tag(PdfHandle* handle, int page_index);
+
+/*
+ * Set the text read in place of the content of the tag begun or ended
+ * last, such as a description of the image in a "Figure" tag.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   text   - Alternate description (UTF-8)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT when no tag was begun.
+ */
+int pdf_set_alt_text(PdfHandle* handle, const char* text);
+
+/*
+ * Add a layer (optional content group) that readers can hide or show in
+ * the viewer's layers panel. Layers are listed in the order added and are
+ * shown when the document opens unless pdf_set_layer_visible hides them.
+ * Layers need PDF 1.5 and are not allowed in PDF/A-1b.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   name   - Name shown in the layers panel (UTF-8)
+ *
+ * Returns:
+ *   The layer id (>= 0), or PDF_ERR_INVALID_ARGUMENT for an empty name.
+ */
+int pdf_add_layer(PdfHandle* handle, const char* name);
+
+/*
+ * Set whether a layer is shown when the document opens.
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   layer_id - Id returned by pdf_add_layer
+ *   visible  - Nonzero to show the layer, 0 to hide it
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for an unknown layer.
+ */
+int pdf_set_layer_visible(PdfHandle* handle, int layer_id, int visible);
+
+/*
+ * Begin content on a page that belongs to a layer, until pdf_end_layer.
+ * Layers begun on a page nest. While a layer begun inside a tag is open,
+ * no tag can begin or end.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Page the content is drawn on
+ *   layer_id   - Id returned by pdf_add_layer
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT for an unknown layer.
+ */
+int pdf_begin_layer(PdfHandle* handle, int page_index, int layer_id);
+
+/*
+ * End the innermost layer begun on a page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Page the layer was begun on
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT when no layer is open on
+ *   the page or a tag begun inside it is still open.
+ */
+int pdf_end_layer(PdfHandle* handle, int page_index);
+
+/*
+ * Make the document conform to PDF/A-1b or PDF/A-2b for archiving.
+ * Conforming output embeds an sRGB ICC output intent, an XMP metadata
+ * packet and a file identifier. Serialization fails while
+ * pdf_validate_pdfa reports violations, such as text in a standard font
+ * (use pdf_load_font so the font is embedded), encryption, attachments,
+ * or transparency under PDF/A-1b.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   level  - PDF_PDFA_1B, PDF_PDFA_2B, or PDF_PDFA_NONE to turn it off
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_pdfa_mode(PdfHandle* handle, int level);
+
+/*
+ * Check the document against the level set with pdf_set_pdfa_mode.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   out_report - Pointer to receive the violations, one per line, or NULL.
+ *                The string is owned by the handle and stays valid until
+ *                the next pdf_validate_pdfa call or pdf_free().
+ *
+ * Returns:
+ *   The number of violations (0 if the document conforms), or
+ *   PDF_ERR_INVALID_ARGUMENT if PDF/A mode is not set.
+ */
+int pdf_validate_pdfa(PdfHandle* handle, const char** out_report);
+
+/*
+ * Check the resources the document depends on before it is sent to print:
+ * fonts that are not embedded, including those of appended PDFs, images
+ * compressed with the JPEG 2000 or CCITT fax filters, and objects an
+ * appended PDF refers to but does not contain.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   out_report - Pointer to receive the problems, one per line, or NULL.
+ *                The string is owned by the handle and stays valid until
+ *                the next pdf_audit_resources call or pdf_free().
+ *
+ * Returns:
+ *   The number of problems (0 if none were found), or a negative error code.
+ */
+int pdf_audit_resources(PdfHandle* handle, const char** out_report);
+
+/*
+ * Turn Flate compression of content, font and attachment streams
+ * on or off. New documents are compressed at level 6 when the library is
+ * built with the "compression" feature, which makes text-heavy files
+ * roughly 70% smaller; turn it off to read the streams while debugging.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Non-zero to compress, 0 to write plain streams
+ *   level   - 0 (fastest) to 9 (smallest); ignored when disabled
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT for a level outside 0-9,
+ *   or PDF_ERR_UNSUPPORTED when enabling it in a build without the
+ *   "compression" feature.
+ */
+int pdf_set_compression(PdfHandle* handle, int enabled, int level);
+
+/*
+ * Write streams shorter than a number of bytes uncompressed when
+ * compression is on. Flate saves little or nothing on short streams and
+ * each costs a compressor setup, so documents of many small pages are
+ * written faster, and often smaller, with a threshold of a few hundred
+ * bytes.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   bytes  - Shortest stream to compress; 0 (the default) compresses all
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_compress_min_size(PdfHandle* handle, size_t bytes);
+
+/*
+ * Round coordinates and other numbers in page content streams to a number
+ * of decimal places, with trailing zeros dropped, so 72.00000000001 is
+ * written as 72. Color components and the scaling and rotation terms of
+ * matrices keep at least 4 places.
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   decimals - Decimal places from 0 to 10; the default is 4, and 2 is
+ *              ample for drawings
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_coordinate_precision(PdfHandle* handle, int decimals);
+
+/*
+ * Pack objects into compressed object streams and end the file with a
+ * cross-reference stream instead of the classic xref table. Documents
+ * with many pages, fonts or annotations become noticeably smaller.
+ * Only PDF 1.5 and later allow this, so it has no effect for an older
+ * version set with pdf_set_version or for PDF/A-1b.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Non-zero to use object streams, 0 for the classic layout
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_use_object_streams(PdfHandle* handle, int enabled);
+
+/*
+ * Lay the file out for fast web view ("linearized"): the first page's
+ * objects come first, with hint tables giving the offset of every other
+ * page, so viewers can show the first page before the whole file has
+ * downloaded. Adds a few hundred bytes and turns off object streams.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Non-zero to linearize, 0 for the normal layout
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_linearized(PdfHandle* handle, int enabled);
+
+/*
+ * Write identical streams, fonts and graphics states only once from now
+ * on, with every reference pointing at the copy kept. Objects are compared
+ * byte for byte once their dictionary keys are sorted; pages, annotations
+ * and form fields keep their own objects. The document is serialized with
+ * and without merging to measure the difference, without being signed. On
+ * failure merging is left as it was.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *
+ * Returns:
+ *   The number of bytes saved (0 if nothing was merged), or a negative
+ *   error code.
+ */
+int64_t pdf_optimize(PdfHandle* handle);
+
+/*
+ * Set the margins around the content area of every page.
+ *
+ * Parameters:
+ *   handle                   - PDF handle from pdf_create_*
+ *   top, right, bottom, left - Margins in points (must not be negative)
+ *
+ * Flowing text and text boxes drawn with a zero width and height fill
+ * the content area, and page numbers are aligned to it with their
+ * baseline halfway up the bottom margin.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_margins(PdfHandle* handle, double top, double right,
+                    double bottom, double left);
+
+/*
+ * Get the area of a page inside the margins. Without pdf_set_margins,
+ * one inch is left on every side.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   page_index   - Zero-based page index
+ *   out_x, out_y - Receive the lower-left corner in points
+ *   out_w, out_h - Receive the width and height in points
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if an output pointer is NULL.
+ */
+int pdf_get_content_rect(const PdfHandle* handle, int page_index,
+                         double* out_x, double* out_y,
+                         double* out_w, double* out_h);
+
+/*
+ * Set the document metadata (Info dictionary).
+ * May be called before or after pages are added.
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   title    - Document title, or NULL to leave unchanged
+ *   author   - Document author, or NULL to leave unchanged
+ *   subject  - Document subject, or NULL to leave unchanged
+ *   keywords - Document keywords, or NULL to leave unchanged
+ *
+ * All strings are null-terminated UTF-8; non-ASCII text is stored as
+ * UTF-16BE so accented characters are preserved.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_metadata(PdfHandle* handle, const char* title, const char* author,
+                     const char* subject, const char* keywords);
+
+/*
+ * Embed a raw XMP metadata packet in the document catalog, replacing any
+ * packet set before. XML that is not already an xpacket is wrapped in the
+ * <?xpacket begin/end?> processing instructions with padding whitespace,
+ * so other tools can rewrite the metadata in place.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   xmp_xml - UTF-8 XMP data, or NULL (with xmp_len 0) to remove it
+ *   xmp_len - Length of the data in bytes
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_xmp_metadata(PdfHandle* handle, const uint8_t* xmp_xml, size_t xmp_len);
+
+/*
+ * Set the document metadata and embed an XMP packet generated from it,
+ * with the title, author, subject and keywords as Dublin Core properties.
+ * The packet follows later pdf_set_metadata calls, so the Info dictionary
+ * and XMP always agree.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   title        - Document title, or NULL to leave unchanged
+ *   author       - Document author, or NULL to leave unchanged
+ *   subject      - Document subject, or NULL to leave unchanged
+ *   keywords     - Comma-separated keywords, or NULL to leave unchanged
+ *   creator_tool - Application that created the document, or NULL to
+ *                  leave unchanged
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_xmp_fields(PdfHandle* handle, const char* title, const char* author,
+                       const char* subject, const char* keywords,
+                       const char* creator_tool);
+
+/*
+ * Make the output reproducible: writing the same document twice gives
+ * identical bytes. The trailer's /ID is fixed_id instead of an identifier
+ * generated on every write. AES-256 encryption and signing still add
+ * random or time-dependent data.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   fixed_date - Creation date as a PDF date (e.g. "D:20240131120000Z"),
+ *                or NULL to leave it unchanged
+ *   fixed_id   - File identifier as an even number of hex digits
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT if fixed_id is not
+ *   hex or fixed_date is not a valid PDF date.
+ */
+int pdf_set_deterministic(PdfHandle* handle, const char* fixed_date,
+                          const char* fixed_id);
+
+/*
+ * Password-protect the document. Encryption is applied when the document
+ * is serialized by pdf_get_data, pdf_write_to_callback or pdf_save_to_file.
+ *
+ * Parameters:
+ *   handle         - PDF handle from pdf_create_*
+ *   user_password  - Password required to open the document, or NULL so
+ *                    anyone can open it with the permissions applied
+ *   owner_password - Password granting full access, or NULL to reuse the
+ *                    user password
+ *   permissions    - Bitmask of PDF_PERMISSION_* flags; operations not
+ *                    listed are denied
+ *   algorithm      - PDF_ENCRYPTION_RC4_128 or PDF_ENCRYPTION_AES_256
+ *
+ * Passwords are null-terminated UTF-8 strings; at least one is required.
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT, or PDF_ERR_UNSUPPORTED if
+ *   the document was signed with pdf_sign or the library was built without
+ *   the "encryption" feature.
+ */
+int pdf_set_encryption(PdfHandle* handle, const char* user_password,
+                       const char* owner_password, int permissions, int algorithm);
+
+/*
+ * Sign the document with the certificate and private key in a PKCS#12
+ * (.p12 or .pfx) file. The signature is an invisible field on the first
+ * page holding a detached PKCS#7 signature over the whole file; it is
+ * added when the document is serialized, so later changes are covered.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   pkcs12_data - Contents of the PKCS#12 file
+ *   pkcs12_len  - Length of pkcs12_data in bytes
+ *   password    - Password of the PKCS#12 file ("" for none)
+ *   reason      - Reason for signing, or NULL
+ *   location    - Where the document was signed, or NULL
+ *
+ * Files must use AES and SHA-2, as OpenSSL 3 writes by default; re-export
+ * older RC2 or 3DES files with "openssl pkcs12 -export".
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT (including a wrong
+ *   password), or PDF_ERR_UNSUPPORTED for an encrypted document, a
+ *   PKCS#12 file using older algorithms, or a library built without the
+ *   "signatures" feature.
+ */
+int pdf_sign(PdfHandle* handle, const uint8_t* pkcs12_data, size_t pkcs12_len,
+             const char* password, const char* reason, const char* location);
+
+/*
+ * Set the fill color for subsequent text and filled shapes.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   r, g, b - Color components from 0.0 to 1.0 (out-of-range values
+ *             are clamped)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_fill_color(PdfHandle* handle, double r, double g, double b);
+
+/*
+ * Set the stroke color for subsequent lines and shape outlines.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   r, g, b - Color components from 0.0 to 1.0 (out-of-range values
+ *             are clamped)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_stroke_color(PdfHandle* handle, double r, double g, double b);
+
+/*
+ * Set a CMYK fill color for subsequent text and filled shapes. The color
+ * is written with the k operator, without conversion to RGB.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   c, m, y, k - Color components from 0.0 to 1.0 (out-of-range values
+ *                are clamped)
+ *
+ * Returns:
+ *   PDF_OK on success, or a negative error code.
+ */
+int pdf_set_fill_color_cmyk(PdfHandle* handle, double c, double m, double y, double k);
+
+/*
+ * Set a gray fill color for subsequent text and filled shapes, written
+ * with the g operator.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   value  - Gray level from 0.0 (black) to 1.0 (white), clamped
+ *
+ * Returns:
+ *   PDF_OK on success, or a negative error code.
+ */
+int pdf_set_fill_color_gray(PdfHandle* handle, double value);
+
+/*
+ * Set a CMYK stroke color for subsequent lines and shape outlines. The
+ * color is written with the K operator, without conversion to RGB.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   c, m, y, k - Color components from 0.0 to 1.0 (out-of-range values
+ *                are clamped)
+ *
+ * Returns:
+ *   PDF_OK on success, or a negative error code.
+ */
+int pdf_set_stroke_color_cmyk(PdfHandle* handle, double c, double m, double y, double k);
+
+/*
+ * Set a gray stroke color for subsequent lines and shape outlines,
+ * written with the G operator.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   value  - Gray level from 0.0 (black) to 1.0 (white), clamped
+ *
+ * Returns:
+ *   PDF_OK on success, or a negative error code.
+ */
+int pdf_set_stroke_color_gray(PdfHandle* handle, double value);
+
+/*
+ * Restore black fill and stroke colors.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_reset_colors(PdfHandle* handle);
+
+/*
+ * Fill subsequent text and filled shapes with a linear gradient.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   x0, y0     - Start of the gradient, in page coordinates
+ *   x1, y1     - End of the gradient, in page coordinates
+ *   stops      - Color stops, by non-decreasing offset from 0.0 to 1.0
+ *   stop_count - Number of entries in stops (at least 2)
+ *
+ * The first and last stop colors continue beyond the ends. The gradient is
+ * painted as a shading pattern and stays in place regardless of the shape;
+ * any pdf_set_fill_color* call or pdf_reset_colors replaces it.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_fill_linear_gradient(PdfHandle* handle, double x0, double y0,
+                                 double x1, double y1,
+                                 const PdfGradientStop* stops, size_t stop_count);
+
+/*
+ * Fill subsequent text and filled shapes with a radial gradient.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   cx, cy     - Center of the gradient (offset 0.0), in page coordinates
+ *   radius     - Distance from the center at which offset 1.0 is reached
+ *   stops      - Color stops, by non-decreasing offset from 0.0 to 1.0
+ *   stop_count - Number of entries in stops (at least 2)
+ *
+ * The last stop color continues past the radius. Replaced as for
+ * pdf_set_fill_linear_gradient.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_fill_radial_gradient(PdfHandle* handle, double cx, double cy,
+                                 double radius,
+                                 const PdfGradientStop* stops, size_t stop_count);
+
+/*
+ * Create a pattern that repeats a cell painted by raw content stream
+ * operators, such as hatching for engineering drawings. Cells are laid edge
+ * to edge from the page origin, and anything drawn outside a cell is
+ * clipped. The operators are not checked, and the cell has no resources,
+ * so it cannot show text or images.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   width, height - Size of a cell in points (must be greater than 0)
+ *   content       - Operators painting one cell with the origin at its
+ *                   lower-left corner, such as "0 0 m 8 8 l S"
+ *   content_len   - Length of content in bytes
+ *
+ * Returns:
+ *   A pattern id (>= 0) for pdf_set_fill_pattern, or
+ *   PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_create_tiling_pattern(PdfHandle* handle, double width, double height,
+                              const uint8_t* content, size_t content_len);
+
+/*
+ * Fill subsequent text and filled shapes with a pattern from
+ * pdf_create_tiling_pattern. A fill color or gradient call replaces it.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   pattern_id - Id returned by pdf_create_tiling_pattern
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for an unknown id.
+ */
+int pdf_set_fill_pattern(PdfHandle* handle, int pattern_id);
+
+/*
+ * Set the dash pattern for subsequent lines and shape outlines.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   pattern     - Alternating dash and gap lengths in points, e.g. {6, 3}
+ *   pattern_len - Number of entries in pattern (must be greater than 0)
+ *   phase       - Distance into the pattern at which each stroke starts
+ *
+ * The pattern and phase map directly to the PDF "d" operator. Lengths must
+ * not be negative and at least one must be nonzero.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_line_dash(PdfHandle* handle, const double* pattern,
+                      size_t pattern_len, double phase);
+
+/*
+ * Restore solid lines for subsequent drawing calls.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_line_dash_solid(PdfHandle* handle);
+
+/*
+ * Set the extra space added after every character of subsequent text (the
+ * PDF "Tc" operator). Negative values tighten the text and 0 restores the
+ * default. pdf_measure_text and the wrapping functions include it.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   spacing - Spacing in points
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_char_spacing(PdfHandle* handle, double spacing);
+
+/*
+ * Set the extra space added after every space of subsequent text (the PDF
+ * "Tw" operator). Negative values tighten the text and 0 restores the
+ * default. PDF applies word spacing only to one-byte character codes, so
+ * it has no effect on text drawn with a loaded font.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   spacing - Spacing in points
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_word_spacing(PdfHandle* handle, double spacing);
+
+/*
+ * Turn kerning of subsequent text in loaded fonts on or off (off by
+ * default). Kerned glyph pairs such as "AV" are moved by the adjustments
+ * of the font's GPOS "kern" feature, or of its kern table. The built-in
+ * Helvetica is never kerned.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Nonzero to kern, 0 not to
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for a bad handle.
+ */
+int pdf_set_kerning(PdfHandle* handle, int enabled);
+
+/*
+ * Turn the standard ligatures of loaded fonts (their GSUB "liga" feature)
+ * on or off for subsequent text (off by default), so that sequences such
+ * as "fi" are drawn as one glyph. Extracted text still reads as the
+ * separate letters.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   enabled - Nonzero to use ligatures, 0 not to
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT for a bad handle.
+ */
+int pdf_set_ligatures(PdfHandle* handle, int enabled);
+
+/*
+ * Set whether subsequent fills and strokes overprint the inks beneath them
+ * instead of knocking them out, e.g. for black text on a colored background
+ * without misregistration gaps. Overprint only shows on separations.
+ *
+ * Parameters:
+ *   handle           - PDF handle from pdf_create_*
+ *   fill_overprint   - Nonzero to overprint fills (and text)
+ *   stroke_overprint - Nonzero to overprint strokes
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_overprint(PdfHandle* handle, int fill_overprint, int stroke_overprint);
+
+/*
+ * Set the overprint mode of subsequent drawing. In mode 0, the default,
+ * an overprinting CMYK color paints all four inks. In mode 1 components of
+ * 0 leave the ink beneath unchanged.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   mode   - 0 or 1
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_overprint_mode(PdfHandle* handle, int mode);
+
+/*
+ * Draw text on a page in the font set with pdf_set_default_font, or
+ * Helvetica. Characters the font cannot show (for Helvetica, anything
+ * outside printable ASCII) are drawn with the first font registered with
+ * pdf_register_fallback_font that has a glyph for them.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Text position in points (origin at bottom-left)
+ *   text       - The text content (null-terminated UTF-8 string)
+ *   font_size  - Font size in points, or 0 for the default size (12
+ *                unless set with pdf_set_default_font)
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE if the page does not
+ *   exist, or PDF_ERR_INVALID_ARGUMENT (including a font size that is
+ *   not positive).
+ */
+int pdf_add_text(PdfHandle* handle, int page_index, double x, double y,
+                 const char* text, double font_size);
+
+/*
+ * Draw text like pdf_add_text, underlined and/or struck through in the
+ * fill color. The lines follow the default font's underline (post table)
+ * and strikeout (OS/2 table) metrics, or fractions of the font size where
+ * the font has none.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   page_index  - Zero-based page index
+ *   x, y        - Baseline start position in points (origin at bottom-left)
+ *   text        - The text to draw (null-terminated UTF-8)
+ *   font_size   - Font size in points, or 0 for the default size
+ *   decorations - PDF_DECORATION_* flags combined with |
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including a font size that is not positive,
+ *   in which case no lines are drawn).
+ */
+int pdf_add_text_decorated(PdfHandle* handle, int page_index, double x, double y,
+                           const char* text, double font_size, int decorations);
+
+/*
+ * Draw text rotated counterclockwise around its start point. The
+ * rotation does not affect later drawing calls.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Baseline start position and center of rotation in points
+ *   text          - The text content (null-terminated UTF-8 string)
+ *   font_size     - Font size in points (must be positive)
+ *   angle_degrees - Counterclockwise rotation in degrees
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including a non-finite angle or a font size
+ *   that is not positive).
+ */
+int pdf_add_text_rotated(PdfHandle* handle, int page_index, double x, double y,
+                         const char* text, double font_size, double angle_degrees);
+
+/*
+ * Draw a run of text as normal, superscript, or subscript. Scripts are
+ * drawn at 65% of font_size and raised or lowered from the baseline.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Start of the surrounding text's baseline in points
+ *   text       - The text content (null-terminated UTF-8 string)
+ *   font_size  - Font size of the surrounding text in points (must be
+ *                positive)
+ *   script     - PDF_SCRIPT_NORMAL, PDF_SCRIPT_SUPERSCRIPT, or
+ *                PDF_SCRIPT_SUBSCRIPT
+ *
+ * Returns:
+ *   The advance width of the run in points (start the next run at x plus
+ *   this width), or a negative error code (PDF_ERR_PAGE_OUT_OF_RANGE or
+ *   PDF_ERR_INVALID_ARGUMENT).
+ */
+double pdf_add_text_script(PdfHandle* handle, int page_index, double x, double y,
+                           const char* text, double font_size, int script);
+
+/*
+ * Set the distance between baselines for text drawn over several lines by
+ * pdf_add_text_box, pdf_add_flowing_text, pdf_add_text_aligned and
+ * pdf_add_list after this call. The default is 1.2 times the font size.
+ * The number of lines that fit in a box or on a page follows the leading.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   leading - Distance between baselines in points (must be positive)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_leading(PdfHandle* handle, double leading);
+
+/*
+ * Set the leading for multi-line text, as in pdf_set_leading, to a multiple
+ * of the font size of each call.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   factor - Leading as a multiple of the font size (must be positive)
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_set_leading_multiple(PdfHandle* handle, double factor);
+
+/*
+ * Draw Helvetica text wrapped to fit a box. Lines break at spaces and at
+ * '\n', flow down from the top of the box, and lines that do not fit
+ * within the box height are not drawn.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Lower-left corner of the box in points
+ *   width, height - Box size in points; pass 0 for both to fill the
+ *                   page's content area (see pdf_get_content_rect), in
+ *                   which case x and y are ignored
+ *   text          - The text to draw (null-terminated UTF-8 string)
+ *   font_size     - Font size in points
+ *
+ * Returns:
+ *   The number of lines that did not fit (0 if all text was drawn), or
+ *   PDF_ERR_PAGE_OUT_OF_RANGE or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_text_box(PdfHandle* handle, int page_index, double x, double y,
+                     double width, double height, const char* text,
+                     double font_size);
+
+/*
+ * Flow Helvetica text through the content area of the last page (see
+ * pdf_get_content_rect), starting at its top, and add pages of the same
+ * size whenever it runs out of room. Lines break at spaces and at '\n';
+ * a form feed ('\f') starts a new page. If the document has no pages, a
+ * US Letter page is added first.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   text      - The text to draw (null-terminated UTF-8 string)
+ *   font_size - Font size in points
+ *
+ * Returns:
+ *   The number of pages the text spans, including the starting page, or
+ *   PDF_ERR_INVALID_ARGUMENT (including when the content area cannot hold
+ *   one line).
+ */
+int pdf_add_flowing_text(PdfHandle* handle, const char* text, double font_size);
+
+/*
+ * Draw Helvetica text aligned within a box, wrapping at spaces and '\n'.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x          - Left edge of the box in points
+ *   y          - Baseline of the first line; later lines flow downward
+ *   width      - Box width in points; text aligns between x and x + width
+ *   text       - The text to draw (null-terminated UTF-8 string)
+ *   font_size  - Font size in points
+ *   alignment  - One of the PDF_ALIGN_* values
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unknown alignment).
+ */
+int pdf_add_text_aligned(PdfHandle* handle, int page_index, double x, double y,
+                         double width, const char* text, double font_size,
+                         int alignment);
+
+/*
+ * Stamp a page number on every page, 36 points (half an inch) above the
+ * bottom edge, in Helvetica.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   format    - Text template (null-terminated UTF-8); "{page}" becomes the
+ *               page number and "{total}" the page count, e.g.
+ *               "Page {page} of {total}"
+ *   font_size - Font size in points
+ *   alignment - PDF_ALIGN_LEFT, PDF_ALIGN_CENTER or PDF_ALIGN_RIGHT; left
+ *               and right numbers are inset 36 points from the side edges
+ *
+ * Placeholders are resolved whenever the document is serialized, so pages
+ * added after this call are numbered and counted. Calling it again
+ * replaces the previous settings. After pdf_set_margins, numbers are
+ * aligned to the content area instead, halfway up the bottom margin.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_page_numbers(PdfHandle* handle, const char* format, double font_size,
+                         int alignment);
+
+/*
+ * Stamp semi-transparent text across the center of every page, in black
+ * Helvetica.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   text          - Watermark text (null-terminated UTF-8, not empty)
+ *   font_size     - Font size in points
+ *   opacity       - 0.0 (invisible) to 1.0 (opaque)
+ *   angle_degrees - Counterclockwise rotation about the page center,
+ *                   e.g. 45 for a diagonal
+ *
+ * The watermark is drawn over the page content whenever the document is
+ * serialized, so pages added after this call are stamped too. Calling it
+ * again replaces the previous watermark.
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_watermark(PdfHandle* handle, const char* text, double font_size,
+                      double opacity, double angle_degrees);
+
+/*
+ * Add an entry to the document outline (the viewer's bookmark panel).
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   parent_id  - Id of the bookmark to nest under, or -1 for a top-level entry
+ *   title      - Entry text (null-terminated UTF-8)
+ *   page_index - Zero-based page the entry jumps to
+ *   y          - Vertical position shown at the top of the view (e.g. the
+ *                page height for the top of the page)
+ *
+ * Children appear under their parent in the order they are added.
+ *
+ * Returns:
+ *   The bookmark id (>= 0) on success, or PDF_ERR_PAGE_OUT_OF_RANGE or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unknown parent).
+ */
+int pdf_add_bookmark(PdfHandle* handle, int parent_id, const char* title,
+                     int page_index, double y);
+
+/*
+ * Make a rectangle on a page a clickable link to a URI.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Lower-left corner of the clickable area in points
+ *   width, height - Size of the clickable area (must be > 0)
+ *   uri           - Link target (null-terminated UTF-8); characters outside
+ *                   printable ASCII are percent-encoded
+ *
+ * Overlapping links are kept as separate annotations.
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_link_uri(PdfHandle* handle, int page_index, double x, double y,
+                     double width, double height, const char* uri);
+
+/*
+ * Make a rectangle on a page a clickable link to another page.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page holding the link
+ *   x, y          - Lower-left corner of the clickable area in points
+ *   width, height - Size of the clickable area (must be > 0)
+ *   target_page   - Zero-based destination page; it may be added later but
+ *                   must exist when the document is serialized
+ *   target_y      - Vertical position shown at the top of the view
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_link_internal(PdfHandle* handle, int page_index, double x, double y,
+                          double width, double height, int target_page,
+                          double target_y);
+
+/*
+ * Highlight a rectangle on a page, as a comment listed in the viewer's
+ * comments panel that the reader can delete.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Lower-left corner of the highlighted area in points
+ *   width, height - Size of the highlighted area (must be > 0)
+ *   r, g, b       - Highlight color components (0.0 - 1.0, clamped)
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_highlight(PdfHandle* handle, int page_index, double x, double y,
+                      double width, double height, double r, double g, double b);
+
+/*
+ * Add a sticky note to a page. The note's icon opens to show its text,
+ * which is also listed in the viewer's comments panel.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Lower-left corner of the 20x20 point note icon
+ *   contents   - Text of the note (UTF-8)
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_text_note(PdfHandle* handle, int page_index, double x, double y,
+                      const char* contents);
+
+/*
+ * Add a standard rubber stamp to a page, sized to fit its label.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Lower-left corner of the stamp in points
+ *   stamp_name - One of "Approved", "Experimental", "NotApproved", "AsIs",
+ *                "Expired", "NotForPublicRelease", "Confidential", "Final",
+ *                "Sold", "Departmental", "ForComment", "TopSecret", "Draft"
+ *                or "ForPublicRelease"
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT
+ *   for an unknown stamp name.
+ */
+int pdf_add_stamp(PdfHandle* handle, int page_index, double x, double y,
+                  const char* stamp_name);
+
+/*
+ * Define a named destination that links made with pdf_add_link_named can
+ * go to. Unlike a page index, the name keeps pointing at the same place
+ * if the pages are reordered. A destination of the same name is replaced:
+ * the call succeeds, and pdf_last_error reports the replacement.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   name       - Destination name (UTF-8, not empty)
+ *   page_index - Zero-based destination page, which must exist
+ *   x, y       - Position shown at the top left of the view
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_named_destination(PdfHandle* handle, const char* name, int page_index,
+                              double x, double y);
+
+/*
+ * Make a rectangle on a page a clickable link to a named destination.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page holding the link
+ *   x, y          - Lower-left corner of the clickable area in points
+ *   width, height - Size of the clickable area (must be > 0)
+ *   dest_name     - Name given to pdf_add_named_destination; the destination
+ *                   may be added later but must exist when the document is
+ *                   serialized
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_link_named(PdfHandle* handle, int page_index, double x, double y,
+                       double width, double height, const char* dest_name);
+
+/*
+ * Load a TrueType or OpenType font for use with pdf_add_text_with_font.
+ * Only the glyphs actually drawn are embedded in the output.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   data      - Font file bytes (.ttf or .otf); copied by the library
+ *   data_len  - Length of data in bytes
+ *   font_name - Unique name to register the font under
+ *
+ * Returns:
+ *   A font id (>= 0) on success, PDF_ERR_INVALID_FONT if the bytes are
+ *   not a usable font, or PDF_ERR_INVALID_ARGUMENT (including a name that
+ *   is already registered).
+ */
+int pdf_load_font(PdfHandle* handle, const uint8_t* data, size_t data_len,
+                  const char* font_name);
+
+/*
+ * Draw text on a page using a font loaded with pdf_load_font, whatever
+ * the default font. Characters the font has no glyph for are drawn with
+ * the first fallback font that has one, or else as the font's missing glyph.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Baseline start position in points (origin at bottom-left)
+ *   text       - The text to draw (null-terminated UTF-8 string)
+ *   font_size  - Font size in points, or 0 for the default size
+ *   font_id    - Id returned by pdf_load_font
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unknown font id or a negative
+ *   font size).
+ */
+int pdf_add_text_with_font(PdfHandle* handle, int page_index, double x, double y,
+                           const char* text, double font_size, int font_id);
+
+/*
+ * Draw a line of right-to-left text, such as Arabic or Hebrew, ending at x.
+ * Arabic letters take their initial, medial, final or isolated form where
+ * the font (or a fallback font) has a glyph for it, and lam-alef is drawn as
+ * a ligature. Embedded Latin words and numbers keep their left-to-right
+ * order. Explicit bidi controls are not interpreted.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Baseline end position in points (origin at bottom-left)
+ *   text       - The text to draw, in logical order (null-terminated UTF-8)
+ *   font_size  - Font size in points, or 0 for the default size
+ *   font_id    - Id returned by pdf_load_font
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unknown font id or a negative
+ *   font size).
+ */
+int pdf_add_text_rtl(PdfHandle* handle, int page_index, double x, double y,
+                     const char* text, double font_size, int font_id);
+
+/*
+ * Draw text in vertical columns for Chinese and Japanese typesetting:
+ * characters run top to bottom, and each line of text starts a new column
+ * to the left. Characters advance by the font's vertical metrics (vhea and
+ * vmtx), or by one em in a font without them. Glyphs are drawn upright and
+ * not replaced by vertical forms, so brackets and dashes stay horizontal.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Top center of the first column in points
+ *   text       - The text to draw; '\n' starts a new column (null-terminated UTF-8)
+ *   font_size  - Font size in points, or 0 for the default size
+ *   font_id    - Id returned by pdf_load_font
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unknown font id or a negative
+ *   font size).
+ */
+int pdf_add_text_vertical(PdfHandle* handle, int page_index, double x, double y,
+                          const char* text, double font_size, int font_id);
+
+/*
+ * Draw a number formatted with locale-style separators, right-aligned so
+ * that it ends at x, in the default font (see pdf_set_default_font).
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Baseline end position in points (origin at bottom-left)
+ *   value         - The number to draw; must be finite
+ *   decimals      - Decimals to round to, 0 to 15
+ *   thousands_sep - Separator between groups of thousands, or NULL for none
+ *   decimal_sep   - Separator before the decimals, or NULL for "."
+ *   font_size     - Font size in points, or 0 for the default size
+ *   flags         - PDF_NUMBER_PARENTHESES or 0
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including a font size that is not positive).
+ */
+int pdf_add_number(PdfHandle* handle, int page_index, double x, double y,
+                   double value, int decimals, const char* thousands_sep,
+                   const char* decimal_sep, double font_size, int flags);
+
+/*
+ * Draw an amount of money like pdf_add_number, with a currency symbol
+ * before the digits ("$1,234.50"), or after them following a space with
+ * PDF_NUMBER_SYMBOL_AFTER ("1.234,50 EUR"). A minus sign or parentheses
+ * enclose the symbol. Symbols outside ASCII need a loaded default or
+ * fallback font with a glyph for them.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Baseline end position in points (origin at bottom-left)
+ *   value         - The amount to draw; must be finite
+ *   decimals      - Decimals to round to, 0 to 15
+ *   thousands_sep - Separator between groups of thousands, or NULL for none
+ *   decimal_sep   - Separator before the decimals, or NULL for "."
+ *   symbol        - Currency symbol (null-terminated UTF-8)
+ *   font_size     - Font size in points, or 0 for the default size
+ *   flags         - PDF_NUMBER_* flags combined with |
+ *
+ * Returns:
+ *   The number of characters no font has a glyph for (0 when all of the
+ *   text is drawn), PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including a font size that is not positive).
+ */
+int pdf_add_currency(PdfHandle* handle, int page_index, double x, double y,
+                     double value, int decimals, const char* thousands_sep,
+                     const char* decimal_sep, const char* symbol,
+                     double font_size, int flags);
+
+/*
+ * Register a loaded font as a fallback for pdf_add_text and
+ * pdf_add_text_with_font. Text is split where the requested font has no
+ * glyph, and each such character is drawn with the first fallback, in
+ * registration order, that has one. Registering a font twice has no
+ * effect.
+ *
+ * Parameters:
+ *   handle  - PDF handle from pdf_create_*
+ *   font_id - Id returned by pdf_load_font
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT (including an unknown
+ *   font id).
+ */
+int pdf_register_fallback_font(PdfHandle* handle, int font_id);
+
+/*
+ * Set the font pdf_add_text draws with, and the size used by calls that
+ * pass a size of 0.
+ *
+ * The font is used by pdf_add_text, pdf_add_text_decorated, pdf_add_number
+ * and pdf_add_currency. The size is used by those and by
+ * pdf_add_text_with_font, pdf_add_text_rtl and pdf_add_text_vertical when
+ * they pass 0, and for the body text of pdf_add_html. Other text functions,
+ * such as pdf_add_text_rotated, pdf_add_text_script, pdf_add_text_box and
+ * pdf_add_text_aligned, always draw in Helvetica at the size they are given.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   font_id   - Id returned by pdf_load_font, or PDF_FONT_DEFAULT for Helvetica
+ *   font_size - Default font size in points
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT (including an unknown
+ *   font id or a size that is not positive).
+ */
+int pdf_set_default_font(PdfHandle* handle, int font_id, double font_size);
+
+/*
+ * Measure the advance width of text as it would be drawn, using the
+ * glyph widths of the font plus the spacing set with pdf_set_char_spacing
+ * and pdf_set_word_spacing. For loaded fonts, the kerning and ligatures
+ * turned on with pdf_set_kerning and pdf_set_ligatures are applied too.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   text      - The text to measure (null-terminated UTF-8 string)
+ *   font_size - Font size in points (must not be negative)
+ *   font_id   - Id returned by pdf_load_font, or PDF_FONT_DEFAULT for the
+ *               Helvetica used by pdf_add_text
+ *
+ * Returns:
+ *   The width in points, or a negative error code
+ *   (PDF_ERR_INVALID_ARGUMENT, including an unknown font id).
+ */
+double pdf_measure_text(const PdfHandle* handle, const char* text, double font_size,
+                        int font_id);
+
+/*
+ * Get the vertical metrics of a font scaled to a font size, for placing
+ * text precisely, such as centering it in a table cell. A line of text
+ * takes ascent - descent + line gap. Embedded fonts take their metrics from
+ * the hhea and OS/2 tables; Helvetica takes them from its AFM data.
+ *
+ * Parameters:
+ *   handle         - PDF handle from pdf_create_*
+ *   font_id        - Id returned by pdf_load_font, or PDF_FONT_DEFAULT
+ *   font_size      - Font size in points
+ *   out_ascent     - Receives the height above the baseline
+ *   out_descent    - Receives the depth below the baseline (negative)
+ *   out_cap_height - Receives the height of capital letters
+ *   out_line_gap   - Receives the extra space between lines
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT (including an unknown
+ *   font id or a null output pointer).
+ */
+int pdf_font_metrics(const PdfHandle* handle, int font_id, double font_size,
+                     double* out_ascent, double* out_descent,
+                     double* out_cap_height, double* out_line_gap);
+
+/*
+ * Embed a JPEG or PNG image on a page.
+ *
+ * Baseline and progressive 8-bit JPEGs with 1 (gray), 3 (RGB) or 4 (CMYK
+ * or YCCK) components are supported. A CMYK JPEG with an Adobe APP14
+ * marker is embedded as inverted, the way Adobe applications write them.
+ * Lossless, arithmetic-coded and 12-bit JPEGs are rejected.
  *
  * Parameters:
- *   text      - The text content (null-terminated UTF-8 string)
- *   font_size - Font size in points (e.g., 12.0, 24.0)
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   data          - Image file bytes (format detected from magic bytes)
+ *   data_len      - Length of data in bytes
+ *   x, y          - Lower-left corner in points
+ *   width, height - Display size in points; pass 0 for either to keep
+ *                   the image's aspect ratio (both 0 = 1pt per pixel)
  *
  * Returns:
- *   A handle to the PDF document, or NULL on failure.
- *   Must be freed with pdf_free().
+ *   PDF_OK on success, PDF_ERR_INVALID_IMAGE if the bytes are not a
+ *   JPEG or PNG image, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_UNSUPPORTED
+ *   if built without the "images" feature, or PDF_ERR_INVALID_ARGUMENT.
  */
-PdfHandle* pdf_create_simple(const char* text, double font_size);
+int pdf_add_image(PdfHandle* handle, int page_index,
+                  const uint8_t* data, size_t data_len,
+                  double x, double y, double width, double height);
+
+/*
+ * Embed a CMYK JPEG on a page like pdf_add_image, saying whether its
+ * colors are stored inverted instead of relying on the Adobe marker.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   data          - JPEG file bytes with 4 color components
+ *   data_len      - Length of data in bytes
+ *   x, y          - Lower-left corner in points
+ *   width, height - Display size in points, as for pdf_add_image
+ *   inverted      - Nonzero if the CMYK samples are stored inverted
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_IMAGE if the bytes are not a
+ *   4-component JPEG, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_UNSUPPORTED
+ *   if built without the "images" feature, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_image_cmyk(PdfHandle* handle, int page_index,
+                       const uint8_t* data, size_t data_len,
+                       double x, double y, double width, double height,
+                       int inverted);
+
+/*
+ * Draw a rectangle on a page.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Lower-left corner in points (origin at bottom-left)
+ *   width, height - Size in points
+ *   stroke_width  - Outline width in points (0 for no outline)
+ *   filled        - Nonzero to fill with the current fill color
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including an unfilled rectangle with no
+ *   outline).
+ */
+int pdf_draw_rectangle(PdfHandle* handle, int page_index,
+                       double x, double y, double width, double height,
+                       double stroke_width, int filled);
+
+/*
+ * Draw a straight line on a page.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   page_index   - Zero-based page index
+ *   x1, y1       - Start point in points
+ *   x2, y2       - End point in points
+ *   stroke_width - Line width in points (must be greater than 0)
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_draw_line(PdfHandle* handle, int page_index,
+                  double x1, double y1, double x2, double y2,
+                  double stroke_width);
+
+/*
+ * Append raw content stream operators to a page, wrapped in their own q/Q.
+ * This is an escape hatch for operators the API has no function for: the
+ * bytes are not checked, and malformed operators, an unbalanced Q or a
+ * resource that was never added produce a file viewers may display wrongly
+ * or reject.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   page_index  - Zero-based page index
+ *   content     - Operators, such as "/GS1 gs 0 0 10 10 re f"; valid UTF-8,
+ *                 with binary strings written in hex
+ *   content_len - Length of content in bytes
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_append_raw_content(PdfHandle* handle, int page_index,
+                           const uint8_t* content, size_t content_len);
+
+/*
+ * Add a resource to a page for raw content operators to refer to,
+ * replacing one of the same type and name added before. Resources the
+ * library adds for drawing calls take precedence over one with the same
+ * name.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   name          - Name the operators use, such as "GS1"
+ *   resource_type - One of the PDF_RESOURCE_* constants
+ *   object        - The resource in PDF syntax, such as
+ *                   "<< /Type /ExtGState /CA 0.5 >>"; it must not hold
+ *                   streams or references
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_INVALID_ARGUMENT,
+ *   or PDF_ERR_UNSUPPORTED when built without the "parser" feature.
+ */
+int pdf_add_resource(PdfHandle* handle, int page_index, const char* name,
+                     int resource_type, const char* object);
+
+/*
+ * Draw a cubic Bezier curve on a page, stroked 1 point wide in the current
+ * stroke color.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x0, y0     - Start point in points
+ *   cx1, cy1   - First control point
+ *   cx2, cy2   - Second control point
+ *   x1, y1     - End point
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_draw_bezier(PdfHandle* handle, int page_index,
+                    double x0, double y0, double cx1, double cy1,
+                    double cx2, double cy2, double x1, double y1);
+
+/*
+ * Draw an ellipse on a page, approximated with four Bezier arcs.
+ *
+ * Parameters:
+ *   handle       - PDF handle from pdf_create_*
+ *   page_index   - Zero-based page index
+ *   cx, cy       - Center in points
+ *   rx, ry       - Horizontal and vertical radii (must be greater than 0);
+ *                  equal radii draw a circle
+ *   stroke_width - Outline width in points, or 0 for no outline
+ *   filled       - Nonzero to fill with the current fill color
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if a radius is not positive or the ellipse is
+ *   neither filled nor stroked.
+ */
+int pdf_draw_ellipse(PdfHandle* handle, int page_index,
+                     double cx, double cy, double rx, double ry,
+                     double stroke_width, int filled);
+
+/*
+ * Constrain later drawing on a page to a rectangle until the matching
+ * pdf_pop_clip(), or a pdf_restore_state() of a state saved before it.
+ * Nested clips intersect. Clips still pushed when the document is written
+ * are popped at the end of the page.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Bottom-left corner in points
+ *   width, height - Size in points (must be greater than 0)
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if the width or height is not positive.
+ */
+int pdf_push_clip_rect(PdfHandle* handle, int page_index,
+                       double x, double y, double width, double height);
+
+/*
+ * Remove the clip most recently pushed on a page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if the page has no clip to pop or a state saved
+ *   after the clip is not restored yet.
+ */
+int pdf_pop_clip(PdfHandle* handle, int page_index);
+
+/*
+ * Save the drawing state of a page: fill and stroke colors, line dash, text
+ * spacing and leading, transformation, and the clips pushed so far. Saves
+ * nest. States
+ * still saved when the document is written are restored at the end of the
+ * page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *
+ * Returns:
+ *   PDF_OK on success or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_save_state(PdfHandle* handle, int page_index);
+
+/*
+ * Restore the state most recently saved on a page with pdf_save_state(),
+ * undoing drawing state changes made since and popping clips pushed since.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if the page has no saved state.
+ */
+int pdf_restore_state(PdfHandle* handle, int page_index);
+
+/*
+ * Transform the coordinates of later drawing on a page by the affine matrix
+ * [a b c d e f], which maps (x, y) to (a*x + c*y + e, b*x + d*y + f) after
+ * the transformation already in effect. Scope it with pdf_save_state() and
+ * pdf_restore_state(). Links and form fields are not transformed.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   a .. f     - Matrix values
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if a value is not finite or the matrix is not
+ *   invertible.
+ */
+int pdf_transform(PdfHandle* handle, int page_index,
+                  double a, double b, double c, double d, double e, double f);
+
+/*
+ * Move the origin of later drawing on a page.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   tx, ty     - Offset in points
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if an offset is not finite.
+ */
+int pdf_translate(PdfHandle* handle, int page_index, double tx, double ty);
+
+/*
+ * Scale later drawing on a page about the origin.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   sx, sy     - Horizontal and vertical factors; negative factors mirror
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if a factor is zero or not finite.
+ */
+int pdf_scale(PdfHandle* handle, int page_index, double sx, double sy);
+
+/*
+ * Rotate later drawing on a page counterclockwise about the origin.
+ * Translate first to rotate about another point.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   angle_degrees - Rotation angle in degrees
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT if the angle is not finite.
+ */
+int pdf_rotate(PdfHandle* handle, int page_index, double angle_degrees);
+
+/*
+ * Draw a table with rows flowing down from its top-left corner. Every
+ * cell gets a 0.5 point border in the current stroke color; its text is
+ * set in Helvetica, left-aligned within 4 points of padding, vertically
+ * centered, and clipped to the padding.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Top-left corner of the table in points
+ *   cells      - Cell texts in row-major order (null-terminated UTF-8); a
+ *                NULL entry leaves its cell empty
+ *   cell_count - Number of entries in cells (must equal rows * cols)
+ *   rows, cols - Table dimensions (must be greater than 0)
+ *   col_widths - Array of cols column widths in points
+ *   row_height - Height of every row in points
+ *   font_size  - Font size in points
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_table(PdfHandle* handle, int page_index, double x, double y,
+                  const char* const* cells, size_t cell_count, int rows,
+                  int cols, const double* col_widths, double row_height,
+                  double font_size);
+
+/*
+ * Draw a bulleted, dashed, or numbered list flowing down from the top of
+ * its first line. Items wrap within width with a hanging indent, so
+ * continuation lines align with the text after the marker.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Left edge and top of the first line in points
+ *   width      - Width of the list, including markers, in points
+ *   items      - Item texts (null-terminated UTF-8); a NULL entry is an
+ *                empty item
+ *   item_count - Number of entries in items
+ *   font_size  - Font size in points
+ *   list_style - PDF_LIST_BULLET, PDF_LIST_DASH, or PDF_LIST_NUMBERED
+ *
+ * Returns:
+ *   The y-coordinate below the last item, or a negative error code
+ *   (PDF_ERR_PAGE_OUT_OF_RANGE or PDF_ERR_INVALID_ARGUMENT).
+ */
+double pdf_add_list(PdfHandle* handle, int page_index, double x, double y,
+                    double width, const char* const* items, size_t item_count,
+                    double font_size, int list_style);
+
+/*
+ * Lay out a small subset of HTML, flowing down from the top of the first
+ * line and wrapping within width. Understands p, h1-h3, br, b/strong,
+ * i/em, ul/li and span, styled by inline style (color, font-weight,
+ * font-style) and color attributes. Other tags are ignored but their text
+ * is kept. Body text is Helvetica at the size set with
+ * pdf_set_default_font, in the current fill color and leading.
+ * Requires the "html" feature.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   x, y       - Left edge and top of the first line in points
+ *   width      - Width of the column in points
+ *   html       - Markup (null-terminated UTF-8 string)
+ *
+ * Returns:
+ *   The y-coordinate below the last line, or a negative error code
+ *   (PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_INVALID_ARGUMENT, or
+ *   PDF_ERR_UNSUPPORTED without the "html" feature).
+ */
+double pdf_add_html(PdfHandle* handle, int page_index, double x, double y,
+                    double width, const char* html);
+
+/*
+ * Draw a barcode as filled vector bars in the current fill color. Leave
+ * about ten bar widths of blank space on each side so scanners can find
+ * the code.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   page_index    - Zero-based page index
+ *   x, y          - Lower-left corner in points
+ *   width, height - Size of the bars in points
+ *   data          - The data to encode (null-terminated UTF-8 string)
+ *   symbology     - PDF_BARCODE_CODE128
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, or
+ *   PDF_ERR_INVALID_ARGUMENT (including data the symbology cannot encode).
+ */
+int pdf_add_barcode(PdfHandle* handle, int page_index, double x, double y,
+                    double width, double height, const char* data,
+                    int symbology);
+
+/*
+ * Draw a QR code as filled vector modules in the current fill color. The
+ * four-module quiet zone is included in the size and left unpainted.
+ *
+ * Parameters:
+ *   handle           - PDF handle from pdf_create_*
+ *   page_index       - Zero-based page index
+ *   x, y             - Lower-left corner in points
+ *   size             - Width and height of the code and quiet zone in points
+ *   data             - The data to encode (null-terminated UTF-8 string)
+ *   error_correction - One of the PDF_QR_ECC_* levels
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_DATA_TOO_LONG if the data does not fit in a
+ *   QR code at that level (use a lower level or split the data),
+ *   PDF_ERR_PAGE_OUT_OF_RANGE, or PDF_ERR_INVALID_ARGUMENT.
+ */
+int pdf_add_qrcode(PdfHandle* handle, int page_index, double x, double y,
+                   double size, const char* data, int error_correction);
+
+/*
+ * Draw printer's marks around a page's trim box in registration color.
+ * They are drawn between the bleed box (or the trim box if there is none)
+ * and the media box, so they are cut away with the sheet; the media box
+ * must be larger than the bleed box to leave room.
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   marks      - PDF_MARKS_* flags combined with |
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT if the page has no trim box
+ *   (see pdf_set_page_box) or no room for marks, or PDF_ERR_PAGE_OUT_OF_RANGE.
+ */
+int pdf_add_printer_marks(PdfHandle* handle, int page_index, int marks);
 
 /*
  * Get the PDF data from a handle.
@@ -39,11 +2403,163 @@ PdfHandle* pdf_create_simple(const char* text, double font_size);
  *   out_data - Pointer to receive the data pointer
  *
  * Returns:
- *   The length of the data in bytes, or 0 on failure.
- *   The data pointer is valid until pdf_free() is called.
+ *   The length of the data in bytes, or 0 on failure (including a
+ *   document with no pages). All pages are serialized in order.
+ *   The data is owned by the handle and stays valid until the next call
+ *   that changes the document (adds pages or content, or changes a
+ *   document setting) or until pdf_free(). Use pdf_get_data_copy() to
+ *   keep it longer.
  */
 size_t pdf_get_data(const PdfHandle* handle, const uint8_t** out_data);
 
+/*
+ * Get a copy of the PDF data that the caller owns. The copy stays valid
+ * after the document changes and after pdf_free(), until it is released
+ * with pdf_free_buffer().
+ *
+ * Parameters:
+ *   handle   - PDF handle from pdf_create_*
+ *   out_data - Receives the copy, or NULL on failure
+ *   out_len  - Receives its length in bytes, or 0 on failure
+ *
+ * Returns:
+ *   PDF_OK on success, or PDF_ERR_INVALID_ARGUMENT (including a document
+ *   with no pages).
+ */
+int pdf_get_data_copy(const PdfHandle* handle, uint8_t** out_data, size_t* out_len);
+
+/*
+ * Render a page to a PNG image. Paths, colors, clipping, images and text in
+ * loaded fonts are drawn; standard-font text is drawn as grey bars, and
+ * form fields, links and transparency are left out. The image stays valid
+ * until it is released with pdf_free_buffer().
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   dpi        - Pixels per inch (72 draws one pixel per point)
+ *   out_data   - Receives the PNG bytes, or NULL on failure
+ *   out_len    - Receives their length, or 0 on failure
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_INVALID_ARGUMENT
+ *   for a resolution that is not positive or an image too large to render,
+ *   or PDF_ERR_UNSUPPORTED if the library was built without the "render"
+ *   feature (see pdf_has_feature()).
+ */
+int pdf_render_page_png(const PdfHandle* handle, int page_index, double dpi,
+                        uint8_t** out_data, size_t* out_len);
+
+/*
+ * Render every page as a small image stored as its thumbnail (/Thumb),
+ * which viewers show in their page navigation pane. Pages are drawn as by
+ * pdf_render_page_png. Each thumbnail adds about max_dimension squared
+ * times 1.5 bytes before compression, so 64 to 128 pixels is usual. Pages
+ * already written by pdf_begin_streaming get none.
+ *
+ * Parameters:
+ *   handle        - PDF handle from pdf_create_*
+ *   max_dimension - Length in pixels of each thumbnail's longer side
+ *
+ * Returns:
+ *   The number of thumbnails made, PDF_ERR_INVALID_ARGUMENT for a size that
+ *   is not positive, PDF_ERR_ABORTED if the progress callback cancelled, or
+ *   PDF_ERR_UNSUPPORTED if the library was built without the "render"
+ *   feature (see pdf_has_feature()).
+ */
+int pdf_generate_thumbnails(PdfHandle* handle, int max_dimension);
+
+/*
+ * Extract the text of a page as UTF-8, in the order the page shows it.
+ * Characters come from the fonts' ToUnicode maps, or from the encodings of
+ * fonts without one; text on a new baseline starts a new line. The string
+ * is owned by the handle and stays valid until the next call to this
+ * function or pdf_free().
+ *
+ * Parameters:
+ *   handle     - PDF handle from pdf_create_*
+ *   page_index - Zero-based page index
+ *   out_text   - Receives the null-terminated text, or NULL on failure
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_PAGE_OUT_OF_RANGE, PDF_ERR_INVALID_ARGUMENT,
+ *   or PDF_ERR_UNSUPPORTED for an encrypted document or if the library was
+ *   built without the "parser" feature (see pdf_has_feature()).
+ */
+int pdf_extract_text(PdfHandle* handle, int page_index, const char** out_text);
+
+/*
+ * Sign a finished PDF, such as one read from a file, as pdf_sign() does
+ * for a handle. The signature is appended as an incremental update, so the
+ * original bytes, and any signatures they already hold, stay valid. The
+ * signed PDF stays valid until it is released with pdf_free_buffer().
+ *
+ * Parameters:
+ *   data        - The PDF to sign
+ *   len         - Length of data in bytes
+ *   pkcs12_data - Contents of the PKCS#12 file
+ *   pkcs12_len  - Length of pkcs12_data in bytes
+ *   password    - Password of the PKCS#12 file ("" for none)
+ *   reason      - Reason for signing, or NULL
+ *   location    - Where the document was signed, or NULL
+ *   out_data    - Receives the signed PDF, or NULL on failure
+ *   out_len     - Receives its length, or 0 on failure
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_INVALID_ARGUMENT (including a wrong
+ *   password, or data that is not a PDF or is encrypted), or
+ *   PDF_ERR_UNSUPPORTED as for pdf_sign().
+ */
+int pdf_sign_pdf(const uint8_t* data, size_t len, const uint8_t* pkcs12_data,
+                 size_t pkcs12_len, const char* password, const char* reason,
+                 const char* location, uint8_t** out_data, size_t* out_len);
+
+/*
+ * Free a buffer returned by pdf_get_data_copy, pdf_render_page_png or
+ * pdf_sign_pdf. Do not pass it to free().
+ *
+ * Parameters:
+ *   data - Buffer to free (NULL is safely ignored)
+ */
+void pdf_free_buffer(uint8_t* data);
+
+/*
+ * Serialize the PDF, passing the output to a callback in chunks as it is
+ * produced, without building the whole file in one buffer.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   callback  - Called with each chunk, in order
+ *   user_data - Passed to every callback invocation unchanged
+ *
+ * Returns:
+ *   PDF_OK on success, PDF_ERR_ABORTED if the callback returned nonzero,
+ *   or PDF_ERR_INVALID_ARGUMENT (including a document with no pages, or
+ *   one being streamed with pdf_begin_streaming).
+ */
+int pdf_write_to_callback(const PdfHandle* handle, PdfWriteCallback callback,
+                          void* user_data);
+
+/*
+ * Set a callback told about the progress of pdf_append_pdf ("merge"),
+ * serialization ("serialize"), and pdf_render_page_png and
+ * pdf_generate_thumbnails ("render").
+ * Returning nonzero cancels the operation, which returns PDF_ERR_ABORTED
+ * and leaves the document as it was. The report of 1.0 comes after the
+ * operation finishes, so its return value is ignored. The callback must
+ * not use the handle.
+ *
+ * Parameters:
+ *   handle    - PDF handle from pdf_create_*
+ *   callback  - Progress callback, or NULL to remove it
+ *   user_data - Passed to every callback invocation unchanged
+ *
+ * Returns:
+ *   PDF_OK on success, or a negative error code.
+ */
+int pdf_set_progress_callback(PdfHandle* handle, PdfProgressCallback callback,
+                              void* user_data);
+
 /*
  * Save the PDF to a file.
  *
@@ -52,15 +2568,88 @@ size_t pdf_get_data(const PdfHandle* handle, const uint8_t** out_data);
  *   path   - File path (null-terminated string)
  *
  * Returns:
- *   0 on success, -1 on failure.
+ *   0 on success, PDF_ERR_ABORTED if the progress callback cancelled
+ *   serialization, PDF_ERR_BUSY if another call is using the handle, or
+ *   PDF_ERR_INVALID_ARGUMENT (see pdf_last_error() for the cause).
  */
 int pdf_save_to_file(const PdfHandle* handle, const char* path);
 
 /*
- * Free a PDF handle.
+ * Save the PDF to a file, stopping if *cancel_flag becomes nonzero. Another
+ * thread may set the flag during the call, and should write it atomically.
+ * A cancelled save does not touch the file, so no partial output is left
+ * behind.
+ *
+ * Parameters:
+ *   handle      - PDF handle from pdf_create_*
+ *   path        - File path (null-terminated string)
+ *   cancel_flag - Cancels the save when nonzero; NULL never cancels
+ *
+ * Returns:
+ *   0 on success, PDF_ERR_CANCELLED if the flag was set, or another
+ *   negative error code (see pdf_last_error() for the cause).
+ */
+int pdf_save_to_file_cancellable(const PdfHandle* handle, const char* path,
+                                 const int* cancel_flag);
+
+/*
+ * Start writing the document to a file page by page instead of building it
+ * in memory, so memory use stays about the same however many pages are
+ * added. Each page is written and dropped once the next one is added; only
+ * the last page can still be drawn on. Moving or deleting pages returns
+ * PDF_ERR_UNSUPPORTED and pdf_get_data fails until pdf_finalize_streaming.
+ * The objects of a file appended with pdf_append_pdf* are freed once its
+ * last page has been written, so appended files do not add up.
+ * Page numbers, tagging, PDF/A, linearization and pdf_optimize cannot be
+ * used, and document settings must be made before this call.
+ *
+ * Parameters:
+ *   handle - PDF handle from pdf_create_*
+ *   path   - File path (null-terminated string)
+ *
+ * Returns:
+ *   0 on success, -1 on failure (see pdf_last_error() for the cause).
+ */
+int pdf_begin_streaming(PdfHandle* handle, const char* path);
+
+/*
+ * Write the remaining pages and the rest of a streamed document and close
+ * the file. The handle is left without pages.
+ *
+ * Parameters:
+ *   handle - PDF handle passed to pdf_begin_streaming
+ *
+ * Returns:
+ *   0 on success, -1 on failure (see pdf_last_error() for the cause).
+ */
+int pdf_finalize_streaming(PdfHandle* handle);
+
+/*
+ * Get a human-readable message for the most recent failed call on the
+ * calling thread. Successful calls do not clear the message, though a
+ * few record a warning, such as pdf_add_named_destination replacing a
+ * destination.
+ *
+ * Returns:
+ *   The message, or NULL if no call has failed since pdf_clear_error().
+ *   The string is owned by the library and remains valid until the next
+ *   failed call or pdf_clear_error() on the same thread. Do not free it.
+ */
+const char* pdf_last_error(void);
+
+/*
+ * Clear the calling thread's last error message.
+ */
+void pdf_clear_error(void);
+
+/*
+ * Free a PDF handle. A handle that was already freed, or that another
+ * call is using, is left alone and the problem reported through
+ * pdf_last_error().
  *
  * Parameters:
- *   handle - PDF handle to free (NULL is safely ignored)
+ *   handle - PDF handle to free (NULL is safely ignored). No other thread
+ *            may be using it.
  */
 void pdf_free(PdfHandle* handle);
 
@@ -73,6 +2662,29 @@ void pdf_free(PdfHandle* handle);
  */
 const char* pdf_version(void);
 
+/*
+ * Check whether the library was built with an optional feature.
+ *
+ * Parameters:
+ *   name - Feature name: "compression", "images", "parser", "encryption",
+ *          "signatures", "render", "html" or "office"
+ *
+ * Returns:
+ *   1 if the feature is available, 0 if it is not or the name is unknown,
+ *   or PDF_ERR_INVALID_ARGUMENT if name is NULL.
+ */
+int pdf_has_feature(const char* name);
+
+/*
+ * Describe the build for diagnostics: the library version, the optional
+ * features it includes and its build profile, for example
+ * "rust-pdf 0.1.0 (features: compression, render; profile: release)".
+ *
+ * Returns:
+ *   A static string. Do not free it.
+ */
+const char* pdf_build_info(void);
+
 #ifdef __cplusplus
 }
 #endif
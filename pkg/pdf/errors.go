@@ -0,0 +1,43 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is a failure reported by the library, with the PDF_ERR_* code it
+// returned and the message from pdf_last_error.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("rust-pdf: error %d", e.Code)
+	}
+	return "rust-pdf: " + e.Message
+}
+
+// Is reports whether target is an *Error with the same code, so callers can
+// write errors.Is(err, pdf.ErrPageOutOfRange).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errors matching the PDF_ERR_* codes of rust_pdf.h, for use with errors.Is.
+var (
+	ErrInvalidArgument = &Error{Code: -1, Message: "invalid argument"}
+	ErrPageOutOfRange  = &Error{Code: -2, Message: "page out of range"}
+	ErrInvalidImage    = &Error{Code: -3, Message: "invalid image"}
+	ErrUnsupported     = &Error{Code: -4, Message: "unsupported"}
+	ErrInvalidFont     = &Error{Code: -5, Message: "invalid font"}
+	ErrBusy            = &Error{Code: -6, Message: "handle in use by another call"}
+	ErrAborted         = &Error{Code: -7, Message: "aborted"}
+	ErrDataTooLong     = &Error{Code: -8, Message: "data too long"}
+	ErrCancelled       = &Error{Code: -9, Message: "cancelled"}
+)
+
+// ErrClosed is returned by every method called after Close.
+var ErrClosed = errors.New("rust-pdf: document is closed")
@@ -0,0 +1,3 @@
+module github.com/galihlasahido/rust-pdf/pkg/pdf
+
+go 1.18
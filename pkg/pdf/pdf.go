@@ -0,0 +1,207 @@
+// Package pdf wraps the rust-pdf C API in Go types.
+//
+// The library must be built first (cargo build --release). The package links
+// against target/release in this repository by default; set CGO_LDFLAGS to
+// link against a copy installed elsewhere, and LD_LIBRARY_PATH (or
+// DYLD_LIBRARY_PATH on macOS) so it is found at run time.
+//
+//	doc, err := pdf.New()
+//	if err != nil {
+//		return err
+//	}
+//	defer doc.Close()
+//	page, err := doc.AddPage(0, 0)
+//	...
+//	err = doc.AddText(page, 72, 720, "Hello from Go!", 24)
+//	...
+//	err = doc.Save("hello.pdf")
+package pdf
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../../include
+#cgo LDFLAGS: -L${SRCDIR}/../../target/release -lrust_pdf
+#include <stdlib.h>
+#include "rust_pdf.h"
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// PDF is a document being built. Its methods must not be called from two
+// goroutines at once; use Clone to give each goroutine its own copy.
+type PDF struct {
+	handle *C.PdfHandle
+}
+
+// New creates an empty document with no pages.
+func New() (*PDF, error) {
+	return wrap(func() *C.PdfHandle { return C.pdf_create_empty() })
+}
+
+// NewText creates a document with text laid out on US Letter pages.
+func NewText(text string, fontSize float64) (*PDF, error) {
+	ctext := C.CString(text)
+	defer C.free(unsafe.Pointer(ctext))
+	return wrap(func() *C.PdfHandle { return C.pdf_create_simple(ctext, C.double(fontSize)) })
+}
+
+// Clone returns an independent copy of the document.
+func (p *PDF) Clone() (*PDF, error) {
+	if p.handle == nil {
+		return nil, ErrClosed
+	}
+	defer runtime.KeepAlive(p)
+	return wrap(func() *C.PdfHandle { return C.pdf_clone(p.handle) })
+}
+
+// AddPage appends a page of the given size in points, or US Letter if both
+// are 0, and returns its zero-based index.
+func (p *PDF) AddPage(width, height float64) (int, error) {
+	return p.call(func(h *C.PdfHandle) C.int {
+		return C.pdf_add_page(h, C.double(width), C.double(height))
+	})
+}
+
+// AddText draws text on a page with its baseline starting at x, y, in points
+// from the bottom-left corner. A fontSize of 0 uses the document's default size.
+func (p *PDF) AddText(page int, x, y float64, text string, fontSize float64) error {
+	ctext := C.CString(text)
+	defer C.free(unsafe.Pointer(ctext))
+	_, err := p.call(func(h *C.PdfHandle) C.int {
+		return C.pdf_add_text(h, C.int(page), C.double(x), C.double(y), ctext, C.double(fontSize))
+	})
+	return err
+}
+
+// Save writes the document to a file.
+func (p *PDF) Save(path string) error {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	_, err := p.call(func(h *C.PdfHandle) C.int { return C.pdf_save_to_file(h, cpath) })
+	return err
+}
+
+// SaveContext writes the document to a file like Save, but stops if ctx is
+// done first. A cancelled save writes nothing to path and returns ctx.Err().
+func (p *PDF) SaveContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	// The library polls the flag while it serializes; the goroutine sets it
+	// when ctx is done and must finish before the flag goes out of scope.
+	var flag int32
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&flag, 1)
+		case <-done:
+		}
+	}()
+	_, err := p.call(func(h *C.PdfHandle) C.int {
+		return C.pdf_save_to_file_cancellable(h, cpath, (*C.int)(unsafe.Pointer(&flag)))
+	})
+	close(done)
+	<-stopped
+	if errors.Is(err, ErrCancelled) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Bytes serializes the document. The result is a copy the caller owns.
+func (p *PDF) Bytes() ([]byte, error) {
+	var data *C.uint8_t
+	var size C.size_t
+	_, err := p.call(func(h *C.PdfHandle) C.int { return C.pdf_get_data_copy(h, &data, &size) })
+	if err != nil {
+		return nil, err
+	}
+	defer C.pdf_free_buffer(data)
+	// C.GoBytes takes a C int, which cannot hold the length of a file over 2 GiB
+	return append([]byte(nil), unsafe.Slice((*byte)(unsafe.Pointer(data)), size)...), nil
+}
+
+// Close frees the document. Calling it again does nothing.
+func (p *PDF) Close() error {
+	if p.handle != nil {
+		C.pdf_free(p.handle)
+		p.handle = nil
+		runtime.SetFinalizer(p, nil)
+	}
+	return nil
+}
+
+// Version returns the version of the linked library.
+func Version() string {
+	return C.GoString(C.pdf_version())
+}
+
+// HasFeature reports whether the linked library was built with an optional
+// feature, such as "render" or "encryption". Unknown names report false.
+func HasFeature(name string) bool {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.pdf_has_feature(cname) == 1
+}
+
+// BuildInfo describes the linked library: its version, enabled features and
+// build profile.
+func BuildInfo() string {
+	return C.GoString(C.pdf_build_info())
+}
+
+// wrap takes ownership of the handle returned by create, freeing it when
+// the PDF is garbage collected if Close is never called.
+func wrap(create func() *C.PdfHandle) (*PDF, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	handle := create()
+	if handle == nil {
+		return nil, lastError(ErrInvalidArgument.Code)
+	}
+	p := &PDF{handle: handle}
+	runtime.SetFinalizer(p, (*PDF).Close)
+	return p, nil
+}
+
+// call runs f with the handle and turns a negative result into an error.
+// The call and the error lookup stay on one OS thread, because the library
+// keeps the last error per thread.
+func (p *PDF) call(f func(*C.PdfHandle) C.int) (int, error) {
+	if p.handle == nil {
+		return 0, ErrClosed
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer runtime.KeepAlive(p)
+
+	result := int(f(p.handle))
+	if result < 0 {
+		return 0, lastError(result)
+	}
+	return result, nil
+}
+
+// lastError builds an error from code and the calling thread's last error
+// message, then clears the message.
+func lastError(code int) error {
+	err := &Error{Code: code}
+	if message := C.pdf_last_error(); message != nil {
+		err.Message = C.GoString(message)
+		C.pdf_clear_error()
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildAndSave(t *testing.T) {
+	doc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	page, err := doc.AddPage(0, 0)
+	if err != nil || page != 0 {
+		t.Fatalf("AddPage = %d, %v", page, err)
+	}
+	if err := doc.AddText(page, 72, 720, "Hello from Go", 24); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Fatalf("output starts with %q", data[:8])
+	}
+
+	path := filepath.Join(t.TempDir(), "hello.pdf")
+	if err := doc.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Fatal("saved file differs from Bytes")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	doc, err := NewText("Errors", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = doc.AddText(5, 72, 720, "Missing", 12)
+	if !errors.Is(err, ErrPageOutOfRange) {
+		t.Fatalf("AddText on a missing page = %v", err)
+	}
+	var pdfErr *Error
+	if !errors.As(err, &pdfErr) || pdfErr.Message == "" {
+		t.Fatalf("error has no message: %#v", err)
+	}
+
+	clone, err := doc.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Close()
+	doc.Close()
+	if _, err := doc.Bytes(); err != ErrClosed {
+		t.Fatalf("Bytes after Close = %v", err)
+	}
+	if _, err := clone.Bytes(); err != nil {
+		t.Fatalf("clone was freed with the original: %v", err)
+	}
+	clone.Close()
+}
+
+func TestSaveContext(t *testing.T) {
+	doc, err := NewText("Cancellable", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	path := filepath.Join(t.TempDir(), "cancelled.pdf")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := doc.SaveContext(ctx, path); err != context.Canceled {
+		t.Fatalf("SaveContext with a cancelled context = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("cancelled save left a file: %v", err)
+	}
+
+	if err := doc.SaveContext(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := os.ReadFile(path); err != nil || !bytes.HasPrefix(saved, []byte("%PDF-")) {
+		t.Fatalf("saved file is not a PDF: %v", err)
+	}
+}
+
+func TestSaveContextCancelledWhileSaving(t *testing.T) {
+	doc, err := NewText(strings.Repeat("A document large enough to take a while to save. ", 100000), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	path := filepath.Join(t.TempDir(), "large.pdf")
+
+	// Clones have no cached output, so each save serializes from scratch
+	save := func(ctx context.Context) error {
+		clone, err := doc.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clone.Close()
+		return clone.SaveContext(ctx, path)
+	}
+	start := time.Now()
+	if err := save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	// Cancel halfway through a save; should a slow start let the save win
+	// the race, try again with an earlier cancel
+	for delay := elapsed / 2; delay > 0; delay /= 4 {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		timer := time.AfterFunc(delay, cancel)
+		err := save(ctx)
+		timer.Stop()
+		cancel()
+		if err == nil {
+			continue
+		}
+		if err != context.Canceled {
+			t.Fatalf("SaveContext cancelled after %v = %v", delay, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("save cancelled after %v left a file: %v", delay, err)
+		}
+		return
+	}
+	t.Fatalf("every save finished before it was cancelled (an uncancelled save took %v)", elapsed)
+}
+
+func TestEmptyDocument(t *testing.T) {
+	doc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if _, err := doc.Bytes(); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Bytes of a document with no pages = %v, want ErrInvalidArgument", err)
+	}
+	if Version() == "" {
+		t.Fatal("empty version")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	if HasFeature("no-such-feature") {
+		t.Fatal("unknown feature reported as available")
+	}
+	info := BuildInfo()
+	if !strings.HasPrefix(info, "rust-pdf "+Version()) {
+		t.Fatalf("build info %q does not start with the version", info)
+	}
+	if HasFeature("compression") != strings.Contains(info, "compression") {
+		t.Fatalf("build info %q disagrees with HasFeature", info)
+	}
+}
@@ -1,5 +1,6 @@
 //! CMYK Color handling.
 
+use super::clamp_component;
 use crate::error::ContentError;
 
 /// A CMYK color with components in the range [0.0, 1.0].
@@ -35,6 +36,18 @@ impl CmykColor {
         Self { c, m, y, k }
     }
 
+    /// Creates a new CMYK color, clamping each component to [0.0, 1.0].
+    ///
+    /// NaN components are treated as 0.0.
+    pub fn clamped(c: f64, m: f64, y: f64, k: f64) -> Self {
+        Self {
+            c: clamp_component(c),
+            m: clamp_component(m),
+            y: clamp_component(y),
+            k: clamp_component(k),
+        }
+    }
+
     fn validate_component(value: f64) -> Result<(), ContentError> {
         if !(0.0..=1.0).contains(&value) {
             return Err(ContentError::InvalidColorValue(value));
@@ -111,6 +124,12 @@ mod tests {
         assert!(CmykColor::new(1.5, 0.0, 0.0, 0.0).is_err());
     }
 
+    #[test]
+    fn test_clamped() {
+        let color = CmykColor::clamped(-0.5, 0.25, 3.0, f64::NAN);
+        assert_eq!(color.as_tuple(), (0.0, 0.25, 1.0, 0.0));
+    }
+
     #[test]
     fn test_predefined_colors() {
         assert_eq!(CmykColor::BLACK.k, 1.0);
@@ -0,0 +1,225 @@
+//! Linear and radial gradients, painted as shading patterns.
+
+use super::RgbColor;
+use crate::error::ContentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName};
+
+/// A color at a position along a gradient.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct GradientStop {
+    /// Position from 0.0 (the start) to 1.0 (the end).
+    pub offset: f64,
+    /// Color at the position.
+    pub color: RgbColor,
+}
+
+/// Where a gradient runs, in the default coordinates of the page.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum GradientGeometry {
+    /// Along the line from (`x0`, `y0`) to (`x1`, `y1`).
+    Linear {
+        /// X of the start point.
+        x0: f64,
+        /// Y of the start point.
+        y0: f64,
+        /// X of the end point.
+        x1: f64,
+        /// Y of the end point.
+        y1: f64,
+    },
+    /// Outwards from the start circle to the end circle.
+    Radial {
+        /// X of the start circle's center.
+        x0: f64,
+        /// Y of the start circle's center.
+        y0: f64,
+        /// Radius of the start circle.
+        r0: f64,
+        /// X of the end circle's center.
+        x1: f64,
+        /// Y of the end circle's center.
+        y1: f64,
+        /// Radius of the end circle.
+        r1: f64,
+    },
+}
+
+/// A smooth blend between colors. Areas before the start and past the end
+/// keep the first and last colors.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Gradient {
+    /// Where the gradient runs.
+    pub geometry: GradientGeometry,
+    /// Colors along the gradient, by increasing offset.
+    pub stops: Vec<GradientStop>,
+}
+
+impl Gradient {
+    /// Creates a gradient along the line from (`x0`, `y0`) to (`x1`, `y1`).
+    pub fn linear(x0: f64, y0: f64, x1: f64, y1: f64) -> Self {
+        Self {
+            geometry: GradientGeometry::Linear { x0, y0, x1, y1 },
+            stops: Vec::new(),
+        }
+    }
+
+    /// Creates a gradient from the center (`cx`, `cy`) out to `radius`.
+    pub fn radial(cx: f64, cy: f64, radius: f64) -> Self {
+        Self {
+            geometry: GradientGeometry::Radial {
+                x0: cx,
+                y0: cy,
+                r0: 0.0,
+                x1: cx,
+                y1: cy,
+                r1: radius,
+            },
+            stops: Vec::new(),
+        }
+    }
+
+    /// Adds a color stop at `offset`, from 0.0 to 1.0.
+    pub fn stop(mut self, offset: f64, color: RgbColor) -> Self {
+        self.stops.push(GradientStop { offset, color });
+        self
+    }
+
+    /// Checks that the gradient has at least two stops with offsets from
+    /// 0.0 to 1.0 that never decrease, and finite coordinates.
+    pub fn check(&self) -> Result<(), ContentError> {
+        if self.stops.len() < 2 {
+            return Err(ContentError::InvalidGradient(format!(
+                "{} color stops given, at least 2 are needed",
+                self.stops.len()
+            )));
+        }
+        let mut previous = 0.0;
+        for stop in &self.stops {
+            if !(previous..=1.0).contains(&stop.offset) {
+                return Err(ContentError::InvalidGradient(format!(
+                    "stop offset {} is not between {} and 1",
+                    stop.offset, previous
+                )));
+            }
+            previous = stop.offset;
+        }
+        let (coords, radii) = match self.geometry {
+            GradientGeometry::Linear { x0, y0, x1, y1 } => ([x0, y0, x1, y1], [0.0, 0.0]),
+            GradientGeometry::Radial { x0, y0, r0, x1, y1, r1 } => ([x0, y0, x1, y1], [r0, r1]),
+        };
+        if !coords.iter().chain(&radii).all(|v| v.is_finite()) || radii.iter().any(|&r| r < 0.0) {
+            return Err(ContentError::InvalidGradient("coordinates must be finite and radii not negative".into()));
+        }
+        Ok(())
+    }
+
+    /// Returns the shading pattern dictionary that paints the gradient. The
+    /// gradient must pass [`Gradient::check`].
+    pub fn to_pattern(&self) -> PdfDictionary {
+        let (shading_type, coords) = match self.geometry {
+            GradientGeometry::Linear { x0, y0, x1, y1 } => (2, vec![x0, y0, x1, y1]),
+            GradientGeometry::Radial { x0, y0, r0, x1, y1, r1 } => (3, vec![x0, y0, r0, x1, y1, r1]),
+        };
+        let mut shading = PdfDictionary::new();
+        shading.set("ShadingType", Object::Integer(shading_type));
+        shading.set("ColorSpace", Object::Name(PdfName::new_unchecked("DeviceRGB")));
+        shading.set("Coords", numbers(&coords));
+        shading.set("Function", Object::Dictionary(self.function()));
+        shading.set("Extend", Object::Array([Object::Boolean(true), Object::Boolean(true)].into_iter().collect()));
+
+        let mut pattern = PdfDictionary::new();
+        pattern.set("Type", Object::Name(PdfName::new_unchecked("Pattern")));
+        pattern.set("PatternType", Object::Integer(2));
+        pattern.set("Shading", Object::Dictionary(shading));
+        pattern
+    }
+
+    /// Builds the function from offsets to colors: one interpolation per
+    /// pair of stops, stitched together where there are several. Stops at
+    /// the same offset make a hard edge.
+    fn function(&self) -> PdfDictionary {
+        let mut stops = self.stops.clone();
+        if let Some(&first) = stops.first().filter(|stop| stop.offset > 0.0) {
+            stops.insert(0, GradientStop { offset: 0.0, ..first });
+        }
+        if let Some(&last) = stops.last().filter(|stop| stop.offset < 1.0) {
+            stops.push(GradientStop { offset: 1.0, ..last });
+        }
+        let segments: Vec<(&GradientStop, &GradientStop)> =
+            stops.windows(2).filter(|pair| pair[0].offset < pair[1].offset).map(|pair| (&pair[0], &pair[1])).collect();
+        if let [(from, to)] = segments[..] {
+            return interpolation(from.color, to.color);
+        }
+
+        let mut function = PdfDictionary::new();
+        function.set("FunctionType", Object::Integer(3));
+        function.set("Domain", numbers(&[0.0, 1.0]));
+        let functions = segments.iter().map(|(from, to)| Object::Dictionary(interpolation(from.color, to.color)));
+        function.set("Functions", Object::Array(functions.collect()));
+        let bounds: Vec<f64> = segments[1..].iter().map(|(from, _)| from.offset).collect();
+        function.set("Bounds", numbers(&bounds));
+        function.set("Encode", numbers(&segments.iter().flat_map(|_| [0.0, 1.0]).collect::<Vec<_>>()));
+        function
+    }
+}
+
+/// Builds a function that blends linearly from `from` to `to`.
+fn interpolation(from: RgbColor, to: RgbColor) -> PdfDictionary {
+    let mut function = PdfDictionary::new();
+    function.set("FunctionType", Object::Integer(2));
+    function.set("Domain", numbers(&[0.0, 1.0]));
+    function.set("C0", numbers(&[from.r, from.g, from.b]));
+    function.set("C1", numbers(&[to.r, to.g, to.b]));
+    function.set("N", Object::Integer(1));
+    function
+}
+
+fn numbers(values: &[f64]) -> Object {
+    Object::Array(values.iter().map(|&v| Object::Real(v)).collect::<PdfArray>())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check() {
+        let gradient = Gradient::linear(0.0, 0.0, 100.0, 0.0).stop(0.0, RgbColor::RED);
+        assert!(gradient.check().is_err());
+        let gradient = gradient.stop(1.0, RgbColor::BLUE);
+        assert!(gradient.check().is_ok());
+        assert!(gradient.clone().stop(0.5, RgbColor::GREEN).check().is_err());
+        assert!(Gradient::radial(0.0, 0.0, -1.0).stop(0.0, RgbColor::RED).stop(1.0, RgbColor::BLUE).check().is_err());
+        assert!(Gradient::linear(0.0, 0.0, f64::NAN, 0.0).stop(0.0, RgbColor::RED).stop(1.0, RgbColor::BLUE).check().is_err());
+    }
+
+    #[test]
+    fn test_two_stop_pattern() {
+        let pattern = Gradient::linear(0.0, 0.0, 100.0, 50.0)
+            .stop(0.0, RgbColor::RED)
+            .stop(1.0, RgbColor::BLUE)
+            .to_pattern();
+        assert_eq!(
+            pattern.to_pdf_string(),
+            "<< /Type /Pattern /PatternType 2 /Shading << /ShadingType 2 /ColorSpace /DeviceRGB \
+             /Coords [0 0 100 50] /Function << /FunctionType 2 /Domain [0 1] /C0 [1 0 0] /C1 [0 0 1] /N 1 >> \
+             /Extend [true true] >> >>"
+        );
+    }
+
+    #[test]
+    fn test_stitched_pattern() {
+        let pattern = Gradient::radial(50.0, 50.0, 40.0)
+            .stop(0.25, RgbColor::RED)
+            .stop(0.5, RgbColor::GREEN)
+            .stop(0.5, RgbColor::BLUE)
+            .stop(1.0, RgbColor::BLACK)
+            .to_pattern()
+            .to_pdf_string();
+        assert!(pattern.contains("/ShadingType 3"));
+        assert!(pattern.contains("/Coords [50 50 0 50 50 40]"));
+        // A leading stop repeats the first color, and the hard edge at 0.5 has no segment
+        assert!(pattern.contains("/C0 [1 0 0] /C1 [1 0 0]"));
+        assert!(pattern.contains("/Bounds [0.25 0.5] /Encode [0 1 0 1 0 1]"));
+    }
+}
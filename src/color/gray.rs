@@ -1,5 +1,6 @@
 //! Grayscale Color handling.
 
+use super::clamp_component;
 use crate::error::ContentError;
 
 /// A grayscale color with value in the range [0.0, 1.0].
@@ -28,6 +29,15 @@ impl GrayColor {
         Self { level }
     }
 
+    /// Creates a new grayscale color, clamping the level to [0.0, 1.0].
+    ///
+    /// A NaN level is treated as 0.0.
+    pub fn clamped(level: f64) -> Self {
+        Self {
+            level: clamp_component(level),
+        }
+    }
+
     /// Returns the gray level.
     pub fn level(&self) -> f64 {
         self.level
@@ -73,6 +83,13 @@ mod tests {
         assert!(GrayColor::new(-0.1).is_err());
     }
 
+    #[test]
+    fn test_clamped() {
+        assert_eq!(GrayColor::clamped(1.5).level, 1.0);
+        assert_eq!(GrayColor::clamped(0.25).level, 0.25);
+        assert_eq!(GrayColor::clamped(f64::NAN).level, 0.0);
+    }
+
     #[test]
     fn test_predefined_colors() {
         assert_eq!(GrayColor::BLACK.level, 0.0);
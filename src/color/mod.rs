@@ -1,12 +1,27 @@
 //! Color types for PDF content streams.
 
 mod cmyk;
+mod gradient;
 mod gray;
+mod overprint;
 mod rgb;
+mod tiling;
 
 pub use cmyk::CmykColor;
+pub use gradient::{Gradient, GradientGeometry, GradientStop};
 pub use gray::GrayColor;
+pub use overprint::Overprint;
 pub use rgb::RgbColor;
+pub use tiling::TilingPattern;
+
+/// Clamps a color component to [0.0, 1.0], treating NaN as 0.0.
+fn clamp_component(value: f64) -> f64 {
+    if value.is_nan() {
+        0.0
+    } else {
+        value.clamp(0.0, 1.0)
+    }
+}
 
 /// A color that can be used in PDF content streams.
 #[derive(Debug, Clone, Copy, PartialEq)]
@@ -0,0 +1,59 @@
+//! Overprint control for print production.
+
+use crate::object::{Object, PdfDictionary, PdfName};
+
+/// Whether ink painted on a separation prints on top of the inks beneath it
+/// or knocks them out, applied through a graphics state resource.
+///
+/// Overprinting black text over a colored background avoids the white gaps
+/// misregistered plates leave around knocked-out text.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Default)]
+pub struct Overprint {
+    /// Overprint when filling (/op).
+    pub fill: bool,
+    /// Overprint when stroking (/OP).
+    pub stroke: bool,
+    /// Nonzero overprint mode (/OPM 1): a CMYK component of 0 leaves the ink
+    /// beneath unchanged instead of knocking it out.
+    pub nonzero_mode: bool,
+}
+
+impl Overprint {
+    /// Returns the name the graphics state is added to page resources with,
+    /// which differs for every combination of settings.
+    pub fn resource_name(&self) -> String {
+        format!(
+            "GSOverprint{}{}{}",
+            self.fill as u8, self.stroke as u8, self.nonzero_mode as u8
+        )
+    }
+
+    /// Builds the graphics state dictionary that applies the settings.
+    pub fn to_ext_gstate(&self) -> PdfDictionary {
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("ExtGState")));
+        dict.set("OP", Object::Boolean(self.stroke));
+        dict.set("op", Object::Boolean(self.fill));
+        dict.set("OPM", Object::Integer(self.nonzero_mode as i64));
+        dict
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_overprint_ext_gstate() {
+        let overprint = Overprint {
+            fill: true,
+            stroke: false,
+            nonzero_mode: true,
+        };
+        assert_eq!(overprint.resource_name(), "GSOverprint101");
+        let dict = overprint.to_ext_gstate();
+        assert_eq!(dict.get("OP"), Some(&Object::Boolean(false)));
+        assert_eq!(dict.get("op"), Some(&Object::Boolean(true)));
+        assert_eq!(dict.get("OPM"), Some(&Object::Integer(1)));
+    }
+}
@@ -1,5 +1,6 @@
 //! RGB Color handling.
 
+use super::clamp_component;
 use crate::error::ContentError;
 
 /// An RGB color with components in the range [0.0, 1.0].
@@ -32,6 +33,17 @@ impl RgbColor {
         Self { r, g, b }
     }
 
+    /// Creates a new RGB color, clamping each component to [0.0, 1.0].
+    ///
+    /// NaN components are treated as 0.0.
+    pub fn clamped(r: f64, g: f64, b: f64) -> Self {
+        Self {
+            r: clamp_component(r),
+            g: clamp_component(g),
+            b: clamp_component(b),
+        }
+    }
+
     /// Creates an RGB color from 8-bit components (0-255).
     pub fn from_u8(r: u8, g: u8, b: u8) -> Self {
         Self {
@@ -131,6 +143,13 @@ mod tests {
         assert!(RgbColor::new(0.0, -0.1, 0.0).is_err());
     }
 
+    #[test]
+    fn test_clamped() {
+        let color = RgbColor::clamped(-0.5, 0.25, 3.0);
+        assert_eq!(color.as_tuple(), (0.0, 0.25, 1.0));
+        assert_eq!(RgbColor::clamped(f64::NAN, 0.0, 0.0).r, 0.0);
+    }
+
     #[test]
     fn test_from_u8() {
         let color = RgbColor::from_u8(255, 128, 0);
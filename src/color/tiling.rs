@@ -0,0 +1,84 @@
+//! Tiling patterns, which fill areas with a repeating cell.
+
+use crate::content::ContentBuilder;
+use crate::error::ContentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream};
+
+/// A cell of content repeated edge to edge across filled areas, such as the
+/// hatching of a section in an engineering drawing.
+///
+/// Cells line up with the page origin rather than the shape they fill, so
+/// adjacent shapes filled with the same pattern continue it seamlessly.
+#[derive(Debug, Clone)]
+pub struct TilingPattern {
+    /// Width of a cell, which is also the horizontal distance between cells.
+    pub width: f64,
+    /// Height of a cell, which is also the vertical distance between cells.
+    pub height: f64,
+    /// Operators painting one cell, with the origin at its lower-left corner.
+    /// Anything outside the cell is clipped. The cell has no resources, so
+    /// it cannot show text or images.
+    pub content: ContentBuilder,
+}
+
+impl TilingPattern {
+    /// Creates a pattern of `width` by `height` cells painted by `content`.
+    pub fn new(width: f64, height: f64, content: ContentBuilder) -> Self {
+        Self { width, height, content }
+    }
+
+    /// Checks that the cell has a finite, positive size.
+    pub fn check(&self) -> Result<(), ContentError> {
+        if [self.width, self.height].iter().all(|v| v.is_finite() && *v > 0.0) {
+            Ok(())
+        } else {
+            Err(ContentError::InvalidPattern(format!(
+                "cell size {} x {} is not positive",
+                self.width, self.height
+            )))
+        }
+    }
+
+    /// Builds the pattern stream. The pattern must pass
+    /// [`TilingPattern::check`].
+    ///
+    /// The pattern is colored (/PaintType 1), so it is selected in the
+    /// Pattern color space without a color of its own, which is the form
+    /// viewers support most consistently.
+    pub fn to_stream(&self) -> PdfStream {
+        let mut stream = PdfStream::new(self.content.build_balanced_string().into_bytes());
+        let dict = &mut stream.dictionary;
+        dict.set("Type", Object::Name(PdfName::new_unchecked("Pattern")));
+        dict.set("PatternType", Object::Integer(1));
+        dict.set("PaintType", Object::Integer(1));
+        dict.set("TilingType", Object::Integer(1));
+        let bbox = [0.0, 0.0, self.width, self.height];
+        dict.set("BBox", Object::Array(bbox.iter().map(|&v| Object::Real(v)).collect::<PdfArray>()));
+        dict.set("XStep", Object::Real(self.width));
+        dict.set("YStep", Object::Real(self.height));
+        dict.set("Resources", Object::Dictionary(PdfDictionary::new()));
+        stream
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tiling_pattern_stream() {
+        assert!(TilingPattern::new(0.0, 10.0, ContentBuilder::new()).check().is_err());
+        assert!(TilingPattern::new(10.0, f64::INFINITY, ContentBuilder::new()).check().is_err());
+
+        let hatch = ContentBuilder::new().move_to(0.0, 0.0).line_to(8.0, 8.0).stroke();
+        let pattern = TilingPattern::new(8.0, 8.0, hatch);
+        assert!(pattern.check().is_ok());
+        let stream = pattern.to_stream();
+        assert_eq!(
+            stream.dictionary.to_pdf_string(),
+            "<< /Length 13 /Type /Pattern /PatternType 1 /PaintType 1 /TilingType 1 /BBox [0 0 8 8] \
+             /XStep 8 /YStep 8 /Resources << >> >>"
+        );
+        assert_eq!(stream.data(), b"0 0 m\n8 8 l\nS");
+    }
+}
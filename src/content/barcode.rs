@@ -0,0 +1,208 @@
+//! Linear barcodes drawn as vector bars.
+
+use crate::error::ContentError;
+
+/// A barcode symbology.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Symbology {
+    /// Code 128, covering all 128 ASCII characters.
+    Code128,
+}
+
+/// An encoded barcode, stored as alternating bar and space widths.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Barcode {
+    symbology: Symbology,
+    widths: Vec<u8>,
+}
+
+impl Barcode {
+    /// Encodes `data` in the given symbology.
+    ///
+    /// Fails if `data` is empty or contains characters the symbology cannot encode.
+    pub fn new(symbology: Symbology, data: &str) -> Result<Self, ContentError> {
+        match symbology {
+            Symbology::Code128 => Self::code128(data),
+        }
+    }
+
+    /// Encodes `data` as Code 128, switching to code set C for runs of digits.
+    pub fn code128(data: &str) -> Result<Self, ContentError> {
+        let values = code128_values(data)?;
+        let checksum = values
+            .iter()
+            .enumerate()
+            .map(|(i, &value)| i.max(1) * value as usize)
+            .sum::<usize>()
+            % 103;
+
+        let mut widths = Vec::with_capacity((values.len() + 2) * 6 + 1);
+        for &value in values.iter().chain([checksum as u8, CODE128_STOP].iter()) {
+            widths.extend(CODE128_PATTERNS[value as usize].bytes().map(|b| b - b'0'));
+        }
+        Ok(Self {
+            symbology: Symbology::Code128,
+            widths,
+        })
+    }
+
+    /// Returns the symbology.
+    pub fn symbology(&self) -> Symbology {
+        self.symbology
+    }
+
+    /// Returns the bar and space widths in modules, starting with a bar.
+    pub fn widths(&self) -> &[u8] {
+        &self.widths
+    }
+
+    /// Returns the total width in modules, excluding quiet zones.
+    pub fn modules(&self) -> u32 {
+        self.widths.iter().map(|&w| w as u32).sum()
+    }
+}
+
+const CODE128_START_A: u8 = 103;
+const CODE128_START_B: u8 = 104;
+const CODE128_START_C: u8 = 105;
+const CODE128_CODE_A: u8 = 101;
+const CODE128_CODE_B: u8 = 100;
+const CODE128_CODE_C: u8 = 99;
+const CODE128_STOP: u8 = 106;
+
+#[derive(Clone, Copy, PartialEq)]
+enum CodeSet {
+    A,
+    B,
+    C,
+}
+
+/// Returns the symbol values for `data`, starting with the start code and
+/// excluding the checksum.
+fn code128_values(data: &str) -> Result<Vec<u8>, ContentError> {
+    if data.is_empty() {
+        return Err(ContentError::InvalidBarcodeData("data is empty".to_string()));
+    }
+    if let Some(c) = data.chars().find(|c| !c.is_ascii()) {
+        return Err(ContentError::InvalidBarcodeData(format!(
+            "{:?} cannot be encoded in Code 128",
+            c
+        )));
+    }
+
+    let bytes = data.as_bytes();
+    let digits_at = |i: usize| bytes[i..].iter().take_while(|b| b.is_ascii_digit()).count();
+    let text_set = |b: u8| if b < 32 { CodeSet::A } else { CodeSet::B };
+
+    // Code set C pays off for four or more digits at the ends, six in the middle.
+    let leading = digits_at(0);
+    let mut set = if leading == bytes.len() && leading % 2 == 0 || leading >= 4 {
+        CodeSet::C
+    } else {
+        text_set(bytes[0])
+    };
+    let mut values = vec![match set {
+        CodeSet::A => CODE128_START_A,
+        CodeSet::B => CODE128_START_B,
+        CodeSet::C => CODE128_START_C,
+    }];
+
+    let mut i = 0;
+    while i < bytes.len() {
+        let run = digits_at(i);
+        if set == CodeSet::C {
+            if run >= 2 {
+                values.push((bytes[i] - b'0') * 10 + (bytes[i + 1] - b'0'));
+                i += 2;
+                continue;
+            }
+            set = text_set(bytes[i]);
+            values.push(if set == CodeSet::A { CODE128_CODE_A } else { CODE128_CODE_B });
+        } else if run >= 4 && (i + run == bytes.len() || run >= 6) {
+            // Leave an odd digit in the current set so the rest pairs up.
+            if run % 2 == 1 {
+                values.push(set_value(set, bytes[i]));
+                i += 1;
+            }
+            set = CodeSet::C;
+            values.push(CODE128_CODE_C);
+            continue;
+        }
+
+        let b = bytes[i];
+        if set == CodeSet::A && b >= 96 {
+            set = CodeSet::B;
+            values.push(CODE128_CODE_B);
+        } else if set == CodeSet::B && b < 32 {
+            set = CodeSet::A;
+            values.push(CODE128_CODE_A);
+        }
+        values.push(set_value(set, b));
+        i += 1;
+    }
+    Ok(values)
+}
+
+/// Returns the value of an ASCII character in code set A or B.
+fn set_value(set: CodeSet, b: u8) -> u8 {
+    match set {
+        CodeSet::A if b < 32 => b + 64,
+        _ => b - 32,
+    }
+}
+
+/// Bar and space widths of each Code 128 symbol value, in modules.
+const CODE128_PATTERNS: [&str; 107] = [
+    "212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212",
+    "221213", "221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221",
+    "223211", "221132", "221231", "213212", "223112", "312131", "311222", "321122", "321221",
+    "312212", "322112", "322211", "212123", "212321", "232121", "111323", "131123", "131321",
+    "112313", "132113", "132311", "211313", "231113", "231311", "112133", "112331", "132131",
+    "113123", "113321", "133121", "313121", "211331", "231131", "213113", "213311", "213131",
+    "311123", "311321", "331121", "312113", "312311", "332111", "314111", "221411", "431111",
+    "111224", "111422", "121124", "121421", "141122", "141221", "112214", "112412", "122114",
+    "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111", "111242",
+    "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+    "214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311",
+    "113141", "114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+];
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_patterns_are_eleven_modules() {
+        for pattern in &CODE128_PATTERNS[..106] {
+            assert_eq!(pattern.bytes().map(|b| (b - b'0') as u32).sum::<u32>(), 11, "{}", pattern);
+        }
+        assert_eq!(CODE128_PATTERNS[106].len(), 7);
+    }
+
+    #[test]
+    fn test_code128_values() {
+        assert_eq!(code128_values("AB").unwrap(), vec![CODE128_START_B, 33, 34]);
+        assert_eq!(code128_values("123456").unwrap(), vec![CODE128_START_C, 12, 34, 56]);
+        // Code set C for the trailing digits, after one odd digit in set B.
+        assert_eq!(
+            code128_values("X12345").unwrap(),
+            vec![CODE128_START_B, 56, 17, CODE128_CODE_C, 23, 45]
+        );
+        assert_eq!(
+            code128_values("a\tb").unwrap(),
+            vec![CODE128_START_B, 65, CODE128_CODE_A, 73, CODE128_CODE_B, 66]
+        );
+        assert!(code128_values("").is_err());
+        assert!(code128_values("café").is_err());
+    }
+
+    #[test]
+    fn test_code128_checksum_and_stop() {
+        // Start B (104) + 'P' (48 * 1) + 'J' (42 * 2) = 236, and 236 % 103 = 30.
+        let barcode = Barcode::code128("PJ").unwrap();
+        let widths = barcode.widths();
+        assert_eq!(&widths[18..24], &[2, 1, 2, 1, 2, 3]);
+        assert_eq!(&widths[24..], &[2, 3, 3, 1, 1, 1, 2]);
+        assert_eq!(barcode.modules(), 4 * 11 + 13);
+    }
+}
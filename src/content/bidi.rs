@@ -0,0 +1,333 @@
+//! Right-to-left text: Arabic joining and bidirectional reordering.
+//!
+//! This covers what a single line of Arabic or Hebrew with embedded Latin
+//! words and numbers needs. It is not a full implementation of the Unicode
+//! Bidirectional Algorithm: there are no explicit embeddings or isolates,
+//! and the paragraph direction is always right-to-left.
+
+/// Shapes a line of right-to-left text and returns it in visual order, ready
+/// to be drawn from left to right.
+///
+/// Arabic letters are replaced by the initial, medial, final or isolated
+/// presentation form their neighbours call for, and lam followed by alef by a
+/// ligature, wherever `has_glyph` reports that the font has the form. Runs of
+/// left-to-right text and numbers keep their order within the line, and
+/// brackets in right-to-left runs are mirrored.
+pub fn shape_rtl(text: &str, has_glyph: impl Fn(char) -> bool) -> String {
+    let shaped = join_arabic(&text.chars().collect::<Vec<_>>(), &has_glyph);
+    reorder(&shaped)
+}
+
+/// How an Arabic character connects to its neighbours.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Joining {
+    /// Connects on both sides, with four forms.
+    Dual,
+    /// Connects only to the letter before it, with isolated and final forms.
+    Right,
+    /// Connects on both sides without changing shape, like tatweel.
+    Causing,
+    /// Skipped when looking for neighbours, like vowel marks.
+    Transparent,
+    /// Does not connect.
+    None,
+}
+
+/// Arabic letters with their isolated form in Arabic Presentation Forms-B.
+/// The final, initial and medial forms follow it in that order.
+const LETTERS: [(char, u32, Joining); 37] = [
+    ('\u{0621}', 0xFE80, Joining::None),
+    ('\u{0622}', 0xFE81, Joining::Right),
+    ('\u{0623}', 0xFE83, Joining::Right),
+    ('\u{0624}', 0xFE85, Joining::Right),
+    ('\u{0625}', 0xFE87, Joining::Right),
+    ('\u{0626}', 0xFE89, Joining::Dual),
+    ('\u{0627}', 0xFE8D, Joining::Right),
+    ('\u{0628}', 0xFE8F, Joining::Dual),
+    ('\u{0629}', 0xFE93, Joining::Right),
+    ('\u{062A}', 0xFE95, Joining::Dual),
+    ('\u{062B}', 0xFE99, Joining::Dual),
+    ('\u{062C}', 0xFE9D, Joining::Dual),
+    ('\u{062D}', 0xFEA1, Joining::Dual),
+    ('\u{062E}', 0xFEA5, Joining::Dual),
+    ('\u{062F}', 0xFEA9, Joining::Right),
+    ('\u{0630}', 0xFEAB, Joining::Right),
+    ('\u{0631}', 0xFEAD, Joining::Right),
+    ('\u{0632}', 0xFEAF, Joining::Right),
+    ('\u{0633}', 0xFEB1, Joining::Dual),
+    ('\u{0634}', 0xFEB5, Joining::Dual),
+    ('\u{0635}', 0xFEB9, Joining::Dual),
+    ('\u{0636}', 0xFEBD, Joining::Dual),
+    ('\u{0637}', 0xFEC1, Joining::Dual),
+    ('\u{0638}', 0xFEC5, Joining::Dual),
+    ('\u{0639}', 0xFEC9, Joining::Dual),
+    ('\u{063A}', 0xFECD, Joining::Dual),
+    ('\u{0641}', 0xFED1, Joining::Dual),
+    ('\u{0642}', 0xFED5, Joining::Dual),
+    ('\u{0643}', 0xFED9, Joining::Dual),
+    ('\u{0644}', 0xFEDD, Joining::Dual),
+    ('\u{0645}', 0xFEE1, Joining::Dual),
+    ('\u{0646}', 0xFEE5, Joining::Dual),
+    ('\u{0647}', 0xFEE9, Joining::Dual),
+    ('\u{0648}', 0xFEED, Joining::Right),
+    ('\u{0649}', 0xFEEF, Joining::Right),
+    ('\u{064A}', 0xFEF1, Joining::Dual),
+    ('\u{0640}', 0, Joining::Causing),
+];
+
+/// Alef variants that form a ligature with a preceding lam, with the isolated
+/// form of the ligature; the final form follows it.
+const LAM_ALEF: [(char, u32); 4] = [
+    ('\u{0622}', 0xFEF5),
+    ('\u{0623}', 0xFEF7),
+    ('\u{0625}', 0xFEF9),
+    ('\u{0627}', 0xFEFB),
+];
+
+const LAM: char = '\u{0644}';
+
+fn joining(c: char) -> Joining {
+    if let Some(&(_, _, joining)) = LETTERS.iter().find(|(letter, _, _)| *letter == c) {
+        return joining;
+    }
+    match c {
+        '\u{064B}'..='\u{065F}' | '\u{0670}' => Joining::Transparent,
+        _ => Joining::None,
+    }
+}
+
+/// Returns whether a character connects to the one after it in logical order.
+fn joins_forward(joining: Joining) -> bool {
+    matches!(joining, Joining::Dual | Joining::Causing)
+}
+
+/// Returns whether a character connects to the one before it in logical order.
+fn joins_backward(joining: Joining) -> bool {
+    matches!(joining, Joining::Dual | Joining::Right | Joining::Causing)
+}
+
+/// Replaces Arabic letters with their contextual presentation forms.
+fn join_arabic(chars: &[char], has_glyph: &impl Fn(char) -> bool) -> Vec<char> {
+    let neighbour = |indices: &mut dyn Iterator<Item = usize>| {
+        indices
+            .map(|i| joining(chars[i]))
+            .find(|&j| j != Joining::Transparent)
+            .unwrap_or(Joining::None)
+    };
+    let form = |base: u32, offset: u32| char::from_u32(base + offset).filter(|&c| has_glyph(c));
+
+    let mut shaped = Vec::with_capacity(chars.len());
+    let mut i = 0;
+    while i < chars.len() {
+        let c = chars[i];
+        let before = neighbour(&mut (0..i).rev());
+        let joins_before = joins_forward(before) && joins_backward(joining(c));
+
+        // Lam followed by alef, possibly with vowel marks between, is one glyph
+        if c == LAM {
+            let next = (i + 1..chars.len()).find(|&n| joining(chars[n]) != Joining::Transparent);
+            let ligature = next.and_then(|n| {
+                let (_, base) = LAM_ALEF.iter().find(|(alef, _)| *alef == chars[n])?;
+                form(*base, joins_before as u32).map(|glyph| (n, glyph))
+            });
+            if let Some((alef, glyph)) = ligature {
+                shaped.push(glyph);
+                shaped.extend(chars[i + 1..alef].iter().copied());
+                i = alef + 1;
+                continue;
+            }
+        }
+
+        let after = neighbour(&mut (i + 1..chars.len()));
+        let joins_after = joins_forward(joining(c)) && joins_backward(after);
+        let glyph = LETTERS
+            .iter()
+            .find(|(letter, _, _)| *letter == c)
+            .and_then(|&(_, base, joining)| {
+                let offset = match (joining, joins_before, joins_after) {
+                    (Joining::Causing, _, _) => return None,
+                    (_, false, false) => 0,
+                    (_, true, false) => 1,
+                    (Joining::Dual, false, true) => 2,
+                    (Joining::Dual, true, true) => 3,
+                    // A right-joining letter never connects to the letter after it
+                    (_, joined, true) => joined as u32,
+                };
+                form(base, offset)
+            });
+        shaped.push(glyph.unwrap_or(c));
+        i += 1;
+    }
+    shaped
+}
+
+/// Bidirectional character classes, simplified.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Class {
+    /// Strong left-to-right, such as Latin letters.
+    Left,
+    /// Strong right-to-left: Hebrew and Arabic letters.
+    Right,
+    /// Western and extended Arabic-Indic digits.
+    European,
+    /// Arabic-Indic digits.
+    Arabic,
+    /// Separators between digits, such as the decimal point.
+    Separator,
+    /// Marks that take the class of the character before them.
+    Mark,
+    /// Spaces and punctuation.
+    Neutral,
+}
+
+fn class(c: char) -> Class {
+    match c {
+        '0'..='9' | '\u{06F0}'..='\u{06F9}' => Class::European,
+        '\u{0660}'..='\u{0669}' => Class::Arabic,
+        '.' | ',' | ':' | '/' | '\u{066B}' | '\u{066C}' => Class::Separator,
+        '\u{0591}'..='\u{05BD}' | '\u{05BF}' | '\u{05C1}' | '\u{05C2}' | '\u{05C4}' | '\u{05C5}' | '\u{05C7}' => {
+            Class::Mark
+        }
+        '\u{064B}'..='\u{065F}' | '\u{0670}' | '\u{06D6}'..='\u{06ED}' => Class::Mark,
+        '\u{0590}'..='\u{08FF}' | '\u{FB1D}'..='\u{FDFF}' | '\u{FE70}'..='\u{FEFF}' => Class::Right,
+        c if c.is_alphabetic() => Class::Left,
+        _ => Class::Neutral,
+    }
+}
+
+/// Returns the characters in visual order for a right-to-left paragraph.
+fn reorder(chars: &[char]) -> String {
+    let mut classes: Vec<Class> = chars.iter().map(|&c| class(c)).collect();
+
+    // Marks follow the character they belong to; a separator between two
+    // digits of the same kind joins the number
+    for i in 0..classes.len() {
+        if classes[i] == Class::Mark {
+            classes[i] = if i == 0 { Class::Right } else { classes[i - 1] };
+        }
+    }
+    for i in 1..classes.len().saturating_sub(1) {
+        let digits = classes[i - 1];
+        if classes[i] == Class::Separator
+            && matches!(digits, Class::European | Class::Arabic)
+            && classes[i + 1] == digits
+        {
+            classes[i] = digits;
+        }
+    }
+
+    // Numbers count as right-to-left when deciding the direction of the
+    // neutral characters around them
+    let strong = |class: Class| match class {
+        Class::Left => Some(false),
+        Class::Right | Class::European | Class::Arabic => Some(true),
+        _ => None,
+    };
+    let mut levels = vec![1u8; chars.len()];
+    let mut i = 0;
+    while i < chars.len() {
+        match classes[i] {
+            Class::Left | Class::European | Class::Arabic => {
+                levels[i] = 2;
+                i += 1;
+            }
+            Class::Right => i += 1,
+            _ => {
+                let end = (i..chars.len())
+                    .find(|&j| strong(classes[j]).is_some())
+                    .unwrap_or(chars.len());
+                let before = (0..i).rev().find_map(|j| strong(classes[j])).unwrap_or(true);
+                let after = (end..chars.len()).find_map(|j| strong(classes[j])).unwrap_or(true);
+                let left = !before && !after;
+                levels[i..end]
+                    .iter_mut()
+                    .for_each(|level| *level = if left { 2 } else { 1 });
+                i = end;
+            }
+        }
+    }
+
+    // Reverse the left-to-right runs, then the whole line
+    let mut visual: Vec<char> = chars
+        .iter()
+        .zip(&levels)
+        .map(|(&c, &level)| if level == 1 { mirror(c) } else { c })
+        .collect();
+    let mut start = 0;
+    while start < visual.len() {
+        if levels[start] < 2 {
+            start += 1;
+            continue;
+        }
+        let end = (start..visual.len()).find(|&j| levels[j] < 2).unwrap_or(visual.len());
+        visual[start..end].reverse();
+        start = end;
+    }
+    visual.reverse();
+    visual.into_iter().collect()
+}
+
+/// Returns the mirrored form of a bracket drawn right to left.
+fn mirror(c: char) -> char {
+    match c {
+        '(' => ')',
+        ')' => '(',
+        '[' => ']',
+        ']' => '[',
+        '{' => '}',
+        '}' => '{',
+        '<' => '>',
+        '>' => '<',
+        '\u{00AB}' => '\u{00BB}',
+        '\u{00BB}' => '\u{00AB}',
+        c => c,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn codes(text: &str) -> Vec<u32> {
+        text.chars().map(|c| c as u32).collect()
+    }
+
+    #[test]
+    fn test_arabic_joining() {
+        // Beh, teh, alef: initial beh, medial teh, final alef, drawn leftwards
+        assert_eq!(
+            codes(&shape_rtl("\u{0628}\u{062A}\u{0627}", |_| true)),
+            [0xFE8E, 0xFE98, 0xFE91]
+        );
+        // Dal does not connect forwards, so the beh after it is isolated
+        assert_eq!(codes(&shape_rtl("\u{062F}\u{0628}", |_| true)), [0xFE8F, 0xFEA9]);
+        // A vowel mark between letters does not break the connection
+        assert_eq!(
+            codes(&shape_rtl("\u{0628}\u{064E}\u{0628}", |_| true)),
+            [0xFE90, 0x064E, 0xFE91]
+        );
+        // Lam-alef ligature, in its final form after a connecting letter
+        assert_eq!(codes(&shape_rtl("\u{0644}\u{0627}", |_| true)), [0xFEFB]);
+        assert_eq!(
+            codes(&shape_rtl("\u{0628}\u{0644}\u{0627}", |_| true)),
+            [0xFEFC, 0xFE91]
+        );
+        // Forms the font lacks fall back to the base letter
+        assert_eq!(
+            codes(&shape_rtl("\u{0628}\u{062A}", |c| c != '\u{FE91}')),
+            [0xFE96, 0x0628]
+        );
+    }
+
+    #[test]
+    fn test_reorder() {
+        // Hebrew is reversed, a Latin word and a number keep their order
+        assert_eq!(
+            shape_rtl("\u{05D0}\u{05D1} abc 12.5", |_| true),
+            "12.5 abc \u{05D1}\u{05D0}"
+        );
+        assert_eq!(shape_rtl("\u{05D0} (\u{05D1})", |_| true), "(\u{05D1}) \u{05D0}");
+        assert_eq!(shape_rtl("one two", |_| true), "one two");
+        assert_eq!(shape_rtl("", |_| true), "");
+    }
+}
@@ -235,15 +235,20 @@ impl GraphicsBuilder {
 
     /// Draws a circle (approximated with Bezier curves).
     pub fn circle(self, cx: f64, cy: f64, r: f64) -> Self {
-        // Magic number for approximating a circle with cubic Bezier curves
+        self.ellipse(cx, cy, r, r)
+    }
+
+    /// Draws an axis-aligned ellipse as four Bezier arcs, one per quadrant.
+    pub fn ellipse(self, cx: f64, cy: f64, rx: f64, ry: f64) -> Self {
+        // Magic number for approximating a quarter circle with a cubic Bezier curve
         let k = 0.5522847498;
-        let kr = k * r;
+        let (kx, ky) = (k * rx, k * ry);
 
-        self.move_to(cx + r, cy)
-            .curve_to(cx + r, cy + kr, cx + kr, cy + r, cx, cy + r)
-            .curve_to(cx - kr, cy + r, cx - r, cy + kr, cx - r, cy)
-            .curve_to(cx - r, cy - kr, cx - kr, cy - r, cx, cy - r)
-            .curve_to(cx + kr, cy - r, cx + r, cy - kr, cx + r, cy)
+        self.move_to(cx + rx, cy)
+            .curve_to(cx + rx, cy + ky, cx + kx, cy + ry, cx, cy + ry)
+            .curve_to(cx - kx, cy + ry, cx - rx, cy + ky, cx - rx, cy)
+            .curve_to(cx - rx, cy - ky, cx - kx, cy - ry, cx, cy - ry)
+            .curve_to(cx + kx, cy - ry, cx + rx, cy - ky, cx + rx, cy)
             .close_path()
     }
 
@@ -340,4 +345,15 @@ mod tests {
         // move, 4 curves, close, fill
         assert_eq!(ops.len(), 7);
     }
+
+    #[test]
+    fn test_ellipse() {
+        let ops = GraphicsBuilder::new().ellipse(100.0, 50.0, 40.0, 20.0).build();
+        let (kx, ky) = (0.5522847498 * 40.0, 0.5522847498 * 20.0);
+
+        assert_eq!(ops[0], Operator::MoveTo(140.0, 50.0));
+        assert_eq!(ops[1], Operator::CurveTo(140.0, 50.0 + ky, 100.0 + kx, 70.0, 100.0, 70.0));
+        assert_eq!(ops[4], Operator::CurveTo(100.0 + kx, 30.0, 140.0, 50.0 - ky, 140.0, 50.0));
+        assert_eq!(ops[5], Operator::ClosePath);
+    }
 }
@@ -0,0 +1,78 @@
+//! Line breaking for text laid out in a fixed-width box.
+
+/// Breaks text into lines no wider than `max_width`.
+///
+/// Lines break at spaces and at explicit newlines; runs of spaces collapse to
+/// one. A word wider than `max_width` is split between characters. `measure`
+/// returns the width of a string in the same units as `max_width`.
+pub fn wrap_text(text: &str, max_width: f64, measure: impl Fn(&str) -> f64) -> Vec<String> {
+    let mut lines = Vec::new();
+
+    for paragraph in text.lines() {
+        let mut line = String::new();
+        for word in paragraph.split(' ').filter(|w| !w.is_empty()) {
+            let candidate = if line.is_empty() {
+                word.to_string()
+            } else {
+                format!("{} {}", line, word)
+            };
+            if measure(&candidate) <= max_width {
+                line = candidate;
+                continue;
+            }
+
+            if !line.is_empty() {
+                lines.push(std::mem::take(&mut line));
+            }
+            if measure(word) <= max_width {
+                line = word.to_string();
+                continue;
+            }
+
+            // The word alone is too wide: fill each line with as many characters as fit.
+            for c in word.chars() {
+                line.push(c);
+                if measure(&line) > max_width && line.chars().count() > 1 {
+                    line.pop();
+                    lines.push(std::mem::take(&mut line));
+                    line.push(c);
+                }
+            }
+        }
+        lines.push(line);
+    }
+
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn chars(s: &str) -> f64 {
+        s.chars().count() as f64
+    }
+
+    #[test]
+    fn test_wrap_at_spaces() {
+        let lines = wrap_text("the quick brown fox jumps", 10.0, chars);
+        assert_eq!(lines, vec!["the quick", "brown fox", "jumps"]);
+    }
+
+    #[test]
+    fn test_wrap_respects_newlines() {
+        let lines = wrap_text("one\n\ntwo  three\n", 20.0, chars);
+        assert_eq!(lines, vec!["one", "", "two three"]);
+    }
+
+    #[test]
+    fn test_wrap_splits_long_words() {
+        let lines = wrap_text("a abcdefghij", 4.0, chars);
+        assert_eq!(lines, vec!["a", "abcd", "efgh", "ij"]);
+    }
+
+    #[test]
+    fn test_wrap_empty_text() {
+        assert!(wrap_text("", 10.0, chars).is_empty());
+    }
+}
@@ -0,0 +1,188 @@
+//! Printer's marks for print production.
+//!
+//! Marks are drawn in the slug, the space between the bleed box and the
+//! media box, so that they are cut away with the rest of the sheet and do
+//! not appear on the finished page.
+
+use super::graphics::GraphicsBuilder;
+use crate::color::Color;
+use crate::types::Rectangle;
+
+/// Space between the bleed box and the marks, in points.
+const MARK_OFFSET: f64 = 3.0;
+/// Length of crop mark lines, in points.
+const CROP_MARK_LENGTH: f64 = 18.0;
+/// Width of the lines of crop marks and registration targets, in points.
+const MARK_LINE_WIDTH: f64 = 0.25;
+/// Largest radius of a registration target, in points.
+const TARGET_RADIUS: f64 = 6.0;
+/// Largest size of a color bar patch, in points.
+const PATCH_SIZE: f64 = 10.0;
+
+/// The printer's marks drawn by [`ContentBuilder::printer_marks`].
+///
+/// [`ContentBuilder::printer_marks`]: super::ContentBuilder::printer_marks
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct PrinterMarks {
+    /// Lines at the corners of the trim box showing where to cut.
+    pub crop: bool,
+    /// Targets at the middle of each side for lining up the color plates.
+    pub registration: bool,
+    /// Patches of the process colors and gray tints for checking the press.
+    pub color_bar: bool,
+}
+
+impl PrinterMarks {
+    /// Returns every kind of mark.
+    pub fn all() -> Self {
+        Self {
+            crop: true,
+            registration: true,
+            color_bar: true,
+        }
+    }
+}
+
+/// The width of the slug on each side, left, bottom, right and top, that is
+/// left for marks outside `bleed`.
+fn slug(bleed: &Rectangle, media: &Rectangle) -> [f64; 4] {
+    [
+        bleed.llx - media.llx - MARK_OFFSET,
+        bleed.lly - media.lly - MARK_OFFSET,
+        media.urx - bleed.urx - MARK_OFFSET,
+        media.ury - bleed.ury - MARK_OFFSET,
+    ]
+}
+
+/// Draws `marks` for a page trimmed to `trim`, outside `bleed` and within
+/// `media`. Marks are shrunk to the space there is, and left out of a side
+/// with no space.
+pub(super) fn printer_marks(marks: PrinterMarks, trim: Rectangle, bleed: Rectangle, media: Rectangle) -> GraphicsBuilder {
+    // Content may bleed past the trim box, but never the other way round
+    let bleed = Rectangle::new(
+        bleed.llx.min(trim.llx),
+        bleed.lly.min(trim.lly),
+        bleed.urx.max(trim.urx),
+        bleed.ury.max(trim.ury),
+    );
+    let [left, bottom, right, top] = slug(&bleed, &media);
+    // Registration color, printed on every plate
+    let registration = Color::cmyk(1.0, 1.0, 1.0, 1.0);
+    let mut g = GraphicsBuilder::new()
+        .save_state()
+        .line_width(MARK_LINE_WIDTH)
+        .stroke_color(registration)
+        .fill_color(registration);
+
+    if marks.crop {
+        // Each side's marks run away from the page, starting past the bleed
+        for (space, direction, edge) in [(left, -1.0, bleed.llx), (right, 1.0, bleed.urx)] {
+            let length = space.min(CROP_MARK_LENGTH);
+            if length > 0.0 {
+                let start = edge + direction * MARK_OFFSET;
+                for y in [trim.lly, trim.ury] {
+                    g = g.line(start, y, start + direction * length, y);
+                }
+            }
+        }
+        for (space, direction, edge) in [(bottom, -1.0, bleed.lly), (top, 1.0, bleed.ury)] {
+            let length = space.min(CROP_MARK_LENGTH);
+            if length > 0.0 {
+                let start = edge + direction * MARK_OFFSET;
+                for x in [trim.llx, trim.urx] {
+                    g = g.line(x, start, x, start + direction * length);
+                }
+            }
+        }
+    }
+
+    if marks.registration {
+        let (mid_x, mid_y) = ((trim.llx + trim.urx) / 2.0, (trim.lly + trim.ury) / 2.0);
+        let targets = [
+            (left, -1.0, 0.0, bleed.llx, mid_y),
+            (bottom, 0.0, -1.0, mid_x, bleed.lly),
+            (right, 1.0, 0.0, bleed.urx, mid_y),
+            (top, 0.0, 1.0, mid_x, bleed.ury),
+        ];
+        for (space, dx, dy, x, y) in targets {
+            let radius = (space / 2.0).min(TARGET_RADIUS);
+            if radius <= 0.0 {
+                continue;
+            }
+            let distance = MARK_OFFSET + radius;
+            let (cx, cy) = (x + dx * distance, y + dy * distance);
+            g = g
+                .stroked_circle(cx, cy, radius * 0.6)
+                .line(cx - radius, cy, cx + radius, cy)
+                .line(cx, cy - radius, cx, cy + radius)
+                .filled_circle(cx, cy, radius * 0.25);
+        }
+    }
+
+    if marks.color_bar {
+        let size = top.min(PATCH_SIZE);
+        // Along the top, clear of the crop mark at the left edge and short
+        // of the registration target in the middle
+        let start = trim.llx + 2.0 * MARK_OFFSET;
+        let room = (trim.llx + trim.urx) / 2.0 - TARGET_RADIUS - MARK_OFFSET - start;
+        if size > 0.0 && room > 0.0 {
+            let patches = [
+                Color::cmyk(1.0, 0.0, 0.0, 0.0),
+                Color::cmyk(0.0, 1.0, 0.0, 0.0),
+                Color::cmyk(0.0, 0.0, 1.0, 0.0),
+                Color::cmyk(0.0, 0.0, 0.0, 1.0),
+                Color::cmyk(0.0, 1.0, 1.0, 0.0),
+                Color::cmyk(1.0, 0.0, 1.0, 0.0),
+                Color::cmyk(1.0, 1.0, 0.0, 0.0),
+                Color::cmyk(0.0, 0.0, 0.0, 0.75),
+                Color::cmyk(0.0, 0.0, 0.0, 0.5),
+                Color::cmyk(0.0, 0.0, 0.0, 0.25),
+            ];
+            let y = bleed.ury + MARK_OFFSET;
+            let count = ((room / size) as usize).min(patches.len());
+            for (i, color) in patches.into_iter().take(count).enumerate() {
+                g = g.fill_color(color).filled_rect(start + i as f64 * size, y, size, size);
+            }
+        }
+    }
+
+    g.restore_state()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::Operator;
+
+    #[test]
+    fn test_printer_marks_stay_outside_bleed() {
+        let media = Rectangle::from_dimensions(650.0, 830.0);
+        let trim = Rectangle::new(19.0, 19.0, 631.0, 811.0);
+        let bleed = Rectangle::new(10.0, 10.0, 640.0, 820.0);
+        let ops = printer_marks(PrinterMarks::all(), trim, bleed, media).build();
+        let points: Vec<(f64, f64)> = ops
+            .iter()
+            .filter_map(|op| match op {
+                Operator::MoveTo(x, y) | Operator::LineTo(x, y) => Some((*x, *y)),
+                Operator::Rectangle(x, y, _, _) => Some((*x, *y)),
+                _ => None,
+            })
+            .collect();
+        assert!(!points.is_empty());
+        for (x, y) in points {
+            assert!(media.contains(&Rectangle::new(x, y, x, y)));
+            assert!(!(x > bleed.llx && x < bleed.urx && y > bleed.lly && y < bleed.ury), "({}, {})", x, y);
+        }
+        // A slug of 10 points leaves 7 for the crop marks past the offset
+        assert!(ops.contains(&Operator::MoveTo(7.0, 19.0)));
+        assert!(ops.contains(&Operator::LineTo(0.0, 19.0)));
+    }
+
+    #[test]
+    fn test_printer_marks_without_slug() {
+        let page = Rectangle::letter();
+        let ops = printer_marks(PrinterMarks::all(), page, page, page).build();
+        assert!(!ops.iter().any(|op| matches!(op, Operator::MoveTo(..) | Operator::Rectangle(..))));
+        assert_eq!(ops.last(), Some(&Operator::RestoreState));
+    }
+}
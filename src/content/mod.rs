@@ -1,17 +1,29 @@
 //! PDF Content Stream building.
 
+mod barcode;
+mod bidi;
 mod graphics;
+mod layout;
+mod marks;
+mod number;
 mod operator;
+mod qrcode;
 mod text;
 
+pub use barcode::{Barcode, Symbology};
+pub use bidi::shape_rtl;
 pub use graphics::GraphicsBuilder;
-pub use operator::{Operator, TextElement};
+pub use layout::wrap_text;
+pub use marks::PrinterMarks;
+pub use number::NumberFormat;
+pub use operator::{Operator, TextElement, DEFAULT_PRECISION};
+pub use qrcode::{QrCode, QrErrorCorrection};
 pub use text::{kern, text, TextBuilder};
 
 use crate::color::Color;
 use crate::error::PdfResult;
 use crate::object::PdfStream;
-use crate::types::Matrix;
+use crate::types::{Matrix, Rectangle};
 
 /// Builder for PDF content streams.
 ///
@@ -20,6 +32,7 @@ use crate::types::Matrix;
 pub struct ContentBuilder {
     operators: Vec<Operator>,
     state_depth: i32,
+    marked_depth: i32,
 }
 
 impl ContentBuilder {
@@ -28,6 +41,7 @@ impl ContentBuilder {
         Self {
             operators: Vec::new(),
             state_depth: 0,
+            marked_depth: 0,
         }
     }
 
@@ -101,6 +115,12 @@ impl ContentBuilder {
         self
     }
 
+    /// Applies the parameters of a named ExtGState resource, such as opacity.
+    pub fn ext_gstate(mut self, name: impl Into<String>) -> Self {
+        self.operators.push(Operator::SetExtGState(name.into()));
+        self
+    }
+
     // Color
 
     /// Sets the stroke color.
@@ -123,6 +143,14 @@ impl ContentBuilder {
         self
     }
 
+    /// Fills subsequent shapes and text with the pattern resource `name`,
+    /// such as a gradient added with `Page::add_pattern`.
+    pub fn fill_pattern(mut self, name: impl Into<String>) -> Self {
+        self.operators.push(Operator::SetFillColorSpace("Pattern".into()));
+        self.operators.push(Operator::SetFillPattern(name.into()));
+        self
+    }
+
     // Path construction
 
     /// Moves to a point.
@@ -218,6 +246,68 @@ impl ContentBuilder {
         self
     }
 
+    /// Fills the bars of a barcode in the current fill color, scaled to a
+    /// `width` by `height` box whose lower-left corner is (x, y).
+    ///
+    /// Scanners need blank space of about ten modules on each side.
+    pub fn barcode(mut self, barcode: &Barcode, x: f64, y: f64, width: f64, height: f64) -> Self {
+        let module = width / barcode.modules() as f64;
+        let mut offset = 0u32;
+        for (i, &w) in barcode.widths().iter().enumerate() {
+            if i % 2 == 0 {
+                self.operators.push(Operator::Rectangle(
+                    x + offset as f64 * module,
+                    y,
+                    w as f64 * module,
+                    height,
+                ));
+            }
+            offset += w as u32;
+        }
+        self.operators.push(Operator::Fill);
+        self
+    }
+
+    /// Draws printer's marks for a page whose finished size is `trim`, in the
+    /// space between `bleed` and `media` so they are cut away with the sheet.
+    /// Marks are shrunk to fit that space, and left off a side without any.
+    pub fn printer_marks(self, marks: PrinterMarks, trim: Rectangle, bleed: Rectangle, media: Rectangle) -> Self {
+        self.graphics(marks::printer_marks(marks, trim, bleed, media))
+    }
+
+    /// Fills the dark modules of a QR code in the current fill color, scaled
+    /// so the code and its quiet zone fill a `size` square whose lower-left
+    /// corner is (x, y).
+    ///
+    /// The quiet zone is left unpainted, so the background must be light.
+    pub fn qr_code(mut self, code: &QrCode, x: f64, y: f64, size: f64) -> Self {
+        let modules = code.size();
+        let module = size / (modules + 2 * QrCode::QUIET_ZONE) as f64;
+        for row in 0..modules {
+            let bottom = y + size - (QrCode::QUIET_ZONE + row + 1) as f64 * module;
+            let mut col = 0;
+            while col < modules {
+                if !code.is_dark(col, row) {
+                    col += 1;
+                    continue;
+                }
+                // One rectangle per horizontal run of dark modules.
+                let start = col;
+                while col < modules && code.is_dark(col, row) {
+                    col += 1;
+                }
+                self.operators.push(Operator::Rectangle(
+                    x + (QrCode::QUIET_ZONE + start) as f64 * module,
+                    bottom,
+                    (col - start) as f64 * module,
+                    module,
+                ));
+            }
+        }
+        self.operators.push(Operator::Fill);
+        self
+    }
+
     // XObject
 
     /// Paints an XObject.
@@ -252,6 +342,39 @@ impl ContentBuilder {
             .restore_state()
     }
 
+    // Marked content
+
+    /// Begins a marked-content sequence with tag `tag` (BMC operator), such
+    /// as `Artifact` for decorations outside the document structure.
+    pub fn begin_marked_content(mut self, tag: impl Into<String>) -> Self {
+        self.operators.push(Operator::BeginMarkedContent(tag.into()));
+        self.marked_depth += 1;
+        self
+    }
+
+    /// Begins the marked-content sequence `mcid` of a structure element
+    /// (BDC operator), tagged with the element's structure type.
+    pub fn begin_tagged_content(mut self, tag: impl Into<String>, mcid: u32) -> Self {
+        self.operators.push(Operator::BeginMarkedContentId(tag.into(), mcid));
+        self.marked_depth += 1;
+        self
+    }
+
+    /// Begins content that belongs to the layer under `name` in the page's
+    /// /Properties resources (`/OC /name BDC`).
+    pub fn begin_optional_content(mut self, name: impl Into<String>) -> Self {
+        self.operators.push(Operator::BeginMarkedContentProperties("OC".into(), name.into()));
+        self.marked_depth += 1;
+        self
+    }
+
+    /// Ends the innermost marked-content sequence (EMC operator).
+    pub fn end_marked_content(mut self) -> Self {
+        self.operators.push(Operator::EndMarkedContent);
+        self.marked_depth -= 1;
+        self
+    }
+
     // Raw operator
 
     /// Adds a raw operator string.
@@ -268,13 +391,40 @@ impl ContentBuilder {
 
     /// Builds the content stream as a string.
     pub fn build_string(&self) -> String {
+        self.build_string_with_precision(DEFAULT_PRECISION)
+    }
+
+    /// Builds the content stream as a string, with numeric operands rounded
+    /// as [`Operator::to_pdf_string_with_precision`] does.
+    pub fn build_string_with_precision(&self, decimals: usize) -> String {
         self.operators
             .iter()
-            .map(|op| op.to_pdf_string())
+            .map(|op| op.to_pdf_string_with_precision(decimals))
             .collect::<Vec<_>>()
             .join("\n")
     }
 
+    /// Builds the content stream as a string, ending any marked-content
+    /// sequences left open and restoring any graphics states that were saved
+    /// but not restored, so content after it is unaffected.
+    pub fn build_balanced_string(&self) -> String {
+        self.build_balanced_string_with_precision(DEFAULT_PRECISION)
+    }
+
+    /// Builds the content stream as [`ContentBuilder::build_balanced_string`]
+    /// does, with numeric operands rounded to `decimals` places.
+    pub fn build_balanced_string_with_precision(&self, decimals: usize) -> String {
+        let mut content = self.build_string_with_precision(decimals);
+        let closing = (0..self.marked_depth.max(0)).map(|_| "EMC").chain((0..self.state_depth.max(0)).map(|_| "Q"));
+        for operator in closing {
+            if !content.is_empty() {
+                content.push('\n');
+            }
+            content.push_str(operator);
+        }
+        content
+    }
+
     /// Builds the content stream as bytes.
     pub fn build_bytes(&self) -> Vec<u8> {
         self.build_string().into_bytes()
@@ -350,6 +500,34 @@ mod tests {
         assert_eq!(builder.state_depth(), 1);
     }
 
+    #[test]
+    fn test_build_balanced_string() {
+        let builder = ContentBuilder::new()
+            .save_state()
+            .rect(0.0, 0.0, 10.0, 10.0)
+            .clip()
+            .end_path()
+            .save_state();
+        assert_eq!(builder.build_balanced_string(), "q\n0 0 10 10 re\nW\nn\nq\nQ\nQ");
+
+        let builder = builder.restore_state().restore_state();
+        assert_eq!(builder.build_balanced_string(), builder.build_string());
+    }
+
+    #[test]
+    fn test_marked_content() {
+        let builder = ContentBuilder::new()
+            .begin_marked_content("Artifact")
+            .end_marked_content()
+            .save_state()
+            .begin_tagged_content("P", 3)
+            .begin_optional_content("OC1");
+        assert_eq!(
+            builder.build_balanced_string(),
+            "/Artifact BMC\nEMC\nq\n/P <</MCID 3>> BDC\n/OC /OC1 BDC\nEMC\nEMC\nQ"
+        );
+    }
+
     #[test]
     fn test_graphics_builder_integration() {
         let graphics = GraphicsBuilder::new()
@@ -365,6 +543,32 @@ mod tests {
         assert!(content.contains("100 100 re"));
     }
 
+    #[test]
+    fn test_barcode() {
+        let barcode = Barcode::code128("PJ").unwrap();
+        let content = ContentBuilder::new()
+            .barcode(&barcode, 10.0, 20.0, 57.0, 30.0)
+            .build_string();
+
+        // One point per module; Start B is 211214.
+        assert!(content.starts_with("10 20 2 30 re\n13 20 1 30 re\n16 20 1 30 re\n"));
+        assert!(content.ends_with("65 20 2 30 re\nf"));
+        assert_eq!(content.matches(" re").count(), 3 * 4 + 4);
+    }
+
+    #[test]
+    fn test_qr_code() {
+        let code = QrCode::encode("HELLO WORLD", QrErrorCorrection::Quartile).unwrap();
+        let content = ContentBuilder::new()
+            .qr_code(&code, 100.0, 200.0, 58.0)
+            .build_string();
+
+        // 21 modules plus two 4-module quiet zones at 2pt each; the top row
+        // starts with the 7-module finder pattern.
+        assert!(content.starts_with("108 248 14 2 re\n"));
+        assert!(content.ends_with("re\nf"));
+    }
+
     #[test]
     fn test_build_stream() {
         let stream = ContentBuilder::new()
@@ -0,0 +1,135 @@
+//! Number formatting for tables and reports.
+
+/// How [`NumberFormat::format`] writes numbers, such as `1,234.50`, `$1,234.50`
+/// or `(1.234,50 €)`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct NumberFormat {
+    decimals: usize,
+    thousands_separator: String,
+    decimal_separator: String,
+    parentheses: bool,
+    prefix: String,
+    suffix: String,
+}
+
+impl NumberFormat {
+    /// Creates a format with two decimals, `,` between thousands and `.` before
+    /// the decimals.
+    pub fn new() -> Self {
+        Self {
+            decimals: 2,
+            thousands_separator: ",".to_string(),
+            decimal_separator: ".".to_string(),
+            parentheses: false,
+            prefix: String::new(),
+            suffix: String::new(),
+        }
+    }
+
+    /// Sets the number of decimals the value is rounded to.
+    pub fn decimals(mut self, decimals: usize) -> Self {
+        self.decimals = decimals;
+        self
+    }
+
+    /// Sets the separator between groups of thousands; an empty one leaves the
+    /// digits ungrouped.
+    pub fn thousands_separator(mut self, separator: impl Into<String>) -> Self {
+        self.thousands_separator = separator.into();
+        self
+    }
+
+    /// Sets the separator before the decimals.
+    pub fn decimal_separator(mut self, separator: impl Into<String>) -> Self {
+        self.decimal_separator = separator.into();
+        self
+    }
+
+    /// Writes negative values in parentheses, as accountants do, instead of
+    /// with a minus sign.
+    pub fn parentheses(mut self, parentheses: bool) -> Self {
+        self.parentheses = parentheses;
+        self
+    }
+
+    /// Sets text written before the digits, such as a currency symbol. A minus
+    /// sign goes before it.
+    pub fn prefix(mut self, prefix: impl Into<String>) -> Self {
+        self.prefix = prefix.into();
+        self
+    }
+
+    /// Sets text written after the digits, such as `" €"`.
+    pub fn suffix(mut self, suffix: impl Into<String>) -> Self {
+        self.suffix = suffix.into();
+        self
+    }
+
+    /// Formats `value`. A value that rounds to zero is written without a
+    /// sign. Infinite and NaN values are written as Rust displays them.
+    pub fn format(&self, value: f64) -> String {
+        if !value.is_finite() {
+            return value.to_string();
+        }
+        let digits = format!("{:.*}", self.decimals, value.abs());
+        let (integer, fraction) = match digits.split_once('.') {
+            Some((integer, fraction)) => (integer, Some(fraction)),
+            None => (digits.as_str(), None),
+        };
+        let negative = value < 0.0 && digits.bytes().any(|b| matches!(b, b'1'..=b'9'));
+
+        let mut out = String::new();
+        if negative {
+            out.push(if self.parentheses { '(' } else { '-' });
+        }
+        out.push_str(&self.prefix);
+        for (i, digit) in integer.chars().enumerate() {
+            if i > 0 && (integer.len() - i) % 3 == 0 {
+                out.push_str(&self.thousands_separator);
+            }
+            out.push(digit);
+        }
+        if let Some(fraction) = fraction {
+            out.push_str(&self.decimal_separator);
+            out.push_str(fraction);
+        }
+        out.push_str(&self.suffix);
+        if negative && self.parentheses {
+            out.push(')');
+        }
+        out
+    }
+}
+
+impl Default for NumberFormat {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_number() {
+        let format = NumberFormat::new();
+        assert_eq!(format.format(1234567.891), "1,234,567.89");
+        assert_eq!(format.format(-999.999), "-1,000.00");
+        assert_eq!(format.format(12.0), "12.00");
+        // No sign on a negative value that rounds to zero
+        assert_eq!(format.format(-0.001), "0.00");
+
+        let format = NumberFormat::new().decimals(0).thousands_separator("").parentheses(true);
+        assert_eq!(format.format(-1234.6), "(1235)");
+        assert_eq!(format.format(f64::NAN), "NaN");
+    }
+
+    #[test]
+    fn test_format_currency() {
+        let dollars = NumberFormat::new().prefix("$").parentheses(true);
+        assert_eq!(dollars.format(-1234.5), "($1,234.50)");
+        let euros = NumberFormat::new().thousands_separator(".").decimal_separator(",").suffix(" €");
+        assert_eq!(euros.format(-1234.5), "-1.234,50 €");
+    }
+}
@@ -20,6 +20,8 @@ pub enum Operator {
     SetMiterLimit(f64),
     /// d - Set dash pattern
     SetDashPattern(Vec<f64>, f64),
+    /// gs - Set parameters from a graphics state resource
+    SetExtGState(String),
 
     // Color operators
     /// G - Set gray for stroking
@@ -34,6 +36,10 @@ pub enum Operator {
     SetCmykStroke(f64, f64, f64, f64),
     /// k - Set CMYK for filling
     SetCmykFill(f64, f64, f64, f64),
+    /// cs - Set color space for filling
+    SetFillColorSpace(String),
+    /// scn - Set pattern for filling, in the Pattern color space
+    SetFillPattern(String),
 
     // Path construction operators
     /// m - Move to
@@ -112,6 +118,8 @@ pub enum Operator {
     // Text showing operators
     /// Tj - Show text string
     ShowText(String),
+    /// Tj - Show pre-encoded bytes as a hex string (e.g. glyph IDs)
+    ShowHexText(Vec<u8>),
     /// TJ - Show text with positioning
     ShowTextPositioned(Vec<TextElement>),
     /// ' - Move to next line and show text
@@ -123,6 +131,16 @@ pub enum Operator {
     /// Do - Paint XObject
     PaintXObject(String),
 
+    // Marked content operators
+    /// BMC - Begin marked-content sequence
+    BeginMarkedContent(String),
+    /// BDC - Begin marked-content sequence with a marked-content identifier
+    BeginMarkedContentId(String, u32),
+    /// BDC - Begin marked-content sequence with a named property list resource
+    BeginMarkedContentProperties(String, String),
+    /// EMC - End marked-content sequence
+    EndMarkedContent,
+
     // Raw operator (for custom operators)
     Raw(String),
 }
@@ -132,13 +150,30 @@ pub enum Operator {
 pub enum TextElement {
     /// A text string.
     Text(String),
+    /// Pre-encoded bytes written as a hex string (e.g. glyph IDs).
+    Hex(Vec<u8>),
     /// A positioning adjustment (negative = move right).
     Position(f64),
 }
 
+/// Decimal places numeric operands are written with unless set otherwise.
+pub const DEFAULT_PRECISION: usize = 4;
+
 impl Operator {
     /// Converts the operator to its PDF string representation.
     pub fn to_pdf_string(&self) -> String {
+        self.to_pdf_string_with_precision(DEFAULT_PRECISION)
+    }
+
+    /// Converts the operator to its PDF string representation, rounding
+    /// coordinates, lengths and other numeric operands to `decimals` places.
+    ///
+    /// Color components and the scaling and rotation terms of matrices keep
+    /// at least [`DEFAULT_PRECISION`] places, since rounding them further
+    /// shifts colors and distorts rotated content.
+    pub fn to_pdf_string_with_precision(&self, decimals: usize) -> String {
+        let fmt = |v: &f64| format_number(*v, decimals);
+        let fine = |v: &f64| format_number(*v, decimals.max(DEFAULT_PRECISION));
         match self {
             // Graphics state
             Operator::SaveState => "q".into(),
@@ -146,10 +181,10 @@ impl Operator {
             Operator::ConcatMatrix(a, b, c, d, e, f) => {
                 format!(
                     "{} {} {} {} {} {} cm",
-                    fmt(a),
-                    fmt(b),
-                    fmt(c),
-                    fmt(d),
+                    fine(a),
+                    fine(b),
+                    fine(c),
+                    fine(d),
                     fmt(e),
                     fmt(f)
                 )
@@ -162,18 +197,21 @@ impl Operator {
                 let arr: Vec<String> = array.iter().map(fmt).collect();
                 format!("[{}] {} d", arr.join(" "), fmt(phase))
             }
+            Operator::SetExtGState(name) => format!("/{} gs", name),
 
             // Color
-            Operator::SetGrayStroke(g) => format!("{} G", fmt(g)),
-            Operator::SetGrayFill(g) => format!("{} g", fmt(g)),
-            Operator::SetRgbStroke(r, g, b) => format!("{} {} {} RG", fmt(r), fmt(g), fmt(b)),
-            Operator::SetRgbFill(r, g, b) => format!("{} {} {} rg", fmt(r), fmt(g), fmt(b)),
+            Operator::SetGrayStroke(g) => format!("{} G", fine(g)),
+            Operator::SetGrayFill(g) => format!("{} g", fine(g)),
+            Operator::SetRgbStroke(r, g, b) => format!("{} {} {} RG", fine(r), fine(g), fine(b)),
+            Operator::SetRgbFill(r, g, b) => format!("{} {} {} rg", fine(r), fine(g), fine(b)),
             Operator::SetCmykStroke(c, m, y, k) => {
-                format!("{} {} {} {} K", fmt(c), fmt(m), fmt(y), fmt(k))
+                format!("{} {} {} {} K", fine(c), fine(m), fine(y), fine(k))
             }
             Operator::SetCmykFill(c, m, y, k) => {
-                format!("{} {} {} {} k", fmt(c), fmt(m), fmt(y), fmt(k))
+                format!("{} {} {} {} k", fine(c), fine(m), fine(y), fine(k))
             }
+            Operator::SetFillColorSpace(name) => format!("/{} cs", name),
+            Operator::SetFillPattern(name) => format!("/{} scn", name),
 
             // Path construction
             Operator::MoveTo(x, y) => format!("{} {} m", fmt(x), fmt(y)),
@@ -234,10 +272,10 @@ impl Operator {
             Operator::SetTextMatrix(a, b, c, d, e, f) => {
                 format!(
                     "{} {} {} {} {} {} Tm",
-                    fmt(a),
-                    fmt(b),
-                    fmt(c),
-                    fmt(d),
+                    fine(a),
+                    fine(b),
+                    fine(c),
+                    fine(d),
                     fmt(e),
                     fmt(f)
                 )
@@ -246,11 +284,19 @@ impl Operator {
 
             // Text showing
             Operator::ShowText(s) => format!("({}) Tj", escape_string(s)),
+            Operator::ShowHexText(bytes) => {
+                let hex: String = bytes.iter().map(|b| format!("{:02X}", b)).collect();
+                format!("<{}> Tj", hex)
+            }
             Operator::ShowTextPositioned(elements) => {
                 let mut parts = Vec::new();
                 for elem in elements {
                     match elem {
                         TextElement::Text(s) => parts.push(format!("({})", escape_string(s))),
+                        TextElement::Hex(bytes) => {
+                            let hex: String = bytes.iter().map(|b| format!("{:02X}", b)).collect();
+                            parts.push(format!("<{}>", hex));
+                        }
                         TextElement::Position(p) => parts.push(fmt(p)),
                     }
                 }
@@ -264,21 +310,33 @@ impl Operator {
             // XObject
             Operator::PaintXObject(name) => format!("/{} Do", name),
 
+            // Marked content
+            Operator::BeginMarkedContent(tag) => format!("/{} BMC", tag),
+            Operator::BeginMarkedContentId(tag, mcid) => format!("/{} <</MCID {}>> BDC", tag, mcid),
+            Operator::BeginMarkedContentProperties(tag, name) => format!("/{} /{} BDC", tag, name),
+            Operator::EndMarkedContent => "EMC".into(),
+
             // Raw
             Operator::Raw(s) => s.clone(),
         }
     }
 }
 
-/// Formats a float for PDF output.
-fn fmt(v: &f64) -> String {
-    if *v == 0.0 {
+/// Formats a float for PDF output, rounded to `decimals` places and without
+/// trailing zeros.
+fn format_number(v: f64, decimals: usize) -> String {
+    if v == 0.0 {
         "0".into()
     } else if v.fract() == 0.0 && v.abs() < i64::MAX as f64 {
-        (*v as i64).to_string()
+        (v as i64).to_string()
     } else {
-        let s = format!("{:.4}", v);
-        s.trim_end_matches('0').trim_end_matches('.').to_string()
+        let s = format!("{:.*}", decimals, v);
+        let s = if s.contains('.') { s.trim_end_matches('0').trim_end_matches('.') } else { &s };
+        match s {
+            // Values that round to zero, such as cos(90°), may keep their sign
+            "-0" => "0".into(),
+            s => s.to_string(),
+        }
     }
 }
 
@@ -309,6 +367,11 @@ mod tests {
         assert_eq!(Operator::RestoreState.to_pdf_string(), "Q");
     }
 
+    #[test]
+    fn test_ext_gstate() {
+        assert_eq!(Operator::SetExtGState("GS1".to_string()).to_pdf_string(), "/GS1 gs");
+    }
+
     #[test]
     fn test_color_operators() {
         assert_eq!(Operator::SetGrayFill(0.5).to_pdf_string(), "0.5 g");
@@ -325,6 +388,28 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_matrix_rounding_drops_negative_zero() {
+        let (sin, cos) = 270f64.to_radians().sin_cos();
+        assert_eq!(
+            Operator::ConcatMatrix(cos, sin, -sin, cos, 0.0, 0.0).to_pdf_string(),
+            "0 -1 1 0 0 0 cm"
+        );
+    }
+
+    #[test]
+    fn test_precision() {
+        assert_eq!(Operator::MoveTo(72.00000000001, 10.126).to_pdf_string(), "72 10.126 m");
+        assert_eq!(Operator::MoveTo(10.125001, 19.6).to_pdf_string_with_precision(2), "10.13 19.6 m");
+        assert_eq!(Operator::LineTo(19.6, -0.004).to_pdf_string_with_precision(0), "20 0 l");
+        assert_eq!(Operator::SetRgbFill(0.33333, 0.5, 0.0).to_pdf_string_with_precision(1), "0.3333 0.5 0 rg");
+        let (sin, cos) = 30f64.to_radians().sin_cos();
+        assert_eq!(
+            Operator::ConcatMatrix(cos, sin, -sin, cos, 10.556, 0.0).to_pdf_string_with_precision(2),
+            "0.866 0.5 -0.5 0.866 10.56 0 cm"
+        );
+    }
+
     #[test]
     fn test_text_operators() {
         assert_eq!(Operator::BeginText.to_pdf_string(), "BT");
@@ -337,6 +422,10 @@ mod tests {
             Operator::ShowText("Hello".to_string()).to_pdf_string(),
             "(Hello) Tj"
         );
+        assert_eq!(
+            Operator::ShowHexText(vec![0x00, 0x01, 0x0A, 0xFF]).to_pdf_string(),
+            "<00010AFF> Tj"
+        );
     }
 
     #[test]
@@ -0,0 +1,648 @@
+//! QR codes drawn as vector modules.
+
+use crate::error::ContentError;
+
+/// Error correction level of a QR code, from least to most redundant.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum QrErrorCorrection {
+    /// Recovers about 7% of damaged modules.
+    Low,
+    /// Recovers about 15% of damaged modules.
+    Medium,
+    /// Recovers about 25% of damaged modules.
+    Quartile,
+    /// Recovers about 30% of damaged modules.
+    High,
+}
+
+impl QrErrorCorrection {
+    fn ordinal(self) -> usize {
+        match self {
+            QrErrorCorrection::Low => 0,
+            QrErrorCorrection::Medium => 1,
+            QrErrorCorrection::Quartile => 2,
+            QrErrorCorrection::High => 3,
+        }
+    }
+
+    fn format_bits(self) -> u32 {
+        match self {
+            QrErrorCorrection::Low => 1,
+            QrErrorCorrection::Medium => 0,
+            QrErrorCorrection::Quartile => 3,
+            QrErrorCorrection::High => 2,
+        }
+    }
+}
+
+/// An encoded QR code: a square grid of dark and light modules.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct QrCode {
+    version: u8,
+    size: usize,
+    modules: Vec<bool>,
+}
+
+impl QrCode {
+    /// Width in modules of the light border scanners need on every side.
+    pub const QUIET_ZONE: usize = 4;
+
+    /// Encodes `data` in the smallest QR code version that holds it.
+    ///
+    /// Digits and the QR alphanumeric set (`0-9 A-Z $%*+-./:` and space) are
+    /// packed densely; other text is encoded as UTF-8 bytes. Fails if the data
+    /// does not fit in version 40 at the given error correction level.
+    pub fn encode(data: &str, ecc: QrErrorCorrection) -> Result<Self, ContentError> {
+        let segment = Segment::new(data);
+        let version = (1..=40)
+            .find(|&v| {
+                segment
+                    .bit_len(v)
+                    .map_or(false, |bits| bits <= data_codewords(v, ecc) * 8)
+            })
+            .ok_or_else(|| {
+                ContentError::QrCodeCapacity(format!(
+                    "{} bytes exceed the capacity at {:?} error correction",
+                    data.len(),
+                    ecc
+                ))
+            })?;
+
+        let codewords = add_error_correction(&segment.codewords(version, ecc), version, ecc);
+        let mut grid = Grid::new(version);
+        grid.draw_function_patterns(version, ecc);
+        grid.draw_codewords(&codewords);
+
+        // Keep the mask with the lowest penalty; masking twice undoes it.
+        let mut best = (0, u32::MAX);
+        for mask in 0..8 {
+            grid.apply_mask(mask);
+            grid.draw_format_bits(ecc, mask);
+            let penalty = grid.penalty();
+            if penalty < best.1 {
+                best = (mask, penalty);
+            }
+            grid.apply_mask(mask);
+        }
+        grid.apply_mask(best.0);
+        grid.draw_format_bits(ecc, best.0);
+
+        Ok(Self {
+            version,
+            size: grid.size,
+            modules: grid.modules,
+        })
+    }
+
+    /// Returns the version, from 1 (21 x 21 modules) to 40 (177 x 177).
+    pub fn version(&self) -> u8 {
+        self.version
+    }
+
+    /// Returns the width and height in modules, excluding the quiet zone.
+    pub fn size(&self) -> usize {
+        self.size
+    }
+
+    /// Returns whether the module at column `x` and row `y` is dark, with
+    /// (0, 0) at the top left.
+    pub fn is_dark(&self, x: usize, y: usize) -> bool {
+        x < self.size && y < self.size && self.modules[y * self.size + x]
+    }
+}
+
+/// Characters of the alphanumeric mode, in value order.
+const ALPHANUMERIC: &[u8] = b"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:";
+
+#[derive(Clone, Copy)]
+enum Mode {
+    Numeric,
+    Alphanumeric,
+    Byte,
+}
+
+/// Data encoded in a single mode.
+struct Segment {
+    mode: Mode,
+    count: usize,
+    bits: Vec<bool>,
+}
+
+impl Segment {
+    fn new(data: &str) -> Self {
+        let bytes = data.as_bytes();
+        let mut bits = Vec::new();
+        if bytes.iter().all(u8::is_ascii_digit) {
+            for chunk in bytes.chunks(3) {
+                let value = chunk.iter().fold(0, |acc, b| acc * 10 + (b - b'0') as u32);
+                append_bits(&mut bits, value, chunk.len() * 3 + 1);
+            }
+            Self {
+                mode: Mode::Numeric,
+                count: bytes.len(),
+                bits,
+            }
+        } else if bytes.iter().all(|b| ALPHANUMERIC.contains(b)) {
+            let value = |b: &u8| ALPHANUMERIC.iter().position(|a| a == b).unwrap_or(0) as u32;
+            for chunk in bytes.chunks(2) {
+                match chunk {
+                    [a, b] => append_bits(&mut bits, value(a) * 45 + value(b), 11),
+                    [a] => append_bits(&mut bits, value(a), 6),
+                    _ => {}
+                }
+            }
+            Self {
+                mode: Mode::Alphanumeric,
+                count: bytes.len(),
+                bits,
+            }
+        } else {
+            for &b in bytes {
+                append_bits(&mut bits, b as u32, 8);
+            }
+            Self {
+                mode: Mode::Byte,
+                count: bytes.len(),
+                bits,
+            }
+        }
+    }
+
+    fn mode_bits(&self) -> u32 {
+        match self.mode {
+            Mode::Numeric => 0x1,
+            Mode::Alphanumeric => 0x2,
+            Mode::Byte => 0x4,
+        }
+    }
+
+    /// Returns the width of the character count field for a version.
+    fn count_bits(&self, version: u8) -> usize {
+        let index = (version as usize + 7) / 17;
+        match self.mode {
+            Mode::Numeric => [10, 12, 14][index],
+            Mode::Alphanumeric => [9, 11, 13][index],
+            Mode::Byte => [8, 16, 16][index],
+        }
+    }
+
+    /// Returns the encoded length in bits, or `None` if the character count
+    /// does not fit its field.
+    fn bit_len(&self, version: u8) -> Option<usize> {
+        let count_bits = self.count_bits(version);
+        (self.count < 1 << count_bits).then(|| 4 + count_bits + self.bits.len())
+    }
+
+    /// Returns the data codewords, with terminator and padding, for a version
+    /// that holds the segment.
+    fn codewords(&self, version: u8, ecc: QrErrorCorrection) -> Vec<u8> {
+        let capacity = data_codewords(version, ecc) * 8;
+        let mut bits = Vec::with_capacity(capacity);
+        append_bits(&mut bits, self.mode_bits(), 4);
+        append_bits(&mut bits, self.count as u32, self.count_bits(version));
+        bits.extend_from_slice(&self.bits);
+        let terminator = (capacity - bits.len()).min(4);
+        bits.resize(bits.len() + terminator, false);
+        bits.resize((bits.len() + 7) / 8 * 8, false);
+
+        let mut codewords: Vec<u8> = bits
+            .chunks(8)
+            .map(|byte| byte.iter().fold(0, |acc, &bit| acc << 1 | bit as u8))
+            .collect();
+        for pad in [0xEC, 0x11].iter().cycle() {
+            if codewords.len() * 8 >= capacity {
+                break;
+            }
+            codewords.push(*pad);
+        }
+        codewords
+    }
+}
+
+fn append_bits(bits: &mut Vec<bool>, value: u32, len: usize) {
+    bits.extend((0..len).rev().map(|i| (value >> i) & 1 != 0));
+}
+
+/// Returns the number of modules available for data and error correction.
+fn raw_data_modules(version: u8) -> usize {
+    let v = version as usize;
+    let mut result = (16 * v + 128) * v + 64;
+    if v >= 2 {
+        let alignments = v / 7 + 2;
+        result -= (25 * alignments - 10) * alignments - 55;
+        if v >= 7 {
+            result -= 36;
+        }
+    }
+    result
+}
+
+/// Returns the number of data codewords a version holds at a level.
+fn data_codewords(version: u8, ecc: QrErrorCorrection) -> usize {
+    let v = version as usize;
+    let e = ecc.ordinal();
+    raw_data_modules(version) / 8 - ECC_CODEWORDS_PER_BLOCK[e][v] as usize * ERROR_CORRECTION_BLOCKS[e][v] as usize
+}
+
+/// Splits data into blocks, appends Reed-Solomon codewords to each, and interleaves them.
+fn add_error_correction(data: &[u8], version: u8, ecc: QrErrorCorrection) -> Vec<u8> {
+    let v = version as usize;
+    let blocks = ERROR_CORRECTION_BLOCKS[ecc.ordinal()][v] as usize;
+    let ecc_len = ECC_CODEWORDS_PER_BLOCK[ecc.ordinal()][v] as usize;
+    let raw = raw_data_modules(version) / 8;
+    let short_blocks = blocks - raw % blocks;
+    let short_len = raw / blocks;
+    let divisor = reed_solomon_divisor(ecc_len);
+
+    let mut encoded = Vec::with_capacity(blocks);
+    let mut offset = 0;
+    for i in 0..blocks {
+        let len = short_len - ecc_len + usize::from(i >= short_blocks);
+        let block_data = &data[offset..offset + len];
+        offset += len;
+        let mut block = block_data.to_vec();
+        if i < short_blocks {
+            // Placeholder so every block has the same length while interleaving.
+            block.push(0);
+        }
+        block.extend(reed_solomon_remainder(block_data, &divisor));
+        encoded.push(block);
+    }
+
+    let mut result = Vec::with_capacity(raw);
+    for i in 0..encoded[0].len() {
+        for (j, block) in encoded.iter().enumerate() {
+            if i != short_len - ecc_len || j >= short_blocks {
+                result.push(block[i]);
+            }
+        }
+    }
+    result
+}
+
+fn reed_solomon_divisor(degree: usize) -> Vec<u8> {
+    let mut result = vec![0u8; degree];
+    result[degree - 1] = 1;
+    let mut root = 1u8;
+    for _ in 0..degree {
+        for j in 0..degree {
+            result[j] = gf_multiply(result[j], root);
+            if j + 1 < degree {
+                result[j] ^= result[j + 1];
+            }
+        }
+        root = gf_multiply(root, 0x02);
+    }
+    result
+}
+
+fn reed_solomon_remainder(data: &[u8], divisor: &[u8]) -> Vec<u8> {
+    let mut result = vec![0u8; divisor.len()];
+    for &b in data {
+        let factor = b ^ result.remove(0);
+        result.push(0);
+        for (x, &y) in result.iter_mut().zip(divisor) {
+            *x ^= gf_multiply(y, factor);
+        }
+    }
+    result
+}
+
+/// Multiplies in GF(2^8) with the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1.
+fn gf_multiply(x: u8, y: u8) -> u8 {
+    let mut z: u32 = 0;
+    for i in (0..8).rev() {
+        z = (z << 1) ^ ((z >> 7) * 0x11D);
+        z ^= ((y as u32 >> i) & 1) * x as u32;
+    }
+    z as u8
+}
+
+/// Modules being laid out, with a flag for those reserved for function patterns.
+struct Grid {
+    size: usize,
+    modules: Vec<bool>,
+    function: Vec<bool>,
+}
+
+impl Grid {
+    fn new(version: u8) -> Self {
+        let size = version as usize * 4 + 17;
+        Self {
+            size,
+            modules: vec![false; size * size],
+            function: vec![false; size * size],
+        }
+    }
+
+    fn get(&self, x: usize, y: usize) -> bool {
+        self.modules[y * self.size + x]
+    }
+
+    fn set_function(&mut self, x: usize, y: usize, dark: bool) {
+        self.modules[y * self.size + x] = dark;
+        self.function[y * self.size + x] = true;
+    }
+
+    fn draw_function_patterns(&mut self, version: u8, ecc: QrErrorCorrection) {
+        let size = self.size;
+        for i in 0..size {
+            self.set_function(6, i, i % 2 == 0);
+            self.set_function(i, 6, i % 2 == 0);
+        }
+
+        for (x, y) in [(3, 3), (size - 4, 3), (3, size - 4)] {
+            self.draw_finder(x, y);
+        }
+
+        let positions = alignment_positions(version);
+        let last = positions.len().saturating_sub(1);
+        for (i, &x) in positions.iter().enumerate() {
+            for (j, &y) in positions.iter().enumerate() {
+                // The corners overlap the finder patterns.
+                if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+                    continue;
+                }
+                for dy in -2i32..=2 {
+                    for dx in -2i32..=2 {
+                        let dark = dx.abs().max(dy.abs()) != 1;
+                        self.set_function((x as i32 + dx) as usize, (y as i32 + dy) as usize, dark);
+                    }
+                }
+            }
+        }
+
+        // Reserve the format areas; the real bits are drawn once the mask is chosen.
+        self.draw_format_bits(ecc, 0);
+
+        if version >= 7 {
+            let bits = version_bits(version);
+            for i in 0..18 {
+                let dark = (bits >> i) & 1 != 0;
+                let (a, b) = (size - 11 + i % 3, i / 3);
+                self.set_function(a, b, dark);
+                self.set_function(b, a, dark);
+            }
+        }
+    }
+
+    fn draw_finder(&mut self, x: usize, y: usize) {
+        for dy in -4i32..=4 {
+            for dx in -4i32..=4 {
+                let (xx, yy) = (x as i32 + dx, y as i32 + dy);
+                if (0..self.size as i32).contains(&xx) && (0..self.size as i32).contains(&yy) {
+                    let distance = dx.abs().max(dy.abs());
+                    self.set_function(xx as usize, yy as usize, distance != 2 && distance != 4);
+                }
+            }
+        }
+    }
+
+    fn draw_format_bits(&mut self, ecc: QrErrorCorrection, mask: u8) {
+        let bits = format_bits(ecc, mask);
+        let bit = |i: usize| (bits >> i) & 1 != 0;
+        let size = self.size;
+
+        for i in 0..=5 {
+            self.set_function(8, i, bit(i));
+        }
+        self.set_function(8, 7, bit(6));
+        self.set_function(8, 8, bit(7));
+        self.set_function(7, 8, bit(8));
+        for i in 9..15 {
+            self.set_function(14 - i, 8, bit(i));
+        }
+
+        for i in 0..8 {
+            self.set_function(size - 1 - i, 8, bit(i));
+        }
+        for i in 8..15 {
+            self.set_function(8, size - 15 + i, bit(i));
+        }
+        self.set_function(8, size - 8, true);
+    }
+
+    /// Places codewords in the zigzag order, two columns at a time from the right.
+    fn draw_codewords(&mut self, codewords: &[u8]) {
+        let size = self.size;
+        let total_bits = codewords.len() * 8;
+        let mut i = 0;
+        let mut right = size - 1;
+        loop {
+            if right == 6 {
+                right = 5;
+            }
+            for vert in 0..size {
+                for j in 0..2 {
+                    let x = right - j;
+                    let upward = (right + 1) & 2 == 0;
+                    let y = if upward { size - 1 - vert } else { vert };
+                    if !self.function[y * size + x] && i < total_bits {
+                        self.modules[y * size + x] = (codewords[i >> 3] >> (7 - (i & 7))) & 1 != 0;
+                        i += 1;
+                    }
+                }
+            }
+            if right < 2 {
+                break;
+            }
+            right -= 2;
+        }
+    }
+
+    fn apply_mask(&mut self, mask: u8) {
+        for y in 0..self.size {
+            for x in 0..self.size {
+                let invert = match mask {
+                    0 => (x + y) % 2 == 0,
+                    1 => y % 2 == 0,
+                    2 => x % 3 == 0,
+                    3 => (x + y) % 3 == 0,
+                    4 => (x / 3 + y / 2) % 2 == 0,
+                    5 => x * y % 2 + x * y % 3 == 0,
+                    6 => (x * y % 2 + x * y % 3) % 2 == 0,
+                    _ => ((x + y) % 2 + x * y % 3) % 2 == 0,
+                };
+                let index = y * self.size + x;
+                if invert && !self.function[index] {
+                    self.modules[index] = !self.modules[index];
+                }
+            }
+        }
+    }
+
+    /// Scores the symbol by the four penalty rules of ISO/IEC 18004; lower is
+    /// easier to scan.
+    fn penalty(&self) -> u32 {
+        let size = self.size;
+        let mut penalty = 0;
+        for i in 0..size {
+            let row: Vec<bool> = (0..size).map(|x| self.get(x, i)).collect();
+            let column: Vec<bool> = (0..size).map(|y| self.get(i, y)).collect();
+            penalty += line_penalty(&row) + line_penalty(&column);
+        }
+
+        for y in 0..size - 1 {
+            for x in 0..size - 1 {
+                let color = self.get(x, y);
+                if color == self.get(x + 1, y) && color == self.get(x, y + 1) && color == self.get(x + 1, y + 1) {
+                    penalty += 3;
+                }
+            }
+        }
+
+        let total = (size * size) as i64;
+        let dark = self.modules.iter().filter(|&&m| m).count() as i64;
+        let k = ((dark * 20 - total * 10).abs() + total - 1) / total - 1;
+        penalty + k as u32 * 10
+    }
+}
+
+/// Penalizes runs of five or more same-colored modules and finder-like
+/// patterns in one row or column.
+fn line_penalty(line: &[bool]) -> u32 {
+    const FINDER: [bool; 7] = [true, false, true, true, true, false, true];
+    let mut penalty = 0;
+
+    let mut run = 1;
+    for i in 1..=line.len() {
+        if i < line.len() && line[i] == line[i - 1] {
+            run += 1;
+        } else {
+            if run >= 5 {
+                penalty += run - 2;
+            }
+            run = 1;
+        }
+    }
+
+    // Modules outside the symbol count as light.
+    let light = |i: isize| i < 0 || i >= line.len() as isize || !line[i as usize];
+    for i in 0..=line.len() - FINDER.len() {
+        if line[i..i + FINDER.len()] == FINDER {
+            let start = i as isize;
+            let end = start + FINDER.len() as isize - 1;
+            if (1..=4).all(|k| light(start - k)) || (1..=4).all(|k| light(end + k)) {
+                penalty += 40;
+            }
+        }
+    }
+    penalty
+}
+
+/// Returns the center coordinates of the alignment patterns, ascending.
+fn alignment_positions(version: u8) -> Vec<usize> {
+    if version == 1 {
+        return Vec::new();
+    }
+    let v = version as usize;
+    let count = v / 7 + 2;
+    let step = if v == 32 {
+        26
+    } else {
+        (v * 4 + count * 2 + 1) / (count * 2 - 2) * 2
+    };
+    let size = v * 4 + 17;
+    let mut positions: Vec<usize> = (0..count - 1).map(|i| size - 7 - i * step).collect();
+    positions.push(6);
+    positions.reverse();
+    positions
+}
+
+/// Returns the 15 format bits, BCH-protected and masked.
+fn format_bits(ecc: QrErrorCorrection, mask: u8) -> u32 {
+    let data = ecc.format_bits() << 3 | mask as u32;
+    let mut rem = data;
+    for _ in 0..10 {
+        rem = (rem << 1) ^ ((rem >> 9) * 0x537);
+    }
+    (data << 10 | rem) ^ 0x5412
+}
+
+/// Returns the 18 version bits, BCH-protected.
+fn version_bits(version: u8) -> u32 {
+    let mut rem = version as u32;
+    for _ in 0..12 {
+        rem = (rem << 1) ^ ((rem >> 11) * 0x1F25);
+    }
+    (version as u32) << 12 | rem
+}
+
+/// Error correction codewords per block, by level and version.
+const ECC_CODEWORDS_PER_BLOCK: [[u8; 41]; 4] = [
+    [0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30],
+    [0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28],
+    [0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30],
+    [0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30],
+];
+
+/// Error correction blocks, by level and version.
+const ERROR_CORRECTION_BLOCKS: [[u8; 41]; 4] = [
+    [0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25],
+    [0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49],
+    [0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68],
+    [0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81],
+];
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_hello_world_codewords() {
+        // The worked example from the QR code specification tutorials: version 1-M.
+        let segment = Segment::new("HELLO WORLD");
+        let data = segment.codewords(1, QrErrorCorrection::Medium);
+        assert_eq!(data, [32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17]);
+
+        let all = add_error_correction(&data, 1, QrErrorCorrection::Medium);
+        assert_eq!(&all[16..], &[196, 35, 39, 119, 235, 215, 231, 226, 93, 23]);
+    }
+
+    #[test]
+    fn test_capacities() {
+        use QrErrorCorrection::*;
+        assert_eq!(data_codewords(1, Low), 19);
+        assert_eq!(data_codewords(1, High), 9);
+        assert_eq!(data_codewords(10, Medium), 216);
+        assert_eq!(data_codewords(40, Low), 2956);
+        assert_eq!(data_codewords(40, Quartile), 1666);
+        assert_eq!(data_codewords(40, High), 1276);
+    }
+
+    #[test]
+    fn test_format_and_version_bits() {
+        assert_eq!(format_bits(QrErrorCorrection::Low, 0), 0b111011111000100);
+        assert_eq!(format_bits(QrErrorCorrection::Medium, 0), 0b101010000010010);
+        assert_eq!(format_bits(QrErrorCorrection::High, 0), 0b001011010001001);
+        assert_eq!(version_bits(7), 0x07C94);
+        assert_eq!(alignment_positions(7), [6, 22, 38]);
+        assert_eq!(alignment_positions(32), [6, 34, 60, 86, 112, 138]);
+    }
+
+    #[test]
+    fn test_encode_chooses_version() {
+        let code = QrCode::encode("HELLO WORLD", QrErrorCorrection::Quartile).unwrap();
+        assert_eq!((code.version(), code.size()), (1, 21));
+
+        // Finder pattern corners and the dark module.
+        assert!(code.is_dark(0, 0) && code.is_dark(20, 0) && code.is_dark(0, 20));
+        assert!(!code.is_dark(7, 7));
+        assert!(code.is_dark(8, 13));
+
+        let url = QrCode::encode("https://example.com/orders/12345", QrErrorCorrection::Medium).unwrap();
+        assert_eq!(url.version(), 3);
+        assert!(url.size() == 29);
+    }
+
+    #[test]
+    fn test_encode_capacity_limit() {
+        let max = "a".repeat(2953);
+        assert_eq!(QrCode::encode(&max, QrErrorCorrection::Low).unwrap().version(), 40);
+        assert!(QrCode::encode(&format!("{}a", max), QrErrorCorrection::Low).is_err());
+        assert!(matches!(
+            QrCode::encode(&max, QrErrorCorrection::High),
+            Err(ContentError::QrCodeCapacity(_))
+        ));
+    }
+}
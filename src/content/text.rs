@@ -1,6 +1,7 @@
 //! Text content building.
 
 use super::operator::{Operator, TextElement};
+use crate::font::ShapedGlyph;
 
 /// Builder for text blocks within a content stream.
 ///
@@ -52,12 +53,43 @@ impl TextBuilder {
         self
     }
 
+    /// Shows pre-encoded text as a hex string.
+    ///
+    /// Used for embedded fonts, where `bytes` are big-endian glyph IDs
+    /// (see `TrueTypeFont::encode`).
+    pub fn show_hex(mut self, bytes: impl Into<Vec<u8>>) -> Self {
+        self.operators.push(Operator::ShowHexText(bytes.into()));
+        self
+    }
+
     /// Shows text with kerning/positioning adjustments.
     pub fn show_positioned(mut self, elements: Vec<TextElement>) -> Self {
         self.operators.push(Operator::ShowTextPositioned(elements));
         self
     }
 
+    /// Shows glyphs from [`TrueTypeFont::shape`](crate::font::TrueTypeFont::shape)
+    /// of a font with `units_per_em` design units per em, as a hex string
+    /// or, where they are kerned, a TJ array.
+    pub fn show_shaped(self, glyphs: &[ShapedGlyph], units_per_em: u16) -> Self {
+        let bytes = |glyphs: &[ShapedGlyph]| glyphs.iter().flat_map(|g| g.gid.to_be_bytes()).collect::<Vec<u8>>();
+        if glyphs.iter().all(|g| g.kerning == 0) {
+            return self.show_hex(bytes(glyphs));
+        }
+        let mut elements = Vec::new();
+        let mut start = 0;
+        for (i, glyph) in glyphs.iter().enumerate() {
+            if glyph.kerning != 0 && i + 1 < glyphs.len() {
+                elements.push(TextElement::Hex(bytes(&glyphs[start..=i])));
+                // TJ adjustments are thousandths of an em, subtracted from the advance
+                elements.push(TextElement::Position(-(glyph.kerning as f64) * 1000.0 / units_per_em as f64));
+                start = i + 1;
+            }
+        }
+        elements.push(TextElement::Hex(bytes(&glyphs[start..])));
+        self.show_positioned(elements)
+    }
+
     /// Moves to the next line (uses current leading).
     pub fn next_line(mut self) -> Self {
         self.operators.push(Operator::NextLine);
@@ -177,6 +209,16 @@ mod tests {
         assert!(ops.iter().any(|op| matches!(op, Operator::ShowTextPositioned(_))));
     }
 
+    #[test]
+    fn test_shaped_text() {
+        let glyph = |gid, kerning| ShapedGlyph { gid, kerning };
+        let ops = TextBuilder::new().show_shaped(&[glyph(1, 0), glyph(2, 0)], 1000).end();
+        assert_eq!(ops[1], Operator::ShowHexText(vec![0, 1, 0, 2]));
+
+        let ops = TextBuilder::new().show_shaped(&[glyph(1, -80), glyph(2, 0), glyph(3, 0)], 2048).end();
+        assert_eq!(ops[1].to_pdf_string(), "[<0001> 39.0625 <00020003>] TJ");
+    }
+
     #[test]
     fn test_has_font() {
         let builder = TextBuilder::new();
@@ -0,0 +1,182 @@
+//! Embedded file attachments.
+
+use crate::error::DocumentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
+use crate::types::ObjectId;
+
+/// How an attached file relates to the document (the /AFRelationship entry).
+///
+/// Associated files are how PDF/A-3 and invoice formats such as
+/// ZUGFeRD/Factur-X link machine-readable data to the visible pages.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AfRelationship {
+    /// The original source the document was created from.
+    Source,
+    /// Data the document's content is derived from, such as invoice XML.
+    Data,
+    /// An alternative representation of the document's content.
+    Alternative,
+    /// A supplement to the document's content.
+    Supplement,
+    /// A relationship that is not known or not listed here.
+    Unspecified,
+}
+
+impl AfRelationship {
+    /// Returns the PDF name of the relationship.
+    pub fn pdf_name(&self) -> &'static str {
+        match self {
+            AfRelationship::Source => "Source",
+            AfRelationship::Data => "Data",
+            AfRelationship::Alternative => "Alternative",
+            AfRelationship::Supplement => "Supplement",
+            AfRelationship::Unspecified => "Unspecified",
+        }
+    }
+}
+
+/// A file embedded in the document.
+#[derive(Debug, Clone, PartialEq)]
+pub struct FileAttachment {
+    /// File name shown in the viewer's attachment panel.
+    pub filename: String,
+    /// Contents of the file.
+    pub data: Vec<u8>,
+    /// MIME type, such as `text/xml`.
+    pub mime_type: Option<String>,
+    /// Description shown alongside the file name.
+    pub description: Option<String>,
+    /// Relationship to the document, which also lists the file in the
+    /// catalog's associated files.
+    pub relationship: Option<AfRelationship>,
+}
+
+impl FileAttachment {
+    /// Creates an attachment with the given file name and contents.
+    pub fn new(filename: impl Into<String>, data: impl Into<Vec<u8>>) -> Self {
+        Self {
+            filename: filename.into(),
+            data: data.into(),
+            mime_type: None,
+            description: None,
+            relationship: None,
+        }
+    }
+
+    /// Sets the MIME type.
+    pub fn mime_type(mut self, mime_type: impl Into<String>) -> Self {
+        self.mime_type = Some(mime_type.into());
+        self
+    }
+
+    /// Sets the description.
+    pub fn description(mut self, description: impl Into<String>) -> Self {
+        self.description = Some(description.into());
+        self
+    }
+
+    /// Sets the relationship to the document.
+    pub fn relationship(mut self, relationship: AfRelationship) -> Self {
+        self.relationship = Some(relationship);
+        self
+    }
+}
+
+/// Object IDs of an attachment.
+pub(super) struct AttachmentIds {
+    /// The file specification dictionary.
+    pub filespec: ObjectId,
+    /// The embedded file stream.
+    pub file: ObjectId,
+}
+
+/// Checks that `attachment` has a file name not used by `existing`.
+pub(super) fn check_attachment(
+    attachment: &FileAttachment,
+    existing: &[FileAttachment],
+) -> Result<(), DocumentError> {
+    if attachment.filename.is_empty() {
+        return Err(DocumentError::InvalidAttachment("file name is empty".to_string()));
+    }
+    if existing.iter().any(|a| a.filename == attachment.filename) {
+        let message = format!("a file named '{}' is already attached", attachment.filename);
+        return Err(DocumentError::InvalidAttachment(message));
+    }
+    Ok(())
+}
+
+/// Builds the file specification and embedded file stream of an attachment.
+pub(super) fn attachment_objects(attachment: &FileAttachment, ids: &AttachmentIds) -> (PdfDictionary, PdfStream) {
+    let mut params = PdfDictionary::new();
+    params.set("Size", Object::Integer(attachment.data.len() as i64));
+
+    let mut file = PdfDictionary::new();
+    file.set("Type", Object::Name(PdfName::new_unchecked("EmbeddedFile")));
+    if let Some(ref mime_type) = attachment.mime_type {
+        file.set("Subtype", Object::Name(PdfName::new_unchecked(mime_type.as_str())));
+    }
+    file.set("Params", Object::Dictionary(params));
+    let stream = PdfStream::with_dictionary(file, attachment.data.clone());
+
+    let mut ef = PdfDictionary::new();
+    ef.set("F", Object::Reference(ids.file));
+    ef.set("UF", Object::Reference(ids.file));
+
+    let mut filespec = PdfDictionary::new();
+    filespec.set("Type", Object::Name(PdfName::new_unchecked("Filespec")));
+    filespec.set("F", Object::String(PdfString::text(&attachment.filename)));
+    filespec.set("UF", Object::String(PdfString::text(&attachment.filename)));
+    filespec.set("EF", Object::Dictionary(ef));
+    if let Some(ref description) = attachment.description {
+        filespec.set("Desc", Object::String(PdfString::text(description)));
+    }
+    if let Some(relationship) = attachment.relationship {
+        filespec.set("AFRelationship", Object::Name(PdfName::new_unchecked(relationship.pdf_name())));
+    }
+    (filespec, stream)
+}
+
+/// Builds the /EmbeddedFiles name tree, whose keys must be sorted.
+pub(super) fn embedded_files_tree(attachments: &[FileAttachment], ids: &[AttachmentIds]) -> PdfDictionary {
+    let mut entries: Vec<(PdfString, ObjectId)> = attachments
+        .iter()
+        .zip(ids)
+        .map(|(attachment, ids)| (PdfString::text(&attachment.filename), ids.filespec))
+        .collect();
+    entries.sort_by(|a, b| a.0.as_bytes().cmp(b.0.as_bytes()));
+
+    let mut names = PdfArray::new();
+    for (key, id) in entries {
+        names.push(Object::String(key));
+        names.push(Object::Reference(id));
+    }
+    let mut tree = PdfDictionary::new();
+    tree.set("Names", Object::Array(names));
+    tree
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_attachment() {
+        let existing = [FileAttachment::new("factur-x.xml", b"<xml/>".to_vec())];
+        assert!(check_attachment(&FileAttachment::new("notes.txt", Vec::new()), &existing).is_ok());
+        assert!(check_attachment(&FileAttachment::new("factur-x.xml", Vec::new()), &existing).is_err());
+        assert!(check_attachment(&FileAttachment::new("", Vec::new()), &[]).is_err());
+    }
+
+    #[test]
+    fn test_embedded_files_tree_is_sorted() {
+        let attachments = [FileAttachment::new("b.txt", Vec::new()), FileAttachment::new("a.txt", Vec::new())];
+        let ids = [(1, 2), (3, 4)].map(|(filespec, file)| AttachmentIds {
+            filespec: ObjectId::new(filespec),
+            file: ObjectId::new(file),
+        });
+        let tree = embedded_files_tree(&attachments, &ids);
+        let names = tree.get("Names").and_then(|n| n.as_array()).unwrap();
+        assert_eq!(names.get(0), Some(&Object::String(PdfString::text("a.txt"))));
+        assert_eq!(names.get(1), Some(&Object::Reference(ObjectId::new(3))));
+    }
+}
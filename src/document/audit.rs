@@ -0,0 +1,248 @@
+//! Pre-flight checks of the resources a document depends on.
+
+#[cfg(feature = "parser")]
+use std::collections::HashSet;
+#[cfg(feature = "parser")]
+use std::sync::Arc;
+
+use super::pdfa::{helvetica_features, standard_fonts_used};
+use super::Document;
+#[cfg(feature = "parser")]
+use crate::object::{Object, PdfDictionary};
+#[cfg(feature = "parser")]
+use crate::page::ImportedObjects;
+
+/// Image filters that many printers and viewers cannot decode, with the
+/// names they are known by.
+#[cfg(feature = "parser")]
+const UNSUPPORTED_FILTERS: [(&str, &str); 2] = [("JPXDecode", "JPEG 2000"), ("CCITTFaxDecode", "CCITT fax")];
+
+/// Returns a description of every font `doc` shows text in without
+/// embedding it, every image compressed with a filter in
+/// [`UNSUPPORTED_FILTERS`], and every object that pages imported from other
+/// files refer to but that was missing from them.
+///
+/// Objects shared by pages imported from the same file are reported for the
+/// first page that uses them.
+pub(super) fn issues(doc: &Document) -> Vec<String> {
+    let mut issues: Vec<String> = helvetica_features(doc)
+        .into_iter()
+        .map(|feature| format!("{} use the standard Helvetica font, which is not embedded", feature))
+        .collect();
+    #[cfg(feature = "parser")]
+    let mut visited = HashSet::new();
+    for (i, page) in doc.pages.iter().enumerate() {
+        let content = page.content.build_string();
+        let tokens: Vec<&str> = content.split_whitespace().collect();
+        for font in standard_fonts_used(page, &tokens) {
+            issues.push(format!(
+                "Page {} uses the standard font {}, which is not embedded",
+                i + 1,
+                font.postscript_name()
+            ));
+        }
+
+        #[cfg(feature = "parser")]
+        for (_, imported) in &page.imported {
+            let objects = imported.objects();
+            let mut audit = ImportAudit {
+                objects,
+                key: Arc::as_ptr(objects) as usize,
+                visited: &mut visited,
+                page: i + 1,
+                issues: &mut issues,
+            };
+            audit.visit_index(imported.form());
+        }
+    }
+    issues
+}
+
+/// Walks the objects an imported page reaches, reporting each one once.
+#[cfg(feature = "parser")]
+struct ImportAudit<'a> {
+    objects: &'a ImportedObjects,
+    /// Tells the sets of objects apart in `visited`.
+    key: usize,
+    visited: &'a mut HashSet<(usize, usize)>,
+    page: usize,
+    issues: &'a mut Vec<String>,
+}
+
+#[cfg(feature = "parser")]
+impl ImportAudit<'_> {
+    fn visit_index(&mut self, index: usize) {
+        if !self.visited.insert((self.key, index)) {
+            return;
+        }
+        if let Some(id) = self.objects.missing(index) {
+            self.issues.push(format!(
+                "Page {} refers to object {} {} R, which is missing from the file it was imported from",
+                self.page, id.number, id.generation
+            ));
+        }
+        if let Some(object) = self.objects.get(index) {
+            self.visit(object);
+        }
+    }
+
+    fn visit(&mut self, object: &Object) {
+        match object {
+            Object::Reference(id) => self.visit_index(id.number as usize),
+            Object::Array(array) => array.iter().for_each(|o| self.visit(o)),
+            Object::Dictionary(dict) => self.visit_dictionary(dict),
+            Object::Stream(stream) => {
+                self.check_filters(&stream.dictionary);
+                self.visit_dictionary(&stream.dictionary);
+            }
+            _ => {}
+        }
+    }
+
+    fn visit_dictionary(&mut self, dict: &PdfDictionary) {
+        self.check_font(dict);
+        for (_, value) in dict.iter() {
+            self.visit(value);
+        }
+    }
+
+    /// Reports a font that is neither embedded nor a Type 0 or Type 3 font,
+    /// whose glyphs come from their descendant font and content streams.
+    fn check_font(&mut self, dict: &PdfDictionary) {
+        if !matches!(dict.get("Type"), Some(Object::Name(name)) if name.as_str() == "Font") {
+            return;
+        }
+        if matches!(dict.get("Subtype"), Some(Object::Name(name)) if ["Type0", "Type3"].contains(&name.as_str())) {
+            return;
+        }
+        let embedded = match dict.get("FontDescriptor").map(|d| self.resolve(d)) {
+            Some(Some(Object::Dictionary(descriptor))) => {
+                ["FontFile", "FontFile2", "FontFile3"].iter().any(|key| descriptor.contains_key(key))
+            }
+            _ => false,
+        };
+        if !embedded {
+            let name = match dict.get("BaseFont") {
+                Some(Object::Name(name)) => name.as_str().to_string(),
+                _ => "without a name".to_string(),
+            };
+            self.issues.push(format!("Page {} uses the font {}, which is not embedded", self.page, name));
+        }
+    }
+
+    /// Reports an image compressed with a filter in [`UNSUPPORTED_FILTERS`].
+    fn check_filters(&mut self, dict: &PdfDictionary) {
+        if !matches!(dict.get("Subtype"), Some(Object::Name(name)) if name.as_str() == "Image") {
+            return;
+        }
+        let filters: Vec<&Object> = match dict.get("Filter") {
+            Some(Object::Array(filters)) => filters.iter().collect(),
+            Some(filter) => vec![filter],
+            None => Vec::new(),
+        };
+        for filter in filters {
+            let name = match filter {
+                Object::Name(name) => name.as_str(),
+                _ => continue,
+            };
+            if let Some((filter, kind)) = UNSUPPORTED_FILTERS.iter().find(|(f, _)| *f == name) {
+                self.issues.push(format!(
+                    "Page {} has an image compressed with {} ({}), which not every printer can decode",
+                    self.page, filter, kind
+                ));
+            }
+        }
+    }
+
+    fn resolve<'b>(&'b self, object: &'b Object) -> Option<&'b Object> {
+        match object {
+            Object::Reference(id) => self.objects.get(id.number as usize),
+            other => Some(other),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::ContentBuilder;
+    use crate::font::Standard14Font;
+    use crate::page::PageBuilder;
+
+    #[test]
+    fn test_standard_fonts() {
+        let page = PageBuilder::a4()
+            .font("F1", Standard14Font::Helvetica)
+            .font("F2", Standard14Font::Courier)
+            .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, "Hello"))
+            .build();
+        let mut doc = Document::new();
+        doc.add_page(page);
+        assert_eq!(issues(&doc), ["Page 1 uses the standard font Helvetica, which is not embedded"]);
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_imported_resources() {
+        use crate::object::{PdfArray, PdfName, PdfStream};
+        use crate::page::{ImportedPage, Page};
+        use crate::types::{ObjectId, Rectangle};
+        use std::collections::BTreeMap;
+
+        let name = |n: &str| Object::Name(PdfName::new_unchecked(n));
+        let font = |base: &str, descriptor: Option<usize>| {
+            let mut dict = PdfDictionary::new();
+            dict.set("Type", name("Font"));
+            dict.set("Subtype", name("TrueType"));
+            dict.set("BaseFont", name(base));
+            if let Some(index) = descriptor {
+                dict.set("FontDescriptor", Object::Reference(ObjectId::new(index as u32)));
+            }
+            Object::Dictionary(dict)
+        };
+        let mut descriptor = PdfDictionary::new();
+        descriptor.set("FontFile2", Object::Reference(ObjectId::new(5)));
+        let mut image = PdfDictionary::new();
+        image.set("Subtype", name("Image"));
+        image.set("Filter", Object::Array(PdfArray::from_objects(vec![name("JPXDecode")])));
+
+        let mut fonts = PdfDictionary::new();
+        fonts.set("F1", Object::Reference(ObjectId::new(1)));
+        fonts.set("F2", Object::Reference(ObjectId::new(2)));
+        let mut xobjects = PdfDictionary::new();
+        xobjects.set("Im1", Object::Reference(ObjectId::new(4)));
+        let mut resources = PdfDictionary::new();
+        resources.set("Font", Object::Dictionary(fonts));
+        resources.set("XObject", Object::Dictionary(xobjects));
+        let mut form = PdfDictionary::new();
+        form.set("Resources", Object::Dictionary(resources));
+
+        let objects = Arc::new(ImportedObjects::new(
+            vec![
+                Object::Stream(PdfStream::with_dictionary(form, Vec::new())),
+                font("Arial", None),
+                font("Embedded", Some(3)),
+                Object::Dictionary(descriptor),
+                Object::Stream(PdfStream::with_dictionary(image, Vec::new())),
+                Object::Null,
+            ],
+            BTreeMap::from([(5, ObjectId::new(17))]),
+        ));
+        let mut doc = Document::new();
+        for _ in 0..2 {
+            let mut page = Page::a4();
+            let imported = ImportedPage::new(objects.clone(), 0, Rectangle::a4(), None);
+            page.add_imported_page("ImportedPage", imported);
+            doc.add_page(page);
+        }
+        // Objects the pages share are reported once
+        assert_eq!(
+            issues(&doc),
+            [
+                "Page 1 uses the font Arial, which is not embedded",
+                "Page 1 refers to object 17 0 R, which is missing from the file it was imported from",
+                "Page 1 has an image compressed with JPXDecode (JPEG 2000), which not every printer can decode",
+            ]
+        );
+    }
+}
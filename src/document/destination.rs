@@ -0,0 +1,102 @@
+//! Named destinations.
+
+use crate::error::DocumentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::types::ObjectId;
+
+/// A place in the document that links can refer to by name, so they keep
+/// working when pages are reordered.
+#[derive(Debug, Clone, PartialEq)]
+pub struct NamedDestination {
+    /// The name links use to refer to the destination.
+    pub name: String,
+    /// Zero-based index of the destination page.
+    pub page_index: usize,
+    /// Horizontal position scrolled to the left of the view, or `None` to
+    /// keep the viewer's current position.
+    pub x: Option<f64>,
+    /// Vertical position scrolled to the top of the view, or `None` to keep
+    /// the viewer's current position.
+    pub y: Option<f64>,
+}
+
+impl NamedDestination {
+    /// Creates a destination that opens `page_index`.
+    pub fn new(name: impl Into<String>, page_index: usize) -> Self {
+        Self {
+            name: name.into(),
+            page_index,
+            x: None,
+            y: None,
+        }
+    }
+
+    /// Scrolls the destination page so (`x`, `y`) is at the top left of the view.
+    pub fn at(mut self, x: f64, y: f64) -> Self {
+        self.x = Some(x);
+        self.y = Some(y);
+        self
+    }
+}
+
+/// Checks that `destination` can be stored in a document with `page_count` pages.
+pub(super) fn check_destination(destination: &NamedDestination, page_count: usize) -> Result<(), DocumentError> {
+    if destination.name.is_empty() {
+        return Err(DocumentError::InvalidDestination("name is empty".to_string()));
+    }
+    if destination.page_index >= page_count {
+        let message = format!(
+            "page {} of '{}' is out of range (page count is {})",
+            destination.page_index, destination.name, page_count
+        );
+        return Err(DocumentError::InvalidDestination(message));
+    }
+    Ok(())
+}
+
+/// Builds the /Dests name tree, whose keys must be sorted.
+///
+/// Every destination must pass [`check_destination`].
+pub(super) fn dests_tree(destinations: &[NamedDestination], page_ids: &[ObjectId]) -> PdfDictionary {
+    let mut entries: Vec<(PdfString, &NamedDestination)> =
+        destinations.iter().map(|d| (PdfString::text(&d.name), d)).collect();
+    entries.sort_by(|a, b| a.0.as_bytes().cmp(b.0.as_bytes()));
+
+    let mut names = PdfArray::new();
+    for (key, destination) in entries {
+        let mut dest = PdfArray::new();
+        dest.push(Object::Reference(page_ids[destination.page_index]));
+        dest.push(Object::Name(PdfName::new_unchecked("XYZ")));
+        dest.push(destination.x.map_or(Object::Null, Object::Real));
+        dest.push(destination.y.map_or(Object::Null, Object::Real));
+        dest.push(Object::Null);
+        names.push(Object::String(key));
+        names.push(Object::Array(dest));
+    }
+    let mut tree = PdfDictionary::new();
+    tree.set("Names", Object::Array(names));
+    tree
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_destination() {
+        assert!(check_destination(&NamedDestination::new("intro", 0), 1).is_ok());
+        assert!(check_destination(&NamedDestination::new("intro", 1), 1).is_err());
+        assert!(check_destination(&NamedDestination::new("", 0), 1).is_err());
+    }
+
+    #[test]
+    fn test_dests_tree_is_sorted() {
+        let destinations = [NamedDestination::new("summary", 1).at(72.0, 700.0), NamedDestination::new("intro", 0)];
+        let pages = [ObjectId::new(3), ObjectId::new(7)];
+        let tree = dests_tree(&destinations, &pages).to_pdf_string();
+        assert_eq!(
+            tree,
+            "<< /Names [(intro) [3 0 R /XYZ null null null] (summary) [7 0 R /XYZ 72 700 null]] >>"
+        );
+    }
+}
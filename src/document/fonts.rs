@@ -0,0 +1,75 @@
+//! Embedded fonts shared across the pages of a document.
+
+use std::collections::BTreeSet;
+
+use crate::content::{Operator, TextElement};
+use crate::font::TrueTypeFont;
+use crate::page::Page;
+
+/// An embedded font and every glyph the document draws with it.
+pub(super) struct EmbeddedFont {
+    pub font: TrueTypeFont,
+    pub glyphs: BTreeSet<u16>,
+}
+
+/// Collects the embedded fonts used by `pages`.
+///
+/// A font registered on several pages is embedded once, with a subset
+/// covering the glyphs shown on all of them.
+pub(super) fn collect_embedded_fonts(pages: &[Page]) -> Vec<EmbeddedFont> {
+    let mut fonts: Vec<EmbeddedFont> = Vec::new();
+
+    for page in pages {
+        for (_, font) in &page.fonts {
+            if let Some(font) = font.as_truetype() {
+                if find_embedded_font(&fonts, font).is_none() {
+                    fonts.push(EmbeddedFont {
+                        font: font.clone(),
+                        glyphs: BTreeSet::new(),
+                    });
+                }
+            }
+        }
+
+        let mut current: Option<usize> = None;
+        for op in page.content.operators() {
+            match op {
+                Operator::SetFont(name, _) => {
+                    current = page
+                        .fonts
+                        .iter()
+                        .find(|(n, _)| n == name)
+                        .and_then(|(_, font)| font.as_truetype())
+                        .and_then(|font| find_embedded_font(&fonts, font));
+                }
+                Operator::ShowHexText(bytes) => {
+                    if let Some(index) = current {
+                        fonts[index].glyphs.extend(glyph_ids(bytes));
+                    }
+                }
+                Operator::ShowTextPositioned(elements) => {
+                    if let Some(index) = current {
+                        for element in elements {
+                            if let TextElement::Hex(bytes) = element {
+                                fonts[index].glyphs.extend(glyph_ids(bytes));
+                            }
+                        }
+                    }
+                }
+                _ => {}
+            }
+        }
+    }
+
+    fonts
+}
+
+/// Reads big-endian glyph IDs.
+fn glyph_ids(bytes: &[u8]) -> impl Iterator<Item = u16> + '_ {
+    bytes.chunks_exact(2).map(|pair| u16::from_be_bytes([pair[0], pair[1]]))
+}
+
+/// Returns the index of `font` in `fonts`.
+pub(super) fn find_embedded_font(fonts: &[EmbeddedFont], font: &TrueTypeFont) -> Option<usize> {
+    fonts.iter().position(|f| f.font.ptr_eq(font))
+}
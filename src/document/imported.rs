@@ -0,0 +1,28 @@
+//! Objects of imported pages, written once per source file.
+
+use std::sync::Arc;
+
+use crate::page::{ImportedObjects, Page};
+
+/// Collects the object sets of the pages imported into `pages`.
+///
+/// Pages imported from the same file share a set, which is returned once.
+pub(super) fn collect_imported_objects(pages: &[Page]) -> Vec<Arc<ImportedObjects>> {
+    let mut sets: Vec<Arc<ImportedObjects>> = Vec::new();
+    for page in pages {
+        for (_, imported) in &page.imported {
+            if find_imported_objects(&sets, imported.objects()).is_none() {
+                sets.push(imported.objects().clone());
+            }
+        }
+    }
+    sets
+}
+
+/// Returns the index of `objects` in `sets`.
+pub(super) fn find_imported_objects(
+    sets: &[Arc<ImportedObjects>],
+    objects: &Arc<ImportedObjects>,
+) -> Option<usize> {
+    sets.iter().position(|set| Arc::ptr_eq(set, objects))
+}
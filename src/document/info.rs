@@ -94,22 +94,22 @@ impl DocumentInfo {
         let mut dict = PdfDictionary::new();
 
         if let Some(ref title) = self.title {
-            dict.set("Title", Object::String(PdfString::literal(title)));
+            dict.set("Title", Object::String(PdfString::text(title)));
         }
         if let Some(ref author) = self.author {
-            dict.set("Author", Object::String(PdfString::literal(author)));
+            dict.set("Author", Object::String(PdfString::text(author)));
         }
         if let Some(ref subject) = self.subject {
-            dict.set("Subject", Object::String(PdfString::literal(subject)));
+            dict.set("Subject", Object::String(PdfString::text(subject)));
         }
         if let Some(ref keywords) = self.keywords {
-            dict.set("Keywords", Object::String(PdfString::literal(keywords)));
+            dict.set("Keywords", Object::String(PdfString::text(keywords)));
         }
         if let Some(ref creator) = self.creator {
-            dict.set("Creator", Object::String(PdfString::literal(creator)));
+            dict.set("Creator", Object::String(PdfString::text(creator)));
         }
         if let Some(ref producer) = self.producer {
-            dict.set("Producer", Object::String(PdfString::literal(producer)));
+            dict.set("Producer", Object::String(PdfString::text(producer)));
         }
         if let Some(ref creation_date) = self.creation_date {
             dict.set(
@@ -213,6 +213,17 @@ mod tests {
         assert!(!dict.contains_key("Subject"));
     }
 
+    #[test]
+    fn test_to_dictionary_unicode() {
+        let info = DocumentInfo::new().author("Fran\u{e7}ois M\u{fc}ller");
+        let dict = info.to_dictionary();
+        assert_eq!(
+            dict.get("Author"),
+            Some(&Object::String(PdfString::text("Fran\u{e7}ois M\u{fc}ller")))
+        );
+        assert!(dict.to_pdf_string().contains("/Author <FEFF0046"));
+    }
+
     #[test]
     fn test_is_empty() {
         let info = DocumentInfo::new();
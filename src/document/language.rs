@@ -0,0 +1,92 @@
+//! The natural language of a document.
+
+use crate::error::DocumentError;
+
+/// Returns whether `tag` is shaped like a BCP 47 language tag, such as
+/// `en`, `en-US`, `zh-Hant-TW` or `sr-Latn-RS`: a primary language subtag
+/// followed, in order, by optional extended language, script, region,
+/// variant, extension and private use subtags.
+///
+/// Subtags are not looked up in the IANA registry, so a well-formed tag for
+/// a language that does not exist passes.
+pub fn is_language_tag(tag: &str) -> bool {
+    let subtags: Vec<&str> = tag.split('-').collect();
+    if subtags.iter().any(|s| s.is_empty() || s.len() > 8 || !s.bytes().all(|b| b.is_ascii_alphanumeric())) {
+        return false;
+    }
+    let alpha = |s: &str| s.bytes().all(|b| b.is_ascii_alphabetic());
+    let digit = |s: &str| s.bytes().all(|b| b.is_ascii_digit());
+
+    let primary = subtags[0];
+    // A tag that is all private use, or an irregular grandfathered one such
+    // as i-klingon
+    if primary.eq_ignore_ascii_case("x") || primary.eq_ignore_ascii_case("i") {
+        return subtags.len() > 1;
+    }
+    if primary.len() < 2 || !alpha(primary) {
+        return false;
+    }
+
+    // Each subtag must be of a kind that comes later than the last one;
+    // up to three extended languages and any number of variants may repeat
+    let (extlang, script, region, variant) = (1, 2, 3, 4);
+    let mut last = 0;
+    let mut extlangs = 0;
+    let mut i = 1;
+    while i < subtags.len() {
+        let subtag = subtags[i];
+        if subtag.len() == 1 {
+            // Private use takes everything after it
+            if subtag.eq_ignore_ascii_case("x") {
+                return i + 1 < subtags.len();
+            }
+            // An extension has at least one subtag of 2 to 8 characters
+            let end = subtags[i + 1..].iter().position(|s| s.len() == 1).map_or(subtags.len(), |p| i + 1 + p);
+            if end == i + 1 {
+                return false;
+            }
+            last = variant + 1;
+            i = end;
+            continue;
+        }
+        let kind = match subtag.len() {
+            3 if alpha(subtag) && primary.len() <= 3 && extlangs < 3 && last <= extlang => {
+                extlangs += 1;
+                extlang
+            }
+            4 if alpha(subtag) && last < script => script,
+            2 if alpha(subtag) && last < region => region,
+            3 if digit(subtag) && last < region => region,
+            5..=8 if last <= variant => variant,
+            4 if subtag.as_bytes()[0].is_ascii_digit() && last <= variant => variant,
+            _ => return false,
+        };
+        last = kind;
+        i += 1;
+    }
+    true
+}
+
+/// Checks that a document language is a well-formed language tag.
+pub(super) fn check_language(tag: &str) -> Result<(), DocumentError> {
+    if is_language_tag(tag) {
+        Ok(())
+    } else {
+        Err(DocumentError::InvalidLanguage(tag.to_string()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_language_tags() {
+        for tag in ["en", "en-US", "ar-EG", "zh-Hant-TW", "sr-Latn-RS", "es-419", "de-CH-1996", "zh-yue-HK", "en-a-bbb-x-private", "x-whatever", "i-klingon"] {
+            assert!(is_language_tag(tag), "{}", tag);
+        }
+        for tag in ["", "e", "en_US", "en-", "-en", "en-GB-1", "en-US-GB", "en-Latn-Latn", "en-a", "en-x", "12", "en-US-Latn", "fr-toolongsubtag"] {
+            assert!(!is_language_tag(tag), "{}", tag);
+        }
+    }
+}
@@ -0,0 +1,94 @@
+//! Layers (optional content groups) that readers can hide and show.
+
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::types::ObjectId;
+
+/// A layer listed in the viewer's layers panel. Content between
+/// [`ContentBuilder::begin_optional_content`] with the layer's
+/// [`Layer::resource_name`] and [`ContentBuilder::end_marked_content`]
+/// belongs to it, and is drawn only while the layer is shown.
+///
+/// [`ContentBuilder::begin_optional_content`]: crate::content::ContentBuilder::begin_optional_content
+/// [`ContentBuilder::end_marked_content`]: crate::content::ContentBuilder::end_marked_content
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Layer {
+    /// Name shown in the layers panel.
+    pub name: String,
+    /// Whether the layer is shown when the document opens.
+    pub visible: bool,
+}
+
+impl Layer {
+    /// Creates a layer that is shown when the document opens.
+    pub fn new(name: impl Into<String>) -> Self {
+        Self {
+            name: name.into(),
+            visible: true,
+        }
+    }
+
+    /// Sets whether the layer is shown when the document opens.
+    pub fn visible(mut self, visible: bool) -> Self {
+        self.visible = visible;
+        self
+    }
+
+    /// Returns the name of layer `index` in the /Properties resources of
+    /// every page.
+    pub fn resource_name(index: usize) -> String {
+        format!("OC{}", index + 1)
+    }
+}
+
+/// Builds the optional content group dictionary of `layer`.
+pub(super) fn layer_dictionary(layer: &Layer) -> PdfDictionary {
+    let mut dict = PdfDictionary::new();
+    dict.set("Type", Object::Name(PdfName::new_unchecked("OCG")));
+    dict.set("Name", Object::String(PdfString::text(&layer.name)));
+    dict
+}
+
+/// Builds the catalog's /OCProperties for `layers` written as `ids`, listing
+/// every layer in the panel and turning hidden ones off.
+pub(super) fn oc_properties(layers: &[Layer], ids: &[ObjectId]) -> PdfDictionary {
+    let refs = |visible: Option<bool>| -> PdfArray {
+        layers
+            .iter()
+            .zip(ids)
+            .filter(|(layer, _)| visible.map_or(true, |visible| layer.visible == visible))
+            .map(|(_, id)| Object::Reference(*id))
+            .collect()
+    };
+
+    // PDF/A-2 requires the default configuration to have a name
+    let mut config = PdfDictionary::new();
+    config.set("Name", Object::String(PdfString::text("Default")));
+    config.set("Order", Object::Array(refs(None)));
+    let hidden = refs(Some(false));
+    if !hidden.is_empty() {
+        config.set("OFF", Object::Array(hidden));
+    }
+
+    let mut properties = PdfDictionary::new();
+    properties.set("OCGs", Object::Array(refs(None)));
+    properties.set("D", Object::Dictionary(config));
+    properties
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_oc_properties() {
+        let layers = [Layer::new("Walls"), Layer::new("Wiring").visible(false)];
+        let ids = [ObjectId::new(4), ObjectId::new(5)];
+        assert_eq!(layer_dictionary(&layers[0]).to_pdf_string(), "<< /Type /OCG /Name (Walls) >>");
+        assert_eq!(
+            oc_properties(&layers, &ids).to_pdf_string(),
+            "<< /OCGs [4 0 R 5 0 R] /D << /Name (Default) /Order [4 0 R 5 0 R] /OFF [5 0 R] >> >>"
+        );
+        assert!(!oc_properties(&layers[..1], &ids[..1]).to_pdf_string().contains("/OFF"));
+        assert_eq!(Layer::resource_name(0), "OC1");
+    }
+}
@@ -1,26 +1,81 @@
 //! PDF Document structure and building.
 
+mod attachment;
+mod audit;
+mod destination;
+mod fonts;
+#[cfg(feature = "parser")]
+mod imported;
 mod info;
+mod language;
+mod layer;
+mod outline;
+mod page_numbers;
+mod pdfa;
+mod streaming;
+mod structure;
+#[cfg(feature = "parser")]
+mod update;
 mod version;
+mod view;
+mod watermark;
+mod xmp;
 
+pub use attachment::{AfRelationship, FileAttachment};
+pub use destination::NamedDestination;
 pub use info::{DocumentInfo, DocumentInfoBuilder};
+pub use language::is_language_tag;
+pub use layer::Layer;
+pub use outline::Bookmark;
+pub use page_numbers::{PageNumberAlignment, PageNumbers};
+pub use pdfa::PdfAConformance;
+pub use streaming::StreamingWriter;
+pub use structure::{StructureElement, StructureKid, StructureType};
 pub use version::PdfVersion;
-
-use crate::error::{DocumentError, PdfResult};
+pub use view::{InitialView, PageLayout, PageMode, Zoom};
+pub use watermark::Watermark;
+pub use xmp::XmpMetadata;
+pub(crate) use xmp::xmp_date;
+
+use crate::error::{DocumentError, PdfError, PdfResult};
+use crate::font::embed::{embedded_font_objects, EmbeddedFontIds};
+use crate::font::{Font, Standard14Font};
 use crate::forms::{AppearanceBuilder, FormField, FormFieldType};
+use crate::content::{ContentBuilder, DEFAULT_PRECISION};
 use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
-use crate::page::Page;
-use crate::types::ObjectId;
+#[cfg(feature = "compression")]
+use crate::object::DEFAULT_COMPRESSION_LEVEL;
+use crate::page::{AnnotationKind, LinkTarget, Page, PageBox};
+use crate::types::{Margins, ObjectId, Rectangle};
 use crate::writer::PdfWriter;
+use std::collections::HashSet;
 use std::fs::File;
 use std::io::{BufWriter, Write};
 use std::path::Path;
 
+use attachment::{attachment_objects, check_attachment, embedded_files_tree, AttachmentIds};
+use destination::{check_destination, dests_tree};
+use fonts::{collect_embedded_fonts, find_embedded_font, EmbeddedFont};
+use layer::{layer_dictionary, oc_properties};
+use outline::{check_bookmark, outline_dictionaries};
+use page_numbers::PAGE_NUMBER_FONT_NAME;
+use structure::{check_structure, next_mcid, struct_parents, structure_dictionaries};
+use language::check_language;
+use view::{check_zoom, open_action};
+use watermark::{WATERMARK_FONT_NAME, WATERMARK_GSTATE_NAME};
+
+#[cfg(feature = "parser")]
+use crate::parser::PdfReader;
+#[cfg(feature = "parser")]
+use std::sync::Arc;
+#[cfg(feature = "parser")]
+use imported::{collect_imported_objects, find_imported_objects};
+
 #[cfg(feature = "encryption")]
-use crate::encryption::{generate_file_id, EncryptionConfig, EncryptionHandler};
+use crate::encryption::{generate_file_id, EncryptionAlgorithm, EncryptionConfig, EncryptionHandler};
 
 /// A complete PDF document.
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct Document {
     /// PDF version.
     pub version: PdfVersion,
@@ -28,12 +83,70 @@ pub struct Document {
     pub info: DocumentInfo,
     /// Pages in the document.
     pub pages: Vec<Page>,
+    /// Page numbers stamped on every page when the document is written.
+    pub page_numbers: Option<PageNumbers>,
+    /// Watermark stamped on every page when the document is written.
+    pub watermark: Option<Watermark>,
+    /// Margins around the content area of every page, if set.
+    ///
+    /// Page numbers are placed in the bottom margin when these are set.
+    pub margins: Option<Margins>,
+    /// Outline entries, in display order within each parent.
+    pub bookmarks: Vec<Bookmark>,
+    /// Files embedded in the document.
+    pub attachments: Vec<FileAttachment>,
+    /// Destinations links can refer to by name, each with a different name.
+    pub destinations: Vec<NamedDestination>,
+    /// The zoom, page layout and panel the document opens with.
+    pub initial_view: InitialView,
+    /// The natural language of the text, as a BCP 47 tag such as `en-US`,
+    /// for screen readers and hyphenation (/Lang).
+    pub language: Option<String>,
+    /// Elements of the structure tree, each after its parent. The document
+    /// is written as tagged when there are any.
+    pub structure: Vec<StructureElement>,
+    /// Layers content can belong to, listed in the viewer's layers panel in
+    /// this order.
+    pub layers: Vec<Layer>,
+    /// PDF/A level the document must conform to when written, if any.
+    pub pdfa: Option<PdfAConformance>,
+    /// XMP metadata for the catalog, if any. PDF/A output generates a packet
+    /// from the document information when this is not set.
+    pub xmp: Option<XmpMetadata>,
+    /// File identifier written to the trailer's /ID, if set. Otherwise one
+    /// is generated where it is needed, for PDF/A and encryption, and
+    /// changes every time the document is written.
+    pub file_id: Option<Vec<u8>>,
+    /// Whether to pack objects into object streams and write a
+    /// cross-reference stream. Only PDF 1.5 and later, except PDF/A-1b,
+    /// allow this; other documents keep the classic layout.
+    pub object_streams: bool,
+    /// Whether to lay the file out for fast web view, with the first page at
+    /// the front. Object streams are not used in a linearized file.
+    pub linearized: bool,
+    /// Whether to write identical streams, fonts and graphics states once,
+    /// shared by everything that uses them.
+    pub deduplicate: bool,
+    /// Decimal places coordinates and other numbers in page content streams
+    /// are rounded to. Color components and matrix scaling keep at least
+    /// [`DEFAULT_PRECISION`] places.
+    pub coordinate_precision: usize,
     /// Whether to compress content streams.
     #[cfg(feature = "compression")]
     pub compress_streams: bool,
+    /// Flate level from 0 (fastest) to 9 (smallest) used when compressing.
+    #[cfg(feature = "compression")]
+    pub compression_level: u32,
+    /// Streams shorter than this many bytes are written uncompressed, as
+    /// Flate saves little on them and costs as much time per stream.
+    #[cfg(feature = "compression")]
+    pub compress_min_size: usize,
     /// Encryption configuration.
     #[cfg(feature = "encryption")]
     pub encryption: Option<EncryptionConfig>,
+    /// The file this document updates, if opened with `open_for_update`.
+    #[cfg(feature = "parser")]
+    original: Option<Arc<PdfReader>>,
 }
 
 impl Document {
@@ -43,10 +156,33 @@ impl Document {
             version: PdfVersion::default(),
             info: DocumentInfo::new(),
             pages: Vec::new(),
+            page_numbers: None,
+            watermark: None,
+            margins: None,
+            bookmarks: Vec::new(),
+            attachments: Vec::new(),
+            destinations: Vec::new(),
+            initial_view: InitialView::default(),
+            language: None,
+            structure: Vec::new(),
+            layers: Vec::new(),
+            pdfa: None,
+            xmp: None,
+            file_id: None,
+            object_streams: false,
+            linearized: false,
+            deduplicate: false,
+            coordinate_precision: DEFAULT_PRECISION,
             #[cfg(feature = "compression")]
             compress_streams: false,
+            #[cfg(feature = "compression")]
+            compression_level: DEFAULT_COMPRESSION_LEVEL,
+            #[cfg(feature = "compression")]
+            compress_min_size: 0,
             #[cfg(feature = "encryption")]
             encryption: None,
+            #[cfg(feature = "parser")]
+            original: None,
         }
     }
 
@@ -55,11 +191,472 @@ impl Document {
         self.pages.push(page);
     }
 
+    /// Moves the page at `from` to index `to`, shifting the pages in between,
+    /// and keeps links, bookmarks, named destinations and tagged content on
+    /// the same pages.
+    ///
+    /// Returns an error if either index is out of range, or if either is one
+    /// of the original pages of a document opened for update.
+    pub fn move_page(&mut self, from: usize, to: usize) -> PdfResult<()> {
+        self.check_page_index(from)?;
+        self.check_page_index(to)?;
+        self.check_page_change(from.min(to), "page reordering")?;
+        let page = self.pages.remove(from);
+        self.pages.insert(to, page);
+        let shift = |i: usize| match i {
+            i if i == from => to,
+            i if from < to && (from..=to).contains(&i) => i - 1,
+            i if to < from && (to..from).contains(&i) => i + 1,
+            i => i,
+        };
+        self.retarget_pages(|i| Some(shift(i)), 0);
+        Ok(())
+    }
+
+    /// Removes the page at `index` and returns it.
+    ///
+    /// Links, bookmarks and named destinations that pointed at the page
+    /// point at the page now at its index instead, or the new last page,
+    /// without their scroll positions. Bookmarks and named destinations are
+    /// removed with the last page. Structure elements lose the page's
+    /// content but stay in the tree.
+    ///
+    /// Returns an error if the index is out of range, or if it is one of the
+    /// original pages of a document opened for update.
+    pub fn delete_page(&mut self, index: usize) -> PdfResult<Page> {
+        self.check_page_index(index)?;
+        self.check_page_change(index, "page deletion")?;
+        let page = self.pages.remove(index);
+        if self.pages.is_empty() {
+            self.bookmarks.clear();
+            self.destinations.clear();
+            self.retarget_structure(|_| None);
+            return Ok(page);
+        }
+        let fallback = index.min(self.pages.len() - 1);
+        let shift = |i: usize| match i {
+            i if i == index => None,
+            i if i > index => Some(i - 1),
+            i => Some(i),
+        };
+        self.retarget_pages(shift, fallback);
+        Ok(page)
+    }
+
+    /// Returns an error if there is no page at `index`.
+    fn check_page_index(&self, index: usize) -> PdfResult<()> {
+        if index >= self.pages.len() {
+            let count = self.pages.len();
+            return Err(DocumentError::PageOutOfRange { index, count }.into());
+        }
+        Ok(())
+    }
+
+    /// Returns an error if pages from `index` on cannot be moved or removed,
+    /// because they are drawn over the original pages in an update.
+    #[cfg_attr(not(feature = "parser"), allow(unused_variables))]
+    fn check_page_change(&self, index: usize, change: &'static str) -> PdfResult<()> {
+        #[cfg(feature = "parser")]
+        if let Some(original) = &self.original {
+            if index < original.page_count() {
+                return Err(DocumentError::NotIncremental(change).into());
+            }
+        }
+        Ok(())
+    }
+
+    /// Points every reference to a page by index at `target(index)`, or at
+    /// `fallback` without a scroll position where that is `None`.
+    fn retarget_pages(&mut self, target: impl Fn(usize) -> Option<usize>, fallback: usize) {
+        let retarget = |index: &mut usize| match target(*index) {
+            Some(new) => {
+                *index = new;
+                true
+            }
+            None => {
+                *index = fallback;
+                false
+            }
+        };
+        for link in self.pages.iter_mut().flat_map(|p| &mut p.links) {
+            if let LinkTarget::Page { page_index, y } = &mut link.target {
+                if !retarget(page_index) {
+                    *y = None;
+                }
+            }
+        }
+        for bookmark in &mut self.bookmarks {
+            if !retarget(&mut bookmark.page_index) {
+                bookmark.y = None;
+            }
+        }
+        for destination in &mut self.destinations {
+            if !retarget(&mut destination.page_index) {
+                destination.x = None;
+                destination.y = None;
+            }
+        }
+        self.retarget_structure(target);
+    }
+
+    /// Points the marked content of structure elements at `target(index)`,
+    /// dropping the content of pages for which that is `None`.
+    fn retarget_structure(&mut self, target: impl Fn(usize) -> Option<usize>) {
+        for element in &mut self.structure {
+            element.kids.retain_mut(|kid| match kid {
+                StructureKid::MarkedContent { page_index, .. } => match target(*page_index) {
+                    Some(new) => {
+                        *page_index = new;
+                        true
+                    }
+                    None => false,
+                },
+                StructureKid::Element(_) => true,
+            });
+        }
+    }
+
+    /// Appends every page of a PDF file and returns the number of pages added.
+    ///
+    /// See [`PdfReader::import_pages`] for how the pages are copied. Form
+    /// fields named like fields of the document are renamed as by
+    /// [`rename_conflicting_fields`](Self::rename_conflicting_fields).
+    #[cfg(feature = "parser")]
+    pub fn append_pdf(&mut self, data: impl Into<Vec<u8>>) -> PdfResult<usize> {
+        let mut pages = PdfReader::from_bytes(data.into())?.import_pages()?;
+        self.rename_conflicting_fields(&mut pages);
+        let count = pages.len();
+        self.pages.extend(pages);
+        Ok(count)
+    }
+
+    /// Appends the pages of a PDF file tiled `rows` by `cols` per page, and
+    /// returns the number of pages added.
+    ///
+    /// See [`PdfReader::import_nup`] for how the pages are laid out.
+    #[cfg(feature = "parser")]
+    pub fn append_nup(&mut self, data: impl Into<Vec<u8>>, rows: usize, cols: usize, gutter: f64) -> PdfResult<usize> {
+        let sheets = PdfReader::from_bytes(data.into())?.import_nup(rows, cols, gutter)?;
+        let count = sheets.len();
+        self.pages.extend(sheets);
+        Ok(count)
+    }
+
+    /// Opens a PDF file to be written as an incremental update: the original
+    /// bytes are kept unchanged, and what is added is appended after them
+    /// with a new cross-reference section, so earlier signatures stay valid.
+    ///
+    /// The document starts with a blank page in place of each original page,
+    /// of the same media box. Content, links and form fields added to those
+    /// pages are drawn over the original ones, in their unrotated
+    /// coordinates; further pages are added after them. Each page starts
+    /// with the original's rotation and boundaries, and different ones
+    /// replace them.
+    /// Document information set on the document is merged into the
+    /// original's. Bookmarks, named destinations, attachments, the initial
+    /// view, tagged structure, layers, PDF/A, XMP metadata, linearization
+    /// and encryption cannot be applied in an update, and make writing fail.
+    #[cfg(feature = "parser")]
+    pub fn open_for_update(data: impl Into<Vec<u8>>) -> PdfResult<Self> {
+        let reader = PdfReader::from_bytes(data.into())?;
+        let mut document = Self::new();
+        document.version = reader.version();
+        for (_, leaf) in reader.leaf_pages()? {
+            let media_box = leaf.get("MediaBox").and_then(crate::parser::rectangle);
+            let mut page = Page::new(media_box.unwrap_or_else(Rectangle::letter));
+            page.rotation = update::rotation(&reader, &leaf);
+            for kind in PageBox::ALL {
+                page.set_page_box(kind, leaf.get(kind.key()).and_then(crate::parser::rectangle));
+            }
+            document.pages.push(page);
+        }
+        document.original = Some(Arc::new(reader));
+        Ok(document)
+    }
+
+    /// Adds an outline entry and returns its index, for use as a parent.
+    ///
+    /// Returns an error if the parent does not exist or the page is out of range.
+    pub fn add_bookmark(&mut self, bookmark: Bookmark) -> PdfResult<usize> {
+        check_bookmark(&bookmark, self.bookmarks.len(), self.pages.len())?;
+        self.bookmarks.push(bookmark);
+        Ok(self.bookmarks.len() - 1)
+    }
+
+    /// Adds an element to the structure tree and returns its index, for use
+    /// as a parent. Without a parent, it is a child of the tree's root.
+    ///
+    /// Returns an error if the parent does not exist.
+    pub fn add_structure_element(&mut self, element: StructureElement, parent: Option<usize>) -> PdfResult<usize> {
+        let index = self.structure.len();
+        if let Some(parent) = parent {
+            let parent = self.structure.get_mut(parent).ok_or_else(|| {
+                DocumentError::InvalidStructure(format!("parent {} does not exist", parent))
+            })?;
+            parent.kids.push(StructureKid::Element(index));
+        }
+        self.structure.push(element);
+        Ok(index)
+    }
+
+    /// Adds a marked-content sequence on page `page_index` to the structure
+    /// element `element`, and returns its identifier. Wrap the element's
+    /// content in [`ContentBuilder::begin_tagged_content`] with it and
+    /// [`ContentBuilder::end_marked_content`].
+    ///
+    /// Returns an error if the element does not exist or the page is out of range.
+    pub fn mark_content(&mut self, element: usize, page_index: usize) -> PdfResult<u32> {
+        self.check_page_index(page_index)?;
+        let mcid = next_mcid(&self.structure, page_index);
+        let element = self.structure.get_mut(element).ok_or_else(|| {
+            DocumentError::InvalidStructure(format!("element {} does not exist", element))
+        })?;
+        element.kids.push(StructureKid::MarkedContent { page_index, mcid });
+        Ok(mcid)
+    }
+
+    /// Adds a layer and returns its index. Its content is marked with
+    /// [`Layer::resource_name`] of the index.
+    pub fn add_layer(&mut self, layer: Layer) -> usize {
+        self.layers.push(layer);
+        self.layers.len() - 1
+    }
+
+    /// Embeds a file in the document.
+    ///
+    /// Returns an error if the file name is empty or already attached.
+    pub fn attach_file(&mut self, attachment: FileAttachment) -> PdfResult<()> {
+        check_attachment(&attachment, &self.attachments)?;
+        self.attachments.push(attachment);
+        Ok(())
+    }
+
+    /// Adds a destination that links can refer to by name, replacing any
+    /// destination of the same name, which is returned.
+    ///
+    /// Returns an error if the name is empty or the page is out of range.
+    pub fn add_named_destination(&mut self, destination: NamedDestination) -> PdfResult<Option<NamedDestination>> {
+        check_destination(&destination, self.pages.len())?;
+        match self.destinations.iter_mut().find(|d| d.name == destination.name) {
+            Some(existing) => Ok(Some(std::mem::replace(existing, destination))),
+            None => {
+                self.destinations.push(destination);
+                Ok(None)
+            }
+        }
+    }
+
+    /// Returns the form field with the given name on any page.
+    pub fn form_field_mut(&mut self, name: &str) -> Option<&mut FormField> {
+        self.pages.iter_mut().flat_map(|p| &mut p.form_fields).find(|f| f.name == name)
+    }
+
+    /// Renames the form fields of `pages`, such as pages imported from
+    /// another file, whose names are already used by fields of the document,
+    /// so that adding the pages does not merge unrelated fields. A field is
+    /// renamed by appending `_2`, or the first higher number unused by either.
+    /// Fields of `pages` that share a name are one field shown in several
+    /// places, and keep sharing the new name.
+    ///
+    /// Returns the old and new name of each field renamed.
+    pub fn rename_conflicting_fields(&self, pages: &mut [Page]) -> Vec<(String, String)> {
+        let existing: HashSet<&str> =
+            self.pages.iter().flat_map(|p| &p.form_fields).map(|f| f.name.as_str()).collect();
+        let mut taken: HashSet<String> = existing.iter().map(|name| name.to_string()).collect();
+        taken.extend(pages.iter().flat_map(|p| &p.form_fields).map(|f| f.name.clone()));
+
+        let mut renamed: Vec<(String, String)> = Vec::new();
+        for field in pages.iter_mut().flat_map(|p| &mut p.form_fields) {
+            if !existing.contains(field.name.as_str()) {
+                continue;
+            }
+            let new_name = match renamed.iter().find(|(old, _)| *old == field.name) {
+                Some((_, new_name)) => new_name.clone(),
+                None => {
+                    let new_name = (2..)
+                        .map(|n| format!("{}_{}", field.name, n))
+                        .find(|candidate| !taken.contains(candidate))
+                        .unwrap_or_default();
+                    taken.insert(new_name.clone());
+                    renamed.push((field.name.clone(), new_name.clone()));
+                    new_name
+                }
+            };
+            field.name = new_name;
+        }
+        renamed
+    }
+
+    /// Draws the text fields and checkboxes into their pages' content and
+    /// removes them, so their values can no longer be edited. Returns the
+    /// number of fields flattened; other field types are left in place.
+    pub fn flatten_form(&mut self) -> usize {
+        let mut count = 0;
+        for page in &mut self.pages {
+            let (flat, kept): (Vec<_>, Vec<_>) = std::mem::take(&mut page.form_fields)
+                .into_iter()
+                .partition(|f| matches!(f.field_type, FormFieldType::Text | FormFieldType::CheckBox));
+            page.form_fields = kept;
+            if flat.is_empty() {
+                continue;
+            }
+
+            let mut content = std::mem::take(&mut page.content);
+            for field in &flat {
+                let appearance = match field.field_type {
+                    FormFieldType::Text => text_field_appearance(field),
+                    _ if field.checked => field_appearance_builder(field).build_checkbox_checked(field.text_color),
+                    _ => field_appearance_builder(field).build_checkbox_unchecked(),
+                };
+                content = content
+                    .save_state()
+                    .translate(field.rect.llx, field.rect.lly)
+                    .raw(appearance.trim_end())
+                    .restore_state();
+            }
+            page.content = content;
+            if !page.fonts.iter().any(|(name, _)| name == "Helv") {
+                page.add_font("Helv", Font::helvetica());
+            }
+            count += flat.len();
+        }
+        count
+    }
+
     /// Returns the number of pages.
     pub fn page_count(&self) -> usize {
         self.pages.len()
     }
 
+    /// Returns the area of a page inside the document margins, or `None` if
+    /// there is no such page. Without margins, one inch is left on every side.
+    pub fn content_rect(&self, index: usize) -> Option<Rectangle> {
+        let page = self.pages.get(index)?;
+        Some(self.margins.unwrap_or_default().content_rect(&page.media_box))
+    }
+
+    /// Checks that every feature the document uses is available in its PDF
+    /// version. Writing the document fails with the same error.
+    pub fn check_version(&self) -> PdfResult<()> {
+        self.check_version_of(&self.pages)
+    }
+
+    /// Checks the document-level features and those `pages` use against the
+    /// PDF version.
+    fn check_version_of(&self, pages: &[Page]) -> PdfResult<()> {
+        let mut required = Vec::new();
+        if !self.destinations.is_empty() {
+            required.push(("Named destinations", PdfVersion::V1_2));
+        }
+        if !self.attachments.is_empty() {
+            required.push(("File attachments", PdfVersion::V1_3));
+        }
+        if self.xmp.is_some() {
+            required.push(("XMP metadata", PdfVersion::V1_4));
+        }
+        if !self.structure.is_empty() {
+            required.push(("Tagged structure", PdfVersion::V1_4));
+        }
+        if pages.iter().any(|p| !p.patterns.is_empty()) {
+            required.push(("Gradients", PdfVersion::V1_3));
+        }
+        if pages.iter().any(|p| !p.overprints.is_empty()) {
+            required.push(("Overprint control", PdfVersion::V1_3));
+        }
+        if !self.layers.is_empty() {
+            required.push(("Layers", PdfVersion::V1_5));
+        }
+        if self.watermark.is_some() {
+            required.push(("Watermark transparency", PdfVersion::V1_4));
+        }
+        let annotations = || pages.iter().flat_map(|p| &p.annotations);
+        if annotations().any(|a| matches!(a.kind, AnnotationKind::Highlight(_))) {
+            required.push(("Highlight transparency", PdfVersion::V1_4));
+        }
+        if annotations().any(|a| matches!(a.kind, AnnotationKind::Stamp(_))) {
+            required.push(("Stamp annotations", PdfVersion::V1_3));
+        }
+        required.extend(self.initial_view.required_versions());
+        #[cfg(feature = "images")]
+        if pages.iter().flat_map(|p| &p.images).any(|(_, image)| image.has_alpha()) {
+            required.push(("Image transparency", PdfVersion::V1_4));
+        }
+        #[cfg(feature = "encryption")]
+        if let Some(ref config) = self.encryption {
+            let feature = match config.algorithm {
+                EncryptionAlgorithm::Rc4 => "RC4 encryption",
+                EncryptionAlgorithm::Aes128 => "AES-128 encryption",
+                EncryptionAlgorithm::Aes256 => "AES-256 encryption",
+            };
+            required.push((feature, config.algorithm.min_version()));
+        }
+
+        match required.into_iter().find(|&(_, version)| !self.version.supports(version)) {
+            Some((feature, required)) => Err(DocumentError::VersionTooLow {
+                feature,
+                required,
+                version: self.version,
+            }
+            .into()),
+            None => Ok(()),
+        }
+    }
+
+    /// Returns every way the document breaks its PDF/A level, or nothing if
+    /// no level is set. Writing the document fails while any remain.
+    pub fn pdfa_violations(&self) -> Vec<String> {
+        match self.pdfa {
+            Some(level) => pdfa::violations(self, level),
+            None => Vec::new(),
+        }
+    }
+
+    /// Returns a description of every font the document shows text in
+    /// without embedding it, every imported image compressed with the JPEG
+    /// 2000 or CCITT fax filters, which not every printer can decode, and
+    /// every object imported pages refer to that was missing from their file.
+    pub fn audit_resources(&self) -> Vec<String> {
+        audit::issues(self)
+    }
+
+    /// Returns whether object streams are enabled and allowed.
+    fn uses_object_streams(&self) -> bool {
+        self.object_streams
+            && !self.linearized
+            && self.version.supports(PdfVersion::V1_5)
+            && self.pdfa != Some(PdfAConformance::A1b)
+    }
+
+    /// Returns the Flate level streams are compressed at, if they are.
+    fn stream_compression_level(&self) -> Option<u32> {
+        #[cfg(feature = "compression")]
+        {
+            self.compress_streams.then_some(self.compression_level)
+        }
+        #[cfg(not(feature = "compression"))]
+        None
+    }
+
+    /// Compresses `stream` if streams are compressed and it is at least
+    /// `compress_min_size` bytes long.
+    #[cfg(feature = "compression")]
+    fn compress(&self, stream: PdfStream) -> PdfResult<PdfStream> {
+        if self.compress_streams && stream.data().len() >= self.compress_min_size {
+            Ok(stream.with_compression_level(self.compression_level)?)
+        } else {
+            Ok(stream)
+        }
+    }
+
+    /// Returns the XMP packet written to the catalog, if any.
+    fn metadata_packet(&self) -> Option<Vec<u8>> {
+        match (&self.xmp, self.pdfa) {
+            (Some(xmp), pdfa) => Some(xmp.packet(&self.info, pdfa)),
+            (None, Some(level)) => Some(XmpMetadata::FromInfo.packet(&self.info, Some(level))),
+            (None, None) => None,
+        }
+    }
+
     /// Saves the document to a file.
     pub fn save_to_file(&self, path: impl AsRef<Path>) -> PdfResult<()> {
         if self.pages.is_empty() {
@@ -73,24 +670,64 @@ impl Document {
 
     /// Saves the document to a byte vector.
     pub fn save_to_bytes(&self) -> PdfResult<Vec<u8>> {
+        self.save_to_bytes_with_progress(|_| true)
+    }
+
+    /// Saves the document to bytes like [`save_to_bytes`](Self::save_to_bytes),
+    /// reporting progress as for [`write_with_progress`](Self::write_with_progress).
+    pub fn save_to_bytes_with_progress(&self, progress: impl FnMut(f64) -> bool) -> PdfResult<Vec<u8>> {
         if self.pages.is_empty() {
             return Err(DocumentError::NoPages.into());
         }
 
         let buffer = Vec::new();
         let mut cursor = std::io::Cursor::new(buffer);
-        self.write_to(&mut cursor)?;
+        self.write_with_progress(&mut cursor, progress)?;
         Ok(cursor.into_inner())
     }
 
     /// Writes the document to any writer.
     pub fn write_to<W: Write>(&self, writer: W) -> PdfResult<()> {
+        self.write_with_progress(writer, |_| true)
+    }
+
+    /// Writes the document to any writer, calling `progress` with the
+    /// fraction of pages written before each page. If `progress` returns
+    /// false the write stops with [`PdfError::Cancelled`], leaving the output
+    /// incomplete.
+    pub fn write_with_progress<W: Write>(&self, writer: W, mut progress: impl FnMut(f64) -> bool) -> PdfResult<()> {
+        #[cfg(feature = "parser")]
+        if let Some(original) = &self.original {
+            if !progress(0.0) {
+                return Err(PdfError::Cancelled);
+            }
+            return update::write_update(self, original, writer);
+        }
+        self.check_version()?;
+        for (index, page) in self.pages.iter().enumerate() {
+            check_page(index, page)?;
+        }
+        if let Some(level) = self.pdfa {
+            let violations = pdfa::violations(self, level);
+            if !violations.is_empty() {
+                return Err(DocumentError::NotPdfA { level, violations }.into());
+            }
+        }
         let mut pdf_writer = PdfWriter::new(writer, self.version.as_str());
+        if self.linearized && !self.pages.is_empty() {
+            pdf_writer.linearize();
+        }
+        if self.deduplicate {
+            pdf_writer.deduplicate();
+        }
+        if self.uses_object_streams() {
+            pdf_writer.use_object_streams(self.stream_compression_level());
+        }
 
         // Create encryption handler if configured
         #[cfg(feature = "encryption")]
         let encryption_handler = if let Some(ref config) = self.encryption {
-            let file_id = generate_file_id();
+            let file_id = self.file_id.clone().unwrap_or_else(generate_file_id);
             let handler = EncryptionHandler::new(config.clone(), file_id)?;
             // Set the encryption handler on the writer so it encrypts streams/strings
             pdf_writer.set_encryption_handler(handler.clone());
@@ -106,11 +743,42 @@ impl Document {
         let catalog_id = pdf_writer.allocate_id();
         let pages_id = pdf_writer.allocate_id();
 
+        // Embedded fonts are written once and shared by every page that uses them
+        let embedded_fonts = collect_embedded_fonts(&self.pages);
+        let mut embedded_font_ids = Vec::with_capacity(embedded_fonts.len());
+        for _ in &embedded_fonts {
+            embedded_font_ids.push(EmbeddedFontIds {
+                font: pdf_writer.allocate_id(),
+                descendant: pdf_writer.allocate_id(),
+                descriptor: pdf_writer.allocate_id(),
+                font_file: pdf_writer.allocate_id(),
+                to_unicode: pdf_writer.allocate_id(),
+                cid_set: (self.pdfa == Some(PdfAConformance::A1b)).then(|| pdf_writer.allocate_id()),
+            });
+        }
+
+        // Objects copied from another file are shared by all pages imported from it
+        #[cfg(feature = "parser")]
+        let imported_sets = collect_imported_objects(&self.pages);
+        #[cfg(feature = "parser")]
+        let imported_ids: Vec<Vec<ObjectId>> = imported_sets
+            .iter()
+            .map(|set| (0..set.len()).map(|_| pdf_writer.allocate_id()).collect())
+            .collect();
+
+        // Page numbers and the watermark share one font object across all pages
+        let overlay_font_id = (self.page_numbers.is_some() || self.watermark.is_some())
+            .then(|| pdf_writer.allocate_id());
+        let watermark_gstate_id = self.watermark.as_ref().map(|_| pdf_writer.allocate_id());
+
         // Allocate IDs for each page and its content
         let mut page_ids: Vec<ObjectId> = Vec::new();
         let mut content_ids: Vec<ObjectId> = Vec::new();
         let mut font_ids: Vec<Vec<(String, ObjectId)>> = Vec::new();
 
+        // Gradient pattern IDs for each page
+        let mut pattern_ids: Vec<Vec<ObjectId>> = Vec::new();
+
         // Image IDs: Vec of (name, image_id, optional soft_mask_id)
         #[cfg(feature = "images")]
         let mut image_ids: Vec<Vec<(String, ObjectId, Option<ObjectId>)>> = Vec::new();
@@ -121,11 +789,27 @@ impl Document {
 
             // Allocate font IDs for this page
             let mut page_font_ids = Vec::new();
-            for (font_name, _) in &page.fonts {
-                page_font_ids.push((font_name.clone(), pdf_writer.allocate_id()));
+            for (font_name, font) in &page.fonts {
+                let embedded = font
+                    .as_truetype()
+                    .and_then(|f| find_embedded_font(&embedded_fonts, f));
+                let font_id = match embedded {
+                    Some(index) => embedded_font_ids[index].font,
+                    None => pdf_writer.allocate_id(),
+                };
+                page_font_ids.push((font_name.clone(), font_id));
             }
             font_ids.push(page_font_ids);
 
+            for (_, gradient) in &page.patterns {
+                gradient.check()?;
+            }
+            for (_, pattern) in &page.tiling_patterns {
+                pattern.check()?;
+            }
+            let pattern_count = page.patterns.len() + page.tiling_patterns.len();
+            pattern_ids.push((0..pattern_count).map(|_| pdf_writer.allocate_id()).collect());
+
             // Allocate image IDs for this page
             #[cfg(feature = "images")]
             {
@@ -157,41 +841,65 @@ impl Document {
         // Structure: Vec<Vec<(field_id, appearance_normal_id, appearance_down_id_opt)>>
         let mut form_field_ids: Vec<Vec<FormFieldIds>> = Vec::new();
         for page in &self.pages {
-            let mut page_field_ids = Vec::new();
-            for field in page.form_fields() {
-                let field_id = pdf_writer.allocate_id();
-                let ap_normal_id = pdf_writer.allocate_id();
-
-                // For checkboxes, radio buttons, we need both on and off appearances
-                let ap_down_id = match field.field_type {
-                    FormFieldType::CheckBox | FormFieldType::RadioButton => {
-                        Some(pdf_writer.allocate_id())
-                    }
-                    _ => None,
-                };
-
-                // For radio groups, allocate IDs for each button's widget
-                let radio_widget_ids: Vec<(ObjectId, ObjectId, ObjectId)> = if field.field_type == FormFieldType::RadioButton {
-                    field.radio_buttons.iter().map(|_| {
-                        let widget_id = pdf_writer.allocate_id();
-                        let ap_on = pdf_writer.allocate_id();
-                        let ap_off = pdf_writer.allocate_id();
-                        (widget_id, ap_on, ap_off)
-                    }).collect()
-                } else {
-                    Vec::new()
-                };
-
-                page_field_ids.push(FormFieldIds {
-                    field_id,
-                    ap_normal_id,
-                    ap_down_id,
-                    radio_widget_ids,
-                });
-            }
+            let page_field_ids = page.form_fields().iter().map(|f| allocate_form_field_ids(&mut pdf_writer, f)).collect();
             form_field_ids.push(page_field_ids);
         }
 
+        // Allocate outline IDs if there are bookmarks
+        self.check_document_objects(self.pages.len())?;
+        let outline_root_id = if self.bookmarks.is_empty() {
+            None
+        } else {
+            Some(pdf_writer.allocate_id())
+        };
+        let bookmark_ids: Vec<ObjectId> =
+            self.bookmarks.iter().map(|_| pdf_writer.allocate_id()).collect();
+
+        // Allocate structure tree IDs if the document is tagged
+        check_structure(&self.structure, self.pages.len())?;
+        let struct_tree_id = if self.structure.is_empty() {
+            None
+        } else {
+            Some(pdf_writer.allocate_id())
+        };
+        let structure_ids: Vec<ObjectId> =
+            self.structure.iter().map(|_| pdf_writer.allocate_id()).collect();
+        let page_struct_parents = struct_parents(&self.structure, self.pages.len());
+
+        // Allocate optional content group IDs for layers
+        let layer_ids: Vec<ObjectId> = self.layers.iter().map(|_| pdf_writer.allocate_id()).collect();
+
+        // Allocate link annotation IDs for each page
+        let link_ids: Vec<Vec<ObjectId>> = self
+            .pages
+            .iter()
+            .map(|page| page.links.iter().map(|_| pdf_writer.allocate_id()).collect())
+            .collect();
+
+        // Allocate markup annotation and appearance stream IDs for each page
+        let annotation_ids: Vec<Vec<(ObjectId, ObjectId)>> = self
+            .pages
+            .iter()
+            .map(|page| {
+                page.annotations
+                    .iter()
+                    .map(|_| (pdf_writer.allocate_id(), pdf_writer.allocate_id()))
+                    .collect()
+            })
+            .collect();
+        let thumbnail_ids: Vec<Option<ObjectId>> =
+            self.pages.iter().map(|page| page.thumbnail.as_ref().map(|_| pdf_writer.allocate_id())).collect();
+
+        // Allocate attachment IDs
+        let attachment_ids: Vec<AttachmentIds> = self
+            .attachments
+            .iter()
+            .map(|_| AttachmentIds {
+                filespec: pdf_writer.allocate_id(),
+                file: pdf_writer.allocate_id(),
+            })
+            .collect();
+
         // Allocate info object ID if we have metadata
         let info_id = if !self.info.is_empty() {
             Some(pdf_writer.allocate_id())
@@ -199,6 +907,10 @@ impl Document {
             None
         };
 
+        // XMP metadata, which PDF/A always has, and the PDF/A output intent profile
+        let metadata = self.metadata_packet().map(|packet| (pdf_writer.allocate_id(), packet));
+        let profile_id = self.pdfa.map(|_| pdf_writer.allocate_id());
+
         // Allocate encrypt object ID if encryption is configured
         #[cfg(feature = "encryption")]
         let encrypt_id = if encryption_handler.is_some() {
@@ -208,15 +920,17 @@ impl Document {
         };
 
         // Write catalog
-        let mut catalog = PdfDictionary::new();
-        catalog.set("Type", Object::Name(PdfName::catalog()));
-        catalog.set("Pages", Object::Reference(pages_id));
-
-        // Add AcroForm reference if forms exist
-        if let Some(acroform_id) = acroform_id {
-            catalog.set("AcroForm", Object::Reference(acroform_id));
-        }
-
+        let catalog = self.catalog(&CatalogIds {
+            pages: pages_id,
+            page_ids: &page_ids,
+            outline_root: outline_root_id,
+            acroform: acroform_id,
+            struct_tree: struct_tree_id,
+            layers: &layer_ids,
+            metadata: metadata.as_ref().map(|(id, _)| *id),
+            profile: profile_id,
+            attachments: &attachment_ids,
+        });
         pdf_writer.write_object_with_id(catalog_id, &Object::Dictionary(catalog))?;
 
         // Write pages tree
@@ -232,199 +946,559 @@ impl Document {
         pdf_writer.write_object_with_id(pages_id, &Object::Dictionary(pages_dict))?;
 
         // Write each page
+        let context = PageContext {
+            pages_id,
+            page_ids: &page_ids,
+            page_count: self.pages.len(),
+            overlay_font_id,
+            watermark_gstate_id,
+            layer_ids: &layer_ids,
+            tagged: struct_tree_id.is_some(),
+            #[cfg(feature = "parser")]
+            imported_sets: &imported_sets,
+            #[cfg(feature = "parser")]
+            imported_ids: &imported_ids,
+        };
         for (i, page) in self.pages.iter().enumerate() {
-            let page_id = page_ids[i];
-            let content_id = content_ids[i];
-            let page_fonts = &font_ids[i];
-
-            // Build font resources dictionary
-            let mut font_dict = PdfDictionary::new();
-            for (font_name, font_id) in page_fonts {
-                font_dict.set(font_name, Object::Reference(*font_id));
+            if !progress(i as f64 / self.pages.len() as f64) {
+                return Err(PdfError::Cancelled);
             }
+            let ids = PageIds {
+                page: page_ids[i],
+                content: content_ids[i],
+                fonts: &font_ids[i],
+                patterns: &pattern_ids[i],
+                #[cfg(feature = "images")]
+                images: &image_ids[i],
+                form_fields: &form_field_ids[i],
+                links: &link_ids[i],
+                annotations: &annotation_ids[i],
+                thumbnail: thumbnail_ids[i],
+                struct_parents: page_struct_parents[i],
+            };
+            self.write_page(&mut pdf_writer, page, i, &ids, &context)?;
+        }
 
-            // Build XObject resources dictionary for images
-            #[cfg(feature = "images")]
-            let page_images = &image_ids[i];
+        if let Some(font_id) = overlay_font_id {
+            let font_dict = Font::from(Standard14Font::Helvetica).to_dictionary();
+            pdf_writer.write_object_with_id(font_id, &Object::Dictionary(font_dict))?;
+        }
+        if let (Some(watermark), Some(gstate_id)) = (&self.watermark, watermark_gstate_id) {
+            pdf_writer.write_object_with_id(gstate_id, &Object::Dictionary(watermark.ext_gstate()))?;
+        }
 
-            #[cfg(feature = "images")]
-            let mut xobject_dict = PdfDictionary::new();
-            #[cfg(feature = "images")]
-            for (image_name, image_id, _) in page_images {
-                xobject_dict.set(image_name, Object::Reference(*image_id));
-            }
+        // Write embedded fonts, subset to the glyphs used across all pages
+        self.write_embedded_fonts(&mut pdf_writer, &embedded_fonts, &embedded_font_ids)?;
+
+        // Write objects copied from other PDF files
+        #[cfg(feature = "parser")]
+        for (set, ids) in imported_sets.iter().zip(&imported_ids) {
+            self.write_imported_objects(&mut pdf_writer, set, ids)?;
+        }
+
+        // Write AcroForm dictionary if forms exist
+        if let Some(acroform_id) = acroform_id {
+            let fields = form_field_ids.iter().flatten().map(|ids| Object::Reference(ids.field_id)).collect();
+            pdf_writer.write_object_with_id(acroform_id, &Object::Dictionary(acroform_dictionary(fields)))?;
+        }
+
+        // Write attachments
+        self.write_attachments(&mut pdf_writer, &attachment_ids)?;
 
-            // Build resources dictionary
-            let mut resources = PdfDictionary::new();
-            if !font_dict.is_empty() {
-                resources.set("Font", Object::Dictionary(font_dict));
+        // Write outline
+        if let Some(outline_root_id) = outline_root_id {
+            let (root, items) =
+                outline_dictionaries(&self.bookmarks, outline_root_id, &bookmark_ids, &page_ids);
+            pdf_writer.write_object_with_id(outline_root_id, &Object::Dictionary(root))?;
+            for (id, item) in bookmark_ids.iter().zip(items) {
+                pdf_writer.write_object_with_id(*id, &Object::Dictionary(item))?;
             }
-            #[cfg(feature = "images")]
-            if !xobject_dict.is_empty() {
-                resources.set("XObject", Object::Dictionary(xobject_dict));
+        }
+
+        // Write structure tree
+        if let Some(struct_tree_id) = struct_tree_id {
+            let (root, items) = structure_dictionaries(
+                &self.structure,
+                struct_tree_id,
+                &structure_ids,
+                &page_ids,
+                &page_struct_parents,
+            );
+            pdf_writer.write_object_with_id(struct_tree_id, &Object::Dictionary(root))?;
+            for (id, item) in structure_ids.iter().zip(items) {
+                pdf_writer.write_object_with_id(*id, &Object::Dictionary(item))?;
             }
+        }
 
-            // Build page dictionary
-            let mut page_dict = PdfDictionary::new();
-            page_dict.set("Type", Object::Name(PdfName::page()));
-            page_dict.set("Parent", Object::Reference(pages_id));
+        // Write the layers' optional content groups
+        for (layer, id) in self.layers.iter().zip(&layer_ids) {
+            pdf_writer.write_object_with_id(*id, &Object::Dictionary(layer_dictionary(layer)))?;
+        }
 
-            // MediaBox
-            let media_box = page.media_box.to_array();
-            let mut media_box_array = PdfArray::new();
-            for val in media_box {
-                media_box_array.push(Object::Real(val));
-            }
-            page_dict.set("MediaBox", Object::Array(media_box_array));
+        // Write XMP metadata and the PDF/A output intent profile
+        if let Some((metadata_id, packet)) = metadata {
+            pdf_writer.write_object_with_id(metadata_id, &Object::Stream(xmp::metadata_stream(packet)))?;
+        }
+        if let Some(profile_id) = profile_id {
+            pdf_writer.write_object_with_id(profile_id, &Object::Stream(pdfa::output_profile_stream()))?;
+        }
 
-            // Resources
-            if !resources.is_empty() {
-                page_dict.set("Resources", Object::Dictionary(resources));
+        // Write info dictionary if present
+        if let Some(info_id) = info_id {
+            let info_dict = self.info.to_dictionary();
+            pdf_writer.write_object_with_id(info_id, &Object::Dictionary(info_dict))?;
+        }
+
+        // Write encryption dictionary if encryption is enabled
+        // NOTE: The encryption dictionary must NOT be encrypted itself!
+        #[cfg(feature = "encryption")]
+        if let (Some(encrypt_id), Some(ref handler)) = (encrypt_id, &encryption_handler) {
+            let encrypt_dict = handler.create_encrypt_dictionary();
+            pdf_writer.write_object_unencrypted(encrypt_id, &Object::Dictionary(encrypt_dict))?;
+        }
+
+        // Write trailer; PDF/A requires a file identifier even without encryption
+        let file_id = self.file_id.clone().or_else(|| self.pdfa.map(|_| pdfa::file_id(&self.info)));
+        #[cfg(feature = "encryption")]
+        {
+            if let (Some(encrypt_id), Some(ref handler)) = (encrypt_id, &encryption_handler) {
+                pdf_writer.write_trailer_with_encryption(
+                    catalog_id,
+                    info_id,
+                    Some(encrypt_id),
+                    Some(handler.file_id()),
+                )?;
+            } else {
+                pdf_writer.write_trailer_with_encryption(catalog_id, info_id, None, file_id.as_deref())?;
             }
+        }
 
-            // Contents
-            page_dict.set("Contents", Object::Reference(content_id));
-
-            // Annotations (form fields) - Note: For radio groups, we add each button widget
-            let page_form_ids = &form_field_ids[i];
-            if !page_form_ids.is_empty() {
-                let mut annots = PdfArray::new();
-                for field_ids in page_form_ids {
-                    if !field_ids.radio_widget_ids.is_empty() {
-                        // Radio group: add each button widget as annotation
-                        for (widget_id, _, _) in &field_ids.radio_widget_ids {
-                            annots.push(Object::Reference(*widget_id));
-                        }
-                    } else {
-                        // Regular field: field itself is the widget
-                        annots.push(Object::Reference(field_ids.field_id));
-                    }
-                }
-                page_dict.set("Annots", Object::Array(annots));
+        #[cfg(not(feature = "encryption"))]
+        pdf_writer.write_trailer_with_encryption(catalog_id, info_id, None, file_id.as_deref())?;
+
+        Ok(())
+    }
+
+    /// Checks the bookmarks, named destinations, initial zoom, language and
+    /// attachments of a document with `page_count` pages.
+    fn check_document_objects(&self, page_count: usize) -> PdfResult<()> {
+        for (i, bookmark) in self.bookmarks.iter().enumerate() {
+            check_bookmark(bookmark, i, page_count)?;
+        }
+        for (i, destination) in self.destinations.iter().enumerate() {
+            check_destination(destination, page_count)?;
+            if self.destinations[..i].iter().any(|d| d.name == destination.name) {
+                let message = format!("name '{}' is used twice", destination.name);
+                return Err(DocumentError::InvalidDestination(message).into());
             }
+        }
+        if let Some(zoom) = self.initial_view.zoom {
+            check_zoom(zoom)?;
+        }
+        if let Some(language) = &self.language {
+            check_language(language)?;
+        }
+        for (i, attachment) in self.attachments.iter().enumerate() {
+            check_attachment(attachment, &self.attachments[..i])?;
+        }
+        Ok(())
+    }
 
-            pdf_writer.write_object_with_id(page_id, &Object::Dictionary(page_dict))?;
+    /// Writes embedded fonts, each subset to the glyphs it was drawn with.
+    fn write_embedded_fonts<W: Write>(
+        &self,
+        pdf_writer: &mut PdfWriter<W>,
+        fonts: &[EmbeddedFont],
+        ids: &[EmbeddedFontIds],
+    ) -> PdfResult<()> {
+        for (embedded, ids) in fonts.iter().zip(ids) {
+            let objects = embedded_font_objects(&embedded.font, &embedded.glyphs, ids);
+            #[cfg(feature = "compression")]
+            let (font_file, to_unicode) = (self.compress(objects.font_file)?, self.compress(objects.to_unicode)?);
+            #[cfg(not(feature = "compression"))]
+            let (font_file, to_unicode) = (objects.font_file, objects.to_unicode);
+
+            pdf_writer.write_object_with_id(ids.font, &Object::Dictionary(objects.font))?;
+            pdf_writer.write_object_with_id(ids.descendant, &Object::Dictionary(objects.descendant))?;
+            pdf_writer.write_object_with_id(ids.descriptor, &Object::Dictionary(objects.descriptor))?;
+            pdf_writer.write_object_with_id(ids.font_file, &Object::Stream(font_file))?;
+            pdf_writer.write_object_with_id(ids.to_unicode, &Object::Stream(to_unicode))?;
+            if let (Some(id), Some(cid_set)) = (ids.cid_set, objects.cid_set) {
+                pdf_writer.write_object_with_id(id, &Object::Stream(cid_set))?;
+            }
+        }
+        Ok(())
+    }
 
-            // Write content stream (with optional compression)
-            let content_stream = page.build_content_stream();
+    /// Writes the objects copied from another file under `ids`.
+    #[cfg(feature = "parser")]
+    fn write_imported_objects<W: Write>(
+        &self,
+        pdf_writer: &mut PdfWriter<W>,
+        set: &crate::page::ImportedObjects,
+        ids: &[ObjectId],
+    ) -> PdfResult<()> {
+        for (object, id) in set.renumbered(ids).zip(ids) {
             #[cfg(feature = "compression")]
-            let content_stream = if self.compress_streams {
-                content_stream.with_compression()?
-            } else {
-                content_stream
+            let object = match object {
+                Object::Stream(stream) => Object::Stream(self.compress(stream)?),
+                other => other,
             };
-            pdf_writer.write_object_with_id(content_id, &Object::Stream(content_stream))?;
+            pdf_writer.write_object_with_id(*id, &object)?;
+        }
+        Ok(())
+    }
 
-            // Write font objects
-            for (j, (_, font_id)) in page_fonts.iter().enumerate() {
-                let (_, font) = &page.fonts[j];
-                let font_dict = font.to_dictionary();
-                pdf_writer.write_object_with_id(*font_id, &Object::Dictionary(font_dict))?;
-            }
+    /// Writes the file specifications and embedded files of the attachments.
+    fn write_attachments<W: Write>(&self, pdf_writer: &mut PdfWriter<W>, ids: &[AttachmentIds]) -> PdfResult<()> {
+        for (attachment, ids) in self.attachments.iter().zip(ids) {
+            let (filespec, file) = attachment_objects(attachment, ids);
+            #[cfg(feature = "compression")]
+            let file = self.compress(file)?;
+            pdf_writer.write_object_with_id(ids.filespec, &Object::Dictionary(filespec))?;
+            pdf_writer.write_object_with_id(ids.file, &Object::Stream(file))?;
+        }
+        Ok(())
+    }
 
-            // Write image XObject streams
-            #[cfg(feature = "images")]
-            {
-                use crate::image::ImageXObject;
+    /// Builds the catalog.
+    fn catalog(&self, ids: &CatalogIds<'_>) -> PdfDictionary {
+        let mut catalog = PdfDictionary::new();
+        catalog.set("Type", Object::Name(PdfName::catalog()));
+        catalog.set("Pages", Object::Reference(ids.pages));
 
-                for (j, (_, image_id, mask_id)) in page_images.iter().enumerate() {
-                    let (_, image) = &page.images[j];
-                    let xobject = ImageXObject::from_image_with_mask_ref(image, *mask_id);
+        // Add outline reference, and show the bookmark panel on open unless
+        // the initial view picks another
+        if let Some(outline_root_id) = ids.outline_root {
+            catalog.set("Outlines", Object::Reference(outline_root_id));
+        }
+        if let Some(mode) = self.initial_view.page_mode(ids.outline_root.is_some()) {
+            catalog.set("PageMode", mode);
+        }
+        if let Some(layout) = self.initial_view.page_layout() {
+            catalog.set("PageLayout", layout);
+        }
+        if let (Some(zoom), Some(&first_page)) = (self.initial_view.zoom, ids.page_ids.first()) {
+            catalog.set("OpenAction", Object::Array(open_action(zoom, first_page)));
+        }
+        if let Some(language) = &self.language {
+            catalog.set("Lang", Object::String(PdfString::text(language)));
+        }
 
-                    // Write soft mask first if present
-                    if let (Some(mask_id), Some(mask_stream)) = (mask_id, xobject.soft_mask) {
-                        pdf_writer.write_object_with_id(*mask_id, &Object::Stream(mask_stream))?;
-                    }
+        // Add AcroForm reference if forms exist
+        if let Some(acroform_id) = ids.acroform {
+            catalog.set("AcroForm", Object::Reference(acroform_id));
+        }
+
+        // Mark the document as tagged
+        if let Some(struct_tree_id) = ids.struct_tree {
+            catalog.set("StructTreeRoot", Object::Reference(struct_tree_id));
+            let mut mark_info = PdfDictionary::new();
+            mark_info.set("Marked", Object::Boolean(true));
+            catalog.set("MarkInfo", Object::Dictionary(mark_info));
+        }
 
-                    // Write main image stream
-                    pdf_writer.write_object_with_id(*image_id, &Object::Stream(xobject.stream))?;
+        // List the layers for the viewer's layers panel
+        if !self.layers.is_empty() {
+            catalog.set("OCProperties", Object::Dictionary(oc_properties(&self.layers, ids.layers)));
+        }
+
+        // Add the XMP metadata and PDF/A output intent
+        if let Some(metadata_id) = ids.metadata {
+            catalog.set("Metadata", Object::Reference(metadata_id));
+        }
+        if let Some(profile_id) = ids.profile {
+            catalog.set("OutputIntents", Object::Array(pdfa::output_intents(profile_id)));
+        }
+
+        // Add the name trees of destinations and embedded files, and list
+        // files with a relationship to the document as associated files
+        let mut names = PdfDictionary::new();
+        if !self.destinations.is_empty() {
+            names.set("Dests", Object::Dictionary(dests_tree(&self.destinations, ids.page_ids)));
+        }
+        if !self.attachments.is_empty() {
+            let tree = embedded_files_tree(&self.attachments, ids.attachments);
+            names.set("EmbeddedFiles", Object::Dictionary(tree));
+
+            let mut associated = PdfArray::new();
+            for (attachment, ids) in self.attachments.iter().zip(ids.attachments) {
+                if attachment.relationship.is_some() {
+                    associated.push(Object::Reference(ids.filespec));
                 }
             }
+            if !associated.is_empty() {
+                catalog.set("AF", Object::Array(associated));
+            }
+        }
+        if !names.is_empty() {
+            catalog.set("Names", Object::Dictionary(names));
+        }
+
+        catalog
+    }
 
-            // Write form field dictionaries and appearances for this page
-            let page_form_ids = &form_field_ids[i];
-            for (j, field_ids) in page_form_ids.iter().enumerate() {
-                let field = &page.form_fields[j];
-                write_form_field(&mut pdf_writer, field, field_ids, page_id)?;
+    /// Writes page `i` with its content stream, resources and annotations,
+    /// except embedded fonts and imported objects, which pages share.
+    fn write_page<W: Write>(
+        &self,
+        pdf_writer: &mut PdfWriter<W>,
+        page: &Page,
+        i: usize,
+        ids: &PageIds<'_>,
+        context: &PageContext<'_>,
+    ) -> PdfResult<()> {
+        let page_id = ids.page;
+        let content_id = ids.content;
+        let page_fonts = ids.fonts;
+
+        // Build font resources dictionary
+        let mut font_dict = PdfDictionary::new();
+        for (font_name, font_id) in page_fonts {
+            font_dict.set(font_name, Object::Reference(*font_id));
+        }
+        if let Some(font_id) = context.overlay_font_id {
+            if self.page_numbers.is_some() {
+                font_dict.set(PAGE_NUMBER_FONT_NAME, Object::Reference(font_id));
+            }
+            if self.watermark.is_some() {
+                font_dict.set(WATERMARK_FONT_NAME, Object::Reference(font_id));
             }
         }
 
-        // Write AcroForm dictionary if forms exist
-        if let Some(acroform_id) = acroform_id {
-            let mut acroform = PdfDictionary::new();
+        // Build XObject resources dictionary for images and imported pages
+        #[cfg(feature = "images")]
+        let page_images = ids.images;
 
-            // Collect all field references
-            let mut fields = PdfArray::new();
-            for page_field_ids in &form_field_ids {
-                for field_ids in page_field_ids {
-                    fields.push(Object::Reference(field_ids.field_id));
-                }
+        #[cfg(any(feature = "images", feature = "parser"))]
+        let mut xobject_dict = PdfDictionary::new();
+        #[cfg(feature = "images")]
+        for (image_name, image_id, _) in page_images {
+            xobject_dict.set(image_name, Object::Reference(*image_id));
+        }
+        #[cfg(feature = "parser")]
+        for (name, imported) in &page.imported {
+            if let Some(set) = find_imported_objects(context.imported_sets, imported.objects()) {
+                xobject_dict.set(name, Object::Reference(context.imported_ids[set][imported.form()]));
+            }
+        }
+
+        // Build resources dictionary
+        let mut resources = PdfDictionary::new();
+        if !font_dict.is_empty() {
+            resources.set("Font", Object::Dictionary(font_dict));
+        }
+        #[cfg(any(feature = "images", feature = "parser"))]
+        if !xobject_dict.is_empty() {
+            resources.set("XObject", Object::Dictionary(xobject_dict));
+        }
+        if !ids.patterns.is_empty() {
+            let mut pattern_dict = PdfDictionary::new();
+            let gradients = page.patterns.iter().map(|(name, _)| name);
+            let names = gradients.chain(page.tiling_patterns.iter().map(|(name, _)| name));
+            for (name, id) in names.zip(ids.patterns) {
+                pattern_dict.set(name, Object::Reference(*id));
+            }
+            resources.set("Pattern", Object::Dictionary(pattern_dict));
+        }
+        if !context.layer_ids.is_empty() {
+            let mut properties = PdfDictionary::new();
+            for (index, id) in context.layer_ids.iter().enumerate() {
+                properties.set(Layer::resource_name(index), Object::Reference(*id));
+            }
+            resources.set("Properties", Object::Dictionary(properties));
+        }
+        let mut gstate_dict = PdfDictionary::new();
+        if let Some(gstate_id) = context.watermark_gstate_id {
+            gstate_dict.set(WATERMARK_GSTATE_NAME, Object::Reference(gstate_id));
+        }
+        for overprint in &page.overprints {
+            gstate_dict.set(overprint.resource_name(), Object::Dictionary(overprint.to_ext_gstate()));
+        }
+        if !gstate_dict.is_empty() {
+            resources.set("ExtGState", Object::Dictionary(gstate_dict));
+        }
+        for (category, name, object) in &page.extra_resources {
+            let mut dict = match resources.remove(category.key()) {
+                Some(Object::Dictionary(dict)) => dict,
+                _ => PdfDictionary::new(),
+            };
+            if !dict.contains_key(name) {
+                dict.set(name.as_str(), object.clone());
             }
-            acroform.set("Fields", Object::Array(fields));
+            resources.set(category.key(), Object::Dictionary(dict));
+        }
 
-            // Default appearance string (DA)
-            acroform.set("DA", Object::String(PdfString::literal("/Helv 12 Tf 0 g")));
+        // Build page dictionary
+        let mut page_dict = PdfDictionary::new();
+        page_dict.set("Type", Object::Name(PdfName::page()));
+        page_dict.set("Parent", Object::Reference(context.pages_id));
 
-            // Default resources for form fields
-            let mut dr = PdfDictionary::new();
-            let mut font_dict = PdfDictionary::new();
+        // MediaBox and the print boundaries
+        page_dict.set("MediaBox", Object::Array(rect_to_array(&page.media_box)));
+        for kind in PageBox::ALL {
+            if let Some(rect) = page.page_box(kind) {
+                page_dict.set(kind.key(), Object::Array(rect_to_array(&rect)));
+            }
+        }
+        let rotation = page.rotation.rem_euclid(360);
+        if rotation != 0 {
+            page_dict.set("Rotate", Object::Integer(rotation as i64));
+        }
 
-            // Add Helvetica as default form font
-            let mut helv = PdfDictionary::new();
-            helv.set("Type", Object::Name(PdfName::new_unchecked("Font")));
-            helv.set("Subtype", Object::Name(PdfName::new_unchecked("Type1")));
-            helv.set("BaseFont", Object::Name(PdfName::new_unchecked("Helvetica")));
-            helv.set("Encoding", Object::Name(PdfName::new_unchecked("WinAnsiEncoding")));
-            font_dict.set("Helv", Object::Dictionary(helv));
+        // Resources
+        if !resources.is_empty() {
+            page_dict.set("Resources", Object::Dictionary(resources));
+        }
 
-            // Add ZapfDingbats for checkmarks
-            let mut zadb = PdfDictionary::new();
-            zadb.set("Type", Object::Name(PdfName::new_unchecked("Font")));
-            zadb.set("Subtype", Object::Name(PdfName::new_unchecked("Type1")));
-            zadb.set("BaseFont", Object::Name(PdfName::new_unchecked("ZapfDingbats")));
-            font_dict.set("ZaDb", Object::Dictionary(zadb));
+        // Contents
+        page_dict.set("Contents", Object::Reference(content_id));
+        if let Some(thumbnail_id) = ids.thumbnail {
+            page_dict.set("Thumb", Object::Reference(thumbnail_id));
+        }
+        if let Some(key) = ids.struct_parents {
+            page_dict.set("StructParents", Object::Integer(key));
+        }
 
-            dr.set("Font", Object::Dictionary(font_dict));
-            acroform.set("DR", Object::Dictionary(dr));
+        // Annotations (form fields, links and markup) - Note: For radio groups, we add each button widget
+        let page_form_ids = ids.form_fields;
+        let page_link_ids = ids.links;
+        let page_annotation_ids = ids.annotations;
+        if !page_form_ids.is_empty() || !page_link_ids.is_empty() || !page_annotation_ids.is_empty() {
+            let mut annots = PdfArray::new();
+            for field_ids in page_form_ids {
+                if !field_ids.radio_widget_ids.is_empty() {
+                    // Radio group: add each button widget as annotation
+                    for (widget_id, _, _) in &field_ids.radio_widget_ids {
+                        annots.push(Object::Reference(*widget_id));
+                    }
+                } else {
+                    // Regular field: field itself is the widget
+                    annots.push(Object::Reference(field_ids.field_id));
+                }
+            }
+            for link_id in page_link_ids {
+                annots.push(Object::Reference(*link_id));
+            }
+            for (annotation_id, _) in page_annotation_ids {
+                annots.push(Object::Reference(*annotation_id));
+            }
+            page_dict.set("Annots", Object::Array(annots));
+        }
 
-            // NeedAppearances flag - let viewer generate appearances if needed
-            acroform.set("NeedAppearances", Object::Boolean(false));
+        pdf_writer.write_object_with_id(page_id, &Object::Dictionary(page_dict))?;
+
+        // Write content stream (with optional compression)
+        let mut overlays = Vec::new();
+        if let Some(numbers) = &self.page_numbers {
+            overlays.push(numbers.overlay(
+                &page.media_box,
+                self.margins.as_ref(),
+                i + 1,
+                context.page_count,
+            ));
+        }
+        if let Some(watermark) = &self.watermark {
+            overlays.push(watermark.overlay(&page.media_box));
+        }
+        let precision = self.coordinate_precision;
+        let content = if overlays.is_empty() {
+            page.content.build_balanced_string_with_precision(precision)
+        } else {
+            stamp_overlays(&page.content, &overlays, context.tagged, precision)
+        };
+        let content_stream = PdfStream::from_text(paint_background(page, content, context.tagged, precision));
+        #[cfg(feature = "compression")]
+        let content_stream = self.compress(content_stream)?;
+        pdf_writer.write_object_with_id(content_id, &Object::Stream(content_stream))?;
 
-            pdf_writer.write_object_with_id(acroform_id, &Object::Dictionary(acroform))?;
+        if let (Some(thumbnail), Some(thumbnail_id)) = (&page.thumbnail, ids.thumbnail) {
+            let stream = thumbnail.to_stream();
+            #[cfg(feature = "compression")]
+            let stream = self.compress(stream)?;
+            pdf_writer.write_object_with_id(thumbnail_id, &Object::Stream(stream))?;
         }
 
-        // Write info dictionary if present
-        if let Some(info_id) = info_id {
-            let info_dict = self.info.to_dictionary();
-            pdf_writer.write_object_with_id(info_id, &Object::Dictionary(info_dict))?;
+        // Write font objects (embedded fonts are written after all pages)
+        for (j, (_, font_id)) in page_fonts.iter().enumerate() {
+            let (_, font) = &page.fonts[j];
+            if font.as_truetype().is_some() {
+                continue;
+            }
+            let font_dict = font.to_dictionary();
+            pdf_writer.write_object_with_id(*font_id, &Object::Dictionary(font_dict))?;
         }
 
-        // Write encryption dictionary if encryption is enabled
-        // NOTE: The encryption dictionary must NOT be encrypted itself!
-        #[cfg(feature = "encryption")]
-        if let (Some(encrypt_id), Some(ref handler)) = (encrypt_id, &encryption_handler) {
-            let encrypt_dict = handler.create_encrypt_dictionary();
-            pdf_writer.write_object_unencrypted(encrypt_id, &Object::Dictionary(encrypt_dict))?;
+        // Write gradient and tiling patterns
+        let (gradient_ids, tiling_ids) = ids.patterns.split_at(page.patterns.len());
+        for ((_, gradient), id) in page.patterns.iter().zip(gradient_ids) {
+            pdf_writer.write_object_with_id(*id, &Object::Dictionary(gradient.to_pattern()))?;
+        }
+        for ((_, pattern), id) in page.tiling_patterns.iter().zip(tiling_ids) {
+            let stream = pattern.to_stream();
+            #[cfg(feature = "compression")]
+            let stream = self.compress(stream)?;
+            pdf_writer.write_object_with_id(*id, &Object::Stream(stream))?;
         }
 
-        // Write trailer
-        #[cfg(feature = "encryption")]
+        // Write image XObject streams
+        #[cfg(feature = "images")]
         {
-            if let (Some(encrypt_id), Some(ref handler)) = (encrypt_id, &encryption_handler) {
-                pdf_writer.write_trailer_with_encryption(
-                    catalog_id,
-                    info_id,
-                    Some(encrypt_id),
-                    Some(handler.file_id()),
-                )?;
-            } else {
-                pdf_writer.write_trailer(catalog_id, info_id)?;
+            use crate::image::ImageXObject;
+
+            for (j, (_, image_id, mask_id)) in page_images.iter().enumerate() {
+                let (_, image) = &page.images[j];
+                let xobject = ImageXObject::from_image_with_mask_ref(image, *mask_id);
+
+                // Write soft mask first if present
+                if let (Some(mask_id), Some(mask_stream)) = (mask_id, xobject.soft_mask) {
+                    pdf_writer.write_object_with_id(*mask_id, &Object::Stream(mask_stream))?;
+                }
+
+                // Write main image stream
+                pdf_writer.write_object_with_id(*image_id, &Object::Stream(xobject.stream))?;
             }
         }
 
-        #[cfg(not(feature = "encryption"))]
-        pdf_writer.write_trailer(catalog_id, info_id)?;
+        // Write form field dictionaries and appearances for this page
+        let page_form_ids = ids.form_fields;
+        for (j, field_ids) in page_form_ids.iter().enumerate() {
+            let field = &page.form_fields[j];
+            write_form_field(pdf_writer, field, field_ids, page_id)?;
+        }
 
+        // Write link annotations for this page
+        for (link, link_id) in page.links.iter().zip(page_link_ids) {
+            if let LinkTarget::Named(name) = &link.target {
+                if !self.destinations.iter().any(|d| &d.name == name) {
+                    return Err(DocumentError::InvalidLink(format!(
+                        "link on page {} targets the destination '{}', which does not exist",
+                        i, name
+                    ))
+                    .into());
+                }
+            }
+            let annotation = link.to_annotation(context.page_ids).ok_or_else(|| {
+                DocumentError::InvalidLink(format!(
+                    "link on page {} targets a page that does not exist (page count is {})",
+                    i,
+                    context.page_count
+                ))
+            })?;
+            let mut annotation = annotation;
+            if self.pdfa.is_some() {
+                // PDF/A requires every annotation to be printable
+                annotation.set("F", Object::Integer(4));
+            }
+            pdf_writer.write_object_with_id(*link_id, &Object::Dictionary(annotation))?;
+        }
+
+        // Write highlights, notes and stamps for this page
+        for (annotation, (annotation_id, appearance_id)) in page.annotations.iter().zip(page_annotation_ids) {
+            let dict = annotation.to_annotation(Object::Reference(*appearance_id));
+            pdf_writer.write_object_with_id(*annotation_id, &Object::Dictionary(dict))?;
+            pdf_writer.write_object_with_id(*appearance_id, &Object::Stream(annotation.appearance()))?;
+        }
         Ok(())
     }
 }
@@ -441,8 +1515,24 @@ pub struct DocumentBuilder {
     version: PdfVersion,
     info: DocumentInfo,
     pages: Vec<Page>,
+    page_numbers: Option<PageNumbers>,
+    watermark: Option<Watermark>,
+    margins: Option<Margins>,
+    initial_view: InitialView,
+    language: Option<String>,
     #[cfg(feature = "compression")]
     compress_streams: bool,
+    #[cfg(feature = "compression")]
+    compression_level: Option<u32>,
+    #[cfg(feature = "compression")]
+    compress_min_size: usize,
+    pdfa: Option<PdfAConformance>,
+    xmp: Option<XmpMetadata>,
+    file_id: Option<Vec<u8>>,
+    object_streams: bool,
+    linearized: bool,
+    deduplicate: bool,
+    coordinate_precision: Option<usize>,
     #[cfg(feature = "encryption")]
     encryption: Option<EncryptionConfig>,
 }
@@ -513,6 +1603,86 @@ impl DocumentBuilder {
         self
     }
 
+    /// Stamps page numbers on every page.
+    pub fn page_numbers(mut self, numbers: PageNumbers) -> Self {
+        self.page_numbers = Some(numbers);
+        self
+    }
+
+    /// Stamps a watermark on every page.
+    pub fn watermark(mut self, watermark: Watermark) -> Self {
+        self.watermark = Some(watermark);
+        self
+    }
+
+    /// Sets the margins around the content area of every page.
+    pub fn margins(mut self, margins: Margins) -> Self {
+        self.margins = Some(margins);
+        self
+    }
+
+    /// Sets the zoom, page layout and panel the document opens with.
+    pub fn initial_view(mut self, view: InitialView) -> Self {
+        self.initial_view = view;
+        self
+    }
+
+    /// Sets the natural language of the text, as a BCP 47 tag such as
+    /// `en-US` or `ar-EG`. The tag is checked when the document is written.
+    pub fn language(mut self, tag: impl Into<String>) -> Self {
+        self.language = Some(tag.into());
+        self
+    }
+
+    /// Requires the document to conform to a PDF/A level when written.
+    pub fn pdfa(mut self, level: PdfAConformance) -> Self {
+        self.pdfa = Some(level);
+        self
+    }
+
+    /// Sets the XMP metadata written to the catalog.
+    pub fn xmp(mut self, xmp: XmpMetadata) -> Self {
+        self.xmp = Some(xmp);
+        self
+    }
+
+    /// Sets the file identifier written to the trailer, so that writing the
+    /// same document again gives the same bytes.
+    pub fn file_id(mut self, id: impl Into<Vec<u8>>) -> Self {
+        self.file_id = Some(id.into());
+        self
+    }
+
+    /// Packs objects into object streams with a cross-reference stream,
+    /// which makes documents with many objects smaller.
+    ///
+    /// Ignored for versions before PDF 1.5 and for PDF/A-1b.
+    pub fn object_streams(mut self, enabled: bool) -> Self {
+        self.object_streams = enabled;
+        self
+    }
+
+    /// Lays the file out for fast web view, so viewers can show the first
+    /// page before the whole file has downloaded.
+    pub fn linearized(mut self, enabled: bool) -> Self {
+        self.linearized = enabled;
+        self
+    }
+
+    /// Writes identical objects, such as an image drawn on every page, only
+    /// once.
+    pub fn deduplicate(mut self, enabled: bool) -> Self {
+        self.deduplicate = enabled;
+        self
+    }
+
+    /// Rounds numbers in page content streams to `decimals` places instead
+    /// of [`DEFAULT_PRECISION`], which makes drawing-heavy pages smaller.
+    pub fn coordinate_precision(mut self, decimals: usize) -> Self {
+        self.coordinate_precision = Some(decimals);
+        self
+    }
+
     /// Enables compression for all content streams.
     ///
     /// When enabled, all page content streams will be compressed using
@@ -523,9 +1693,25 @@ impl DocumentBuilder {
         self
     }
 
+    /// Sets the Flate level used by `compress_streams`, from 0 (fastest) to
+    /// 9 (smallest). The default is 6.
+    #[cfg(feature = "compression")]
+    pub fn compression_level(mut self, level: u32) -> Self {
+        self.compression_level = Some(level);
+        self
+    }
+
+    /// Leaves streams shorter than `bytes` uncompressed when
+    /// `compress_streams` is on. The default of 0 compresses every stream.
+    #[cfg(feature = "compression")]
+    pub fn compress_min_size(mut self, bytes: usize) -> Self {
+        self.compress_min_size = bytes;
+        self
+    }
+
     /// Enables encryption with the given configuration.
     ///
-    /// When enabled, the document will be encrypted using AES-256.
+    /// When enabled, the document will be encrypted using the configured algorithm.
     ///
     /// # Example
     ///
@@ -559,14 +1745,132 @@ impl DocumentBuilder {
             version: self.version,
             info: self.info,
             pages: self.pages,
+            page_numbers: self.page_numbers,
+            watermark: self.watermark,
+            margins: self.margins,
+            bookmarks: Vec::new(),
+            attachments: Vec::new(),
+            destinations: Vec::new(),
+            initial_view: self.initial_view,
+            language: self.language,
+            structure: Vec::new(),
+            layers: Vec::new(),
+            pdfa: self.pdfa,
+            xmp: self.xmp,
+            file_id: self.file_id,
+            object_streams: self.object_streams,
+            linearized: self.linearized,
+            deduplicate: self.deduplicate,
+            coordinate_precision: self.coordinate_precision.unwrap_or(DEFAULT_PRECISION),
             #[cfg(feature = "compression")]
             compress_streams: self.compress_streams,
+            #[cfg(feature = "compression")]
+            compression_level: self.compression_level.unwrap_or(DEFAULT_COMPRESSION_LEVEL),
+            #[cfg(feature = "compression")]
+            compress_min_size: self.compress_min_size,
             #[cfg(feature = "encryption")]
             encryption: self.encryption,
+            #[cfg(feature = "parser")]
+            original: None,
+        })
+    }
+}
+
+/// Appends overlays to a page's content stream.
+///
+/// The page's own content is wrapped in `q`/`Q` so its graphics state does
+/// not affect the overlays. In a tagged document the overlays are marked as
+/// artifacts, which assistive technology skips. Numbers are rounded to
+/// `precision` decimal places.
+fn stamp_overlays(content: &ContentBuilder, overlays: &[ContentBuilder], artifacts: bool, precision: usize) -> String {
+    let overlays: Vec<String> = overlays
+        .iter()
+        .map(|overlay| {
+            let overlay = overlay.build_string_with_precision(precision);
+            if artifacts {
+                format!("/Artifact BMC\n{}\nEMC", overlay)
+            } else {
+                overlay
+            }
         })
+        .collect();
+    let page = content.build_balanced_string_with_precision(precision);
+    if page.is_empty() {
+        overlays.join("\n")
+    } else {
+        format!("q\n{}\nQ\n{}", page, overlays.join("\n"))
     }
 }
 
+/// Puts the page's background, if any, in front of its content stream so
+/// everything else paints over it. In a tagged document the background is
+/// marked as an artifact.
+fn paint_background(page: &Page, content: String, artifact: bool, precision: usize) -> String {
+    let background = match page.background_content() {
+        Some(background) => background.build_string_with_precision(precision),
+        None => return content,
+    };
+    let background = if artifact {
+        format!("/Artifact BMC\n{}\nEMC", background)
+    } else {
+        background
+    };
+    if content.is_empty() {
+        background
+    } else {
+        format!("{}\n{}", background, content)
+    }
+}
+
+/// Object IDs the catalog refers to.
+struct CatalogIds<'a> {
+    pages: ObjectId,
+    page_ids: &'a [ObjectId],
+    outline_root: Option<ObjectId>,
+    acroform: Option<ObjectId>,
+    struct_tree: Option<ObjectId>,
+    layers: &'a [ObjectId],
+    metadata: Option<ObjectId>,
+    profile: Option<ObjectId>,
+    attachments: &'a [AttachmentIds],
+}
+
+/// Object IDs allocated for one page and the objects only it uses.
+struct PageIds<'a> {
+    page: ObjectId,
+    content: ObjectId,
+    fonts: &'a [(String, ObjectId)],
+    /// Gradients, then tiling patterns.
+    patterns: &'a [ObjectId],
+    /// (name, image, soft mask) of each image.
+    #[cfg(feature = "images")]
+    images: &'a [(String, ObjectId, Option<ObjectId>)],
+    form_fields: &'a [FormFieldIds],
+    links: &'a [ObjectId],
+    /// (annotation, appearance stream) of each markup annotation.
+    annotations: &'a [(ObjectId, ObjectId)],
+    thumbnail: Option<ObjectId>,
+    struct_parents: Option<i64>,
+}
+
+/// Object IDs every page may refer to.
+struct PageContext<'a> {
+    pages_id: ObjectId,
+    /// The pages links may point at.
+    page_ids: &'a [ObjectId],
+    /// Page count shown by the page numbers.
+    page_count: usize,
+    overlay_font_id: Option<ObjectId>,
+    watermark_gstate_id: Option<ObjectId>,
+    layer_ids: &'a [ObjectId],
+    /// Whether the document has a structure tree.
+    tagged: bool,
+    #[cfg(feature = "parser")]
+    imported_sets: &'a [Arc<crate::page::ImportedObjects>],
+    #[cfg(feature = "parser")]
+    imported_ids: &'a [Vec<ObjectId>],
+}
+
 /// Helper struct for tracking form field object IDs.
 struct FormFieldIds {
     field_id: ObjectId,
@@ -575,6 +1879,77 @@ struct FormFieldIds {
     radio_widget_ids: Vec<(ObjectId, ObjectId, ObjectId)>, // (widget_id, ap_on, ap_off)
 }
 
+/// Builds the AcroForm dictionary listing `fields`.
+fn acroform_dictionary(fields: PdfArray) -> PdfDictionary {
+    let mut acroform = PdfDictionary::new();
+    acroform.set("Fields", Object::Array(fields));
+
+    // Default appearance string (DA)
+    acroform.set("DA", Object::String(PdfString::literal("/Helv 12 Tf 0 g")));
+
+    // Default resources for form fields
+    let mut dr = PdfDictionary::new();
+    let mut font_dict = PdfDictionary::new();
+
+    // Add Helvetica as default form font
+    let mut helv = PdfDictionary::new();
+    helv.set("Type", Object::Name(PdfName::new_unchecked("Font")));
+    helv.set("Subtype", Object::Name(PdfName::new_unchecked("Type1")));
+    helv.set("BaseFont", Object::Name(PdfName::new_unchecked("Helvetica")));
+    helv.set("Encoding", Object::Name(PdfName::new_unchecked("WinAnsiEncoding")));
+    font_dict.set("Helv", Object::Dictionary(helv));
+
+    // Add ZapfDingbats for checkmarks
+    let mut zadb = PdfDictionary::new();
+    zadb.set("Type", Object::Name(PdfName::new_unchecked("Font")));
+    zadb.set("Subtype", Object::Name(PdfName::new_unchecked("Type1")));
+    zadb.set("BaseFont", Object::Name(PdfName::new_unchecked("ZapfDingbats")));
+    font_dict.set("ZaDb", Object::Dictionary(zadb));
+
+    dr.set("Font", Object::Dictionary(font_dict));
+    acroform.set("DR", Object::Dictionary(dr));
+
+    // NeedAppearances flag - let viewer generate appearances if needed
+    acroform.set("NeedAppearances", Object::Boolean(false));
+
+    acroform
+}
+
+/// Allocates the IDs of a form field, its appearances and, for a radio
+/// group, each button's widget.
+fn allocate_form_field_ids<W: Write>(pdf_writer: &mut PdfWriter<W>, field: &FormField) -> FormFieldIds {
+    let field_id = pdf_writer.allocate_id();
+    let ap_normal_id = pdf_writer.allocate_id();
+
+    // For checkboxes, radio buttons, we need both on and off appearances
+    let ap_down_id = match field.field_type {
+        FormFieldType::CheckBox | FormFieldType::RadioButton => Some(pdf_writer.allocate_id()),
+        _ => None,
+    };
+
+    let radio_widget_ids: Vec<(ObjectId, ObjectId, ObjectId)> = if field.field_type == FormFieldType::RadioButton {
+        field
+            .radio_buttons
+            .iter()
+            .map(|_| {
+                let widget_id = pdf_writer.allocate_id();
+                let ap_on = pdf_writer.allocate_id();
+                let ap_off = pdf_writer.allocate_id();
+                (widget_id, ap_on, ap_off)
+            })
+            .collect()
+    } else {
+        Vec::new()
+    };
+
+    FormFieldIds {
+        field_id,
+        ap_normal_id,
+        ap_down_id,
+        radio_widget_ids,
+    }
+}
+
 /// Writes a form field and its appearances to the PDF.
 fn write_form_field<W: Write>(
     pdf_writer: &mut PdfWriter<W>,
@@ -606,6 +1981,25 @@ fn write_form_field<W: Write>(
     Ok(())
 }
 
+/// Returns an appearance builder for a field's rectangle, borders and colors.
+fn field_appearance_builder(field: &FormField) -> AppearanceBuilder {
+    AppearanceBuilder::new(field.rect.with_origin())
+        .background_color(field.background_color.unwrap_or(crate::color::Color::WHITE))
+        .border_color(field.border_color.unwrap_or(crate::color::Color::BLACK))
+        .border_style(field.border_style)
+        .border_width(field.border_width)
+}
+
+/// Builds the normal appearance of a text field showing its current value.
+fn text_field_appearance(field: &FormField) -> String {
+    field_appearance_builder(field).build_text_appearance(
+        field.value.as_deref(),
+        "Helv",
+        field.font_size,
+        field.text_color,
+    )
+}
+
 /// Writes a text field.
 fn write_text_field<W: Write>(
     pdf_writer: &mut PdfWriter<W>,
@@ -674,18 +2068,7 @@ fn write_text_field<W: Write>(
     }
 
     // Build and set appearance
-    let builder = AppearanceBuilder::new(field.rect.with_origin())
-        .background_color(field.background_color.unwrap_or(crate::color::Color::WHITE))
-        .border_color(field.border_color.unwrap_or(crate::color::Color::BLACK))
-        .border_style(field.border_style)
-        .border_width(field.border_width);
-
-    let ap_stream = builder.build_text_appearance(
-        field.value.as_deref(),
-        "Helv",
-        field.font_size,
-        field.text_color,
-    );
+    let ap_stream = text_field_appearance(field);
 
     // Create appearance stream
     let mut ap_dict = PdfDictionary::new();
@@ -754,12 +2137,7 @@ fn write_checkbox<W: Write>(
     dict.set("MK", Object::Dictionary(mk));
 
     // Build appearances
-    let builder = AppearanceBuilder::new(field.rect.with_origin())
-        .background_color(field.background_color.unwrap_or(crate::color::Color::WHITE))
-        .border_color(field.border_color.unwrap_or(crate::color::Color::BLACK))
-        .border_style(field.border_style)
-        .border_width(field.border_width);
-
+    let builder = field_appearance_builder(field);
     let checked_stream = builder.build_checkbox_checked(field.text_color);
     let unchecked_stream = builder.build_checkbox_unchecked();
 
@@ -1188,6 +2566,28 @@ fn write_pushbutton<W: Write>(
     Ok(())
 }
 
+/// Checks that page `index` has a rotation that is a multiple of 90 degrees
+/// and boundaries within its media box.
+fn check_page(index: usize, page: &Page) -> PdfResult<()> {
+    if page.rotation % 90 != 0 {
+        return Err(DocumentError::InvalidRotation { page: index, degrees: page.rotation }.into());
+    }
+    let media_box = Rectangle::new(
+        page.media_box.llx.min(page.media_box.urx),
+        page.media_box.lly.min(page.media_box.ury),
+        page.media_box.llx.max(page.media_box.urx),
+        page.media_box.lly.max(page.media_box.ury),
+    );
+    for kind in PageBox::ALL {
+        if let Some(rect) = page.page_box(kind) {
+            if rect.width() <= 0.0 || rect.height() <= 0.0 || !media_box.contains(&rect) {
+                return Err(DocumentError::InvalidPageBox { page: index, name: kind.key() }.into());
+            }
+        }
+    }
+    Ok(())
+}
+
 /// Converts a rectangle to a PDF array.
 fn rect_to_array(rect: &crate::types::Rectangle) -> PdfArray {
     let mut arr = PdfArray::new();
@@ -1255,7 +2655,8 @@ fn create_appearance_stream(content: &str, bbox: &crate::types::Rectangle) -> Pd
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::page::PageBuilder;
+    use crate::color::Color;
+    use crate::page::{PageBuilder, ResourceType};
 
     #[test]
     fn test_document_builder() {
@@ -1290,4 +2691,861 @@ mod tests {
         assert!(content.starts_with("%PDF-1.7"));
         assert!(content.contains("%%EOF"));
     }
+
+    #[test]
+    fn test_write_with_progress() {
+        let mut builder = DocumentBuilder::new();
+        for _ in 0..4 {
+            builder = builder.page(PageBuilder::a4().build());
+        }
+        let doc = builder.build().unwrap();
+
+        let mut fractions = Vec::new();
+        doc.save_to_bytes_with_progress(|f| {
+            fractions.push(f);
+            true
+        })
+        .unwrap();
+        assert_eq!(fractions, [0.0, 0.25, 0.5, 0.75]);
+        assert!(matches!(doc.save_to_bytes_with_progress(|f| f < 0.5), Err(PdfError::Cancelled)));
+    }
+
+    #[test]
+    fn test_page_numbers_resolved_on_write() {
+        let mut doc = DocumentBuilder::new()
+            .pages([PageBuilder::a4().build(), PageBuilder::a4().build()])
+            .page_numbers(PageNumbers::new("Page {page} of {total}"))
+            .build()
+            .unwrap();
+        doc.add_page(PageBuilder::a4().build());
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+
+        assert!(content.contains("(Page 1 of 3) Tj"));
+        assert!(content.contains("(Page 3 of 3) Tj"));
+        assert!(content.contains("/FPageNum"));
+        assert_eq!(content.matches("/BaseFont /Helvetica").count(), 1);
+    }
+
+    #[test]
+    fn test_watermark_applied_on_write() {
+        let mut doc = DocumentBuilder::new()
+            .page(PageBuilder::a4().build())
+            .page_numbers(PageNumbers::new("{page}"))
+            .watermark(Watermark::new("DRAFT").opacity(0.4))
+            .build()
+            .unwrap();
+        doc.pages[0].content = ContentBuilder::new().raw("1 0 0 rg");
+        doc.add_page(PageBuilder::a4().build());
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+
+        assert!(content.contains("q\n1 0 0 rg\nQ\nq\n0 g\nBT"));
+        assert_eq!(content.matches("/GSWatermark gs").count(), 2);
+        assert_eq!(content.matches("/Type /ExtGState").count(), 1);
+        assert!(content.contains("/ca 0.4"));
+        assert!(content.contains("/FWatermark"));
+        assert_eq!(content.matches("/BaseFont /Helvetica").count(), 1);
+    }
+
+    #[test]
+    fn test_content_rect() {
+        let mut doc = DocumentBuilder::new()
+            .page(PageBuilder::letter().build())
+            .build()
+            .unwrap();
+        assert_eq!(doc.content_rect(0), Some(Rectangle::new(72.0, 72.0, 540.0, 720.0)));
+        assert_eq!(doc.content_rect(1), None);
+
+        doc.margins = Some(Margins::new(36.0, 18.0, 54.0, 18.0));
+        assert_eq!(doc.content_rect(0), Some(Rectangle::new(18.0, 54.0, 594.0, 756.0)));
+    }
+
+    #[test]
+    fn test_version_gates_features() {
+        let mut doc = DocumentBuilder::new()
+            .version(PdfVersion::V1_3)
+            .page(PageBuilder::letter().build())
+            .build()
+            .unwrap();
+        let bytes = doc.save_to_bytes().unwrap();
+        assert!(bytes.starts_with(b"%PDF-1.3"));
+
+        doc.watermark = Some(Watermark::new("DRAFT"));
+        let err = doc.save_to_bytes().unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "Document error: Watermark transparency requires PDF 1.4 or later, but the document is PDF 1.3"
+        );
+
+        doc.version = PdfVersion::V1_4;
+        assert!(doc.check_version().is_ok());
+        #[cfg(feature = "encryption")]
+        {
+            doc.encryption = Some(EncryptionConfig::aes256());
+            assert!(doc.check_version().is_err());
+            doc.version = PdfVersion::V1_7;
+            assert!(doc.check_version().is_ok());
+        }
+    }
+
+    #[test]
+    fn test_bookmarks_written_as_outline() {
+        let mut doc = DocumentBuilder::new()
+            .pages([PageBuilder::a4().build(), PageBuilder::a4().build()])
+            .build()
+            .unwrap();
+        let chapter = doc.add_bookmark(Bookmark::new("Chapter", 0)).unwrap();
+        doc.add_bookmark(Bookmark::new("Section", 1).at(500.0).child_of(chapter)).unwrap();
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert!(content.contains("/Outlines"));
+        assert!(content.contains("/PageMode /UseOutlines"));
+        assert!(content.contains("/Title (Section)"));
+        assert!(content.contains("/XYZ null 500 null"));
+    }
+
+    #[test]
+    fn test_initial_view() {
+        let view = InitialView::new().zoom(Zoom::FitWidth).layout(PageLayout::TwoPageRight);
+        let mut doc = DocumentBuilder::new()
+            .pages([PageBuilder::a4().build(), PageBuilder::a4().build()])
+            .initial_view(view)
+            .build()
+            .unwrap();
+        doc.add_bookmark(Bookmark::new("Cover", 0)).unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/OpenAction [3 0 R /FitH null]"));
+        assert!(content.contains("/PageLayout /TwoPageRight"));
+        assert!(content.contains("/PageMode /UseOutlines"));
+
+        doc.initial_view = doc.initial_view.mode(PageMode::UseNone).zoom(Zoom::Percent(125.0));
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/XYZ null null 1.25]"));
+        assert!(content.contains("/PageMode /UseNone"));
+        assert!(!content.contains("/UseOutlines"));
+
+        doc.version = PdfVersion::V1_4;
+        assert!(doc.check_version().is_err());
+        doc.version = PdfVersion::V1_7;
+        doc.initial_view.zoom = Some(Zoom::Percent(-10.0));
+        assert!(doc.save_to_bytes().is_err());
+    }
+
+    #[test]
+    fn test_extra_resources() {
+        let mut page = PageBuilder::a4().font("F1", Font::helvetica()).build();
+        page.add_resource(ResourceType::Font, "F1", Object::Null);
+        page.add_resource(ResourceType::Font, "Sym", Object::Name(PdfName::new_unchecked("Symbol")));
+        let mut gstate = PdfDictionary::new();
+        gstate.set("CA", Object::Real(0.5));
+        page.add_resource(ResourceType::ExtGState, "GS1", Object::Dictionary(gstate));
+        let doc = DocumentBuilder::new().page(page).build().unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/ExtGState << /GS1 << /CA 0.5 >> >>"));
+        assert!(content.contains("/Sym /Symbol"));
+        assert!(!content.contains("/F1 null"));
+    }
+
+    #[test]
+    fn test_page_background() {
+        let content = ContentBuilder::new().fill_color(Color::BLUE).rect(10.0, 10.0, 20.0, 20.0).fill();
+        let page = PageBuilder::custom(200.0, 100.0).content(content).background(Color::RED).build();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+        let output = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(output.contains("q\n1 0 0 rg\n0 0 200 100 re\nf\nQ\n0 0 1 rg\n10 10 20 20 re"));
+
+        // The background stays beneath overlays too
+        doc.watermark = Some(Watermark::new("DRAFT"));
+        let output = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(output.contains("0 0 200 100 re\nf\nQ\nq\n0 0 1 rg"));
+    }
+
+    #[test]
+    fn test_coordinate_precision() {
+        let content = ContentBuilder::new().move_to(10.004, 20.5).line_to(30.126, 40.0).stroke();
+        let page = PageBuilder::a4().content(content).build();
+        let mut doc = DocumentBuilder::new().page(page).coordinate_precision(2).build().unwrap();
+        let output = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(output.contains("10 20.5 m\n30.13 40 l"));
+
+        // Pages with overlays are rounded the same way
+        doc.page_numbers = Some(PageNumbers::new("{page}"));
+        let output = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(output.contains("10 20.5 m\n30.13 40 l"));
+
+        doc.coordinate_precision = DEFAULT_PRECISION;
+        let output = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(output.contains("10.004 20.5 m\n30.126 40 l"));
+    }
+
+    #[test]
+    fn test_document_language() {
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).language("en-US").build().unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/Lang (en-US)"));
+
+        doc.language = Some("en_GB".into());
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(PdfError::Document(DocumentError::InvalidLanguage(_)))
+        ));
+    }
+
+    #[test]
+    fn test_tagged_structure() {
+        let mut doc = DocumentBuilder::new()
+            .pages([PageBuilder::a4().build(), PageBuilder::a4().build()])
+            .watermark(Watermark::new("DRAFT"))
+            .build()
+            .unwrap();
+        let section = doc.add_structure_element(StructureElement::new(StructureType::Sect), None).unwrap();
+        let heading = doc.add_structure_element(StructureElement::new(StructureType::H1), Some(section)).unwrap();
+        let figure = StructureElement::new(StructureType::Figure).alt_text("Company logo");
+        let figure = doc.add_structure_element(figure, Some(section)).unwrap();
+        assert_eq!(doc.mark_content(heading, 1).unwrap(), 0);
+        assert_eq!(doc.mark_content(figure, 1).unwrap(), 1);
+        assert!(doc.mark_content(figure, 2).is_err());
+        assert!(doc.add_structure_element(StructureElement::new(StructureType::P), Some(9)).is_err());
+        let page = &mut doc.pages[1];
+        page.content = std::mem::take(&mut page.content)
+            .begin_tagged_content("H1", 0)
+            .end_marked_content()
+            .begin_tagged_content("Figure", 1)
+            .end_marked_content();
+
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/StructTreeRoot"));
+        assert!(content.contains("/MarkInfo << /Marked true >>"));
+        assert!(content.contains("/StructParents 0"));
+        assert!(content.contains("/Alt (Company logo)"));
+        assert!(content.contains("/Artifact BMC"));
+
+        // Content of a deleted page leaves the tree, and other pages keep theirs
+        doc.move_page(1, 0).unwrap();
+        assert_eq!(doc.structure[heading].kids, vec![StructureKid::MarkedContent { page_index: 0, mcid: 0 }]);
+        doc.delete_page(0).unwrap();
+        assert!(doc.structure[figure].kids.is_empty());
+
+        doc.version = PdfVersion::V1_3;
+        assert!(doc.check_version().is_err());
+    }
+
+    #[test]
+    fn test_layers() {
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).build().unwrap();
+        assert_eq!(doc.add_layer(Layer::new("Dimensions")), 0);
+        assert_eq!(doc.add_layer(Layer::new("Hidden lines").visible(false)), 1);
+        let page = &mut doc.pages[0];
+        page.content = std::mem::take(&mut page.content)
+            .begin_optional_content(Layer::resource_name(1))
+            .rect(0.0, 0.0, 10.0, 10.0)
+            .stroke()
+            .end_marked_content();
+
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/OCProperties << /OCGs ["));
+        assert!(content.contains("/Type /OCG /Name (Hidden lines)"));
+        assert!(content.contains("/Properties << /OC1 "));
+        assert!(content.contains("/OC /OC2 BDC"));
+
+        doc.pdfa = Some(PdfAConformance::A1b);
+        assert!(doc.pdfa_violations().contains(&"PDF/A-1b does not allow layers".to_string()));
+        doc.pdfa = None;
+        doc.version = PdfVersion::V1_4;
+        assert!(doc.check_version().is_err());
+    }
+
+    #[test]
+    fn test_fixed_file_id() {
+        let page = PageBuilder::a4().helvetica().build();
+        let mut doc = DocumentBuilder::new()
+            .pdfa(PdfAConformance::A1b)
+            .file_id([0xCA, 0xFE])
+            .page(page)
+            .build()
+            .unwrap();
+        let first = doc.save_to_bytes().unwrap();
+        assert!(String::from_utf8_lossy(&first).contains("/ID [<CAFE> <CAFE>]"));
+        assert_eq!(doc.save_to_bytes().unwrap(), first);
+
+        doc.pdfa = None;
+        assert!(String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).contains("/ID [<CAFE> <CAFE>]"));
+    }
+
+    #[test]
+    fn test_add_bookmark_validates() {
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).build().unwrap();
+        assert!(doc.add_bookmark(Bookmark::new("Missing page", 1)).is_err());
+        assert!(doc.add_bookmark(Bookmark::new("Orphan", 0).child_of(0)).is_err());
+        assert_eq!(doc.add_bookmark(Bookmark::new("Intro", 0)).unwrap(), 0);
+    }
+
+    #[test]
+    fn test_named_destinations() {
+        use crate::page::Link;
+        use crate::types::Rectangle;
+
+        let area = Rectangle::new(72.0, 700.0, 272.0, 720.0);
+        let page = PageBuilder::a4().link(Link::named(area, "summary")).build();
+        let mut doc = DocumentBuilder::new().pages([page, PageBuilder::a4().build()]).build().unwrap();
+        let summary = NamedDestination::new("summary", 0);
+        assert_eq!(doc.add_named_destination(summary.clone()).unwrap(), None);
+        doc.add_named_destination(NamedDestination::new("attachments", 0)).unwrap();
+        // A destination of the same name replaces the earlier one
+        let moved = NamedDestination::new("summary", 1).at(0.0, 400.0);
+        assert_eq!(doc.add_named_destination(moved).unwrap(), Some(summary));
+        assert!(doc.add_named_destination(NamedDestination::new("missing", 2)).is_err());
+        doc.attach_file(FileAttachment::new("notes.txt", b"Notes".to_vec())).unwrap();
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert!(content.contains("/Dest (summary)"));
+        assert!(content.contains("/Dests << /Names [(attachments) [3 0 R /XYZ null null null] (summary) [5 0 R /XYZ 0 400 null]] >>"));
+        assert!(content.contains("/EmbeddedFiles"));
+
+        doc.destinations.clear();
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(crate::error::PdfError::Document(DocumentError::InvalidLink(_)))
+        ));
+    }
+
+    #[test]
+    fn test_move_page() {
+        use crate::page::Link;
+        use crate::types::Rectangle;
+
+        let area = Rectangle::new(0.0, 0.0, 10.0, 10.0);
+        let pages = (0..4).map(|i| PageBuilder::custom(100.0 + i as f64, 100.0).build());
+        let mut doc = DocumentBuilder::new().pages(pages).build().unwrap();
+        doc.pages[3].add_link(Link::internal(area, 0, Some(50.0)));
+        doc.add_bookmark(Bookmark::new("Second", 1)).unwrap();
+        doc.add_named_destination(NamedDestination::new("last", 3)).unwrap();
+
+        doc.move_page(0, 2).unwrap();
+        let widths: Vec<f64> = doc.pages.iter().map(|p| p.width()).collect();
+        assert_eq!(widths, [101.0, 102.0, 100.0, 103.0]);
+        assert_eq!(doc.pages[3].links[0].target, LinkTarget::Page { page_index: 2, y: Some(50.0) });
+        assert_eq!(doc.bookmarks[0].page_index, 0);
+        assert_eq!(doc.destinations[0].page_index, 3);
+
+        doc.move_page(3, 0).unwrap();
+        assert_eq!(doc.pages[0].width(), 103.0);
+        assert_eq!(doc.pages[0].links[0].target, LinkTarget::Page { page_index: 3, y: Some(50.0) });
+        assert_eq!(doc.bookmarks[0].page_index, 1);
+        assert_eq!(doc.destinations[0].page_index, 0);
+        assert!(matches!(
+            doc.move_page(0, 4),
+            Err(crate::error::PdfError::Document(DocumentError::PageOutOfRange { index: 4, count: 4 }))
+        ));
+    }
+
+    #[test]
+    fn test_delete_page() {
+        use crate::page::Link;
+        use crate::types::Rectangle;
+
+        let area = Rectangle::new(0.0, 0.0, 10.0, 10.0);
+        let pages = (0..3).map(|i| PageBuilder::custom(100.0 + i as f64, 100.0).build());
+        let mut doc = DocumentBuilder::new().pages(pages).build().unwrap();
+        doc.pages[0].add_link(Link::internal(area, 1, Some(50.0)));
+        doc.pages[0].add_link(Link::internal(area, 2, Some(50.0)));
+        let chapter = doc.add_bookmark(Bookmark::new("Chapter", 1).at(80.0)).unwrap();
+        doc.add_bookmark(Bookmark::new("Section", 2).child_of(chapter)).unwrap();
+        doc.add_named_destination(NamedDestination::new("end", 2).at(0.0, 90.0)).unwrap();
+
+        // References to the deleted page move to the page taking its place
+        assert_eq!(doc.delete_page(1).unwrap().width(), 101.0);
+        let targets: Vec<&LinkTarget> = doc.pages[0].links.iter().map(|l| &l.target).collect();
+        assert_eq!(targets[0], &LinkTarget::Page { page_index: 1, y: None });
+        assert_eq!(targets[1], &LinkTarget::Page { page_index: 1, y: Some(50.0) });
+        assert_eq!((doc.bookmarks[0].page_index, doc.bookmarks[0].y), (1, None));
+        assert_eq!(doc.bookmarks[1].page_index, 1);
+
+        // or to the new last page
+        doc.delete_page(1).unwrap();
+        assert_eq!((doc.destinations[0].page_index, doc.destinations[0].y), (0, None));
+        assert!(doc.save_to_bytes().is_ok());
+
+        doc.delete_page(0).unwrap();
+        assert!(doc.bookmarks.is_empty() && doc.destinations.is_empty());
+        assert!(doc.delete_page(0).is_err());
+    }
+
+    #[test]
+    fn test_links_share_annots_with_form_fields() {
+        use crate::forms::TextField;
+        use crate::page::Link;
+        use crate::types::Rectangle;
+
+        let area = Rectangle::new(72.0, 700.0, 272.0, 720.0);
+        let page = PageBuilder::a4()
+            .text_field(TextField::new("name").rect(72.0, 600.0, 200.0, 20.0))
+            .link(Link::uri(area, "https://example.com"))
+            .link(Link::internal(area, 1, None))
+            .build();
+        let doc = DocumentBuilder::new()
+            .pages([page, PageBuilder::a4().build()])
+            .build()
+            .unwrap();
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert_eq!(content.matches("/Subtype /Link").count(), 2);
+        assert!(content.contains("/URI (https://example.com)"));
+        assert!(content.contains("/Annots [8 0 R 10 0 R 11 0 R]"));
+    }
+
+    #[test]
+    fn test_markup_annotations() {
+        use crate::color::RgbColor;
+        use crate::page::{Annotation, Link, StampName};
+        use crate::types::Rectangle;
+
+        let yellow = RgbColor::new_unchecked(1.0, 1.0, 0.0);
+        let page = PageBuilder::a4()
+            .link(Link::uri(Rectangle::new(0.0, 0.0, 10.0, 10.0), "https://example.com"))
+            .annotation(Annotation::highlight(Rectangle::new(72.0, 700.0, 272.0, 714.0), yellow))
+            .annotation(Annotation::note(300.0, 700.0, "Reword this"))
+            .annotation(Annotation::stamp(72.0, 72.0, StampName::Draft))
+            .build();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/Annots [5 0 R 6 0 R 8 0 R 10 0 R]"));
+        assert!(content.contains("/Subtype /Highlight"));
+        assert!(content.contains("/Subtype /Text"));
+        assert!(content.contains("/Contents (Reword this)"));
+        assert!(content.contains("/Subtype /Stamp"));
+        assert!(content.contains("/Name /Draft"));
+        assert_eq!(content.matches("/Subtype /Form").count(), 3);
+
+        doc.pdfa = Some(PdfAConformance::A1b);
+        let violations = doc.pdfa_violations();
+        assert!(violations.contains(&"Highlights use transparency, which PDF/A-1b does not allow".to_string()));
+        assert!(violations.iter().any(|v| v.starts_with("Stamp annotations use")));
+        doc.pdfa = None;
+        doc.version = PdfVersion::V1_3;
+        assert!(doc.check_version().is_err());
+    }
+
+    #[test]
+    fn test_link_to_missing_page_fails() {
+        let mut page = PageBuilder::a4().build();
+        let area = crate::types::Rectangle::new(0.0, 0.0, 10.0, 10.0);
+        page.add_link(crate::page::Link::internal(area, 3, None));
+        let doc = DocumentBuilder::new().page(page).build().unwrap();
+        assert!(doc.save_to_bytes().is_err());
+    }
+
+    #[test]
+    fn test_page_rotation() {
+        let doc = DocumentBuilder::new()
+            .page(PageBuilder::a4().rotation(-90).build())
+            .page(PageBuilder::a4().rotation(360).build())
+            .build()
+            .unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert_eq!(content.matches("/Rotate").count(), 1);
+        assert!(content.contains("/Rotate 270"));
+
+        let doc = DocumentBuilder::new().page(PageBuilder::a4().rotation(45).build()).build().unwrap();
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(crate::error::PdfError::Document(DocumentError::InvalidRotation { page: 0, degrees: 45 }))
+        ));
+    }
+
+    #[test]
+    fn test_page_boxes() {
+        let page = PageBuilder::letter()
+            .bleed_box(Rectangle::letter())
+            .trim_box(Rectangle::new(9.0, 9.0, 603.0, 783.0))
+            .build();
+        let doc = DocumentBuilder::new().page(page).build().unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/TrimBox [9 9 603 783]"));
+        assert!(content.contains("/BleedBox [0 0 612 792]"));
+        assert!(!content.contains("/CropBox"));
+
+        let page = PageBuilder::letter().art_box(Rectangle::new(0.0, 0.0, 700.0, 792.0)).build();
+        let doc = DocumentBuilder::new().page(page).build().unwrap();
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(crate::error::PdfError::Document(DocumentError::InvalidPageBox { page: 0, name: "ArtBox" }))
+        ));
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf() {
+        use crate::content::ContentBuilder;
+        use crate::font::Standard14Font;
+
+        let source = DocumentBuilder::new()
+            .pages([1, 2].map(|n| {
+                PageBuilder::a5()
+                    .font("F1", Standard14Font::Courier)
+                    .content(ContentBuilder::new().text("F1", 12.0, 72.0, 500.0, &format!("Source {}", n)))
+                    .build()
+            }))
+            .build()
+            .unwrap()
+            .save_to_bytes()
+            .unwrap();
+
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).build().unwrap();
+        assert_eq!(doc.append_pdf(source).unwrap(), 2);
+        assert_eq!(doc.page_count(), 3);
+        assert_eq!(doc.pages[2].media_box, crate::types::Rectangle::a5());
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert_eq!(content.matches("/Subtype /Form").count(), 2);
+        assert!(content.contains("(Source 2) Tj"));
+        assert!(content.contains("/BaseFont /Courier"));
+        assert_eq!(PdfReader::from_bytes(bytes.clone()).unwrap().page_count(), 3);
+
+        assert!(doc.append_pdf(b"not a pdf".to_vec()).is_err());
+    }
+
+    #[test]
+    fn test_attachments_written_to_name_tree() {
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).build().unwrap();
+        let invoice = FileAttachment::new("factur-x.xml", b"<Invoice/>".to_vec())
+            .mime_type("text/xml")
+            .description("Invoice data")
+            .relationship(AfRelationship::Alternative);
+        doc.attach_file(invoice).unwrap();
+        doc.attach_file(FileAttachment::new("notes.txt", b"notes".to_vec())).unwrap();
+        assert!(doc.attach_file(FileAttachment::new("notes.txt", Vec::new())).is_err());
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert!(content.contains("/Names << /EmbeddedFiles << /Names [(factur-x.xml) 5 0 R (notes.txt) 7 0 R] >> >>"));
+        assert!(content.contains("/AF [5 0 R]"));
+        assert!(content.contains("/Subtype /text#2Fxml"));
+        assert!(content.contains("/AFRelationship /Alternative"));
+        assert!(content.contains("/Desc (Invoice data)"));
+        assert!(content.contains("<Invoice/>"));
+
+        doc.version = PdfVersion::V1_2;
+        assert!(doc.save_to_bytes().is_err());
+    }
+
+    #[test]
+    fn test_pdfa_output() {
+        use crate::content::ContentBuilder;
+
+        let page = PageBuilder::a4()
+            .helvetica()
+            .content(ContentBuilder::new().rect(72.0, 700.0, 100.0, 50.0).fill())
+            .build();
+        let mut doc = DocumentBuilder::new()
+            .title("Archive")
+            .pdfa(PdfAConformance::A1b)
+            .page(page)
+            .build()
+            .unwrap();
+        assert!(doc.pdfa_violations().is_empty());
+
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1"));
+        assert!(content.contains("/Subtype /XML"));
+        assert!(content.contains("<pdfaid:part>1</pdfaid:part>"));
+        assert!(content.contains("/ID [<"));
+
+        doc.pages[0].content = ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, "Hello");
+        doc.watermark = Some(Watermark::new("DRAFT"));
+        assert_eq!(doc.pdfa_violations().len(), 3);
+        let error = doc.save_to_bytes().unwrap_err().to_string();
+        assert!(error.contains("does not conform to PDF/A-1b: Watermarks use the standard Helvetica font"));
+
+        doc.pdfa = Some(PdfAConformance::A2b);
+        assert_eq!(doc.pdfa_violations().len(), 2);
+        doc.pdfa = None;
+        assert!(doc.save_to_bytes().is_ok());
+    }
+
+    #[test]
+    fn test_object_streams() {
+        use crate::content::ContentBuilder;
+
+        let pages = (1..=3).map(|n| {
+            PageBuilder::a4()
+                .helvetica()
+                .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, &format!("Page {}", n)))
+                .build()
+        });
+        let mut doc = DocumentBuilder::new().title("Packed").pages(pages).object_streams(true).build().unwrap();
+        let classic = doc.clone();
+
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        assert!(content.contains("/Type /ObjStm"));
+        assert!(content.contains("/Type /XRef"));
+        assert!(!content.contains("trailer"));
+        assert!(content.contains("(Page 3) Tj"));
+
+        #[cfg(feature = "parser")]
+        {
+            let reader = PdfReader::from_bytes(bytes.clone()).unwrap();
+            assert_eq!(reader.page_count(), 3);
+            assert_eq!(reader.info().unwrap().get("Title"), Some(&Object::String(PdfString::text("Packed"))));
+            let mut copy = Document::new();
+            assert_eq!(copy.append_pdf(bytes).unwrap(), 3);
+
+            doc.compress_streams = true;
+            let bytes = doc.save_to_bytes().unwrap();
+            assert!(!String::from_utf8_lossy(&bytes).contains("/Type /Catalog"));
+            assert_eq!(PdfReader::from_bytes(bytes).unwrap().page_count(), 3);
+            doc.compress_streams = false;
+        }
+
+        doc.version = PdfVersion::V1_4;
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("trailer"));
+        assert!(!content.contains("/ObjStm"));
+        assert!(classic.save_to_bytes().is_ok());
+    }
+
+    #[test]
+    fn test_linearized() {
+        use crate::content::ContentBuilder;
+
+        let pages = (1..=4).map(|n| {
+            PageBuilder::a4()
+                .helvetica()
+                .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, &format!("Page {}", n)))
+                .build()
+        });
+        let doc = DocumentBuilder::new()
+            .title("Fast")
+            .pages(pages)
+            .linearized(true)
+            .object_streams(true)
+            .build()
+            .unwrap();
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes);
+        let header = content.split("endobj").next().unwrap();
+        assert!(header.contains("/Linearized 1"));
+        assert!(header.contains("/N 4"));
+        assert!(!content.contains("/ObjStm"));
+
+        // The first page comes before the later ones, which are numbered from 1
+        let first = content.find("(Page 1) Tj").unwrap();
+        assert!(first < content.find("(Page 2) Tj").unwrap());
+        let length: u64 = header.split("/L ").nth(1).unwrap().split_whitespace().next().unwrap().parse().unwrap();
+        assert_eq!(length, bytes.len() as u64);
+        assert!(content.ends_with("%%EOF\n"));
+
+        #[cfg(feature = "parser")]
+        {
+            let reader = PdfReader::from_bytes(bytes.clone()).unwrap();
+            assert_eq!(reader.page_count(), 4);
+            assert_eq!(reader.info().unwrap().get("Title"), Some(&Object::String(PdfString::text("Fast"))));
+            let mut copy = Document::new();
+            assert_eq!(copy.append_pdf(bytes).unwrap(), 4);
+        }
+    }
+
+    #[test]
+    fn test_deduplicate() {
+        use crate::content::ContentBuilder;
+
+        let pages = (1..=3).map(|_| {
+            PageBuilder::a4()
+                .helvetica()
+                .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, "Same"))
+                .build()
+        });
+        let mut doc = DocumentBuilder::new().pages(pages).deduplicate(true).build().unwrap();
+        let bytes = doc.save_to_bytes().unwrap();
+        let content = String::from_utf8_lossy(&bytes).into_owned();
+        assert_eq!(content.matches("/BaseFont /Helvetica").count(), 1);
+        assert_eq!(content.matches("(Same) Tj").count(), 1);
+        assert_eq!(content.matches("/Type /Page ").count() + content.matches("/Type /Page\n").count(), 3);
+
+        doc.deduplicate = false;
+        let full = doc.save_to_bytes().unwrap();
+        assert_eq!(String::from_utf8_lossy(&full).matches("/BaseFont /Helvetica").count(), 3);
+        assert!(bytes.len() < full.len());
+
+        #[cfg(feature = "parser")]
+        for (linearized, object_streams) in [(false, false), (true, false), (false, true)] {
+            doc.deduplicate = true;
+            doc.linearized = linearized;
+            doc.object_streams = object_streams;
+            let reader = PdfReader::from_bytes(doc.save_to_bytes().unwrap()).unwrap();
+            assert_eq!(reader.page_count(), 3);
+        }
+    }
+
+    #[test]
+    fn test_xmp_metadata() {
+        let mut doc = DocumentBuilder::new()
+            .author("Ada")
+            .xmp(XmpMetadata::FromInfo)
+            .page(PageBuilder::a4().build())
+            .build()
+            .unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/Type /Metadata /Subtype /XML"));
+        assert!(content.contains("<dc:creator><rdf:Seq><rdf:li>Ada</rdf:li></rdf:Seq></dc:creator>"));
+        assert!(!content.contains("pdfaid"));
+
+        doc.xmp = Some(XmpMetadata::Raw(b"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"/>".to_vec()));
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("?>\n<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"/>\n "));
+        assert!(content.contains("<?xpacket end=\"w\"?>"));
+
+        doc.pdfa = Some(PdfAConformance::A2b);
+        assert_eq!(doc.pdfa_violations(), ["The XMP metadata does not identify the document as PDF/A-2b"]);
+        doc.pdfa = None;
+        doc.version = PdfVersion::V1_3;
+        assert!(doc.save_to_bytes().is_err());
+    }
+
+    #[test]
+    fn test_gradient_patterns() {
+        use crate::color::{Gradient, RgbColor};
+
+        let gradient = Gradient::linear(0.0, 0.0, 100.0, 0.0).stop(0.0, RgbColor::RED).stop(1.0, RgbColor::BLUE);
+        let mut page = PageBuilder::a4().pattern("Gr1", gradient).build();
+        page.content = ContentBuilder::new().fill_pattern("Gr1").rect(0.0, 0.0, 100.0, 50.0).fill();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/Pattern << /Gr1 "));
+        assert!(content.contains("/Type /Pattern /PatternType 2"));
+
+        doc.version = PdfVersion::V1_2;
+        assert!(doc.save_to_bytes().is_err());
+        doc.version = PdfVersion::V1_7;
+        doc.pages[0].patterns[0].1.stops.pop();
+        assert!(doc.save_to_bytes().is_err());
+    }
+
+    #[test]
+    fn test_tiling_patterns() {
+        use crate::color::{Gradient, RgbColor, TilingPattern};
+
+        let gradient = Gradient::linear(0.0, 0.0, 100.0, 0.0).stop(0.0, RgbColor::RED).stop(1.0, RgbColor::BLUE);
+        let hatch = TilingPattern::new(6.0, 6.0, ContentBuilder::new().move_to(0.0, 0.0).line_to(6.0, 6.0).stroke());
+        let mut page = PageBuilder::a4().pattern("Gr1", gradient).tiling_pattern("Tp1", hatch).build();
+        page.content = ContentBuilder::new().fill_pattern("Tp1").rect(0.0, 0.0, 100.0, 50.0).fill();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        // The tiling pattern's id follows the gradient's
+        let resources = content.split("/Pattern << /Gr1 ").nth(1).unwrap();
+        let (gradient_id, rest) = resources.split_once(" 0 R /Tp1 ").unwrap();
+        let tiling_id = rest.split_once(' ').unwrap().0;
+        assert_eq!(tiling_id.parse::<u32>().unwrap(), gradient_id.parse::<u32>().unwrap() + 1);
+        let object = content.split(&format!("\n{} 0 obj\n", tiling_id)).nth(1).unwrap();
+        assert!(object.split("endobj").next().unwrap().contains("/Type /Pattern /PatternType 1 /PaintType 1"));
+        assert!(content.contains("/XStep 6 /YStep 6"));
+
+        doc.pages[0].tiling_patterns[0].1.width = -6.0;
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(PdfError::Content(crate::error::ContentError::InvalidPattern(_)))
+        ));
+    }
+
+    #[test]
+    fn test_flatten_form() {
+        use crate::forms::{CheckBox, ComboBox, TextField};
+
+        let page = PageBuilder::a4()
+            .text_field(TextField::new("name").rect(72.0, 600.0, 200.0, 20.0))
+            .checkbox(CheckBox::new("agree").rect(72.0, 560.0, 12.0, 12.0).checked(true))
+            .form_field(ComboBox::new("country").rect(72.0, 520.0, 100.0, 20.0).options(vec!["NL", "ID"]))
+            .build();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+        assert!(doc.form_field_mut("missing").is_none());
+        doc.form_field_mut("name").unwrap().value = Some("Ada".to_string());
+
+        assert_eq!(doc.flatten_form(), 2);
+        assert_eq!(doc.pages[0].form_fields.len(), 1);
+        assert!(doc.pages[0].fonts.iter().any(|(name, _)| name == "Helv"));
+        let content = doc.pages[0].content.build_string();
+        assert!(content.contains("1 0 0 1 72 600 cm"));
+        assert!(content.contains("(Ada) Tj"));
+        assert_eq!(doc.flatten_form(), 0);
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf_keeps_form_fields() {
+        use crate::forms::{CheckBox, TextField};
+
+        let source = DocumentBuilder::new()
+            .page(
+                PageBuilder::a4()
+                    .text_field(TextField::new("name").rect(72.0, 600.0, 200.0, 20.0).value("Old"))
+                    .checkbox(CheckBox::new("agree").rect(72.0, 560.0, 12.0, 12.0).checked(true))
+                    .build(),
+            )
+            .build()
+            .unwrap()
+            .save_to_bytes()
+            .unwrap();
+
+        let mut doc = Document::new();
+        doc.append_pdf(source).unwrap();
+        let fields = &doc.pages[0].form_fields;
+        assert_eq!(fields.len(), 2);
+        assert_eq!(fields[0].value.as_deref(), Some("Old"));
+        assert_eq!(fields[0].rect, Rectangle::new(72.0, 600.0, 272.0, 620.0));
+        assert!(fields[1].checked);
+
+        doc.form_field_mut("name").unwrap().value = Some("New".to_string());
+        let content = String::from_utf8_lossy(&doc.save_to_bytes().unwrap()).into_owned();
+        assert!(content.contains("/V (New)"));
+        assert!(content.contains("(New) Tj"));
+        assert!(!content.contains("Old"));
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf_keeps_choice_fields_and_renames() {
+        use crate::forms::{ComboBox, ListBox, TextField};
+
+        let source = DocumentBuilder::new()
+            .page(
+                PageBuilder::a4()
+                    .form_field(
+                        ComboBox::new("country").rect(72.0, 520.0, 100.0, 20.0).options(vec!["NL", "ID"]).selected_index(1),
+                    )
+                    .form_field(ListBox::new("size").rect(72.0, 420.0, 100.0, 60.0).options(vec!["S", "M", "L"]))
+                    .build(),
+            )
+            .build()
+            .unwrap()
+            .save_to_bytes()
+            .unwrap();
+
+        let page = PageBuilder::a4().text_field(TextField::new("country").rect(72.0, 600.0, 200.0, 20.0)).build();
+        let mut doc = DocumentBuilder::new().page(page).build().unwrap();
+        doc.append_pdf(source).unwrap();
+        let fields = &doc.pages[1].form_fields;
+        assert_eq!(fields[0].name, "country_2");
+        assert_eq!(fields[0].field_type, FormFieldType::ComboBox);
+        assert_eq!(fields[0].options, ["NL", "ID"]);
+        assert_eq!(fields[0].selected_indices, [1]);
+        assert_eq!(fields[1].name, "size");
+        assert_eq!(fields[1].field_type, FormFieldType::ListBox);
+        assert!(fields[1].selected_indices.is_empty());
+
+        // Fields sharing a name stay one field under the new name
+        let mut pages = vec![doc.pages[1].clone(), doc.pages[1].clone()];
+        let renamed = doc.rename_conflicting_fields(&mut pages);
+        let renamed: Vec<(&str, &str)> = renamed.iter().map(|(old, new)| (old.as_str(), new.as_str())).collect();
+        assert_eq!(renamed, [("country_2", "country_2_2"), ("size", "size_2")]);
+        assert_eq!(pages[1].form_fields[0].name, "country_2_2");
+    }
 }
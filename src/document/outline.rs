@@ -0,0 +1,178 @@
+//! Document outline (bookmarks).
+
+use crate::error::DocumentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::types::ObjectId;
+
+/// An entry in the document outline.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Bookmark {
+    /// Text shown in the viewer's bookmark panel.
+    pub title: String,
+    /// Zero-based index of the destination page.
+    pub page_index: usize,
+    /// Vertical position scrolled to the top of the view, or `None` to keep
+    /// the viewer's current position.
+    pub y: Option<f64>,
+    /// Index of the parent bookmark, or `None` for a top-level entry.
+    pub parent: Option<usize>,
+}
+
+impl Bookmark {
+    /// Creates a top-level bookmark that opens `page_index`.
+    pub fn new(title: impl Into<String>, page_index: usize) -> Self {
+        Self {
+            title: title.into(),
+            page_index,
+            y: None,
+            parent: None,
+        }
+    }
+
+    /// Scrolls the destination page so `y` is at the top of the view.
+    pub fn at(mut self, y: f64) -> Self {
+        self.y = Some(y);
+        self
+    }
+
+    /// Nests the bookmark under the bookmark with index `parent`.
+    pub fn child_of(mut self, parent: usize) -> Self {
+        self.parent = Some(parent);
+        self
+    }
+}
+
+/// Checks that `bookmark` can be stored at `index` in a document with `page_count` pages.
+///
+/// A parent must come before its children so the tree has no cycles.
+pub(super) fn check_bookmark(
+    bookmark: &Bookmark,
+    index: usize,
+    page_count: usize,
+) -> Result<(), DocumentError> {
+    if let Some(parent) = bookmark.parent {
+        if parent >= index {
+            let message = format!("parent {} of '{}' does not exist", parent, bookmark.title);
+            return Err(DocumentError::InvalidBookmark(message));
+        }
+    }
+    if bookmark.page_index >= page_count {
+        let message = format!(
+            "page {} of '{}' is out of range (page count is {})",
+            bookmark.page_index, bookmark.title, page_count
+        );
+        return Err(DocumentError::InvalidBookmark(message));
+    }
+    Ok(())
+}
+
+/// Builds the outline root and item dictionaries.
+///
+/// `ids[i]` is the object ID of `bookmarks[i]`; every bookmark must pass
+/// [`check_bookmark`]. Entries are open, so `/Count` includes all descendants.
+pub(super) fn outline_dictionaries(
+    bookmarks: &[Bookmark],
+    root_id: ObjectId,
+    ids: &[ObjectId],
+    page_ids: &[ObjectId],
+) -> (PdfDictionary, Vec<PdfDictionary>) {
+    let children = |parent: Option<usize>| -> Vec<usize> {
+        (0..bookmarks.len()).filter(|&i| bookmarks[i].parent == parent).collect()
+    };
+    let mut descendants = vec![0i64; bookmarks.len()];
+    for i in (0..bookmarks.len()).rev() {
+        if let Some(parent) = bookmarks[i].parent {
+            descendants[parent] += descendants[i] + 1;
+        }
+    }
+
+    let mut root = PdfDictionary::new();
+    root.set("Type", Object::Name(PdfName::new_unchecked("Outlines")));
+    link_children(&mut root, &children(None), ids);
+    root.set("Count", Object::Integer(bookmarks.len() as i64));
+
+    let items = bookmarks
+        .iter()
+        .enumerate()
+        .map(|(i, bookmark)| {
+            let mut item = PdfDictionary::new();
+            item.set("Title", Object::String(PdfString::text(&bookmark.title)));
+            let parent = bookmark.parent.map_or(root_id, |p| ids[p]);
+            item.set("Parent", Object::Reference(parent));
+
+            let siblings = children(bookmark.parent);
+            let position = siblings.iter().position(|&s| s == i).unwrap_or(0);
+            if position > 0 {
+                item.set("Prev", Object::Reference(ids[siblings[position - 1]]));
+            }
+            if let Some(&next) = siblings.get(position + 1) {
+                item.set("Next", Object::Reference(ids[next]));
+            }
+
+            link_children(&mut item, &children(Some(i)), ids);
+            if descendants[i] > 0 {
+                item.set("Count", Object::Integer(descendants[i]));
+            }
+
+            let mut dest = PdfArray::new();
+            dest.push(Object::Reference(page_ids[bookmark.page_index]));
+            dest.push(Object::Name(PdfName::new_unchecked("XYZ")));
+            dest.push(Object::Null);
+            dest.push(bookmark.y.map_or(Object::Null, Object::Real));
+            dest.push(Object::Null);
+            item.set("Dest", Object::Array(dest));
+            item
+        })
+        .collect();
+
+    (root, items)
+}
+
+/// Sets `/First` and `/Last` when the entry has children.
+fn link_children(dict: &mut PdfDictionary, children: &[usize], ids: &[ObjectId]) {
+    if let (Some(&first), Some(&last)) = (children.first(), children.last()) {
+        dict.set("First", Object::Reference(ids[first]));
+        dict.set("Last", Object::Reference(ids[last]));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_outline_links() {
+        let bookmarks = vec![
+            Bookmark::new("Chapter 1", 0),
+            Bookmark::new("Section 1.1", 0).at(400.0).child_of(0),
+            Bookmark::new("Section 1.2", 1).child_of(0),
+            Bookmark::new("Chapter 2", 1),
+        ];
+        let ids: Vec<ObjectId> = (20..24).map(ObjectId::new).collect();
+        let pages = [ObjectId::new(3), ObjectId::new(5)];
+        let (root, items) = outline_dictionaries(&bookmarks, ObjectId::new(10), &ids, &pages);
+
+        let root = root.to_pdf_string();
+        assert!(root.contains("/First 20 0 R"));
+        assert!(root.contains("/Last 23 0 R"));
+        assert!(root.contains("/Count 4"));
+
+        let chapter = items[0].to_pdf_string();
+        assert!(chapter.contains("/Parent 10 0 R"));
+        assert!(chapter.contains("/Next 23 0 R"));
+        assert!(chapter.contains("/First 21 0 R /Last 22 0 R /Count 2"));
+        assert!(!chapter.contains("/Prev"));
+
+        let section = items[1].to_pdf_string();
+        assert!(section.contains("/Parent 20 0 R"));
+        assert!(section.contains("/Next 22 0 R"));
+        assert!(section.contains("/Dest [3 0 R /XYZ null 400 null]"));
+
+        let last = items[2].to_pdf_string();
+        assert!(last.contains("/Prev 21 0 R"));
+        assert!(!last.contains("/Next"));
+        assert!(last.contains("/Dest [5 0 R /XYZ null null null]"));
+
+        assert!(items[3].to_pdf_string().contains("/Prev 20 0 R"));
+    }
+}
@@ -0,0 +1,160 @@
+//! Page numbers stamped in the bottom margin when a document is written.
+
+use crate::content::{ContentBuilder, TextBuilder};
+use crate::font::calculate_helvetica_width;
+use crate::types::{Margins, Rectangle};
+
+/// Resource name of the font used for page numbers.
+pub(super) const PAGE_NUMBER_FONT_NAME: &str = "FPageNum";
+
+/// Horizontal placement of page numbers.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum PageNumberAlignment {
+    /// Against the left margin.
+    Left,
+    /// Centered on the page.
+    #[default]
+    Center,
+    /// Against the right margin.
+    Right,
+}
+
+/// Page numbering applied to every page of a document.
+///
+/// The format may contain `{page}` (the 1-based page number) and `{total}`
+/// (the page count), both resolved when the document is written.
+#[derive(Debug, Clone, PartialEq)]
+pub struct PageNumbers {
+    /// Text template, e.g. `"Page {page} of {total}"`.
+    pub format: String,
+    /// Font size in points. Numbers are set in Helvetica.
+    pub font_size: f64,
+    /// Horizontal placement.
+    pub alignment: PageNumberAlignment,
+    /// Distance in points from the bottom edge to the baseline, and from
+    /// the side edges for left and right alignment.
+    pub margin: f64,
+}
+
+impl PageNumbers {
+    /// Creates centered page numbers in 10pt Helvetica, half an inch from the bottom.
+    pub fn new(format: impl Into<String>) -> Self {
+        Self {
+            format: format.into(),
+            font_size: 10.0,
+            alignment: PageNumberAlignment::Center,
+            margin: 36.0,
+        }
+    }
+
+    /// Sets the font size.
+    pub fn font_size(mut self, size: f64) -> Self {
+        self.font_size = size;
+        self
+    }
+
+    /// Sets the horizontal placement.
+    pub fn alignment(mut self, alignment: PageNumberAlignment) -> Self {
+        self.alignment = alignment;
+        self
+    }
+
+    /// Sets the margin.
+    pub fn margin(mut self, margin: f64) -> Self {
+        self.margin = margin;
+        self
+    }
+
+    /// Returns the text for a page, with placeholders resolved.
+    pub fn text(&self, page_number: usize, total: usize) -> String {
+        self.format
+            .replace("{page}", &page_number.to_string())
+            .replace("{total}", &total.to_string())
+    }
+
+    /// Builds the operators that draw the number of one page, in black.
+    ///
+    /// With document margins, the number is aligned to the content area and
+    /// its baseline sits halfway up the bottom margin instead of using
+    /// [`margin`](Self::margin).
+    pub(super) fn overlay(
+        &self,
+        media_box: &Rectangle,
+        margins: Option<&Margins>,
+        page_number: usize,
+        total: usize,
+    ) -> ContentBuilder {
+        let text = self.text(page_number, total);
+        let width = calculate_helvetica_width(&text, self.font_size);
+        let (area, y) = match margins {
+            Some(margins) => (margins.content_rect(media_box), media_box.lly + margins.bottom / 2.0),
+            None => (
+                Rectangle::new(
+                    media_box.llx + self.margin,
+                    media_box.lly,
+                    media_box.urx - self.margin,
+                    media_box.ury,
+                ),
+                media_box.lly + self.margin,
+            ),
+        };
+        let x = match self.alignment {
+            PageNumberAlignment::Left => area.llx,
+            PageNumberAlignment::Center => area.llx + (area.width() - width) / 2.0,
+            PageNumberAlignment::Right => area.urx - width,
+        };
+
+        ContentBuilder::new()
+            .save_state()
+            .raw("0 g")
+            .text_block(
+                TextBuilder::new()
+                    .font(PAGE_NUMBER_FONT_NAME, self.font_size)
+                    .move_to(x, y)
+                    .show(text),
+            )
+            .restore_state()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_text_resolves_placeholders() {
+        let numbers = PageNumbers::new("Page {page} of {total}");
+        assert_eq!(numbers.text(3, 12), "Page 3 of 12");
+        assert_eq!(PageNumbers::new("{page}/{page}").text(2, 5), "2/2");
+    }
+
+    #[test]
+    fn test_overlay_positions() {
+        let media_box = Rectangle::new(0.0, 0.0, 200.0, 300.0);
+        // "10" is 11.12pt wide at 10pt.
+        let numbers = PageNumbers::new("{page}").margin(20.0);
+
+        let centered = numbers.overlay(&media_box, None, 10, 10).build_string();
+        assert!(centered.starts_with("q\n0 g\nBT"));
+        assert!(centered.contains("94.44 20 Td\n(10) Tj"));
+
+        let left = numbers.clone().alignment(PageNumberAlignment::Left);
+        assert!(left.overlay(&media_box, None, 10, 10).build_string().contains("20 20 Td"));
+
+        let right = numbers.alignment(PageNumberAlignment::Right);
+        assert!(right.overlay(&media_box, None, 10, 10).build_string().contains("168.88 20 Td"));
+    }
+
+    #[test]
+    fn test_overlay_follows_margins() {
+        let media_box = Rectangle::new(0.0, 0.0, 200.0, 300.0);
+        let margins = Margins::new(20.0, 30.0, 40.0, 10.0);
+        let numbers = PageNumbers::new("{page}");
+
+        let centered = numbers.overlay(&media_box, Some(&margins), 10, 10).build_string();
+        assert!(centered.contains("84.44 20 Td"));
+
+        let right = numbers.alignment(PageNumberAlignment::Right);
+        assert!(right.overlay(&media_box, Some(&margins), 10, 10).build_string().contains("158.88 20 Td"));
+    }
+}
@@ -0,0 +1,314 @@
+//! PDF/A archival output: conformance checks, output intent and file identifier.
+
+use std::collections::hash_map::DefaultHasher;
+use std::collections::BTreeSet;
+use std::fmt;
+use std::hash::{Hash, Hasher};
+use std::time::SystemTime;
+
+use super::xmp::{declares_pdfa_part, xmp_date};
+use super::{Document, DocumentInfo, PdfVersion, XmpMetadata};
+use crate::font::Font;
+use crate::forms::FormFieldType;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
+use crate::page::{AnnotationKind, Page};
+use crate::types::ObjectId;
+
+/// Name of the color space described by the embedded output intent profile.
+const OUTPUT_CONDITION: &str = "sRGB IEC61966-2.1";
+
+/// A PDF/A conformance level, requiring a document to be self-contained so it
+/// renders the same way in the future.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PdfAConformance {
+    /// PDF/A-1b (ISO 19005-1), based on PDF 1.4; transparency is not allowed.
+    A1b,
+    /// PDF/A-2b (ISO 19005-2), based on PDF 1.7.
+    A2b,
+}
+
+impl PdfAConformance {
+    /// Returns the part of ISO 19005 the level belongs to.
+    pub fn part(&self) -> u8 {
+        match self {
+            PdfAConformance::A1b => 1,
+            PdfAConformance::A2b => 2,
+        }
+    }
+}
+
+impl fmt::Display for PdfAConformance {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "PDF/A-{}b", self.part())
+    }
+}
+
+/// Returns a description of every way `doc` breaks `level`.
+pub(super) fn violations(doc: &Document, level: PdfAConformance) -> Vec<String> {
+    let mut violations = Vec::new();
+    if doc.version == PdfVersion::V2_0 {
+        violations.push(format!("{} cannot be based on PDF 2.0", level));
+    }
+
+    #[cfg(feature = "encryption")]
+    if doc.encryption.is_some() {
+        violations.push("Encryption is not allowed".to_string());
+    }
+    if !doc.attachments.is_empty() {
+        violations.push(format!("{} does not allow attached files", level));
+    }
+    if !doc.layers.is_empty() && level == PdfAConformance::A1b {
+        violations.push("PDF/A-1b does not allow layers".to_string());
+    }
+    for (key, date) in [("creation", &doc.info.creation_date), ("modification", &doc.info.mod_date)] {
+        if date.as_deref().map_or(false, |d| xmp_date(d).is_none()) {
+            violations.push(format!("The {} date is not a valid PDF date", key));
+        }
+    }
+    if let Some(XmpMetadata::Raw(ref packet)) = doc.xmp {
+        if !declares_pdfa_part(packet, level.part()) {
+            violations.push(format!("The XMP metadata does not identify the document as {}", level));
+        }
+    }
+
+    for feature in helvetica_features(doc) {
+        violations.push(format!("{} use the standard Helvetica font, which is not embedded", feature));
+    }
+    if doc.watermark.is_some() && level == PdfAConformance::A1b {
+        violations.push("Watermarks use transparency, which PDF/A-1b does not allow".to_string());
+    }
+    let annotations = || doc.pages.iter().flat_map(|p| &p.annotations);
+    if level == PdfAConformance::A1b && annotations().any(|a| matches!(a.kind, AnnotationKind::Highlight(_))) {
+        violations.push("Highlights use transparency, which PDF/A-1b does not allow".to_string());
+    }
+
+    for (i, page) in doc.pages.iter().enumerate() {
+        page_violations(page, i + 1, level, &mut violations);
+    }
+    violations
+}
+
+/// Returns the features of `doc` in use that draw text in the standard
+/// Helvetica font, which is never embedded.
+pub(super) fn helvetica_features(doc: &Document) -> Vec<&'static str> {
+    let mut features = Vec::new();
+    if doc.page_numbers.is_some() {
+        features.push("Page numbers");
+    }
+    if doc.watermark.is_some() {
+        features.push("Watermarks");
+    }
+    let text_fields = doc.pages.iter().flat_map(|p| &p.form_fields).any(|f| {
+        !matches!(f.field_type, FormFieldType::CheckBox | FormFieldType::RadioButton)
+    });
+    if text_fields {
+        features.push("Text and choice form fields");
+    }
+    if doc.pages.iter().flat_map(|p| &p.annotations).any(|a| matches!(a.kind, AnnotationKind::Stamp(_))) {
+        features.push("Stamp annotations");
+    }
+    features
+}
+
+/// Returns the standard fonts, which are not embedded, that `tokens` of the
+/// content of `page` select with `Tf`.
+pub(super) fn standard_fonts_used<'a>(page: &'a Page, tokens: &[&str]) -> Vec<&'a Font> {
+    let mut used_fonts = BTreeSet::new();
+    for (i, &token) in tokens.iter().enumerate() {
+        if token == "Tf" && i >= 2 {
+            used_fonts.insert(tokens[i - 2].trim_start_matches('/'));
+        }
+    }
+    page.fonts
+        .iter()
+        .filter(|(name, font)| font.as_truetype().is_none() && used_fonts.contains(name.as_str()))
+        .map(|(_, font)| font)
+        .collect()
+}
+
+/// Adds the violations found on one page.
+fn page_violations(page: &Page, number: usize, level: PdfAConformance, violations: &mut Vec<String>) {
+    let content = page.content.build_string();
+    let tokens: Vec<&str> = content.split_whitespace().collect();
+    for font in standard_fonts_used(page, &tokens) {
+        violations.push(format!(
+            "Page {} uses the standard font {}, which is not embedded; load a TrueType font instead",
+            number,
+            font.postscript_name()
+        ));
+    }
+    if tokens.iter().any(|&t| t == "k" || t == "K") {
+        violations.push(format!(
+            "Page {} uses CMYK colors, which the sRGB output intent does not cover",
+            number
+        ));
+    }
+
+    #[cfg(feature = "images")]
+    if level == PdfAConformance::A1b && page.images.iter().any(|(_, image)| image.has_alpha()) {
+        violations.push(format!(
+            "Page {} has an image with transparency, which PDF/A-1b does not allow",
+            number
+        ));
+    }
+    #[cfg(not(feature = "images"))]
+    let _ = level;
+
+    #[cfg(feature = "parser")]
+    if !page.imported.is_empty() {
+        violations.push(format!(
+            "Page {} was imported from another PDF, whose fonts and colors cannot be checked",
+            number
+        ));
+    }
+}
+
+/// Builds the /OutputIntents entry of the catalog, referring to the sRGB
+/// profile stream `profile_id`. Every part of PDF/A uses the same subtype.
+pub(super) fn output_intents(profile_id: ObjectId) -> PdfArray {
+    let mut intent = PdfDictionary::new();
+    intent.set("Type", Object::Name(PdfName::new_unchecked("OutputIntent")));
+    intent.set("S", Object::Name(PdfName::new_unchecked("GTS_PDFA1")));
+    intent.set("OutputConditionIdentifier", Object::String(PdfString::literal(OUTPUT_CONDITION)));
+    intent.set("Info", Object::String(PdfString::literal(OUTPUT_CONDITION)));
+    intent.set("RegistryName", Object::String(PdfString::literal("http://www.color.org")));
+    intent.set("DestOutputProfile", Object::Reference(profile_id));
+
+    let mut intents = PdfArray::new();
+    intents.push(Object::Dictionary(intent));
+    intents
+}
+
+/// Builds the ICC profile stream of the output intent.
+pub(super) fn output_profile_stream() -> PdfStream {
+    let mut dict = PdfDictionary::new();
+    dict.set("N", Object::Integer(3));
+    PdfStream::with_dictionary(dict, srgb_icc_profile())
+}
+
+/// Returns a file identifier for the trailer, which PDF/A requires.
+pub(super) fn file_id(info: &DocumentInfo) -> Vec<u8> {
+    let now = SystemTime::now()
+        .duration_since(SystemTime::UNIX_EPOCH)
+        .map(|d| d.as_nanos())
+        .unwrap_or(0);
+    let mut id = Vec::with_capacity(16);
+    for seed in 0u8..2 {
+        let mut hasher = DefaultHasher::new();
+        (seed, now, &info.title, &info.author, &info.subject).hash(&mut hasher);
+        id.extend_from_slice(&hasher.finish().to_be_bytes());
+    }
+    id
+}
+
+/// Builds an ICC version 2 display profile for the sRGB color space.
+fn srgb_icc_profile() -> Vec<u8> {
+    fn xyz(values: [f64; 3]) -> Vec<u8> {
+        let mut data = b"XYZ \0\0\0\0".to_vec();
+        for v in values {
+            data.extend_from_slice(&((v * 65536.0).round() as i32).to_be_bytes());
+        }
+        data
+    }
+
+    let mut description = b"desc\0\0\0\0".to_vec();
+    description.extend_from_slice(&(OUTPUT_CONDITION.len() as u32 + 1).to_be_bytes());
+    description.extend_from_slice(OUTPUT_CONDITION.as_bytes());
+    description.extend_from_slice(&[0; 1 + 4 + 4 + 2 + 1 + 67]);
+
+    let mut copyright = b"text\0\0\0\0".to_vec();
+    copyright.extend_from_slice(b"No copyright, use freely\0");
+
+    // The sRGB transfer function, sampled at 256 points
+    let mut curve = b"curv\0\0\0\0".to_vec();
+    curve.extend_from_slice(&256u32.to_be_bytes());
+    for i in 0..256 {
+        let v = i as f64 / 255.0;
+        let linear = if v <= 0.04045 { v / 12.92 } else { ((v + 0.055) / 1.055).powf(2.4) };
+        curve.extend_from_slice(&((linear * 65535.0).round() as u16).to_be_bytes());
+    }
+
+    let tags: [(&[u8; 4], Vec<u8>); 9] = [
+        (b"desc", description),
+        (b"cprt", copyright),
+        (b"wtpt", xyz([0.9505, 1.0, 1.0891])),
+        (b"rXYZ", xyz([0.4361, 0.2225, 0.0139])),
+        (b"gXYZ", xyz([0.3851, 0.7169, 0.0971])),
+        (b"bXYZ", xyz([0.1431, 0.0606, 0.7141])),
+        (b"rTRC", curve.clone()),
+        (b"gTRC", curve.clone()),
+        (b"bTRC", curve),
+    ];
+
+    let mut table = (tags.len() as u32).to_be_bytes().to_vec();
+    let mut data = Vec::new();
+    let data_start = 128 + 4 + 12 * tags.len();
+    for (signature, tag) in &tags {
+        table.extend_from_slice(*signature);
+        table.extend_from_slice(&((data_start + data.len()) as u32).to_be_bytes());
+        table.extend_from_slice(&(tag.len() as u32).to_be_bytes());
+        data.extend_from_slice(tag);
+        while data.len() % 4 != 0 {
+            data.push(0);
+        }
+    }
+
+    let size = data_start + data.len();
+    let mut profile = Vec::with_capacity(size);
+    profile.extend_from_slice(&(size as u32).to_be_bytes());
+    profile.extend_from_slice(&[0; 4]); // preferred CMM
+    profile.extend_from_slice(&[2, 0x10, 0, 0]); // version 2.1
+    profile.extend_from_slice(b"mntrRGB XYZ ");
+    for part in [2024u16, 1, 1, 0, 0, 0] {
+        profile.extend_from_slice(&part.to_be_bytes());
+    }
+    profile.extend_from_slice(b"acsp");
+    profile.extend_from_slice(&[0; 24]); // platform, flags, device and attributes
+    profile.extend_from_slice(&[0; 4]); // perceptual rendering intent
+    profile.extend_from_slice(&xyz([0.9642, 1.0, 0.8249])[8..]); // D50 illuminant
+    profile.resize(128, 0);
+    profile.extend_from_slice(&table);
+    profile.extend_from_slice(&data);
+    profile
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::ContentBuilder;
+    use crate::font::Standard14Font;
+    use crate::page::PageBuilder;
+
+    #[test]
+    fn test_srgb_icc_profile() {
+        let profile = srgb_icc_profile();
+        assert_eq!(u32::from_be_bytes(profile[..4].try_into().unwrap()) as usize, profile.len());
+        assert_eq!(&profile[12..24], b"mntrRGB XYZ ");
+        assert_eq!(&profile[36..40], b"acsp");
+        assert_eq!(u32::from_be_bytes(profile[128..132].try_into().unwrap()), 9);
+    }
+
+    #[test]
+    fn test_violations() {
+        let page = PageBuilder::a4()
+            .font("F1", Standard14Font::Helvetica)
+            .font("F2", Standard14Font::Courier)
+            .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, "Hello"))
+            .build();
+        let mut doc = Document::new();
+        doc.add_page(page);
+        let violations = violations(&doc, PdfAConformance::A1b);
+        assert_eq!(violations.len(), 1);
+        assert!(violations[0].starts_with("Page 1 uses the standard font Helvetica"));
+
+        doc.pages[0].content = ContentBuilder::new().fill_color(crate::color::Color::cmyk(0.0, 0.0, 0.0, 1.0));
+        doc.info.mod_date = Some("last week".to_string());
+        assert_eq!(
+            super::violations(&doc, PdfAConformance::A2b),
+            [
+                "The modification date is not a valid PDF date",
+                "Page 1 uses CMYK colors, which the sRGB output intent does not cover",
+            ]
+        );
+    }
+}
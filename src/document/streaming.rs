@@ -0,0 +1,477 @@
+//! Writing a document one page at a time.
+//!
+//! `Document::write_to` needs every page in memory until the file is done. A
+//! [`StreamingWriter`] writes each page as soon as it is given, keeping only
+//! object numbers, so memory stays flat however many pages the file has. The
+//! page tree, catalog and document-level objects follow the last page.
+
+use std::io::Write;
+#[cfg(feature = "parser")]
+use std::sync::Arc;
+
+use super::fonts::{collect_embedded_fonts, find_embedded_font, EmbeddedFont};
+#[cfg(feature = "parser")]
+use super::imported::{collect_imported_objects, find_imported_objects};
+use super::layer::layer_dictionary;
+use super::outline::outline_dictionaries;
+use super::{
+    acroform_dictionary, allocate_form_field_ids, check_page, xmp, AttachmentIds, CatalogIds, Document, PageContext, PageIds,
+};
+use crate::error::{DocumentError, PdfResult};
+use crate::font::embed::EmbeddedFontIds;
+use crate::font::{Font, Standard14Font};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName};
+use crate::page::Page;
+use crate::types::ObjectId;
+use crate::writer::PdfWriter;
+
+#[cfg(feature = "encryption")]
+use crate::encryption::{generate_file_id, EncryptionHandler};
+#[cfg(feature = "parser")]
+use crate::page::ImportedObjects;
+
+/// Writes a document one page at a time.
+///
+/// The [`Document`] passed to each call supplies the settings and the
+/// document-level content, such as the information dictionary and bookmarks,
+/// written by [`finish`](Self::finish); its own pages are ignored. The version,
+/// encryption and object stream settings are taken when the writer is created.
+///
+/// Links can only point at pages already written. Page numbers, tagged
+/// structure, PDF/A, linearization and deduplication need the whole document
+/// and are rejected. After an error the output is incomplete.
+pub struct StreamingWriter<W: Write> {
+    writer: PdfWriter<W>,
+    catalog_id: ObjectId,
+    pages_id: ObjectId,
+    page_ids: Vec<ObjectId>,
+    overlay_font_id: Option<ObjectId>,
+    watermark_gstate_id: Option<ObjectId>,
+    layer_ids: Vec<ObjectId>,
+    /// Embedded fonts with the glyphs of every page written so far, written
+    /// by `finish`.
+    embedded_fonts: Vec<EmbeddedFont>,
+    embedded_font_ids: Vec<EmbeddedFontIds>,
+    /// Form fields of the pages written, for the AcroForm dictionary.
+    field_ids: Vec<ObjectId>,
+    /// Objects copied from other files that have been written, with their
+    /// numbers, kept while a page not yet written may still use them.
+    #[cfg(feature = "parser")]
+    imported_sets: Vec<Arc<ImportedObjects>>,
+    #[cfg(feature = "parser")]
+    imported_ids: Vec<Vec<ObjectId>>,
+    #[cfg(feature = "encryption")]
+    encryption_handler: Option<EncryptionHandler>,
+}
+
+impl<W: Write> StreamingWriter<W> {
+    /// Starts a file for `document` on `writer`, writing the header.
+    pub fn new(writer: W, document: &Document) -> PdfResult<Self> {
+        check_streamable(document)?;
+        document.check_version_of(&[])?;
+        let mut pdf_writer = PdfWriter::new(writer, document.version.as_str());
+        if document.uses_object_streams() {
+            pdf_writer.use_object_streams(document.stream_compression_level());
+        }
+        #[cfg(feature = "encryption")]
+        let encryption_handler = match document.encryption {
+            Some(ref config) => {
+                let file_id = document.file_id.clone().unwrap_or_else(generate_file_id);
+                let handler = EncryptionHandler::new(config.clone(), file_id)?;
+                pdf_writer.set_encryption_handler(handler.clone());
+                Some(handler)
+            }
+            None => None,
+        };
+
+        pdf_writer.write_header()?;
+        let catalog_id = pdf_writer.allocate_id();
+        let pages_id = pdf_writer.allocate_id();
+        Ok(Self {
+            writer: pdf_writer,
+            catalog_id,
+            pages_id,
+            page_ids: Vec::new(),
+            overlay_font_id: None,
+            watermark_gstate_id: None,
+            layer_ids: Vec::new(),
+            embedded_fonts: Vec::new(),
+            embedded_font_ids: Vec::new(),
+            field_ids: Vec::new(),
+            #[cfg(feature = "parser")]
+            imported_sets: Vec::new(),
+            #[cfg(feature = "parser")]
+            imported_ids: Vec::new(),
+            #[cfg(feature = "encryption")]
+            encryption_handler,
+        })
+    }
+
+    /// Returns the number of pages written so far.
+    pub fn page_count(&self) -> usize {
+        self.page_ids.len()
+    }
+
+    /// Writes `page` as the next page of the file, with everything only it
+    /// uses. Embedded fonts are written by `finish`, subset to the glyphs of
+    /// every page.
+    pub fn write_page(&mut self, document: &Document, page: &Page) -> PdfResult<()> {
+        check_streamable(document)?;
+        document.check_version_of(std::slice::from_ref(page))?;
+        let index = self.page_ids.len();
+        check_page(index, page)?;
+        for (_, gradient) in &page.patterns {
+            gradient.check()?;
+        }
+        for (_, pattern) in &page.tiling_patterns {
+            pattern.check()?;
+        }
+
+        for font in collect_embedded_fonts(std::slice::from_ref(page)) {
+            match find_embedded_font(&self.embedded_fonts, &font.font) {
+                Some(i) => self.embedded_fonts[i].glyphs.extend(font.glyphs),
+                None => {
+                    self.embedded_font_ids.push(EmbeddedFontIds {
+                        font: self.writer.allocate_id(),
+                        descendant: self.writer.allocate_id(),
+                        descriptor: self.writer.allocate_id(),
+                        font_file: self.writer.allocate_id(),
+                        to_unicode: self.writer.allocate_id(),
+                        cid_set: None,
+                    });
+                    self.embedded_fonts.push(font);
+                }
+            }
+        }
+
+        // Objects copied from another file go out with the first page that uses them
+        #[cfg(feature = "parser")]
+        self.forget_unused_imports();
+        #[cfg(feature = "parser")]
+        for set in collect_imported_objects(std::slice::from_ref(page)) {
+            if find_imported_objects(&self.imported_sets, &set).is_none() {
+                let ids: Vec<ObjectId> = (0..set.len()).map(|_| self.writer.allocate_id()).collect();
+                document.write_imported_objects(&mut self.writer, &set, &ids)?;
+                self.imported_sets.push(set);
+                self.imported_ids.push(ids);
+            }
+        }
+
+        if document.watermark.is_some() && self.watermark_gstate_id.is_none() {
+            self.overlay_font_id = Some(self.writer.allocate_id());
+            self.watermark_gstate_id = Some(self.writer.allocate_id());
+        }
+        self.allocate_layer_ids(document);
+
+        let page_id = self.writer.allocate_id();
+        let content_id = self.writer.allocate_id();
+        let mut fonts = Vec::with_capacity(page.fonts.len());
+        for (name, font) in &page.fonts {
+            let id = match font.as_truetype().and_then(|f| find_embedded_font(&self.embedded_fonts, f)) {
+                Some(i) => self.embedded_font_ids[i].font,
+                None => self.writer.allocate_id(),
+            };
+            fonts.push((name.clone(), id));
+        }
+        let pattern_count = page.patterns.len() + page.tiling_patterns.len();
+        let patterns: Vec<ObjectId> = (0..pattern_count).map(|_| self.writer.allocate_id()).collect();
+        #[cfg(feature = "images")]
+        let mut images = Vec::with_capacity(page.images.len());
+        #[cfg(feature = "images")]
+        for (name, image) in &page.images {
+            let image_id = self.writer.allocate_id();
+            let mask_id = image.has_alpha().then(|| self.writer.allocate_id());
+            images.push((name.clone(), image_id, mask_id));
+        }
+        let form_fields: Vec<_> = page
+            .form_fields()
+            .iter()
+            .map(|field| allocate_form_field_ids(&mut self.writer, field))
+            .collect();
+        let links: Vec<ObjectId> = page.links.iter().map(|_| self.writer.allocate_id()).collect();
+        let annotations: Vec<(ObjectId, ObjectId)> = page
+            .annotations
+            .iter()
+            .map(|_| (self.writer.allocate_id(), self.writer.allocate_id()))
+            .collect();
+        let thumbnail = page.thumbnail.as_ref().map(|_| self.writer.allocate_id());
+        self.page_ids.push(page_id);
+
+        let ids = PageIds {
+            page: page_id,
+            content: content_id,
+            fonts: &fonts,
+            patterns: &patterns,
+            #[cfg(feature = "images")]
+            images: &images,
+            form_fields: &form_fields,
+            links: &links,
+            annotations: &annotations,
+            thumbnail,
+            struct_parents: None,
+        };
+        let context = PageContext {
+            pages_id: self.pages_id,
+            page_ids: &self.page_ids,
+            page_count: self.page_ids.len(),
+            overlay_font_id: self.overlay_font_id,
+            watermark_gstate_id: self.watermark_gstate_id,
+            layer_ids: &self.layer_ids,
+            tagged: false,
+            #[cfg(feature = "parser")]
+            imported_sets: &self.imported_sets,
+            #[cfg(feature = "parser")]
+            imported_ids: &self.imported_ids,
+        };
+        document.write_page(&mut self.writer, page, index, &ids, &context)?;
+        self.field_ids.extend(form_fields.iter().map(|f| f.field_id));
+        Ok(())
+    }
+
+    /// Drops the objects copied from other files that only this writer still
+    /// holds: the pages using them have been written and dropped, and no
+    /// page written later can use them, so they are freed.
+    #[cfg(feature = "parser")]
+    fn forget_unused_imports(&mut self) {
+        let mut i = 0;
+        while i < self.imported_sets.len() {
+            if Arc::strong_count(&self.imported_sets[i]) == 1 {
+                self.imported_sets.swap_remove(i);
+                self.imported_ids.swap_remove(i);
+            } else {
+                i += 1;
+            }
+        }
+    }
+
+    /// Writes the page tree, the catalog and the document-level objects of
+    /// `document`, then the trailer, and returns the output.
+    ///
+    /// Fails with [`DocumentError::NoPages`] if no page was written.
+    pub fn finish(mut self, document: &Document) -> PdfResult<W> {
+        check_streamable(document)?;
+        document.check_version_of(&[])?;
+        if self.page_ids.is_empty() {
+            return Err(DocumentError::NoPages.into());
+        }
+        document.check_document_objects(self.page_ids.len())?;
+        self.allocate_layer_ids(document);
+        let writer = &mut self.writer;
+
+        if let Some(font_id) = self.overlay_font_id {
+            let font_dict = Font::from(Standard14Font::Helvetica).to_dictionary();
+            writer.write_object_with_id(font_id, &Object::Dictionary(font_dict))?;
+        }
+        if let Some(gstate_id) = self.watermark_gstate_id {
+            let gstate = document.watermark.as_ref().map(|w| w.ext_gstate()).unwrap_or_default();
+            writer.write_object_with_id(gstate_id, &Object::Dictionary(gstate))?;
+        }
+        document.write_embedded_fonts(writer, &self.embedded_fonts, &self.embedded_font_ids)?;
+
+        let acroform_id = (!self.field_ids.is_empty()).then(|| writer.allocate_id());
+        if let Some(acroform_id) = acroform_id {
+            let fields = self.field_ids.iter().map(|id| Object::Reference(*id)).collect();
+            writer.write_object_with_id(acroform_id, &Object::Dictionary(acroform_dictionary(fields)))?;
+        }
+
+        let attachment_ids: Vec<AttachmentIds> = document
+            .attachments
+            .iter()
+            .map(|_| AttachmentIds {
+                filespec: writer.allocate_id(),
+                file: writer.allocate_id(),
+            })
+            .collect();
+        document.write_attachments(writer, &attachment_ids)?;
+
+        let outline_root_id = (!document.bookmarks.is_empty()).then(|| writer.allocate_id());
+        if let Some(outline_root_id) = outline_root_id {
+            let bookmark_ids: Vec<ObjectId> = document.bookmarks.iter().map(|_| writer.allocate_id()).collect();
+            let (root, items) =
+                outline_dictionaries(&document.bookmarks, outline_root_id, &bookmark_ids, &self.page_ids);
+            writer.write_object_with_id(outline_root_id, &Object::Dictionary(root))?;
+            for (id, item) in bookmark_ids.iter().zip(items) {
+                writer.write_object_with_id(*id, &Object::Dictionary(item))?;
+            }
+        }
+
+        for (layer, id) in document.layers.iter().zip(&self.layer_ids) {
+            writer.write_object_with_id(*id, &Object::Dictionary(layer_dictionary(layer)))?;
+        }
+
+        let metadata_id = match document.metadata_packet() {
+            Some(packet) => {
+                let id = writer.allocate_id();
+                writer.write_object_with_id(id, &Object::Stream(xmp::metadata_stream(packet)))?;
+                Some(id)
+            }
+            None => None,
+        };
+        let info_id = if document.info.is_empty() {
+            None
+        } else {
+            let id = writer.allocate_id();
+            writer.write_object_with_id(id, &Object::Dictionary(document.info.to_dictionary()))?;
+            Some(id)
+        };
+
+        let catalog = document.catalog(&CatalogIds {
+            pages: self.pages_id,
+            page_ids: &self.page_ids,
+            outline_root: outline_root_id,
+            acroform: acroform_id,
+            struct_tree: None,
+            layers: &self.layer_ids,
+            metadata: metadata_id,
+            profile: None,
+            attachments: &attachment_ids,
+        });
+        writer.write_object_with_id(self.catalog_id, &Object::Dictionary(catalog))?;
+
+        let mut pages_dict = PdfDictionary::new();
+        pages_dict.set("Type", Object::Name(PdfName::pages()));
+        let kids: PdfArray = self.page_ids.iter().map(|id| Object::Reference(*id)).collect();
+        pages_dict.set("Kids", Object::Array(kids));
+        pages_dict.set("Count", Object::Integer(self.page_ids.len() as i64));
+        writer.write_object_with_id(self.pages_id, &Object::Dictionary(pages_dict))?;
+
+        #[cfg(feature = "encryption")]
+        if let Some(ref handler) = self.encryption_handler {
+            // The encryption dictionary must not be encrypted itself
+            let encrypt_id = writer.allocate_id();
+            writer.write_object_unencrypted(encrypt_id, &Object::Dictionary(handler.create_encrypt_dictionary()))?;
+            writer.write_trailer_with_encryption(self.catalog_id, info_id, Some(encrypt_id), Some(handler.file_id()))?;
+            return Ok(self.writer.into_inner());
+        }
+        writer.write_trailer_with_encryption(self.catalog_id, info_id, None, document.file_id.as_deref())?;
+        Ok(self.writer.into_inner())
+    }
+
+    /// Allocates IDs for layers added since the last call.
+    fn allocate_layer_ids(&mut self, document: &Document) {
+        while self.layer_ids.len() < document.layers.len() {
+            self.layer_ids.push(self.writer.allocate_id());
+        }
+    }
+}
+
+/// Fails with [`DocumentError::NotStreamable`] if `document` uses a setting
+/// that needs every page up front.
+fn check_streamable(document: &Document) -> PdfResult<()> {
+    #[cfg(feature = "parser")]
+    if document.original.is_some() {
+        return Err(DocumentError::NotStreamable("an incremental update").into());
+    }
+    let unsupported = [
+        ("page numbers", document.page_numbers.is_some()),
+        ("tagged structure", !document.structure.is_empty()),
+        ("PDF/A conformance", document.pdfa.is_some()),
+        ("linearization", document.linearized),
+        ("deduplication", document.deduplicate),
+    ];
+    match unsupported.iter().find(|(_, used)| *used) {
+        Some((setting, _)) => Err(DocumentError::NotStreamable(setting).into()),
+        None => Ok(()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::ContentBuilder;
+    use crate::document::{Bookmark, PageNumbers};
+    use crate::error::PdfError;
+    use crate::page::{Link, PageBuilder};
+    use crate::types::Rectangle;
+
+    fn page(n: usize) -> Page {
+        PageBuilder::a4()
+            .helvetica()
+            .content(ContentBuilder::new().text("F1", 12.0, 72.0, 700.0, &format!("Page {}", n)))
+            .build()
+    }
+
+    #[test]
+    fn test_streaming_writer() {
+        let mut document = Document::new();
+        document.info.title = Some("Streamed".to_string());
+        let mut writer = StreamingWriter::new(Vec::new(), &document).unwrap();
+        for n in 1..=3 {
+            writer.write_page(&document, &page(n)).unwrap();
+        }
+        let mut linked = page(4);
+        linked.add_link(Link::internal(Rectangle::new(0.0, 0.0, 10.0, 10.0), 0, None));
+        writer.write_page(&document, &linked).unwrap();
+        assert_eq!(writer.page_count(), 4);
+
+        document.bookmarks.push(Bookmark::new("Last", 3));
+        let bytes = writer.finish(&document).unwrap();
+        let content = String::from_utf8_lossy(&bytes).into_owned();
+        assert!(content.contains("/Count 4"));
+        assert!(content.contains("/Title (Streamed)"));
+        assert!(content.find("(Page 1) Tj").unwrap() < content.find("(Page 4) Tj").unwrap());
+        // The page tree and catalog come after the pages
+        assert!(content.find("/Type /Catalog").unwrap() > content.find("(Page 4) Tj").unwrap());
+
+        #[cfg(feature = "parser")]
+        {
+            let reader = crate::parser::PdfReader::from_bytes(bytes).unwrap();
+            assert_eq!(reader.page_count(), 4);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_streaming_writer_frees_imported_objects() {
+        use crate::object::PdfStream;
+        use crate::page::ImportedPage;
+        use std::collections::BTreeMap;
+
+        let imported_page = |objects: &Arc<ImportedObjects>| {
+            let mut page = Page::a4();
+            page.add_imported_page("ImportedPage", ImportedPage::new(objects.clone(), 0, Rectangle::a4(), None));
+            page
+        };
+        let form = || {
+            let stream = PdfStream::with_dictionary(PdfDictionary::new(), b"0 0 m".to_vec());
+            Arc::new(ImportedObjects::new(vec![Object::Stream(stream)], BTreeMap::new()))
+        };
+        let document = Document::new();
+        let mut writer = StreamingWriter::new(Vec::new(), &document).unwrap();
+        let first = form();
+        let pages = [imported_page(&first), imported_page(&first)];
+        drop(first);
+        writer.write_page(&document, &pages[0]).unwrap();
+        writer.write_page(&document, &pages[1]).unwrap();
+        // The second page shared the objects written with the first
+        assert_eq!(writer.imported_sets.len(), 1);
+        drop(pages);
+
+        let second = form();
+        writer.write_page(&document, &imported_page(&second)).unwrap();
+        assert_eq!(writer.imported_sets.len(), 1);
+        assert!(Arc::ptr_eq(&writer.imported_sets[0], &second));
+        let content = String::from_utf8_lossy(&writer.finish(&document).unwrap()).into_owned();
+        assert_eq!(content.matches("0 0 m").count(), 2);
+    }
+
+    #[test]
+    fn test_streaming_writer_errors() {
+        let document = Document::new();
+        let writer = StreamingWriter::new(Vec::new(), &document).unwrap();
+        assert!(matches!(writer.finish(&document), Err(PdfError::Document(DocumentError::NoPages))));
+
+        // A link can't point at a page that hasn't been written
+        let mut writer = StreamingWriter::new(Vec::new(), &document).unwrap();
+        let mut linked = page(1);
+        linked.add_link(Link::internal(Rectangle::new(0.0, 0.0, 10.0, 10.0), 1, None));
+        assert!(writer.write_page(&document, &linked).is_err());
+
+        let mut numbered = Document::new();
+        numbered.page_numbers = Some(PageNumbers::new("{page}"));
+        assert!(matches!(
+            StreamingWriter::new(Vec::new(), &numbered),
+            Err(PdfError::Document(DocumentError::NotStreamable("page numbers")))
+        ));
+    }
+}
@@ -0,0 +1,462 @@
+//! Logical structure of tagged documents.
+
+use crate::error::DocumentError;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::types::ObjectId;
+use std::collections::HashSet;
+
+/// A standard structure type, as assistive technology understands it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum StructureType {
+    /// The whole document.
+    Document,
+    /// A large division, such as a chapter.
+    Part,
+    /// A self-contained article.
+    Art,
+    /// A section.
+    Sect,
+    /// A generic block of elements.
+    Div,
+    /// A quotation of one or more paragraphs.
+    BlockQuote,
+    /// The caption of a figure or table.
+    Caption,
+    /// A table of contents.
+    TOC,
+    /// An entry of a table of contents.
+    TOCI,
+    /// An index.
+    Index,
+    /// A paragraph.
+    P,
+    /// A heading whose level follows from its nesting.
+    H,
+    /// A level 1 heading.
+    H1,
+    /// A level 2 heading.
+    H2,
+    /// A level 3 heading.
+    H3,
+    /// A level 4 heading.
+    H4,
+    /// A level 5 heading.
+    H5,
+    /// A level 6 heading.
+    H6,
+    /// A list.
+    L,
+    /// A list item.
+    LI,
+    /// The bullet or number of a list item.
+    Lbl,
+    /// The body of a list item.
+    LBody,
+    /// A table.
+    Table,
+    /// A table row.
+    TR,
+    /// A table header cell.
+    TH,
+    /// A table data cell.
+    TD,
+    /// The header rows of a table.
+    THead,
+    /// The body rows of a table.
+    TBody,
+    /// The footer rows of a table.
+    TFoot,
+    /// A run of inline text.
+    Span,
+    /// An inline quotation.
+    Quote,
+    /// A footnote or endnote.
+    Note,
+    /// A reference to elsewhere in the document.
+    Reference,
+    /// Computer code.
+    Code,
+    /// A hyperlink.
+    Link,
+    /// An image or other graphic.
+    Figure,
+    /// A mathematical formula.
+    Formula,
+    /// A form field.
+    Form,
+}
+
+const STRUCTURE_TYPES: [(StructureType, &str); 38] = [
+    (StructureType::Document, "Document"),
+    (StructureType::Part, "Part"),
+    (StructureType::Art, "Art"),
+    (StructureType::Sect, "Sect"),
+    (StructureType::Div, "Div"),
+    (StructureType::BlockQuote, "BlockQuote"),
+    (StructureType::Caption, "Caption"),
+    (StructureType::TOC, "TOC"),
+    (StructureType::TOCI, "TOCI"),
+    (StructureType::Index, "Index"),
+    (StructureType::P, "P"),
+    (StructureType::H, "H"),
+    (StructureType::H1, "H1"),
+    (StructureType::H2, "H2"),
+    (StructureType::H3, "H3"),
+    (StructureType::H4, "H4"),
+    (StructureType::H5, "H5"),
+    (StructureType::H6, "H6"),
+    (StructureType::L, "L"),
+    (StructureType::LI, "LI"),
+    (StructureType::Lbl, "Lbl"),
+    (StructureType::LBody, "LBody"),
+    (StructureType::Table, "Table"),
+    (StructureType::TR, "TR"),
+    (StructureType::TH, "TH"),
+    (StructureType::TD, "TD"),
+    (StructureType::THead, "THead"),
+    (StructureType::TBody, "TBody"),
+    (StructureType::TFoot, "TFoot"),
+    (StructureType::Span, "Span"),
+    (StructureType::Quote, "Quote"),
+    (StructureType::Note, "Note"),
+    (StructureType::Reference, "Reference"),
+    (StructureType::Code, "Code"),
+    (StructureType::Link, "Link"),
+    (StructureType::Figure, "Figure"),
+    (StructureType::Formula, "Formula"),
+    (StructureType::Form, "Form"),
+];
+
+impl StructureType {
+    /// Returns the type with the PDF name `name`, such as `"H1"`.
+    pub fn from_name(name: &str) -> Option<Self> {
+        STRUCTURE_TYPES.iter().find(|(_, n)| *n == name).map(|(t, _)| *t)
+    }
+
+    /// Returns the PDF name of the type.
+    pub fn name(self) -> &'static str {
+        STRUCTURE_TYPES.iter().find(|(t, _)| *t == self).map_or("", |(_, n)| n)
+    }
+
+    /// Returns whether elements of the type only group other elements, such
+    /// as sections, lists and table rows, rather than holding content.
+    pub fn is_grouping(self) -> bool {
+        matches!(
+            self,
+            Self::Document
+                | Self::Part
+                | Self::Art
+                | Self::Sect
+                | Self::Div
+                | Self::BlockQuote
+                | Self::TOC
+                | Self::TOCI
+                | Self::Index
+                | Self::L
+                | Self::Table
+                | Self::TR
+                | Self::THead
+                | Self::TBody
+                | Self::TFoot
+        )
+    }
+}
+
+/// A child of a structure element, in reading order.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum StructureKid {
+    /// The structure element with this index.
+    Element(usize),
+    /// The marked-content sequence with identifier `mcid` on a page.
+    MarkedContent {
+        /// Zero-based index of the page.
+        page_index: usize,
+        /// Marked-content identifier, unique on the page.
+        mcid: u32,
+    },
+}
+
+/// An element of the structure tree, such as a heading or a figure.
+#[derive(Debug, Clone, PartialEq)]
+pub struct StructureElement {
+    /// What the element is.
+    pub structure_type: StructureType,
+    /// Text read in place of the element's content, as for an image.
+    pub alt_text: Option<String>,
+    /// Child elements and content, in reading order. Child elements must
+    /// come after their parent in the document's list.
+    pub kids: Vec<StructureKid>,
+}
+
+impl StructureElement {
+    /// Creates an element with no children.
+    pub fn new(structure_type: StructureType) -> Self {
+        Self {
+            structure_type,
+            alt_text: None,
+            kids: Vec::new(),
+        }
+    }
+
+    /// Sets the alternate description of the element.
+    pub fn alt_text(mut self, text: impl Into<String>) -> Self {
+        self.alt_text = Some(text.into());
+        self
+    }
+
+    /// Returns the marked-content sequences among the element's children.
+    fn marked_content(&self) -> impl Iterator<Item = (usize, u32)> + '_ {
+        self.kids.iter().filter_map(|kid| match *kid {
+            StructureKid::MarkedContent { page_index, mcid } => Some((page_index, mcid)),
+            StructureKid::Element(_) => None,
+        })
+    }
+}
+
+/// Checks that `elements` form a tree over a document with `page_count`
+/// pages: each element has at most one parent, which comes before it, and
+/// each sequence is on a page and has an identifier used once there.
+pub(super) fn check_structure(elements: &[StructureElement], page_count: usize) -> Result<(), DocumentError> {
+    let mut has_parent = vec![false; elements.len()];
+    let mut sequences = HashSet::new();
+    for (i, element) in elements.iter().enumerate() {
+        for kid in &element.kids {
+            match *kid {
+                StructureKid::Element(child) if child <= i || child >= elements.len() => {
+                    let message = format!("child {} of element {} does not come after it", child, i);
+                    return Err(DocumentError::InvalidStructure(message));
+                }
+                StructureKid::Element(child) if has_parent[child] => {
+                    let message = format!("element {} has more than one parent", child);
+                    return Err(DocumentError::InvalidStructure(message));
+                }
+                StructureKid::Element(child) => has_parent[child] = true,
+                StructureKid::MarkedContent { page_index, .. } if page_index >= page_count => {
+                    let message = format!(
+                        "content of element {} is on page {}, which does not exist (page count is {})",
+                        i, page_index, page_count
+                    );
+                    return Err(DocumentError::InvalidStructure(message));
+                }
+                StructureKid::MarkedContent { page_index, mcid } => {
+                    if !sequences.insert((page_index, mcid)) {
+                        let message = format!("MCID {} is used twice on page {}", mcid, page_index);
+                        return Err(DocumentError::InvalidStructure(message));
+                    }
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Returns the first marked-content identifier not yet used on `page_index`.
+pub(super) fn next_mcid(elements: &[StructureElement], page_index: usize) -> u32 {
+    elements
+        .iter()
+        .flat_map(StructureElement::marked_content)
+        .filter(|&(page, _)| page == page_index)
+        .map(|(_, mcid)| mcid + 1)
+        .max()
+        .unwrap_or(0)
+}
+
+/// Returns each page's /StructParents key: the pages with marked content
+/// are numbered in order, and other pages have none.
+pub(super) fn struct_parents(elements: &[StructureElement], page_count: usize) -> Vec<Option<i64>> {
+    let mut marked = vec![false; page_count];
+    for (page_index, _) in elements.iter().flat_map(StructureElement::marked_content) {
+        marked[page_index] = true;
+    }
+    let mut next = 0;
+    marked
+        .into_iter()
+        .map(|marked| {
+            marked.then(|| {
+                next += 1;
+                next - 1
+            })
+        })
+        .collect()
+}
+
+/// Builds the structure tree root and element dictionaries.
+///
+/// `ids[i]` is the object ID of `elements[i]`, and `parents` comes from
+/// [`struct_parents`]; the elements must pass [`check_structure`].
+/// Elements without a parent are the root's children, in order.
+pub(super) fn structure_dictionaries(
+    elements: &[StructureElement],
+    root_id: ObjectId,
+    ids: &[ObjectId],
+    page_ids: &[ObjectId],
+    parents: &[Option<i64>],
+) -> (PdfDictionary, Vec<PdfDictionary>) {
+    let mut parent = vec![None; elements.len()];
+    for (i, element) in elements.iter().enumerate() {
+        for kid in &element.kids {
+            if let StructureKid::Element(child) = *kid {
+                parent[child] = Some(i);
+            }
+        }
+    }
+
+    // The parent tree maps each sequence back to its element, by MCID
+    let mut owners: Vec<Vec<Option<usize>>> = vec![Vec::new(); page_ids.len()];
+    for (i, element) in elements.iter().enumerate() {
+        for (page_index, mcid) in element.marked_content() {
+            let owners = &mut owners[page_index];
+            if owners.len() <= mcid as usize {
+                owners.resize(mcid as usize + 1, None);
+            }
+            owners[mcid as usize] = Some(i);
+        }
+    }
+    let mut nums = PdfArray::new();
+    for (page_owners, key) in owners.iter().zip(parents) {
+        if let Some(key) = key {
+            nums.push(Object::Integer(*key));
+            let refs = page_owners.iter().map(|owner| owner.map_or(Object::Null, |i| Object::Reference(ids[i])));
+            nums.push(Object::Array(refs.collect()));
+        }
+    }
+    let mut parent_tree = PdfDictionary::new();
+    parent_tree.set("Nums", Object::Array(nums));
+
+    let mut root = PdfDictionary::new();
+    root.set("Type", Object::Name(PdfName::new_unchecked("StructTreeRoot")));
+    let top: PdfArray = (0..elements.len())
+        .filter(|&i| parent[i].is_none())
+        .map(|i| Object::Reference(ids[i]))
+        .collect();
+    root.set("K", Object::Array(top));
+    root.set("ParentTree", Object::Dictionary(parent_tree));
+    if let Some(next) = parents.iter().flatten().max() {
+        root.set("ParentTreeNextKey", Object::Integer(next + 1));
+    }
+
+    let items = elements
+        .iter()
+        .enumerate()
+        .map(|(i, element)| {
+            let mut item = PdfDictionary::new();
+            item.set("Type", Object::Name(PdfName::new_unchecked("StructElem")));
+            item.set("S", Object::Name(PdfName::new_unchecked(element.structure_type.name())));
+            item.set("P", Object::Reference(parent[i].map_or(root_id, |p| ids[p])));
+
+            // Sequences on the element's page are written as bare MCIDs
+            let page = element.marked_content().next().map(|(page_index, _)| page_index);
+            if let Some(page) = page {
+                item.set("Pg", Object::Reference(page_ids[page]));
+            }
+            let kids: PdfArray = element
+                .kids
+                .iter()
+                .map(|kid| match *kid {
+                    StructureKid::Element(child) => Object::Reference(ids[child]),
+                    StructureKid::MarkedContent { page_index, mcid } if Some(page_index) == page => {
+                        Object::Integer(mcid as i64)
+                    }
+                    StructureKid::MarkedContent { page_index, mcid } => {
+                        let mut reference = PdfDictionary::new();
+                        reference.set("Type", Object::Name(PdfName::new_unchecked("MCR")));
+                        reference.set("Pg", Object::Reference(page_ids[page_index]));
+                        reference.set("MCID", Object::Integer(mcid as i64));
+                        Object::Dictionary(reference)
+                    }
+                })
+                .collect();
+            if !kids.is_empty() {
+                item.set("K", Object::Array(kids));
+            }
+            if let Some(alt) = &element.alt_text {
+                item.set("Alt", Object::String(PdfString::text(alt)));
+            }
+            item
+        })
+        .collect();
+
+    (root, items)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn paragraph(kids: Vec<StructureKid>) -> StructureElement {
+        StructureElement {
+            kids,
+            ..StructureElement::new(StructureType::P)
+        }
+    }
+
+    fn content(page_index: usize, mcid: u32) -> StructureKid {
+        StructureKid::MarkedContent { page_index, mcid }
+    }
+
+    #[test]
+    fn test_structure_type_names() {
+        assert_eq!(StructureType::from_name("H1"), Some(StructureType::H1));
+        assert_eq!(StructureType::from_name("Figure").map(StructureType::name), Some("Figure"));
+        assert_eq!(StructureType::from_name("Heading"), None);
+        assert!(StructureType::Table.is_grouping());
+        assert!(!StructureType::TD.is_grouping());
+    }
+
+    #[test]
+    fn test_check_structure() {
+        let section = StructureElement {
+            kids: vec![StructureKid::Element(1)],
+            ..StructureElement::new(StructureType::Sect)
+        };
+        assert!(check_structure(&[section.clone(), paragraph(vec![content(0, 0)])], 1).is_ok());
+        assert!(check_structure(&[paragraph(vec![content(1, 0)])], 1).is_err());
+        assert!(check_structure(&[paragraph(vec![content(0, 0), content(0, 0)])], 1).is_err());
+        let cycle = StructureElement {
+            kids: vec![StructureKid::Element(0)],
+            ..StructureElement::new(StructureType::Sect)
+        };
+        assert!(check_structure(&[cycle], 1).is_err());
+        assert!(check_structure(&[section.clone(), section, paragraph(Vec::new())], 1).is_err());
+    }
+
+    #[test]
+    fn test_next_mcid_and_struct_parents() {
+        let elements = [paragraph(vec![content(0, 0), content(2, 0)]), paragraph(vec![content(0, 1)])];
+        assert_eq!(next_mcid(&elements, 0), 2);
+        assert_eq!(next_mcid(&elements, 1), 0);
+        assert_eq!(struct_parents(&elements, 3), vec![Some(0), None, Some(1)]);
+    }
+
+    #[test]
+    fn test_structure_dictionaries() {
+        let elements = [
+            StructureElement {
+                kids: vec![content(0, 0), StructureKid::Element(1), content(1, 0)],
+                ..StructureElement::new(StructureType::P)
+            },
+            StructureElement {
+                kids: vec![content(0, 1)],
+                ..StructureElement::new(StructureType::Figure).alt_text("Logo")
+            },
+        ];
+        let ids = [ObjectId::new(11), ObjectId::new(12)];
+        let page_ids = [ObjectId::new(3), ObjectId::new(4)];
+        let parents = struct_parents(&elements, 2);
+        let (root, items) = structure_dictionaries(&elements, ObjectId::new(10), &ids, &page_ids, &parents);
+
+        assert_eq!(
+            root.to_pdf_string(),
+            "<< /Type /StructTreeRoot /K [11 0 R] /ParentTree << /Nums [0 [11 0 R 12 0 R] 1 [11 0 R]] >> \
+             /ParentTreeNextKey 2 >>"
+        );
+        assert_eq!(
+            items[0].to_pdf_string(),
+            "<< /Type /StructElem /S /P /P 10 0 R /Pg 3 0 R /K [0 12 0 R << /Type /MCR /Pg 4 0 R /MCID 0 >>] >>"
+        );
+        assert_eq!(
+            items[1].to_pdf_string(),
+            "<< /Type /StructElem /S /Figure /P 11 0 R /Pg 3 0 R /K [1] /Alt (Logo) >>"
+        );
+    }
+}
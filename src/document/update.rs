@@ -0,0 +1,636 @@
+//! Writing a document opened with `Document::open_for_update` as an
+//! incremental update of the file it was read from.
+
+use std::collections::HashMap;
+use std::io::Write;
+
+use super::{rect_to_array, Document, DocumentInfo};
+use crate::error::{DocumentError, ParserError, PdfResult};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream};
+use crate::page::{Page, PageBox};
+use crate::parser::{rectangle, IncrementalWriter, PdfReader};
+use crate::types::ObjectId;
+
+/// Prefix of the resource name a page's new content is drawn under.
+const OVERLAY_NAME: &str = "Update";
+
+/// Returns whether nothing has been added to `page`, one of the pages of the
+/// original file, so its update can be skipped.
+fn is_unchanged(document: &Document, page: &Page) -> bool {
+    page.content.operators().is_empty()
+        && page.form_fields.is_empty()
+        && page.links.is_empty()
+        && page.annotations.is_empty()
+        && page.background.is_none()
+        && document.page_numbers.is_none()
+        && document.watermark.is_none()
+}
+
+/// Writes the original file followed by an update holding the new pages,
+/// the content added to the original pages, and the new document
+/// information. A document with no changes is written as it was read.
+pub(super) fn write_update<W: Write>(document: &Document, original: &PdfReader, mut writer: W) -> PdfResult<()> {
+    #[cfg(feature = "encryption")]
+    if document.encryption.is_some() {
+        return Err(DocumentError::NotIncremental("encryption").into());
+    }
+    let unsupported = [
+        ("bookmarks", !document.bookmarks.is_empty()),
+        ("attachments", !document.attachments.is_empty()),
+        ("named destinations", !document.destinations.is_empty()),
+        ("the initial view", !document.initial_view.is_empty()),
+        ("a language", document.language.is_some()),
+        ("tagged structure", !document.structure.is_empty()),
+        ("layers", !document.layers.is_empty()),
+        ("PDF/A conformance", document.pdfa.is_some()),
+        ("XMP metadata", document.xmp.is_some()),
+        ("a file identifier", document.file_id.is_some()),
+        ("linearization", document.linearized),
+    ];
+    if let Some((setting, _)) = unsupported.iter().find(|(_, used)| *used) {
+        return Err(DocumentError::NotIncremental(*setting).into());
+    }
+
+    let originals = original.leaf_pages()?;
+    let changed: Vec<bool> = document
+        .pages
+        .iter()
+        .zip(&originals)
+        .map(|(page, _)| !is_unchanged(document, page))
+        .collect();
+    let rotated: Vec<bool> = document
+        .pages
+        .iter()
+        .zip(&originals)
+        .map(|(page, (_, leaf))| page.rotation.rem_euclid(360) != rotation(original, leaf))
+        .collect();
+    let reboxed: Vec<bool> = document
+        .pages
+        .iter()
+        .zip(&originals)
+        .map(|(page, (_, leaf))| {
+            PageBox::ALL
+                .iter()
+                .any(|&kind| page.page_box(kind) != leaf.get(kind.key()).and_then(rectangle))
+        })
+        .collect();
+    if document.pages.len() == originals.len()
+        && !changed.contains(&true)
+        && !rotated.contains(&true)
+        && !reboxed.contains(&true)
+        && document.info.is_empty()
+    {
+        writer.write_all(original.raw_data())?;
+        return Ok(());
+    }
+
+    // Serialize the additions as a document of their own, then move its
+    // objects into the update
+    let mut additions = document.clone();
+    additions.original = None;
+    additions.info = DocumentInfo::new();
+    additions.object_streams = false;
+    // Backgrounds of the original pages go beneath their old content
+    // rather than in the new content drawn over it
+    for page in additions.pages.iter_mut().take(originals.len()) {
+        page.background = None;
+    }
+    let additions = PdfReader::from_bytes(additions.save_to_bytes()?)?;
+    let added = additions.leaf_pages()?;
+
+    let pages_id = match original.catalog().and_then(|catalog| catalog.get("Pages").cloned()) {
+        Some(Object::Reference(id)) => id,
+        _ => return Err(ParserError::InvalidPageTree("missing /Pages".to_string()).into()),
+    };
+    let mut copier = Copier {
+        reader: &additions,
+        update: IncrementalWriter::new(original),
+        numbers: HashMap::new(),
+    };
+    // References to the pages drawn over, and to the page tree, keep
+    // pointing at the originals, and new pages are numbered up front so
+    // links to them are not copied as ordinary objects
+    for ((id, _), (added_id, _)) in originals.iter().zip(&added) {
+        copier.numbers.insert(added_id.number, *id);
+    }
+    if let Some(Object::Reference(id)) = additions.catalog().and_then(|catalog| catalog.get("Pages").cloned()) {
+        copier.numbers.insert(id.number, pages_id);
+    }
+    let new_pages = &added[originals.len().min(added.len())..];
+    for (id, _) in new_pages {
+        let number = copier.update.allocate();
+        copier.numbers.insert(id.number, number);
+    }
+
+    let mut overlay_name = None;
+    for (i, ((id, leaf), (_, page))) in originals.iter().zip(&added).enumerate() {
+        let mut page = if changed[i] {
+            let name = overlay_name.get_or_insert_with(|| unused_name(original, &originals));
+            let background = document.pages[i].background_content();
+            let background = background.map(|b| b.build_string_with_precision(document.coordinate_precision));
+            overlay_page(&mut copier, original, *id, leaf, page, name, background)?
+        } else if rotated[i] || reboxed[i] {
+            match original.resolve_reference(*id) {
+                Some(Object::Dictionary(page)) => page,
+                _ => return Err(ParserError::ObjectNotFound(id.number, id.generation).into()),
+            }
+        } else {
+            continue;
+        };
+        if rotated[i] {
+            let degrees = document.pages[i].rotation.rem_euclid(360);
+            page.set("Rotate", Object::Integer(degrees as i64));
+        }
+        if reboxed[i] {
+            set_page_boxes(&mut page, leaf, &document.pages[i]);
+        }
+        copier.update.write_object(*id, &Object::Dictionary(page))?;
+    }
+
+    if !new_pages.is_empty() {
+        let mut root = match original.resolve_reference(pages_id) {
+            Some(Object::Dictionary(root)) => root,
+            _ => return Err(ParserError::InvalidPageTree("/Pages is not a dictionary".to_string()).into()),
+        };
+        for (id, _) in new_pages {
+            let mut page = match additions.resolve_reference(*id) {
+                Some(Object::Dictionary(page)) => page,
+                _ => return Err(ParserError::ObjectNotFound(id.number, id.generation).into()),
+            };
+            // Attributes the original tree would pass on to the page
+            for key in ["Resources", "CropBox", "Rotate"] {
+                if root.contains_key(key) && !page.contains_key(key) {
+                    let value = match key {
+                        "Resources" => Object::Dictionary(PdfDictionary::new()),
+                        "CropBox" => page.get("MediaBox").cloned().unwrap_or(Object::Null),
+                        _ => Object::Integer(0),
+                    };
+                    page.set(key, value);
+                }
+            }
+            let page = copier.copy(&Object::Dictionary(page))?;
+            copier.update.write_object(copier.numbers[&id.number], &page)?;
+        }
+
+        let mut kids = match root.get("Kids").and_then(|kids| resolve(original, kids)) {
+            Some(Object::Array(kids)) => kids,
+            _ => PdfArray::new(),
+        };
+        for (id, _) in new_pages {
+            kids.push(Object::Reference(copier.numbers[&id.number]));
+        }
+        let count = match root.get("Count") {
+            Some(Object::Integer(count)) => *count,
+            _ => originals.len() as i64,
+        };
+        root.set("Kids", Object::Array(kids));
+        root.set("Count", Object::Integer(count + new_pages.len() as i64));
+        copier.update.write_object(pages_id, &Object::Dictionary(root))?;
+    }
+
+    merge_form(&mut copier, original)?;
+
+    if !document.info.is_empty() {
+        let mut info = match original.info() {
+            Some(info) => info,
+            None => PdfDictionary::new(),
+        };
+        for (key, value) in document.info.to_dictionary().iter() {
+            info.set(key.clone(), value.clone());
+        }
+        let id = match original.trailer().info {
+            Some(id) => id,
+            None => copier.update.allocate(),
+        };
+        copier.update.write_object(id, &Object::Dictionary(info))?;
+        copier.update.set_info(id);
+    }
+
+    writer.write_all(&copier.update.finish()?)?;
+    Ok(())
+}
+
+/// Replaces the boundaries of the original page dictionary `dict`, whose
+/// leaf with inherited attributes is `leaf`, with those of `page`. A crop box
+/// the page tree passes on is overridden with the media box, as it cannot be
+/// removed.
+fn set_page_boxes(dict: &mut PdfDictionary, leaf: &PdfDictionary, page: &Page) {
+    for kind in PageBox::ALL {
+        match page.page_box(kind) {
+            Some(rect) => dict.set(kind.key(), Object::Array(rect_to_array(&rect))),
+            None => {
+                dict.remove(kind.key());
+            }
+        }
+    }
+    if page.crop_box.is_none() && leaf.contains_key("CropBox") && !dict.contains_key("CropBox") {
+        dict.set("CropBox", Object::Array(rect_to_array(&page.media_box)));
+    }
+}
+
+/// Returns the rotation of the original page `leaf` in degrees, from 0 to 270.
+pub(super) fn rotation(original: &PdfReader, leaf: &PdfDictionary) -> i32 {
+    match leaf.get("Rotate").and_then(|r| resolve(original, r)) {
+        Some(Object::Integer(degrees)) => degrees.rem_euclid(360) as i32,
+        _ => 0,
+    }
+}
+
+/// Returns a resource name of the form `UpdateN` that no XObject of the
+/// original pages has.
+fn unused_name(original: &PdfReader, pages: &[(ObjectId, PdfDictionary)]) -> String {
+    let taken = |name: &str| {
+        pages.iter().any(|(_, page)| {
+            let resources = page.get("Resources").and_then(|r| resolve(original, r));
+            let xobjects = match resources {
+                Some(Object::Dictionary(resources)) => resources.get("XObject").and_then(|x| resolve(original, x)),
+                _ => None,
+            };
+            matches!(xobjects, Some(Object::Dictionary(xobjects)) if xobjects.contains_key(name))
+        })
+    };
+    (1..)
+        .map(|n| format!("{}{}", OVERLAY_NAME, n))
+        .find(|name| !taken(name))
+        .expect("names are unbounded")
+}
+
+/// Returns a new revision of the original page `id` that draws the content
+/// of `added`, its counterpart among the additions, as a form XObject named
+/// `name` over its own, and lists the annotations of both. The operators of
+/// `background` are drawn beneath the original content.
+fn overlay_page(
+    copier: &mut Copier,
+    original: &PdfReader,
+    id: ObjectId,
+    leaf: &PdfDictionary,
+    added: &PdfDictionary,
+    name: &str,
+    background: Option<String>,
+) -> PdfResult<PdfDictionary> {
+    let content = match added.get("Contents").and_then(|c| resolve(copier.reader, c)) {
+        Some(Object::Stream(stream)) => stream,
+        _ => return Err(ParserError::InvalidPageTree("new page content is not a stream".to_string()).into()),
+    };
+    let mut form = PdfDictionary::new();
+    form.set("Type", Object::Name(PdfName::new_unchecked("XObject")));
+    form.set("Subtype", Object::Name(PdfName::new_unchecked("Form")));
+    if let Some(media_box) = added.get("MediaBox") {
+        form.set("BBox", media_box.clone());
+    }
+    if let Some(resources) = added.get("Resources") {
+        form.set("Resources", copier.copy(resources)?);
+    }
+    for key in ["Filter", "DecodeParms"] {
+        if let Some(value) = content.dictionary.get(key) {
+            form.set(key, value.clone());
+        }
+    }
+    let form_id = copier.update.allocate();
+    let form = PdfStream::with_dictionary(form, content.data.clone());
+    copier.update.write_object(form_id, &Object::Stream(form))?;
+
+    let mut page = match original.resolve_reference(id) {
+        Some(Object::Dictionary(page)) => page,
+        _ => return Err(ParserError::ObjectNotFound(id.number, id.generation).into()),
+    };
+
+    // The resources may be inherited or shared with other pages, so the
+    // page gets a copy of its own
+    let mut resources = match leaf.get("Resources").and_then(|r| resolve(original, r)) {
+        Some(Object::Dictionary(resources)) => resources,
+        _ => PdfDictionary::new(),
+    };
+    let mut xobjects = match resources.get("XObject").and_then(|x| resolve(original, x)) {
+        Some(Object::Dictionary(xobjects)) => xobjects,
+        _ => PdfDictionary::new(),
+    };
+    xobjects.set(name, Object::Reference(form_id));
+    resources.set("XObject", Object::Dictionary(xobjects));
+    page.set("Resources", Object::Dictionary(resources));
+
+    // The original content is wrapped in q/Q so its graphics state does not
+    // affect the new content
+    let mut contents = PdfArray::new();
+    let open_id = copier.update.allocate();
+    let open = match background {
+        Some(background) => format!("{}\nq\n", background),
+        None => "q\n".to_string(),
+    };
+    copier.update.write_object(open_id, &Object::Stream(PdfStream::from_text(open)))?;
+    contents.push(Object::Reference(open_id));
+    let streams = match page.get("Contents") {
+        Some(Object::Reference(id)) => match original.resolve_reference(*id) {
+            Some(Object::Array(streams)) => streams,
+            _ => PdfArray::from_objects(vec![Object::Reference(*id)]),
+        },
+        Some(Object::Array(streams)) => streams.clone(),
+        _ => PdfArray::new(),
+    };
+    for stream in streams.iter() {
+        contents.push(stream.clone());
+    }
+    let close_id = copier.update.allocate();
+    let close = format!("Q\nq /{} Do Q\n", name);
+    copier.update.write_object(close_id, &Object::Stream(PdfStream::from_text(close)))?;
+    contents.push(Object::Reference(close_id));
+    page.set("Contents", Object::Array(contents));
+
+    if let Some(Object::Array(new_annots)) = added.get("Annots").and_then(|a| resolve(copier.reader, a)) {
+        let mut annots = match page.get("Annots").and_then(|a| resolve(original, a)) {
+            Some(Object::Array(annots)) => annots,
+            _ => PdfArray::new(),
+        };
+        for annot in new_annots.iter() {
+            let copy = copier.copy(annot)?;
+            annots.push(copy);
+        }
+        page.set("Annots", Object::Array(annots));
+    }
+    Ok(page)
+}
+
+/// Adds the form fields of the additions to the form of the original file.
+fn merge_form(copier: &mut Copier, original: &PdfReader) -> PdfResult<()> {
+    let added = match copier.reader.catalog().and_then(|c| c.get("AcroForm").cloned()) {
+        Some(form) => match resolve(copier.reader, &form) {
+            Some(Object::Dictionary(form)) => form,
+            _ => return Ok(()),
+        },
+        None => return Ok(()),
+    };
+    let root = original.trailer().root;
+    let mut catalog = original.catalog().unwrap_or_default();
+    let (form_id, form) = match catalog.get("AcroForm") {
+        Some(Object::Reference(id)) => (Some(*id), original.resolve_reference(*id)),
+        Some(form) => (None, Some(form.clone())),
+        None => (None, None),
+    };
+    let form = match form {
+        Some(Object::Dictionary(mut form)) => {
+            let mut fields = match form.get("Fields").and_then(|f| resolve(original, f)) {
+                Some(Object::Array(fields)) => fields,
+                _ => PdfArray::new(),
+            };
+            if let Some(Object::Array(new_fields)) = added.get("Fields") {
+                for field in new_fields.iter() {
+                    let copy = copier.copy(field)?;
+                    fields.push(copy);
+                }
+            }
+            form.set("Fields", Object::Array(fields));
+            if !form.contains_key("DR") {
+                if let Some(resources) = added.get("DR") {
+                    form.set("DR", copier.copy(resources)?);
+                }
+            }
+            form
+        }
+        _ => copier.copy_dictionary(&added)?,
+    };
+    match form_id {
+        Some(id) => copier.update.write_object(id, &Object::Dictionary(form))?,
+        None => {
+            catalog.set("AcroForm", Object::Dictionary(form));
+            copier.update.write_object(root, &Object::Dictionary(catalog))?;
+        }
+    }
+    Ok(())
+}
+
+/// Resolves `object` if it is a reference.
+fn resolve(reader: &PdfReader, object: &Object) -> Option<Object> {
+    match object {
+        Object::Reference(id) => reader.resolve_reference(*id),
+        other => Some(other.clone()),
+    }
+}
+
+/// Copies objects of the additions into the update, renumbering them after
+/// the objects of the original file. Each object is copied once.
+struct Copier<'a> {
+    reader: &'a PdfReader,
+    update: IncrementalWriter,
+    /// The number in the update of each object copied, by its number in
+    /// the additions.
+    numbers: HashMap<u32, ObjectId>,
+}
+
+impl Copier<'_> {
+    /// Copies `object`, writing every object it references that has not
+    /// been copied yet.
+    fn copy(&mut self, object: &Object) -> PdfResult<Object> {
+        Ok(match object {
+            Object::Reference(id) => Object::Reference(self.copy_reference(*id)?),
+            Object::Array(array) => {
+                let mut copy = PdfArray::new();
+                for item in array.iter() {
+                    copy.push(self.copy(item)?);
+                }
+                Object::Array(copy)
+            }
+            Object::Dictionary(dict) => Object::Dictionary(self.copy_dictionary(dict)?),
+            Object::Stream(stream) => {
+                Object::Stream(PdfStream::with_dictionary(self.copy_dictionary(&stream.dictionary)?, stream.data.clone()))
+            }
+            other => other.clone(),
+        })
+    }
+
+    fn copy_dictionary(&mut self, dict: &PdfDictionary) -> PdfResult<PdfDictionary> {
+        let mut copy = PdfDictionary::with_capacity(dict.len());
+        for (key, value) in dict.iter() {
+            copy.set(key.clone(), self.copy(value)?);
+        }
+        Ok(copy)
+    }
+
+    /// Returns the number of the copy of object `id`, writing it on first use.
+    fn copy_reference(&mut self, id: ObjectId) -> PdfResult<ObjectId> {
+        if let Some(&number) = self.numbers.get(&id.number) {
+            return Ok(number);
+        }
+        // Register the number first so reference cycles terminate
+        let number = self.update.allocate();
+        self.numbers.insert(id.number, number);
+        let object = match self.reader.resolve_reference(id) {
+            Some(object) => self.copy(&object)?,
+            None => Object::Null,
+        };
+        self.update.write_object(number, &object)?;
+        Ok(number)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::prelude::*;
+
+    fn original(object_streams: bool) -> Vec<u8> {
+        let page = PageBuilder::a4()
+            .font("F1", Standard14Font::Helvetica)
+            .content(ContentBuilder::new().text("F1", 12.0, 72.0, 720.0, "Original"))
+            .build();
+        let doc = DocumentBuilder::new().title("First").page(page).object_streams(object_streams).build().unwrap();
+        doc.save_to_bytes().unwrap()
+    }
+
+    #[test]
+    fn test_unchanged_update() {
+        let data = original(false);
+        let doc = Document::open_for_update(data.clone()).unwrap();
+        assert_eq!(doc.page_count(), 1);
+        assert_eq!(doc.pages[0].media_box, Rectangle::a4());
+        assert_eq!(doc.save_to_bytes().unwrap(), data);
+    }
+
+    #[test]
+    fn test_update_rotation() {
+        let page = PageBuilder::a4().rotation(90).build();
+        let data = DocumentBuilder::new().page(page).build().unwrap().save_to_bytes().unwrap();
+        let mut doc = Document::open_for_update(data.clone()).unwrap();
+        assert_eq!(doc.pages[0].rotation, 90);
+        assert_eq!(doc.save_to_bytes().unwrap(), data);
+
+        doc.pages[0].rotation = -90;
+        let updated = doc.save_to_bytes().unwrap();
+        assert!(updated.starts_with(&data));
+        let reader = PdfReader::from_bytes(updated).unwrap();
+        let pages = reader.leaf_pages().unwrap();
+        assert_eq!(pages[0].1.get("Rotate"), Some(&Object::Integer(270)));
+        // Only the page is revised, as its content has not changed
+        assert!(!pages[0].1.contains_key("Resources"));
+    }
+
+    #[test]
+    fn test_update_page_boxes() {
+        let trim = Rectangle::new(9.0, 9.0, 586.0, 833.0);
+        let page = PageBuilder::a4().trim_box(trim).build();
+        let data = DocumentBuilder::new().page(page).build().unwrap().save_to_bytes().unwrap();
+        let mut doc = Document::open_for_update(data.clone()).unwrap();
+        assert_eq!(doc.pages[0].trim_box, Some(trim));
+        assert_eq!(doc.save_to_bytes().unwrap(), data);
+
+        doc.pages[0].trim_box = None;
+        doc.pages[0].bleed_box = Some(Rectangle::a4());
+        let reader = PdfReader::from_bytes(doc.save_to_bytes().unwrap()).unwrap();
+        let pages = reader.leaf_pages().unwrap();
+        assert!(!pages[0].1.contains_key("TrimBox"));
+        assert_eq!(pages[0].1.get("BleedBox").and_then(rectangle), Some(Rectangle::a4()));
+    }
+
+    #[test]
+    fn test_update() {
+        for object_streams in [false, true] {
+            let data = original(object_streams);
+            let mut doc = Document::open_for_update(data.clone()).unwrap();
+            doc.compress_streams = false;
+            doc.info.author = Some("Reviewer".to_string());
+            let page = &mut doc.pages[0];
+            page.add_font("F1", Standard14Font::Courier.into());
+            page.content = ContentBuilder::new().text("F1", 10.0, 72.0, 700.0, "Approved");
+            doc.add_page(PageBuilder::letter().build());
+            let updated = doc.save_to_bytes().unwrap();
+            assert!(updated.starts_with(&data));
+
+            let reader = PdfReader::from_bytes(updated).unwrap();
+            assert_eq!(reader.page_count(), 2);
+            let trailer = &reader.trailer().dict;
+            assert_eq!(trailer.get("Type").is_some(), object_streams);
+            let info = reader.info().unwrap();
+            assert_eq!(info.get("Title"), Some(&Object::String(crate::object::PdfString::text("First"))));
+            assert_eq!(info.get("Author"), Some(&Object::String(crate::object::PdfString::text("Reviewer"))));
+
+            // The original content is wrapped and followed by the new
+            let pages = reader.leaf_pages().unwrap();
+            let contents = match pages[0].1.get("Contents") {
+                Some(Object::Array(contents)) => contents.clone(),
+                other => panic!("unexpected {:?}", other),
+            };
+            assert_eq!(contents.len(), 3);
+            let text = |object: &Object| match resolve(&reader, object) {
+                Some(Object::Stream(stream)) => String::from_utf8_lossy(&stream.data).into_owned(),
+                other => panic!("unexpected {:?}", other),
+            };
+            assert_eq!(text(contents.get(0).unwrap()), "q\n");
+            assert!(text(contents.get(1).unwrap()).contains("(Original) Tj"));
+            assert_eq!(text(contents.get(2).unwrap()), "Q\nq /Update1 Do Q\n");
+            // The page keeps its own fonts besides the new form
+            let resources = match pages[0].1.get("Resources") {
+                Some(Object::Dictionary(resources)) => resources.clone(),
+                other => panic!("unexpected {:?}", other),
+            };
+            assert!(resources.contains_key("Font"));
+            let form = match resources.get("XObject") {
+                Some(Object::Dictionary(xobjects)) => xobjects.get("Update1").cloned().unwrap(),
+                other => panic!("unexpected {:?}", other),
+            };
+            assert!(text(&form).contains("(Approved) Tj"));
+            let new_box = pages[1].1.get("MediaBox").and_then(crate::parser::rectangle);
+            assert_eq!(new_box, Some(Rectangle::letter()));
+        }
+    }
+
+    #[test]
+    fn test_update_background() {
+        let mut doc = Document::open_for_update(original(false)).unwrap();
+        doc.compress_streams = false;
+        doc.pages[0].background = Some(Color::RED);
+        let reader = PdfReader::from_bytes(doc.save_to_bytes().unwrap()).unwrap();
+        let pages = reader.leaf_pages().unwrap();
+        let text = |object: &Object| match resolve(&reader, object) {
+            Some(Object::Stream(stream)) => String::from_utf8_lossy(&stream.data).into_owned(),
+            other => panic!("unexpected {:?}", other),
+        };
+        let contents = match pages[0].1.get("Contents") {
+            Some(Object::Array(contents)) => contents.clone(),
+            other => panic!("unexpected {:?}", other),
+        };
+        assert_eq!(text(contents.get(0).unwrap()), "q\n1 0 0 rg\n0 0 595 842 re\nf\nQ\nq\n");
+        let form = match pages[0].1.get("Resources") {
+            Some(Object::Dictionary(resources)) => match resources.get("XObject") {
+                Some(Object::Dictionary(xobjects)) => xobjects.get("Update1").cloned().unwrap(),
+                other => panic!("unexpected {:?}", other),
+            },
+            other => panic!("unexpected {:?}", other),
+        };
+        assert!(!text(&form).contains(" re"));
+    }
+
+    #[test]
+    fn test_chained_updates() {
+        let first = original(false);
+        let mut doc = Document::open_for_update(first.clone()).unwrap();
+        doc.add_page(PageBuilder::a4().build());
+        let second = doc.save_to_bytes().unwrap();
+        let mut doc = Document::open_for_update(second.clone()).unwrap();
+        assert_eq!(doc.page_count(), 2);
+        doc.add_page(PageBuilder::a4().build());
+        let third = doc.save_to_bytes().unwrap();
+        assert!(third.starts_with(&second) && second.starts_with(&first));
+        assert_eq!(PdfReader::from_bytes(third).unwrap().page_count(), 3);
+    }
+
+    #[test]
+    fn test_update_rejects_document_settings() {
+        let mut doc = Document::open_for_update(original(false)).unwrap();
+        doc.add_bookmark(super::super::Bookmark::new("Start", 0)).unwrap();
+        assert!(matches!(
+            doc.save_to_bytes(),
+            Err(crate::error::PdfError::Document(DocumentError::NotIncremental("bookmarks")))
+        ));
+    }
+
+    #[test]
+    fn test_update_keeps_original_pages_in_place() {
+        let mut doc = Document::open_for_update(original(false)).unwrap();
+        doc.add_page(PageBuilder::a4().build());
+        doc.add_page(PageBuilder::letter().build());
+        assert!(doc.move_page(0, 1).is_err());
+        assert!(doc.delete_page(0).is_err());
+        // Pages added in the update can be rearranged
+        doc.move_page(2, 1).unwrap();
+        assert_eq!(doc.delete_page(2).unwrap().media_box, Rectangle::a4());
+    }
+}
@@ -65,6 +65,22 @@ impl PdfVersion {
         }
     }
 
+    /// Returns the version with the given major and minor numbers, if it exists.
+    pub fn from_numbers(major: u8, minor: u8) -> Option<Self> {
+        match (major, minor) {
+            (1, 0) => Some(PdfVersion::V1_0),
+            (1, 1) => Some(PdfVersion::V1_1),
+            (1, 2) => Some(PdfVersion::V1_2),
+            (1, 3) => Some(PdfVersion::V1_3),
+            (1, 4) => Some(PdfVersion::V1_4),
+            (1, 5) => Some(PdfVersion::V1_5),
+            (1, 6) => Some(PdfVersion::V1_6),
+            (1, 7) => Some(PdfVersion::V1_7),
+            (2, 0) => Some(PdfVersion::V2_0),
+            _ => None,
+        }
+    }
+
     /// Checks if a feature is supported in this version.
     ///
     /// Returns true if the feature requires a version <= this version.
@@ -125,6 +141,14 @@ mod tests {
         assert_eq!(PdfVersion::default(), PdfVersion::V1_7);
     }
 
+    #[test]
+    fn test_from_numbers() {
+        assert_eq!(PdfVersion::from_numbers(1, 4), Some(PdfVersion::V1_4));
+        assert_eq!(PdfVersion::from_numbers(2, 0), Some(PdfVersion::V2_0));
+        assert_eq!(PdfVersion::from_numbers(1, 8), None);
+        assert_eq!(PdfVersion::from_numbers(2, 1), None);
+    }
+
     #[test]
     fn test_try_from() {
         assert_eq!(PdfVersion::try_from("1.7"), Ok(PdfVersion::V1_7));
@@ -0,0 +1,198 @@
+//! How viewers show a document when they open it.
+
+use super::PdfVersion;
+use crate::error::DocumentError;
+use crate::object::{Object, PdfArray, PdfName};
+use crate::types::ObjectId;
+
+/// Magnification of the first page when the document opens.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Zoom {
+    /// The whole page fits the window.
+    FitPage,
+    /// The page width fits the window.
+    FitWidth,
+    /// A fixed magnification, in percent of the actual size.
+    Percent(f64),
+}
+
+/// How pages are arranged in the window.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PageLayout {
+    /// One page at a time.
+    SinglePage,
+    /// Pages in one continuous column.
+    OneColumn,
+    /// Pages in two continuous columns, odd pages on the left.
+    TwoColumnLeft,
+    /// Pages in two continuous columns, odd pages on the right.
+    TwoColumnRight,
+    /// Two pages at a time, odd pages on the left.
+    TwoPageLeft,
+    /// Two pages at a time, odd pages on the right, as in a printed spread.
+    TwoPageRight,
+}
+
+impl PageLayout {
+    fn name(self) -> &'static str {
+        match self {
+            Self::SinglePage => "SinglePage",
+            Self::OneColumn => "OneColumn",
+            Self::TwoColumnLeft => "TwoColumnLeft",
+            Self::TwoColumnRight => "TwoColumnRight",
+            Self::TwoPageLeft => "TwoPageLeft",
+            Self::TwoPageRight => "TwoPageRight",
+        }
+    }
+}
+
+/// Which panel, if any, is shown beside the pages.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PageMode {
+    /// No panel.
+    UseNone,
+    /// The bookmarks.
+    UseOutlines,
+    /// Page thumbnails.
+    UseThumbs,
+    /// Full-screen mode, with no menu bar or panels.
+    FullScreen,
+    /// The file attachments.
+    UseAttachments,
+}
+
+impl PageMode {
+    fn name(self) -> &'static str {
+        match self {
+            Self::UseNone => "UseNone",
+            Self::UseOutlines => "UseOutlines",
+            Self::UseThumbs => "UseThumbs",
+            Self::FullScreen => "FullScreen",
+            Self::UseAttachments => "UseAttachments",
+        }
+    }
+}
+
+/// The view a document opens with. Unset parts are left to the viewer,
+/// except the panel: documents with bookmarks show them, and others show
+/// none once any part of the view is set.
+#[derive(Debug, Clone, Copy, PartialEq, Default)]
+pub struct InitialView {
+    /// Magnification of the first page.
+    pub zoom: Option<Zoom>,
+    /// Arrangement of the pages.
+    pub layout: Option<PageLayout>,
+    /// Panel shown beside the pages.
+    pub mode: Option<PageMode>,
+}
+
+impl InitialView {
+    /// Creates a view that leaves everything to the viewer.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Sets the magnification of the first page.
+    pub fn zoom(mut self, zoom: Zoom) -> Self {
+        self.zoom = Some(zoom);
+        self
+    }
+
+    /// Sets the arrangement of the pages.
+    pub fn layout(mut self, layout: PageLayout) -> Self {
+        self.layout = Some(layout);
+        self
+    }
+
+    /// Sets the panel shown beside the pages.
+    pub fn mode(mut self, mode: PageMode) -> Self {
+        self.mode = Some(mode);
+        self
+    }
+
+    /// Returns whether every part is left to the viewer.
+    pub fn is_empty(&self) -> bool {
+        *self == Self::default()
+    }
+
+    /// Returns the features of the view that need a newer PDF than 1.0,
+    /// with the version each needs.
+    pub(super) fn required_versions(&self) -> Vec<(&'static str, PdfVersion)> {
+        let mut required = Vec::new();
+        if matches!(self.layout, Some(PageLayout::TwoPageLeft | PageLayout::TwoPageRight)) {
+            required.push(("Two-page layouts", PdfVersion::V1_5));
+        }
+        if self.mode == Some(PageMode::UseAttachments) {
+            required.push(("The attachments page mode", PdfVersion::V1_6));
+        }
+        required
+    }
+
+    /// Returns the catalog's /PageLayout name, if set.
+    pub(super) fn page_layout(&self) -> Option<Object> {
+        self.layout.map(|layout| Object::Name(PdfName::new_unchecked(layout.name())))
+    }
+
+    /// Returns the catalog's /PageMode name. Without a mode, documents with
+    /// an outline show it, and others set no panel once any part of the view
+    /// is set.
+    pub(super) fn page_mode(&self, has_outline: bool) -> Option<Object> {
+        let mode = match self.mode {
+            Some(mode) => mode,
+            None if has_outline => PageMode::UseOutlines,
+            None if !self.is_empty() => PageMode::UseNone,
+            None => return None,
+        };
+        Some(Object::Name(PdfName::new_unchecked(mode.name())))
+    }
+}
+
+/// Checks that a fixed zoom is a positive percentage.
+pub(super) fn check_zoom(zoom: Zoom) -> Result<(), DocumentError> {
+    match zoom {
+        Zoom::Percent(percent) if !(percent.is_finite() && percent > 0.0) => Err(DocumentError::InvalidZoom(percent)),
+        _ => Ok(()),
+    }
+}
+
+/// Builds the /OpenAction destination that shows `first_page` at `zoom`.
+pub(super) fn open_action(zoom: Zoom, first_page: ObjectId) -> PdfArray {
+    let mut dest = PdfArray::new();
+    dest.push(Object::Reference(first_page));
+    match zoom {
+        Zoom::FitPage => dest.push(Object::Name(PdfName::new_unchecked("Fit"))),
+        Zoom::FitWidth => {
+            // A null top keeps the page scrolled to its top
+            dest.push(Object::Name(PdfName::new_unchecked("FitH")));
+            dest.push(Object::Null);
+        }
+        Zoom::Percent(percent) => {
+            dest.push(Object::Name(PdfName::new_unchecked("XYZ")));
+            dest.push(Object::Null);
+            dest.push(Object::Null);
+            dest.push(Object::Real(percent / 100.0));
+        }
+    }
+    dest
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_open_action() {
+        let page = ObjectId::new(3);
+        assert_eq!(open_action(Zoom::FitPage, page).to_pdf_string(), "[3 0 R /Fit]");
+        assert_eq!(open_action(Zoom::FitWidth, page).to_pdf_string(), "[3 0 R /FitH null]");
+        assert_eq!(open_action(Zoom::Percent(150.0), page).to_pdf_string(), "[3 0 R /XYZ null null 1.5]");
+    }
+
+    #[test]
+    fn test_check_zoom() {
+        assert!(check_zoom(Zoom::Percent(25.0)).is_ok());
+        assert!(check_zoom(Zoom::Percent(0.0)).is_err());
+        assert!(check_zoom(Zoom::Percent(f64::NAN)).is_err());
+        assert!(check_zoom(Zoom::FitWidth).is_ok());
+    }
+}
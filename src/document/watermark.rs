@@ -0,0 +1,112 @@
+//! Semi-transparent text stamped across every page when a document is written.
+
+use crate::content::{ContentBuilder, TextBuilder};
+use crate::font::calculate_helvetica_width;
+use crate::object::{Object, PdfDictionary, PdfName};
+use crate::types::{Matrix, Rectangle};
+
+/// Resource name of the font used for watermarks.
+pub(super) const WATERMARK_FONT_NAME: &str = "FWatermark";
+
+/// Resource name of the graphics state that sets the watermark opacity.
+pub(super) const WATERMARK_GSTATE_NAME: &str = "GSWatermark";
+
+/// Height of Helvetica capitals as a fraction of the font size, used to
+/// center the text vertically.
+const CAP_HEIGHT: f64 = 0.718;
+
+/// Text drawn over the center of every page of a document.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Watermark {
+    /// The text, set in Helvetica.
+    pub text: String,
+    /// Font size in points.
+    pub font_size: f64,
+    /// Opacity from 0.0 (invisible) to 1.0 (opaque).
+    pub opacity: f64,
+    /// Counterclockwise rotation in degrees.
+    pub angle: f64,
+}
+
+impl Watermark {
+    /// Creates a 60pt watermark at 30% opacity, rotated 45 degrees.
+    pub fn new(text: impl Into<String>) -> Self {
+        Self {
+            text: text.into(),
+            font_size: 60.0,
+            opacity: 0.3,
+            angle: 45.0,
+        }
+    }
+
+    /// Sets the font size.
+    pub fn font_size(mut self, size: f64) -> Self {
+        self.font_size = size;
+        self
+    }
+
+    /// Sets the opacity, clamped to 0.0-1.0.
+    pub fn opacity(mut self, opacity: f64) -> Self {
+        self.opacity = opacity.clamp(0.0, 1.0);
+        self
+    }
+
+    /// Sets the rotation.
+    pub fn angle(mut self, degrees: f64) -> Self {
+        self.angle = degrees;
+        self
+    }
+
+    /// Builds the graphics state dictionary that applies the opacity to filled text.
+    pub(super) fn ext_gstate(&self) -> PdfDictionary {
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("ExtGState")));
+        dict.set("ca", Object::Real(self.opacity.clamp(0.0, 1.0)));
+        dict
+    }
+
+    /// Builds the operators that draw the watermark centered on a page, in black.
+    pub(super) fn overlay(&self, media_box: &Rectangle) -> ContentBuilder {
+        let width = calculate_helvetica_width(&self.text, self.font_size);
+        let center_x = media_box.llx + media_box.width() / 2.0;
+        let center_y = media_box.lly + media_box.height() / 2.0;
+        let matrix = Matrix::rotate_degrees(self.angle).multiply(&Matrix::translate(center_x, center_y));
+
+        ContentBuilder::new()
+            .save_state()
+            .ext_gstate(WATERMARK_GSTATE_NAME)
+            .raw("0 g")
+            .transform(matrix)
+            .text_block(
+                TextBuilder::new()
+                    .font(WATERMARK_FONT_NAME, self.font_size)
+                    .move_to(-width / 2.0, -self.font_size * CAP_HEIGHT / 2.0)
+                    .show(self.text.clone()),
+            )
+            .restore_state()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_overlay_is_centered() {
+        // "DRAFT" is 33.33pt wide at 10pt.
+        let watermark = Watermark::new("DRAFT").font_size(10.0).angle(0.0);
+        let ops = watermark.overlay(&Rectangle::new(0.0, 0.0, 200.0, 300.0)).build_string();
+        assert!(ops.starts_with("q\n/GSWatermark gs\n0 g\n1 0 0 1 100 150 cm\nBT"));
+        assert!(ops.contains("/FWatermark 10 Tf\n-16.665 -3.59 Td\n(DRAFT) Tj"));
+        assert!(ops.ends_with("ET\nQ"));
+    }
+
+    #[test]
+    fn test_ext_gstate_opacity() {
+        let watermark = Watermark::new("DRAFT").opacity(1.5);
+        assert_eq!(watermark.opacity, 1.0);
+        let dict = Watermark::new("DRAFT").opacity(0.25).ext_gstate().to_pdf_string();
+        assert!(dict.contains("/Type /ExtGState"));
+        assert!(dict.contains("/ca 0.25"));
+    }
+}
@@ -0,0 +1,220 @@
+//! XMP metadata packets.
+
+use super::{DocumentInfo, PdfAConformance};
+use crate::object::{Object, PdfDictionary, PdfName, PdfStream};
+
+/// Processing instruction that starts an XMP packet.
+const PACKET_BEGIN: &str = "<?xpacket begin=\"\u{feff}\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n";
+
+/// Processing instruction that ends an XMP packet, marking it writable.
+const PACKET_END: &str = "<?xpacket end=\"w\"?>";
+
+/// Lines of 99 spaces left before the end of a packet, so tools can rewrite
+/// the metadata in place without moving the rest of the file.
+const PADDING_LINES: usize = 20;
+
+/// XMP metadata written to the document catalog.
+#[derive(Debug, Clone, PartialEq)]
+pub enum XmpMetadata {
+    /// A packet generated from the document information, so the two agree.
+    FromInfo,
+    /// A packet supplied by the caller. It is wrapped in `xpacket`
+    /// processing instructions and padding unless it already has them.
+    Raw(Vec<u8>),
+}
+
+impl XmpMetadata {
+    /// Returns the complete packet for `info`, identifying the document as
+    /// `pdfa` if set.
+    pub(super) fn packet(&self, info: &DocumentInfo, pdfa: Option<PdfAConformance>) -> Vec<u8> {
+        match self {
+            XmpMetadata::FromInfo => wrap_packet(info_packet(info, pdfa).as_bytes()),
+            XmpMetadata::Raw(data) => wrap_packet(data),
+        }
+    }
+}
+
+/// Builds the metadata stream for a packet.
+///
+/// The stream is written uncompressed, which PDF/A-1 requires and which lets
+/// tools find and rewrite the packet in place.
+pub(super) fn metadata_stream(packet: Vec<u8>) -> PdfStream {
+    let mut dict = PdfDictionary::new();
+    dict.set("Type", Object::Name(PdfName::new_unchecked("Metadata")));
+    dict.set("Subtype", Object::Name(PdfName::new_unchecked("XML")));
+    PdfStream::with_dictionary(dict, packet)
+}
+
+/// Returns whether a packet identifies the document as PDF/A part `part`.
+pub(super) fn declares_pdfa_part(packet: &[u8], part: u8) -> bool {
+    let packet = String::from_utf8_lossy(packet);
+    packet.contains(&format!("<pdfaid:part>{}</pdfaid:part>", part))
+        || packet.contains(&format!("pdfaid:part=\"{}\"", part))
+}
+
+/// Wraps `body` in the packet processing instructions and padding, unless it
+/// already starts with one.
+fn wrap_packet(body: &[u8]) -> Vec<u8> {
+    let trimmed = body.strip_prefix("\u{feff}".as_bytes()).unwrap_or(body);
+    if trimmed.trim_ascii_start().starts_with(b"<?xpacket") {
+        return body.to_vec();
+    }
+
+    let mut packet = PACKET_BEGIN.as_bytes().to_vec();
+    packet.extend_from_slice(body.trim_ascii());
+    packet.push(b'\n');
+    for _ in 0..PADDING_LINES {
+        packet.extend_from_slice(&[b' '; 99]);
+        packet.push(b'\n');
+    }
+    packet.extend_from_slice(PACKET_END.as_bytes());
+    packet
+}
+
+/// Builds the `x:xmpmeta` element mirroring the document information with the
+/// Dublin Core, PDF and XMP basic schemas, plus the PDF/A identification.
+fn info_packet(info: &DocumentInfo, pdfa: Option<PdfAConformance>) -> String {
+    let mut xmp = String::from("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n");
+    xmp.push_str("<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n");
+    if let Some(level) = pdfa {
+        let mut id = format!("<pdfaid:part>{}</pdfaid:part>\n", level.part());
+        id.push_str("<pdfaid:conformance>B</pdfaid:conformance>\n");
+        push_description(&mut xmp, "pdfaid", "http://www.aiim.org/pdfa/ns/id/", &id);
+    }
+
+    let alt = |s: &str| format!("<rdf:Alt><rdf:li xml:lang=\"x-default\">{}</rdf:li></rdf:Alt>", xml_escape(s));
+    let mut dc = String::from("<dc:format>application/pdf</dc:format>\n");
+    if let Some(ref title) = info.title {
+        dc.push_str(&format!("<dc:title>{}</dc:title>\n", alt(title)));
+    }
+    if let Some(ref author) = info.author {
+        dc.push_str(&format!("<dc:creator><rdf:Seq><rdf:li>{}</rdf:li></rdf:Seq></dc:creator>\n", xml_escape(author)));
+    }
+    if let Some(ref subject) = info.subject {
+        dc.push_str(&format!("<dc:description>{}</dc:description>\n", alt(subject)));
+    }
+    let keywords: Vec<&str> = info.keywords.iter().flat_map(|k| k.split([',', ';'])).map(str::trim).collect();
+    if keywords.iter().any(|k| !k.is_empty()) {
+        dc.push_str("<dc:subject><rdf:Bag>");
+        for keyword in keywords.into_iter().filter(|k| !k.is_empty()) {
+            dc.push_str(&format!("<rdf:li>{}</rdf:li>", xml_escape(keyword)));
+        }
+        dc.push_str("</rdf:Bag></dc:subject>\n");
+    }
+    push_description(&mut xmp, "dc", "http://purl.org/dc/elements/1.1/", &dc);
+
+    let mut pdf = String::new();
+    if let Some(ref producer) = info.producer {
+        pdf.push_str(&format!("<pdf:Producer>{}</pdf:Producer>\n", xml_escape(producer)));
+    }
+    if let Some(ref keywords) = info.keywords {
+        pdf.push_str(&format!("<pdf:Keywords>{}</pdf:Keywords>\n", xml_escape(keywords)));
+    }
+    push_description(&mut xmp, "pdf", "http://ns.adobe.com/pdf/1.3/", &pdf);
+
+    let mut basic = String::new();
+    if let Some(ref creator) = info.creator {
+        basic.push_str(&format!("<xmp:CreatorTool>{}</xmp:CreatorTool>\n", xml_escape(creator)));
+    }
+    if let Some(date) = info.creation_date.as_deref().and_then(xmp_date) {
+        basic.push_str(&format!("<xmp:CreateDate>{}</xmp:CreateDate>\n", date));
+    }
+    if let Some(date) = info.mod_date.as_deref().and_then(xmp_date) {
+        basic.push_str(&format!("<xmp:ModifyDate>{}</xmp:ModifyDate>\n", date));
+    }
+    push_description(&mut xmp, "xmp", "http://ns.adobe.com/xap/1.0/", &basic);
+
+    xmp.push_str("</rdf:RDF>\n</x:xmpmeta>");
+    xmp
+}
+
+/// Appends an `rdf:Description` for one schema, unless it has no properties.
+fn push_description(xmp: &mut String, prefix: &str, namespace: &str, properties: &str) {
+    if properties.is_empty() {
+        return;
+    }
+    xmp.push_str(&format!(
+        "<rdf:Description rdf:about=\"\" xmlns:{}=\"{}\">\n{}</rdf:Description>\n",
+        prefix, namespace, properties
+    ));
+}
+
+fn xml_escape(s: &str) -> String {
+    s.replace('&', "&amp;").replace('<', "&lt;").replace('>', "&gt;")
+}
+
+/// Converts a PDF date such as `D:20240131235900+07'00'` to the XMP
+/// (ISO 8601) form, or returns `None` if it is not a valid date.
+pub(crate) fn xmp_date(date: &str) -> Option<String> {
+    let date = date.strip_prefix("D:").unwrap_or(date);
+    let digits = date.bytes().take_while(u8::is_ascii_digit).count();
+    if digits < 4 || digits > 14 || digits % 2 != 0 {
+        return None;
+    }
+    let (numbers, zone) = date.split_at(digits);
+    let field = |start: usize, default: &'static str| numbers.get(start..start + 2).unwrap_or(default);
+
+    let mut xmp = format!("{}-{}-{}", &numbers[..4], field(4, "01"), field(6, "01"));
+    if digits > 8 {
+        xmp.push_str(&format!("T{}:{}:{}", field(8, "00"), field(10, "00"), field(12, "00")));
+        match zone.as_bytes().first() {
+            None => {}
+            Some(b'Z') => xmp.push('Z'),
+            Some(sign @ (b'+' | b'-')) => {
+                let offset: String = zone[1..].chars().filter(char::is_ascii_digit).collect();
+                if offset.len() != 4 {
+                    return None;
+                }
+                xmp.push_str(&format!("{}{}:{}", *sign as char, &offset[..2], &offset[2..]));
+            }
+            Some(_) => return None,
+        }
+    }
+    Some(xmp)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_xmp_date() {
+        assert_eq!(xmp_date("D:20240131235900+07'00'").as_deref(), Some("2024-01-31T23:59:00+07:00"));
+        assert_eq!(xmp_date("D:20240131").as_deref(), Some("2024-01-31"));
+        assert_eq!(xmp_date("D:202401311200Z").as_deref(), Some("2024-01-31T12:00:00Z"));
+        assert_eq!(xmp_date("D:2024013"), None);
+        assert_eq!(xmp_date("yesterday"), None);
+    }
+
+    #[test]
+    fn test_info_packet_mirrors_info() {
+        let info = DocumentInfo::new()
+            .title("Q&A")
+            .author("Ada")
+            .keywords("pdf, archive")
+            .creation_date("D:20240131");
+        let xmp = info_packet(&info, Some(PdfAConformance::A2b));
+        assert!(xmp.contains("<pdfaid:part>2</pdfaid:part>"));
+        assert!(xmp.contains("<rdf:li xml:lang=\"x-default\">Q&amp;A</rdf:li>"));
+        assert!(xmp.contains("<rdf:li>Ada</rdf:li>"));
+        assert!(xmp.contains("<rdf:Bag><rdf:li>pdf</rdf:li><rdf:li>archive</rdf:li></rdf:Bag>"));
+        assert!(xmp.contains("<xmp:CreateDate>2024-01-31</xmp:CreateDate>"));
+        assert!(!xmp.contains("pdf:Producer"));
+        assert!(!info_packet(&info, None).contains("pdfaid"));
+    }
+
+    #[test]
+    fn test_wrap_packet() {
+        let packet = wrap_packet(b"  <x:xmpmeta xmlns:x=\"adobe:ns:meta/\"/>\n");
+        let text = String::from_utf8(packet).unwrap();
+        assert!(text.starts_with(PACKET_BEGIN));
+        assert!(text.contains("?>\n<x:xmpmeta"));
+        assert!(text.ends_with(&format!("{}\n{}", " ".repeat(99), PACKET_END)));
+        assert!(text.len() > 2000);
+
+        let wrapped = text.as_bytes();
+        assert_eq!(wrap_packet(wrapped), wrapped);
+        assert!(declares_pdfa_part(b"<rdf:Description pdfaid:part=\"1\"/>", 1));
+        assert!(!declares_pdfa_part(wrapped, 1));
+    }
+}
@@ -1,12 +1,15 @@
 //! Encryption configuration.
 
 use super::permissions::Permissions;
+use crate::document::PdfVersion;
 use zeroize::{Zeroize, ZeroizeOnDrop};
 
 /// Encryption algorithm to use.
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum EncryptionAlgorithm {
-    /// AES-128 encryption (PDF 1.5+, V=4, R=4).
+    /// RC4 encryption with a 128-bit key (PDF 1.4+, V=2, R=3).
+    Rc4,
+    /// AES-128 encryption (PDF 1.6+, V=4, R=4).
     Aes128,
     /// AES-256 encryption (PDF 2.0, V=5, R=6).
     Aes256,
@@ -16,14 +19,28 @@ impl EncryptionAlgorithm {
     /// Returns the key length in bytes.
     pub fn key_length(&self) -> usize {
         match self {
+            EncryptionAlgorithm::Rc4 => 16,
             EncryptionAlgorithm::Aes128 => 16,
             EncryptionAlgorithm::Aes256 => 32,
         }
     }
 
+    /// Returns the earliest PDF version that supports the algorithm.
+    ///
+    /// AES-256 is standardized in PDF 2.0, but readers accept it in PDF 1.7
+    /// files through Adobe's extension level 8, so 1.7 is allowed.
+    pub fn min_version(&self) -> PdfVersion {
+        match self {
+            EncryptionAlgorithm::Rc4 => PdfVersion::V1_4,
+            EncryptionAlgorithm::Aes128 => PdfVersion::V1_6,
+            EncryptionAlgorithm::Aes256 => PdfVersion::V1_7,
+        }
+    }
+
     /// Returns the encryption dictionary V value.
     pub fn v_value(&self) -> i32 {
         match self {
+            EncryptionAlgorithm::Rc4 => 2,
             EncryptionAlgorithm::Aes128 => 4,
             EncryptionAlgorithm::Aes256 => 5,
         }
@@ -32,6 +49,7 @@ impl EncryptionAlgorithm {
     /// Returns the encryption dictionary R value.
     pub fn r_value(&self) -> i32 {
         match self {
+            EncryptionAlgorithm::Rc4 => 3,
             EncryptionAlgorithm::Aes128 => 4,
             EncryptionAlgorithm::Aes256 => 6,
         }
@@ -84,6 +102,20 @@ impl EncryptionConfig {
         }
     }
 
+    /// Creates a new encryption config with RC4-128 encryption.
+    ///
+    /// RC4 is weak by modern standards; use it only for readers that
+    /// do not support AES.
+    pub fn rc4() -> Self {
+        Self {
+            algorithm: EncryptionAlgorithm::Rc4,
+            user_password: String::new(),
+            owner_password: String::new(),
+            permissions: Permissions::new(),
+            encrypt_metadata: true,
+        }
+    }
+
     /// Sets the user password (for opening the document).
     ///
     /// An empty password means anyone can open the document,
@@ -156,6 +188,15 @@ mod tests {
         assert_eq!(config.algorithm.r_value(), 4);
     }
 
+    #[test]
+    fn test_rc4_config() {
+        let config = EncryptionConfig::rc4();
+        assert_eq!(config.algorithm, EncryptionAlgorithm::Rc4);
+        assert_eq!(config.algorithm.key_length(), 16);
+        assert_eq!(config.algorithm.v_value(), 2);
+        assert_eq!(config.algorithm.r_value(), 3);
+    }
+
     #[test]
     fn test_config_builder() {
         let config = EncryptionConfig::aes256()
@@ -1,9 +1,12 @@
 //! Key derivation functions for PDF encryption.
 //!
 //! Implements the key derivation algorithms from PDF 2.0 specification (ISO 32000-2).
-//! Specifically Algorithm 2.A, 2.B, 2.C, and 2.D for R=6 (AES-256) encryption.
+//! Specifically Algorithm 2.A, 2.B, 2.C, and 2.D for R=6 (AES-256) encryption,
+//! and Algorithms 2, 3, and 5 for R=3 (RC4-128) encryption.
 
+use super::rc4::rc4;
 use crate::error::EncryptionError;
+use md5::Md5;
 use sha2::{Digest, Sha256, Sha384, Sha512};
 use zeroize::{Zeroize, ZeroizeOnDrop};
 
@@ -410,6 +413,97 @@ pub fn verify_user_password(
     Ok(file_key)
 }
 
+/// Padding appended to passwords shorter than 32 bytes (ISO 32000-1, Algorithm 2).
+const PASSWORD_PADDING: [u8; 32] = [
+    0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+    0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+];
+
+/// Derives encryption keys for RC4-128 (V=2, R=3) encryption.
+///
+/// This implements Algorithms 2, 3, and 5 from ISO 32000-1. An empty owner
+/// password falls back to the user password, as the specification requires.
+/// Only the file key, O and U are set; the AES-256 values are left empty.
+pub fn derive_rc4_keys(
+    user_password: &str,
+    owner_password: &str,
+    permissions: i32,
+    file_id: &[u8],
+) -> Result<EncryptionKeys, EncryptionError> {
+    let user_pwd = pad_password(user_password);
+    let owner_pwd = if owner_password.is_empty() {
+        user_pwd
+    } else {
+        pad_password(owner_password)
+    };
+
+    // ===== Algorithm 3: Computing O =====
+    let mut owner_key = Md5::digest(owner_pwd).to_vec();
+    for _ in 0..50 {
+        owner_key = Md5::digest(&owner_key).to_vec();
+    }
+    let o_value = rc4_rounds(&owner_key, &user_pwd);
+
+    // ===== Algorithm 2: Computing the file encryption key =====
+    let mut hasher = Md5::new();
+    hasher.update(user_pwd);
+    hasher.update(&o_value);
+    hasher.update(permissions.to_le_bytes());
+    hasher.update(file_id);
+    let mut file_encryption_key = hasher.finalize().to_vec();
+    for _ in 0..50 {
+        file_encryption_key = Md5::digest(&file_encryption_key).to_vec();
+    }
+
+    // ===== Algorithm 5: Computing U =====
+    let mut hasher = Md5::new();
+    hasher.update(PASSWORD_PADDING);
+    hasher.update(file_id);
+    let mut u_value = rc4_rounds(&file_encryption_key, &hasher.finalize());
+    // The last 16 bytes are arbitrary; pad with zeros.
+    u_value.resize(32, 0);
+
+    Ok(EncryptionKeys {
+        file_encryption_key,
+        o_value,
+        u_value,
+        oe_value: Vec::new(),
+        ue_value: Vec::new(),
+        perms_value: Vec::new(),
+    })
+}
+
+/// Computes the RC4 key for one object (ISO 32000-1, Algorithm 1).
+pub(crate) fn rc4_object_key(file_key: &[u8], obj_num: u32, gen_num: u16) -> Vec<u8> {
+    let mut hasher = Md5::new();
+    hasher.update(file_key);
+    hasher.update(&obj_num.to_le_bytes()[..3]);
+    hasher.update(gen_num.to_le_bytes());
+    let mut key = hasher.finalize().to_vec();
+    key.truncate((file_key.len() + 5).min(16));
+    key
+}
+
+/// Pads or truncates a password to 32 bytes.
+fn pad_password(password: &str) -> [u8; 32] {
+    let bytes = password.as_bytes();
+    let len = bytes.len().min(32);
+    let mut padded = PASSWORD_PADDING;
+    padded[..len].copy_from_slice(&bytes[..len]);
+    padded[len..].copy_from_slice(&PASSWORD_PADDING[..32 - len]);
+    padded
+}
+
+/// Encrypts `data` with RC4 twenty times, XORing each key byte with the round number.
+fn rc4_rounds(key: &[u8], data: &[u8]) -> Vec<u8> {
+    let mut result = rc4(key, data);
+    for round in 1..20u8 {
+        let round_key: Vec<u8> = key.iter().map(|b| b ^ round).collect();
+        result = rc4(&round_key, &result);
+    }
+    result
+}
+
 /// AES-256-CBC decryption without padding.
 fn aes_cbc_decrypt_no_padding(key: &[u8], iv: &[u8], ciphertext: &[u8]) -> Result<Vec<u8>, EncryptionError> {
     use aes::cipher::{BlockDecryptMut, KeyIvInit};
@@ -530,6 +624,44 @@ mod tests {
         assert_eq!(&decrypted[..16], plaintext);
     }
 
+    fn from_hex(hex: &str) -> Vec<u8> {
+        (0..hex.len())
+            .step_by(2)
+            .map(|i| u8::from_str_radix(&hex[i..i + 2], 16).unwrap())
+            .collect()
+    }
+
+    #[test]
+    fn test_derive_rc4_keys() {
+        let file_id: Vec<u8> = (0..16).collect();
+        let keys = derive_rc4_keys("user", "owner", -3904, &file_id).unwrap();
+
+        assert_eq!(
+            keys.o_value,
+            from_hex("0ba3835f88f90388e74e54584125ce142be0de24c6b0d37746e075b891756671")
+        );
+        assert_eq!(keys.file_encryption_key, from_hex("ebc53cf170c71152a5ba9925bd0fefc3"));
+        assert_eq!(&keys.u_value[..16], from_hex("b8d04c0b647956d75df3b1f5a437ef97"));
+        assert_eq!(keys.u_value.len(), 32);
+        assert!(keys.oe_value.is_empty());
+        assert!(keys.perms_value.is_empty());
+    }
+
+    #[test]
+    fn test_rc4_empty_owner_password_uses_user_password() {
+        let file_id = [1u8; 16];
+        let implicit = derive_rc4_keys("secret", "", -4, &file_id).unwrap();
+        let explicit = derive_rc4_keys("secret", "secret", -4, &file_id).unwrap();
+        assert_eq!(implicit.o_value, explicit.o_value);
+    }
+
+    #[test]
+    fn test_rc4_object_key() {
+        let key = from_hex("ebc53cf170c71152a5ba9925bd0fefc3");
+        assert_eq!(rc4_object_key(&key, 7, 0), from_hex("a92b9a572bde3762112f40846bbf7f70"));
+        assert_eq!(rc4_object_key(&key[..5], 7, 0).len(), 10);
+    }
+
     #[test]
     fn test_verify_against_qpdf_encrypted_pdf() {
         // Fresh values extracted from a qpdf-encrypted PDF with user password "user123"
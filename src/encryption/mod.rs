@@ -1,6 +1,6 @@
 //! PDF encryption module.
 //!
-//! This module provides AES-256 encryption support for PDF documents.
+//! This module provides AES-256 and RC4-128 encryption support for PDF documents.
 //!
 //! # Example
 //!
@@ -21,6 +21,7 @@
 mod config;
 mod key_derivation;
 mod permissions;
+mod rc4;
 
 pub use config::{EncryptionAlgorithm, EncryptionConfig};
 pub use key_derivation::EncryptionKeys;
@@ -28,7 +29,8 @@ pub use permissions::Permissions;
 
 use crate::error::EncryptionError;
 use crate::object::{Object, PdfDictionary, PdfName, PdfString};
-use key_derivation::derive_aes256_keys;
+use key_derivation::{derive_aes256_keys, derive_rc4_keys, rc4_object_key};
+use rc4::rc4;
 use zeroize::Zeroize;
 
 /// Handles PDF encryption.
@@ -47,11 +49,19 @@ impl EncryptionHandler {
         }
 
         // Derive encryption keys
-        let keys = derive_aes256_keys(
-            &config.user_password,
-            &config.owner_password,
-            config.permissions.as_i32(),
-        )?;
+        let keys = match config.algorithm {
+            EncryptionAlgorithm::Rc4 => derive_rc4_keys(
+                &config.user_password,
+                &config.owner_password,
+                config.permissions.as_i32(),
+                &file_id,
+            )?,
+            _ => derive_aes256_keys(
+                &config.user_password,
+                &config.owner_password,
+                config.permissions.as_i32(),
+            )?,
+        };
 
         Ok(Self {
             config,
@@ -70,19 +80,25 @@ impl EncryptionHandler {
         &self.file_id
     }
 
-    /// Encrypts data using AES-256-CBC.
+    /// Encrypts data using AES-256-CBC, or RC4 for RC4-128 configurations.
     ///
-    /// Each encrypted item has a unique IV derived from its object/generation number.
+    /// AES output starts with a random IV; RC4 uses a key derived from the
+    /// object and generation numbers.
     pub fn encrypt_data(
         &self,
         data: &[u8],
-        _obj_num: u32,
-        _gen_num: u16,
+        obj_num: u32,
+        gen_num: u16,
     ) -> Result<Vec<u8>, EncryptionError> {
         use aes::cipher::{BlockEncryptMut, KeyIvInit};
         use cbc::Encryptor;
         use rand::Rng;
 
+        if self.config.algorithm == EncryptionAlgorithm::Rc4 {
+            let key = rc4_object_key(&self.keys.file_encryption_key, obj_num, gen_num);
+            return Ok(rc4(&key, data));
+        }
+
         type Aes256CbcEnc = Encryptor<aes::Aes256>;
 
         // For AES-256 (V=5, R=6), generate random 16-byte IV
@@ -114,17 +130,22 @@ impl EncryptionHandler {
         Ok(result)
     }
 
-    /// Decrypts data using AES-256-CBC.
+    /// Decrypts data using AES-256-CBC, or RC4 for RC4-128 configurations.
     #[allow(dead_code)]
     pub fn decrypt_data(
         &self,
         data: &[u8],
-        _obj_num: u32,
-        _gen_num: u16,
+        obj_num: u32,
+        gen_num: u16,
     ) -> Result<Vec<u8>, EncryptionError> {
         use aes::cipher::{BlockDecryptMut, KeyIvInit};
         use cbc::Decryptor;
 
+        if self.config.algorithm == EncryptionAlgorithm::Rc4 {
+            let key = rc4_object_key(&self.keys.file_encryption_key, obj_num, gen_num);
+            return Ok(rc4(&key, data));
+        }
+
         if data.len() < 16 {
             return Err(EncryptionError::CipherFailed(
                 "Ciphertext too short".into(),
@@ -157,7 +178,7 @@ impl EncryptionHandler {
         // Standard encryption handler
         dict.set("Filter", Object::Name(PdfName::new_unchecked("Standard")));
 
-        // V and R values for AES-256
+        // V and R values
         dict.set("V", Object::Integer(self.config.algorithm.v_value() as i64));
         dict.set("R", Object::Integer(self.config.algorithm.r_value() as i64));
 
@@ -167,16 +188,23 @@ impl EncryptionHandler {
             Object::Integer((self.config.algorithm.key_length() * 8) as i64),
         );
 
-        // O, U, OE, UE, Perms values (as hex strings)
+        // O and U values (as hex strings)
         dict.set("O", Object::String(PdfString::Hex(self.keys.o_value.clone())));
         dict.set("U", Object::String(PdfString::Hex(self.keys.u_value.clone())));
-        dict.set("OE", Object::String(PdfString::Hex(self.keys.oe_value.clone())));
-        dict.set("UE", Object::String(PdfString::Hex(self.keys.ue_value.clone())));
-        dict.set("Perms", Object::String(PdfString::Hex(self.keys.perms_value.clone())));
 
         // Permissions (P)
         dict.set("P", Object::Integer(self.config.permissions.as_i32() as i64));
 
+        // RC4 (V=2) uses the key directly, without crypt filters
+        if self.config.algorithm == EncryptionAlgorithm::Rc4 {
+            return dict;
+        }
+
+        // OE, UE, Perms values
+        dict.set("OE", Object::String(PdfString::Hex(self.keys.oe_value.clone())));
+        dict.set("UE", Object::String(PdfString::Hex(self.keys.ue_value.clone())));
+        dict.set("Perms", Object::String(PdfString::Hex(self.keys.perms_value.clone())));
+
         // Crypt filter dictionary for AES
         let mut cf_dict = PdfDictionary::new();
         let mut std_cf = PdfDictionary::new();
@@ -284,6 +312,30 @@ mod tests {
         assert!(dict.get("CF").is_some());
     }
 
+    #[test]
+    fn test_rc4_roundtrip_and_dictionary() {
+        let config = EncryptionConfig::rc4()
+            .owner_password("owner")
+            .permissions(Permissions::new().allow_printing(true));
+
+        let handler = EncryptionHandler::new(config, generate_file_id()).unwrap();
+        assert_eq!(handler.file_key().len(), 16);
+
+        let plaintext = b"BT /F1 12 Tf (Hello) Tj ET";
+        let ciphertext = handler.encrypt_data(plaintext, 4, 0).unwrap();
+        assert_eq!(ciphertext.len(), plaintext.len());
+        assert_ne!(&ciphertext[..], plaintext);
+        assert_ne!(ciphertext, handler.encrypt_data(plaintext, 5, 0).unwrap());
+        assert_eq!(handler.decrypt_data(&ciphertext, 4, 0).unwrap(), plaintext);
+
+        let dict = handler.create_encrypt_dictionary().to_pdf_string();
+        assert!(dict.contains("/V 2"));
+        assert!(dict.contains("/R 3"));
+        assert!(dict.contains("/Length 128"));
+        assert!(!dict.contains("/CF"));
+        assert!(!dict.contains("/Perms"));
+    }
+
     #[test]
     fn test_generate_file_id() {
         let id1 = generate_file_id();
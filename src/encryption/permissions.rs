@@ -42,6 +42,17 @@ impl Permissions {
         }
     }
 
+    /// Creates permissions from /P flag bits.
+    ///
+    /// Bits that are not permission flags are ignored; reserved bits are
+    /// always set.
+    pub fn from_bits(bits: i32) -> Self {
+        let known = PRINT | MODIFY | COPY | ANNOTATE | FILL_FORMS | EXTRACT | ASSEMBLE | PRINT_HIGH_QUALITY;
+        Self {
+            flags: RESERVED_MASK | (bits & known),
+        }
+    }
+
     /// Allow or deny printing.
     pub fn allow_printing(mut self, allow: bool) -> Self {
         if allow {
@@ -215,6 +226,16 @@ mod tests {
         assert!(!perms.can_print_high_quality());
     }
 
+    #[test]
+    fn test_from_bits() {
+        let perms = Permissions::from_bits(PRINT | COPY | 1);
+        assert!(perms.can_print());
+        assert!(perms.can_copy());
+        assert!(!perms.can_modify());
+        assert_eq!(perms.as_i32(), RESERVED_MASK | PRINT | COPY);
+        assert_eq!(Permissions::from_bits(0), Permissions::new());
+    }
+
     #[test]
     fn test_builder_pattern() {
         let perms = Permissions::new()
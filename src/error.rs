@@ -1,5 +1,6 @@
 //! Error types for the rust-pdf library.
 
+use crate::document::{PdfAConformance, PdfVersion};
 use thiserror::Error;
 
 /// The main error type for PDF operations.
@@ -50,9 +51,22 @@ pub enum PdfError {
     #[error("Signature error: {0}")]
     Signature(#[from] SignatureError),
 
+    /// Error during page rendering.
+    #[cfg(feature = "render")]
+    #[error("Render error: {0}")]
+    Render(#[from] RenderError),
+
     /// Error during form field operations.
     #[error("Form error: {0}")]
     Form(#[from] FormError),
+
+    /// Error during font loading.
+    #[error("Font error: {0}")]
+    Font(#[from] FontError),
+
+    /// A progress callback asked for the operation to stop.
+    #[error("Operation cancelled")]
+    Cancelled,
 }
 
 /// Errors related to PDF object handling.
@@ -89,6 +103,95 @@ pub enum DocumentError {
     /// Missing required resource.
     #[error("Missing required resource: {0}")]
     MissingResource(String),
+
+    /// Page index past the last page.
+    #[error("Page {index} does not exist (page count is {count})")]
+    PageOutOfRange {
+        /// The index given.
+        index: usize,
+        /// The number of pages.
+        count: usize,
+    },
+
+    /// Bookmark with an invalid parent or destination page.
+    #[error("Invalid bookmark: {0}")]
+    InvalidBookmark(String),
+
+    /// Link to a page or named destination that does not exist.
+    #[error("Invalid link: {0}")]
+    InvalidLink(String),
+
+    /// Named destination without a name, or on a page that does not exist.
+    #[error("Invalid destination: {0}")]
+    InvalidDestination(String),
+
+    /// Structure elements that do not form a tree, or content on a page
+    /// that does not exist.
+    #[error("Invalid structure: {0}")]
+    InvalidStructure(String),
+
+    /// Attachment without a file name, or with one already used.
+    #[error("Invalid attachment: {0}")]
+    InvalidAttachment(String),
+
+    /// N-up layout without cells, or with gutters that leave no room for them.
+    #[error("Invalid N-up layout: {0}")]
+    InvalidNup(String),
+
+    /// Fixed zoom that is not a positive percentage.
+    #[error("Invalid zoom: {0}% is not a positive percentage")]
+    InvalidZoom(f64),
+
+    /// Document language that is not a well-formed BCP 47 language tag.
+    #[error("Invalid language tag: '{0}'")]
+    InvalidLanguage(String),
+
+    /// Page rotation that is not a multiple of 90 degrees.
+    #[error("Invalid rotation of page {page}: {degrees} is not a multiple of 90 degrees")]
+    InvalidRotation {
+        /// Index of the page.
+        page: usize,
+        /// The rotation set on it.
+        degrees: i32,
+    },
+
+    /// Page boundary that is empty or extends past the media box.
+    #[error("Invalid {name} of page {page}: it must be a non-empty rectangle within the MediaBox")]
+    InvalidPageBox {
+        /// Index of the page.
+        page: usize,
+        /// The page dictionary key of the boundary, such as `TrimBox`.
+        name: &'static str,
+    },
+
+    /// The document breaks the PDF/A level it must conform to.
+    #[error("Document does not conform to {level}: {}", .violations.join("; "))]
+    NotPdfA {
+        /// The required conformance level.
+        level: PdfAConformance,
+        /// A description of each violation.
+        violations: Vec<String>,
+    },
+
+    /// A setting that an incremental update cannot apply to the original file.
+    #[error("Cannot apply {0} in an incremental update")]
+    NotIncremental(&'static str),
+
+    /// A setting that needs the whole document, so pages cannot be written
+    /// one at a time.
+    #[error("Cannot use {0} when writing pages one at a time")]
+    NotStreamable(&'static str),
+
+    /// A feature the document uses is newer than its PDF version.
+    #[error("{feature} requires PDF {required} or later, but the document is PDF {version}")]
+    VersionTooLow {
+        /// The feature that needs a newer version.
+        feature: &'static str,
+        /// The earliest version supporting the feature.
+        required: PdfVersion,
+        /// The document's version.
+        version: PdfVersion,
+    },
 }
 
 /// Errors related to content stream building.
@@ -109,6 +212,22 @@ pub enum ContentError {
     /// Font not set before text operation.
     #[error("Font must be set before text operations")]
     FontNotSet,
+
+    /// Barcode data that the symbology cannot encode.
+    #[error("Invalid barcode data: {0}")]
+    InvalidBarcodeData(String),
+
+    /// Data too long for the largest QR code at the requested error correction level.
+    #[error("Data does not fit in a QR code: {0}")]
+    QrCodeCapacity(String),
+
+    /// Gradient with fewer than two stops, or with offsets out of order.
+    #[error("Invalid gradient: {0}")]
+    InvalidGradient(String),
+
+    /// Tiling pattern whose cell has no area.
+    #[error("Invalid pattern: {0}")]
+    InvalidPattern(String),
 }
 
 /// Errors related to PDF writing.
@@ -123,6 +242,46 @@ pub enum WriterError {
     InvalidOffset(u64),
 }
 
+/// Errors related to font loading and embedding.
+#[derive(Debug, Error)]
+pub enum FontError {
+    /// Failed to read the font file.
+    #[error("Failed to load font: {0}")]
+    LoadFailed(String),
+
+    /// The data is not a valid TrueType/OpenType font.
+    #[error("Invalid font data: {0}")]
+    InvalidFont(String),
+
+    /// The font uses a feature that is not supported.
+    #[error("Unsupported font: {0}")]
+    UnsupportedFont(String),
+}
+
+/// Errors related to rendering pages to images.
+#[cfg(feature = "render")]
+#[derive(Debug, Error)]
+pub enum RenderError {
+    /// The resolution is not a positive number.
+    #[error("Invalid resolution: {0} dpi")]
+    InvalidResolution(f64),
+
+    /// A thumbnail size of 0 pixels, or a page with no area.
+    #[error("Invalid thumbnail size: {0} pixels")]
+    InvalidThumbnailSize(u32),
+
+    /// The image would have more pixels than the renderer allows.
+    #[error("Rendered page would be {width}x{height} pixels, more than the limit of {limit}")]
+    TooLarge {
+        /// Image width in pixels.
+        width: u64,
+        /// Image height in pixels.
+        height: u64,
+        /// The largest number of pixels allowed.
+        limit: u64,
+    },
+}
+
 /// Errors related to compression operations.
 #[cfg(feature = "compression")]
 #[derive(Debug, Error)]
@@ -209,6 +368,10 @@ pub enum ParserError {
     #[error("Invalid cross-reference stream")]
     InvalidXrefStream,
 
+    /// Missing or malformed page tree.
+    #[error("Invalid page tree: {0}")]
+    InvalidPageTree(String),
+
     /// Decompression error (when using parser with compression).
     #[cfg(feature = "compression")]
     #[error("Decompression failed: {0}")]
@@ -283,6 +446,10 @@ pub enum SignatureError {
     /// PKCS#7 encoding error.
     #[error("PKCS#7 encoding error: {0}")]
     Pkcs7Error(String),
+
+    /// PKCS#12 file could not be read.
+    #[error("PKCS#12 error: {0}")]
+    Pkcs12Error(String),
 }
 
 /// Errors related to form fields.
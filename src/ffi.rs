@@ -3,21 +3,1104 @@
 //! This module provides functions that can be called from C, Python, or other languages
 //! via FFI (Foreign Function Interface).
 
-use std::ffi::CStr;
-use std::os::raw::c_char;
-use std::ptr;
+use std::cell::{Ref, RefCell};
+use std::collections::HashMap;
+use std::ffi::{CStr, CString};
+use std::fs::File;
+use std::io::{self, BufWriter, Write};
+use std::ops::{Deref, DerefMut};
+use std::os::raw::{c_char, c_void};
+use std::ptr::{self, NonNull};
+use std::sync::atomic::{AtomicBool, AtomicI32, Ordering};
+use std::sync::OnceLock;
 
-use crate::content::ContentBuilder;
-use crate::document::DocumentBuilder;
-use crate::font::Standard14Font;
-use crate::page::PageBuilder;
+use crate::color::{CmykColor, Color, Gradient, GrayColor, Overprint, RgbColor, TilingPattern};
+use crate::content::{
+    Barcode, ContentBuilder, GraphicsBuilder, NumberFormat, Operator, PrinterMarks, QrCode, QrErrorCorrection,
+    Symbology,
+};
+use crate::document::{
+    is_language_tag, AfRelationship, Bookmark, Document, FileAttachment, Layer, NamedDestination, PageLayout,
+    PageNumberAlignment, PageNumbers, PdfAConformance, PdfVersion, StreamingWriter, StructureElement, StructureType,
+    Watermark, XmpMetadata, Zoom,
+};
+use crate::error::{DocumentError, PdfError};
+use crate::content::{shape_rtl, wrap_text, TextBuilder};
+use crate::font::{calculate_helvetica_width, FontMetrics, Shaping, Standard14Font, TrueTypeFont};
+use crate::forms::{CheckBox, FormFieldTrait, FormFieldType, TextField};
+#[cfg(feature = "parser")]
+use crate::object::Object;
+use crate::page::{Annotation, Link, Page, PageBox, ResourceType, StampName};
+#[cfg(feature = "parser")]
+use crate::page::ImportedPage;
+#[cfg(feature = "signatures")]
+use crate::signatures::{DocumentSigner, Pkcs12, SignatureConfig};
+use crate::types::{Margins, Matrix, Rectangle};
+
+/// Operation completed successfully.
+pub const PDF_OK: i32 = 0;
+/// A required argument was null or invalid, or the operation failed.
+pub const PDF_ERR_INVALID_ARGUMENT: i32 = -1;
+/// The page index does not refer to an existing page.
+pub const PDF_ERR_PAGE_OUT_OF_RANGE: i32 = -2;
+/// The byte buffer is not a recognizable JPEG or PNG image.
+pub const PDF_ERR_INVALID_IMAGE: i32 = -3;
+/// The library was built without the feature required by this call.
+pub const PDF_ERR_UNSUPPORTED: i32 = -4;
+/// The byte buffer is not a usable TrueType or OpenType font.
+pub const PDF_ERR_INVALID_FONT: i32 = -5;
+/// The handle is being used by another call, typically on another thread.
+pub const PDF_ERR_BUSY: i32 = -6;
+/// A write or progress callback returned nonzero, so the operation was stopped.
+pub const PDF_ERR_ABORTED: i32 = -7;
+/// The data does not fit in the requested symbol, such as a QR code at the
+/// chosen error correction level.
+pub const PDF_ERR_DATA_TOO_LONG: i32 = -8;
+/// The caller set the cancel flag of the call, so the operation was stopped.
+pub const PDF_ERR_CANCELLED: i32 = -9;
+
+/// Align text to the left edge of its box.
+pub const PDF_ALIGN_LEFT: i32 = 0;
+/// Center text within its box.
+pub const PDF_ALIGN_CENTER: i32 = 1;
+/// Align text to the right edge of its box.
+pub const PDF_ALIGN_RIGHT: i32 = 2;
+/// Stretch word spacing so lines fill the box, except the last line of each paragraph.
+pub const PDF_ALIGN_JUSTIFY: i32 = 3;
+
+/// Portrait page orientation (height >= width).
+pub const PDF_ORIENTATION_PORTRAIT: i32 = 0;
+/// Landscape page orientation (width >= height).
+pub const PDF_ORIENTATION_LANDSCAPE: i32 = 1;
+
+/// Text on the baseline at full size.
+pub const PDF_SCRIPT_NORMAL: i32 = 0;
+/// Smaller text raised above the baseline, as in x².
+pub const PDF_SCRIPT_SUPERSCRIPT: i32 = 1;
+/// Smaller text lowered below the baseline, as in H₂O.
+pub const PDF_SCRIPT_SUBSCRIPT: i32 = 2;
+
+/// RC4 encryption with a 128-bit key (PDF 1.4).
+pub const PDF_ENCRYPTION_RC4_128: i32 = 0;
+/// AES encryption with a 256-bit key (PDF 2.0).
+pub const PDF_ENCRYPTION_AES_256: i32 = 1;
+
+/// Allow printing.
+pub const PDF_PERMISSION_PRINT: i32 = 1 << 2;
+/// Allow modifying page contents.
+pub const PDF_PERMISSION_MODIFY: i32 = 1 << 3;
+/// Allow copying text and graphics.
+pub const PDF_PERMISSION_COPY: i32 = 1 << 4;
+/// Allow adding and editing annotations.
+pub const PDF_PERMISSION_ANNOTATE: i32 = 1 << 5;
+/// Allow filling in form fields.
+pub const PDF_PERMISSION_FILL_FORMS: i32 = 1 << 8;
+/// Allow extracting text for accessibility.
+pub const PDF_PERMISSION_EXTRACT: i32 = 1 << 9;
+/// Allow inserting, rotating, and deleting pages.
+pub const PDF_PERMISSION_ASSEMBLE: i32 = 1 << 10;
+/// Allow printing at full quality.
+pub const PDF_PERMISSION_PRINT_HIGH_QUALITY: i32 = 1 << 11;
+
+/// Font id of the built-in Helvetica used by `pdf_add_text` and related functions.
+pub const PDF_FONT_DEFAULT: i32 = -1;
+
+/// List items marked with a round bullet.
+pub const PDF_LIST_BULLET: i32 = 0;
+/// List items marked with a hyphen.
+pub const PDF_LIST_DASH: i32 = 1;
+/// List items numbered from 1, as in "1.".
+pub const PDF_LIST_NUMBERED: i32 = 2;
+
+/// Code 128 barcodes, covering all ASCII characters.
+pub const PDF_BARCODE_CODE128: i32 = 0;
+
+/// QR code error correction recovering about 7% of the symbol.
+pub const PDF_QR_ECC_LOW: i32 = 0;
+/// QR code error correction recovering about 15% of the symbol.
+pub const PDF_QR_ECC_MEDIUM: i32 = 1;
+/// QR code error correction recovering about 25% of the symbol.
+pub const PDF_QR_ECC_QUARTILE: i32 = 2;
+/// QR code error correction recovering about 30% of the symbol.
+pub const PDF_QR_ECC_HIGH: i32 = 3;
+
+/// Attach a file without an associated-file relationship.
+pub const PDF_AF_NONE: i32 = 0;
+/// The attached file is the source the document was created from.
+pub const PDF_AF_SOURCE: i32 = 1;
+/// The attached file holds data the document is derived from.
+pub const PDF_AF_DATA: i32 = 2;
+/// The attached file is an alternative representation of the document.
+pub const PDF_AF_ALTERNATIVE: i32 = 3;
+/// The attached file supplements the document.
+pub const PDF_AF_SUPPLEMENT: i32 = 4;
+/// The attached file's relationship is not known.
+pub const PDF_AF_UNSPECIFIED: i32 = 5;
+
+/// Turn PDF/A output off.
+pub const PDF_PDFA_NONE: i32 = 0;
+/// PDF/A-1b (ISO 19005-1, visual reproduction).
+pub const PDF_PDFA_1B: i32 = 1;
+/// PDF/A-2b (ISO 19005-2, visual reproduction).
+pub const PDF_PDFA_2B: i32 = 2;
+
+/// Open at the viewer's default zoom. Any positive zoom is a percentage.
+pub const PDF_ZOOM_DEFAULT: i32 = 0;
+/// Open with the whole first page fitting the window.
+pub const PDF_ZOOM_FIT_PAGE: i32 = -1;
+/// Open with the width of the first page fitting the window.
+pub const PDF_ZOOM_FIT_WIDTH: i32 = -2;
+
+/// The region viewers display and print (/CropBox).
+pub const PDF_BOX_CROP: i32 = 0;
+/// The region clipped to in print production, including bleed (/BleedBox).
+pub const PDF_BOX_BLEED: i32 = 1;
+/// The size of the finished page after trimming (/TrimBox).
+pub const PDF_BOX_TRIM: i32 = 2;
+/// The extent of the page's meaningful content (/ArtBox).
+pub const PDF_BOX_ART: i32 = 3;
+
+/// Graphics states (/ExtGState), for `pdf_add_resource`.
+pub const PDF_RESOURCE_EXT_GSTATE: i32 = 0;
+/// Color spaces (/ColorSpace).
+pub const PDF_RESOURCE_COLOR_SPACE: i32 = 1;
+/// Patterns (/Pattern).
+pub const PDF_RESOURCE_PATTERN: i32 = 2;
+/// Shadings (/Shading).
+pub const PDF_RESOURCE_SHADING: i32 = 3;
+/// Fonts (/Font).
+pub const PDF_RESOURCE_FONT: i32 = 4;
+/// Marked-content property lists (/Properties).
+pub const PDF_RESOURCE_PROPERTIES: i32 = 5;
+
+/// Crop marks at the corners of the trim box, for `pdf_add_printer_marks`.
+pub const PDF_MARKS_CROP: i32 = 1;
+/// Registration targets at the middle of each side.
+pub const PDF_MARKS_REGISTRATION: i32 = 1 << 1;
+/// A bar of process color patches and gray tints.
+pub const PDF_MARKS_COLOR_BAR: i32 = 1 << 2;
+/// Every kind of printer's mark.
+pub const PDF_MARKS_ALL: i32 = PDF_MARKS_CROP | PDF_MARKS_REGISTRATION | PDF_MARKS_COLOR_BAR;
+
+/// Write negative numbers in parentheses, for `pdf_add_number` and
+/// `pdf_add_currency`.
+pub const PDF_NUMBER_PARENTHESES: i32 = 1;
+/// Write the currency symbol after the digits, for `pdf_add_currency`.
+pub const PDF_NUMBER_SYMBOL_AFTER: i32 = 1 << 1;
+
+/// A line under text, for `pdf_add_text_decorated`.
+pub const PDF_DECORATION_UNDERLINE: i32 = 1;
+/// A line through the middle of lowercase letters.
+pub const PDF_DECORATION_STRIKETHROUGH: i32 = 1 << 1;
+
+/// Leave the page layout to the viewer.
+pub const PDF_LAYOUT_DEFAULT: i32 = 0;
+/// Show one page at a time.
+pub const PDF_LAYOUT_SINGLE_PAGE: i32 = 1;
+/// Show pages in one continuous column.
+pub const PDF_LAYOUT_ONE_COLUMN: i32 = 2;
+/// Show pages in two continuous columns, odd pages on the left.
+pub const PDF_LAYOUT_TWO_COLUMN_LEFT: i32 = 3;
+/// Show pages in two continuous columns, odd pages on the right.
+pub const PDF_LAYOUT_TWO_COLUMN_RIGHT: i32 = 4;
+/// Show two pages at a time, odd pages on the left (PDF 1.5).
+pub const PDF_LAYOUT_TWO_PAGE_LEFT: i32 = 5;
+/// Show two pages at a time, odd pages on the right, like a printed spread (PDF 1.5).
+pub const PDF_LAYOUT_TWO_PAGE_RIGHT: i32 = 6;
+
+/// Resource name of the font used by the text functions.
+const DEFAULT_FONT_NAME: &str = "F1";
+/// Size of text drawn by `pdf_add_text` with no size until `pdf_set_default_font`.
+const DEFAULT_FONT_SIZE: f64 = 12.0;
+/// Default distance between baselines of wrapped text, as a multiple of the font size.
+const LINE_SPACING: f64 = 1.2;
+/// Space between table cell borders and their text, in points.
+const TABLE_CELL_PADDING: f64 = 4.0;
+/// Width of table cell borders, in points.
+const TABLE_BORDER_WIDTH: f64 = 0.5;
+/// Space between a list marker and its item text, as a multiple of the font size.
+const LIST_MARKER_GAP: f64 = 0.5;
+/// Font size of superscripts and subscripts relative to the base size.
+const SCRIPT_SCALE: f64 = 0.65;
+/// Text rise of superscripts, as a multiple of the base font size.
+const SUPERSCRIPT_RISE: f64 = 0.35;
+/// Text rise of subscripts, as a multiple of the base font size.
+const SUBSCRIPT_RISE: f64 = -0.15;
+/// Space between the cells of `pdf_add_nup` and around them, in points.
+#[cfg(feature = "parser")]
+const NUP_GUTTER: f64 = 10.0;
+/// Most decimals `pdf_add_number` writes; an f64 has no more digits to show.
+const MAX_NUMBER_DECIMALS: usize = 15;
+/// Size of the chunks passed to a `PdfWriteCallback`.
+const CALLBACK_CHUNK_SIZE: usize = 64 * 1024;
+
+/// Receives a chunk of serialized PDF bytes; returning nonzero aborts the write.
+pub type PdfWriteCallback =
+    unsafe extern "C" fn(chunk: *const u8, len: usize, user_data: *mut c_void) -> i32;
+
+/// Receives the fraction of a long operation done so far and the name of the
+/// operation; returning nonzero cancels it.
+pub type PdfProgressCallback =
+    unsafe extern "C" fn(fraction: f64, stage: *const c_char, user_data: *mut c_void) -> i32;
+
+/// Stage names passed to a `PdfProgressCallback`, null-terminated.
+#[cfg(feature = "parser")]
+const STAGE_MERGE: &[u8] = b"merge\0";
+const STAGE_SERIALIZE: &[u8] = b"serialize\0";
+#[cfg(feature = "render")]
+const STAGE_RENDER: &[u8] = b"render\0";
+
+/// Drawing state applied to subsequent text and shape operations.
+#[derive(Debug, Clone, PartialEq)]
+struct Style {
+    fill_color: Color,
+    /// Name and pattern that fills instead of `fill_color`, if set.
+    fill_pattern: Option<(String, FillPattern)>,
+    stroke_color: Color,
+    /// Dash array and phase for strokes, or `None` for solid lines.
+    line_dash: Option<(Vec<f64>, f64)>,
+    /// Extra space after every character of text, in points.
+    char_spacing: f64,
+    /// Extra space after every space of text, in points.
+    word_spacing: f64,
+    /// Overprint settings, applied through a graphics state resource.
+    overprint: Overprint,
+}
+
+impl Default for Style {
+    fn default() -> Self {
+        Self {
+            fill_color: Color::BLACK,
+            fill_pattern: None,
+            stroke_color: Color::BLACK,
+            line_dash: None,
+            char_spacing: 0.0,
+            word_spacing: 0.0,
+            overprint: Overprint::default(),
+        }
+    }
+}
+
+/// A pattern subsequent drawing fills with.
+#[derive(Debug, Clone, PartialEq)]
+enum FillPattern {
+    Gradient(Gradient),
+    /// A pattern from `pdf_create_tiling_pattern`, by pattern id.
+    Tiling(usize),
+}
+
+/// Distance between baselines of multi-line text.
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Leading {
+    /// A multiple of the font size.
+    Multiple(f64),
+    /// A fixed distance in points.
+    Fixed(f64),
+}
+
+impl Leading {
+    /// Returns the leading in points for text of `font_size`.
+    fn at(self, font_size: f64) -> f64 {
+        match self {
+            Leading::Multiple(factor) => factor * font_size,
+            Leading::Fixed(points) => points,
+        }
+    }
+}
+
+impl Style {
+    /// Emits operators for any state that differs from the PDF defaults.
+    fn apply(self, content: ContentBuilder) -> ContentBuilder {
+        let defaults = Style::default();
+        let content = if let Some((name, _)) = &self.fill_pattern {
+            content.fill_pattern(name)
+        } else if self.fill_color != defaults.fill_color {
+            content.fill_color(self.fill_color)
+        } else {
+            content
+        };
+        let content = if self.stroke_color != defaults.stroke_color {
+            content.stroke_color(self.stroke_color)
+        } else {
+            content
+        };
+        let content = match self.line_dash {
+            Some((array, phase)) => content.dash(array, phase),
+            None => content,
+        };
+        let content = if self.char_spacing != defaults.char_spacing {
+            content.extend([Operator::SetCharacterSpacing(self.char_spacing)])
+        } else {
+            content
+        };
+        let content = if self.word_spacing != defaults.word_spacing {
+            content.extend([Operator::SetWordSpacing(self.word_spacing)])
+        } else {
+            content
+        };
+        if self.overprint != defaults.overprint {
+            content.ext_gstate(self.overprint.resource_name())
+        } else {
+            content
+        }
+    }
+
+    /// Returns the width character and word spacing add to `text`. Word spacing
+    /// only applies to the one-byte spaces of the built-in Helvetica, not to
+    /// loaded fonts, whose character codes are two bytes.
+    fn spacing_width(&self, text: &str, helvetica: bool) -> f64 {
+        let spaces = if helvetica { text.matches(' ').count() } else { 0 };
+        self.char_spacing * text.chars().count() as f64 + self.word_spacing * spaces as f64
+    }
+
+    /// Runs `f` inside a saved graphics state when any non-default state is set.
+    fn wrap(
+        self,
+        content: ContentBuilder,
+        f: impl FnOnce(ContentBuilder) -> ContentBuilder,
+    ) -> ContentBuilder {
+        if self == Style::default() {
+            f(content)
+        } else {
+            f(self.apply(content.save_state())).restore_state()
+        }
+    }
+}
+
+/// A graphics state pushed on a page and not yet popped.
+#[derive(Debug, Clone)]
+enum SavedState {
+    /// A clip from `pdf_push_clip_rect`, removed by `pdf_pop_clip`.
+    Clip,
+    /// The drawing state at a `pdf_save_state` call, brought back by
+    /// `pdf_restore_state`.
+    Drawing(Style, Leading),
+}
+
+/// A structure element begun with `pdf_begin_tag` and not yet ended.
+#[derive(Debug, Clone)]
+struct OpenTag {
+    /// Index of the element in the document's structure.
+    element: usize,
+    /// Page on which the element's marked-content sequence is open, if any.
+    page: Option<usize>,
+}
+
+/// A layer begun with `pdf_begin_layer` and not yet ended.
+#[derive(Debug, Clone, Copy)]
+struct OpenLayer {
+    /// Index of the layer in the document's layers.
+    layer: usize,
+    /// Number of tags open when the layer was begun.
+    tags: usize,
+}
 
 /// Opaque handle to a PDF document
+///
+/// A handle may be moved between threads but must not be used by two threads at
+/// once. Every call claims the handle for its duration, and a call that finds it
+/// already claimed fails with `PDF_ERR_BUSY` instead of corrupting the document.
+/// Use `pdf_clone` to give each thread its own copy.
+#[repr(C)]
 pub struct PdfHandle {
-    data: Vec<u8>,
+    /// `HANDLE_TAG` while the handle is live. Checked on every call, so a freed
+    /// or foreign pointer is reported instead of dereferenced further.
+    tag: u64,
+    document: Document,
+    /// Whether pages added from now on are landscape.
+    landscape: bool,
+    /// Colors and other state for subsequent drawing calls.
+    style: Style,
+    /// Baseline spacing for text that wraps over several lines.
+    leading: Leading,
+    /// States pushed on each page and not yet popped, by page index.
+    saved_states: HashMap<usize, Vec<SavedState>>,
+    /// Tags begun and not yet ended, innermost last.
+    open_tags: Vec<OpenTag>,
+    /// Element of the tag begun or ended last, which `pdf_set_alt_text` describes.
+    last_tag: Option<usize>,
+    /// Layers begun on each page and not yet ended, innermost last, by page index.
+    open_layers: HashMap<usize, Vec<OpenLayer>>,
+    /// Fonts loaded with `pdf_load_font`, indexed by font id.
+    fonts: Vec<(String, TrueTypeFont)>,
+    /// Ids of loaded fonts tried in order for characters the requested font
+    /// has no glyph for.
+    fallback_fonts: Vec<usize>,
+    /// Font `pdf_add_text` draws with, or `None` for Helvetica, and the size
+    /// used when a call passes none.
+    default_font: (Option<usize>, f64),
+    /// Kerning and ligatures applied to text in loaded fonts.
+    shaping: Shaping,
+    /// Number of gradients set so far, which numbers their pattern names.
+    gradient_count: usize,
+    /// Patterns created with `pdf_create_tiling_pattern`, indexed by pattern id.
+    tiling_patterns: Vec<TilingPattern>,
+    /// Pages imported with `pdf_import_page_as_xobject`, indexed by xobject id.
+    #[cfg(feature = "parser")]
+    xobjects: Vec<ImportedPage>,
+    /// Whether imports rebuild a damaged cross-reference table, from
+    /// `pdf_set_recovery_mode`.
+    recovery: bool,
+    /// Whether the last imported file was damaged and its table rebuilt.
+    import_recovered: bool,
+    /// Serialized bytes, cached until the next mutation.
+    data: RefCell<Option<Vec<u8>>>,
+    /// Violations found by the last `pdf_validate_pdfa` call.
+    pdfa_report: CString,
+    /// Problems found by the last `pdf_audit_resources` call.
+    audit_report: CString,
+    /// Text found by the last `pdf_extract_text` call.
+    extracted_text: CString,
+    /// Identity and settings from `pdf_sign`, applied as the document is
+    /// serialized.
+    #[cfg(feature = "signatures")]
+    signature: Option<(Pkcs12, SignatureConfig)>,
+    /// Callback and user data from `pdf_set_progress_callback`.
+    progress: Option<(PdfProgressCallback, *mut c_void)>,
+    /// File the pages are written to, from `pdf_begin_streaming`.
+    streaming: Option<StreamingWriter<BufWriter<File>>>,
+    /// Set while a call is using the handle.
+    busy: AtomicBool,
+}
+
+impl PdfHandle {
+    fn new(document: Document) -> Self {
+        Self {
+            tag: HANDLE_TAG,
+            document,
+            landscape: false,
+            style: Style::default(),
+            leading: Leading::Multiple(LINE_SPACING),
+            saved_states: HashMap::new(),
+            open_tags: Vec::new(),
+            last_tag: None,
+            open_layers: HashMap::new(),
+            fonts: Vec::new(),
+            fallback_fonts: Vec::new(),
+            default_font: (None, DEFAULT_FONT_SIZE),
+            shaping: Shaping::default(),
+            gradient_count: 0,
+            tiling_patterns: Vec::new(),
+            #[cfg(feature = "parser")]
+            xobjects: Vec::new(),
+            recovery: false,
+            import_recovered: false,
+            data: RefCell::new(None),
+            pdfa_report: CString::default(),
+            audit_report: CString::default(),
+            extracted_text: CString::default(),
+            #[cfg(feature = "signatures")]
+            signature: None,
+            progress: None,
+            streaming: None,
+            busy: AtomicBool::new(false),
+        }
+    }
+
+    /// Returns an independent deep copy, without the cached output.
+    fn duplicate(&self) -> Self {
+        Self {
+            landscape: self.landscape,
+            style: self.style.clone(),
+            leading: self.leading,
+            saved_states: self.saved_states.clone(),
+            open_tags: self.open_tags.clone(),
+            last_tag: self.last_tag,
+            open_layers: self.open_layers.clone(),
+            fonts: self.fonts.clone(),
+            fallback_fonts: self.fallback_fonts.clone(),
+            default_font: self.default_font,
+            shaping: self.shaping,
+            gradient_count: self.gradient_count,
+            tiling_patterns: self.tiling_patterns.clone(),
+            #[cfg(feature = "parser")]
+            xobjects: self.xobjects.clone(),
+            recovery: self.recovery,
+            import_recovered: self.import_recovered,
+            #[cfg(feature = "signatures")]
+            signature: self.signature.clone(),
+            ..Self::new(self.document.clone())
+        }
+    }
+
+    /// Opens a PDF file to import pages from, rebuilding a damaged
+    /// cross-reference table if recovery is on, and records whether it was.
+    #[cfg(feature = "parser")]
+    fn open_import(&mut self, bytes: Vec<u8>) -> crate::error::PdfResult<crate::parser::PdfReader> {
+        let reader = if self.recovery {
+            crate::parser::PdfReader::from_bytes_with_recovery(bytes)
+        } else {
+            crate::parser::PdfReader::from_bytes(bytes)
+        };
+        self.record_recovery(reader)
+    }
+
+    /// Maps the PDF file at `path` into memory to import pages from, like
+    /// [`open_import`](Self::open_import).
+    ///
+    /// # Safety
+    /// The file must not be changed or truncated while the reader exists.
+    #[cfg(feature = "parser")]
+    unsafe fn open_import_file(&mut self, path: &str) -> crate::error::PdfResult<crate::parser::PdfReader> {
+        let reader = if self.recovery {
+            crate::parser::PdfReader::from_mapped_file_with_recovery(path)
+        } else {
+            crate::parser::PdfReader::from_mapped_file(path)
+        };
+        self.record_recovery(reader)
+    }
+
+    #[cfg(feature = "parser")]
+    fn record_recovery(
+        &mut self,
+        reader: crate::error::PdfResult<crate::parser::PdfReader>,
+    ) -> crate::error::PdfResult<crate::parser::PdfReader> {
+        self.import_recovered = reader.as_ref().is_ok_and(|reader| reader.was_recovered());
+        reader
+    }
+
+    /// Returns the page at `index` for modification, discarding any cached output.
+    /// The current fill pattern and overprint settings are added to the page's
+    /// resources with [`add_style_resources`]. Fails with
+    /// `PDF_ERR_PAGE_OUT_OF_RANGE` if there is no such page.
+    fn page_mut(&mut self, index: i32) -> Result<&mut Page, i32> {
+        let position = self.page_position(index)?;
+        let page = &mut self.document.pages[position];
+        self.data.get_mut().take();
+        add_style_resources(page, &self.style, &self.tiling_patterns);
+        Ok(page)
+    }
+
+    /// Returns where page `index` is in `document.pages`, which no longer
+    /// holds the pages written by `pdf_begin_streaming`. Fails with
+    /// `PDF_ERR_PAGE_OUT_OF_RANGE` if there is no such page or it was written.
+    fn page_position(&self, index: i32) -> Result<usize, i32> {
+        let written = self.written_pages();
+        let count = written + self.document.pages.len();
+        match usize::try_from(index) {
+            Ok(index) if index < written => Err(fail(
+                PDF_ERR_PAGE_OUT_OF_RANGE,
+                format!("Page {} has already been written to the streamed file", index),
+            )),
+            Ok(index) if index < count => Ok(index - written),
+            _ => Err(fail(
+                PDF_ERR_PAGE_OUT_OF_RANGE,
+                format!("Page index {} is out of range (page count is {})", index, count),
+            )),
+        }
+    }
+
+    /// Returns the number of pages written since `pdf_begin_streaming`.
+    fn written_pages(&self) -> usize {
+        self.streaming.as_ref().map_or(0, |writer| writer.page_count())
+    }
+
+    /// When streaming, writes out and drops every page but the last `keep`.
+    fn write_streamed_pages(&mut self, keep: usize) -> Result<(), i32> {
+        let writer = match self.streaming.as_mut() {
+            Some(writer) => writer,
+            None => return Ok(()),
+        };
+        let before = writer.page_count();
+        let count = self.document.pages.len().saturating_sub(keep);
+        let result = self.document.pages[..count]
+            .iter()
+            .try_for_each(|page| writer.write_page(&self.document, page));
+        // A page that failed part way through is in the file all the same
+        let written = writer.page_count() - before;
+        self.document.pages.drain(..written);
+        result.map_err(|e| fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to write page: {}", e)))
+    }
+
+    /// Saves the graphics state of the page at `index`, appends the operators
+    /// `f` builds, and records `state` for the matching `pop_state`.
+    fn push_state(
+        &mut self,
+        index: i32,
+        state: SavedState,
+        f: impl FnOnce(ContentBuilder) -> ContentBuilder,
+    ) -> Result<(), i32> {
+        let page = self.page_mut(index)?;
+        append_content(page, |c| f(c.save_state()));
+        self.saved_states.entry(index as usize).or_default().push(state);
+        Ok(())
+    }
+
+    /// Restores graphics states of the page at `index` down to the most recent
+    /// one `matches` accepts, popping the clips pushed after it, and returns
+    /// it. Fails with `PDF_ERR_INVALID_ARGUMENT` naming `what` when the page
+    /// has no such state, or when a `pdf_save_state` after it is unrestored.
+    fn pop_state(&mut self, index: i32, what: &str, matches: fn(&SavedState) -> bool) -> Result<SavedState, i32> {
+        self.page_mut(index)?;
+        let states = self.saved_states.entry(index as usize).or_default();
+        let position = match states.iter().rposition(matches) {
+            Some(position) => position,
+            None => return Err(fail(PDF_ERR_INVALID_ARGUMENT, format!("Page {} has no {}", index, what))),
+        };
+        if states[position + 1..].iter().any(|state| matches!(state, SavedState::Drawing(..))) {
+            return Err(fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("A state saved on page {} later is not restored yet", index),
+            ));
+        }
+        let popped = states.len() - position;
+        let state = states.drain(position..).next().expect("position is in range");
+        let page = self.page_mut(index)?;
+        append_content(page, |c| (0..popped).fold(c, |c, _| c.restore_state()));
+        Ok(state)
+    }
+
+    /// Applies `change`, which moves or removes entries the way the pages
+    /// were, to the indices of the `count` pages, so the states saved on each
+    /// page and the tags open on it stay with their page.
+    fn rearrange_pages(&mut self, count: usize, change: impl FnOnce(&mut Vec<usize>)) {
+        let mut order: Vec<usize> = (0..count).collect();
+        change(&mut order);
+        let new_index = |old: usize| order.iter().position(|&i| i == old);
+        self.saved_states = self.saved_states.drain().filter_map(|(i, s)| Some((new_index(i)?, s))).collect();
+        for tag in &mut self.open_tags {
+            tag.page = tag.page.and_then(new_index);
+        }
+        self.open_layers = self.open_layers.drain().filter_map(|(i, l)| Some((new_index(i)?, l))).collect();
+    }
+
+    /// Fails if a layer begun inside the innermost open tag is still open,
+    /// since pausing or ending the tag's marked content would end the layer's.
+    fn check_no_layer_in_tag(&self) -> Result<(), i32> {
+        let tags = self.open_tags.len();
+        let inside = self.open_layers.values().flatten().find(|open| tags > 0 && open.tags == tags);
+        match inside {
+            Some(open) => Err(fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("Layer {} was begun inside the open tag and must end first", open.layer),
+            )),
+            None => Ok(()),
+        }
+    }
+
+    /// Begins a marked-content sequence of the open tag `tag` on `page`,
+    /// unless its element only groups others.
+    fn open_sequence(&mut self, tag: usize, page: usize) -> Result<(), i32> {
+        let element = self.open_tags[tag].element;
+        let structure_type = self.document.structure[element].structure_type;
+        if structure_type.is_grouping() {
+            return Ok(());
+        }
+        let mcid = match self.document.mark_content(element, page) {
+            Ok(mcid) => mcid,
+            Err(e) => return Err(fail(PDF_ERR_INVALID_ARGUMENT, e.to_string())),
+        };
+        let page_ref = self.page_mut(page as i32)?;
+        append_content(page_ref, |c| c.begin_tagged_content(structure_type.name(), mcid));
+        self.open_tags[tag].page = Some(page);
+        Ok(())
+    }
+
+    /// Ends the marked-content sequence of the open tag `tag`, if one is open.
+    fn close_sequence(&mut self, tag: usize) -> Result<(), i32> {
+        if let Some(page) = self.open_tags[tag].page.take() {
+            append_content(self.page_mut(page as i32)?, |c| c.end_marked_content());
+        }
+        Ok(())
+    }
+
+    /// Returns the content area of the page at `index`.
+    /// Fails with `PDF_ERR_PAGE_OUT_OF_RANGE` if there is no such page.
+    fn content_rect(&self, index: i32) -> Result<Rectangle, i32> {
+        let position = self.page_position(index)?;
+        self.document.content_rect(position).ok_or(PDF_ERR_PAGE_OUT_OF_RANGE)
+    }
+
+    /// Splits `text` into runs that each use one font: every character takes
+    /// the first of `primary` (`None` for the built-in Helvetica) and the
+    /// fallback fonts with a glyph for it. Characters no font has stay in the
+    /// run before them. Returns the runs and the number of such characters.
+    fn font_runs(&self, text: &str, primary: Option<usize>) -> (Vec<(Option<usize>, String)>, usize) {
+        // Standard fonts are written one byte per character, so only ASCII is safe
+        let covers = |font: Option<usize>, c: char| match font {
+            None => (' '..='~').contains(&c),
+            Some(id) => self.fonts[id].1.has_glyph(c),
+        };
+        let candidates: Vec<Option<usize>> =
+            std::iter::once(primary).chain(self.fallback_fonts.iter().map(|&id| Some(id))).collect();
+
+        let mut runs: Vec<(Option<usize>, String)> = Vec::new();
+        let mut missing = 0;
+        for c in text.chars() {
+            let found = if c.is_control() {
+                None
+            } else {
+                let found = candidates.iter().copied().find(|&font| covers(font, c));
+                missing += found.is_none() as usize;
+                found
+            };
+            match (found, runs.last_mut()) {
+                (Some(font), Some((last, run))) if *last == font => run.push(c),
+                (None, Some((_, run))) => run.push(c),
+                (found, _) => runs.push((found.unwrap_or(primary), c.to_string())),
+            }
+        }
+        if runs.is_empty() {
+            runs.push((primary, String::new()));
+        }
+        (runs, missing)
+    }
+
+    /// Returns the width of `text` at `font_size` when drawn in the runs
+    /// `font_runs` splits it into, including the current spacing.
+    fn runs_width(&self, text: &str, font_size: f64, primary: Option<usize>) -> f64 {
+        self.font_runs(text, primary)
+            .0
+            .iter()
+            .map(|(font, run)| match font {
+                None => calculate_helvetica_width(run, font_size) + self.style.spacing_width(run, true),
+                Some(id) => self.font_run_width(*id, run, font_size),
+            })
+            .sum()
+    }
+
+    /// Returns the width of `run` at `font_size` in loaded font `id`, with
+    /// the current kerning, ligatures and character spacing.
+    fn font_run_width(&self, id: usize, run: &str, font_size: f64) -> f64 {
+        let font = &self.fonts[id].1;
+        let glyphs = font.shape(run, self.shaping);
+        font.shaped_width(&glyphs, font_size) + self.style.char_spacing * glyphs.len() as f64
+    }
+
+    /// Returns the serialized document, building it on first use, or the
+    /// error code of the failure.
+    fn bytes(&self) -> Result<Ref<'_, Vec<u8>>, i32> {
+        self.bytes_until(|| false)
+    }
+
+    /// Returns the serialized document like `bytes`, but stops building it
+    /// with `PDF_ERR_CANCELLED` once `cancel` returns true.
+    fn bytes_until(&self, cancel: impl Fn() -> bool) -> Result<Ref<'_, Vec<u8>>, i32> {
+        if self.data.borrow().is_none() {
+            let bytes = self.serialize_until(cancel)?;
+            #[cfg(feature = "signatures")]
+            let bytes = match self.sign(bytes) {
+                Ok(bytes) => bytes,
+                Err(message) => return Err(fail(PDF_ERR_INVALID_ARGUMENT, message)),
+            };
+            *self.data.borrow_mut() = Some(bytes);
+        }
+        Ref::filter_map(self.data.borrow(), |data| data.as_ref()).map_err(|_| PDF_ERR_INVALID_ARGUMENT)
+    }
+
+    /// Fails with `PDF_ERR_INVALID_ARGUMENT` while the document is being
+    /// streamed, as the pages already written are no longer held.
+    fn check_not_streaming(&self) -> Result<(), i32> {
+        if self.streaming.is_some() {
+            return Err(fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                "The document is being streamed to a file; finish it with pdf_finalize_streaming",
+            ));
+        }
+        Ok(())
+    }
+
+    /// Serializes the document without signing it or caching the output,
+    /// stopping with `PDF_ERR_CANCELLED` once `cancel` returns true.
+    fn serialize_until(&self, cancel: impl Fn() -> bool) -> Result<Vec<u8>, i32> {
+        self.check_not_streaming()?;
+        match self
+            .document
+            .save_to_bytes_with_progress(|fraction| self.report_progress(STAGE_SERIALIZE, fraction) && !cancel())
+        {
+            Ok(bytes) => {
+                self.report_progress(STAGE_SERIALIZE, 1.0);
+                Ok(bytes)
+            }
+            Err(PdfError::Cancelled) if cancel() => Err(fail(PDF_ERR_CANCELLED, "Serialization was cancelled")),
+            Err(PdfError::Cancelled) => Err(cancelled()),
+            Err(e) => Err(fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to serialize document: {}", e))),
+        }
+    }
+
+    /// Passes `fraction` of `stage` to the callback from
+    /// `pdf_set_progress_callback`, returning false if it asks to cancel.
+    fn report_progress(&self, stage: &[u8], fraction: f64) -> bool {
+        match self.progress {
+            // SAFETY: the caller of `pdf_set_progress_callback` guarantees the
+            // callback is valid, and stages are null-terminated.
+            Some((callback, user_data)) => unsafe { callback(fraction, stage.as_ptr().cast(), user_data) == 0 },
+            None => true,
+        }
+    }
+
+    /// Signs serialized `bytes` with the identity from `pdf_sign`, if any.
+    #[cfg(feature = "signatures")]
+    fn sign(&self, bytes: Vec<u8>) -> Result<Vec<u8>, String> {
+        match &self.signature {
+            Some((identity, config)) => DocumentSigner::from_bytes(bytes)
+                .config(config.clone())
+                .pkcs12(identity.clone())
+                .sign()
+                .map_err(|e| format!("Failed to sign document: {}", e)),
+            None => Ok(bytes),
+        }
+    }
+}
+
+/// Creates an empty document with the settings new handles start with:
+/// streams are compressed at the default level if the library supports it.
+fn new_document() -> Document {
+    #[cfg_attr(not(feature = "compression"), allow(unused_mut))]
+    let mut document = Document::new();
+    #[cfg(feature = "compression")]
+    {
+        document.compress_streams = true;
+    }
+    document
+}
+
+/// Creates a page with the default text font registered.
+fn new_page(width: f64, height: f64) -> Page {
+    let mut page = Page::new(Rectangle::from_dimensions(width, height));
+    page.add_font(DEFAULT_FONT_NAME, Standard14Font::Helvetica.into());
+    page
+}
+
+/// Creates a page showing `text` one inch from the top-left corner.
+fn text_page(text: &str, font_size: f64, width: f64, height: f64) -> Page {
+    let mut page = new_page(width, height);
+    append_content(&mut page, |c| {
+        c.text(DEFAULT_FONT_NAME, font_size, 72.0, height - 72.0, text)
+    });
+    page
+}
+
+/// Returns how many lines of Helvetica, `leading` points apart, fit in a box
+/// `height` points tall. A line fits if its descenders stay inside the box.
+fn line_capacity(height: f64, font_size: f64, leading: f64) -> usize {
+    let metrics = FontMetrics::for_standard14(Standard14Font::Helvetica);
+    let ascent = font_size * metrics.ascender as f64 / metrics.units_per_em as f64;
+    let descent = -font_size * metrics.descender as f64 / metrics.units_per_em as f64;
+    if ascent + descent > height {
+        0
+    } else {
+        ((height - ascent - descent) / leading).floor() as usize + 1
+    }
+}
+
+/// Builds a Helvetica text block showing `lines` downward from a box whose top
+/// edge is at `top`, with the first line's ascenders touching it.
+fn text_lines(lines: &[String], x: f64, top: f64, font_size: f64, leading: f64) -> TextBuilder {
+    let metrics = FontMetrics::for_standard14(Standard14Font::Helvetica);
+    let ascent = font_size * metrics.ascender as f64 / metrics.units_per_em as f64;
+    let mut builder = TextBuilder::new()
+        .font(DEFAULT_FONT_NAME, font_size)
+        .leading(leading)
+        .move_to(x, top - ascent);
+    for (i, line) in lines.iter().enumerate() {
+        builder = if i == 0 {
+            builder.show(line.as_str())
+        } else {
+            builder.next_line_show(line.as_str())
+        };
+    }
+    builder
+}
+
+/// Appends operators to a page's content stream.
+fn append_content(page: &mut Page, f: impl FnOnce(ContentBuilder) -> ContentBuilder) {
+    let content = std::mem::take(&mut page.content);
+    page.content = f(content);
+}
+
+/// Adds the fill pattern and overprint settings of `style` to the resources
+/// of `page`, unless it has them already, so drawing with them finds them.
+/// `tiling_patterns` are the patterns of `pdf_create_tiling_pattern`.
+fn add_style_resources(page: &mut Page, style: &Style, tiling_patterns: &[TilingPattern]) {
+    match &style.fill_pattern {
+        Some((name, FillPattern::Gradient(gradient))) => {
+            if !page.patterns.iter().any(|(existing, _)| existing == name) {
+                page.add_pattern(name.clone(), gradient.clone());
+            }
+        }
+        Some((name, FillPattern::Tiling(id))) => {
+            if !page.tiling_patterns.iter().any(|(existing, _)| existing == name) {
+                page.add_tiling_pattern(name.clone(), tiling_patterns[*id].clone());
+            }
+        }
+        None => {}
+    }
+    if style.overprint != Overprint::default() {
+        page.add_overprint(style.overprint);
+    }
+}
+
+/// Returns whether `object` can be written inside a resource dictionary as it
+/// is: it holds no streams, which must be indirect, and no references, which
+/// would point at unrelated objects of the output.
+#[cfg(feature = "parser")]
+fn is_direct(object: &Object) -> bool {
+    match object {
+        Object::Stream(_) | Object::Reference(_) => false,
+        Object::Array(array) => array.iter().all(is_direct),
+        Object::Dictionary(dict) => dict.iter().all(|(_, value)| is_direct(value)),
+        _ => true,
+    }
+}
+
+/// Applies an orientation value to a rectangle.
+fn orient(rect: Rectangle, orientation: i32) -> Option<Rectangle> {
+    match orientation {
+        PDF_ORIENTATION_PORTRAIT => Some(rect.portrait()),
+        PDF_ORIENTATION_LANDSCAPE => Some(rect.landscape()),
+        _ => None,
+    }
+}
+
+/// Reads the name of a new form field, which must not be used by another field.
+unsafe fn field_name(pdf: &mut PdfHandle, name: *const c_char) -> Result<String, i32> {
+    let name = match str_arg(name) {
+        Some(name) if !name.is_empty() && !name.contains('.') => name,
+        Some(name) if !name.is_empty() => {
+            return Err(fail(PDF_ERR_INVALID_ARGUMENT, format!("Field name {} contains a period", name)))
+        }
+        _ => return Err(fail(PDF_ERR_INVALID_ARGUMENT, "Field name is null, empty or not valid UTF-8")),
+    };
+    if pdf.document.form_field_mut(name).is_some() {
+        return Err(fail(PDF_ERR_INVALID_ARGUMENT, format!("A form field named {} already exists", name)));
+    }
+    Ok(name.to_string())
+}
+
+thread_local! {
+    /// Message for the most recent failed call on this thread.
+    static LAST_ERROR: RefCell<Option<CString>> = const { RefCell::new(None) };
+}
+
+/// Records `message` as the calling thread's last error.
+fn set_last_error(message: impl Into<String>) {
+    let mut message = message.into();
+    message.retain(|c| c != '\0');
+    LAST_ERROR.with(|last| *last.borrow_mut() = CString::new(message).ok());
+}
+
+/// Records `message` as the calling thread's last error and returns `code`.
+fn fail(code: i32, message: impl Into<String>) -> i32 {
+    set_last_error(message);
+    code
+}
+
+/// Converts a C string to `&str`, returning `None` for null or invalid UTF-8.
+unsafe fn str_arg<'a>(s: *const c_char) -> Option<&'a str> {
+    if s.is_null() {
+        return None;
+    }
+    CStr::from_ptr(s).to_str().ok()
+}
+
+/// Exclusive access to a handle for the duration of one call.
+struct HandleGuard<'a> {
+    handle: NonNull<PdfHandle>,
+    _marker: std::marker::PhantomData<&'a mut PdfHandle>,
+}
+
+impl Deref for HandleGuard<'_> {
+    type Target = PdfHandle;
+
+    fn deref(&self) -> &PdfHandle {
+        // SAFETY: the busy flag guarantees no other guard exists for this handle.
+        unsafe { self.handle.as_ref() }
+    }
+}
+
+impl DerefMut for HandleGuard<'_> {
+    fn deref_mut(&mut self) -> &mut PdfHandle {
+        // SAFETY: as above.
+        unsafe { self.handle.as_mut() }
+    }
+}
+
+impl Drop for HandleGuard<'_> {
+    fn drop(&mut self) {
+        self.busy.store(false, Ordering::Release);
+    }
+}
+
+/// Marks a live `PdfHandle` ("RUSTPDF!").
+const HANDLE_TAG: u64 = 0x5255_5354_5044_4621;
+
+/// Returns whether `handle` still carries the live tag. Freeing a handle
+/// clears the tag, so this catches most double frees and uses after free.
+unsafe fn is_live(handle: *const PdfHandle) -> bool {
+    ptr::addr_of!((*handle).tag).read_volatile() == HANDLE_TAG
+}
+
+/// Claims a raw handle pointer for exclusive use.
+///
+/// Fails with `PDF_ERR_INVALID_ARGUMENT` for null or a handle that is not
+/// live, and `PDF_ERR_BUSY` if another call is using the handle.
+unsafe fn handle_mut<'a>(handle: *const PdfHandle) -> Result<HandleGuard<'a>, i32> {
+    let handle = match NonNull::new(handle as *mut PdfHandle) {
+        Some(handle) => handle,
+        None => return Err(fail(PDF_ERR_INVALID_ARGUMENT, "Handle is null")),
+    };
+    if !is_live(handle.as_ptr()) {
+        return Err(fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "Handle is not a live PDF handle; it may already have been freed",
+        ));
+    }
+    let busy = &(*handle.as_ptr()).busy;
+    if busy
+        .compare_exchange(false, true, Ordering::Acquire, Ordering::Relaxed)
+        .is_err()
+    {
+        return Err(fail(PDF_ERR_BUSY, "Handle is in use by another call"));
+    }
+    Ok(HandleGuard {
+        handle,
+        _marker: std::marker::PhantomData,
+    })
 }
 
-/// Create a simple PDF with text and return a handle.
+/// Page size presets accepted by `pdf_create_simple_sized`.
+#[repr(C)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PdfPageSize {
+    /// US Letter (8.5in x 11in)
+    Letter = 0,
+    /// ISO A4 (210mm x 297mm)
+    A4 = 1,
+    /// US Legal (8.5in x 14in)
+    Legal = 2,
+    /// ISO A3 (297mm x 420mm)
+    A3 = 3,
+    /// ISO A5 (148mm x 210mm)
+    A5 = 4,
+}
+
+impl PdfPageSize {
+    /// Converts a raw C enum value, returning `None` for unknown values.
+    pub fn from_raw(value: i32) -> Option<Self> {
+        match value {
+            0 => Some(PdfPageSize::Letter),
+            1 => Some(PdfPageSize::A4),
+            2 => Some(PdfPageSize::Legal),
+            3 => Some(PdfPageSize::A3),
+            4 => Some(PdfPageSize::A5),
+            _ => None,
+        }
+    }
+
+    /// Width and height in points.
+    pub fn dimensions(self) -> (f64, f64) {
+        match self {
+            PdfPageSize::Letter => (612.0, 792.0),
+            PdfPageSize::A4 => (595.28, 841.89),
+            PdfPageSize::Legal => (612.0, 1008.0),
+            PdfPageSize::A3 => (841.89, 1190.55),
+            PdfPageSize::A5 => (419.53, 595.28),
+        }
+    }
+}
+
+/// Create a simple PDF with text on a US Letter page and return a handle.
 /// Returns null on failure.
 ///
 /// # Safety
@@ -27,100 +1110,8877 @@ pub unsafe extern "C" fn pdf_create_simple(
     text: *const c_char,
     font_size: f64,
 ) -> *mut PdfHandle {
-    if text.is_null() {
+    pdf_create_simple_sized(text, font_size, PdfPageSize::Letter as i32)
+}
+
+/// Create a simple PDF with text on a page of the given size and return a handle.
+/// `page_size` is a `PdfPageSize` value.
+/// Returns null on failure, including an unknown page size.
+///
+/// # Safety
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_create_simple_sized(
+    text: *const c_char,
+    font_size: f64,
+    page_size: i32,
+) -> *mut PdfHandle {
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => {
+            set_last_error("Text is null or not valid UTF-8");
+            return ptr::null_mut();
+        }
+    };
+    let (width, height) = match PdfPageSize::from_raw(page_size) {
+        Some(size) => size.dimensions(),
+        None => {
+            set_last_error(format!("Unknown page size {}", page_size));
+            return ptr::null_mut();
+        }
+    };
+
+    let mut document = new_document();
+    document.add_page(text_page(text, font_size, width, height));
+
+    Box::into_raw(Box::new(PdfHandle::new(document)))
+}
+
+/// Create a PDF with one US Letter page per string in `texts` and return a handle.
+/// Each page shows its text like `pdf_create_simple`; a null entry produces a blank
+/// page. Building many pages in one call avoids a handle per record.
+/// Returns null on failure, including an entry that is not valid UTF-8.
+///
+/// # Safety
+/// `texts` must point to `text_count` pointers, each null or a valid
+/// null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_create_multipage(
+    texts: *const *const c_char,
+    text_count: usize,
+    font_size: f64,
+) -> *mut PdfHandle {
+    if texts.is_null() && text_count > 0 {
+        set_last_error("Text array is null");
         return ptr::null_mut();
     }
+    let (width, height) = PdfPageSize::Letter.dimensions();
 
-    let c_str = match CStr::from_ptr(text).to_str() {
-        Ok(s) => s,
-        Err(_) => return ptr::null_mut(),
-    };
+    let mut document = new_document();
+    for i in 0..text_count {
+        let text = *texts.add(i);
+        let page = if text.is_null() {
+            new_page(width, height)
+        } else {
+            match str_arg(text) {
+                Some(t) => text_page(t, font_size, width, height),
+                None => {
+                    set_last_error(format!("Text {} is not valid UTF-8", i));
+                    return ptr::null_mut();
+                }
+            }
+        };
+        document.add_page(page);
+    }
+
+    Box::into_raw(Box::new(PdfHandle::new(document)))
+}
 
-    let content = ContentBuilder::new()
-        .text("F1", font_size, 72.0, 750.0, c_str);
+/// Create an empty PDF document with no pages.
+/// Pages are added with `pdf_add_page`. Nothing is allocated beyond the handle
+/// itself, so creating one handle per request is inexpensive.
+/// Returns null on failure.
+#[no_mangle]
+pub extern "C" fn pdf_create_empty() -> *mut PdfHandle {
+    Box::into_raw(Box::new(PdfHandle::new(new_document())))
+}
 
-    let page = PageBuilder::a4()
-        .font("F1", Standard14Font::Helvetica)
-        .content(content)
-        .build();
+/// Create an independent deep copy of a document, including its pages, loaded
+/// fonts, orientation and color state.
+/// The copy is unaffected by later changes to the original, and either may be
+/// freed first. Cloning a prepared template is the intended way to hand each
+/// thread its own handle.
+/// Returns null on failure, including when `handle` is in use by another call.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// The returned handle must be freed with `pdf_free`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_clone(handle: *const PdfHandle) -> *mut PdfHandle {
+    match handle_mut(handle) {
+        Ok(pdf) if pdf.streaming.is_some() => {
+            fail(PDF_ERR_INVALID_ARGUMENT, "A document being streamed cannot be cloned");
+            ptr::null_mut()
+        }
+        Ok(pdf) => Box::into_raw(Box::new(pdf.duplicate())),
+        Err(_) => ptr::null_mut(),
+    }
+}
 
-    let doc = match DocumentBuilder::new().page(page).build() {
-        Ok(d) => d,
-        Err(_) => return ptr::null_mut(),
+/// Append a new page to the document.
+/// A width or height of 0 selects US Letter (612 x 792 points).
+/// Returns the index of the new page, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_page(handle: *mut PdfHandle, width: f64, height: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
     };
 
-    let data = match doc.save_to_bytes() {
-        Ok(d) => d,
-        Err(_) => return ptr::null_mut(),
+    if width < 0.0 || height < 0.0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Page width and height must not be negative");
+    }
+
+    let (width, height) = if width == 0.0 || height == 0.0 {
+        let letter = Rectangle::letter();
+        (letter.width(), letter.height())
+    } else {
+        (width, height)
     };
 
-    Box::into_raw(Box::new(PdfHandle { data }))
+    let mut page = new_page(width, height);
+    if pdf.landscape {
+        page.media_box = page.media_box.landscape();
+    }
+
+    pdf.document.add_page(page);
+    pdf.data.get_mut().take();
+    if let Err(code) = pdf.write_streamed_pages(1) {
+        return code;
+    }
+    (pdf.written_pages() + pdf.document.page_count() - 1) as i32
 }
 
-/// Get the PDF data from a handle.
-/// Returns the length of the data, or 0 on failure.
-/// The data pointer is written to `out_data`.
+/// Move the page at `from_index` to `to_index`, shifting the pages in between.
+/// Links, bookmarks and named destinations keep pointing at the same pages.
+/// Returns 0 on success, or a negative error code on failure.
 ///
 /// # Safety
 /// `handle` must be a valid pointer returned by `pdf_create_*` functions.
-/// `out_data` must be a valid pointer to a `*const u8`.
 #[no_mangle]
-pub unsafe extern "C" fn pdf_get_data(
-    handle: *const PdfHandle,
-    out_data: *mut *const u8,
-) -> usize {
-    if handle.is_null() || out_data.is_null() {
-        return 0;
+pub unsafe extern "C" fn pdf_move_page(handle: *mut PdfHandle, from_index: i32, to_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if pdf.streaming.is_some() {
+        return fail(PDF_ERR_UNSUPPORTED, "Pages cannot be moved while streaming");
     }
+    let (from, to) = match (usize::try_from(from_index), usize::try_from(to_index)) {
+        (Ok(from), Ok(to)) => (from, to),
+        _ => return fail(PDF_ERR_PAGE_OUT_OF_RANGE, "Page index is negative"),
+    };
 
-    let pdf = &*handle;
-    *out_data = pdf.data.as_ptr();
-    pdf.data.len()
+    let count = pdf.document.page_count();
+    if let Err(e) = pdf.document.move_page(from, to) {
+        return page_change_error(e);
+    }
+    pdf.rearrange_pages(count, |pages| {
+        let moved = pages.remove(from);
+        pages.insert(to, moved);
+    });
+    pdf.data.get_mut().take();
+    PDF_OK
 }
 
-/// Save the PDF to a file.
-/// Returns 0 on success, -1 on failure.
+/// Delete the page at `page_index`. Links, bookmarks and named destinations
+/// that pointed at it go to the page that takes its place, or the new last
+/// page, instead; bookmarks and named destinations are removed with the last page.
+/// Later page indices shift down by one.
+/// Returns 0 on success, or a negative error code on failure.
 ///
 /// # Safety
 /// `handle` must be a valid pointer returned by `pdf_create_*` functions.
-/// `path` must be a valid null-terminated C string.
 #[no_mangle]
-pub unsafe extern "C" fn pdf_save_to_file(
-    handle: *const PdfHandle,
-    path: *const c_char,
-) -> i32 {
-    if handle.is_null() || path.is_null() {
-        return -1;
+pub unsafe extern "C" fn pdf_delete_page(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if pdf.streaming.is_some() {
+        return fail(PDF_ERR_UNSUPPORTED, "Pages cannot be deleted while streaming");
     }
-
-    let pdf = &*handle;
-    let path_str = match CStr::from_ptr(path).to_str() {
-        Ok(s) => s,
-        Err(_) => return -1,
+    let index = match usize::try_from(page_index) {
+        Ok(index) => index,
+        Err(_) => return fail(PDF_ERR_PAGE_OUT_OF_RANGE, format!("Page index {} is negative", page_index)),
     };
 
-    match std::fs::write(path_str, &pdf.data) {
-        Ok(_) => 0,
-        Err(_) => -1,
+    let count = pdf.document.page_count();
+    if let Err(e) = pdf.document.delete_page(index) {
+        return page_change_error(e);
     }
+    pdf.rearrange_pages(count, |pages| {
+        pages.remove(index);
+    });
+    pdf.data.get_mut().take();
+    PDF_OK
 }
 
-/// Free a PDF handle.
+/// Records why a page could not be moved or deleted and returns the error code.
+fn page_change_error(e: PdfError) -> i32 {
+    let code = match e {
+        PdfError::Document(DocumentError::PageOutOfRange { .. }) => PDF_ERR_PAGE_OUT_OF_RANGE,
+        PdfError::Document(DocumentError::NotIncremental(_)) => PDF_ERR_UNSUPPORTED,
+        _ => PDF_ERR_INVALID_ARGUMENT,
+    };
+    fail(code, e.to_string())
+}
+
+/// Append every page of an existing PDF to the document.
+/// Objects of the input are renumbered, and resources shared by its pages are
+/// written once. The appended pages accept text and drawing like any other page.
+/// Text fields, checkboxes, combo boxes and list boxes are kept and can be
+/// filled with `pdf_fill_form_field`; a field named like one already in the
+/// document is renamed by appending `_2`, or the first higher number that is
+/// free. Other annotations and form fields, and bookmarks, are not copied.
+/// Returns the number of pages appended, or a negative error code on failure.
 ///
 /// # Safety
-/// `handle` must be a valid pointer returned by `pdf_create_*` functions,
-/// or null (which is safely ignored).
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must point to at least `data_len` readable bytes.
 #[no_mangle]
-pub unsafe extern "C" fn pdf_free(handle: *mut PdfHandle) {
-    if !handle.is_null() {
-        drop(Box::from_raw(handle));
+pub unsafe extern "C" fn pdf_append_pdf(
+    handle: *mut PdfHandle,
+    data: *const u8,
+    data_len: usize,
+) -> i32 {
+    pdf_append_pdf_renamed(handle, data, data_len, ptr::null_mut())
+}
+
+/// Append every page of an existing PDF like `pdf_append_pdf`, and write the
+/// number of form fields renamed because their names were taken to
+/// `out_renamed`, which may be null.
+/// Returns the number of pages appended, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must point to at least `data_len` readable bytes, and `out_renamed`
+/// must be null or valid for writing an `int`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_append_pdf_renamed(
+    handle: *mut PdfHandle,
+    data: *const u8,
+    data_len: usize,
+    out_renamed: *mut i32,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if data.is_null() || data_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "PDF data is empty");
+    }
+
+    #[cfg(feature = "parser")]
+    {
+        let bytes = std::slice::from_raw_parts(data, data_len).to_vec();
+        let reader = pdf.open_import(bytes);
+        append_reader(pdf, reader, out_renamed)
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, out_renamed);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
     }
 }
 
-/// Get the library version as a string.
-/// The returned string is statically allocated and should not be freed.
+/// Append every page of the PDF file at `path` like `pdf_append_pdf_renamed`,
+/// reading the file here instead of having the caller pass its bytes.
+/// The file is mapped into memory rather than read, so only the parts
+/// holding the objects the pages use are loaded; on systems without memory
+/// maps it is read whole. `out_renamed` may be null.
+/// Returns the number of pages appended, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `path` must be a valid null-terminated C string, and `out_renamed` must be
+/// null or valid for writing an `int`. The file must not be written to or
+/// truncated until the call returns: reading a mapped file that was truncated
+/// raises `SIGBUS`, which terminates the process.
 #[no_mangle]
-pub extern "C" fn pdf_version() -> *const c_char {
-    static VERSION: &[u8] = b"0.1.0\0";
+pub unsafe extern "C" fn pdf_append_pdf_from_path(
+    handle: *mut PdfHandle,
+    path: *const c_char,
+    out_renamed: *mut i32,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let path = match str_arg(path) {
+        Some(path) => path,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Path is null or not valid UTF-8"),
+    };
+
+    #[cfg(feature = "parser")]
+    {
+        // SAFETY: the caller must not change the file during the call, and
+        // the reader is dropped before it returns
+        let reader = match pdf.open_import_file(path) {
+            Err(PdfError::Io(e)) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to read '{}': {}", path, e)),
+            reader => reader,
+        };
+        append_reader(pdf, reader, out_renamed)
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, path, out_renamed);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Appends the pages of an opened PDF to the document, for the
+/// `pdf_append_pdf*` functions, and returns the number of pages appended or
+/// an error code.
+#[cfg(feature = "parser")]
+unsafe fn append_reader(
+    mut pdf: HandleGuard<'_>,
+    reader: crate::error::PdfResult<crate::parser::PdfReader>,
+    out_renamed: *mut i32,
+) -> i32 {
+    use crate::error::ParserError;
+
+    let pages = reader.and_then(|r| r.import_pages_with_progress(|fraction| pdf.report_progress(STAGE_MERGE, fraction)));
+    let mut pages = match pages {
+        Ok(pages) => pages,
+        Err(PdfError::Cancelled) => return cancelled(),
+        Err(PdfError::Parser(e @ (ParserError::EncryptedPdf | ParserError::UnsupportedFeature(_)))) => {
+            return fail(PDF_ERR_UNSUPPORTED, format!("Failed to read PDF: {}", e))
+        }
+        Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to read PDF: {}", e)),
+    };
+
+    let renamed = pdf.document.rename_conflicting_fields(&mut pages);
+    if !out_renamed.is_null() {
+        *out_renamed = renamed.len().try_into().unwrap_or(i32::MAX);
+    }
+    let count = pages.len();
+    for mut page in pages {
+        page.add_font(DEFAULT_FONT_NAME, Standard14Font::Helvetica.into());
+        pdf.document.add_page(page);
+    }
+    pdf.data.get_mut().take();
+    if let Err(code) = pdf.write_streamed_pages(1) {
+        return code;
+    }
+    pdf.report_progress(STAGE_MERGE, 1.0);
+    count as i32
+}
+
+/// Turn recovery of damaged files on or off for `pdf_append_pdf*`,
+/// `pdf_add_nup` and `pdf_import_page_as_xobject`. With recovery on, a file
+/// whose cross-reference table cannot be read, or lists offsets where its
+/// objects are not, is read by scanning it for objects instead of being
+/// rejected; `pdf_last_import_recovered` tells whether that happened.
+/// Recovery is off by default.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_recovery_mode(handle: *mut PdfHandle, enabled: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.recovery = enabled != 0;
+    PDF_OK
+}
+
+/// Returns 1 if the file read by the last import call was damaged and
+/// recovered as described under `pdf_set_recovery_mode`, 0 if
+/// it was not, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_last_import_recovered(handle: *mut PdfHandle) -> i32 {
+    match handle_mut(handle) {
+        Ok(pdf) => pdf.import_recovered as i32,
+        Err(code) => code,
+    }
+}
+
+/// Append the pages of a PDF file (`source_data`, `source_len` bytes) tiled
+/// `rows` by `cols` per page, left to right and top to bottom, for proofing
+/// several pages on one sheet. Each new page has the size of the first page
+/// placed on it, and each source page is centered in its cell, shrunk to fit
+/// without changing its proportions, with a small gutter between cells. Form
+/// fields and other annotations are not copied.
+/// Returns the number of pages appended, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `source_data` must point to at least `source_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_nup(
+    handle: *mut PdfHandle,
+    source_data: *const u8,
+    source_len: usize,
+    rows: i32,
+    cols: i32,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if source_data.is_null() || source_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "PDF data is empty");
+    }
+    let (rows, cols) = match (usize::try_from(rows), usize::try_from(cols)) {
+        (Ok(rows), Ok(cols)) if rows > 0 && cols > 0 => (rows, cols),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid grid of {} by {} pages", rows, cols)),
+    };
+
+    #[cfg(feature = "parser")]
+    {
+        use crate::error::ParserError;
+
+        let bytes = std::slice::from_raw_parts(source_data, source_len).to_vec();
+        let reader = pdf.open_import(bytes);
+        let sheets = match reader.and_then(|r| r.import_nup(rows, cols, NUP_GUTTER)) {
+            Ok(sheets) => sheets,
+            Err(PdfError::Parser(e @ (ParserError::EncryptedPdf | ParserError::UnsupportedFeature(_)))) => {
+                return fail(PDF_ERR_UNSUPPORTED, format!("Failed to read PDF: {}", e))
+            }
+            Err(e @ PdfError::Document(_)) => return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string()),
+            Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to read PDF: {}", e)),
+        };
+
+        let count = sheets.len();
+        for mut sheet in sheets {
+            sheet.add_font(DEFAULT_FONT_NAME, Standard14Font::Helvetica.into());
+            pdf.document.add_page(sheet);
+        }
+        pdf.data.get_mut().take();
+        if let Err(code) = pdf.write_streamed_pages(1) {
+            return code;
+        }
+        count.min(i32::MAX as usize) as i32
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, rows, cols);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Copy page `source_page` of a PDF file (`source_data`, `source_len` bytes)
+/// so it can be drawn on any page with `pdf_draw_xobject`, such as a
+/// letterhead. The page and its resources are written once however often
+/// it is drawn.
+/// Returns an xobject id (>= 0), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `source_data` must point to at least `source_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_import_page_as_xobject(
+    handle: *mut PdfHandle,
+    source_data: *const u8,
+    source_len: usize,
+    source_page: i32,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if source_data.is_null() || source_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "PDF data is empty");
+    }
+    let source_page = match usize::try_from(source_page) {
+        Ok(index) => index,
+        Err(_) => return fail(PDF_ERR_PAGE_OUT_OF_RANGE, format!("Page index {} is out of range", source_page)),
+    };
+
+    #[cfg(feature = "parser")]
+    {
+        use crate::error::ParserError;
+
+        let bytes = std::slice::from_raw_parts(source_data, source_len).to_vec();
+        let reader = pdf.open_import(bytes);
+        let form = match reader.and_then(|r| r.import_page(source_page)) {
+            Ok(form) => form,
+            Err(PdfError::Parser(e @ (ParserError::EncryptedPdf | ParserError::UnsupportedFeature(_)))) => {
+                return fail(PDF_ERR_UNSUPPORTED, format!("Failed to read PDF: {}", e))
+            }
+            Err(e @ PdfError::Document(DocumentError::PageOutOfRange { .. })) => {
+                return fail(PDF_ERR_PAGE_OUT_OF_RANGE, e.to_string())
+            }
+            Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to read PDF: {}", e)),
+        };
+        pdf.xobjects.push(form);
+        (pdf.xobjects.len() - 1).min(i32::MAX as usize) as i32
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, source_page);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Draw a page imported with `pdf_import_page_as_xobject` with its lower-left
+/// corner at (`x`, `y`), scaled by `scale` (1.0 for its original size).
+/// Returns 0 on success, or a negative error code on failure (including an
+/// unknown xobject id or a `scale` that is not positive).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_draw_xobject(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    xobject_id: i32,
+    x: f64,
+    y: f64,
+    scale: f64,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(scale > 0.0 && scale.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Scale {} is not positive", scale));
+    }
+
+    #[cfg(feature = "parser")]
+    {
+        let form = match usize::try_from(xobject_id).ok().and_then(|id| pdf.xobjects.get(id)) {
+            Some(form) => form.clone(),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid xobject id {}", xobject_id)),
+        };
+        let page = match pdf.page_mut(page_index) {
+            Ok(page) => page,
+            Err(code) => return code,
+        };
+
+        let name = format!("Stamp{}", xobject_id + 1);
+        let matrix = form.placement(x, y, scale);
+        if !page.imported.iter().any(|(existing, _)| *existing == name) {
+            page.add_imported_page(name.clone(), form);
+        }
+        append_content(page, |c| c.save_state().transform(matrix).paint_xobject(name).restore_state());
+        PDF_OK
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, page_index, xobject_id, x, y);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Open an existing PDF for an incremental update.
+/// The handle starts with one blank page over each page of the input. Text and
+/// drawing added to those pages, and any new pages, are appended after the
+/// original bytes with a new cross-reference section when the document is
+/// written, so the original file, and any signature in it, stays intact.
+/// Encryption, bookmarks, named destinations, attachments and PDF/A cannot be
+/// applied to such a handle; writing it then fails.
+/// Returns null on failure.
+///
+/// # Safety
+/// `data` must point to at least `data_len` readable bytes.
+/// The returned handle must be freed with `pdf_free`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_open_for_update(data: *const u8, data_len: usize) -> *mut PdfHandle {
+    if data.is_null() || data_len == 0 {
+        set_last_error("PDF data is empty");
+        return ptr::null_mut();
+    }
+
+    #[cfg(feature = "parser")]
+    {
+        let bytes = std::slice::from_raw_parts(data, data_len).to_vec();
+        #[cfg_attr(not(feature = "compression"), allow(unused_mut))]
+        let mut document = match Document::open_for_update(bytes) {
+            Ok(document) => document,
+            Err(e) => {
+                set_last_error(format!("Failed to read PDF: {}", e));
+                return ptr::null_mut();
+            }
+        };
+        #[cfg(feature = "compression")]
+        {
+            document.compress_streams = true;
+        }
+        for page in &mut document.pages {
+            page.add_font(DEFAULT_FONT_NAME, Standard14Font::Helvetica.into());
+        }
+        Box::into_raw(Box::new(PdfHandle::new(document)))
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        set_last_error("Built without the \"parser\" feature");
+        ptr::null_mut()
+    }
+}
+
+/// Set the value of a text field, such as one on a page added by `pdf_append_pdf`.
+/// The field's appearance is regenerated from the new value when the document is written.
+/// `field_name` is the fully qualified name, with parent names joined by dots.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `field_name` and `value` must be valid null-terminated C strings.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_fill_form_field(
+    handle: *mut PdfHandle,
+    field_name: *const c_char,
+    value: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let (name, value) = match (str_arg(field_name), str_arg(value)) {
+        (Some(name), Some(value)) => (name, value),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Field name or value is null or not valid UTF-8"),
+    };
+
+    let field = match pdf.document.form_field_mut(name) {
+        Some(field) => field,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("No form field named {}", name)),
+    };
+    if field.field_type != FormFieldType::Text {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Form field {} is not a text field", name));
+    }
+    if let Some(max_length) = field.max_length {
+        if value.chars().count() > max_length as usize {
+            return fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("Value is longer than the {} characters form field {} allows", max_length, name),
+            );
+        }
+    }
+
+    field.value = Some(value.to_string());
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Draw the values of all text fields and checkboxes into the page content and
+/// remove the fields, so the values can no longer be edited.
+/// Returns the number of fields flattened, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_flatten_form(handle: *mut PdfHandle) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    let count = pdf.document.flatten_form();
+    pdf.data.get_mut().take();
+    count as i32
+}
+
+/// Add a fillable text field to a page, with its lower-left corner at (x, y).
+/// The field is listed in the document's AcroForm and starts empty.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_field(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    name: *const c_char,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let name = match field_name(&mut pdf, name) {
+        Ok(name) => name,
+        Err(code) => return code,
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Field width and height must be positive");
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_form_field(TextField::new(name).rect(x, y, width, height).to_form_field());
+    PDF_OK
+}
+
+/// Add a square checkbox to a page, with its lower-left corner at (x, y).
+/// The box is listed in the document's AcroForm and starts unchecked.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_checkbox(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    name: *const c_char,
+    x: f64,
+    y: f64,
+    size: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let name = match field_name(&mut pdf, name) {
+        Ok(name) => name,
+        Err(code) => return code,
+    };
+    if !(size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Checkbox size must be positive");
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_form_field(CheckBox::new(name).rect(x, y, size, size).to_form_field());
+    PDF_OK
+}
+
+/// Embed a file in the document, listed in its /EmbeddedFiles name tree.
+/// `mime_type` and `description` may be NULL. `relationship` is one of the
+/// `PDF_AF_*` constants; anything but `PDF_AF_NONE` also lists the file as an
+/// associated file of the document, as ZUGFeRD and Factur-X invoices require.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `filename` must be a valid null-terminated C string, as must `mime_type` and
+/// `description` unless NULL. `data` must point to at least `data_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_attach_file(
+    handle: *mut PdfHandle,
+    filename: *const c_char,
+    mime_type: *const c_char,
+    data: *const u8,
+    data_len: usize,
+    description: *const c_char,
+    relationship: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let filename = match str_arg(filename) {
+        Some(name) => name,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "File name is null or not valid UTF-8"),
+    };
+    if data.is_null() && data_len > 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "File data is null");
+    }
+    let relationship = match relationship {
+        PDF_AF_NONE => None,
+        PDF_AF_SOURCE => Some(AfRelationship::Source),
+        PDF_AF_DATA => Some(AfRelationship::Data),
+        PDF_AF_ALTERNATIVE => Some(AfRelationship::Alternative),
+        PDF_AF_SUPPLEMENT => Some(AfRelationship::Supplement),
+        PDF_AF_UNSPECIFIED => Some(AfRelationship::Unspecified),
+        other => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown file relationship {}", other)),
+    };
+
+    let bytes = if data_len == 0 {
+        Vec::new()
+    } else {
+        std::slice::from_raw_parts(data, data_len).to_vec()
+    };
+    let mut attachment = FileAttachment::new(filename, bytes);
+    attachment.relationship = relationship;
+    for (field, value) in [(&mut attachment.mime_type, mime_type), (&mut attachment.description, description)] {
+        if value.is_null() {
+            continue;
+        }
+        match str_arg(value) {
+            Some(value) => *field = Some(value.to_string()),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "MIME type or description is not valid UTF-8"),
+        }
+    }
+
+    if let Err(e) = pdf.document.attach_file(attachment) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string());
+    }
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Set the orientation of pages added after this call.
+/// `orientation` is 0 for portrait or 1 for landscape; existing pages are unchanged.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_orientation(handle: *mut PdfHandle, orientation: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    match orientation {
+        PDF_ORIENTATION_PORTRAIT => pdf.landscape = false,
+        PDF_ORIENTATION_LANDSCAPE => pdf.landscape = true,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown orientation {}", orientation)),
+    }
+    PDF_OK
+}
+
+/// Set the orientation of an existing page, swapping its width and height if needed.
+/// A page that already has the requested orientation is left unchanged.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_page_orientation(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    orientation: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    match orient(page.media_box, orientation) {
+        Some(media_box) => {
+            page.media_box = media_box;
+            PDF_OK
+        }
+        None => fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown orientation {}", orientation)),
+    }
+}
+
+/// Set the clockwise rotation, in degrees, with which viewers display a page
+/// (its /Rotate entry). `degrees` must be a multiple of 90; negative values turn
+/// the page counterclockwise. Coordinates for drawing on the page are unchanged.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_page_rotation(handle: *mut PdfHandle, page_index: i32, degrees: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if degrees % 90 != 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Rotation {} is not a multiple of 90 degrees", degrees));
+    }
+    match pdf.page_mut(page_index) {
+        Ok(page) => {
+            page.rotation = degrees.rem_euclid(360);
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set a print boundary of a page: `box_type` is one of the `PDF_BOX_*`
+/// constants, and the box has its lower-left corner at (`x`, `y`). A width
+/// and height of 0 remove the box. The box must lie within the page's media
+/// box; changing the page size afterwards so that it no longer does makes
+/// serialization fail.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_page_box(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    box_type: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let kind = match box_type {
+        PDF_BOX_CROP => PageBox::Crop,
+        PDF_BOX_BLEED => PageBox::Bleed,
+        PDF_BOX_TRIM => PageBox::Trim,
+        PDF_BOX_ART => PageBox::Art,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown page box type {}", box_type)),
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+    if width == 0.0 && height == 0.0 {
+        page.set_page_box(kind, None);
+        return PDF_OK;
+    }
+    let rect = Rectangle::new(x, y, x + width, y + height);
+    if !(width > 0.0 && height > 0.0) || !page.media_box.contains(&rect) {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            format!(
+                "The {} must have a positive size and lie within the page's MediaBox [{} {} {} {}]",
+                kind.key(),
+                page.media_box.llx,
+                page.media_box.lly,
+                page.media_box.urx,
+                page.media_box.ury
+            ),
+        );
+    }
+    page.set_page_box(kind, Some(rect));
+    PDF_OK
+}
+
+/// Fill the whole media box of a page with an RGB color (components 0-1)
+/// beneath all of its content, whether that is drawn before or after this
+/// call. Imported and appended pages keep their content over the background.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_page_background(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    r: f64,
+    g: f64,
+    b: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    match pdf.page_mut(page_index) {
+        Ok(page) => {
+            page.background = Some(Color::Rgb(RgbColor::clamped(r, g, b)));
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Remove the background set by `pdf_set_page_background` from a page.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_clear_page_background(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    match pdf.page_mut(page_index) {
+        Ok(page) => {
+            page.background = None;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set the PDF version written in the file header, such as 1.4 for archival
+/// systems that reject newer files. Only real versions (1.0 to 1.7 and 2.0) are
+/// accepted. Features the version lacks, such as transparency before 1.4 or
+/// AES-256 encryption before 1.7, make serialization fail instead.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_version(handle: *mut PdfHandle, major: i32, minor: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let version = match (u8::try_from(major), u8::try_from(minor)) {
+        (Ok(major), Ok(minor)) => PdfVersion::from_numbers(major, minor),
+        _ => None,
+    };
+    let version = match version {
+        Some(version) => version,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown PDF version {}.{}", major, minor)),
+    };
+
+    pdf.document.version = version;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Set how viewers show the document when it opens: `zoom_mode` is one of the
+/// `PDF_ZOOM_*` constants or a percentage such as 150, and `layout_mode` one of
+/// the `PDF_LAYOUT_*` constants. The zoom applies to the first page. Documents
+/// with bookmarks keep showing the bookmark panel; others open with no panel.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_open_action(handle: *mut PdfHandle, zoom_mode: i32, layout_mode: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let zoom = match zoom_mode {
+        PDF_ZOOM_DEFAULT => None,
+        PDF_ZOOM_FIT_PAGE => Some(Zoom::FitPage),
+        PDF_ZOOM_FIT_WIDTH => Some(Zoom::FitWidth),
+        percent if percent > 0 => Some(Zoom::Percent(percent as f64)),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown zoom mode {}", zoom_mode)),
+    };
+    let layout = match layout_mode {
+        PDF_LAYOUT_DEFAULT => None,
+        PDF_LAYOUT_SINGLE_PAGE => Some(PageLayout::SinglePage),
+        PDF_LAYOUT_ONE_COLUMN => Some(PageLayout::OneColumn),
+        PDF_LAYOUT_TWO_COLUMN_LEFT => Some(PageLayout::TwoColumnLeft),
+        PDF_LAYOUT_TWO_COLUMN_RIGHT => Some(PageLayout::TwoColumnRight),
+        PDF_LAYOUT_TWO_PAGE_LEFT => Some(PageLayout::TwoPageLeft),
+        PDF_LAYOUT_TWO_PAGE_RIGHT => Some(PageLayout::TwoPageRight),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown layout mode {}", layout_mode)),
+    };
+    pdf.document.initial_view.zoom = zoom;
+    pdf.document.initial_view.layout = layout;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Set the natural language of the document's text (/Lang), a BCP 47 tag such
+/// as "en-US" or "ar-EG", which screen readers use to pick a voice and text
+/// to speech rules. A null or empty `lang_tag` removes the language.
+/// Returns 0 on success, or PDF_ERR_INVALID_ARGUMENT if the tag is not a
+/// well-formed language tag.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `lang_tag` must be null or a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_document_language(handle: *mut PdfHandle, lang_tag: *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let language = if lang_tag.is_null() {
+        None
+    } else {
+        match str_arg(lang_tag) {
+            Some("") => None,
+            Some(tag) if is_language_tag(tag) => Some(tag.to_string()),
+            Some(tag) => {
+                let error = DocumentError::InvalidLanguage(tag.to_string());
+                return fail(PDF_ERR_INVALID_ARGUMENT, error.to_string());
+            }
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "Language tag is not valid UTF-8"),
+        }
+    };
+    pdf.document.language = language;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Begin a structure element of type `tag_type`, such as "H1", "P" or "Figure",
+/// inside the innermost tag still open. Content drawn on the page until the
+/// matching `pdf_end_tag` belongs to it, and the document is written as tagged
+/// PDF with a structure tree. Grouping types such as "Sect", "L", "Table" and
+/// "TR" hold no content of their own, only the tags begun inside them.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` for an unknown type).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `tag_type` must be a valid null-terminated string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_begin_tag(handle: *mut PdfHandle, page_index: i32, tag_type: *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let structure_type = match str_arg(tag_type) {
+        Some(name) => match StructureType::from_name(name) {
+            Some(structure_type) => structure_type,
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown tag type {}", name)),
+        },
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Tag type is null or not valid UTF-8"),
+    };
+    if let Err(code) = pdf.page_mut(page_index) {
+        return code;
+    }
+    if let Err(code) = pdf.check_no_layer_in_tag() {
+        return code;
+    }
+
+    // Marked-content sequences cannot nest, so the parent's pauses here
+    let parent = pdf.open_tags.len().checked_sub(1);
+    if let Some(parent) = parent {
+        if let Err(code) = pdf.close_sequence(parent) {
+            return code;
+        }
+    }
+    let parent_element = parent.map(|parent| pdf.open_tags[parent].element);
+    let element = match pdf.document.add_structure_element(StructureElement::new(structure_type), parent_element) {
+        Ok(element) => element,
+        Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string()),
+    };
+    pdf.open_tags.push(OpenTag { element, page: None });
+    pdf.last_tag = Some(element);
+    let tag = pdf.open_tags.len() - 1;
+    match pdf.open_sequence(tag, page_index as usize) {
+        Ok(()) => PDF_OK,
+        Err(code) => code,
+    }
+}
+
+/// End the innermost tag begun with `pdf_begin_tag`. Content drawn on the
+/// page afterwards belongs to the tag it was begun inside, if any.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` when no tag is open, or when the tag's content
+/// is on another page).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_end_tag(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if let Err(code) = pdf.page_mut(page_index) {
+        return code;
+    }
+    let tag = match pdf.open_tags.last() {
+        Some(tag) => tag.clone(),
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "No tag is open"),
+    };
+    if let Some(page) = tag.page.filter(|&page| page != page_index as usize) {
+        let name = pdf.document.structure[tag.element].structure_type.name();
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("The open {} tag is on page {}", name, page));
+    }
+    if let Err(code) = pdf.check_no_layer_in_tag() {
+        return code;
+    }
+
+    let index = pdf.open_tags.len() - 1;
+    if let Err(code) = pdf.close_sequence(index) {
+        return code;
+    }
+    pdf.open_tags.pop();
+    pdf.last_tag = Some(tag.element);
+    match index.checked_sub(1).map(|parent| pdf.open_sequence(parent, page_index as usize)) {
+        Some(Err(code)) => code,
+        _ => PDF_OK,
+    }
+}
+
+/// Set the text read in place of the content of the tag begun or ended last
+/// with `pdf_begin_tag` or `pdf_end_tag`, such as a description of the image
+/// in a "Figure" tag.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` when no tag has been begun).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_alt_text(handle: *mut PdfHandle, text: *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(text) => text,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Alternate text is null or not valid UTF-8"),
+    };
+    let element = match pdf.last_tag {
+        Some(element) => element,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "No tag has been begun"),
+    };
+    pdf.document.structure[element].alt_text = Some(text.to_string());
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Add a layer named `name` that content can be drawn in with
+/// `pdf_begin_layer`. Viewers list layers in their layers panel in the order
+/// added, and readers can hide or show each one. New layers are shown when
+/// the document opens.
+/// Returns the layer id (>= 0), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `name` must be a valid null-terminated string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_layer(handle: *mut PdfHandle, name: *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let name = match str_arg(name) {
+        Some(name) if !name.is_empty() => name,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Layer name is null, empty or not valid UTF-8"),
+    };
+    let id = pdf.document.add_layer(Layer::new(name));
+    pdf.data.get_mut().take();
+    id.min(i32::MAX as usize) as i32
+}
+
+/// Set whether the layer `layer_id` is shown (nonzero) or hidden (0) when
+/// the document opens.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_layer_visible(handle: *mut PdfHandle, layer_id: i32, visible: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let layer = match usize::try_from(layer_id).ok().and_then(|id| pdf.document.layers.get_mut(id)) {
+        Some(layer) => layer,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid layer id {}", layer_id)),
+    };
+    layer.visible = visible != 0;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Begin content on a page that belongs to the layer `layer_id`, until the
+/// matching `pdf_end_layer`. Layers begun on a page nest, and the page's open
+/// layers end when the document is written. Layers and tags must nest too:
+/// while a layer begun inside a tag is open, no tag can begin or end.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_begin_layer(handle: *mut PdfHandle, page_index: i32, layer_id: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let layer = match usize::try_from(layer_id).ok().filter(|&id| id < pdf.document.layers.len()) {
+        Some(layer) => layer,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid layer id {}", layer_id)),
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| c.begin_optional_content(Layer::resource_name(layer)));
+    let tags = pdf.open_tags.len();
+    pdf.open_layers.entry(page_index as usize).or_default().push(OpenLayer { layer, tags });
+    PDF_OK
+}
+
+/// End the innermost layer begun on a page with `pdf_begin_layer`.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` when no layer is open on the page, or when a
+/// tag begun inside the layer is still open).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_end_layer(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if let Err(code) = pdf.page_mut(page_index) {
+        return code;
+    }
+    let open = match pdf.open_layers.get(&(page_index as usize)).and_then(|layers| layers.last()) {
+        Some(&open) => open,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("No layer is open on page {}", page_index)),
+    };
+    if pdf.open_tags.len() > open.tags {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            format!("A tag begun inside layer {} is still open", open.layer),
+        );
+    }
+
+    if let Some(layers) = pdf.open_layers.get_mut(&(page_index as usize)) {
+        layers.pop();
+    }
+    match pdf.page_mut(page_index) {
+        Ok(page) => {
+            append_content(page, |c| c.end_marked_content());
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Make the document conform to PDF/A-1b or PDF/A-2b (`PDF_PDFA_*`), or turn
+/// conformance off with `PDF_PDFA_NONE`. Conforming output embeds an sRGB output
+/// intent, an XMP metadata packet and a file identifier, and serialization fails
+/// while `pdf_validate_pdfa` reports violations.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_pdfa_mode(handle: *mut PdfHandle, level: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.document.pdfa = match level {
+        PDF_PDFA_NONE => None,
+        PDF_PDFA_1B => Some(PdfAConformance::A1b),
+        PDF_PDFA_2B => Some(PdfAConformance::A2b),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown PDF/A level {}", level)),
+    };
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Check the document against the level set with `pdf_set_pdfa_mode`.
+/// Returns the number of violations, such as text in a standard font that
+/// PDF/A cannot embed, or a negative error code on failure (including PDF/A
+/// mode being off).
+/// If `out_report` is not null it receives the violations, one per line. The
+/// string is owned by the handle and stays valid until the next call to this
+/// function or `pdf_free`.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_report` must be null or a valid pointer to a `*const c_char`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_validate_pdfa(handle: *mut PdfHandle, out_report: *mut *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if pdf.document.pdfa.is_none() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "PDF/A mode is not set");
+    }
+
+    let violations = pdf.document.pdfa_violations();
+    let mut report = violations.join("\n");
+    report.retain(|c| c != '\0');
+    pdf.pdfa_report = CString::new(report).unwrap_or_default();
+    if !out_report.is_null() {
+        *out_report = pdf.pdfa_report.as_ptr();
+    }
+    i32::try_from(violations.len()).unwrap_or(i32::MAX)
+}
+
+/// Check the resources the document depends on before it is sent to print.
+/// Returns the number of problems found, such as fonts of appended PDFs that
+/// are not embedded, images compressed with the JPEG 2000 or CCITT fax
+/// filters, and objects an appended PDF refers to but does not contain, or
+/// a negative error code on failure.
+/// If `out_report` is not null it receives the problems, one per line. The
+/// string is owned by the handle and stays valid until the next call to this
+/// function or `pdf_free`.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_report` must be null or a valid pointer to a `*const c_char`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_audit_resources(handle: *mut PdfHandle, out_report: *mut *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    let issues = pdf.document.audit_resources();
+    let mut report = issues.join("\n");
+    report.retain(|c| c != '\0');
+    pdf.audit_report = CString::new(report).unwrap_or_default();
+    if !out_report.is_null() {
+        *out_report = pdf.audit_report.as_ptr();
+    }
+    i32::try_from(issues.len()).unwrap_or(i32::MAX)
+}
+
+/// Turn Flate compression of content, font and attachment streams on
+/// or off. `level` runs from 0 (fastest) to 9 (smallest) and is ignored when
+/// `enabled` is 0. New documents are compressed at level 6 when the library is
+/// built with the `compression` feature; turning it off makes the output easier
+/// to read while debugging.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_compression(handle: *mut PdfHandle, enabled: i32, level: i32) -> i32 {
+    #[cfg_attr(not(feature = "compression"), allow(unused_variables, unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let level = match u32::try_from(level) {
+        _ if enabled == 0 => None,
+        Ok(level) if level <= 9 => Some(level),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Compression level {} is not between 0 and 9", level)),
+    };
+
+    #[cfg(feature = "compression")]
+    {
+        pdf.document.compress_streams = level.is_some();
+        if let Some(level) = level {
+            pdf.document.compression_level = level;
+        }
+        pdf.data.get_mut().take();
+        PDF_OK
+    }
+    #[cfg(not(feature = "compression"))]
+    match level {
+        Some(_) => fail(PDF_ERR_UNSUPPORTED, "Built without the \"compression\" feature"),
+        None => PDF_OK,
+    }
+}
+
+/// Write streams shorter than `bytes` uncompressed when compression is on.
+/// Flate shrinks a stream of a few hundred bytes by little, but each one costs
+/// a compressor setup, so skipping them speeds up documents made of many
+/// small pages. The default of 0 compresses every stream.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_compress_min_size(handle: *mut PdfHandle, bytes: usize) -> i32 {
+    #[cfg_attr(not(feature = "compression"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    #[cfg(feature = "compression")]
+    {
+        pdf.document.compress_min_size = bytes;
+        pdf.data.get_mut().take();
+    }
+    #[cfg(not(feature = "compression"))]
+    let _ = (pdf, bytes);
+    PDF_OK
+}
+
+/// Round coordinates and other numbers in page content streams to `decimals`
+/// places, from 0 to 10, with trailing zeros dropped, so 72.00000000001 is
+/// written as 72. The default of 4 keeps sub-point positions exact; 2 is
+/// ample for drawings and makes their content streams noticeably smaller.
+/// Color components and the scaling and rotation terms of matrices keep at
+/// least 4 places.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_coordinate_precision(handle: *mut PdfHandle, decimals: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(0..=10).contains(&decimals) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Precision must be 0 to 10 decimal places, got {}", decimals));
+    }
+    pdf.document.coordinate_precision = decimals as usize;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Pack objects into compressed object streams and write a cross-reference
+/// stream instead of the classic xref table, which makes documents with many
+/// pages, fonts or annotations noticeably smaller. Only PDF 1.5 and later allow
+/// this, so it has no effect with an older `pdf_set_version` or PDF/A-1b.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_use_object_streams(handle: *mut PdfHandle, enabled: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.document.object_streams = enabled != 0;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Lay the file out for fast web view ("linearized"): the objects of the
+/// first page come first, with hint tables telling the viewer where every other
+/// page starts, so it can show the first page before the rest has downloaded.
+/// This adds a few hundred bytes to the file, and turns off object streams.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_linearized(handle: *mut PdfHandle, enabled: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.document.linearized = enabled != 0;
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Write identical streams, fonts and graphics states only once from now on,
+/// with every reference pointing at the copy kept. An image or font used on
+/// every page then takes the space of one. Objects are compared byte for byte
+/// once their dictionary keys are sorted; pages, annotations and form fields
+/// always keep their own objects.
+/// The document is serialized with and without merging to measure the
+/// difference, so the progress callback sees two serializations; neither is
+/// signed, so the measurement does not sign the document. On failure merging
+/// is left as it was.
+/// Returns the number of bytes saved (0 if nothing was merged), or a negative
+/// error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_optimize(handle: *mut PdfHandle) -> i64 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code as i64,
+    };
+    let previous = pdf.document.deduplicate;
+    pdf.document.deduplicate = false;
+    let before = match pdf.serialize_until(|| false) {
+        Ok(data) => data.len(),
+        Err(code) => {
+            pdf.document.deduplicate = previous;
+            return code as i64;
+        }
+    };
+    pdf.document.deduplicate = true;
+    match pdf.serialize_until(|| false) {
+        Ok(after) => {
+            pdf.data.get_mut().take();
+            before.saturating_sub(after.len()) as i64
+        }
+        Err(code) => {
+            pdf.document.deduplicate = previous;
+            code as i64
+        }
+    }
+}
+
+/// Set the margins, in points, around the content area of every page.
+/// Flowing text and text boxes drawn with a zero width and height fill the content
+/// area, and page numbers are aligned to it with their baseline halfway up the
+/// bottom margin.
+/// Returns 0 on success, or a negative error code on failure (including a
+/// negative margin).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_margins(
+    handle: *mut PdfHandle,
+    top: f64,
+    right: f64,
+    bottom: f64,
+    left: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if ![top, right, bottom, left].iter().all(|m| *m >= 0.0 && m.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Margins must not be negative");
+    }
+
+    pdf.document.margins = Some(Margins::new(top, right, bottom, left));
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Get the area of a page inside the margins set with `pdf_set_margins`, or
+/// inside one-inch margins if none were set. The lower-left corner is written to
+/// `out_x` and `out_y` and the size to `out_w` and `out_h`, all in points.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// The output pointers must be valid for writing a `double`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_get_content_rect(
+    handle: *const PdfHandle,
+    page_index: i32,
+    out_x: *mut f64,
+    out_y: *mut f64,
+    out_w: *mut f64,
+    out_h: *mut f64,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if out_x.is_null() || out_y.is_null() || out_w.is_null() || out_h.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    let rect = match pdf.content_rect(page_index) {
+        Ok(rect) => rect,
+        Err(code) => return code,
+    };
+
+    *out_x = rect.llx;
+    *out_y = rect.lly;
+    *out_w = rect.width();
+    *out_h = rect.height();
+    PDF_OK
+}
+
+/// Set the document's Info dictionary entries.
+/// Any field passed as null is left unchanged. Non-ASCII text is stored as UTF-16BE.
+/// May be called before or after pages are added.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// Each non-null string must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_metadata(
+    handle: *mut PdfHandle,
+    title: *const c_char,
+    author: *const c_char,
+    subject: *const c_char,
+    keywords: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    let mut fields: [Option<&str>; 4] = [None; 4];
+    for (field, value) in fields.iter_mut().zip([title, author, subject, keywords]) {
+        if value.is_null() {
+            continue;
+        }
+        match str_arg(value) {
+            Some(value) => *field = Some(value),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "Metadata is not valid UTF-8"),
+        }
+    }
+
+    let info = &mut pdf.document.info;
+    let targets = [&mut info.title, &mut info.author, &mut info.subject, &mut info.keywords];
+    for (target, value) in targets.into_iter().zip(fields) {
+        if let Some(value) = value {
+            *target = Some(value.to_string());
+        }
+    }
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Embed a raw XMP metadata packet in the document catalog, replacing any set
+/// before. If the XML is not already an `xpacket`, it is wrapped in the packet
+/// processing instructions with padding so tools can rewrite it in place.
+/// A null `xmp_xml` with `xmp_len` 0 removes the metadata.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `xmp_xml` must be null or point to at least `xmp_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_xmp_metadata(handle: *mut PdfHandle, xmp_xml: *const u8, xmp_len: usize) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if xmp_xml.is_null() {
+        if xmp_len != 0 {
+            return fail(PDF_ERR_INVALID_ARGUMENT, "XMP data is null");
+        }
+        pdf.document.xmp = None;
+        pdf.data.get_mut().take();
+        return PDF_OK;
+    }
+
+    let xml = std::slice::from_raw_parts(xmp_xml, xmp_len);
+    if std::str::from_utf8(xml).is_err() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "XMP metadata is not valid UTF-8");
+    }
+    pdf.document.xmp = Some(XmpMetadata::Raw(xml.to_vec()));
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Set the document information and embed an XMP packet generated from it,
+/// using Dublin Core for the title, author, subject and keywords. The packet
+/// stays in step with later `pdf_set_metadata` calls. Any field passed as null
+/// is left unchanged; `creator_tool` names the application that created the
+/// document.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// Each non-null string must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_xmp_fields(
+    handle: *mut PdfHandle,
+    title: *const c_char,
+    author: *const c_char,
+    subject: *const c_char,
+    keywords: *const c_char,
+    creator_tool: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    let mut fields: [Option<&str>; 5] = [None; 5];
+    for (field, value) in fields.iter_mut().zip([title, author, subject, keywords, creator_tool]) {
+        if value.is_null() {
+            continue;
+        }
+        match str_arg(value) {
+            Some(value) => *field = Some(value),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "Metadata is not valid UTF-8"),
+        }
+    }
+
+    let info = &mut pdf.document.info;
+    let targets = [&mut info.title, &mut info.author, &mut info.subject, &mut info.keywords, &mut info.creator];
+    for (target, value) in targets.into_iter().zip(fields) {
+        if let Some(value) = value {
+            *target = Some(value.to_string());
+        }
+    }
+    pdf.document.xmp = Some(XmpMetadata::FromInfo);
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Make the output reproducible, so that writing the same document twice
+/// gives identical bytes. `fixed_id`, a string of hex digits, is written as
+/// the trailer's /ID instead of an identifier generated on every write, and
+/// `fixed_date`, a PDF date such as `D:20240131120000Z`, as the creation
+/// date. A null `fixed_date` leaves the creation date unchanged.
+/// AES-256 encryption still uses random salts and initialization vectors,
+/// and signing adds the current time, so neither output is reproducible.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `fixed_id` and any non-null `fixed_date` must be valid null-terminated C strings.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_deterministic(
+    handle: *mut PdfHandle,
+    fixed_date: *const c_char,
+    fixed_id: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let id = match str_arg(fixed_id).and_then(parse_hex) {
+        Some(id) if !id.is_empty() => id,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "File identifier must be a non-empty string of hex digits"),
+    };
+    let date = if fixed_date.is_null() {
+        None
+    } else {
+        match str_arg(fixed_date).filter(|date| crate::document::xmp_date(date).is_some()) {
+            Some(date) => Some(date.to_string()),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "Creation date is not a valid PDF date"),
+        }
+    };
+
+    pdf.document.file_id = Some(id);
+    if date.is_some() {
+        pdf.document.info.creation_date = date;
+    }
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Decodes a string of hex digit pairs.
+fn parse_hex(hex: &str) -> Option<Vec<u8>> {
+    if hex.len() % 2 != 0 || !hex.bytes().all(|b| b.is_ascii_hexdigit()) {
+        return None;
+    }
+    (0..hex.len()).step_by(2).map(|i| u8::from_str_radix(&hex[i..i + 2], 16).ok()).collect()
+}
+
+/// Encrypt the document with a password.
+/// The user password is needed to open the document; the owner password grants
+/// full access regardless of `permissions`, a bitmask of `PDF_PERMISSION_*` flags.
+/// A null `user_password` lets anyone open the document with the restrictions
+/// applied; a null `owner_password` reuses the user password. `algorithm` is
+/// `PDF_ENCRYPTION_RC4_128` or `PDF_ENCRYPTION_AES_256`. Encryption is applied
+/// when the document is serialized. A document signed with `pdf_sign` cannot
+/// be encrypted and fails with `PDF_ERR_UNSUPPORTED`.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// Each non-null password must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_encryption(
+    handle: *mut PdfHandle,
+    user_password: *const c_char,
+    owner_password: *const c_char,
+    permissions: i32,
+    algorithm: i32,
+) -> i32 {
+    #[cfg_attr(not(feature = "encryption"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if user_password.is_null() && owner_password.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "A user or owner password is required");
+    }
+    let mut passwords = [""; 2];
+    for (password, value) in passwords.iter_mut().zip([user_password, owner_password]) {
+        if value.is_null() {
+            continue;
+        }
+        match str_arg(value) {
+            Some(value) => *password = value,
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, "Password is not valid UTF-8"),
+        }
+    }
+    let [user, owner] = passwords;
+    let owner = if owner_password.is_null() { user } else { owner };
+
+    #[cfg(feature = "encryption")]
+    {
+        use crate::encryption::{EncryptionConfig, Permissions};
+
+        // The signature is made over the unencrypted file
+        #[cfg(feature = "signatures")]
+        if pdf.signature.is_some() {
+            return fail(PDF_ERR_UNSUPPORTED, "Signed documents cannot be encrypted");
+        }
+        let config = match algorithm {
+            PDF_ENCRYPTION_RC4_128 => EncryptionConfig::rc4(),
+            PDF_ENCRYPTION_AES_256 => EncryptionConfig::aes256(),
+            _ => {
+                return fail(
+                    PDF_ERR_INVALID_ARGUMENT,
+                    format!("Unknown encryption algorithm {}", algorithm),
+                )
+            }
+        };
+        pdf.document.encryption = Some(
+            config
+                .user_password(user)
+                .owner_password(owner)
+                .permissions(Permissions::from_bits(permissions)),
+        );
+        pdf.data.get_mut().take();
+        PDF_OK
+    }
+
+    #[cfg(not(feature = "encryption"))]
+    {
+        let _ = (pdf, user, owner, permissions, algorithm);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"encryption\" feature")
+    }
+}
+
+/// Sign the document with the certificate and private key in a PKCS#12
+/// (.p12 or .pfx) file, protected by `password`.
+/// The signature is an invisible field on the first page holding a detached
+/// PKCS#7 signature over the whole file, and is added when the document is
+/// serialized. `reason` and `location` are optional and may be null. Files
+/// must use AES with SHA-2, as OpenSSL 3 writes by default; older RC2 and
+/// 3DES files fail with `PDF_ERR_UNSUPPORTED`.
+/// Returns 0 on success, or a negative error code on failure. Without the
+/// "signatures" feature this is `PDF_ERR_UNSUPPORTED`.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `pkcs12_data` must point to `pkcs12_len` readable bytes. `password` and
+/// each non-null string must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_sign(
+    handle: *mut PdfHandle,
+    pkcs12_data: *const u8,
+    pkcs12_len: usize,
+    password: *const c_char,
+    reason: *const c_char,
+    location: *const c_char,
+) -> i32 {
+    #[cfg_attr(not(feature = "signatures"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    #[cfg(feature = "signatures")]
+    {
+        if pdf.document.encryption.is_some() {
+            return fail(PDF_ERR_UNSUPPORTED, "Encrypted documents cannot be signed");
+        }
+        match signing_identity(pkcs12_data, pkcs12_len, password, reason, location) {
+            Ok(signature) => {
+                pdf.signature = Some(signature);
+                pdf.data.get_mut().take();
+                PDF_OK
+            }
+            Err(code) => code,
+        }
+    }
+
+    #[cfg(not(feature = "signatures"))]
+    {
+        let _ = (pdf, pkcs12_data, pkcs12_len, password, reason, location);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"signatures\" feature")
+    }
+}
+
+/// Sign a finished PDF, such as one read from a file, with the identity in
+/// a PKCS#12 file, as `pdf_sign` does for a handle.
+/// The signature is appended as an incremental update, so the original bytes
+/// and any signatures they hold stay valid. The signed PDF is written to
+/// `out_data` and its length to `out_len`, and must be released with
+/// `pdf_free_buffer`.
+/// Returns 0 on success, or a negative error code on failure, leaving
+/// `out_data` null and `out_len` 0. Without the "signatures" feature this is
+/// `PDF_ERR_UNSUPPORTED`.
+///
+/// # Safety
+/// `data` must point to `len` readable bytes and `pkcs12_data` to
+/// `pkcs12_len`. `password` and each non-null string must be a valid
+/// null-terminated C string. `out_data` must be a valid pointer to a
+/// `*mut u8` and `out_len` a valid pointer to a `usize`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_sign_pdf(
+    data: *const u8,
+    len: usize,
+    pkcs12_data: *const u8,
+    pkcs12_len: usize,
+    password: *const c_char,
+    reason: *const c_char,
+    location: *const c_char,
+    out_data: *mut *mut u8,
+    out_len: *mut usize,
+) -> i32 {
+    if out_data.is_null() || out_len.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    *out_data = ptr::null_mut();
+    *out_len = 0;
+    if data.is_null() || len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "PDF data is null or empty");
+    }
+
+    #[cfg(feature = "signatures")]
+    {
+        let (identity, config) = match signing_identity(pkcs12_data, pkcs12_len, password, reason, location) {
+            Ok(signature) => signature,
+            Err(code) => return code,
+        };
+        let pdf = std::slice::from_raw_parts(data, len).to_vec();
+        match DocumentSigner::from_bytes(pdf).config(config).pkcs12(identity).sign() {
+            Ok(signed) => {
+                give_buffer(&signed, out_data, out_len);
+                PDF_OK
+            }
+            Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to sign document: {}", e)),
+        }
+    }
+
+    #[cfg(not(feature = "signatures"))]
+    {
+        let _ = (pkcs12_data, pkcs12_len, password, reason, location);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"signatures\" feature")
+    }
+}
+
+/// Reads the identity and signature settings passed to `pdf_sign` and
+/// `pdf_sign_pdf`. A wrong password fails with `PDF_ERR_INVALID_ARGUMENT`,
+/// a file using algorithms that are not supported with `PDF_ERR_UNSUPPORTED`.
+#[cfg(feature = "signatures")]
+unsafe fn signing_identity(
+    pkcs12_data: *const u8,
+    pkcs12_len: usize,
+    password: *const c_char,
+    reason: *const c_char,
+    location: *const c_char,
+) -> Result<(Pkcs12, SignatureConfig), i32> {
+    use crate::error::SignatureError;
+
+    if pkcs12_data.is_null() || pkcs12_len == 0 {
+        return Err(fail(PDF_ERR_INVALID_ARGUMENT, "PKCS#12 data is null or empty"));
+    }
+    let password = match str_arg(password) {
+        Some(password) => password,
+        None => return Err(fail(PDF_ERR_INVALID_ARGUMENT, "Password is null or not valid UTF-8")),
+    };
+    let optional = |value: *const c_char, what: &str| match str_arg(value) {
+        None if !value.is_null() => Err(fail(PDF_ERR_INVALID_ARGUMENT, format!("{} is not valid UTF-8", what))),
+        value => Ok(value),
+    };
+    let mut config = SignatureConfig::new();
+    if let Some(reason) = optional(reason, "Reason")? {
+        config = config.reason(reason);
+    }
+    if let Some(location) = optional(location, "Location")? {
+        config = config.location(location);
+    }
+
+    let data = std::slice::from_raw_parts(pkcs12_data, pkcs12_len);
+    match Pkcs12::from_der(data, password) {
+        Ok(identity) => Ok((identity, config)),
+        Err(e @ SignatureError::UnsupportedAlgorithm(_)) => Err(fail(PDF_ERR_UNSUPPORTED, e.to_string())),
+        Err(e) => Err(fail(PDF_ERR_INVALID_ARGUMENT, e.to_string())),
+    }
+}
+
+/// Set the fill color used by subsequent text and filled shapes.
+/// Components range from 0.0 to 1.0; values outside that range are clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_color(handle: *mut PdfHandle, r: f64, g: f64, b: f64) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.fill_color = Color::Rgb(RgbColor::clamped(r, g, b));
+            pdf.style.fill_pattern = None;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set the stroke color used by subsequent lines and shape outlines.
+/// Components range from 0.0 to 1.0; values outside that range are clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_stroke_color(handle: *mut PdfHandle, r: f64, g: f64, b: f64) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.stroke_color = Color::Rgb(RgbColor::clamped(r, g, b));
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set a CMYK fill color used by subsequent text and filled shapes, written with the
+/// `k` operator so it reaches the output unconverted.
+/// Components range from 0.0 to 1.0; values outside that range are clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_color_cmyk(
+    handle: *mut PdfHandle,
+    c: f64,
+    m: f64,
+    y: f64,
+    k: f64,
+) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.fill_color = Color::Cmyk(CmykColor::clamped(c, m, y, k));
+            pdf.style.fill_pattern = None;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set a gray fill color used by subsequent text and filled shapes, written with the
+/// `g` operator. `value` ranges from 0.0 (black) to 1.0 (white) and is clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_color_gray(handle: *mut PdfHandle, value: f64) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.fill_color = Color::Gray(GrayColor::clamped(value));
+            pdf.style.fill_pattern = None;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set a CMYK stroke color used by subsequent lines and shape outlines, written with the
+/// `K` operator so it reaches the output unconverted.
+/// Components range from 0.0 to 1.0; values outside that range are clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_stroke_color_cmyk(
+    handle: *mut PdfHandle,
+    c: f64,
+    m: f64,
+    y: f64,
+    k: f64,
+) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.stroke_color = Color::Cmyk(CmykColor::clamped(c, m, y, k));
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set a gray stroke color used by subsequent lines and shape outlines, written with the
+/// `G` operator. `value` ranges from 0.0 (black) to 1.0 (white) and is clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_stroke_color_gray(handle: *mut PdfHandle, value: f64) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.stroke_color = Color::Gray(GrayColor::clamped(value));
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Set the dash pattern used by subsequent lines and shape outlines.
+/// `pattern` holds `pattern_len` alternating dash and gap lengths in points, and
+/// `phase` is the distance into the pattern at which each stroke starts; both
+/// map directly to the PDF `d` operator. For dots, use a short dash with a round
+/// or thick line, e.g. `{1, 3}`.
+/// Returns 0 on success, or a negative error code on failure (including an
+/// empty pattern, negative lengths, or a pattern of only zeros).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `pattern` must point to at least `pattern_len` readable doubles.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_line_dash(
+    handle: *mut PdfHandle,
+    pattern: *const f64,
+    pattern_len: usize,
+    phase: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if pattern.is_null() || pattern_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Dash pattern is empty");
+    }
+    let pattern = std::slice::from_raw_parts(pattern, pattern_len).to_vec();
+    if !pattern.iter().all(|&len| len >= 0.0 && len.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Dash lengths must be non-negative");
+    }
+    if pattern.iter().all(|&len| len == 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Dash pattern must not be all zeros");
+    }
+    if !(phase >= 0.0 && phase.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Dash phase must be non-negative");
+    }
+
+    pdf.style.line_dash = Some((pattern, phase));
+    PDF_OK
+}
+
+/// Set the extra space added after every character of subsequent text, in
+/// points (the `Tc` operator). Negative values tighten the text; 0 restores
+/// the default. `pdf_measure_text` and the wrapping functions include it.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_char_spacing(handle: *mut PdfHandle, spacing: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !spacing.is_finite() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Character spacing must be finite");
+    }
+    pdf.style.char_spacing = spacing;
+    PDF_OK
+}
+
+/// Set the extra space added after every space of subsequent text, in points
+/// (the `Tw` operator). Negative values tighten the text; 0 restores the
+/// default. The PDF format applies word spacing only to one-byte character
+/// codes, so it has no effect on text drawn with a loaded font.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_word_spacing(handle: *mut PdfHandle, spacing: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !spacing.is_finite() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Word spacing must be finite");
+    }
+    pdf.style.word_spacing = spacing;
+    PDF_OK
+}
+
+/// Set whether subsequent text in loaded fonts is kerned: pairs of glyphs
+/// such as "AV" are moved closer or apart by the adjustments of the font's
+/// `GPOS` `kern` feature, or of its `kern` table. Nonzero turns kerning on;
+/// it is off by default. The built-in Helvetica is never kerned.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_kerning(handle: *mut PdfHandle, enabled: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.shaping.kerning = enabled != 0;
+    PDF_OK
+}
+
+/// Set whether subsequent text in loaded fonts uses the font's standard
+/// ligatures (its `GSUB` `liga` feature), drawing sequences such as "fi" or
+/// "ffl" as one glyph. Text extracted from the PDF still reads as the
+/// separate letters. Nonzero turns ligatures on; they are off by default.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_ligatures(handle: *mut PdfHandle, enabled: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.shaping.ligatures = enabled != 0;
+    PDF_OK
+}
+
+/// Set whether subsequent fills and strokes overprint the inks beneath them
+/// instead of knocking them out, as print workflows want for black text on
+/// a colored background. Nonzero turns overprint on, and 0 for both restores the
+/// default. Overprint only shows on separations: viewers simulate it at best,
+/// and it has no effect on RGB output.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_overprint(handle: *mut PdfHandle, fill_overprint: i32, stroke_overprint: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.style.overprint.fill = fill_overprint != 0;
+    pdf.style.overprint.stroke = stroke_overprint != 0;
+    PDF_OK
+}
+
+/// Set the overprint mode of subsequent drawing (/OPM). In mode 0, the
+/// default, an overprinting CMYK color paints all four inks, so a component
+/// of 0 knocks that ink out. In mode 1 components of 0 leave the ink beneath
+/// unchanged, so black text on cyan keeps the cyan.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_overprint_mode(handle: *mut PdfHandle, mode: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.style.overprint.nonzero_mode = match mode {
+        0 => false,
+        1 => true,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Overprint mode must be 0 or 1, got {}", mode)),
+    };
+    PDF_OK
+}
+
+/// Restore solid lines for subsequent drawing calls.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_line_dash_solid(handle: *mut PdfHandle) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.line_dash = None;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// Restore black fill and stroke colors for subsequent drawing calls.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_reset_colors(handle: *mut PdfHandle) -> i32 {
+    match handle_mut(handle) {
+        Ok(mut pdf) => {
+            pdf.style.fill_color = Color::BLACK;
+            pdf.style.fill_pattern = None;
+            pdf.style.stroke_color = Color::BLACK;
+            PDF_OK
+        }
+        Err(code) => code,
+    }
+}
+
+/// A color stop of a gradient set with `pdf_set_fill_linear_gradient` or
+/// `pdf_set_fill_radial_gradient`.
+#[repr(C)]
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct PdfGradientStop {
+    /// Position along the gradient, from 0.0 (start) to 1.0 (end).
+    pub offset: f64,
+    /// Red component (0.0-1.0, clamped).
+    pub r: f64,
+    /// Green component (0.0-1.0, clamped).
+    pub g: f64,
+    /// Blue component (0.0-1.0, clamped).
+    pub b: f64,
+}
+
+/// Adds `stop_count` stops to `gradient` and makes it the fill of subsequent
+/// drawing calls, under a pattern name no earlier gradient used.
+unsafe fn set_fill_gradient(
+    handle: *mut PdfHandle,
+    mut gradient: Gradient,
+    stops: *const PdfGradientStop,
+    stop_count: usize,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !stops.is_null() {
+        for stop in std::slice::from_raw_parts(stops, stop_count) {
+            gradient = gradient.stop(stop.offset, RgbColor::clamped(stop.r, stop.g, stop.b));
+        }
+    }
+    if let Err(e) = gradient.check() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string());
+    }
+
+    pdf.gradient_count += 1;
+    pdf.style.fill_pattern = Some((format!("Gr{}", pdf.gradient_count), FillPattern::Gradient(gradient)));
+    PDF_OK
+}
+
+/// Fill subsequent text and filled shapes with a gradient along the line from
+/// (`x0`, `y0`) to (`x1`, `y1`), in page coordinates. Beyond the ends, the
+/// first and last stop colors continue. A fill color call replaces it.
+/// Returns 0 on success, or a negative error code on failure (including fewer
+/// than two stops or offsets outside 0-1 or out of order).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `stops` must point to at least `stop_count` readable stops.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_linear_gradient(
+    handle: *mut PdfHandle,
+    x0: f64,
+    y0: f64,
+    x1: f64,
+    y1: f64,
+    stops: *const PdfGradientStop,
+    stop_count: usize,
+) -> i32 {
+    set_fill_gradient(handle, Gradient::linear(x0, y0, x1, y1), stops, stop_count)
+}
+
+/// Fill subsequent text and filled shapes with a gradient from the center
+/// (`cx`, `cy`) out to `radius`, in page coordinates. Past the radius, the
+/// last stop color continues. A fill color call replaces it.
+/// Returns 0 on success, or a negative error code on failure (including fewer
+/// than two stops, offsets outside 0-1 or out of order, or a negative radius).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `stops` must point to at least `stop_count` readable stops.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_radial_gradient(
+    handle: *mut PdfHandle,
+    cx: f64,
+    cy: f64,
+    radius: f64,
+    stops: *const PdfGradientStop,
+    stop_count: usize,
+) -> i32 {
+    set_fill_gradient(handle, Gradient::radial(cx, cy, radius), stops, stop_count)
+}
+
+/// Create a pattern that repeats a `width` by `height` cell, painted by
+/// `content_len` bytes of raw content stream operators with the origin at
+/// the cell's lower-left corner, such as "0 0 m 8 8 l S" for hatching.
+/// Cells are laid edge to edge from the page origin, and anything drawn
+/// outside a cell is clipped. The operators are not checked, and the cell
+/// has no resources, so it cannot show text or images.
+/// Returns a pattern id (>= 0) for `pdf_set_fill_pattern`, or a negative
+/// error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `content` must point to at least `content_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_create_tiling_pattern(
+    handle: *mut PdfHandle,
+    width: f64,
+    height: f64,
+    content: *const u8,
+    content_len: usize,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if content.is_null() || content_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Pattern content is empty");
+    }
+    let content = match std::str::from_utf8(std::slice::from_raw_parts(content, content_len)) {
+        Ok(content) => content,
+        Err(_) => return fail(PDF_ERR_INVALID_ARGUMENT, "Pattern content is not valid UTF-8"),
+    };
+    let pattern = TilingPattern::new(width, height, ContentBuilder::new().raw(content));
+    if let Err(e) = pattern.check() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string());
+    }
+    pdf.tiling_patterns.push(pattern);
+    (pdf.tiling_patterns.len() - 1) as i32
+}
+
+/// Fill subsequent text and filled shapes with the pattern `pattern_id` from
+/// `pdf_create_tiling_pattern`. A fill color or gradient call replaces it.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_fill_pattern(handle: *mut PdfHandle, pattern_id: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let id = match usize::try_from(pattern_id) {
+        Ok(id) if id < pdf.tiling_patterns.len() => id,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown pattern id {}", pattern_id)),
+    };
+    pdf.style.fill_pattern = Some((format!("Tp{}", id + 1), FillPattern::Tiling(id)));
+    PDF_OK
+}
+
+/// Draw text on a page at the given position (in points, origin bottom-left),
+/// in the font set with `pdf_set_default_font`, or Helvetica. A `font_size` of
+/// 0 uses the default size, 12 points unless set.
+/// Characters the font cannot show are drawn with the first fallback font
+/// registered with `pdf_register_fallback_font` that has a glyph for them.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let (font, default_size) = pdf.default_font;
+    let font_size = if font_size == 0.0 { default_size } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    draw_text_runs(pdf, page_index, x, y, text, font_size, font)
+}
+
+/// Draw text like `pdf_add_text`, with the lines `decorations` combines from
+/// `PDF_DECORATION_*` flags drawn across it in the fill color. The lines are
+/// placed and sized by the default font's underline metrics (its `post`
+/// table) and strikeout metrics (its `OS/2` table), or by fractions of the
+/// font size where it has none. Lines span the text in every font it is drawn
+/// with, but follow the metrics of the default font.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_decorated(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    decorations: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let known = PDF_DECORATION_UNDERLINE | PDF_DECORATION_STRIKETHROUGH;
+    if decorations & !known != 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown text decorations {:#x}", decorations & !known));
+    }
+    let (font, default_size) = pdf.default_font;
+    let font_size = if font_size == 0.0 { default_size } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+
+    let metrics = match font {
+        None => FontMetrics::for_standard14(Standard14Font::Helvetica),
+        Some(id) => pdf.fonts[id].1.metrics(),
+    };
+    let mut lines = Vec::new();
+    if decorations & PDF_DECORATION_UNDERLINE != 0 {
+        lines.push((metrics.underline_position, metrics.underline_thickness));
+    }
+    if decorations & PDF_DECORATION_STRIKETHROUGH != 0 {
+        lines.push((metrics.strikeout_position, metrics.strikeout_thickness));
+    }
+    let scale = font_size / metrics.units_per_em as f64;
+    let width = pdf.runs_width(text, font_size, font);
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+    append_content(page, |c| {
+        style.wrap(c, |c| {
+            lines.iter().fold(c, |c, &(position, thickness)| {
+                let thickness = thickness as f64 * scale;
+                c.rect(x, y + position as f64 * scale - thickness / 2.0, width, thickness).fill()
+            })
+        })
+    });
+    draw_text_runs(pdf, page_index, x, y, text, font_size, font)
+}
+
+/// Draw text rotated counterclockwise by `angle_degrees` around its start point (`x`, `y`).
+/// The rotation is applied inside a saved graphics state, so later drawing is unaffected.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_rotated(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    angle_degrees: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    if !angle_degrees.is_finite() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Rotation angle must be a finite number");
+    }
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let matrix = Matrix::rotate_degrees(angle_degrees).multiply(&Matrix::translate(x, y));
+    append_content(page, |c| {
+        style
+            .apply(c.save_state())
+            .transform(matrix)
+            .text(DEFAULT_FONT_NAME, font_size, 0.0, 0.0, text)
+            .restore_state()
+    });
+    PDF_OK
+}
+
+/// Draw a run of text as normal, superscript, or subscript. `font_size` is the
+/// size of the surrounding text; scripts are drawn at 65% of it with the
+/// baseline shifted by the text rise, so `y` stays the surrounding baseline.
+/// `script` is one of the `PDF_SCRIPT_*` values.
+/// Returns the advance width of the run in points, so the next run can start at
+/// `x` plus the width, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_script(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    script: i32,
+) -> f64 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code as f64,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8") as f64,
+    };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive") as f64;
+    }
+    let (size, rise) = match script {
+        PDF_SCRIPT_NORMAL => (font_size, 0.0),
+        PDF_SCRIPT_SUPERSCRIPT => (font_size * SCRIPT_SCALE, font_size * SUPERSCRIPT_RISE),
+        PDF_SCRIPT_SUBSCRIPT => (font_size * SCRIPT_SCALE, font_size * SUBSCRIPT_RISE),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown script {}", script)) as f64,
+    };
+    let style = pdf.style.clone();
+    let width = calculate_helvetica_width(text, size) + style.spacing_width(text, true);
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code as f64,
+    };
+
+    let builder = TextBuilder::new().font(DEFAULT_FONT_NAME, size);
+    if rise == 0.0 {
+        let builder = builder.move_to(x, y).show(text);
+        append_content(page, |c| style.wrap(c, |c| c.text_block(builder)));
+    } else {
+        // Text rise outlives the text object, so keep it inside a saved state.
+        let builder = builder.rise(rise).move_to(x, y).show(text);
+        append_content(page, |c| {
+            style.apply(c.save_state()).text_block(builder).restore_state()
+        });
+    }
+    width
+}
+
+/// Set the distance between baselines, in points, for text drawn over several
+/// lines by `pdf_add_text_box`, `pdf_add_flowing_text`, `pdf_add_text_aligned` and
+/// `pdf_add_list` after this call. The default is 1.2 times the font size.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_leading(handle: *mut PdfHandle, leading: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(leading > 0.0 && leading.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Leading must be positive");
+    }
+    pdf.leading = Leading::Fixed(leading);
+    PDF_OK
+}
+
+/// Set the distance between baselines for multi-line text, as in
+/// `pdf_set_leading`, to `factor` times the font size of each call.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_leading_multiple(handle: *mut PdfHandle, factor: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(factor > 0.0 && factor.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Leading factor must be positive");
+    }
+    pdf.leading = Leading::Multiple(factor);
+    PDF_OK
+}
+
+/// Draw text wrapped to fit a box whose lower-left corner is (`x`, `y`).
+/// Lines break at spaces and at `\n`, and flow down from the top of the box.
+/// Lines that do not fit within `height` are not drawn. When `width` and `height`
+/// are both 0, the box is the page's content area (see `pdf_get_content_rect`)
+/// and `x` and `y` are ignored.
+/// Returns the number of lines that did not fit (0 if all text was drawn),
+/// or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_box(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    text: *const c_char,
+    font_size: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let (x, y, width, height) = if width == 0.0 && height == 0.0 {
+        match pdf.content_rect(page_index) {
+            Ok(rect) => (rect.llx, rect.lly, rect.width(), rect.height()),
+            Err(code) => return code,
+        }
+    } else {
+        (x, y, width, height)
+    };
+    if !(width > 0.0 && height >= 0.0 && font_size > 0.0) {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "Text box width and font size must be positive and height must not be negative",
+        );
+    }
+    let style = pdf.style.clone();
+    let leading = pdf.leading.at(font_size);
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let lines = wrap_text(text, width, |s| calculate_helvetica_width(s, font_size) + style.spacing_width(s, true));
+    let fitting = line_capacity(height, font_size, leading).min(lines.len());
+    if fitting > 0 {
+        let builder = text_lines(&lines[..fitting], x, y + height, font_size, leading);
+        append_content(page, |c| style.wrap(c, |c| c.text_block(builder)));
+    }
+
+    (lines.len() - fitting).min(i32::MAX as usize) as i32
+}
+
+/// Flow text through the content area of the last page (see
+/// `pdf_get_content_rect`), starting at its top and adding pages of the same
+/// size as needed. Lines break at spaces and at `\n`, and a form feed (`\x0C`)
+/// starts a new page. A page is added first if the document has none.
+/// Returns the number of pages the text spans, including the starting page, or a
+/// negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_flowing_text(
+    handle: *mut PdfHandle,
+    text: *const c_char,
+    font_size: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    if !(font_size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    if pdf.document.pages.is_empty() {
+        let letter = Rectangle::letter();
+        let mut page = new_page(letter.width(), letter.height());
+        if pdf.landscape {
+            page.media_box = page.media_box.landscape();
+        }
+        pdf.document.add_page(page);
+    }
+    let first = pdf.document.page_count() - 1;
+    let media_box = pdf.document.pages[first].media_box;
+    let area = pdf.document.margins.unwrap_or_default().content_rect(&media_box);
+    let leading = pdf.leading.at(font_size);
+    let capacity = line_capacity(area.height(), font_size, leading);
+    if capacity == 0 || area.width() <= 0.0 {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "The content area is too small for a line of text",
+        );
+    }
+    let style = pdf.style.clone();
+    pdf.data.get_mut().take();
+
+    let blank_page = || {
+        let mut page = new_page(media_box.width(), media_box.height());
+        page.media_box = media_box;
+        page
+    };
+    for (i, section) in text.split('\x0C').enumerate() {
+        if i > 0 {
+            pdf.document.add_page(blank_page());
+        }
+        let lines = wrap_text(section, area.width(), |s| {
+            calculate_helvetica_width(s, font_size) + style.spacing_width(s, true)
+        });
+        for (j, chunk) in lines.chunks(capacity).enumerate() {
+            if j > 0 {
+                pdf.document.add_page(blank_page());
+            }
+            let builder = text_lines(chunk, area.llx, area.ury, font_size, leading);
+            let handle = &mut *pdf;
+            if let Some(page) = handle.document.pages.last_mut() {
+                add_style_resources(page, &style, &handle.tiling_patterns);
+                append_content(page, |c| style.clone().wrap(c, |c| c.text_block(builder)));
+            }
+        }
+    }
+
+    let added = pdf.document.page_count() - first;
+    if let Err(code) = pdf.write_streamed_pages(1) {
+        return code;
+    }
+    added.min(i32::MAX as usize) as i32
+}
+
+/// Draw text aligned within a box `width` points wide starting at `x`.
+/// `alignment` is one of the `PDF_ALIGN_*` values. Text wraps at spaces and at
+/// `\n`; `y` is the baseline of the first line and later lines flow downward.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_aligned(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    text: *const c_char,
+    font_size: f64,
+    alignment: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    if !(width > 0.0 && font_size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Width and font size must be positive");
+    }
+    if !(PDF_ALIGN_LEFT..=PDF_ALIGN_JUSTIFY).contains(&alignment) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown alignment {}", alignment));
+    }
+    let style = pdf.style.clone();
+    let leading = pdf.leading.at(font_size);
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let measure = |s: &str| calculate_helvetica_width(s, font_size) + style.spacing_width(s, true);
+    let mut builder = TextBuilder::new().font(DEFAULT_FONT_NAME, font_size);
+    let mut baseline = y;
+    let mut spacing = style.word_spacing;
+    for paragraph in text.lines() {
+        let mut lines = wrap_text(paragraph, width, measure);
+        if lines.is_empty() {
+            lines.push(String::new());
+        }
+        let last = lines.len() - 1;
+        for (i, line) in lines.into_iter().enumerate() {
+            let slack = (width - measure(&line)).max(0.0);
+            let spaces = line.matches(' ').count();
+            let (offset, word_spacing) = match alignment {
+                PDF_ALIGN_CENTER => (slack / 2.0, 0.0),
+                PDF_ALIGN_RIGHT => (slack, 0.0),
+                PDF_ALIGN_JUSTIFY if i < last && spaces > 0 => (0.0, slack / spaces as f64),
+                _ => (0.0, 0.0),
+            };
+            let word_spacing = style.word_spacing + word_spacing;
+            if word_spacing != spacing {
+                builder = builder.word_spacing(word_spacing);
+                spacing = word_spacing;
+            }
+            builder = builder.position(x + offset, baseline).show(line);
+            baseline -= leading;
+        }
+    }
+
+    append_content(page, |c| style.wrap(c, |c| c.text_block(builder)));
+    PDF_OK
+}
+
+/// Stamp every page with a page number in the bottom margin, half an inch from the edge.
+/// `format` may contain `{page}` and `{total}`, which are resolved each time the
+/// document is serialized, so pages added later are counted. `alignment` is
+/// `PDF_ALIGN_LEFT`, `PDF_ALIGN_CENTER` or `PDF_ALIGN_RIGHT`. Calling this again
+/// replaces the previous settings. After `pdf_set_margins`, numbers are aligned to
+/// the content area instead.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `format` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_page_numbers(
+    handle: *mut PdfHandle,
+    format: *const c_char,
+    font_size: f64,
+    alignment: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let format = match str_arg(format) {
+        Some(f) => f,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Format is null or not valid UTF-8"),
+    };
+    if !(font_size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    let alignment = match alignment {
+        PDF_ALIGN_LEFT => PageNumberAlignment::Left,
+        PDF_ALIGN_CENTER => PageNumberAlignment::Center,
+        PDF_ALIGN_RIGHT => PageNumberAlignment::Right,
+        _ => {
+            return fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("Alignment {} is not supported for page numbers", alignment),
+            )
+        }
+    };
+
+    pdf.document.page_numbers = Some(
+        PageNumbers::new(format)
+            .font_size(font_size)
+            .alignment(alignment),
+    );
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Stamp every page with `text` in Helvetica, centered and rotated by
+/// `angle_degrees` counterclockwise. `opacity` runs from 0.0 (invisible) to 1.0
+/// (opaque). The watermark is applied each time the document is serialized, so
+/// pages added later are stamped too. Calling this again replaces the previous
+/// watermark.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_watermark(
+    handle: *mut PdfHandle,
+    text: *const c_char,
+    font_size: f64,
+    opacity: f64,
+    angle_degrees: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) if !t.is_empty() => t,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null, empty or not valid UTF-8"),
+    };
+    if !(font_size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    if !(0.0..=1.0).contains(&opacity) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Opacity must be between 0 and 1");
+    }
+    if !angle_degrees.is_finite() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Angle must be finite");
+    }
+
+    pdf.document.watermark = Some(
+        Watermark::new(text)
+            .font_size(font_size)
+            .opacity(opacity)
+            .angle(angle_degrees),
+    );
+    pdf.data.get_mut().take();
+    PDF_OK
+}
+
+/// Add an entry to the document outline that jumps to `page_index`, scrolled so
+/// `y` is at the top of the view. `parent_id` is the id of an earlier bookmark to
+/// nest under, or -1 for a top-level entry; siblings appear in the order added.
+/// Returns the bookmark id (>= 0), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `title` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_bookmark(
+    handle: *mut PdfHandle,
+    parent_id: i32,
+    title: *const c_char,
+    page_index: i32,
+    y: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let title = match str_arg(title) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Title is null or not valid UTF-8"),
+    };
+    if let Err(code) = pdf.page_mut(page_index) {
+        return code;
+    }
+
+    let mut bookmark = Bookmark::new(title, page_index as usize).at(y);
+    if parent_id != -1 {
+        match usize::try_from(parent_id) {
+            Ok(parent) => bookmark = bookmark.child_of(parent),
+            Err(_) => {
+                return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid parent id {}", parent_id))
+            }
+        }
+    }
+    match pdf.document.add_bookmark(bookmark) {
+        Ok(id) => id.min(i32::MAX as usize) as i32,
+        Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, e.to_string()),
+    }
+}
+
+/// Make a rectangle on a page a clickable link to `uri`.
+/// Characters outside printable ASCII are percent-encoded. Overlapping links are
+/// kept as separate annotations.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `uri` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_link_uri(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    uri: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let uri = match str_arg(uri) {
+        Some(u) if !u.is_empty() => u,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "URI is null, empty or not valid UTF-8"),
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Link width and height must be positive");
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_link(Link::uri(Rectangle::new(x, y, x + width, y + height), uri));
+    PDF_OK
+}
+
+/// Make a rectangle on a page a clickable link to `target_page`, scrolled so
+/// `target_y` is at the top of the view. The target page may be added later,
+/// but must exist when the document is serialized.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_link_internal(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    target_page: i32,
+    target_y: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Link width and height must be positive");
+    }
+    let target_page = match usize::try_from(target_page) {
+        Ok(target) => target,
+        Err(_) => {
+            return fail(PDF_ERR_PAGE_OUT_OF_RANGE, format!("Target page {} is negative", target_page))
+        }
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let rect = Rectangle::new(x, y, x + width, y + height);
+    page.add_link(Link::internal(rect, target_page, Some(target_y)));
+    PDF_OK
+}
+
+/// Highlight a rectangle on a page in a translucent color, as a highlight
+/// annotation listed in the viewer's comments panel. Components range from
+/// 0.0 to 1.0; values outside that range are clamped.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_highlight(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    r: f64,
+    g: f64,
+    b: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Highlight width and height must be positive");
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let rect = Rectangle::new(x, y, x + width, y + height);
+    page.add_annotation(Annotation::highlight(rect, RgbColor::clamped(r, g, b)));
+    PDF_OK
+}
+
+/// Add a sticky note to a page with its icon's lower-left corner at (`x`, `y`).
+/// The note opens to show `contents`, which is also listed in the viewer's
+/// comments panel.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `contents` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_note(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    contents: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let contents = match str_arg(contents) {
+        Some(contents) => contents,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Note contents are null or not valid UTF-8"),
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_annotation(Annotation::note(x, y, contents));
+    PDF_OK
+}
+
+/// Add a standard rubber stamp to a page with its lower-left corner at
+/// (`x`, `y`). `stamp_name` is one of the standard names: "Approved",
+/// "Experimental", "NotApproved", "AsIs", "Expired", "NotForPublicRelease",
+/// "Confidential", "Final", "Sold", "Departmental", "ForComment",
+/// "TopSecret", "Draft" or "ForPublicRelease".
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `stamp_name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_stamp(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    stamp_name: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let stamp = match str_arg(stamp_name).and_then(StampName::from_name) {
+        Some(stamp) => stamp,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Stamp name is not one of the standard stamps"),
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_annotation(Annotation::stamp(x, y, stamp));
+    PDF_OK
+}
+
+/// Define a named destination: a position on a page that links made with
+/// `pdf_add_link_named` can go to by name, scrolled so (`x`, `y`) is at the top
+/// left of the view. A destination of the same name is replaced, and the call
+/// still succeeds but records a warning for `pdf_last_error`.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_named_destination(
+    handle: *mut PdfHandle,
+    name: *const c_char,
+    page_index: i32,
+    x: f64,
+    y: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let name = match str_arg(name) {
+        Some(n) if !n.is_empty() => n,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Destination name is null, empty or not valid UTF-8"),
+    };
+    if let Err(code) = pdf.page_mut(page_index) {
+        return code;
+    }
+
+    let destination = NamedDestination::new(name, page_index as usize).at(x, y);
+    match pdf.document.add_named_destination(destination) {
+        Ok(Some(_)) => {
+            set_last_error(format!("Named destination '{}' replaced an earlier one", name));
+            PDF_OK
+        }
+        Ok(None) => PDF_OK,
+        Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, e.to_string()),
+    }
+}
+
+/// Make a rectangle on a page a clickable link to the named destination
+/// `dest_name`. The destination may be defined later with
+/// `pdf_add_named_destination`, but must exist when the document is serialized.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `dest_name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_link_named(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    dest_name: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let dest_name = match str_arg(dest_name) {
+        Some(n) if !n.is_empty() => n,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Destination name is null, empty or not valid UTF-8"),
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Link width and height must be positive");
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    page.add_link(Link::named(Rectangle::new(x, y, x + width, y + height), dest_name));
+    PDF_OK
+}
+
+/// Load a TrueType or OpenType font and register it under `font_name`.
+/// Only the glyphs drawn with the font are embedded when the document is written.
+/// Returns a font id (>= 0) for `pdf_add_text_with_font`, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must point to at least `data_len` readable bytes.
+/// `font_name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_load_font(
+    handle: *mut PdfHandle,
+    data: *const u8,
+    data_len: usize,
+    font_name: *const c_char,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let font_name = match str_arg(font_name) {
+        Some(name) if !name.is_empty() => name,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Font name is null, empty or not valid UTF-8"),
+    };
+    if data.is_null() || data_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font data is empty");
+    }
+    if pdf.fonts.iter().any(|(name, _)| name == font_name) {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            format!("A font named '{}' is already loaded", font_name),
+        );
+    }
+
+    let bytes = std::slice::from_raw_parts(data, data_len).to_vec();
+    let font = match TrueTypeFont::from_bytes(bytes) {
+        Ok(font) => font,
+        Err(e) => return fail(PDF_ERR_INVALID_FONT, e.to_string()),
+    };
+    pdf.fonts.push((font_name.to_string(), font));
+    (pdf.fonts.len() - 1) as i32
+}
+
+/// Draw text on a page using a font loaded with `pdf_load_font`, whatever the
+/// default font. A `font_size` of 0 uses the default size.
+/// Characters the font has no glyph for are drawn with the first fallback font
+/// registered with `pdf_register_fallback_font` that has one, or else as the
+/// font's missing glyph.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_with_font(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    font_id: i32,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let font_id = match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+        Some(id) => id,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+    };
+    let font_size = if font_size == 0.0 { pdf.default_font.1 } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    draw_text_runs(pdf, page_index, x, y, text, font_size, Some(font_id))
+}
+
+/// Draw a line of right-to-left text, such as Arabic or Hebrew, so that it ends
+/// at `x`. Arabic letters take the initial, medial, final or isolated form their
+/// neighbours call for where the font (or a fallback font) has a glyph for it,
+/// and the line is reordered so that embedded Latin words and numbers read left
+/// to right. A `font_size` of 0 uses the default size, and fallback fonts
+/// apply as for `pdf_add_text_with_font`.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_rtl(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    font_id: i32,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let font_id = match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+        Some(id) => id,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+    };
+    let font_size = if font_size == 0.0 { pdf.default_font.1 } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+
+    let visual = shape_rtl(text, |c| {
+        std::iter::once(&font_id)
+            .chain(&pdf.fallback_fonts)
+            .any(|&id| pdf.fonts[id].1.has_glyph(c))
+    });
+    let width = pdf.runs_width(&visual, font_size, Some(font_id));
+    draw_text_runs(pdf, page_index, x - width, y, &visual, font_size, Some(font_id))
+}
+
+/// Draw text in vertical columns, as in traditional Chinese and Japanese
+/// typesetting. Characters run top to bottom from (`x`, `y`), the top center
+/// of the first column, and each line of `text` starts a new column to the
+/// left of the last. A `font_size` of 0 uses the default size, and fallback
+/// fonts apply as for `pdf_add_text_with_font`.
+///
+/// Characters advance by the font's vertical metrics (its `vhea` and `vmtx`
+/// tables). A font without them advances every character by one em, which
+/// suits CJK fonts but crowds or spreads Latin letters. Glyphs are drawn
+/// upright as they are and not replaced by their vertical forms, so brackets,
+/// dashes and the long vowel mark keep their horizontal shape.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_text_vertical(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: *const c_char,
+    font_size: f64,
+    font_id: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8"),
+    };
+    let font_id = match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+        Some(id) => id,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+    };
+    let font_size = if font_size == 0.0 { pdf.default_font.1 } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+
+    let mut columns = Vec::new();
+    let mut missing = 0;
+    for line in text.lines() {
+        let (runs, line_missing) = pdf.font_runs(line, Some(font_id));
+        columns.push(runs);
+        missing += line_missing;
+    }
+    let style = pdf.style.clone();
+    let fonts = pdf.fonts.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let mut block = TextBuilder::new();
+    for (column, runs) in columns.into_iter().enumerate() {
+        let center = x - column as f64 * font_size * LINE_SPACING;
+        let mut top = y;
+        for (font, run) in runs {
+            let id = font.unwrap_or(font_id);
+            let font = &fonts[id].1;
+            let name = format!("TT{}", id + 1);
+            if !page.fonts.iter().any(|(n, _)| *n == name) {
+                page.add_font(name.clone(), font.clone().into());
+            }
+            block = block.font(name, font_size);
+            let scale = font_size / font.units_per_em() as f64;
+            let ascent = font.metrics().ascender as f64 * scale;
+            for c in run.chars().filter(|c| !c.is_control()) {
+                let gid = font.glyph_id(c).unwrap_or(0);
+                let width = font.advance_width(gid) as f64 * scale;
+                block = block.position(center - width / 2.0, top - ascent).show_hex(gid.to_be_bytes().to_vec());
+                top -= font.advance_height(gid).map_or(font_size, |height| height as f64 * scale);
+            }
+        }
+    }
+    append_content(page, |c| style.wrap(c, |c| c.text_block(block)));
+    missing.try_into().unwrap_or(i32::MAX)
+}
+
+/// Draw `value` formatted with `decimals` decimals, `thousands_sep` between
+/// groups of thousands and `decimal_sep` before the decimals, right-aligned so
+/// that it ends at `x`. A null or empty `thousands_sep` leaves the digits
+/// ungrouped and a null `decimal_sep` uses ".". `flags` may include
+/// `PDF_NUMBER_PARENTHESES` to write negative values in parentheses instead of
+/// with a minus sign. The text is drawn like `pdf_add_text`, in the default font.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `thousands_sep` and `decimal_sep` must each be null or a valid
+/// null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_number(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    value: f64,
+    decimals: i32,
+    thousands_sep: *const c_char,
+    decimal_sep: *const c_char,
+    font_size: f64,
+    flags: i32,
+) -> i32 {
+    if flags & !PDF_NUMBER_PARENTHESES != 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown number flags {:#x}", flags & !PDF_NUMBER_PARENTHESES));
+    }
+    let format = match number_format(decimals, thousands_sep, decimal_sep, flags) {
+        Ok(format) => format,
+        Err(code) => return code,
+    };
+    draw_number(handle, page_index, x, y, value, &format, font_size)
+}
+
+/// Draw `value` as an amount of money, like `pdf_add_number` with `symbol`
+/// written before the digits, as in "$1,234.50". With
+/// `PDF_NUMBER_SYMBOL_AFTER` in `flags` the symbol follows the digits after a
+/// space, as in "1.234,50 €". A minus sign or parentheses enclose the symbol.
+/// Symbols outside ASCII, such as "€", need a loaded default or fallback font
+/// with a glyph for them.
+/// Returns the number of characters no font has a glyph for (0 when all of the
+/// text is drawn), or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `symbol` must be a valid null-terminated C string, and `thousands_sep`
+/// and `decimal_sep` each null or one.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_currency(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    value: f64,
+    decimals: i32,
+    thousands_sep: *const c_char,
+    decimal_sep: *const c_char,
+    symbol: *const c_char,
+    font_size: f64,
+    flags: i32,
+) -> i32 {
+    let known = PDF_NUMBER_PARENTHESES | PDF_NUMBER_SYMBOL_AFTER;
+    if flags & !known != 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown number flags {:#x}", flags & !known));
+    }
+    let symbol = match str_arg(symbol) {
+        Some(symbol) => symbol,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Currency symbol is null or not valid UTF-8"),
+    };
+    let format = match number_format(decimals, thousands_sep, decimal_sep, flags) {
+        Ok(format) if flags & PDF_NUMBER_SYMBOL_AFTER != 0 => format.suffix(format!(" {}", symbol)),
+        Ok(format) => format.prefix(symbol),
+        Err(code) => return code,
+    };
+    draw_number(handle, page_index, x, y, value, &format, font_size)
+}
+
+/// Builds the format shared by `pdf_add_number` and `pdf_add_currency` from
+/// their arguments.
+unsafe fn number_format(
+    decimals: i32,
+    thousands_sep: *const c_char,
+    decimal_sep: *const c_char,
+    flags: i32,
+) -> Result<NumberFormat, i32> {
+    let decimals = match usize::try_from(decimals).ok().filter(|&d| d <= MAX_NUMBER_DECIMALS) {
+        Some(decimals) => decimals,
+        None => {
+            return Err(fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("Decimals must be between 0 and {}, got {}", MAX_NUMBER_DECIMALS, decimals),
+            ))
+        }
+    };
+    let mut format = NumberFormat::new()
+        .decimals(decimals)
+        .thousands_separator("")
+        .parentheses(flags & PDF_NUMBER_PARENTHESES != 0);
+    if !thousands_sep.is_null() {
+        match str_arg(thousands_sep) {
+            Some(sep) => format = format.thousands_separator(sep),
+            None => return Err(fail(PDF_ERR_INVALID_ARGUMENT, "Thousands separator is not valid UTF-8")),
+        }
+    }
+    if !decimal_sep.is_null() {
+        match str_arg(decimal_sep) {
+            Some(sep) => format = format.decimal_separator(sep),
+            None => return Err(fail(PDF_ERR_INVALID_ARGUMENT, "Decimal separator is not valid UTF-8")),
+        }
+    }
+    Ok(format)
+}
+
+/// Draws `value` in `format` with the default font, ending at `x`.
+unsafe fn draw_number(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    value: f64,
+    format: &NumberFormat,
+    font_size: f64,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !value.is_finite() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Value must be finite");
+    }
+    let (font, default_size) = pdf.default_font;
+    let font_size = if font_size == 0.0 { default_size } else { font_size };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    let text = format.format(value);
+    let width = pdf.runs_width(&text, font_size, font);
+    draw_text_runs(pdf, page_index, x - width, y, &text, font_size, font)
+}
+
+/// Register a font loaded with `pdf_load_font` as a fallback. `pdf_add_text`
+/// and `pdf_add_text_with_font` split text at characters the requested font
+/// has no glyph for and draw each with the first fallback, in the order
+/// registered, that has one. Registering a font twice has no effect.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_register_fallback_font(handle: *mut PdfHandle, font_id: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let font_id = match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+        Some(id) => id,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+    };
+    if !pdf.fallback_fonts.contains(&font_id) {
+        pdf.fallback_fonts.push(font_id);
+    }
+    PDF_OK
+}
+
+/// Set the font and size `pdf_add_text` draws with: `font_id` is a font loaded
+/// with `pdf_load_font`, or `PDF_FONT_DEFAULT` for the built-in Helvetica, and
+/// `font_size` is used by calls that pass a size of 0.
+///
+/// The font is used by `pdf_add_text`, `pdf_add_text_decorated`,
+/// `pdf_add_number` and `pdf_add_currency`. The size is used by those and by
+/// `pdf_add_text_with_font`, `pdf_add_text_rtl` and `pdf_add_text_vertical`
+/// when they pass 0, and for the body text of `pdf_add_html`. Other text
+/// functions, such as `pdf_add_text_rotated`, `pdf_add_text_script`,
+/// `pdf_add_text_box` and `pdf_add_text_aligned`, always draw in Helvetica
+/// at the size they are given.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_default_font(handle: *mut PdfHandle, font_id: i32, font_size: f64) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let font = match font_id {
+        PDF_FONT_DEFAULT => None,
+        _ => match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+            Some(id) => Some(id),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+        },
+    };
+    if !(font_size > 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive");
+    }
+    pdf.default_font = (font, font_size);
+    PDF_OK
+}
+
+/// Draws `text` as one text block, switching fonts where `font_runs` splits
+/// it, and returns the number of characters no font has a glyph for.
+fn draw_text_runs(
+    mut pdf: HandleGuard<'_>,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    text: &str,
+    font_size: f64,
+    primary: Option<usize>,
+) -> i32 {
+    let (runs, missing) = pdf.font_runs(text, primary);
+    let style = pdf.style.clone();
+    let fonts = pdf.fonts.clone();
+    let shaping = pdf.shaping;
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    let mut block = TextBuilder::new();
+    for (i, (font, run)) in runs.into_iter().enumerate() {
+        block = match font {
+            None => block.font(DEFAULT_FONT_NAME, font_size),
+            Some(id) => {
+                let name = format!("TT{}", id + 1);
+                if !page.fonts.iter().any(|(n, _)| *n == name) {
+                    page.add_font(name.clone(), fonts[id].1.clone().into());
+                }
+                block.font(name, font_size)
+            }
+        };
+        if i == 0 {
+            block = block.move_to(x, y);
+        }
+        block = match font {
+            None => block.show(run),
+            Some(id) => block.show_shaped(&fonts[id].1.shape(&run, shaping), fonts[id].1.units_per_em()),
+        };
+    }
+    append_content(page, |c| style.wrap(c, |c| c.text_block(block)));
+    missing.try_into().unwrap_or(i32::MAX)
+}
+
+/// Measure the advance width of text, in points, as it would be drawn.
+/// `font_id` is a font loaded with `pdf_load_font`, or `PDF_FONT_DEFAULT` for the
+/// built-in Helvetica. Widths come from the font's glyph metrics plus the spacing
+/// set with `pdf_set_char_spacing` and `pdf_set_word_spacing`, and for loaded fonts
+/// the kerning and ligatures turned on with `pdf_set_kerning` and `pdf_set_ligatures`.
+/// Returns the width, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `text` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_measure_text(
+    handle: *const PdfHandle,
+    text: *const c_char,
+    font_size: f64,
+    font_id: i32,
+) -> f64 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code as f64,
+    };
+    let text = match str_arg(text) {
+        Some(t) => t,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Text is null or not valid UTF-8") as f64,
+    };
+    if !(font_size >= 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must not be negative") as f64;
+    }
+
+    if font_id == PDF_FONT_DEFAULT {
+        return calculate_helvetica_width(text, font_size) + pdf.style.spacing_width(text, true);
+    }
+    match usize::try_from(font_id).ok().filter(|&id| id < pdf.fonts.len()) {
+        Some(id) => pdf.font_run_width(id, text, font_size),
+        None => fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)) as f64,
+    }
+}
+
+/// Get the vertical metrics of a font scaled to `font_size` points: the
+/// ascent above the baseline, the descent below it (negative), the height of
+/// capital letters and the extra gap the font asks for between lines.
+/// `font_id` is a font loaded with `pdf_load_font`, whose metrics come from
+/// its `hhea` and `OS/2` tables, or `PDF_FONT_DEFAULT` for the built-in
+/// Helvetica and its AFM metrics. A line of text takes ascent - descent +
+/// line gap.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// The output pointers must be valid for writing a `double`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_font_metrics(
+    handle: *const PdfHandle,
+    font_id: i32,
+    font_size: f64,
+    out_ascent: *mut f64,
+    out_descent: *mut f64,
+    out_cap_height: *mut f64,
+    out_line_gap: *mut f64,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if out_ascent.is_null() || out_descent.is_null() || out_cap_height.is_null() || out_line_gap.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    if !(font_size >= 0.0 && font_size.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must not be negative");
+    }
+    let metrics = if font_id == PDF_FONT_DEFAULT {
+        FontMetrics::for_standard14(Standard14Font::Helvetica)
+    } else {
+        match usize::try_from(font_id).ok().and_then(|id| pdf.fonts.get(id)) {
+            Some((_, font)) => font.metrics(),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown font id {}", font_id)),
+        }
+    };
+
+    let scale = font_size / metrics.units_per_em as f64;
+    *out_ascent = metrics.ascender as f64 * scale;
+    *out_descent = metrics.descender as f64 * scale;
+    *out_cap_height = metrics.cap_height as f64 * scale;
+    *out_line_gap = metrics.line_gap as f64 * scale;
+    PDF_OK
+}
+
+/// Embed a JPEG or PNG image on a page, scaled to the given size.
+/// The format is detected from the image's magic bytes. If `width` or `height`
+/// is 0, it is computed from the other using the image's aspect ratio; if both
+/// are 0, the image is drawn at one point per pixel.
+/// Baseline and progressive 8-bit JPEGs with 1, 3 or 4 components are
+/// supported, the last embedded as CMYK. A CMYK JPEG with an Adobe marker is
+/// taken to be stored inverted, as Adobe applications write them.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must point to at least `data_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_image(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    data: *const u8,
+    data_len: usize,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+) -> i32 {
+    add_image(handle, page_index, data, data_len, x, y, width, height, None)
+}
+
+/// Embed a CMYK JPEG on a page like `pdf_add_image`, for files whose Adobe
+/// marker doesn't say reliably whether the colors are stored inverted.
+/// `inverted` is nonzero when they are. Fails with `PDF_ERR_INVALID_IMAGE`
+/// unless the JPEG has 4 components.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must point to at least `data_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_image_cmyk(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    data: *const u8,
+    data_len: usize,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    inverted: i32,
+) -> i32 {
+    add_image(handle, page_index, data, data_len, x, y, width, height, Some(inverted != 0))
+}
+
+/// Embeds an image for `pdf_add_image` and `pdf_add_image_cmyk`. With
+/// `cmyk` set, the image must be a CMYK JPEG inverted as it says.
+unsafe fn add_image(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    data: *const u8,
+    data_len: usize,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    cmyk: Option<bool>,
+) -> i32 {
+    #[cfg_attr(not(feature = "images"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if data.is_null() || data_len == 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Image data is empty");
+    }
+    if width < 0.0 || height < 0.0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Image width and height must not be negative");
+    }
+
+    #[cfg(feature = "images")]
+    {
+        let bytes = std::slice::from_raw_parts(data, data_len);
+        let image = match cmyk {
+            Some(inverted) => crate::image::Image::from_cmyk_jpeg_bytes(bytes, inverted),
+            None => crate::image::Image::from_bytes(bytes),
+        };
+        let image = match image {
+            Ok(image) if image.width > 0 && image.height > 0 => image,
+            Ok(_) => return fail(PDF_ERR_INVALID_IMAGE, "Image has no pixels"),
+            Err(e) => return fail(PDF_ERR_INVALID_IMAGE, e.to_string()),
+        };
+
+        let (width, height) = match (width == 0.0, height == 0.0) {
+            (true, true) => (image.width as f64, image.height as f64),
+            (true, false) => (height * image.aspect_ratio(), height),
+            (false, true) => (width, width / image.aspect_ratio()),
+            (false, false) => (width, height),
+        };
+
+        let page = match pdf.page_mut(page_index) {
+            Ok(page) => page,
+            Err(code) => return code,
+        };
+
+        let name = format!("Im{}", page.images.len() + 1);
+        page.add_image(name.clone(), image);
+        append_content(page, |c| c.draw_image(name, x, y, width, height));
+        PDF_OK
+    }
+
+    #[cfg(not(feature = "images"))]
+    {
+        let _ = (pdf, page_index, x, y, cmyk);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"images\" feature")
+    }
+}
+
+/// Draw a rectangle on a page.
+/// The outline is stroked when `stroke_width` is positive; when `filled` is nonzero the
+/// interior is also filled with the current fill color (black by default).
+/// Returns 0 on success, or a negative error code if neither fill nor stroke would be drawn.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_draw_rectangle(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    stroke_width: f64,
+    filled: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let filled = filled != 0;
+    let stroked = stroke_width > 0.0;
+    if !filled && !stroked {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "Rectangle must be filled or have a positive stroke width",
+        );
+    }
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| {
+        let c = style.apply(c.save_state());
+        let c = if stroked { c.line_width(stroke_width) } else { c };
+        let c = c.rect(x, y, width, height);
+        let c = match (filled, stroked) {
+            (true, true) => c.fill_and_stroke(),
+            (true, false) => c.fill(),
+            _ => c.stroke(),
+        };
+        c.restore_state()
+    });
+    PDF_OK
+}
+
+/// Append `content_len` bytes of raw content stream operators to a page,
+/// wrapped in their own q/Q so graphics state changes do not leak into
+/// content drawn later. Resources the operators name, such as a graphics
+/// state for `gs`, are registered with `pdf_add_resource`.
+///
+/// This is an escape hatch for operators the API has no function for. The
+/// bytes are not checked: malformed operators, an unbalanced Q or a
+/// resource that was never added produce a file viewers may display wrongly
+/// or reject. The content must be valid UTF-8; write binary strings in hex.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `content` must point to at least `content_len` readable bytes.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_append_raw_content(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    content: *const u8,
+    content_len: usize,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if content.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Content is null");
+    }
+    let content = match std::str::from_utf8(std::slice::from_raw_parts(content, content_len)) {
+        Ok(content) => content.to_string(),
+        Err(_) => return fail(PDF_ERR_INVALID_ARGUMENT, "Content is not valid UTF-8"),
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+    append_content(page, |c| c.save_state().raw(content).restore_state());
+    PDF_OK
+}
+
+/// Add a resource to a page for operators appended with
+/// `pdf_append_raw_content` to refer to: `resource_type` is one of the
+/// `PDF_RESOURCE_*` constants, `name` the name the operators use, and
+/// `object` the resource in PDF syntax, such as "<< /Type /ExtGState /CA 0.5 >>".
+/// A resource of the same type and name added before is replaced. The
+/// object must be direct, holding no streams or references, so image
+/// XObjects cannot be added this way. Resources the library adds for drawing
+/// calls, named like F1, Im1 or GSOverprint100, take precedence over one
+/// with the same name.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `name` and `object` must be valid null-terminated C strings.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_resource(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    name: *const c_char,
+    resource_type: i32,
+    object: *const c_char,
+) -> i32 {
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let category = match resource_type {
+        PDF_RESOURCE_EXT_GSTATE => ResourceType::ExtGState,
+        PDF_RESOURCE_COLOR_SPACE => ResourceType::ColorSpace,
+        PDF_RESOURCE_PATTERN => ResourceType::Pattern,
+        PDF_RESOURCE_SHADING => ResourceType::Shading,
+        PDF_RESOURCE_FONT => ResourceType::Font,
+        PDF_RESOURCE_PROPERTIES => ResourceType::Properties,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown resource type {}", resource_type)),
+    };
+    let name = match str_arg(name) {
+        Some(name) if !name.is_empty() => name.to_string(),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Resource name is null, empty or not valid UTF-8"),
+    };
+    let source = match str_arg(object) {
+        Some(source) => source,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Resource object is null or not valid UTF-8"),
+    };
+    #[cfg(feature = "parser")]
+    {
+        let object = match crate::parser::parse_object(source.as_bytes()) {
+            Ok((rest, object)) if rest.iter().all(u8::is_ascii_whitespace) => object,
+            _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Resource object is not a PDF object: {}", source)),
+        };
+        if !is_direct(&object) {
+            return fail(PDF_ERR_INVALID_ARGUMENT, "Resource object holds a stream or a reference");
+        }
+        let page = match pdf.page_mut(page_index) {
+            Ok(page) => page,
+            Err(code) => return code,
+        };
+        page.add_resource(category, name, object);
+        PDF_OK
+    }
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, page_index, category, name, source);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Draw a straight line on a page.
+/// Returns 0 on success, or a negative error code on failure (including `stroke_width <= 0`).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_draw_line(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x1: f64,
+    y1: f64,
+    x2: f64,
+    y2: f64,
+    stroke_width: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(stroke_width > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Line stroke width must be positive");
+    }
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| {
+        style
+            .apply(c.save_state())
+            .line_width(stroke_width)
+            .move_to(x1, y1)
+            .line_to(x2, y2)
+            .stroke()
+            .restore_state()
+    });
+    PDF_OK
+}
+
+/// Draw a cubic Bezier curve from (x0, y0) to (x1, y1) with control points
+/// (cx1, cy1) and (cx2, cy2), stroked 1pt wide in the current stroke color.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_draw_bezier(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x0: f64,
+    y0: f64,
+    cx1: f64,
+    cy1: f64,
+    cx2: f64,
+    cy2: f64,
+    x1: f64,
+    y1: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| {
+        style.wrap(c, |c| {
+            c.move_to(x0, y0)
+                .curve_to(cx1, cy1, cx2, cy2, x1, y1)
+                .stroke()
+        })
+    });
+    PDF_OK
+}
+
+/// Draw an axis-aligned ellipse centered on (cx, cy) with radii `rx` and `ry`,
+/// approximated with four Bezier arcs. Stroke and fill work as in
+/// [`pdf_draw_rectangle`]; pass equal radii for a circle.
+/// Returns 0 on success, or a negative error code on failure (including
+/// non-positive radii).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_draw_ellipse(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    cx: f64,
+    cy: f64,
+    rx: f64,
+    ry: f64,
+    stroke_width: f64,
+    filled: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(rx > 0.0 && ry > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Ellipse radii must be positive");
+    }
+    let filled = filled != 0;
+    let stroked = stroke_width > 0.0;
+    if !filled && !stroked {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "Ellipse must be filled or have a positive stroke width",
+        );
+    }
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| {
+        let c = style.apply(c.save_state());
+        let c = if stroked { c.line_width(stroke_width) } else { c };
+        let ellipse = GraphicsBuilder::new().ellipse(cx, cy, rx, ry);
+        let ellipse = match (filled, stroked) {
+            (true, true) => ellipse.fill_and_stroke(),
+            (true, false) => ellipse.fill(),
+            _ => ellipse.stroke(),
+        };
+        c.graphics(ellipse).restore_state()
+    });
+    PDF_OK
+}
+
+/// Constrain subsequent drawing on a page to a rectangle until the matching
+/// `pdf_pop_clip`, or a `pdf_restore_state` of a state saved before it. Clips
+/// nest: each one is intersected with the clips already pushed. Clips still
+/// pushed when the document is written are popped at the end of the page, so
+/// they never affect page numbers or watermarks.
+/// Returns 0 on success, or a negative error code on failure (including a
+/// non-positive width or height).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_push_clip_rect(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Clip width and height must be positive");
+    }
+
+    match pdf.push_state(page_index, SavedState::Clip, |c| c.rect(x, y, width, height).clip().end_path()) {
+        Ok(()) => PDF_OK,
+        Err(code) => code,
+    }
+}
+
+/// Remove the clip most recently pushed on a page by `pdf_push_clip_rect`.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` when the page has no clip to pop, or when a
+/// state saved after the clip has not been restored yet).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_pop_clip(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    match pdf.pop_state(page_index, "clip to pop", |state| matches!(state, SavedState::Clip)) {
+        Ok(_) => PDF_OK,
+        Err(code) => code,
+    }
+}
+
+/// Save the drawing state of a page for the matching `pdf_restore_state`, which
+/// undoes changes made in between to the fill and stroke colors, line dash,
+/// text spacing, leading and transformation, and pops clips pushed in between.
+/// Saves nest. States still saved when the document
+/// is written are restored at the end of the page.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_save_state(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    let state = SavedState::Drawing(pdf.style.clone(), pdf.leading);
+    match pdf.push_state(page_index, state, |c| c) {
+        Ok(()) => PDF_OK,
+        Err(code) => code,
+    }
+}
+
+/// Restore the drawing state saved most recently on a page by `pdf_save_state`,
+/// undoing color, dash, spacing, leading and transformation changes made
+/// since, and popping clips pushed since.
+/// Returns 0 on success, or a negative error code on failure (including
+/// `PDF_ERR_INVALID_ARGUMENT` when the page has no saved state).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_restore_state(handle: *mut PdfHandle, page_index: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+
+    match pdf.pop_state(page_index, "saved state to restore", |state| matches!(state, SavedState::Drawing(..))) {
+        Ok(SavedState::Drawing(style, leading)) => {
+            pdf.style = style;
+            pdf.leading = leading;
+            PDF_OK
+        }
+        Ok(SavedState::Clip) => unreachable!("pop_state only returns matching states"),
+        Err(code) => code,
+    }
+}
+
+/// Concatenates `matrix` with the current transformation of a page.
+unsafe fn concat_matrix(handle: *mut PdfHandle, page_index: i32, matrix: Matrix) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let values = matrix.to_array();
+    if !values.iter().all(|v| v.is_finite()) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Transformation values must be finite numbers");
+    }
+    let [a, b, c, d, ..] = values;
+    if a * d - b * c == 0.0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Transformation {:?} is not invertible", values));
+    }
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |content| content.transform(matrix));
+    PDF_OK
+}
+
+/// Transform the coordinates of subsequent drawing on a page by the affine
+/// matrix [a b c d e f], which maps (x, y) to (a*x + c*y + e, b*x + d*y + f)
+/// after any transformation already in effect. Use `pdf_save_state` and
+/// `pdf_restore_state` around it to limit it to a group of drawing calls.
+/// Links and form fields are placed in untransformed page coordinates.
+/// Returns 0 on success, or a negative error code on failure (including
+/// non-finite values and matrices that are not invertible).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_transform(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    a: f64,
+    b: f64,
+    c: f64,
+    d: f64,
+    e: f64,
+    f: f64,
+) -> i32 {
+    concat_matrix(handle, page_index, Matrix::new(a, b, c, d, e, f))
+}
+
+/// Move the origin of subsequent drawing on a page by (`tx`, `ty`), as
+/// `pdf_transform(handle, page_index, 1, 0, 0, 1, tx, ty)`.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_translate(handle: *mut PdfHandle, page_index: i32, tx: f64, ty: f64) -> i32 {
+    concat_matrix(handle, page_index, Matrix::translate(tx, ty))
+}
+
+/// Scale subsequent drawing on a page by `sx` horizontally and `sy`
+/// vertically about the origin; negative factors mirror it.
+/// Returns 0 on success, or a negative error code on failure (including a
+/// zero factor).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_scale(handle: *mut PdfHandle, page_index: i32, sx: f64, sy: f64) -> i32 {
+    concat_matrix(handle, page_index, Matrix::scale(sx, sy))
+}
+
+/// Rotate subsequent drawing on a page counterclockwise by `angle_degrees`
+/// about the origin. Translate first to rotate about another point.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_rotate(handle: *mut PdfHandle, page_index: i32, angle_degrees: f64) -> i32 {
+    concat_matrix(handle, page_index, Matrix::rotate_degrees(angle_degrees))
+}
+
+/// Draw a table whose top-left corner is (`x`, `y`), with rows flowing downward.
+/// `cells` holds `cell_count` strings in row-major order, which must equal
+/// `rows * cols`; a null entry leaves its cell empty. `col_widths` holds `cols`
+/// widths in points. Every cell gets a border in the current stroke color, and
+/// its text is left-aligned, vertically centered and clipped to the cell's padding.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `cells` must point to `cell_count` pointers, each null or a valid
+/// null-terminated C string, and `col_widths` to `cols` readable doubles.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_table(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    cells: *const *const c_char,
+    cell_count: usize,
+    rows: i32,
+    cols: i32,
+    col_widths: *const f64,
+    row_height: f64,
+    font_size: f64,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let (rows, cols) = match (usize::try_from(rows), usize::try_from(cols)) {
+        (Ok(rows), Ok(cols)) if rows > 0 && cols > 0 => (rows, cols),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, "Rows and columns must be positive"),
+    };
+    if cells.is_null() || rows.checked_mul(cols) != Some(cell_count) {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            format!("Expected {} x {} cells but got {}", rows, cols, cell_count),
+        );
+    }
+    if col_widths.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Column widths are null");
+    }
+    let col_widths = std::slice::from_raw_parts(col_widths, cols);
+    if !col_widths.iter().all(|&w| w > 0.0) || !(row_height > 0.0 && font_size > 0.0) {
+        return fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            "Column widths, row height and font size must be positive",
+        );
+    }
+    let mut texts = Vec::with_capacity(cell_count);
+    for i in 0..cell_count {
+        let cell = *cells.add(i);
+        if cell.is_null() {
+            texts.push("");
+            continue;
+        }
+        match str_arg(cell) {
+            Some(t) => texts.push(t),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Cell {} is not valid UTF-8", i)),
+        }
+    }
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    // Center the text's ascent-to-descent span in each row.
+    let metrics = FontMetrics::for_standard14(Standard14Font::Helvetica);
+    let ascent = font_size * metrics.ascender as f64 / metrics.units_per_em as f64;
+    let descent = -font_size * metrics.descender as f64 / metrics.units_per_em as f64;
+    let baseline = (row_height - ascent - descent) / 2.0 + descent;
+
+    append_content(page, |c| {
+        let mut c = style.apply(c.save_state()).line_width(TABLE_BORDER_WIDTH);
+        for row in 0..rows {
+            let cell_y = y - (row + 1) as f64 * row_height;
+            let mut cell_x = x;
+            for &width in col_widths {
+                c = c.rect(cell_x, cell_y, width, row_height);
+                cell_x += width;
+            }
+        }
+        c = c.stroke();
+
+        for (i, text) in texts.iter().enumerate().filter(|(_, t)| !t.is_empty()) {
+            let (row, col) = (i / cols, i % cols);
+            let cell_x = x + col_widths[..col].iter().sum::<f64>();
+            let cell_y = y - (row + 1) as f64 * row_height;
+            c = c
+                .save_state()
+                .rect(
+                    cell_x + TABLE_CELL_PADDING,
+                    cell_y,
+                    (col_widths[col] - 2.0 * TABLE_CELL_PADDING).max(0.0),
+                    row_height,
+                )
+                .clip()
+                .end_path()
+                .text(
+                    DEFAULT_FONT_NAME,
+                    font_size,
+                    cell_x + TABLE_CELL_PADDING,
+                    cell_y + baseline,
+                    text,
+                )
+                .restore_state();
+        }
+        c.restore_state()
+    });
+    PDF_OK
+}
+
+/// Draw a list whose items flow down from (`x`, `y`), the top of the first
+/// line. `items` holds `item_count` strings; a null entry is an empty item.
+/// `list_style` is one of the `PDF_LIST_*` values. Each item wraps within `width`
+/// with a hanging indent, so continuation lines align with the text after the
+/// marker; numbers are right-aligned so their periods line up.
+/// Returns the y-coordinate below the last item, where following content can
+/// start, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `items` must point to `item_count` pointers, each null or a valid
+/// null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_list(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    items: *const *const c_char,
+    item_count: usize,
+    font_size: f64,
+    list_style: i32,
+) -> f64 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code as f64,
+    };
+    if items.is_null() && item_count > 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Items are null") as f64;
+    }
+    if !(font_size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Font size must be positive") as f64;
+    }
+    let mut texts = Vec::with_capacity(item_count);
+    for i in 0..item_count {
+        let item = *items.add(i);
+        if item.is_null() {
+            texts.push("");
+            continue;
+        }
+        match str_arg(item) {
+            Some(t) => texts.push(t),
+            None => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Item {} is not valid UTF-8", i)) as f64,
+        }
+    }
+    let markers: Vec<String> = match list_style {
+        PDF_LIST_BULLET => vec![String::new(); item_count],
+        PDF_LIST_DASH => vec!["-".to_string(); item_count],
+        PDF_LIST_NUMBERED => (1..=item_count).map(|n| format!("{}.", n)).collect(),
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown list style {}", list_style)) as f64,
+    };
+    let column = markers
+        .iter()
+        .map(|m| calculate_helvetica_width(m, font_size))
+        .fold(font_size, f64::max);
+    let indent = column + font_size * LIST_MARKER_GAP;
+    if !(width > indent) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "List width must leave room after the markers") as f64;
+    }
+    let style = pdf.style.clone();
+    let leading = pdf.leading.at(font_size);
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code as f64,
+    };
+
+    let metrics = FontMetrics::for_standard14(Standard14Font::Helvetica);
+    let ascent = font_size * metrics.ascender as f64 / metrics.units_per_em as f64;
+    let measure = {
+        let style = style.clone();
+        move |s: &str| calculate_helvetica_width(s, font_size) + style.spacing_width(s, true)
+    };
+    let mut top = y;
+    append_content(page, |c| {
+        style.wrap(c, |mut c| {
+            for (text, marker) in texts.iter().zip(&markers) {
+                let mut lines = wrap_text(text, width - indent, &measure);
+                if lines.is_empty() {
+                    lines.push(String::new());
+                }
+                let baseline = top - ascent;
+                c = if list_style == PDF_LIST_BULLET {
+                    // A dot centered on the x-height, in the middle of the marker column.
+                    let (cx, cy) = (x + column / 2.0, baseline + font_size * 0.26);
+                    c.graphics(GraphicsBuilder::new().filled_circle(cx, cy, font_size * 0.1))
+                } else {
+                    let marker_x = if list_style == PDF_LIST_NUMBERED {
+                        x + column - calculate_helvetica_width(marker, font_size)
+                    } else {
+                        x + (column - calculate_helvetica_width(marker, font_size)) / 2.0
+                    };
+                    c.text(DEFAULT_FONT_NAME, font_size, marker_x, baseline, marker)
+                };
+                c = c.text_block(text_lines(&lines, x + indent, top, font_size, leading));
+                top -= lines.len() as f64 * leading;
+            }
+            c
+        })
+    });
+    top
+}
+
+/// Lay out a small subset of HTML flowing down from (`x`, `y`), the top of the
+/// first line, wrapping within `width`. Paragraphs, `h1` to `h3`, `br`, `b`/`strong`,
+/// `i`/`em`, `ul`/`li` and `span` are understood, styled by inline `style` and
+/// `color` attributes; other tags are ignored but their text is kept. Body text
+/// uses the size set with `pdf_set_default_font` in Helvetica, the current fill
+/// color and the leading set with `pdf_set_leading`.
+/// Returns the y-coordinate below the last line, or a negative error code on
+/// failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `html` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_html(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    html: *const c_char,
+) -> f64 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code as f64,
+    };
+    let html = match str_arg(html) {
+        Some(html) => html,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Invalid HTML string") as f64,
+    };
+    if !(width > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Width must be positive") as f64;
+    }
+
+    #[cfg(feature = "html")]
+    {
+        use crate::html::{HtmlRenderer, HTML_FONTS};
+
+        let font_size = pdf.default_font.1;
+        let style = pdf.style.clone();
+        let renderer = HtmlRenderer::new()
+            .font_size(font_size)
+            .line_height(pdf.leading.at(font_size) / font_size)
+            .color(style.fill_color);
+        let page = match pdf.page_mut(page_index) {
+            Ok(page) => page,
+            Err(code) => return code as f64,
+        };
+        for (name, font) in HTML_FONTS {
+            if !page.fonts.iter().any(|(n, _)| n == name) {
+                page.add_font(name, font.into());
+            }
+        }
+        let (content, bottom) = renderer.render(html, x, y, width);
+        append_content(page, |c| style.wrap(c, |c| c.extend(content.operators().iter().cloned())));
+        bottom
+    }
+
+    #[cfg(not(feature = "html"))]
+    {
+        let _ = (&mut pdf, page_index, x, y, html);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"html\" feature") as f64
+    }
+}
+
+/// Draw a barcode as filled vector bars in the current fill color, scaled to a
+/// `width` by `height` box whose lower-left corner is (`x`, `y`). `symbology` is
+/// `PDF_BARCODE_CODE128`. Leave about ten bar widths of blank space on each side
+/// so scanners can find the code.
+/// Returns 0 on success, or a negative error code on failure (including data
+/// the symbology cannot encode).
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_barcode(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    width: f64,
+    height: f64,
+    data: *const c_char,
+    symbology: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let data = match str_arg(data) {
+        Some(d) => d,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Data is null or not valid UTF-8"),
+    };
+    if !(width > 0.0 && height > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Barcode width and height must be positive");
+    }
+    let symbology = match symbology {
+        PDF_BARCODE_CODE128 => Symbology::Code128,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown symbology {}", symbology)),
+    };
+    let barcode = match Barcode::new(symbology, data) {
+        Ok(barcode) => barcode,
+        Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, e.to_string()),
+    };
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| style.wrap(c, |c| c.barcode(&barcode, x, y, width, height)));
+    PDF_OK
+}
+
+/// Draw a QR code as filled vector modules in the current fill color, scaled so
+/// the code and its four-module quiet zone fill a `size` square whose lower-left
+/// corner is (`x`, `y`). `error_correction` is one of the `PDF_QR_ECC_*` levels.
+/// Returns 0 on success, `PDF_ERR_DATA_TOO_LONG` if the data exceeds the largest
+/// QR code at that level, or another negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `data` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_qrcode(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    x: f64,
+    y: f64,
+    size: f64,
+    data: *const c_char,
+    error_correction: i32,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let data = match str_arg(data) {
+        Some(d) => d,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Data is null or not valid UTF-8"),
+    };
+    if !(size > 0.0) {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "QR code size must be positive");
+    }
+    let ecc = match error_correction {
+        PDF_QR_ECC_LOW => QrErrorCorrection::Low,
+        PDF_QR_ECC_MEDIUM => QrErrorCorrection::Medium,
+        PDF_QR_ECC_QUARTILE => QrErrorCorrection::Quartile,
+        PDF_QR_ECC_HIGH => QrErrorCorrection::High,
+        _ => {
+            return fail(
+                PDF_ERR_INVALID_ARGUMENT,
+                format!("Unknown error correction level {}", error_correction),
+            )
+        }
+    };
+    let code = match QrCode::encode(data, ecc) {
+        Ok(code) => code,
+        Err(e) => return fail(PDF_ERR_DATA_TOO_LONG, e.to_string()),
+    };
+    let style = pdf.style.clone();
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+
+    append_content(page, |c| style.wrap(c, |c| c.qr_code(&code, x, y, size)));
+    PDF_OK
+}
+
+/// Draw printer's marks around a page's trim box, set with `pdf_set_page_box`.
+/// `marks` combines `PDF_MARKS_*` flags. The marks are drawn between the bleed
+/// box (or the trim box without one) and the media box, so the media box must
+/// be larger to leave room; marks are shrunk to the room there is.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_add_printer_marks(handle: *mut PdfHandle, page_index: i32, marks: i32) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if marks & !PDF_MARKS_ALL != 0 {
+        return fail(PDF_ERR_INVALID_ARGUMENT, format!("Unknown printer's marks {:#x}", marks & !PDF_MARKS_ALL));
+    }
+    let marks = PrinterMarks {
+        crop: marks & PDF_MARKS_CROP != 0,
+        registration: marks & PDF_MARKS_REGISTRATION != 0,
+        color_bar: marks & PDF_MARKS_COLOR_BAR != 0,
+    };
+    let page = match pdf.page_mut(page_index) {
+        Ok(page) => page,
+        Err(code) => return code,
+    };
+    let trim = match page.trim_box {
+        Some(trim) => trim,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "The page has no trim box; set one with pdf_set_page_box"),
+    };
+    let bleed = page.bleed_box.unwrap_or(trim);
+    let media = page.media_box;
+    if bleed == media {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "The media box leaves no room for marks outside the bleed box");
+    }
+
+    append_content(page, |c| c.printer_marks(marks, trim, bleed, media));
+    PDF_OK
+}
+
+/// Adapts a `PdfWriteCallback` to `io::Write`.
+struct CallbackWriter {
+    callback: PdfWriteCallback,
+    user_data: *mut c_void,
+    /// The nonzero value a callback returned, once it has aborted the write.
+    aborted: Option<i32>,
+}
+
+impl Write for CallbackWriter {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        if let Some(status) = self.aborted {
+            return Err(io::Error::new(
+                io::ErrorKind::Other,
+                format!("Write callback returned {}", status),
+            ));
+        }
+        if buf.is_empty() {
+            return Ok(0);
+        }
+        // SAFETY: the caller of `pdf_write_to_callback` guarantees the callback is valid.
+        let status = unsafe { (self.callback)(buf.as_ptr(), buf.len(), self.user_data) };
+        if status != 0 {
+            self.aborted = Some(status);
+            return self.write(buf);
+        }
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+/// Serialize the PDF, passing the output to `callback` in chunks as it is produced
+/// instead of building it in one buffer. `user_data` is passed through unchanged.
+/// If the callback returns nonzero, serialization stops and `PDF_ERR_ABORTED` is returned.
+/// A document being streamed with `pdf_begin_streaming` is rejected.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `callback` must be safe to call with `user_data` for the duration of this call.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_write_to_callback(
+    handle: *const PdfHandle,
+    callback: Option<PdfWriteCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let callback = match callback {
+        Some(callback) => callback,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Callback is null"),
+    };
+    if let Err(code) = pdf.check_not_streaming() {
+        return code;
+    }
+    if pdf.document.pages.is_empty() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, DocumentError::NoPages.to_string());
+    }
+
+    let mut writer = BufWriter::with_capacity(
+        CALLBACK_CHUNK_SIZE,
+        CallbackWriter {
+            callback,
+            user_data,
+            aborted: None,
+        },
+    );
+    // A signature covers the whole file, so signed output is built in memory
+    #[cfg(feature = "signatures")]
+    if pdf.signature.is_some() {
+        if let Err(code) = pdf.bytes() {
+            return code;
+        }
+    }
+    // Reuse output that is already serialized rather than building it again.
+    let result = match &*pdf.data.borrow() {
+        Some(data) => writer.write_all(data).map_err(PdfError::from),
+        None => pdf
+            .document
+            .write_with_progress(&mut writer, |fraction| pdf.report_progress(STAGE_SERIALIZE, fraction)),
+    };
+    let result = result.and_then(|()| writer.flush().map_err(PdfError::from));
+
+    match (result, writer.get_ref().aborted) {
+        (_, Some(status)) => fail(
+            PDF_ERR_ABORTED,
+            format!("Write callback returned {}", status),
+        ),
+        (Err(PdfError::Cancelled), None) => cancelled(),
+        (Err(e), None) => fail(
+            PDF_ERR_INVALID_ARGUMENT,
+            format!("Failed to serialize document: {}", e),
+        ),
+        (Ok(()), None) => {
+            pdf.report_progress(STAGE_SERIALIZE, 1.0);
+            PDF_OK
+        }
+    }
+}
+
+/// Set a callback that is told about the progress of long operations on the
+/// document: appending a PDF with `pdf_append_pdf` (stage "merge"), serializing
+/// it (stage "serialize") and rendering pages with `pdf_render_page_png` or
+/// `pdf_generate_thumbnails` (stage "render"). The callback receives the fraction done, from 0.0 to 1.0,
+/// the stage and `user_data`. Returning nonzero cancels the operation, which
+/// then returns `PDF_ERR_ABORTED` and leaves the document as it was: no pages
+/// are appended, nothing is cached and no image is returned. The call with 1.0
+/// comes once the operation has finished, so its return value is ignored.
+/// The callback must not use the handle. A null callback removes it.
+/// Returns 0 on success, or a negative error code on failure.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `callback` must be safe to call with `user_data` until it is replaced or
+/// the handle is freed.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_set_progress_callback(
+    handle: *mut PdfHandle,
+    callback: Option<PdfProgressCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    pdf.progress = callback.map(|callback| (callback, user_data));
+    PDF_OK
+}
+
+/// Records that a progress callback cancelled the operation and returns the error code.
+fn cancelled() -> i32 {
+    fail(PDF_ERR_ABORTED, "Cancelled by the progress callback")
+}
+
+/// Get the PDF data from a handle.
+/// Returns the length of the data, or 0 on failure.
+/// The data pointer is written to `out_data`.
+/// All pages are serialized in the order they were added.
+///
+/// The data is owned by the handle. It stays valid until the next call that
+/// changes the document (adds a page or content, or changes a document
+/// setting) or until `pdf_free`; use `pdf_get_data_copy` to keep it longer.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_data` must be a valid pointer to a `*const u8`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_get_data(
+    handle: *const PdfHandle,
+    out_data: *mut *const u8,
+) -> usize {
+    if out_data.is_null() {
+        set_last_error("Output pointer is null");
+        return 0;
+    }
+
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(_) => return 0,
+    };
+    let len = match pdf.bytes() {
+        Ok(data) => {
+            *out_data = data.as_ptr();
+            data.len()
+        }
+        Err(_) => 0,
+    };
+    len
+}
+
+/// Bytes before a buffer from `pdf_get_data_copy` that record its allocation
+/// size, so `pdf_free_buffer` needs only the pointer.
+const BUFFER_HEADER: usize = std::mem::size_of::<usize>();
+
+/// Get a copy of the PDF data that the caller owns.
+/// The copy is written to `out_data` and its length to `out_len`; it stays
+/// valid after the document changes or the handle is freed, and must be
+/// released with `pdf_free_buffer`.
+/// Returns 0 on success, or a negative error code on failure, leaving
+/// `out_data` null and `out_len` 0.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_data` must be a valid pointer to a `*mut u8` and `out_len` a valid
+/// pointer to a `usize`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_get_data_copy(
+    handle: *const PdfHandle,
+    out_data: *mut *mut u8,
+    out_len: *mut usize,
+) -> i32 {
+    if out_data.is_null() || out_len.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    *out_data = ptr::null_mut();
+    *out_len = 0;
+
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let data = match pdf.bytes() {
+        Ok(data) => data,
+        Err(code) => return code,
+    };
+
+    give_buffer(&data, out_data, out_len);
+    PDF_OK
+}
+
+/// Copies `data` into a buffer for `pdf_free_buffer` to release, writing it
+/// to `out_data` and its length to `out_len`.
+unsafe fn give_buffer(data: &[u8], out_data: *mut *mut u8, out_len: *mut usize) {
+    let size = BUFFER_HEADER + data.len();
+    let mut buffer = Vec::with_capacity(size);
+    buffer.extend_from_slice(&size.to_ne_bytes());
+    buffer.extend_from_slice(data);
+    let buffer = Box::into_raw(buffer.into_boxed_slice()) as *mut u8;
+    *out_data = buffer.add(BUFFER_HEADER);
+    *out_len = data.len();
+}
+
+/// Render a page to a PNG image at `dpi` pixels per inch (72 draws one
+/// pixel per point).
+/// The image is written to `out_data` and its length to `out_len`, and must
+/// be released with `pdf_free_buffer`. Standard-14 text is drawn as grey
+/// bars; form fields, links and transparency are not drawn.
+/// Returns 0 on success, or a negative error code on failure, leaving
+/// `out_data` null and `out_len` 0. Without the "render" feature this is
+/// `PDF_ERR_UNSUPPORTED`; `pdf_has_feature("render")` reports whether it is
+/// available.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_data` must be a valid pointer to a `*mut u8` and `out_len` a valid
+/// pointer to a `usize`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_render_page_png(
+    handle: *const PdfHandle,
+    page_index: i32,
+    dpi: f64,
+    out_data: *mut *mut u8,
+    out_len: *mut usize,
+) -> i32 {
+    if out_data.is_null() || out_len.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    *out_data = ptr::null_mut();
+    *out_len = 0;
+
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let page = match pdf.page_position(page_index) {
+        Ok(position) => &pdf.document.pages[position],
+        Err(code) => return code,
+    };
+
+    #[cfg(feature = "render")]
+    {
+        match crate::render::render_page_with_progress(page, dpi, |f| pdf.report_progress(STAGE_RENDER, f)) {
+            Ok(png) => {
+                give_buffer(&png, out_data, out_len);
+                pdf.report_progress(STAGE_RENDER, 1.0);
+                PDF_OK
+            }
+            Err(PdfError::Cancelled) => cancelled(),
+            Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to render page: {}", e)),
+        }
+    }
+
+    #[cfg(not(feature = "render"))]
+    {
+        let _ = (page, dpi);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"render\" feature")
+    }
+}
+
+/// Render every page as a thumbnail whose longer side is `max_dimension`
+/// pixels, and store it as the page's `/Thumb` for viewers to show in their
+/// page navigation pane. Each thumbnail adds about `max_dimension` squared
+/// times 1.5 bytes before compression, so 64 to 128 pixels is usual. Pages
+/// are drawn as by `pdf_render_page_png`, and pages already written by
+/// `pdf_begin_streaming` get none. Progress is reported as stage "render".
+/// Returns the number of thumbnails made, or a negative error code on
+/// failure, leaving every page unchanged. Without the "render" feature this
+/// is `PDF_ERR_UNSUPPORTED`; `pdf_has_feature("render")` reports whether it
+/// is available.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_generate_thumbnails(handle: *mut PdfHandle, max_dimension: i32) -> i32 {
+    #[cfg_attr(not(feature = "render"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let max_dimension = match u32::try_from(max_dimension) {
+        Ok(size) if size > 0 => size,
+        _ => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Invalid thumbnail size {}", max_dimension)),
+    };
+
+    #[cfg(feature = "render")]
+    {
+        let count = pdf.document.pages.len();
+        let mut thumbnails = Vec::with_capacity(count);
+        for (i, page) in pdf.document.pages.iter().enumerate() {
+            if !pdf.report_progress(STAGE_RENDER, i as f64 / count as f64) {
+                return cancelled();
+            }
+            match crate::render::render_thumbnail(page, max_dimension) {
+                Ok(thumbnail) => thumbnails.push(thumbnail),
+                Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to render page {}: {}", i, e)),
+            }
+        }
+        for (page, thumbnail) in pdf.document.pages.iter_mut().zip(thumbnails) {
+            page.thumbnail = Some(thumbnail);
+        }
+        pdf.data.get_mut().take();
+        pdf.report_progress(STAGE_RENDER, 1.0);
+        count.min(i32::MAX as usize) as i32
+    }
+
+    #[cfg(not(feature = "render"))]
+    {
+        let _ = (pdf, max_dimension);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"render\" feature")
+    }
+}
+
+/// Extract the text of a page as UTF-8, in the order the page shows it.
+/// Characters come from the fonts' ToUnicode maps or, for fonts without one,
+/// their single-byte encodings; text on a new baseline starts a new line.
+/// `out_text` receives the null-terminated text. The string is owned by the
+/// handle and stays valid until the next call to this function or `pdf_free`.
+/// Returns 0 on success, or a negative error code on failure. Without the
+/// "parser" feature this is `PDF_ERR_UNSUPPORTED`, as it is for an encrypted
+/// document.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `out_text` must be a valid pointer to a `*const c_char`.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_extract_text(
+    handle: *mut PdfHandle,
+    page_index: i32,
+    out_text: *mut *const c_char,
+) -> i32 {
+    if out_text.is_null() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "Output pointer is null");
+    }
+    *out_text = ptr::null();
+
+    #[cfg_attr(not(feature = "parser"), allow(unused_mut))]
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let count = pdf.document.pages.len();
+    let index = match usize::try_from(page_index) {
+        Ok(index) if index < count => index,
+        _ => {
+            return fail(
+                PDF_ERR_PAGE_OUT_OF_RANGE,
+                format!("Page index {} is out of range (page count is {})", page_index, count),
+            )
+        }
+    };
+
+    #[cfg(feature = "parser")]
+    {
+        use crate::error::ParserError;
+        use crate::parser::PdfReader;
+
+        let data = match pdf.bytes() {
+            Ok(data) => data.clone(),
+            Err(code) => return code,
+        };
+        let text = PdfReader::from_bytes(data).and_then(|reader| reader.extract_text(index));
+        let mut text = match text {
+            Ok(text) => text,
+            Err(PdfError::Parser(ParserError::EncryptedPdf)) => {
+                return fail(PDF_ERR_UNSUPPORTED, "Text cannot be extracted from an encrypted document")
+            }
+            Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to extract text: {}", e)),
+        };
+        text.retain(|c| c != '\0');
+        pdf.extracted_text = CString::new(text).unwrap_or_default();
+        *out_text = pdf.extracted_text.as_ptr();
+        PDF_OK
+    }
+
+    #[cfg(not(feature = "parser"))]
+    {
+        let _ = (pdf, index);
+        fail(PDF_ERR_UNSUPPORTED, "Built without the \"parser\" feature")
+    }
+}
+
+/// Free a buffer returned by `pdf_get_data_copy`, `pdf_render_page_png` or
+/// `pdf_sign_pdf`.
+///
+/// # Safety
+/// `data` must be a pointer returned by `pdf_get_data_copy`,
+/// `pdf_render_page_png` or `pdf_sign_pdf` that has not been freed yet, or
+/// null (which is safely ignored).
+#[no_mangle]
+pub unsafe extern "C" fn pdf_free_buffer(data: *mut u8) {
+    if data.is_null() {
+        return;
+    }
+    let buffer = data.sub(BUFFER_HEADER);
+    let size = usize::from_ne_bytes(*(buffer as *const [u8; BUFFER_HEADER]));
+    drop(Box::from_raw(ptr::slice_from_raw_parts_mut(buffer, size)));
+}
+
+/// Save the PDF to a file.
+/// Returns 0 on success or a negative error code on failure, such as
+/// `PDF_ERR_BUSY` if another call is using the handle or `PDF_ERR_ABORTED` if
+/// the progress callback cancels serialization; `pdf_last_error` describes
+/// the cause.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `path` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_save_to_file(
+    handle: *const PdfHandle,
+    path: *const c_char,
+) -> i32 {
+    save_to_file(handle, path, || false)
+}
+
+/// Save the PDF to a file like `pdf_save_to_file`, stopping if the int at
+/// `cancel_flag` becomes nonzero. Another thread may set the flag during the
+/// call. A cancelled save returns `PDF_ERR_CANCELLED` without writing to
+/// `path`, so no partial file is left and an existing file is unchanged. A
+/// null `cancel_flag` never cancels.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `path` must be a valid null-terminated C string.
+/// `cancel_flag` must be null or point to an int that stays valid for the
+/// duration of this call and that other threads only write atomically.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_save_to_file_cancellable(
+    handle: *const PdfHandle,
+    path: *const c_char,
+    cancel_flag: *const i32,
+) -> i32 {
+    // SAFETY: AtomicI32 has the same layout as i32, and the caller only
+    // writes the flag atomically.
+    let flag = (!cancel_flag.is_null()).then(|| &*(cancel_flag as *const AtomicI32));
+    save_to_file(handle, path, || flag.map_or(false, |flag| flag.load(Ordering::Relaxed) != 0))
+}
+
+/// Serializes the document and writes it to `path`, stopping with
+/// `PDF_ERR_CANCELLED` if `cancel` returns true before the file is written.
+unsafe fn save_to_file(handle: *const PdfHandle, path: *const c_char, cancel: impl Fn() -> bool) -> i32 {
+    let path_str = match str_arg(path) {
+        Some(s) => s,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Path is null or not valid UTF-8"),
+    };
+
+    let pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let data = match pdf.bytes_until(&cancel) {
+        Ok(data) => data,
+        Err(code) => return code,
+    };
+    // The file is only touched once the whole document is serialized
+    if cancel() {
+        return fail(PDF_ERR_CANCELLED, "Serialization was cancelled");
+    }
+
+    match std::fs::write(path_str, &*data) {
+        Ok(_) => 0,
+        Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to write '{}': {}", path_str, e)),
+    }
+}
+
+/// Start writing the document to `path` page by page instead of building it
+/// in memory. From now on a page is written to the file and dropped as soon
+/// as the next one is added, so memory use stays about the same however many
+/// pages there are. The objects of an appended file are freed once its last
+/// page has been written. Only the last page can still be drawn on; earlier page
+/// indices fail with `PDF_ERR_PAGE_OUT_OF_RANGE`, and moving or deleting
+/// pages fails with `PDF_ERR_UNSUPPORTED`. `pdf_get_data` and the other
+/// functions that need the whole document fail until
+/// `pdf_finalize_streaming` is called.
+///
+/// Settings that need every page at once, such as page numbers, tagging,
+/// PDF/A, linearization and `pdf_optimize`, cannot be used, and other
+/// document settings must be made before this call.
+///
+/// Returns 0 on success or -1 if the file cannot be created, the document is
+/// already being streamed or uses such a setting.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+/// `path` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_begin_streaming(handle: *mut PdfHandle, path: *const c_char) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    let path = match str_arg(path) {
+        Some(path) => path,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Path is null or not valid UTF-8"),
+    };
+    if pdf.streaming.is_some() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "The document is already being streamed");
+    }
+    let file = match File::create(path) {
+        Ok(file) => file,
+        Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to create '{}': {}", path, e)),
+    };
+    match StreamingWriter::new(BufWriter::new(file), &pdf.document) {
+        Ok(writer) => pdf.streaming = Some(writer),
+        Err(e) => return fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to start streaming: {}", e)),
+    }
+    pdf.data.get_mut().take();
+    match pdf.write_streamed_pages(1) {
+        Ok(()) => PDF_OK,
+        Err(code) => code,
+    }
+}
+
+/// Write the remaining pages and the rest of the document to the file given
+/// to `pdf_begin_streaming`, and close it. The handle is left without pages
+/// and can be freed or used like a new document.
+///
+/// Returns 0 on success or -1 if the document is not being streamed or the
+/// file cannot be written.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_finalize_streaming(handle: *mut PdfHandle) -> i32 {
+    let mut pdf = match handle_mut(handle) {
+        Ok(pdf) => pdf,
+        Err(code) => return code,
+    };
+    if pdf.streaming.is_none() {
+        return fail(PDF_ERR_INVALID_ARGUMENT, "The document is not being streamed");
+    }
+    let written = pdf.write_streamed_pages(0);
+    let writer = match pdf.streaming.take() {
+        Some(writer) => writer,
+        None => return PDF_ERR_INVALID_ARGUMENT,
+    };
+    if let Err(code) = written {
+        return code;
+    }
+    let result = writer
+        .finish(&pdf.document)
+        .and_then(|mut file| file.flush().map_err(PdfError::from));
+    match result {
+        Ok(()) => PDF_OK,
+        Err(e) => fail(PDF_ERR_INVALID_ARGUMENT, format!("Failed to finish the streamed file: {}", e)),
+    }
+}
+
+/// Get a message describing the most recent failed call on the calling thread.
+/// Returns null if no call has failed since the last `pdf_clear_error`.
+/// Successful calls do not clear the message, though a few record a warning,
+/// such as `pdf_add_named_destination` replacing a destination.
+///
+/// The returned string is owned by the library and stays valid until the next
+/// failed call or `pdf_clear_error` on the same thread.
+#[no_mangle]
+pub extern "C" fn pdf_last_error() -> *const c_char {
+    LAST_ERROR.with(|last| match &*last.borrow() {
+        Some(message) => message.as_ptr(),
+        None => ptr::null(),
+    })
+}
+
+/// Clear the calling thread's last error message.
+#[no_mangle]
+pub extern "C" fn pdf_clear_error() {
+    LAST_ERROR.with(|last| last.borrow_mut().take());
+}
+
+/// Free a PDF handle.
+/// A handle that was already freed, or is in use by another call, is left
+/// alone and reported through `pdf_last_error`.
+///
+/// # Safety
+/// `handle` must be a valid pointer returned by `pdf_create_*` functions or
+/// `pdf_clone`, or null (which is safely ignored). No other call may be using
+/// the handle.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_free(handle: *mut PdfHandle) {
+    if handle.is_null() {
+        return;
+    }
+    if !is_live(handle) {
+        set_last_error("pdf_free was given a handle that is not live; it may already have been freed");
+        return;
+    }
+    if (*handle).busy.load(Ordering::Acquire) {
+        set_last_error("pdf_free was given a handle that is in use by another call");
+        return;
+    }
+    ptr::addr_of_mut!((*handle).tag).write_volatile(0);
+    drop(Box::from_raw(handle));
+}
+
+/// Get the library version as a string.
+/// The returned string is statically allocated and should not be freed.
+#[no_mangle]
+pub extern "C" fn pdf_version() -> *const c_char {
+    static VERSION: &[u8] = b"0.1.0\0";
     VERSION.as_ptr() as *const c_char
 }
+
+/// Optional features and whether this build includes them.
+const FEATURES: [(&str, bool); 8] = [
+    ("compression", cfg!(feature = "compression")),
+    ("images", cfg!(feature = "images")),
+    ("parser", cfg!(feature = "parser")),
+    ("encryption", cfg!(feature = "encryption")),
+    ("signatures", cfg!(feature = "signatures")),
+    ("render", cfg!(feature = "render")),
+    ("html", cfg!(feature = "html")),
+    ("office", cfg!(feature = "office")),
+];
+
+/// Check whether the library was built with an optional feature, such as
+/// "render" for `pdf_render_page_png` or "encryption" for `pdf_set_encryption`.
+/// Returns 1 if it was, 0 if it was not or the name is unknown, or
+/// `PDF_ERR_INVALID_ARGUMENT` if `name` is null or not valid UTF-8.
+///
+/// # Safety
+/// `name` must be a valid null-terminated C string.
+#[no_mangle]
+pub unsafe extern "C" fn pdf_has_feature(name: *const c_char) -> i32 {
+    let name = match str_arg(name) {
+        Some(name) => name,
+        None => return fail(PDF_ERR_INVALID_ARGUMENT, "Feature name is null or not valid UTF-8"),
+    };
+    FEATURES.iter().any(|&(feature, enabled)| enabled && feature == name) as i32
+}
+
+/// Describe the build: the library version, the optional features it
+/// includes and whether it is a debug or release build, for example
+/// `rust-pdf 0.1.0 (features: compression, render; profile: release)`.
+/// The returned string is statically allocated and should not be freed.
+#[no_mangle]
+pub extern "C" fn pdf_build_info() -> *const c_char {
+    static INFO: OnceLock<CString> = OnceLock::new();
+    INFO.get_or_init(|| {
+        let features: Vec<&str> = FEATURES.iter().filter(|f| f.1).map(|f| f.0).collect();
+        let features = if features.is_empty() { "none".to_string() } else { features.join(", ") };
+        let profile = if cfg!(debug_assertions) { "debug" } else { "release" };
+        let info = format!(
+            "rust-pdf {} (features: {}; profile: {})",
+            env!("CARGO_PKG_VERSION"),
+            features,
+            profile
+        );
+        CString::new(info).unwrap_or_default()
+    })
+    .as_ptr()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::ffi::CString;
+
+    unsafe fn output(handle: *const PdfHandle) -> String {
+        let mut data: *const u8 = ptr::null();
+        let len = pdf_get_data(handle, &mut data);
+        assert!(len > 0);
+        String::from_utf8_lossy(std::slice::from_raw_parts(data, len)).into_owned()
+    }
+
+    unsafe fn page_ops(handle: *const PdfHandle, index: usize) -> String {
+        let pdf = &*handle;
+        pdf.document.pages[index].content.build_string()
+    }
+
+    #[test]
+    fn test_create_simple() {
+        let text = CString::new("Hello").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert!(!pdf.is_null());
+            assert!(output(pdf).contains("(Hello) Tj"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_create_simple_defaults_to_letter() {
+        let text = CString::new("Hello").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert!(output(pdf).contains("/MediaBox [0 0 612 792]"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_create_simple_sized() {
+        let text = CString::new("Hello").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple_sized(text.as_ptr(), 12.0, PdfPageSize::A4 as i32);
+            assert!(output(pdf).contains("/MediaBox [0 0 595.28 841.89]"));
+            pdf_free(pdf);
+
+            let pdf = pdf_create_simple_sized(text.as_ptr(), 12.0, PdfPageSize::Legal as i32);
+            assert!(output(pdf).contains("/MediaBox [0 0 612 1008]"));
+            pdf_free(pdf);
+
+            assert!(pdf_create_simple_sized(text.as_ptr(), 12.0, 99).is_null());
+        }
+    }
+
+    #[test]
+    fn test_create_multipage() {
+        let first = CString::new("Certificate 1").unwrap();
+        let second = CString::new("Certificate 2").unwrap();
+        let texts = [first.as_ptr(), ptr::null(), second.as_ptr()];
+        unsafe {
+            let pdf = pdf_create_multipage(texts.as_ptr(), texts.len(), 14.0);
+            assert!(!pdf.is_null());
+            assert_eq!((*pdf).document.page_count(), 3);
+            assert!(page_ops(pdf, 0).contains("/F1 14 Tf\n72 720 Td\n(Certificate 1) Tj"));
+            assert!(page_ops(pdf, 1).is_empty());
+            assert!(page_ops(pdf, 2).contains("(Certificate 2) Tj"));
+            pdf_free(pdf);
+
+            let pdf = pdf_create_multipage(ptr::null(), 0, 12.0);
+            assert_eq!((*pdf).document.page_count(), 0);
+            pdf_free(pdf);
+
+            assert!(pdf_create_multipage(ptr::null(), 2, 12.0).is_null());
+            let invalid = [b"\xff\0".as_ptr() as *const c_char];
+            assert!(pdf_create_multipage(invalid.as_ptr(), 1, 12.0).is_null());
+            assert_eq!(last_error().as_deref(), Some("Text 0 is not valid UTF-8"));
+        }
+    }
+
+    #[test]
+    fn test_page_size_from_raw() {
+        assert_eq!(PdfPageSize::from_raw(0), Some(PdfPageSize::Letter));
+        assert_eq!(PdfPageSize::from_raw(4), Some(PdfPageSize::A5));
+        assert_eq!(PdfPageSize::from_raw(-1), None);
+        assert_eq!(PdfPageSize::from_raw(5), None);
+    }
+
+    #[test]
+    fn test_multi_page() {
+        let first = CString::new("First").unwrap();
+        let second = CString::new("Second").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_add_page(pdf, 0.0, 0.0), 0);
+            assert_eq!(pdf_add_page(pdf, 595.0, 842.0), 1);
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 700.0, first.as_ptr(), 12.0), PDF_OK);
+            assert_eq!(pdf_add_text(pdf, 1, 72.0, 700.0, second.as_ptr(), 12.0), PDF_OK);
+
+            let content = output(pdf);
+            assert!(content.contains("/Count 2"));
+            assert!(content.contains("/MediaBox [0 0 612 792]"));
+            assert!(content.contains("/MediaBox [0 0 595 842]"));
+            assert!(content.find("(First) Tj").unwrap() < content.find("(Second) Tj").unwrap());
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_page_out_of_range() {
+        let text = CString::new("Orphan").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text(pdf, -1, 72.0, 700.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_text(pdf, 1, 72.0, 700.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_rejects_bad_font_size() {
+        let text = CString::new("Sized").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            for size in [-12.0, f64::NAN, f64::INFINITY, f64::NEG_INFINITY] {
+                assert_eq!(pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), size), PDF_ERR_INVALID_ARGUMENT);
+                assert_eq!(last_error().as_deref(), Some("Font size must be positive"));
+            }
+            assert!(!page_ops(pdf, 0).contains("Tf"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_orientation_applies_to_new_pages() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_orientation(pdf, PDF_ORIENTATION_LANDSCAPE), PDF_OK);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_orientation(pdf, 7), PDF_ERR_INVALID_ARGUMENT);
+
+            let pages = &(*pdf).document.pages;
+            assert_eq!((pages[0].width(), pages[0].height()), (612.0, 792.0));
+            assert_eq!((pages[1].width(), pages[1].height()), (792.0, 612.0));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_page_orientation_is_idempotent() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            for _ in 0..2 {
+                assert_eq!(pdf_set_page_orientation(pdf, 0, PDF_ORIENTATION_LANDSCAPE), PDF_OK);
+                assert!(output(pdf).contains("/MediaBox [0 0 792 612]"));
+            }
+            assert_eq!(pdf_set_page_orientation(pdf, 0, PDF_ORIENTATION_PORTRAIT), PDF_OK);
+            assert!(output(pdf).contains("/MediaBox [0 0 612 792]"));
+            assert_eq!(pdf_set_page_orientation(pdf, 3, PDF_ORIENTATION_PORTRAIT), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_page_rotation() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert!(!output(pdf).contains("/Rotate"));
+            assert_eq!(pdf_set_page_rotation(pdf, 0, -90), PDF_OK);
+            assert!(output(pdf).contains("/Rotate 270"));
+            // The coordinates stay those of the unrotated page
+            assert!(output(pdf).contains("/MediaBox [0 0 612 792]"));
+            assert_eq!(pdf_set_page_rotation(pdf, 0, 720), PDF_OK);
+            assert!(!output(pdf).contains("/Rotate"));
+
+            assert_eq!(pdf_set_page_rotation(pdf, 0, 45), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("multiple of 90"));
+            assert_eq!(pdf_set_page_rotation(pdf, 1, 90), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    /// A 2x1 red RGB PNG.
+    #[cfg(feature = "images")]
+    const TEST_PNG: [u8; 70] = [
+        0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A, 0x00, 0x00, 0x00, 0x0D, 0x49, 0x48,
+        0x44, 0x52, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x08, 0x02, 0x00, 0x00,
+        0x00, 0x7B, 0x40, 0xE8, 0xDD, 0x00, 0x00, 0x00, 0x0D, 0x49, 0x44, 0x41, 0x54, 0x78,
+        0x9C, 0x63, 0xF8, 0xCF, 0xC0, 0x00, 0x44, 0x00, 0x08, 0xFE, 0x01, 0xFF, 0xC6, 0x9E,
+        0x79, 0xF7, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4E, 0x44, 0xAE, 0x42, 0x60, 0x82,
+    ];
+
+    #[cfg(feature = "images")]
+    #[test]
+    fn test_add_image_keeps_aspect_ratio() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_add_image(pdf, 0, TEST_PNG.as_ptr(), TEST_PNG.len(), 72.0, 600.0, 100.0, 0.0);
+            assert_eq!(result, PDF_OK);
+
+            let content = output(pdf);
+            assert!(content.contains("/XObject << /Im1"));
+            assert!(content.contains("100 0 0 50 72 600 cm"));
+            assert!(content.contains("/Im1 Do"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "images")]
+    #[test]
+    fn test_add_cmyk_image() {
+        let adobe = crate::image::test_jpeg_header(0xC0, 4, true);
+        let rgb = crate::image::test_jpeg_header(0xC0, 3, false);
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_image(pdf, 0, adobe.as_ptr(), adobe.len(), 0.0, 0.0, 0.0, 0.0), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/ColorSpace /DeviceCMYK"));
+            assert!(content.contains("/Decode [1 0 1 0 1 0 1 0]"));
+
+            let plain = pdf_create_empty();
+            pdf_set_compression(plain, 0, 0);
+            pdf_add_page(plain, 0.0, 0.0);
+            assert_eq!(pdf_add_image_cmyk(plain, 0, adobe.as_ptr(), adobe.len(), 0.0, 0.0, 30.0, 0.0, 0), PDF_OK);
+            let content = output(plain);
+            assert!(content.contains("/ColorSpace /DeviceCMYK"));
+            assert!(!content.contains("/Decode"));
+            assert!(content.contains("30 0 0 20 0 0 cm"));
+
+            let result = pdf_add_image_cmyk(plain, 0, rgb.as_ptr(), rgb.len(), 0.0, 0.0, 0.0, 0.0, 1);
+            assert_eq!(result, PDF_ERR_INVALID_IMAGE);
+            assert!(last_error().unwrap().contains("CMYK"));
+            pdf_free(plain);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_image_rejects_unknown_data() {
+        let garbage = [0u8, 1, 2, 3, 4, 5, 6, 7];
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_add_image(pdf, 0, garbage.as_ptr(), garbage.len(), 0.0, 0.0, 10.0, 10.0);
+            if cfg!(feature = "images") {
+                assert_eq!(result, PDF_ERR_INVALID_IMAGE);
+            } else {
+                assert_eq!(result, PDF_ERR_UNSUPPORTED);
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_with_font_embeds_subset() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("A\u{416}").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(font_id, 0);
+
+            let result = pdf_add_text_with_font(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0, font_id);
+            assert_eq!(result, PDF_OK);
+            assert!(page_ops(pdf, 0).contains("/TT1 12 Tf"));
+            assert!(page_ops(pdf, 0).contains("<00010003> Tj"));
+
+            let content = output(pdf);
+            assert!(content.contains("/Subtype /Type0"));
+            assert!(content.contains("/FontFile2"));
+            assert!(content.contains("/W [0 [500 600] 3 [700]]"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_load_font_errors() {
+        let font_data = crate::font::test_font_bytes();
+        let garbage = [0u8; 64];
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("A").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(
+                pdf_load_font(pdf, garbage.as_ptr(), garbage.len(), name.as_ptr()),
+                PDF_ERR_INVALID_FONT
+            );
+            assert_eq!(pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr()), 0);
+            assert_eq!(
+                pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr()),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                pdf_add_text_with_font(pdf, 0, 0.0, 0.0, text.as_ptr(), 12.0, 1),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                pdf_add_text_with_font(pdf, 1, 0.0, 0.0, text.as_ptr(), 12.0, 0),
+                PDF_ERR_PAGE_OUT_OF_RANGE
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_fallback_fonts() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let mixed = CString::new("Hi \u{416}!\u{1F600}").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(pdf_register_fallback_font(pdf, 3), PDF_ERR_INVALID_ARGUMENT);
+
+            // Without a fallback neither the Cyrillic letter nor the emoji is covered
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 700.0, mixed.as_ptr(), 12.0), 2);
+
+            assert_eq!(pdf_register_fallback_font(pdf, font_id), PDF_OK);
+            assert_eq!(pdf_register_fallback_font(pdf, font_id), PDF_OK);
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 680.0, mixed.as_ptr(), 12.0), 1);
+            let ops = page_ops(pdf, 0);
+            let block = &ops[ops.find("72 680 Td").unwrap()..];
+            assert!(block.starts_with("72 680 Td\n(Hi ) Tj\n/TT1 12 Tf\n<0003> Tj\n/F1 12 Tf\n(!\u{1F600}) Tj\nET"));
+            assert!(ops.contains("/F1 12 Tf\n72 680 Td"));
+
+            // A character the primary font covers is not taken from a fallback
+            let plain = CString::new("AB").unwrap();
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 660.0, plain.as_ptr(), 12.0), 0);
+            assert!(page_ops(pdf, 0).contains("72 660 Td\n(AB) Tj\nET"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_default_font() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("A").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 0.0);
+            assert!(page_ops(pdf, 0).contains("/F1 12 Tf\n72 700 Td\n(A) Tj"));
+
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(pdf_set_default_font(pdf, font_id + 1, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_default_font(pdf, font_id, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_default_font(pdf, font_id, 10.0), PDF_OK);
+            pdf_add_text(pdf, 0, 72.0, 680.0, text.as_ptr(), 0.0);
+            assert!(page_ops(pdf, 0).contains("/TT1 10 Tf\n72 680 Td\n<0001> Tj"));
+
+            // An explicit size or font still applies to a single call
+            pdf_add_text(pdf, 0, 72.0, 660.0, text.as_ptr(), 14.0);
+            assert!(page_ops(pdf, 0).contains("/TT1 14 Tf\n72 660 Td"));
+            assert_eq!(pdf_set_default_font(pdf, PDF_FONT_DEFAULT, 9.0), PDF_OK);
+            pdf_add_text_with_font(pdf, 0, 72.0, 640.0, text.as_ptr(), 0.0, font_id);
+            assert!(page_ops(pdf, 0).contains("/TT1 9 Tf\n72 640 Td"));
+            pdf_add_text_rtl(pdf, 0, 300.0, 630.0, text.as_ptr(), 0.0, font_id);
+            let width = pdf_measure_text(pdf, text.as_ptr(), 9.0, font_id);
+            assert!(page_ops(pdf, 0).contains(&format!("/TT1 9 Tf\n{} 630 Td\n<0001> Tj", 300.0 - width)));
+            for size in [-9.0, f64::NAN] {
+                let result = pdf_add_text_with_font(pdf, 0, 0.0, 0.0, text.as_ptr(), size, font_id);
+                assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+                assert_eq!(pdf_add_text_rtl(pdf, 0, 0.0, 0.0, text.as_ptr(), size, font_id), PDF_ERR_INVALID_ARGUMENT);
+            }
+            pdf_add_text(pdf, 0, 72.0, 620.0, text.as_ptr(), 0.0);
+            assert!(page_ops(pdf, 0).contains("/F1 9 Tf\n72 620 Td\n(A) Tj"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_rtl() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        // A Hebrew letter followed by a Latin one: the Latin letter is drawn first
+        let text = CString::new("\u{5D0}A").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text_rtl(pdf, 0, 300.0, 700.0, text.as_ptr(), 12.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+
+            assert_eq!(pdf_add_text_rtl(pdf, 0, 300.0, 700.0, text.as_ptr(), 12.0, font_id), 1);
+            let width = pdf_measure_text(pdf, text.as_ptr(), 12.0, font_id);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains(&format!("{} 700 Td\n<00010000> Tj", 300.0 - width)));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_vertical() {
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("AB\nA").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text_vertical(pdf, 0, 300.0, 700.0, text.as_ptr(), 10.0, 0), PDF_ERR_INVALID_ARGUMENT);
+
+            // Without vertical metrics each glyph advances one em
+            let font_data = crate::font::test_font_bytes();
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(pdf_add_text_vertical(pdf, 0, 300.0, 700.0, text.as_ptr(), 10.0, font_id), 0);
+            let ops = page_ops(pdf, 0);
+            // "A" is 6 points wide and the ascender 8 points high
+            assert!(ops.contains("1 0 0 1 297 692 Tm\n<0001> Tj"));
+            assert!(ops.contains("1 0 0 1 297.25 682 Tm\n<0002> Tj"));
+            // The second line is a column to the left
+            assert!(ops.contains("1 0 0 1 285 692 Tm\n<0001> Tj"));
+
+            let font_data = crate::font::vertical_test_font_bytes();
+            let vertical = CString::new("TestSansVertical").unwrap();
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), vertical.as_ptr());
+            assert_eq!(pdf_add_text_vertical(pdf, 0, 100.0, 700.0, text.as_ptr(), 10.0, font_id), 0);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("1 0 0 1 97.25 683 Tm\n<0002> Tj"));
+            let result = pdf_add_text_vertical(pdf, 0, 100.0, 700.0, text.as_ptr(), -10.0, font_id);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_decorated() {
+        let text = CString::new("Deleted").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let both = PDF_DECORATION_UNDERLINE | PDF_DECORATION_STRIKETHROUGH;
+            assert_eq!(pdf_add_text_decorated(pdf, 0, 72.0, 700.0, text.as_ptr(), 10.0, both), 0);
+            let width = pdf_measure_text(pdf, text.as_ptr(), 10.0, PDF_FONT_DEFAULT);
+            let ops = page_ops(pdf, 0);
+            // Helvetica's underline is 0.5 points thick, centered 1 point below the baseline
+            let lines = format!("72 698.75 {} 0.5 re\nf\n72 702.25 {} 0.5 re\nf\nBT", width, width);
+            assert!(ops.starts_with(&lines));
+            assert!(ops.contains("(Deleted) Tj"));
+
+            assert_eq!(pdf_add_text_decorated(pdf, 0, 72.0, 680.0, text.as_ptr(), 10.0, 4), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_text_decorated(pdf, 1, 72.0, 680.0, text.as_ptr(), 10.0, 1), PDF_ERR_PAGE_OUT_OF_RANGE);
+            // A bad size leaves no lines behind
+            for size in [-10.0, f64::NAN, f64::INFINITY] {
+                let result = pdf_add_text_decorated(pdf, 0, 72.0, 680.0, text.as_ptr(), size, both);
+                assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            }
+            assert_eq!(page_ops(pdf, 0), ops);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_number_and_currency() {
+        let comma = CString::new(",").unwrap();
+        let dot = CString::new(".").unwrap();
+        let dollar = CString::new("$").unwrap();
+        let euro = CString::new("EUR").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let (comma, dot) = (comma.as_ptr(), dot.as_ptr());
+            let parentheses = PDF_NUMBER_PARENTHESES;
+            assert_eq!(pdf_add_number(pdf, 0, 300.0, 700.0, -1234.5, 2, comma, dot, 10.0, parentheses), 0);
+            let ops = page_ops(pdf, 0);
+            // Right-aligned: "(1,234.50)" is 45.58 points wide in Helvetica
+            assert!(ops.contains("254.42 700 Td\n(\\(1,234.50\\)) Tj"));
+
+            // Null separators leave the digits ungrouped around a point
+            assert_eq!(pdf_add_number(pdf, 0, 300.0, 680.0, 98765.4321, 1, ptr::null(), ptr::null(), 10.0, 0), 0);
+            assert!(page_ops(pdf, 0).contains("(98765.4) Tj"));
+
+            assert_eq!(pdf_add_currency(pdf, 0, 300.0, 660.0, 1234.5, 2, comma, dot, dollar.as_ptr(), 10.0, 0), 0);
+            assert!(page_ops(pdf, 0).contains("($1,234.50) Tj"));
+            let flags = PDF_NUMBER_SYMBOL_AFTER;
+            assert_eq!(pdf_add_currency(pdf, 0, 300.0, 640.0, -0.5, 2, dot, comma, euro.as_ptr(), 10.0, flags), 0);
+            assert!(page_ops(pdf, 0).contains("(-0,50 EUR) Tj"));
+
+            assert_eq!(pdf_add_number(pdf, 0, 300.0, 620.0, f64::NAN, 2, ptr::null(), ptr::null(), 10.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_number(pdf, 0, 300.0, 620.0, 1.0, 16, ptr::null(), ptr::null(), 10.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_number(pdf, 0, 300.0, 620.0, 1.0, 2, ptr::null(), ptr::null(), 10.0, flags), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_currency(pdf, 0, 300.0, 620.0, 1.0, 2, ptr::null(), ptr::null(), ptr::null(), 10.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_number(pdf, 1, 300.0, 620.0, 1.0, 2, ptr::null(), ptr::null(), 10.0, 0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            for size in [-10.0, f64::NAN, f64::INFINITY] {
+                let result = pdf_add_currency(pdf, 0, 300.0, 620.0, 1.0, 2, comma, dot, dollar.as_ptr(), size, 0);
+                assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_clone_is_independent() {
+        let text = CString::new("Original").unwrap();
+        let extra = CString::new("Clone only").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            pdf_set_compression(pdf, 0, 0);
+            pdf_set_fill_color(pdf, 1.0, 0.0, 0.0);
+            let copy = pdf_clone(pdf);
+            assert!(!copy.is_null());
+            pdf_free(pdf);
+
+            assert_eq!(pdf_add_text(copy, 0, 72.0, 500.0, extra.as_ptr(), 12.0), PDF_OK);
+            let content = output(copy);
+            assert!(content.contains("(Original) Tj"));
+            assert!(content.contains("1 0 0 rg"));
+            assert!(content.contains("(Clone only) Tj"));
+            pdf_free(copy);
+        }
+    }
+
+    #[test]
+    fn test_busy_handle_is_rejected() {
+        let text = CString::new("Hello").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let guard = handle_mut(pdf).unwrap();
+            assert_eq!(pdf_add_text(pdf, 0, 0.0, 0.0, text.as_ptr(), 12.0), PDF_ERR_BUSY);
+            assert!(pdf_clone(pdf).is_null());
+            let path = CString::new("/nonexistent/busy.pdf").unwrap();
+            assert_eq!(pdf_save_to_file(pdf, path.as_ptr()), PDF_ERR_BUSY);
+            assert_eq!(pdf_save_to_file_cancellable(pdf, path.as_ptr(), ptr::null()), PDF_ERR_BUSY);
+            drop(guard);
+            assert_eq!(pdf_add_text(pdf, 0, 0.0, 0.0, text.as_ptr(), 12.0), PDF_OK);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_clones_on_separate_threads() {
+        let text = CString::new("Template").unwrap();
+        let template = unsafe { pdf_create_simple(text.as_ptr(), 12.0) };
+        unsafe { pdf_set_compression(template, 0, 0) };
+        let copies: Vec<usize> = (0..4)
+            .map(|_| unsafe { pdf_clone(template) } as usize)
+            .collect();
+        unsafe { pdf_free(template) };
+
+        let threads: Vec<_> = copies
+            .into_iter()
+            .enumerate()
+            .map(|(i, copy)| {
+                std::thread::spawn(move || unsafe {
+                    let pdf = copy as *mut PdfHandle;
+                    let text = CString::new(format!("Worker {}", i)).unwrap();
+                    assert_eq!(pdf_add_text(pdf, 0, 72.0, 600.0, text.as_ptr(), 12.0), PDF_OK);
+                    let content = output(pdf);
+                    pdf_free(pdf);
+                    content
+                })
+            })
+            .collect();
+
+        for (i, thread) in threads.into_iter().enumerate() {
+            let content = thread.join().unwrap();
+            assert!(content.contains("(Template) Tj"));
+            assert!(content.contains(&format!("(Worker {}) Tj", i)));
+        }
+    }
+
+    unsafe fn last_error() -> Option<String> {
+        let message = pdf_last_error();
+        if message.is_null() {
+            None
+        } else {
+            Some(CStr::from_ptr(message).to_string_lossy().into_owned())
+        }
+    }
+
+    #[test]
+    fn test_last_error_describes_failure() {
+        let text = CString::new("Hello").unwrap();
+        let path = CString::new("/nonexistent-dir/out.pdf").unwrap();
+        unsafe {
+            pdf_clear_error();
+            assert_eq!(last_error(), None);
+
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert_eq!(pdf_add_text(pdf, 3, 0.0, 0.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(
+                last_error().as_deref(),
+                Some("Page index 3 is out of range (page count is 1)")
+            );
+
+            // Successful calls leave the message in place.
+            assert_eq!(pdf_add_text(pdf, 0, 0.0, 0.0, text.as_ptr(), 12.0), PDF_OK);
+            assert!(last_error().is_some());
+
+            assert_eq!(pdf_save_to_file(pdf, path.as_ptr()), -1);
+            assert!(last_error().unwrap().starts_with("Failed to write '/nonexistent-dir/out.pdf': "));
+
+            pdf_clear_error();
+            assert_eq!(last_error(), None);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_last_error_is_per_thread() {
+        unsafe {
+            pdf_clear_error();
+            std::thread::spawn(|| {
+                assert_eq!(pdf_add_page(ptr::null_mut(), 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+                assert_eq!(last_error().as_deref(), Some("Handle is null"));
+            })
+            .join()
+            .unwrap();
+            assert_eq!(last_error(), None);
+        }
+    }
+
+    #[test]
+    fn test_add_text_box_wraps_and_reports_overflow() {
+        let text = CString::new("The quick brown fox jumps over the lazy dog\nSecond paragraph").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+
+            // 10pt Helvetica: "The quick brown fox" is 88.9pt wide; three lines fit in 36pt.
+            let overflow = pdf_add_text_box(pdf, 0, 72.0, 600.0, 100.0, 36.0, text.as_ptr(), 10.0);
+            assert_eq!(overflow, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("12 TL"));
+            assert!(ops.contains("72 628.82 Td"));
+            assert!(ops.contains("(The quick brown fox) Tj"));
+            assert!(ops.contains("(jumps over the lazy) '"));
+            assert!(ops.contains("(dog) '"));
+            assert!(!ops.contains("Second paragraph"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_flowing_text() {
+        let text = CString::new("one\ntwo\nthree\nfour\nfive\x0Csix").unwrap();
+        let breaks = CString::new("seven\x0C\x0Ceight").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 200.0, 200.0);
+
+            // One-inch margins leave 56pt, room for four 10pt lines.
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 10.0), 3);
+            let first = page_ops(pdf, 0);
+            assert!(first.contains("72 120.82 Td\n(one) Tj"));
+            assert!(first.contains("(four) '"));
+            assert!(!first.contains("five"));
+            assert!(page_ops(pdf, 1).contains("(five) Tj"));
+            assert!(page_ops(pdf, 2).contains("(six) Tj"));
+            assert_eq!((&(*pdf).document.pages)[2].media_box, Rectangle::from_dimensions(200.0, 200.0));
+
+            // Flow continues on the last page; consecutive form feeds leave a blank page.
+            assert_eq!(pdf_add_flowing_text(pdf, breaks.as_ptr(), 10.0), 3);
+            assert!(page_ops(pdf, 2).contains("(seven) Tj"));
+            assert!(page_ops(pdf, 3).is_empty());
+            assert!(page_ops(pdf, 4).contains("(eight) Tj"));
+
+            pdf_set_margins(pdf, 100.0, 0.0, 100.0, 0.0);
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 0.0), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 10.0), 2);
+            assert!(output(pdf).contains("/MediaBox [0 0 612 792]"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_leading() {
+        let text = CString::new("one\ntwo\nthree\nfour\nfive").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 200.0, 200.0);
+            assert_eq!(pdf_set_leading(pdf, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_leading_multiple(pdf, -1.0), PDF_ERR_INVALID_ARGUMENT);
+
+            // The 56pt content area holds three 10pt lines 20pt apart
+            assert_eq!(pdf_set_leading(pdf, 20.0), PDF_OK);
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 10.0), 2);
+            assert!(page_ops(pdf, 0).contains("20 TL"));
+            assert!(page_ops(pdf, 0).contains("(three) '"));
+            assert!(page_ops(pdf, 1).contains("(four) Tj"));
+
+            // Two lines 25pt apart, measured from the font size of the call
+            assert_eq!(pdf_set_leading_multiple(pdf, 2.5), PDF_OK);
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 10.0), 3);
+            assert!(page_ops(pdf, 2).contains("25 TL"));
+            assert_eq!(pdf_add_text_box(pdf, 0, 0.0, 0.0, 100.0, 50.0, text.as_ptr(), 10.0), 3);
+
+            let words = CString::new("left\nright").unwrap();
+            assert_eq!(
+                pdf_add_text_aligned(pdf, 0, 10.0, 100.0, 80.0, words.as_ptr(), 10.0, PDF_ALIGN_LEFT),
+                PDF_OK
+            );
+            assert!(page_ops(pdf, 0).contains("1 0 0 1 10 75 Tm\n(right) Tj"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_box_fits() {
+        let text = CString::new("Short").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text_box(pdf, 0, 0.0, 0.0, 200.0, 50.0, text.as_ptr(), 12.0), 0);
+            assert_eq!(pdf_add_text_box(pdf, 0, 0.0, 0.0, 200.0, 5.0, text.as_ptr(), 12.0), 1);
+            assert_eq!(
+                pdf_add_text_box(pdf, 0, 0.0, 0.0, 0.0, 50.0, text.as_ptr(), 12.0),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_version() {
+        let text = CString::new("Archive").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert!(output(pdf).starts_with("%PDF-1.7"));
+            assert_eq!(pdf_set_version(pdf, 1, 4), PDF_OK);
+            assert!(output(pdf).starts_with("%PDF-1.4"));
+
+            assert_eq!(pdf_set_version(pdf, 1, 8), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Unknown PDF version 1.8"));
+            assert_eq!(pdf_set_version(pdf, 3, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_version(pdf, -1, 4), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).document.version, PdfVersion::V1_4);
+
+            // Transparency needs 1.4, so a watermark cannot be written as 1.3.
+            let draft = CString::new("DRAFT").unwrap();
+            assert_eq!(pdf_set_version(pdf, 1, 3), PDF_OK);
+            assert_eq!(pdf_add_watermark(pdf, draft.as_ptr(), 60.0, 0.3, 45.0), PDF_OK);
+            let mut data = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(last_error().unwrap().contains("requires PDF 1.4 or later"));
+            assert_eq!(pdf_set_version(pdf, 2, 0), PDF_OK);
+            assert!(output(pdf).starts_with("%PDF-2.0"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_open_action() {
+        let text = CString::new("Brochure").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_open_action(pdf, PDF_ZOOM_FIT_WIDTH, PDF_LAYOUT_TWO_PAGE_RIGHT), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/OpenAction [3 0 R /FitH null]"));
+            assert!(content.contains("/PageLayout /TwoPageRight"));
+            assert!(content.contains("/PageMode /UseNone"));
+
+            assert_eq!(pdf_set_open_action(pdf, 150, PDF_LAYOUT_DEFAULT), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/XYZ null null 1.5]"));
+            assert!(!content.contains("/PageLayout"));
+
+            assert_eq!(pdf_set_open_action(pdf, -3, PDF_LAYOUT_SINGLE_PAGE), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Unknown zoom mode -3"));
+            assert_eq!(pdf_set_open_action(pdf, PDF_ZOOM_FIT_PAGE, 7), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).document.initial_view.zoom, Some(Zoom::Percent(150.0)));
+
+            assert_eq!(pdf_set_open_action(pdf, PDF_ZOOM_DEFAULT, PDF_LAYOUT_DEFAULT), PDF_OK);
+            assert!(!output(pdf).contains("/PageMode"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_document_language() {
+        let text = CString::new("Marhaba").unwrap();
+        let arabic = CString::new("ar-EG").unwrap();
+        let invalid = CString::new("en_US").unwrap();
+        let empty = CString::new("").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert_eq!(pdf_set_document_language(pdf, arabic.as_ptr()), PDF_OK);
+            assert!(output(pdf).contains("/Lang (ar-EG)"));
+
+            assert_eq!(pdf_set_document_language(pdf, invalid.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Invalid language tag: 'en_US'"));
+            assert_eq!((*pdf).document.language.as_deref(), Some("ar-EG"));
+
+            assert_eq!(pdf_set_document_language(pdf, empty.as_ptr()), PDF_OK);
+            assert!(!output(pdf).contains("/Lang"));
+            assert_eq!(pdf_set_document_language(pdf, std::ptr::null()), PDF_OK);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_tags() {
+        let tag = |name: &str| CString::new(name).unwrap();
+        let text = CString::new("Intro").unwrap();
+        let alt = CString::new("A chart of sales").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_alt_text(pdf, alt.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("No tag is open"));
+
+            assert_eq!(pdf_begin_tag(pdf, 0, tag("Sect").as_ptr()), PDF_OK);
+            assert_eq!(pdf_begin_tag(pdf, 0, tag("P").as_ptr()), PDF_OK);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0);
+            assert_eq!(pdf_begin_tag(pdf, 0, tag("Span").as_ptr()), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 1), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("The open Span tag is on page 0"));
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_OK);
+            assert_eq!(pdf_begin_tag(pdf, 1, tag("Figure").as_ptr()), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 1), PDF_OK);
+            assert_eq!(pdf_set_alt_text(pdf, alt.as_ptr()), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 1), PDF_OK);
+            assert_eq!(pdf_begin_tag(pdf, 0, tag("Heading").as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Unknown tag type Heading"));
+
+            // The paragraph's sequence pauses around the span nested in it
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("/P <</MCID 0>> BDC\n"));
+            assert!(ops.ends_with("EMC\n/Span <</MCID 1>> BDC\nEMC\n/P <</MCID 2>> BDC\nEMC"));
+            assert_eq!(page_ops(pdf, 1), "/Figure <</MCID 0>> BDC\nEMC");
+
+            let structure = &(*pdf).document.structure;
+            assert_eq!(structure.len(), 4);
+            assert_eq!(structure[3].alt_text.as_deref(), Some("A chart of sales"));
+            let content = output(pdf);
+            assert!(content.contains("/S /Sect"));
+            assert!(content.contains("/Alt (A chart of sales)"));
+            assert!(content.contains("/StructParents 1"));
+
+            // A tag left open is closed at the end of the page
+            assert_eq!(pdf_begin_tag(pdf, 1, tag("P").as_ptr()), PDF_OK);
+            pdf_move_page(pdf, 1, 0);
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_OK);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_layers() {
+        let name = |name: &str| CString::new(name).unwrap();
+        let p = name("P");
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_layer(pdf, name("").as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_layer(pdf, name("Dimensions").as_ptr()), 0);
+            assert_eq!(pdf_add_layer(pdf, name("Notes").as_ptr()), 1);
+            assert_eq!(pdf_set_layer_visible(pdf, 1, 0), PDF_OK);
+            assert_eq!(pdf_set_layer_visible(pdf, 2, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_begin_layer(pdf, 0, 2), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("No layer is open on page 0"));
+
+            assert_eq!(pdf_begin_layer(pdf, 0, 0), PDF_OK);
+            pdf_draw_line(pdf, 0, 0.0, 0.0, 100.0, 0.0, 1.0);
+            assert_eq!(pdf_begin_layer(pdf, 0, 1), PDF_OK);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_OK);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_OK);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("/OC /OC1 BDC\n"));
+            assert!(ops.ends_with("/OC /OC2 BDC\nEMC\nEMC"));
+
+            // Tags begun inside a layer end before it, and layers begun inside a tag before the tag
+            assert_eq!(pdf_begin_layer(pdf, 0, 0), PDF_OK);
+            assert_eq!(pdf_begin_tag(pdf, 0, p.as_ptr()), PDF_OK);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_begin_layer(pdf, 0, 1), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_begin_tag(pdf, 0, p.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_OK);
+            assert_eq!(pdf_end_tag(pdf, 0), PDF_OK);
+            assert_eq!(pdf_end_layer(pdf, 0), PDF_OK);
+
+            let content = output(pdf);
+            assert!(content.contains("/OCProperties"));
+            assert!(content.contains("/Type /OCG /Name (Notes)"));
+            assert!(content.contains("/Properties << /OC1 "));
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "encryption")]
+    #[test]
+    fn test_set_version_rejects_aes256() {
+        let owner = CString::new("owner").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_set_version(pdf, 1, 4);
+            assert_eq!(pdf_set_encryption(pdf, ptr::null(), owner.as_ptr(), 0, PDF_ENCRYPTION_AES_256), PDF_OK);
+            let mut data = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(last_error().unwrap().contains("AES-256 encryption requires PDF 1.7"));
+
+            assert_eq!(pdf_set_encryption(pdf, ptr::null(), owner.as_ptr(), 0, PDF_ENCRYPTION_RC4_128), PDF_OK);
+            assert!(output(pdf).starts_with("%PDF-1.4"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_margins() {
+        let text = CString::new("Body").unwrap();
+        let format = CString::new("{page}").unwrap();
+        let (mut x, mut y, mut w, mut h) = (0.0, 0.0, 0.0, 0.0);
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_get_content_rect(pdf, 0, &mut x, &mut y, &mut w, &mut h), PDF_OK);
+            assert_eq!((x, y, w, h), (72.0, 72.0, 468.0, 648.0));
+
+            assert_eq!(pdf_set_margins(pdf, 50.0, 40.0, 60.0, 30.0), PDF_OK);
+            assert_eq!(pdf_get_content_rect(pdf, 0, &mut x, &mut y, &mut w, &mut h), PDF_OK);
+            assert_eq!((x, y, w, h), (30.0, 60.0, 542.0, 682.0));
+
+            // A zero-sized text box fills the content area.
+            assert_eq!(pdf_add_text_box(pdf, 0, 0.0, 0.0, 0.0, 0.0, text.as_ptr(), 12.0), 0);
+            assert!(page_ops(pdf, 0).contains("30 733.384 Td\n(Body) Tj"));
+
+            // Right-aligned page numbers end at the right margin, halfway up the bottom margin.
+            pdf_add_page_numbers(pdf, format.as_ptr(), 10.0, PDF_ALIGN_RIGHT);
+            assert!(output(pdf).contains("566.44 30 Td\n(1) Tj"));
+
+            assert_eq!(pdf_set_margins(pdf, -1.0, 0.0, 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_get_content_rect(pdf, 1, &mut x, &mut y, &mut w, &mut h), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(
+                pdf_get_content_rect(pdf, 0, ptr::null_mut(), &mut y, &mut w, &mut h),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_aligned() {
+        // "Total" is 22.23pt wide in 10pt Helvetica.
+        let text = CString::new("Total").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text_aligned(pdf, 0, 100.0, 700.0, 200.0, text.as_ptr(), 10.0, PDF_ALIGN_RIGHT), PDF_OK);
+            assert_eq!(pdf_add_text_aligned(pdf, 0, 100.0, 680.0, 200.0, text.as_ptr(), 10.0, PDF_ALIGN_CENTER), PDF_OK);
+            assert_eq!(pdf_add_text_aligned(pdf, 0, 100.0, 660.0, 200.0, text.as_ptr(), 10.0, PDF_ALIGN_LEFT), PDF_OK);
+            assert_eq!(
+                pdf_add_text_aligned(pdf, 0, 100.0, 640.0, 200.0, text.as_ptr(), 10.0, 4),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("1 0 0 1 277.77 700 Tm"));
+            assert!(ops.contains("1 0 0 1 188.885 680 Tm"));
+            assert!(ops.contains("1 0 0 1 100 660 Tm"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_aligned_justify_skips_last_line() {
+        // Wraps to "aa bb" (25.02pt) and "cc" in a 30pt box at 10pt.
+        let text = CString::new("aa bb cc").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_add_text_aligned(pdf, 0, 0.0, 100.0, 30.0, text.as_ptr(), 10.0, PDF_ALIGN_JUSTIFY);
+            assert_eq!(result, PDF_OK);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("4.98 Tw\n1 0 0 1 0 100 Tm\n(aa bb) Tj"));
+            assert!(ops.contains("0 Tw\n1 0 0 1 0 88 Tm\n(cc) Tj"));
+            pdf_free(pdf);
+        }
+    }
+
+    unsafe extern "C" fn collect_chunks(chunk: *const u8, len: usize, user_data: *mut c_void) -> i32 {
+        let chunks = &mut *(user_data as *mut Vec<Vec<u8>>);
+        chunks.push(std::slice::from_raw_parts(chunk, len).to_vec());
+        0
+    }
+
+    unsafe extern "C" fn abort_after_first(_: *const u8, _: usize, user_data: *mut c_void) -> i32 {
+        let calls = &mut *(user_data as *mut u32);
+        *calls += 1;
+        if *calls > 1 {
+            1
+        } else {
+            0
+        }
+    }
+
+    #[test]
+    fn test_write_to_callback() {
+        let text = CString::new("Streamed").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            pdf_set_compression(pdf, 0, 0);
+            let mut chunks: Vec<Vec<u8>> = Vec::new();
+            let result = pdf_write_to_callback(pdf, Some(collect_chunks), &mut chunks as *mut _ as *mut c_void);
+            assert_eq!(result, PDF_OK);
+
+            let streamed = chunks.concat();
+            assert!(streamed.starts_with(b"%PDF-"));
+            assert!(String::from_utf8_lossy(&streamed).contains("(Streamed) Tj"));
+            assert!(String::from_utf8_lossy(&streamed).trim_end().ends_with("%%EOF"));
+
+            // Output already built by pdf_get_data is streamed as is.
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(pdf, &mut data);
+            chunks.clear();
+            pdf_write_to_callback(pdf, Some(collect_chunks), &mut chunks as *mut _ as *mut c_void);
+            assert_eq!(chunks.concat(), std::slice::from_raw_parts(data, len));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_write_to_callback_abort() {
+        let text = CString::new("x".repeat(200_000)).unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            pdf_set_compression(pdf, 0, 0);
+            let mut calls = 0u32;
+            let result = pdf_write_to_callback(pdf, Some(abort_after_first), &mut calls as *mut _ as *mut c_void);
+            assert_eq!(result, PDF_ERR_ABORTED);
+            assert_eq!(calls, 2);
+            assert_eq!(pdf_write_to_callback(pdf, None, ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_write_to_callback_while_streaming() {
+        let file = tempfile::NamedTempFile::new().unwrap();
+        let path = CString::new(file.path().to_str().unwrap()).unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_begin_streaming(pdf, path.as_ptr()), PDF_OK);
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            // Only the last page is still held, so writing it alone would truncate the file
+            let mut chunks: Vec<Vec<u8>> = Vec::new();
+            let result = pdf_write_to_callback(pdf, Some(collect_chunks), &mut chunks as *mut _ as *mut c_void);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("pdf_finalize_streaming"));
+            assert!(chunks.is_empty());
+            assert_eq!(pdf_finalize_streaming(pdf), PDF_OK);
+            pdf_free(pdf);
+        }
+    }
+
+    /// Progress reports received, stopping the operation after `stop_at` of them.
+    struct Progress {
+        reports: Vec<(f64, String)>,
+        stop_at: usize,
+    }
+
+    unsafe extern "C" fn record_progress(fraction: f64, stage: *const c_char, user_data: *mut c_void) -> i32 {
+        let progress = &mut *(user_data as *mut Progress);
+        progress.reports.push((fraction, CStr::from_ptr(stage).to_string_lossy().into_owned()));
+        (progress.reports.len() >= progress.stop_at) as i32
+    }
+
+    #[test]
+    fn test_progress_callback() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let mut progress = Progress {
+                reports: Vec::new(),
+                stop_at: usize::MAX,
+            };
+            let user_data = &mut progress as *mut Progress as *mut c_void;
+            assert_eq!(pdf_set_progress_callback(pdf, Some(record_progress), user_data), PDF_OK);
+            let mut data: *const u8 = ptr::null();
+            assert!(pdf_get_data(pdf, &mut data) > 0);
+            let stage = |fraction: f64| (fraction, "serialize".to_string());
+            assert_eq!(progress.reports, [stage(0.0), stage(0.5), stage(1.0)]);
+
+            // A cancelled write caches nothing, and later writes start over
+            pdf_add_page(pdf, 0.0, 0.0);
+            progress.reports.clear();
+            progress.stop_at = 2;
+            let (mut copy, mut len) = (ptr::null_mut(), 0);
+            assert_eq!(pdf_get_data_copy(pdf, &mut copy, &mut len), PDF_ERR_ABORTED);
+            assert_eq!(last_error().as_deref(), Some("Cancelled by the progress callback"));
+            assert!(copy.is_null());
+            assert!((*pdf).data.borrow().is_none());
+            progress.reports.clear();
+            let mut chunks: Vec<Vec<u8>> = Vec::new();
+            let result = pdf_write_to_callback(pdf, Some(collect_chunks), &mut chunks as *mut _ as *mut c_void);
+            assert_eq!(result, PDF_ERR_ABORTED);
+
+            assert_eq!(pdf_set_progress_callback(pdf, None, ptr::null_mut()), PDF_OK);
+            progress.reports.clear();
+            assert!(pdf_get_data(pdf, &mut data) > 0);
+            assert!(progress.reports.is_empty());
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_progress_callback_merge() {
+        let text = CString::new("Imported").unwrap();
+        unsafe {
+            let source = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let bytes = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            let mut progress = Progress {
+                reports: Vec::new(),
+                stop_at: 1,
+            };
+            pdf_set_progress_callback(pdf, Some(record_progress), &mut progress as *mut Progress as *mut c_void);
+            assert_eq!(pdf_append_pdf(pdf, bytes.as_ptr(), bytes.len()), PDF_ERR_ABORTED);
+            assert_eq!((*pdf).document.page_count(), 0);
+
+            progress.reports.clear();
+            progress.stop_at = usize::MAX;
+            assert_eq!(pdf_append_pdf(pdf, bytes.as_ptr(), bytes.len()), 1);
+            let stage = |fraction: f64| (fraction, "merge".to_string());
+            assert_eq!(progress.reports, [stage(0.0), stage(1.0)]);
+            pdf_free(pdf);
+        }
+    }
+
+    unsafe extern "C" fn cancel_halfway(fraction: f64, _: *const c_char, user_data: *mut c_void) -> i32 {
+        if fraction >= 0.5 {
+            (*(user_data as *const AtomicI32)).store(1, Ordering::Relaxed);
+        }
+        0
+    }
+
+    #[test]
+    fn test_save_to_file_cancellable() {
+        let path = std::env::temp_dir().join(format!("rust_pdf_cancellable_{}.pdf", std::process::id()));
+        let cpath = CString::new(path.to_str().unwrap()).unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let flag = AtomicI32::new(1);
+            assert_eq!(pdf_save_to_file_cancellable(pdf, cpath.as_ptr(), flag.as_ptr()), PDF_ERR_CANCELLED);
+            assert_eq!(last_error().as_deref(), Some("Serialization was cancelled"));
+            assert!(!path.exists());
+
+            // Set partway through, as another thread would
+            flag.store(0, Ordering::Relaxed);
+            pdf_set_progress_callback(pdf, Some(cancel_halfway), &flag as *const AtomicI32 as *mut c_void);
+            assert_eq!(pdf_save_to_file_cancellable(pdf, cpath.as_ptr(), flag.as_ptr()), PDF_ERR_CANCELLED);
+            assert!((*pdf).data.borrow().is_none());
+            assert!(!path.exists());
+
+            pdf_set_progress_callback(pdf, None, ptr::null_mut());
+            flag.store(0, Ordering::Relaxed);
+            assert_eq!(pdf_save_to_file_cancellable(pdf, cpath.as_ptr(), flag.as_ptr()), PDF_OK);
+            assert_eq!(pdf_save_to_file_cancellable(pdf, cpath.as_ptr(), ptr::null()), PDF_OK);
+            assert!(std::fs::read(&path).unwrap().starts_with(b"%PDF-"));
+            std::fs::remove_file(&path).unwrap();
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_page_box() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 612.0, 792.0);
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_TRIM, 9.0, 9.0, 594.0, 774.0), PDF_OK);
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_BLEED, 0.0, 0.0, 612.0, 792.0), PDF_OK);
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_ART, 0.0, 0.0, 700.0, 792.0), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("ArtBox"));
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_CROP, 10.0, 10.0, -5.0, 100.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_page_box(pdf, 0, 4, 0.0, 0.0, 10.0, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_page_box(pdf, 1, PDF_BOX_TRIM, 0.0, 0.0, 10.0, 10.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            let content = output(pdf);
+            assert!(content.contains("/TrimBox [9 9 603 783]"));
+            assert!(content.contains("/BleedBox [0 0 612 792]"));
+
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_BLEED, 0.0, 0.0, 0.0, 0.0), PDF_OK);
+            assert!(!output(pdf).contains("/BleedBox"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_page_background() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 612.0, 792.0);
+            pdf_set_compression(pdf, 0, 0);
+            pdf_set_fill_color(pdf, 0.0, 0.0, 1.0);
+            pdf_draw_rectangle(pdf, 0, 10.0, 10.0, 20.0, 20.0, 0.0, 1);
+            assert_eq!(pdf_set_page_background(pdf, 0, 2.0, 0.0, 0.0), PDF_OK);
+            assert_eq!(pdf_set_page_background(pdf, 1, 1.0, 0.0, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].background, Some(Color::RED));
+            assert!(output(pdf).contains("q\n1 0 0 rg\n0 0 612 792 re\nf\nQ\n"));
+
+            assert_eq!(pdf_clear_page_background(pdf, 0), PDF_OK);
+            assert!(!output(pdf).contains("0 0 612 792 re"));
+            assert_eq!(pdf_clear_page_background(pdf, 1), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_append_raw_content() {
+        let content = b"/GS1 gs 1 0 0 rg 0 0 10 10 re f";
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 612.0, 792.0);
+            assert_eq!(pdf_append_raw_content(pdf, 0, content.as_ptr(), content.len()), PDF_OK);
+            assert_eq!(page_ops(pdf, 0), "q\n/GS1 gs 1 0 0 rg 0 0 10 10 re f\nQ");
+            assert_eq!(pdf_append_raw_content(pdf, 0, [0xff].as_ptr(), 1), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_append_raw_content(pdf, 0, ptr::null(), 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_append_raw_content(pdf, 1, content.as_ptr(), content.len()), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_add_resource() {
+        let name = CString::new("GS1").unwrap();
+        let gstate = CString::new("<< /Type /ExtGState /CA 0.5 /ca 0.5 >>").unwrap();
+        let half = CString::new("<< /CA 0.25 >>").unwrap();
+        let stream = CString::new("<< /Length 0 >> stream\n\nendstream").unwrap();
+        let reference = CString::new("[3 0 R]").unwrap();
+        let trailing = CString::new("<< /CA 1 >> extra").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 612.0, 792.0);
+            assert_eq!(pdf_add_resource(pdf, 0, name.as_ptr(), PDF_RESOURCE_EXT_GSTATE, gstate.as_ptr()), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/ExtGState << /GS1 << /Type /ExtGState /CA 0.5 /ca 0.5 >> >>"));
+
+            assert_eq!(pdf_add_resource(pdf, 0, name.as_ptr(), PDF_RESOURCE_EXT_GSTATE, half.as_ptr()), PDF_OK);
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].extra_resources.len(), 1);
+            assert!(output(pdf).contains("/GS1 << /CA 0.25 >>"));
+
+            for object in [&stream, &reference, &trailing] {
+                assert_eq!(
+                    pdf_add_resource(pdf, 0, name.as_ptr(), PDF_RESOURCE_EXT_GSTATE, object.as_ptr()),
+                    PDF_ERR_INVALID_ARGUMENT
+                );
+            }
+            assert_eq!(pdf_add_resource(pdf, 0, name.as_ptr(), 6, half.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(
+                pdf_add_resource(pdf, 1, name.as_ptr(), PDF_RESOURCE_EXT_GSTATE, half.as_ptr()),
+                PDF_ERR_PAGE_OUT_OF_RANGE
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_streaming() {
+        let path = std::env::temp_dir().join(format!("rust_pdf_streaming_{}.pdf", std::process::id()));
+        let cpath = CString::new(path.to_str().unwrap()).unwrap();
+        let text = CString::new("Row").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_finalize_streaming(pdf), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_page(pdf, 0.0, 0.0), 0);
+            assert_eq!(pdf_begin_streaming(pdf, cpath.as_ptr()), PDF_OK);
+            assert_eq!(pdf_begin_streaming(pdf, cpath.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            for i in 1..50 {
+                assert_eq!(pdf_add_page(pdf, 0.0, 0.0), i);
+                assert_eq!(pdf_add_text(pdf, i, 72.0, 720.0, text.as_ptr(), 12.0), PDF_OK);
+                // Only the page being drawn on is kept
+                assert_eq!((*pdf).document.page_count(), 1);
+            }
+            assert_eq!(pdf_add_text(pdf, 10, 72.0, 720.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_text(pdf, 50, 72.0, 720.0, text.as_ptr(), 12.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_delete_page(pdf, 49), PDF_ERR_UNSUPPORTED);
+            let mut data = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(pdf_clone(pdf).is_null());
+
+            assert_eq!(pdf_finalize_streaming(pdf), PDF_OK);
+            assert_eq!((*pdf).document.page_count(), 0);
+            pdf_free(pdf);
+        }
+        let bytes = std::fs::read(&path).unwrap();
+        std::fs::remove_file(&path).unwrap();
+        assert!(bytes.starts_with(b"%PDF-"));
+        #[cfg(feature = "parser")]
+        assert_eq!(crate::parser::PdfReader::from_bytes(bytes).unwrap().page_count(), 50);
+    }
+
+    #[test]
+    fn test_set_metadata() {
+        let title = CString::new("Report").unwrap();
+        let author = CString::new("Ren\u{e9}e Dupont").unwrap();
+        let keywords = CString::new("labels, shipping").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            let result = pdf_set_metadata(pdf, title.as_ptr(), author.as_ptr(), ptr::null(), ptr::null());
+            assert_eq!(result, PDF_OK);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_set_metadata(pdf, ptr::null(), ptr::null(), ptr::null(), keywords.as_ptr());
+            assert_eq!(result, PDF_OK);
+
+            let content = output(pdf);
+            assert!(content.contains("/Title (Report)"));
+            assert!(content.contains("/Author <FEFF00520065006E00E90065"));
+            assert!(content.contains("/Keywords (labels, shipping)"));
+            assert!(!content.contains("/Subject"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_deterministic() {
+        let text = CString::new("Same every time").unwrap();
+        let date = CString::new("D:20240131120000Z").unwrap();
+        let id = CString::new("00ff10").unwrap();
+        unsafe {
+            let first = pdf_create_simple(text.as_ptr(), 12.0);
+            let second = pdf_create_simple(text.as_ptr(), 12.0);
+            for pdf in [first, second] {
+                assert_eq!(pdf_set_deterministic(pdf, date.as_ptr(), id.as_ptr()), PDF_OK);
+            }
+            let content = output(first);
+            assert!(content.contains("/CreationDate (D:20240131120000Z)"));
+            assert!(content.contains("/ID [<00FF10> <00FF10>]"));
+            assert_eq!(output(second), content);
+
+            for bad in ["", "0f0", "zz"] {
+                let bad = CString::new(bad).unwrap();
+                assert_eq!(pdf_set_deterministic(first, ptr::null(), bad.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            }
+            assert_eq!(pdf_set_deterministic(first, ptr::null(), ptr::null()), PDF_ERR_INVALID_ARGUMENT);
+            let bad_date = CString::new("yesterday").unwrap();
+            assert_eq!(pdf_set_deterministic(first, bad_date.as_ptr(), id.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("PDF date"));
+            pdf_free(first);
+            pdf_free(second);
+        }
+    }
+
+    #[cfg(feature = "encryption")]
+    #[test]
+    fn test_set_encryption() {
+        let text = CString::new("Secret").unwrap();
+        let owner = CString::new("owner").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            let permissions = PDF_PERMISSION_PRINT | PDF_PERMISSION_COPY;
+            let result = pdf_set_encryption(pdf, ptr::null(), owner.as_ptr(), permissions, PDF_ENCRYPTION_RC4_128);
+            assert_eq!(result, PDF_OK);
+
+            let config = (*pdf).document.encryption.as_ref().unwrap();
+            assert!(!config.has_user_password());
+            assert!(config.permissions.can_print());
+            assert!(!config.permissions.can_modify());
+
+            let content = output(pdf);
+            assert!(content.contains("/Encrypt"));
+            assert!(content.contains("/V 2"));
+            assert!(content.contains("/R 3"));
+            assert!(!content.contains("(Secret) Tj"));
+
+            let result = pdf_set_encryption(pdf, owner.as_ptr(), ptr::null(), 0, PDF_ENCRYPTION_AES_256);
+            assert_eq!(result, PDF_OK);
+            assert_eq!((*pdf).document.encryption.as_ref().unwrap().owner_password, "owner");
+            assert!(output(pdf).contains("/V 5"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "encryption")]
+    #[test]
+    fn test_set_encryption_rejects_bad_arguments() {
+        let password = CString::new("pw").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            let result = pdf_set_encryption(pdf, ptr::null(), ptr::null(), 0, PDF_ENCRYPTION_AES_256);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            let result = pdf_set_encryption(pdf, password.as_ptr(), ptr::null(), 0, 9);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            assert!((*pdf).document.encryption.is_none());
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(not(feature = "encryption"))]
+    #[test]
+    fn test_set_encryption_requires_feature() {
+        let password = CString::new("pw").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            let result = pdf_set_encryption(pdf, password.as_ptr(), ptr::null(), 0, PDF_ENCRYPTION_AES_256);
+            assert_eq!(result, PDF_ERR_UNSUPPORTED);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "signatures")]
+    #[test]
+    fn test_sign_rejects_bad_arguments() {
+        let password = CString::new("secret").unwrap();
+        let not_pkcs12 = b"not a PKCS#12 file";
+        unsafe {
+            let pdf = pdf_create_empty();
+            let result = pdf_sign(pdf, not_pkcs12.as_ptr(), not_pkcs12.len(), password.as_ptr(), ptr::null(), ptr::null());
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            let result = pdf_sign(pdf, ptr::null(), 0, password.as_ptr(), ptr::null(), ptr::null());
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            let result = pdf_sign(pdf, not_pkcs12.as_ptr(), not_pkcs12.len(), ptr::null(), ptr::null(), ptr::null());
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            assert!((*pdf).signature.is_none());
+            pdf_free(pdf);
+
+            let mut data: *mut u8 = ptr::null_mut();
+            let mut len = 0;
+            let pdf = b"%PDF-1.7";
+            let result = pdf_sign_pdf(
+                pdf.as_ptr(),
+                pdf.len(),
+                not_pkcs12.as_ptr(),
+                not_pkcs12.len(),
+                password.as_ptr(),
+                ptr::null(),
+                ptr::null(),
+                &mut data,
+                &mut len,
+            );
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            assert!(data.is_null());
+        }
+    }
+
+    #[cfg(not(feature = "signatures"))]
+    #[test]
+    fn test_sign_requires_feature() {
+        let password = CString::new("secret").unwrap();
+        let pkcs12 = [0x30u8, 0x00];
+        unsafe {
+            let pdf = pdf_create_empty();
+            let result = pdf_sign(pdf, pkcs12.as_ptr(), pkcs12.len(), password.as_ptr(), ptr::null(), ptr::null());
+            assert_eq!(result, PDF_ERR_UNSUPPORTED);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_page_numbers() {
+        let format = CString::new("Page {page} of {total}").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_page_numbers(pdf, format.as_ptr(), 10.0, PDF_ALIGN_RIGHT), PDF_OK);
+            assert!(output(pdf).contains("(Page 1 of 1) Tj"));
+
+            // The total reflects pages added after the call.
+            pdf_add_page(pdf, 0.0, 0.0);
+            let content = output(pdf);
+            assert!(content.contains("(Page 1 of 2) Tj"));
+            assert!(content.contains("(Page 2 of 2) Tj"));
+            // Right-aligned: 612 - 36 - width of "Page 2 of 2" (51.15pt).
+            assert!(content.contains("524.85 36 Td"));
+            assert!(page_ops(pdf, 0).is_empty());
+
+            assert_eq!(pdf_add_page_numbers(pdf, format.as_ptr(), 10.0, PDF_ALIGN_JUSTIFY), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_page_numbers(pdf, format.as_ptr(), 0.0, PDF_ALIGN_LEFT), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_page_numbers(pdf, ptr::null(), 10.0, PDF_ALIGN_LEFT), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_watermark() {
+        let text = CString::new("DRAFT").unwrap();
+        let empty = CString::new("").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_watermark(pdf, text.as_ptr(), 60.0, 0.3, 45.0), PDF_OK);
+
+            // Pages added after the call are stamped too, sharing one graphics state.
+            pdf_add_page(pdf, 0.0, 0.0);
+            let content = output(pdf);
+            assert_eq!(content.matches("/GSWatermark gs").count(), 2);
+            assert!(content.contains("/ca 0.3"));
+            assert!(content.contains("0.7071 0.7071 -0.7071 0.7071 306 396 cm"));
+            assert!(content.contains("/FWatermark 60 Tf"));
+            assert!(page_ops(pdf, 1).is_empty());
+
+            assert_eq!(pdf_add_watermark(pdf, empty.as_ptr(), 60.0, 0.3, 45.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_watermark(pdf, text.as_ptr(), 0.0, 0.3, 45.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_watermark(pdf, text.as_ptr(), 60.0, 1.5, 45.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_watermark(pdf, text.as_ptr(), 60.0, 0.3, f64::NAN), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_bookmark() {
+        let chapter = CString::new("Chapter 1").unwrap();
+        let section = CString::new("Section 1.1").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let parent = pdf_add_bookmark(pdf, -1, chapter.as_ptr(), 0, 792.0);
+            assert_eq!(parent, 0);
+            assert_eq!(pdf_add_bookmark(pdf, parent, section.as_ptr(), 1, 400.0), 1);
+
+            let content = output(pdf);
+            assert!(content.contains("/Type /Outlines"));
+            assert!(content.contains("/Title (Section 1.1) /Parent"));
+            assert!(content.contains("/XYZ null 400 null"));
+
+            assert_eq!(pdf_add_bookmark(pdf, -1, chapter.as_ptr(), 2, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_bookmark(pdf, 5, section.as_ptr(), 0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("parent 5"));
+            assert_eq!(pdf_add_bookmark(pdf, -2, section.as_ptr(), 0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_bookmark(pdf, -1, ptr::null(), 0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_annotations() {
+        let note = CString::new("Is this figure current?").unwrap();
+        let approved = CString::new("Approved").unwrap();
+        let unknown = CString::new("Rejected").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_highlight(pdf, 0, 72.0, 700.0, 200.0, 14.0, 1.0, 1.0, 0.0), PDF_OK);
+            assert_eq!(pdf_add_text_note(pdf, 0, 300.0, 700.0, note.as_ptr()), PDF_OK);
+            assert_eq!(pdf_add_stamp(pdf, 0, 72.0, 72.0, approved.as_ptr()), PDF_OK);
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].annotations.len(), 3);
+
+            let content = output(pdf);
+            assert!(content.contains("/Subtype /Highlight"));
+            assert!(content.contains("/Contents (Is this figure current?)"));
+            assert!(content.contains("/Name /Approved"));
+
+            assert_eq!(pdf_add_stamp(pdf, 0, 0.0, 0.0, unknown.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_highlight(pdf, 0, 0.0, 0.0, 0.0, 10.0, 1.0, 1.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_text_note(pdf, 0, 0.0, 0.0, ptr::null()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_text_note(pdf, 1, 0.0, 0.0, note.as_ptr()), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_links() {
+        let uri = CString::new("https://example.com/search?q=rust pdf").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_link_uri(pdf, 0, 72.0, 700.0, 100.0, 20.0, uri.as_ptr()), PDF_OK);
+            // Overlaps the first link, and targets a page that does not exist yet.
+            assert_eq!(pdf_add_link_internal(pdf, 0, 80.0, 705.0, 100.0, 20.0, 1, 792.0), PDF_OK);
+            let mut data: *const u8 = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(last_error().unwrap().contains("Invalid link"));
+
+            pdf_add_page(pdf, 0.0, 0.0);
+            let content = output(pdf);
+            assert_eq!(content.matches("/Subtype /Link").count(), 2);
+            assert!(content.contains("/URI (https://example.com/search?q=rust%20pdf)"));
+            assert!(content.contains("/Rect [80 705 180 725]"));
+            assert!(content.contains("/XYZ null 792 null"));
+
+            assert_eq!(pdf_add_link_uri(pdf, 0, 0.0, 0.0, 0.0, 20.0, uri.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_link_uri(pdf, 2, 0.0, 0.0, 10.0, 20.0, uri.as_ptr()), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_link_internal(pdf, 0, 0.0, 0.0, 10.0, 20.0, -1, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_move_and_delete_pages() {
+        let title = CString::new("Third").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            for width in [100.0, 200.0, 300.0] {
+                pdf_add_page(pdf, width, 100.0);
+            }
+            assert_eq!(pdf_add_bookmark(pdf, -1, title.as_ptr(), 2, 0.0), 0);
+            assert_eq!(pdf_save_state(pdf, 2), PDF_OK);
+
+            assert_eq!(pdf_move_page(pdf, 2, 0), PDF_OK);
+            let widths: Vec<f64> = (*pdf).document.pages.iter().map(|p| p.width()).collect();
+            assert_eq!(widths, [300.0, 100.0, 200.0]);
+            assert_eq!((&(*pdf).document.bookmarks)[0].page_index, 0);
+            // The saved state moved with its page
+            assert_eq!(pdf_restore_state(pdf, 2), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_save_state(pdf, 2), PDF_OK);
+
+            assert_eq!(pdf_delete_page(pdf, 1), PDF_OK);
+            assert_eq!(pdf_restore_state(pdf, 0), PDF_OK);
+            assert_eq!(pdf_restore_state(pdf, 1), PDF_OK);
+            assert_eq!((*pdf).document.page_count(), 2);
+            assert!(output(pdf).contains("/Count 2"));
+
+            assert_eq!(pdf_move_page(pdf, 0, 2), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_move_page(pdf, -1, 0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_delete_page(pdf, 2), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert!(last_error().unwrap().contains("page count is 2"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_named_destinations() {
+        let name = CString::new("appendix").unwrap();
+        let empty = CString::new("").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            // The link may come before the destination it targets
+            assert_eq!(pdf_add_link_named(pdf, 0, 72.0, 700.0, 100.0, 20.0, name.as_ptr()), PDF_OK);
+            let mut data: *const u8 = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(last_error().unwrap().contains("'appendix', which does not exist"));
+
+            pdf_clear_error();
+            assert_eq!(pdf_add_named_destination(pdf, name.as_ptr(), 0, 0.0, 792.0), PDF_OK);
+            assert_eq!(last_error(), None);
+            assert_eq!(pdf_add_named_destination(pdf, name.as_ptr(), 1, 72.0, 500.0), PDF_OK);
+            assert!(last_error().unwrap().contains("replaced"));
+            let content = output(pdf);
+            assert!(content.contains("/Dest (appendix)"));
+            assert!(content.contains("/Dests << /Names [(appendix) ["));
+            assert!(content.contains("/XYZ 72 500 null]"));
+
+            assert_eq!(pdf_add_named_destination(pdf, name.as_ptr(), 2, 0.0, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_named_destination(pdf, empty.as_ptr(), 0, 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_link_named(pdf, 0, 0.0, 0.0, 10.0, 10.0, ptr::null()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_link_named(pdf, 0, 0.0, 0.0, 0.0, 10.0, name.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf() {
+        let text = CString::new("Imported").unwrap();
+        let note = CString::new("Note").unwrap();
+        unsafe {
+            let source = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let bytes = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_append_pdf(pdf, bytes.as_ptr(), bytes.len()), 1);
+            assert_eq!((*pdf).document.page_count(), 2);
+            assert_eq!(pdf_add_text(pdf, 1, 72.0, 100.0, note.as_ptr(), 10.0), PDF_OK);
+            assert!(page_ops(pdf, 1).starts_with("q\n/ImportedPage Do\nQ\n"));
+
+            let content = output(pdf);
+            assert!(content.contains("/Subtype /Form"));
+            assert!(content.contains("(Imported) Tj"));
+            assert!(content.contains("(Note) Tj"));
+
+            let garbage = b"%PDF-1.7 but nothing else";
+            assert_eq!(pdf_append_pdf(pdf, garbage.as_ptr(), garbage.len()), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().starts_with("Failed to read PDF"));
+            assert_eq!(pdf_append_pdf(pdf, ptr::null(), 10), PDF_ERR_INVALID_ARGUMENT);
+
+            // The trailer follows the xref table, so offsets stay valid
+            let start = bytes.windows(10).rposition(|w| w == b"trailer\n<<").unwrap() + 10;
+            let encrypted = [&bytes[..start], b" /Encrypt 1 0 R", &bytes[start..]].concat();
+            let result = pdf_append_pdf(pdf, encrypted.as_ptr(), encrypted.len());
+            assert_eq!(result, PDF_ERR_UNSUPPORTED);
+            assert_eq!((*pdf).document.page_count(), 2);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf_renames_fields() {
+        let name = CString::new("name").unwrap();
+        let agree = CString::new("agree").unwrap();
+        unsafe {
+            let source = pdf_create_empty();
+            pdf_add_page(source, 0.0, 0.0);
+            pdf_add_text_field(source, 0, name.as_ptr(), 72.0, 600.0, 200.0, 20.0);
+            pdf_add_checkbox(source, 0, agree.as_ptr(), 72.0, 560.0, 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let bytes = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_text_field(pdf, 0, name.as_ptr(), 72.0, 600.0, 200.0, 20.0);
+            let mut renamed = -1;
+            assert_eq!(pdf_append_pdf_renamed(pdf, bytes.as_ptr(), bytes.len(), &mut renamed), 1);
+            assert_eq!(renamed, 1);
+            assert_eq!(pdf_append_pdf_renamed(pdf, bytes.as_ptr(), bytes.len(), &mut renamed), 1);
+            assert_eq!(renamed, 2);
+            let names: Vec<&str> =
+                (*pdf).document.pages.iter().flat_map(|p| &p.form_fields).map(|f| f.name.as_str()).collect();
+            assert_eq!(names, ["name", "name_2", "agree", "name_3", "agree_2"]);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_append_pdf_from_path() {
+        let name = CString::new("name").unwrap();
+        let path = std::env::temp_dir().join(format!("rust_pdf_append_source_{}.pdf", std::process::id()));
+        let c_path = CString::new(path.to_str().unwrap()).unwrap();
+        let missing = CString::new("/nonexistent/source.pdf").unwrap();
+        unsafe {
+            let source = pdf_create_empty();
+            pdf_add_page(source, 0.0, 0.0);
+            pdf_add_page(source, 0.0, 0.0);
+            pdf_add_text_field(source, 0, name.as_ptr(), 72.0, 600.0, 200.0, 20.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            std::fs::write(&path, std::slice::from_raw_parts(data, len)).unwrap();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_text_field(pdf, 0, name.as_ptr(), 72.0, 600.0, 200.0, 20.0);
+            let mut renamed = -1;
+            assert_eq!(pdf_append_pdf_from_path(pdf, c_path.as_ptr(), &mut renamed), 2);
+            assert_eq!(renamed, 1);
+            assert_eq!(pdf_append_pdf_from_path(pdf, c_path.as_ptr(), ptr::null_mut()), 2);
+            assert_eq!((*pdf).document.page_count(), 5);
+
+            assert_eq!(pdf_append_pdf_from_path(pdf, missing.as_ptr(), ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("/nonexistent/source.pdf"));
+            assert_eq!(pdf_append_pdf_from_path(pdf, ptr::null(), ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+        std::fs::remove_file(&path).unwrap();
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_recovery_mode() {
+        let text = CString::new("Damaged").unwrap();
+        unsafe {
+            let source = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let intact = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+            let startxref = intact.windows(9).rposition(|w| w == b"startxref").unwrap();
+            let mut damaged = intact[..startxref].to_vec();
+            damaged.extend_from_slice(b"startxref\n12\n%%EOF\n");
+
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_append_pdf(pdf, damaged.as_ptr(), damaged.len()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_last_import_recovered(pdf), 0);
+
+            assert_eq!(pdf_set_recovery_mode(pdf, 1), PDF_OK);
+            assert_eq!(pdf_append_pdf(pdf, damaged.as_ptr(), damaged.len()), 1);
+            assert_eq!(pdf_last_import_recovered(pdf), 1);
+            assert_eq!((*pdf).document.page_count(), 1);
+            assert_eq!(pdf_append_pdf(pdf, intact.as_ptr(), intact.len()), 1);
+            assert_eq!(pdf_last_import_recovered(pdf), 0);
+            assert_eq!(pdf_import_page_as_xobject(pdf, damaged.as_ptr(), damaged.len(), 0), 0);
+            assert_eq!(pdf_last_import_recovered(pdf), 1);
+            pdf_free(pdf);
+            assert_eq!(pdf_last_import_recovered(ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_add_nup() {
+        let text = CString::new("Proof").unwrap();
+        unsafe {
+            let source = pdf_create_empty();
+            for _ in 0..5 {
+                pdf_add_page(source, 0.0, 0.0);
+            }
+            pdf_add_text(source, 0, 72.0, 700.0, text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let bytes = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_add_nup(pdf, bytes.as_ptr(), bytes.len(), 0, 2), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_nup(pdf, ptr::null(), 0, 2, 2), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_nup(pdf, bytes.as_ptr(), bytes.len(), 2, 2), 2);
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].imported.len(), 4);
+            assert_eq!(pages[0].media_box, Rectangle::letter());
+            assert!(page_ops(pdf, 1).starts_with("q\n"));
+            assert!(page_ops(pdf, 1).contains("/ImportedPage1 Do"));
+
+            let content = output(pdf);
+            assert!(content.contains("(Proof) Tj"));
+            assert_eq!(content.matches("/Subtype /Form").count(), 5);
+            pdf_free(pdf);
+
+            // While streaming, only the last sheet is kept
+            let path = std::env::temp_dir().join(format!("rust_pdf_nup_{}.pdf", std::process::id()));
+            let cpath = CString::new(path.to_str().unwrap()).unwrap();
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_begin_streaming(pdf, cpath.as_ptr()), PDF_OK);
+            assert_eq!(pdf_add_nup(pdf, bytes.as_ptr(), bytes.len(), 1, 1), 5);
+            assert_eq!((*pdf).document.page_count(), 1);
+            assert_eq!(pdf_finalize_streaming(pdf), PDF_OK);
+            pdf_free(pdf);
+            let written = std::fs::read(&path).unwrap();
+            std::fs::remove_file(&path).unwrap();
+            assert_eq!(crate::parser::PdfReader::from_bytes(written).unwrap().page_count(), 6);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_draw_xobject() {
+        let text = CString::new("Letterhead").unwrap();
+        unsafe {
+            let source = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let bytes = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_import_page_as_xobject(pdf, bytes.as_ptr(), bytes.len(), 1), PDF_ERR_PAGE_OUT_OF_RANGE);
+            let stamp = pdf_import_page_as_xobject(pdf, bytes.as_ptr(), bytes.len(), 0);
+            assert_eq!(stamp, 0);
+            assert_eq!(pdf_draw_xobject(pdf, 0, 1, 0.0, 0.0, 1.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_draw_xobject(pdf, 0, stamp, 0.0, 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_draw_xobject(pdf, 0, stamp, 0.0, 0.0, 1.0), PDF_OK);
+            assert_eq!(pdf_draw_xobject(pdf, 0, stamp, 100.0, 50.0, 0.5), PDF_OK);
+            assert_eq!(pdf_draw_xobject(pdf, 1, stamp, 0.0, 0.0, 1.0), PDF_OK);
+            assert_eq!(page_ops(pdf, 0), "q\n1 0 0 1 0 0 cm\n/Stamp1 Do\nQ\nq\n0.5 0 0 0.5 100 50 cm\n/Stamp1 Do\nQ");
+
+            // Every stamp draws the same single copy of the page
+            let content = output(pdf);
+            assert_eq!(content.matches("/Subtype /Form").count(), 1);
+            assert_eq!(content.matches("(Letterhead) Tj").count(), 1);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_open_for_update() {
+        let text = CString::new("Original").unwrap();
+        let note = CString::new("Reviewed").unwrap();
+        unsafe {
+            let source = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(source, &mut data);
+            let original = std::slice::from_raw_parts(data, len).to_vec();
+            pdf_free(source);
+
+            let pdf = pdf_open_for_update(original.as_ptr(), original.len());
+            assert!(!pdf.is_null());
+            assert_eq!((*pdf).document.page_count(), 1);
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 100.0, note.as_ptr(), 10.0), PDF_OK);
+            assert_eq!(pdf_add_page(pdf, 0.0, 0.0), 1);
+
+            let mut data: *const u8 = ptr::null();
+            let len = pdf_get_data(pdf, &mut data);
+            let updated = std::slice::from_raw_parts(data, len).to_vec();
+            assert!(updated.starts_with(&original));
+            assert_eq!(String::from_utf8_lossy(&updated).matches("%%EOF").count(), 2);
+            let reader = crate::parser::PdfReader::from_bytes(updated).unwrap();
+            assert_eq!(reader.page_count(), 2);
+            pdf_free(pdf);
+
+            let garbage = b"%PDF-1.7 but nothing else";
+            assert!(pdf_open_for_update(garbage.as_ptr(), garbage.len()).is_null());
+            assert!(last_error().unwrap().starts_with("Failed to read PDF"));
+            assert!(pdf_open_for_update(ptr::null(), 10).is_null());
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_extract_text() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let first = CString::new("First line").unwrap();
+        let second = CString::new("Second").unwrap();
+        let embedded = CString::new("A\u{416}B").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_text(pdf, 0, 72.0, 700.0, first.as_ptr(), 12.0);
+            pdf_add_text(pdf, 0, 72.0, 680.0, second.as_ptr(), 12.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            pdf_add_text_with_font(pdf, 1, 72.0, 700.0, embedded.as_ptr(), 12.0, font_id);
+
+            let mut text: *const c_char = ptr::null();
+            assert_eq!(pdf_extract_text(pdf, 0, &mut text), PDF_OK);
+            assert_eq!(CStr::from_ptr(text).to_str().unwrap(), "First line\nSecond");
+            assert_eq!(pdf_extract_text(pdf, 1, &mut text), PDF_OK);
+            assert_eq!(CStr::from_ptr(text).to_str().unwrap(), "A\u{416}B");
+
+            assert_eq!(pdf_extract_text(pdf, 2, &mut text), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert!(text.is_null());
+            assert_eq!(pdf_extract_text(pdf, 0, ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+
+            #[cfg(feature = "encryption")]
+            {
+                let password = CString::new("secret").unwrap();
+                let algorithm = PDF_ENCRYPTION_AES_256;
+                assert_eq!(pdf_set_encryption(pdf, password.as_ptr(), ptr::null(), 0, algorithm), PDF_OK);
+                assert_eq!(pdf_extract_text(pdf, 0, &mut text), PDF_ERR_UNSUPPORTED);
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "parser")]
+    #[test]
+    fn test_fill_and_flatten_form() {
+        use crate::forms::{CheckBox, TextField};
+        use crate::page::PageBuilder;
+
+        let page = PageBuilder::a4()
+            .text_field(TextField::new("name").rect(72.0, 600.0, 200.0, 20.0).max_length(8))
+            .checkbox(CheckBox::new("agree").rect(72.0, 560.0, 12.0, 12.0))
+            .build();
+        let bytes = crate::document::DocumentBuilder::new().page(page).build().unwrap().save_to_bytes().unwrap();
+        let name = CString::new("name").unwrap();
+        let agree = CString::new("agree").unwrap();
+        let ada = CString::new("Ada").unwrap();
+        let long = CString::new("Ada Lovelace").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_append_pdf(pdf, bytes.as_ptr(), bytes.len()), 1);
+            assert_eq!(pdf_fill_form_field(pdf, name.as_ptr(), ada.as_ptr()), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/V (Ada)"));
+            assert!(content.contains("(Ada) Tj"));
+
+            assert_eq!(pdf_fill_form_field(pdf, name.as_ptr(), long.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_fill_form_field(pdf, agree.as_ptr(), ada.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Form field agree is not a text field"));
+            assert_eq!(pdf_fill_form_field(pdf, ada.as_ptr(), ada.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("No form field named Ada"));
+            assert_eq!(pdf_fill_form_field(pdf, ptr::null(), ada.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+
+            assert_eq!(pdf_flatten_form(pdf), 2);
+            assert!(page_ops(pdf, 0).contains("(Ada) Tj"));
+            let content = output(pdf);
+            assert!(!content.contains("/AcroForm"));
+            assert!(!content.contains("/V (Ada)"));
+            assert_eq!(pdf_fill_form_field(pdf, name.as_ptr(), ada.as_ptr()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_form_fields() {
+        let name = CString::new("name").unwrap();
+        let agree = CString::new("agree").unwrap();
+        let dotted = CString::new("a.b").unwrap();
+        let empty = CString::new("").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_text_field(pdf, 0, name.as_ptr(), 72.0, 700.0, 200.0, 20.0), PDF_OK);
+            assert_eq!(pdf_add_checkbox(pdf, 0, agree.as_ptr(), 72.0, 660.0, 12.0), PDF_OK);
+            let fields = &(&(*pdf).document.pages)[0].form_fields;
+            assert_eq!(fields[0].rect, Rectangle::new(72.0, 700.0, 272.0, 720.0));
+            assert_eq!(fields[1].field_type, FormFieldType::CheckBox);
+            assert_eq!(fields[1].rect, Rectangle::new(72.0, 660.0, 84.0, 672.0));
+
+            let content = output(pdf);
+            assert!(content.contains("/AcroForm"));
+            assert!(content.contains("/FT /Tx"));
+            assert!(content.contains("/T (agree)"));
+
+            assert_eq!(pdf_add_checkbox(pdf, 0, name.as_ptr(), 72.0, 620.0, 12.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("A form field named name already exists"));
+            assert_eq!(pdf_add_text_field(pdf, 0, dotted.as_ptr(), 72.0, 600.0, 10.0, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_text_field(pdf, 0, empty.as_ptr(), 72.0, 600.0, 10.0, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_text_field(pdf, 1, dotted.as_ptr(), 72.0, 600.0, 0.0, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            let other = CString::new("other").unwrap();
+            assert_eq!(pdf_add_checkbox(pdf, 1, other.as_ptr(), 72.0, 600.0, 10.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            assert_eq!(pdf_add_checkbox(pdf, 0, other.as_ptr(), 72.0, 600.0, -1.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((&(*pdf).document.pages)[0].form_fields.len(), 2);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(not(feature = "parser"))]
+    #[test]
+    fn test_append_pdf_requires_feature() {
+        let data = b"%PDF-1.7";
+        unsafe {
+            let pdf = pdf_create_empty();
+            assert_eq!(pdf_append_pdf(pdf, data.as_ptr(), data.len()), PDF_ERR_UNSUPPORTED);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(not(feature = "parser"))]
+    #[test]
+    fn test_open_for_update_requires_feature() {
+        let data = b"%PDF-1.7";
+        unsafe {
+            assert!(pdf_open_for_update(data.as_ptr(), data.len()).is_null());
+            assert_eq!(last_error().as_deref(), Some("Built without the \"parser\" feature"));
+        }
+    }
+
+    #[cfg(not(feature = "parser"))]
+    #[test]
+    fn test_extract_text_requires_feature() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let mut text: *const c_char = ptr::null();
+            assert_eq!(pdf_extract_text(pdf, 0, &mut text), PDF_ERR_UNSUPPORTED);
+            assert_eq!(last_error().as_deref(), Some("Built without the \"parser\" feature"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_script() {
+        let h = CString::new("H").unwrap();
+        let two = CString::new("2").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let width = pdf_add_text_script(pdf, 0, 72.0, 700.0, h.as_ptr(), 20.0, PDF_SCRIPT_NORMAL);
+            assert_eq!(width, calculate_helvetica_width("H", 20.0));
+            let sub = pdf_add_text_script(pdf, 0, 72.0 + width, 700.0, two.as_ptr(), 20.0, PDF_SCRIPT_SUBSCRIPT);
+            assert_eq!(sub, calculate_helvetica_width("2", 13.0));
+            pdf_add_text_script(pdf, 0, 72.0, 700.0, two.as_ptr(), 20.0, PDF_SCRIPT_SUPERSCRIPT);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("/F1 20 Tf\n72 700 Td\n(H) Tj"));
+            assert!(ops.contains("/F1 13 Tf\n-3 Ts\n86.44 700 Td\n(2) Tj"));
+            assert!(ops.contains("q\nBT\n/F1 13 Tf\n7 Ts\n72 700 Td\n(2) Tj\nET\nQ"));
+
+            assert_eq!(pdf_add_text_script(pdf, 0, 0.0, 0.0, h.as_ptr(), 12.0, 3), PDF_ERR_INVALID_ARGUMENT as f64);
+            for size in [0.0, -12.0, f64::INFINITY] {
+                let result = pdf_add_text_script(pdf, 0, 0.0, 0.0, h.as_ptr(), size, PDF_SCRIPT_SUPERSCRIPT);
+                assert_eq!(result, PDF_ERR_INVALID_ARGUMENT as f64);
+            }
+            assert_eq!(
+                pdf_add_text_script(pdf, 1, 0.0, 0.0, h.as_ptr(), 12.0, PDF_SCRIPT_NORMAL),
+                PDF_ERR_PAGE_OUT_OF_RANGE as f64
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_text_rotated() {
+        let draft = CString::new("DRAFT").unwrap();
+        let label = CString::new("Label").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_set_fill_color(pdf, 0.8, 0.8, 0.8);
+            assert_eq!(pdf_add_text_rotated(pdf, 0, 300.0, 400.0, draft.as_ptr(), 48.0, 45.0), PDF_OK);
+            pdf_reset_colors(pdf);
+            assert_eq!(pdf_add_text(pdf, 0, 72.0, 72.0, label.as_ptr(), 12.0), PDF_OK);
+
+            let ops = page_ops(pdf, 0);
+            let rotated = "q\n0.8 0.8 0.8 rg\n0.7071 0.7071 -0.7071 0.7071 300 400 cm\n";
+            assert!(ops.starts_with(rotated), "{}", ops);
+            let (rotated_ops, rest) = ops.split_once("\nQ\n").unwrap();
+            assert!(rotated_ops.contains("0 0 Td\n(DRAFT) Tj"));
+            // Text drawn afterwards is outside the rotated state.
+            assert!(!rest.contains("cm") && rest.contains("72 72 Td\n(Label) Tj"));
+
+            assert_eq!(pdf_add_text_rotated(pdf, 0, 0.0, 0.0, draft.as_ptr(), 12.0, 90.0), PDF_OK);
+            assert!(page_ops(pdf, 0).contains("q\n0 1 -1 0 0 0 cm\n"));
+
+            assert_eq!(pdf_add_text_rotated(pdf, 0, 0.0, 0.0, draft.as_ptr(), 12.0, f64::NAN), PDF_ERR_INVALID_ARGUMENT);
+            for size in [0.0, -12.0, f64::NAN] {
+                assert_eq!(pdf_add_text_rotated(pdf, 0, 0.0, 0.0, draft.as_ptr(), size, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            }
+            assert_eq!(pdf_add_text_rotated(pdf, 1, 0.0, 0.0, draft.as_ptr(), 12.0, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_measure_text() {
+        let text = CString::new("Hello, World").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            // H722 e556 l222 l222 o556 ,278 space278 W944 o556 r333 l222 d556 = 5445
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, PDF_FONT_DEFAULT), 54.45);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 0.0, PDF_FONT_DEFAULT), 0.0);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), -1.0, PDF_FONT_DEFAULT), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, 0), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert!(last_error().unwrap().contains("Unknown font id 0"));
+            assert_eq!(pdf_measure_text(pdf, ptr::null(), 10.0, PDF_FONT_DEFAULT), PDF_ERR_INVALID_ARGUMENT as f64);
+
+            // Glyph advances of the test font: A is 600 and Zhe 700 units per 1000.
+            let font_data = crate::font::test_font_bytes();
+            let name = CString::new("TestSans").unwrap();
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            let text = CString::new("A\u{416}").unwrap();
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, font_id), 13.0);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_kerning_and_ligatures() {
+        // The font draws "AB" as one glyph (3), and kerns B after A by -80 units
+        let font_data = crate::font::shaping_test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("ABA").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 612.0, 792.0);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, font_id), 17.5);
+            pdf_add_text_with_font(pdf, 0, 72.0, 700.0, text.as_ptr(), 10.0, font_id);
+
+            assert_eq!(pdf_set_kerning(pdf, 1), PDF_OK);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, font_id), 16.7);
+            pdf_add_text_with_font(pdf, 0, 72.0, 680.0, text.as_ptr(), 10.0, font_id);
+
+            assert_eq!(pdf_set_ligatures(pdf, 1), PDF_OK);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, font_id), 13.0);
+            pdf_add_text_with_font(pdf, 0, 72.0, 660.0, text.as_ptr(), 10.0, font_id);
+            // Character spacing follows the glyphs drawn
+            pdf_set_char_spacing(pdf, 1.0);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, font_id), 15.0);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("<000100020001> Tj"));
+            assert!(ops.contains("[<0001> 80 <00020001>] TJ"));
+            assert!(ops.contains("<00030001> Tj"));
+            assert_eq!(pdf_set_kerning(ptr::null_mut(), 1), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_ligatures(ptr::null_mut(), 1), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_font_metrics() {
+        let (mut ascent, mut descent, mut cap_height, mut line_gap) = (0.0, 0.0, 0.0f64, 0.0);
+        unsafe {
+            let pdf = pdf_create_empty();
+            let out = (&mut ascent as *mut f64, &mut descent as *mut f64, &mut cap_height as *mut f64);
+            assert_eq!(pdf_font_metrics(pdf, PDF_FONT_DEFAULT, 1000.0, out.0, out.1, out.2, &mut line_gap), PDF_OK);
+            assert_eq!((ascent, descent, cap_height, line_gap), (718.0, -207.0, 718.0, 0.0));
+
+            // The test font has no OS/2 table, so its cap height is estimated from the ascender
+            let font_data = crate::font::test_font_bytes();
+            let name = CString::new("TestSans").unwrap();
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            assert_eq!(pdf_font_metrics(pdf, font_id, 10.0, out.0, out.1, out.2, &mut line_gap), PDF_OK);
+            assert_eq!((ascent, descent, line_gap), (8.0, -2.0, 0.0));
+            assert!((cap_height - 5.6).abs() < 1e-9);
+
+            assert_eq!(pdf_font_metrics(pdf, 5, 10.0, out.0, out.1, out.2, &mut line_gap), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_font_metrics(pdf, font_id, -1.0, out.0, out.1, out.2, &mut line_gap), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_font_metrics(pdf, font_id, 10.0, out.0, out.1, out.2, ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_draw_rectangle() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_draw_rectangle(pdf, 0, 50.0, 100.0, 200.0, 80.0, 1.5, 0), PDF_OK);
+            assert_eq!(pdf_draw_rectangle(pdf, 0, 50.0, 300.0, 200.0, 80.0, 0.0, 1), PDF_OK);
+            assert_eq!(pdf_draw_rectangle(pdf, 0, 50.0, 500.0, 200.0, 80.0, 0.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_draw_rectangle(pdf, 1, 50.0, 500.0, 200.0, 80.0, 1.0, 0), PDF_ERR_PAGE_OUT_OF_RANGE);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("1.5 w\n50 100 200 80 re\nS"));
+            assert!(ops.contains("50 300 200 80 re\nf"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_draw_line() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_draw_line(pdf, 0, 72.0, 700.0, 540.0, 700.0, 0.5), PDF_OK);
+            assert_eq!(pdf_draw_line(pdf, 0, 72.0, 700.0, 540.0, 700.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_draw_line(pdf, 0, 72.0, 700.0, 540.0, 700.0, -1.0), PDF_ERR_INVALID_ARGUMENT);
+
+            let ops = page_ops(pdf, 0);
+            assert_eq!(ops, "q\n0.5 w\n72 700 m\n540 700 l\nS\nQ");
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_draw_bezier() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_draw_bezier(pdf, 0, 0.0, 0.0, 50.0, 100.0, 150.0, 100.0, 200.0, 0.0), PDF_OK);
+            assert_eq!(page_ops(pdf, 0), "0 0 m\n50 100 150 100 200 0 c\nS");
+
+            pdf_set_stroke_color(pdf, 1.0, 0.0, 0.0);
+            assert_eq!(pdf_draw_bezier(pdf, 0, 0.0, 0.0, 1.0, 1.0, 2.0, 1.0, 3.0, 0.0), PDF_OK);
+            assert!(page_ops(pdf, 0).ends_with("q\n1 0 0 RG\n0 0 m\n1 1 2 1 3 0 c\nS\nQ"));
+            assert_eq!(pdf_draw_bezier(pdf, 1, 0.0, 0.0, 1.0, 1.0, 2.0, 1.0, 3.0, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_draw_ellipse() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_draw_ellipse(pdf, 0, 100.0, 200.0, 50.0, 25.0, 2.0, 1), PDF_OK);
+            assert_eq!(pdf_draw_ellipse(pdf, 0, 100.0, 200.0, 50.0, 50.0, 0.0, 1), PDF_OK);
+            assert_eq!(pdf_draw_ellipse(pdf, 0, 100.0, 200.0, 50.0, 25.0, 0.0, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_draw_ellipse(pdf, 0, 100.0, 200.0, 0.0, 25.0, 1.0, 0), PDF_ERR_INVALID_ARGUMENT);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n2 w\n150 200 m\n150 213.8071 127.6142 225 100 225 c"));
+            assert!(ops.contains("h\nB\nQ"));
+            assert!(ops.ends_with("150 200 c\nh\nf\nQ"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_clip_rect() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 10.0, 10.0, 100.0, 100.0), PDF_OK);
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 50.0, 50.0, 100.0, 100.0), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 0.0, 0.0, 200.0, 200.0, 0.0, 1);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_OK);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_OK);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 0.0, 0.0, 0.0, 10.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_push_clip_rect(pdf, 2, 0.0, 0.0, 10.0, 10.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+
+            // The second clip intersects the first
+            assert_eq!(
+                page_ops(pdf, 0),
+                "q\n10 10 100 100 re\nW\nn\nq\n50 50 100 100 re\nW\nn\n\
+                 q\n0 0 200 200 re\nf\nQ\nQ\nQ"
+            );
+
+            // A clip left pushed is popped at the end of its page
+            assert_eq!(pdf_push_clip_rect(pdf, 1, 10.0, 10.0, 50.0, 50.0), PDF_OK);
+            pdf_set_compression(pdf, 0, 0);
+            assert!(output(pdf).contains("stream\nq\n10 10 50 50 re\nW\nn\nQ\nendstream"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_save_restore_state() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_save_state(pdf, 0), PDF_OK);
+            pdf_set_fill_color(pdf, 1.0, 0.0, 0.0);
+            pdf_set_leading(pdf, 30.0);
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 0.0, 0.0, 50.0, 50.0), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 0.0, 0.0, 100.0, 100.0, 0.0, 1);
+
+            // Restoring pops the clip pushed since the save
+            assert_eq!(pdf_save_state(pdf, 0), PDF_OK);
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 0.0, 0.0, 10.0, 10.0), PDF_OK);
+            assert_eq!(pdf_restore_state(pdf, 0), PDF_OK);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_OK);
+
+            // A clip pushed before an unrestored save cannot be popped
+            assert_eq!(pdf_push_clip_rect(pdf, 0, 0.0, 0.0, 10.0, 10.0), PDF_OK);
+            assert_eq!(pdf_save_state(pdf, 0), PDF_OK);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_restore_state(pdf, 0), PDF_OK);
+
+            // The first save brings back the color and leading
+            assert_eq!(pdf_restore_state(pdf, 0), PDF_OK);
+            assert_eq!(pdf_restore_state(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_pop_clip(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).leading, Leading::Multiple(LINE_SPACING));
+            pdf_draw_rectangle(pdf, 0, 0.0, 0.0, 100.0, 100.0, 0.0, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\nq\n0 0 50 50 re\nW\nn\nq\n1 0 0 rg\n0 0 100 100 re\nf\nQ"));
+            assert!(ops.ends_with("q\n0 0 10 10 re\nW\nn\nq\nQ\nQ\nQ\nq\n0 0 100 100 re\nf\nQ"));
+            assert_eq!(pdf_restore_state(pdf, 1), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_save_state(pdf, 2), PDF_ERR_PAGE_OUT_OF_RANGE);
+
+            // A state left saved is restored at the end of its page
+            assert_eq!(pdf_save_state(pdf, 1), PDF_OK);
+            pdf_set_compression(pdf, 0, 0);
+            assert!(output(pdf).contains("stream\nq\nQ\nendstream"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_transform() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_save_state(pdf, 0);
+            assert_eq!(pdf_translate(pdf, 0, 100.0, 200.0), PDF_OK);
+            assert_eq!(pdf_rotate(pdf, 0, 90.0), PDF_OK);
+            assert_eq!(pdf_scale(pdf, 0, 2.0, -1.0), PDF_OK);
+            assert_eq!(pdf_transform(pdf, 0, 1.0, 0.0, 0.5, 1.0, 0.0, 0.0), PDF_OK);
+            pdf_draw_line(pdf, 0, 0.0, 0.0, 10.0, 0.0, 1.0);
+            pdf_restore_state(pdf, 0);
+
+            assert_eq!(pdf_scale(pdf, 0, 0.0, 1.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_transform(pdf, 0, 1.0, 2.0, 2.0, 4.0, 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_rotate(pdf, 0, f64::NAN), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_translate(pdf, 0, f64::INFINITY, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_translate(pdf, 1, 0.0, 0.0), PDF_ERR_PAGE_OUT_OF_RANGE);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n1 0 0 1 100 200 cm\n0 1 -1 0 0 0 cm\n2 0 0 -1 0 0 cm\n1 0 0.5 1 0 0 cm\n"));
+            assert!(ops.ends_with("Q"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_colors_apply_to_text_and_shapes() {
+        let text = CString::new("Red").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_fill_color(pdf, 1.0, 0.0, 0.0), PDF_OK);
+            assert_eq!(pdf_set_stroke_color(pdf, 0.0, 0.0, 2.0), PDF_OK);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0);
+            pdf_draw_rectangle(pdf, 0, 72.0, 600.0, 100.0, 50.0, 1.0, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n1 0 0 rg\n0 0 1 RG\nBT"));
+            assert!(ops.contains("q\n1 0 0 rg\n0 0 1 RG\n1 w\n72 600 100 50 re\nB\nQ"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_attach_file() {
+        let xml = b"<rsm:CrossIndustryInvoice/>";
+        let name = CString::new("factur-x.xml").unwrap();
+        let mime = CString::new("text/xml").unwrap();
+        let description = CString::new("Factur-X invoice").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_attach_file(
+                pdf,
+                name.as_ptr(),
+                mime.as_ptr(),
+                xml.as_ptr(),
+                xml.len(),
+                description.as_ptr(),
+                PDF_AF_ALTERNATIVE,
+            );
+            assert_eq!(result, PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("/EmbeddedFiles"));
+            assert!(content.contains("/AFRelationship /Alternative"));
+            assert!(content.contains("<rsm:CrossIndustryInvoice/>"));
+
+            let duplicate = pdf_attach_file(pdf, name.as_ptr(), ptr::null(), ptr::null(), 0, ptr::null(), PDF_AF_NONE);
+            assert_eq!(duplicate, PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(
+                last_error().as_deref(),
+                Some("Document error: Invalid attachment: a file named 'factur-x.xml' is already attached")
+            );
+            let other = CString::new("data.bin").unwrap();
+            let result = pdf_attach_file(pdf, other.as_ptr(), ptr::null(), xml.as_ptr(), xml.len(), ptr::null(), 9);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            let result = pdf_attach_file(pdf, other.as_ptr(), ptr::null(), ptr::null(), 4, ptr::null(), PDF_AF_DATA);
+            assert_eq!(result, PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).document.attachments.len(), 1);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_pdfa_mode() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("A").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_validate_pdfa(pdf, ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_pdfa_mode(pdf, 3), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_pdfa_mode(pdf, PDF_PDFA_1B), PDF_OK);
+
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            pdf_add_text_with_font(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0, font_id);
+            let mut report = ptr::null();
+            assert_eq!(pdf_validate_pdfa(pdf, &mut report), 0);
+            assert_eq!(CStr::from_ptr(report).to_str(), Ok(""));
+            let content = output(pdf);
+            assert!(content.contains("/OutputIntents"));
+            assert!(content.contains("/CIDSet"));
+            assert!(content.contains("<pdfaid:part>1</pdfaid:part>"));
+            assert!(content.contains("/ID ["));
+
+            pdf_add_text(pdf, 0, 72.0, 650.0, text.as_ptr(), 12.0);
+            assert_eq!(pdf_validate_pdfa(pdf, &mut report), 1);
+            assert!(CStr::from_ptr(report).to_str().unwrap().contains("Helvetica"));
+            let mut data = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            assert!(last_error().unwrap().contains("Document does not conform to PDF/A-1b"));
+
+            assert_eq!(pdf_set_pdfa_mode(pdf, PDF_PDFA_NONE), PDF_OK);
+            assert!(!output(pdf).contains("/OutputIntents"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_audit_resources() {
+        let font_data = crate::font::test_font_bytes();
+        let name = CString::new("TestSans").unwrap();
+        let text = CString::new("A").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            pdf_add_text_with_font(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0, font_id);
+            let mut report = ptr::null();
+            assert_eq!(pdf_audit_resources(pdf, &mut report), 0);
+            assert_eq!(CStr::from_ptr(report).to_str(), Ok(""));
+
+            pdf_add_text(pdf, 0, 72.0, 650.0, text.as_ptr(), 12.0);
+            assert_eq!(pdf_audit_resources(pdf, ptr::null_mut()), 1);
+
+            #[cfg(feature = "parser")]
+            {
+                let source = pdf_create_simple(text.as_ptr(), 12.0);
+                let mut data: *const u8 = ptr::null();
+                let len = pdf_get_data(source, &mut data);
+                let bytes = std::slice::from_raw_parts(data, len).to_vec();
+                pdf_free(source);
+                assert_eq!(pdf_append_pdf(pdf, bytes.as_ptr(), bytes.len()), 1);
+                assert_eq!(pdf_audit_resources(pdf, &mut report), 2);
+                assert_eq!(
+                    CStr::from_ptr(report).to_str(),
+                    Ok("Page 1 uses the standard font Helvetica, which is not embedded\n\
+                        Page 2 uses the font Helvetica, which is not embedded")
+                );
+            }
+            assert_eq!(pdf_audit_resources(ptr::null_mut(), ptr::null_mut()), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_xmp_metadata() {
+        let title = CString::new("Report").unwrap();
+        let tool = CString::new("Invoicer").unwrap();
+        let xml = b"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"><rdf:RDF/></x:xmpmeta>";
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let result = pdf_set_xmp_fields(pdf, title.as_ptr(), ptr::null(), ptr::null(), ptr::null(), tool.as_ptr());
+            assert_eq!(result, PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">Report</rdf:li>"));
+            assert!(content.contains("<xmp:CreatorTool>Invoicer</xmp:CreatorTool>"));
+            assert!(content.contains("/Creator (Invoicer)"));
+
+            assert_eq!(pdf_set_xmp_metadata(pdf, xml.as_ptr(), xml.len()), PDF_OK);
+            let content = output(pdf);
+            assert!(content.contains("<?xpacket begin="));
+            assert!(content.contains("<rdf:RDF/></x:xmpmeta>\n"));
+            assert!(!content.contains("dc:title"));
+
+            let invalid = [0xffu8, 0xfe];
+            assert_eq!(pdf_set_xmp_metadata(pdf, invalid.as_ptr(), 2), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_xmp_metadata(pdf, ptr::null(), 0), PDF_OK);
+            assert!(!output(pdf).contains("/Metadata"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_compress_min_size() {
+        let short = CString::new("Short").unwrap();
+        let long = CString::new("Long text ".repeat(100)).unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(short.as_ptr(), 12.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_add_text(pdf, 1, 72.0, 700.0, long.as_ptr(), 12.0);
+            assert_eq!(pdf_set_compress_min_size(pdf, 500), PDF_OK);
+            let content = output(pdf);
+            #[cfg(feature = "compression")]
+            {
+                // Only the second page's content is long enough to compress
+                assert!(content.contains("(Short) Tj"));
+                assert!(!content.contains("(Long text"));
+                assert_eq!(content.matches("/Filter /FlateDecode").count(), 1);
+                assert_eq!(pdf_set_compress_min_size(pdf, 0), PDF_OK);
+                assert_eq!(output(pdf).matches("/Filter /FlateDecode").count(), 2);
+            }
+            #[cfg(not(feature = "compression"))]
+            assert!(content.contains("(Short) Tj"));
+            assert_eq!(pdf_set_compress_min_size(ptr::null_mut(), 0), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_coordinate_precision() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_compression(pdf, 0, 0), PDF_OK);
+            pdf_draw_line(pdf, 0, 72.00000000001, 100.125001, 200.3333, 100.0, 1.0);
+            let content = output(pdf);
+            assert!(content.contains("72 100.125 m\n200.3333 100 l"));
+
+            assert_eq!(pdf_set_coordinate_precision(pdf, 2), PDF_OK);
+            assert!(output(pdf).contains("72 100.13 m\n200.33 100 l"));
+            assert_eq!(pdf_set_coordinate_precision(pdf, 11), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_coordinate_precision(pdf, -1), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).document.coordinate_precision, 2);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_set_compression() {
+        let text = CString::new("Compressed text ".repeat(100)).unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            assert_eq!(pdf_set_compression(pdf, 1, 10), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_compression(pdf, 1, -1), PDF_ERR_INVALID_ARGUMENT);
+            let compressed = output(pdf);
+
+            assert_eq!(pdf_set_compression(pdf, 0, 0), PDF_OK);
+            let plain = output(pdf);
+            assert!(!plain.contains("/FlateDecode"));
+            assert!(plain.contains("(Compressed text Compressed text"));
+
+            #[cfg(feature = "compression")]
+            {
+                assert!(compressed.contains("/Filter /FlateDecode"));
+                assert!(compressed.len() < plain.len());
+                assert_eq!(pdf_set_compression(pdf, 1, 9), PDF_OK);
+                assert_eq!((*pdf).document.compression_level, 9);
+            }
+            #[cfg(not(feature = "compression"))]
+            {
+                assert_eq!(compressed, plain);
+                assert_eq!(pdf_set_compression(pdf, 1, 6), PDF_ERR_UNSUPPORTED);
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_use_object_streams() {
+        let text = CString::new("Packed").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            for _ in 0..150 {
+                let page = pdf_add_page(pdf, 0.0, 0.0);
+                pdf_add_text(pdf, page, 72.0, 700.0, text.as_ptr(), 12.0);
+            }
+            let classic = output(pdf).len();
+
+            assert_eq!(pdf_set_use_object_streams(pdf, 1), PDF_OK);
+            let packed = output(pdf);
+            assert!(packed.contains("/Type /XRef"));
+            assert!(packed.matches("/Type /ObjStm").count() >= 4);
+            assert!(packed.len() < classic);
+
+            assert_eq!(pdf_set_version(pdf, 1, 4), PDF_OK);
+            assert!(output(pdf).contains("trailer"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_optimize() {
+        let text = CString::new("Repeated").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            for _ in 0..3 {
+                let page = pdf_add_page(pdf, 0.0, 0.0);
+                pdf_add_text(pdf, page, 72.0, 700.0, text.as_ptr(), 12.0);
+            }
+            let mut data: *const u8 = ptr::null();
+            let full = pdf_get_data(pdf, &mut data);
+            assert_eq!(output(pdf).matches("/BaseFont /Helvetica").count(), 4);
+
+            let saved = pdf_optimize(pdf);
+            let optimized = output(pdf);
+            assert_eq!(optimized.matches("/BaseFont /Helvetica").count(), 1);
+            assert_eq!(saved, (full - pdf_get_data(pdf, &mut data)) as i64);
+            // Optimizing again measures against the unmerged document
+            assert_eq!(pdf_optimize(pdf), saved);
+            assert_eq!(output(pdf), optimized);
+            pdf_free(pdf);
+
+            // A document that cannot be serialized keeps its setting
+            let empty = pdf_create_empty();
+            assert!(pdf_optimize(empty) < 0);
+            assert!(!(*empty).document.deduplicate);
+            pdf_free(empty);
+        }
+        assert_eq!(unsafe { pdf_optimize(ptr::null_mut()) }, PDF_ERR_INVALID_ARGUMENT as i64);
+    }
+
+    #[test]
+    fn test_linearized() {
+        let text = CString::new("Fast").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            for _ in 0..3 {
+                let page = pdf_add_page(pdf, 0.0, 0.0);
+                pdf_add_text(pdf, page, 72.0, 700.0, text.as_ptr(), 12.0);
+            }
+            assert!(!output(pdf).contains("/Linearized"));
+
+            assert_eq!(pdf_set_use_object_streams(pdf, 1), PDF_OK);
+            assert_eq!(pdf_set_linearized(pdf, 1), PDF_OK);
+            let linearized = output(pdf);
+            let header = linearized.split("endobj").next().unwrap();
+            assert!(header.contains("/Linearized 1") && header.contains("/N 4"));
+            assert!(!linearized.contains("/ObjStm"));
+
+            #[cfg(feature = "parser")]
+            {
+                let mut data: *const u8 = ptr::null();
+                let len = pdf_get_data(pdf, &mut data);
+                let bytes = std::slice::from_raw_parts(data, len).to_vec();
+                assert_eq!(crate::parser::PdfReader::from_bytes(bytes).unwrap().page_count(), 4);
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_get_data_copy() {
+        let text = CString::new("Copied").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            let mut borrowed: *const u8 = ptr::null();
+            let len = pdf_get_data(pdf, &mut borrowed);
+            let expected = std::slice::from_raw_parts(borrowed, len).to_vec();
+
+            let mut data: *mut u8 = ptr::null_mut();
+            let mut len = 0;
+            assert_eq!(pdf_get_data_copy(pdf, &mut data, &mut len), PDF_OK);
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_free(pdf);
+
+            // The copy outlives both the mutation and the handle
+            assert_eq!(std::slice::from_raw_parts(data, len), expected);
+            pdf_free_buffer(data);
+            pdf_free_buffer(ptr::null_mut());
+
+            let empty = pdf_create_empty();
+            let mut data = NonNull::dangling().as_ptr();
+            assert_eq!(pdf_get_data_copy(empty, &mut data, &mut len), PDF_ERR_INVALID_ARGUMENT);
+            assert!(data.is_null() && len == 0);
+            assert_eq!(pdf_get_data_copy(empty, ptr::null_mut(), &mut len), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(empty);
+        }
+    }
+
+    #[test]
+    fn test_generate_thumbnails() {
+        let text = CString::new("Thumbnail").unwrap();
+        unsafe {
+            let pdf = pdf_create_simple(text.as_ptr(), 12.0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_generate_thumbnails(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            let status = pdf_generate_thumbnails(pdf, 96);
+            if cfg!(feature = "render") {
+                assert_eq!(status, 2);
+                let pages = &(*pdf).document.pages;
+                let thumbnail = pages[1].thumbnail.as_ref().unwrap();
+                assert_eq!((thumbnail.width(), thumbnail.height()), (74, 96));
+                pdf_set_compression(pdf, 0, 0);
+                let content = output(pdf);
+                assert_eq!(content.matches("/Thumb ").count(), 2);
+                assert!(content.contains("/Width 74"));
+            } else {
+                assert_eq!(status, PDF_ERR_UNSUPPORTED);
+                let pages = &(*pdf).document.pages;
+                assert!(pages[0].thumbnail.is_none());
+            }
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_render_page_png() {
+        let feature = CString::new("render").unwrap();
+        let unknown = CString::new("teleport").unwrap();
+        unsafe {
+            assert_eq!(pdf_has_feature(feature.as_ptr()), cfg!(feature = "render") as i32);
+            assert_eq!(pdf_has_feature(unknown.as_ptr()), 0);
+            assert_eq!(pdf_has_feature(ptr::null()), PDF_ERR_INVALID_ARGUMENT);
+
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 100.0, 50.0);
+            pdf_draw_rectangle(pdf, 0, 10.0, 10.0, 20.0, 20.0, 1.0, 1);
+            let mut data: *mut u8 = ptr::null_mut();
+            let mut len = 0;
+            let status = pdf_render_page_png(pdf, 0, 144.0, &mut data, &mut len);
+            if cfg!(feature = "render") {
+                assert_eq!(status, PDF_OK);
+                let png = std::slice::from_raw_parts(data, len);
+                assert!(png.starts_with(b"\x89PNG"));
+                // Width and height in pixels at twice the resolution of points
+                assert_eq!(&png[16..24], &[0, 0, 0, 200, 0, 0, 0, 100]);
+                pdf_free_buffer(data);
+                assert_eq!(pdf_render_page_png(pdf, 0, 0.0, &mut data, &mut len), PDF_ERR_INVALID_ARGUMENT);
+            } else {
+                assert_eq!(status, PDF_ERR_UNSUPPORTED);
+            }
+            assert!(data.is_null() && len == 0);
+            assert_eq!(pdf_render_page_png(pdf, 1, 72.0, &mut data, &mut len), PDF_ERR_PAGE_OUT_OF_RANGE);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_build_info() {
+        let info = unsafe { CStr::from_ptr(pdf_build_info()) }.to_str().unwrap();
+        assert!(info.starts_with(concat!("rust-pdf ", env!("CARGO_PKG_VERSION"), " (features: ")));
+        assert_eq!(info.contains("render"), cfg!(feature = "render"));
+        assert!(info.ends_with(if cfg!(debug_assertions) { "profile: debug)" } else { "profile: release)" }));
+        assert_eq!(pdf_build_info(), pdf_build_info());
+    }
+
+    #[test]
+    fn test_invalid_handles() {
+        unsafe {
+            assert_eq!(pdf_add_page(ptr::null_mut(), 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Handle is null"));
+            pdf_free(ptr::null_mut());
+
+            // Memory that never held a handle lacks the tag
+            let mut foreign = [0u64; 64];
+            let fake = foreign.as_mut_ptr() as *mut PdfHandle;
+            assert_eq!(pdf_add_page(fake, 0.0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().starts_with("Handle is not a live PDF handle"));
+            assert!(pdf_clone(fake).is_null());
+            pdf_clear_error();
+            pdf_free(fake);
+            assert!(last_error().unwrap().starts_with("pdf_free was given a handle that is not live"));
+
+            let pdf = pdf_create_empty();
+            (*pdf).busy.store(true, Ordering::Release);
+            pdf_free(pdf);
+            assert!(last_error().unwrap().contains("in use by another call"));
+            (*pdf).busy.store(false, Ordering::Release);
+            assert_eq!(pdf_add_page(pdf, 0.0, 0.0), 0);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_cmyk_and_gray_colors() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_set_fill_color(pdf, 1.0, 0.0, 0.0);
+            pdf_draw_rectangle(pdf, 0, 72.0, 600.0, 100.0, 50.0, 1.0, 1);
+            assert_eq!(pdf_set_fill_color_cmyk(pdf, 0.0, 1.0, 1.0, 2.0), PDF_OK);
+            assert_eq!(pdf_set_stroke_color_gray(pdf, 0.5), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 72.0, 500.0, 100.0, 50.0, 1.0, 1);
+            assert_eq!(pdf_set_fill_color_gray(pdf, 0.25), PDF_OK);
+            assert_eq!(pdf_set_stroke_color_cmyk(pdf, 1.0, 0.0, 0.0, 0.0), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 72.0, 400.0, 100.0, 50.0, 1.0, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n1 0 0 rg\n1 w\n72 600 100 50 re\nB\nQ\n"));
+            assert!(ops.contains("q\n0 1 1 1 k\n0.5 G\n1 w\n72 500 100 50 re\nB\nQ"));
+            assert!(ops.contains("q\n0.25 g\n1 0 0 0 K\n1 w\n72 400 100 50 re\nB\nQ"));
+            assert!(!ops.contains(" rg\n0.5 G"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_reset_colors() {
+        let text = CString::new("Black").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            pdf_set_fill_color(pdf, -1.0, 0.5, 0.5);
+            assert_eq!((*pdf).style.fill_color, Color::rgb(0.0, 0.5, 0.5));
+            assert_eq!(pdf_reset_colors(pdf), PDF_OK);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 12.0);
+
+            assert!(page_ops(pdf, 0).starts_with("BT"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_fill_gradients() {
+        let stops = [
+            PdfGradientStop { offset: 0.0, r: 1.0, g: 0.0, b: 0.0 },
+            PdfGradientStop { offset: 1.0, r: 0.0, g: 0.0, b: 2.0 },
+        ];
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_fill_linear_gradient(pdf, 0.0, 0.0, 100.0, 0.0, stops.as_ptr(), 1), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("at least 2"));
+            assert_eq!(pdf_set_fill_radial_gradient(pdf, 50.0, 50.0, 40.0, ptr::null(), 0), PDF_ERR_INVALID_ARGUMENT);
+
+            assert_eq!(pdf_set_fill_linear_gradient(pdf, 0.0, 0.0, 100.0, 0.0, stops.as_ptr(), 2), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 0.0, 0.0, 100.0, 50.0, 0.0, 1);
+            assert_eq!(pdf_set_fill_radial_gradient(pdf, 50.0, 150.0, 40.0, stops.as_ptr(), 2), PDF_OK);
+            pdf_draw_ellipse(pdf, 0, 50.0, 150.0, 40.0, 40.0, 0.0, 1);
+            pdf_set_fill_color(pdf, 0.0, 1.0, 0.0);
+            pdf_draw_rectangle(pdf, 0, 0.0, 200.0, 10.0, 10.0, 0.0, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n/Pattern cs\n/Gr1 scn\n0 0 100 50 re\nf\nQ"));
+            assert!(ops.contains("/Pattern cs\n/Gr2 scn"));
+            assert!(ops.contains("0 1 0 rg\n0 200 10 10 re"));
+            let out = output(pdf);
+            assert!(out.contains("/Pattern << /Gr1 "));
+            assert!(out.contains("/ShadingType 2"));
+            assert!(out.contains("/ShadingType 3"));
+            assert!(out.contains("/C1 [0 0 1]"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_flowing_text_style_resources() {
+        let stops = [
+            PdfGradientStop { offset: 0.0, r: 1.0, g: 0.0, b: 0.0 },
+            PdfGradientStop { offset: 1.0, r: 0.0, g: 0.0, b: 1.0 },
+        ];
+        let text = CString::new("First\x0CSecond").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_fill_linear_gradient(pdf, 0.0, 0.0, 100.0, 0.0, stops.as_ptr(), 2), PDF_OK);
+            pdf_set_overprint(pdf, 1, 0);
+            assert_eq!(pdf_add_flowing_text(pdf, text.as_ptr(), 12.0), 2);
+
+            // The pattern and graphics state exist on the starting page and
+            // on the page the form feed adds
+            let pages = &(*pdf).document.pages;
+            for (i, page) in pages.iter().enumerate() {
+                assert!(page_ops(pdf, i).contains("/Pattern cs\n/Gr1 scn"));
+                assert!(page.patterns.iter().any(|(name, _)| name == "Gr1"));
+                assert_eq!(page.overprints.len(), 1);
+            }
+            assert!(output(pdf).contains("/Pattern << /Gr1 "));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_fill_tiling_pattern() {
+        let hatch = b"0 0 m 8 8 l S";
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_create_tiling_pattern(pdf, 0.0, 8.0, hatch.as_ptr(), hatch.len()), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_create_tiling_pattern(pdf, 8.0, 8.0, ptr::null(), 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_fill_pattern(pdf, 0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(last_error().as_deref(), Some("Unknown pattern id 0"));
+
+            let id = pdf_create_tiling_pattern(pdf, 8.0, 8.0, hatch.as_ptr(), hatch.len());
+            assert_eq!(id, 0);
+            assert_eq!(pdf_set_fill_pattern(pdf, id), PDF_OK);
+            pdf_draw_rectangle(pdf, 0, 0.0, 0.0, 100.0, 50.0, 0.0, 1);
+            pdf_draw_rectangle(pdf, 0, 0.0, 100.0, 100.0, 50.0, 0.0, 1);
+            pdf_set_fill_color(pdf, 0.0, 1.0, 0.0);
+            pdf_draw_rectangle(pdf, 0, 0.0, 200.0, 10.0, 10.0, 0.0, 1);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n/Pattern cs\n/Tp1 scn\n0 0 100 50 re\nf\nQ"));
+            assert!(ops.contains("0 1 0 rg\n0 200 10 10 re"));
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].tiling_patterns.len(), 1);
+            let out = output(pdf);
+            assert!(out.contains("/Pattern << /Tp1 "));
+            assert!(out.contains("/PatternType 1 /PaintType 1 /TilingType 1 /BBox [0 0 8 8]"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_table() {
+        let cells: Vec<CString> = ["Item", "Qty", "A very long description", "2"]
+            .iter()
+            .map(|s| CString::new(*s).unwrap())
+            .collect();
+        let mut ptrs: Vec<*const c_char> = cells.iter().map(|c| c.as_ptr()).collect();
+        ptrs[1] = ptr::null();
+        let widths = [60.0, 40.0];
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(
+                pdf_add_table(pdf, 0, 72.0, 700.0, ptrs.as_ptr(), 4, 2, 2, widths.as_ptr(), 20.0, 10.0),
+                PDF_OK
+            );
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n0.5 w\n72 680 60 20 re\n132 680 40 20 re\n72 660 60 20 re"));
+            assert!(ops.contains("132 660 40 20 re\nS"));
+            // Text starts inside the padding and is clipped to it.
+            assert!(ops.contains("q\n76 680 52 20 re\nW\nn\nBT\n/F1 10 Tf\n76 687.445 Td\n(Item) Tj"));
+            assert!(ops.contains("(A very long description) Tj"));
+            assert!(!ops.contains("(Qty)"));
+            assert_eq!(ops.matches("\nW\n").count(), 3);
+
+            assert_eq!(
+                pdf_add_table(pdf, 0, 72.0, 700.0, ptrs.as_ptr(), 3, 2, 2, widths.as_ptr(), 20.0, 10.0),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                pdf_add_table(pdf, 0, 72.0, 700.0, ptrs.as_ptr(), 4, 2, 2, ptr::null(), 20.0, 10.0),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                pdf_add_table(pdf, 0, 72.0, 700.0, ptrs.as_ptr(), 4, 2, 2, widths.as_ptr(), 0.0, 10.0),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                pdf_add_table(pdf, 1, 72.0, 700.0, ptrs.as_ptr(), 4, 2, 2, widths.as_ptr(), 20.0, 10.0),
+                PDF_ERR_PAGE_OUT_OF_RANGE
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_list() {
+        let items: Vec<CString> = ["First item", "A second item that wraps"]
+            .iter()
+            .map(|s| CString::new(*s).unwrap())
+            .collect();
+        let ptrs: Vec<*const c_char> = items.iter().map(|c| c.as_ptr()).collect();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let bottom = pdf_add_list(pdf, 0, 72.0, 700.0, 100.0, ptrs.as_ptr(), 2, 10.0, PDF_LIST_BULLET);
+
+            // Three lines at 12pt leading; text starts 1.5em past x.
+            assert_eq!(bottom, 664.0);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("78 695.42 m"), "{}", ops);
+            assert!(ops.contains("87 692.82 Td\n(First item) Tj"));
+            assert!(ops.contains("87 680.82 Td\n(A second item that) Tj\n(wraps) '"));
+
+            let numbered: Vec<CString> = (0..10).map(|i| CString::new(format!("Step {}", i)).unwrap()).collect();
+            let mut ptrs: Vec<*const c_char> = numbered.iter().map(|c| c.as_ptr()).collect();
+            ptrs[1] = ptr::null();
+            let bottom = pdf_add_list(pdf, 0, 72.0, 500.0, 200.0, ptrs.as_ptr(), 10, 10.0, PDF_LIST_NUMBERED);
+            assert_eq!(bottom, 380.0);
+            // "10." is 13.9pt wide, so "1." is right-aligned within that column.
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("77.56 492.82 Td\n(1.) Tj"));
+            assert!(ops.contains("72 384.82 Td\n(10.) Tj"));
+            assert!(ops.contains("90.9 384.82 Td\n(Step 9) Tj"));
+            assert!(ops.contains("(2.) Tj"));
+
+            let dash = pdf_add_list(pdf, 0, 72.0, 300.0, 200.0, ptrs.as_ptr(), 1, 10.0, PDF_LIST_DASH);
+            assert_eq!(dash, 288.0);
+            assert!(page_ops(pdf, 0).contains("75.335 292.82 Td\n(-) Tj"));
+
+            assert_eq!(pdf_add_list(pdf, 0, 72.0, 700.0, 100.0, ptrs.as_ptr(), 2, 10.0, 5), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert_eq!(pdf_add_list(pdf, 0, 72.0, 700.0, 10.0, ptrs.as_ptr(), 2, 10.0, PDF_LIST_BULLET), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert_eq!(
+                pdf_add_list(pdf, 1, 72.0, 700.0, 100.0, ptrs.as_ptr(), 2, 10.0, PDF_LIST_BULLET),
+                PDF_ERR_PAGE_OUT_OF_RANGE as f64
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(feature = "html")]
+    #[test]
+    fn test_add_html() {
+        let html = "<h2>Notes</h2><p>Some <b>bold</b> <span color=red>text</span></p><ul><li>One</ul><marquee>x</marquee>";
+        let html = CString::new(html).unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            let bottom = pdf_add_html(pdf, 0, 72.0, 700.0, 300.0, html.as_ptr());
+            // An 18pt heading, then three 12pt lines separated by 6pt margins
+            assert!((bottom - (700.0 - 21.6 - 3.0 * 6.0 - 3.0 * 14.4)).abs() < 1e-9, "{}", bottom);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("/HelvB 18 Tf"), "{}", ops);
+            assert!(ops.contains("(Some) Tj"));
+            assert!(ops.contains("1 0 0 rg"));
+            assert!(ops.contains("(x) Tj"));
+            let pages = &(*pdf).document.pages;
+            assert!(pages[0].fonts.iter().any(|(name, _)| name == "HelvBI"));
+
+            // Fonts are only added once
+            pdf_add_html(pdf, 0, 72.0, 400.0, 300.0, html.as_ptr());
+            let pages = &(*pdf).document.pages;
+            assert_eq!(pages[0].fonts.iter().filter(|(name, _)| name == "Helv").count(), 1);
+
+            assert_eq!(pdf_add_html(pdf, 0, 72.0, 700.0, 0.0, html.as_ptr()), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert_eq!(pdf_add_html(pdf, 0, 72.0, 700.0, 100.0, ptr::null()), PDF_ERR_INVALID_ARGUMENT as f64);
+            assert_eq!(pdf_add_html(pdf, 1, 72.0, 700.0, 100.0, html.as_ptr()), PDF_ERR_PAGE_OUT_OF_RANGE as f64);
+            pdf_free(pdf);
+        }
+    }
+
+    #[cfg(not(feature = "html"))]
+    #[test]
+    fn test_add_html_requires_feature() {
+        let html = CString::new("<p>Hi</p>").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_html(pdf, 0, 72.0, 700.0, 100.0, html.as_ptr()), PDF_ERR_UNSUPPORTED as f64);
+            assert_eq!(last_error().as_deref(), Some("Built without the \"html\" feature"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_barcode() {
+        let data = CString::new("PJ").unwrap();
+        let invalid = CString::new("Ω").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_barcode(pdf, 0, 72.0, 600.0, 114.0, 40.0, data.as_ptr(), PDF_BARCODE_CODE128), PDF_OK);
+
+            // 57 modules across 114pt: two points per module.
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("72 600 4 40 re\n78 600 2 40 re"));
+            assert!(ops.ends_with("re\nf"));
+
+            assert_eq!(
+                pdf_add_barcode(pdf, 0, 72.0, 600.0, 114.0, 40.0, invalid.as_ptr(), PDF_BARCODE_CODE128),
+                PDF_ERR_INVALID_ARGUMENT
+            );
+            assert_eq!(
+                last_error().as_deref(),
+                Some("Invalid barcode data: 'Ω' cannot be encoded in Code 128")
+            );
+            assert_eq!(pdf_add_barcode(pdf, 0, 72.0, 600.0, 114.0, 40.0, data.as_ptr(), 7), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_barcode(pdf, 0, 72.0, 600.0, 0.0, 40.0, data.as_ptr(), PDF_BARCODE_CODE128), PDF_ERR_INVALID_ARGUMENT);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_qrcode() {
+        let data = CString::new("HELLO WORLD").unwrap();
+        let long = CString::new("x".repeat(1300)).unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_add_qrcode(pdf, 0, 72.0, 600.0, 58.0, data.as_ptr(), PDF_QR_ECC_QUARTILE), PDF_OK);
+
+            // Version 1 is 21 modules; with the quiet zone, 2pt per module.
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("80 648 14 2 re"));
+            assert!(ops.contains("re\nf"));
+
+            // 1300 bytes fit at low error correction but not at high.
+            assert_eq!(pdf_add_qrcode(pdf, 0, 72.0, 72.0, 400.0, long.as_ptr(), PDF_QR_ECC_HIGH), PDF_ERR_DATA_TOO_LONG);
+            assert!(last_error().unwrap().starts_with("Data does not fit in a QR code"));
+            assert_eq!(pdf_add_qrcode(pdf, 0, 72.0, 72.0, 400.0, long.as_ptr(), PDF_QR_ECC_LOW), PDF_OK);
+
+            assert_eq!(pdf_add_qrcode(pdf, 0, 72.0, 600.0, 58.0, data.as_ptr(), 4), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_add_qrcode(pdf, 0, 72.0, 600.0, 0.0, data.as_ptr(), PDF_QR_ECC_LOW), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(
+                pdf_add_qrcode(pdf, 3, 72.0, 600.0, 58.0, data.as_ptr(), PDF_QR_ECC_LOW),
+                PDF_ERR_PAGE_OUT_OF_RANGE
+            );
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_add_printer_marks() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 648.0, 828.0);
+            assert_eq!(pdf_add_printer_marks(pdf, 0, PDF_MARKS_ALL), PDF_ERR_INVALID_ARGUMENT);
+            assert!(last_error().unwrap().contains("no trim box"));
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_TRIM, 18.0, 18.0, 612.0, 792.0), PDF_OK);
+            assert_eq!(pdf_set_page_box(pdf, 0, PDF_BOX_BLEED, 9.0, 9.0, 630.0, 810.0), PDF_OK);
+            assert_eq!(pdf_add_printer_marks(pdf, 0, 8), PDF_ERR_INVALID_ARGUMENT);
+
+            assert_eq!(pdf_add_printer_marks(pdf, 0, PDF_MARKS_CROP), PDF_OK);
+            // The bottom-left mark runs from past the bleed to the media edge
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains("6 18 m\n0 18 l\nS"));
+            assert!(ops.contains("1 1 1 1 K"));
+            assert!(!ops.contains(" c\n"));
+
+            assert_eq!(pdf_add_printer_marks(pdf, 0, PDF_MARKS_REGISTRATION | PDF_MARKS_COLOR_BAR), PDF_OK);
+            let ops = page_ops(pdf, 0);
+            assert!(ops.contains(" c\n"));
+            assert!(ops.contains("0 1 1 0 k"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_line_dash() {
+        let pattern = [6.0, 3.0];
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_line_dash(pdf, pattern.as_ptr(), pattern.len(), 1.5), PDF_OK);
+            pdf_draw_line(pdf, 0, 72.0, 700.0, 540.0, 700.0, 0.5);
+            assert_eq!(pdf_set_line_dash_solid(pdf), PDF_OK);
+            pdf_draw_line(pdf, 0, 72.0, 600.0, 540.0, 600.0, 0.5);
+
+            let ops = page_ops(pdf, 0);
+            assert!(ops.starts_with("q\n[6 3] 1.5 d\n0.5 w\n72 700 m"));
+            assert_eq!(ops.matches(" d\n").count(), 1);
+
+            let zeros = [0.0, 0.0];
+            let negative = [3.0, -1.0];
+            assert_eq!(pdf_set_line_dash(pdf, pattern.as_ptr(), 0, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_line_dash(pdf, ptr::null(), 2, 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_line_dash(pdf, zeros.as_ptr(), zeros.len(), 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!(pdf_set_line_dash(pdf, negative.as_ptr(), negative.len(), 0.0), PDF_ERR_INVALID_ARGUMENT);
+            assert_eq!((*pdf).style.line_dash, None);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_text_spacing() {
+        let text = CString::new("Hello, World").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_char_spacing(pdf, 1.0), PDF_OK);
+            assert_eq!(pdf_set_word_spacing(pdf, 2.0), PDF_OK);
+            assert_eq!(pdf_set_word_spacing(pdf, f64::NAN), PDF_ERR_INVALID_ARGUMENT);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 10.0);
+            assert!(page_ops(pdf, 0).starts_with("q\n1 Tc\n2 Tw\nBT"));
+
+            // Twelve characters and one space on top of the 54.45pt of glyphs
+            let width = pdf_measure_text(pdf, text.as_ptr(), 10.0, PDF_FONT_DEFAULT);
+            assert!((width - 68.45).abs() < 1e-9);
+
+            // Loaded fonts use two-byte codes, which word spacing does not apply to
+            let font_data = crate::font::test_font_bytes();
+            let name = CString::new("TestSans").unwrap();
+            let font_id = pdf_load_font(pdf, font_data.as_ptr(), font_data.len(), name.as_ptr());
+            let glyphs = CString::new("A \u{416}").unwrap();
+            assert_eq!(pdf_set_char_spacing(pdf, -0.5), PDF_OK);
+            let tight = pdf_measure_text(pdf, glyphs.as_ptr(), 10.0, font_id);
+            pdf_set_char_spacing(pdf, 0.0);
+            assert_eq!(tight, pdf_measure_text(pdf, glyphs.as_ptr(), 10.0, font_id) - 1.5);
+
+            pdf_set_word_spacing(pdf, 0.0);
+            assert_eq!(pdf_measure_text(pdf, text.as_ptr(), 10.0, PDF_FONT_DEFAULT), 54.45);
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_overprint() {
+        let text = CString::new("Black").unwrap();
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_set_compression(pdf, 0, 0);
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert_eq!(pdf_set_overprint(pdf, 1, 0), PDF_OK);
+            assert_eq!(pdf_set_overprint_mode(pdf, 1), PDF_OK);
+            assert_eq!(pdf_set_overprint_mode(pdf, 2), PDF_ERR_INVALID_ARGUMENT);
+            pdf_add_text(pdf, 0, 72.0, 700.0, text.as_ptr(), 10.0);
+            assert!(page_ops(pdf, 0).starts_with("q\n/GSOverprint101 gs\nBT"));
+
+            // Back to the defaults, later drawing knocks out again
+            pdf_set_overprint(pdf, 0, 0);
+            pdf_set_overprint_mode(pdf, 0);
+            pdf_add_text(pdf, 0, 72.0, 680.0, text.as_ptr(), 10.0);
+            assert!(page_ops(pdf, 0).ends_with("Q\nBT\n/F1 10 Tf\n72 680 Td\n(Black) Tj\nET"));
+
+            let content = output(pdf);
+            assert!(content.contains("/ExtGState << /GSOverprint101 << /Type /ExtGState /OP false /op true /OPM 1 >> >>"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_get_data_reflects_later_pages() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert!(output(pdf).contains("/Count 1"));
+            pdf_add_page(pdf, 0.0, 0.0);
+            assert!(output(pdf).contains("/Count 2"));
+            pdf_free(pdf);
+        }
+    }
+
+    #[test]
+    fn test_empty_document_has_no_data() {
+        unsafe {
+            let pdf = pdf_create_empty();
+            let mut data: *const u8 = ptr::null();
+            assert_eq!(pdf_get_data(pdf, &mut data), 0);
+            pdf_free(pdf);
+        }
+    }
+}
@@ -0,0 +1,352 @@
+//! PDF objects for embedded TrueType/OpenType fonts.
+//!
+//! Embedded fonts are written as Type 0 composite fonts with Identity-H
+//! encoding, so content streams address glyphs directly by glyph ID.
+
+use std::collections::{BTreeMap, BTreeSet};
+
+use super::subset::{glyph_closure, subset_truetype};
+use super::{OutlineFormat, TrueTypeFont};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
+use crate::types::ObjectId;
+
+/// Object IDs reserved for one embedded font.
+#[derive(Debug, Clone, Copy)]
+pub(crate) struct EmbeddedFontIds {
+    /// The Type 0 font referenced from page resources.
+    pub font: ObjectId,
+    /// The descendant CIDFont.
+    pub descendant: ObjectId,
+    /// The font descriptor.
+    pub descriptor: ObjectId,
+    /// The embedded font program.
+    pub font_file: ObjectId,
+    /// The ToUnicode CMap.
+    pub to_unicode: ObjectId,
+    /// The CIDSet listing the glyphs of a subset, which PDF/A-1 requires.
+    pub cid_set: Option<ObjectId>,
+}
+
+/// The objects that make up an embedded font.
+pub(crate) struct EmbeddedFontObjects {
+    pub font: PdfDictionary,
+    pub descendant: PdfDictionary,
+    pub descriptor: PdfDictionary,
+    pub font_file: PdfStream,
+    pub to_unicode: PdfStream,
+    pub cid_set: Option<PdfStream>,
+}
+
+/// Returns the base Type 0 font dictionary, without references to its descendants.
+pub(crate) fn type0_dictionary(base_font: &str) -> PdfDictionary {
+    let mut dict = PdfDictionary::new();
+    dict.set("Type", Object::Name(PdfName::font()));
+    dict.set("Subtype", Object::Name(PdfName::new_unchecked("Type0")));
+    dict.set("BaseFont", Object::Name(PdfName::new_unchecked(base_font)));
+    dict.set("Encoding", Object::Name(PdfName::new_unchecked("Identity-H")));
+    dict
+}
+
+/// Builds the PDF objects for a font, embedding only the glyphs in `used`.
+///
+/// Fonts with CFF outlines are embedded whole, as an OpenType font program.
+pub(crate) fn embedded_font_objects(
+    font: &TrueTypeFont,
+    used: &BTreeSet<u16>,
+    ids: &EmbeddedFontIds,
+) -> EmbeddedFontObjects {
+    let (glyphs, program, base_font) = match font.outline_format() {
+        OutlineFormat::TrueType => {
+            let glyphs = glyph_closure(font, used);
+            let program = subset_truetype(font, &glyphs);
+            let base_font = format!("{}+{}", subset_tag(font, &glyphs), font.postscript_name());
+            (glyphs, program, base_font)
+        }
+        OutlineFormat::Cff => {
+            let glyphs: BTreeSet<u16> = used.iter().copied().chain([0]).collect();
+            (glyphs, font.data().to_vec(), font.postscript_name().to_string())
+        }
+    };
+
+    let mut type0 = type0_dictionary(&base_font);
+    let mut descendants = PdfArray::new();
+    descendants.push(Object::Reference(ids.descendant));
+    type0.set("DescendantFonts", Object::Array(descendants));
+    type0.set("ToUnicode", Object::Reference(ids.to_unicode));
+
+    let mut system_info = PdfDictionary::new();
+    system_info.set("Registry", Object::String(PdfString::literal("Adobe")));
+    system_info.set("Ordering", Object::String(PdfString::literal("Identity")));
+    system_info.set("Supplement", Object::Integer(0));
+
+    let mut descendant = PdfDictionary::new();
+    descendant.set("Type", Object::Name(PdfName::font()));
+    descendant.set("BaseFont", Object::Name(PdfName::new_unchecked(&base_font)));
+    descendant.set("CIDSystemInfo", Object::Dictionary(system_info));
+    descendant.set("FontDescriptor", Object::Reference(ids.descriptor));
+    descendant.set("DW", Object::Integer(scale(font, font.advance_width(0) as i32)));
+    descendant.set("W", Object::Array(widths(font, &glyphs)));
+
+    let mut descriptor = descriptor(font, &base_font, ids.font_file);
+    let mut cid_set = None;
+    if let (OutlineFormat::TrueType, Some(id)) = (font.outline_format(), ids.cid_set) {
+        descriptor.set("CIDSet", Object::Reference(id));
+        cid_set = Some(PdfStream::new(cid_set_bits(&glyphs)));
+    }
+
+    let mut font_file = PdfStream::new(program);
+    match font.outline_format() {
+        OutlineFormat::TrueType => {
+            descendant.set("Subtype", Object::Name(PdfName::new_unchecked("CIDFontType2")));
+            descendant.set("CIDToGIDMap", Object::Name(PdfName::new_unchecked("Identity")));
+            let length = font_file.data.len() as i64;
+            font_file.dictionary.set("Length1", Object::Integer(length));
+        }
+        OutlineFormat::Cff => {
+            descendant.set("Subtype", Object::Name(PdfName::new_unchecked("CIDFontType0")));
+            font_file
+                .dictionary
+                .set("Subtype", Object::Name(PdfName::new_unchecked("OpenType")));
+        }
+    }
+
+    EmbeddedFontObjects {
+        font: type0,
+        descendant,
+        descriptor,
+        font_file,
+        to_unicode: PdfStream::from_text(to_unicode_cmap(font, &glyphs)),
+        cid_set,
+    }
+}
+
+/// Returns a CIDSet bit string, with the most significant bit of the first
+/// byte standing for CID 0.
+fn cid_set_bits(glyphs: &BTreeSet<u16>) -> Vec<u8> {
+    let len = glyphs.iter().next_back().map_or(0, |&max| max as usize / 8 + 1);
+    let mut bits = vec![0u8; len];
+    for &gid in glyphs {
+        bits[gid as usize / 8] |= 0x80 >> (gid % 8);
+    }
+    bits
+}
+
+/// Builds the font descriptor dictionary.
+fn descriptor(font: &TrueTypeFont, base_font: &str, font_file: ObjectId) -> PdfDictionary {
+    const FIXED_PITCH: i64 = 1 << 0;
+    const SYMBOLIC: i64 = 1 << 2;
+    const ITALIC: i64 = 1 << 6;
+
+    let mut flags = SYMBOLIC;
+    if font.is_fixed_pitch() {
+        flags |= FIXED_PITCH;
+    }
+    if font.is_italic() {
+        flags |= ITALIC;
+    }
+
+    let metrics = font.metrics();
+    let mut bbox = PdfArray::new();
+    for v in font.bbox() {
+        bbox.push(Object::Integer(scale(font, v as i32)));
+    }
+
+    let mut dict = PdfDictionary::new();
+    dict.set("Type", Object::Name(PdfName::new_unchecked("FontDescriptor")));
+    dict.set("FontName", Object::Name(PdfName::new_unchecked(base_font)));
+    dict.set("Flags", Object::Integer(flags));
+    dict.set("FontBBox", Object::Array(bbox));
+    dict.set("ItalicAngle", Object::Real(font.italic_angle()));
+    dict.set("Ascent", Object::Integer(scale(font, metrics.ascender as i32)));
+    dict.set("Descent", Object::Integer(scale(font, metrics.descender as i32)));
+    dict.set("CapHeight", Object::Integer(scale(font, font.cap_height() as i32)));
+    dict.set("StemV", Object::Integer(if font.is_bold() { 120 } else { 80 }));
+    let key = match font.outline_format() {
+        OutlineFormat::TrueType => "FontFile2",
+        OutlineFormat::Cff => "FontFile3",
+    };
+    dict.set(key, Object::Reference(font_file));
+    dict
+}
+
+/// Builds the CIDFont /W array, grouping consecutive glyph IDs.
+fn widths(font: &TrueTypeFont, glyphs: &BTreeSet<u16>) -> PdfArray {
+    let mut array = PdfArray::new();
+    let mut run: Option<(u16, u16, PdfArray)> = None;
+    for &gid in glyphs {
+        let width = Object::Integer(scale(font, font.advance_width(gid) as i32));
+        match run {
+            Some((_, ref mut last, ref mut group)) if *last + 1 == gid => {
+                group.push(width);
+                *last = gid;
+            }
+            _ => {
+                if let Some((start, _, group)) = run.take() {
+                    array.push(Object::Integer(start as i64));
+                    array.push(Object::Array(group));
+                }
+                let mut group = PdfArray::new();
+                group.push(width);
+                run = Some((gid, gid, group));
+            }
+        }
+    }
+    if let Some((start, _, group)) = run {
+        array.push(Object::Integer(start as i64));
+        array.push(Object::Array(group));
+    }
+    array
+}
+
+/// Builds a ToUnicode CMap so text can be extracted and searched.
+///
+/// Ligatures map to the characters of the glyphs they replace, so "fi"
+/// is found where the font draws it as one glyph.
+fn to_unicode_cmap(font: &TrueTypeFont, glyphs: &BTreeSet<u16>) -> String {
+    let mut chars: BTreeMap<u16, char> = BTreeMap::new();
+    for (&c, &gid) in font.char_map() {
+        let entry = chars.entry(gid).or_insert(c);
+        if c < *entry {
+            *entry = c;
+        }
+    }
+    let mut unicode: BTreeMap<u16, String> = chars
+        .iter()
+        .filter(|(gid, _)| glyphs.contains(gid))
+        .map(|(&gid, &c)| (gid, c.to_string()))
+        .collect();
+    for (ligature, components) in font.ligatures() {
+        if glyphs.contains(&ligature) {
+            let text: Option<String> = components.iter().map(|gid| chars.get(gid)).collect();
+            if let Some(text) = text {
+                unicode.insert(ligature, text);
+            }
+        }
+    }
+
+    let mut cmap = String::from(
+        "/CIDInit /ProcSet findresource begin\n\
+         12 dict begin\n\
+         begincmap\n\
+         /CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n\
+         /CMapName /Adobe-Identity-UCS def\n\
+         /CMapType 2 def\n\
+         1 begincodespacerange\n\
+         <0000> <FFFF>\n\
+         endcodespacerange\n",
+    );
+    let entries: Vec<(u16, String)> = unicode.into_iter().collect();
+    for chunk in entries.chunks(100) {
+        cmap.push_str(&format!("{} beginbfchar\n", chunk.len()));
+        for (gid, text) in chunk {
+            let hex: String = text.encode_utf16().map(|u| format!("{:04X}", u)).collect();
+            cmap.push_str(&format!("<{:04X}> <{}>\n", gid, hex));
+        }
+        cmap.push_str("endbfchar\n");
+    }
+    cmap.push_str(
+        "endcmap\n\
+         CMapName currentdict /CMap defineresource pop\n\
+         end\n\
+         end\n",
+    );
+    cmap
+}
+
+/// Derives the six-letter subset tag from the font name and glyph set.
+fn subset_tag(font: &TrueTypeFont, glyphs: &BTreeSet<u16>) -> String {
+    // FNV-1a, so the same subset always gets the same tag.
+    let mut hash: u32 = 0x811C_9DC5;
+    let bytes = font
+        .postscript_name()
+        .bytes()
+        .chain(glyphs.iter().flat_map(|g| g.to_be_bytes()));
+    for b in bytes {
+        hash ^= b as u32;
+        hash = hash.wrapping_mul(0x0100_0193);
+    }
+    (0..6)
+        .map(|_| {
+            let c = (b'A' + (hash % 26) as u8) as char;
+            hash /= 26;
+            c
+        })
+        .collect()
+}
+
+/// Converts font design units to PDF glyph space (1000 units per em).
+fn scale(font: &TrueTypeFont, value: i32) -> i64 {
+    (value as f64 * 1000.0 / font.units_per_em() as f64).round() as i64
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::font::truetype::{shaping_test_font_bytes, test_font_bytes};
+
+    fn ids() -> EmbeddedFontIds {
+        EmbeddedFontIds {
+            font: ObjectId::new(10),
+            descendant: ObjectId::new(11),
+            descriptor: ObjectId::new(12),
+            font_file: ObjectId::new(13),
+            to_unicode: ObjectId::new(14),
+            cid_set: None,
+        }
+    }
+
+    #[test]
+    fn test_embedded_font_objects() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let used: BTreeSet<u16> = [1, 3].into_iter().collect();
+        let objects = embedded_font_objects(&font, &used, &ids());
+
+        let type0 = objects.font.to_pdf_string();
+        assert!(type0.contains("/Subtype /Type0"));
+        assert!(type0.contains("/Encoding /Identity-H"));
+        assert!(type0.contains("+TestSans"));
+
+        let descendant = objects.descendant.to_pdf_string();
+        assert!(descendant.contains("/Subtype /CIDFontType2"));
+        assert!(descendant.contains("/W [0 [500 600] 3 [700]]"));
+
+        assert!(objects.descriptor.to_pdf_string().contains("/FontFile2 13 0 R"));
+        assert!(objects.cid_set.is_none());
+    }
+
+    #[test]
+    fn test_cid_set() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let used: BTreeSet<u16> = [1, 3].into_iter().collect();
+        let ids = EmbeddedFontIds {
+            cid_set: Some(ObjectId::new(15)),
+            ..ids()
+        };
+        let objects = embedded_font_objects(&font, &used, &ids);
+        assert!(objects.descriptor.to_pdf_string().contains("/CIDSet 15 0 R"));
+        assert_eq!(objects.cid_set.unwrap().data, vec![0b1101_0000]);
+    }
+
+    #[test]
+    fn test_to_unicode_cmap() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let glyphs: BTreeSet<u16> = [0, 1, 3].into_iter().collect();
+        let cmap = to_unicode_cmap(&font, &glyphs);
+        assert!(cmap.contains("2 beginbfchar\n<0001> <0041>\n<0003> <0416>\nendbfchar"));
+
+        // A ligature of A and B drawn as glyph 3 extracts as both letters
+        let font = TrueTypeFont::from_bytes(shaping_test_font_bytes()).unwrap();
+        let cmap = to_unicode_cmap(&font, &glyphs);
+        assert!(cmap.contains("<0001> <0041>\n<0003> <00410042>\n"));
+    }
+
+    #[test]
+    fn test_subset_tag_is_stable() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let glyphs: BTreeSet<u16> = [0, 1].into_iter().collect();
+        let tag = subset_tag(&font, &glyphs);
+        assert_eq!(tag.len(), 6);
+        assert!(tag.chars().all(|c| c.is_ascii_uppercase()));
+        assert_eq!(tag, subset_tag(&font, &glyphs));
+    }
+}
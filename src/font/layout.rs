@@ -0,0 +1,402 @@
+//! OpenType layout: pair kerning and standard ligatures.
+//!
+//! Only what horizontal text in simple scripts needs is read: pair
+//! adjustments of the `kern` feature in the `GPOS` table, or the older
+//! `kern` table when a font has no such feature, and the ligature
+//! substitutions of the `liga` feature in the `GSUB` table. Lookups are
+//! gathered from every script and language system, and their subtables are
+//! treated as one list, so a glyph pair takes the first adjustment found.
+//! Damaged tables are ignored.
+
+use std::collections::{HashMap, HashSet};
+
+/// Layout features applied by [`TrueTypeFont::shape`](super::TrueTypeFont::shape).
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Shaping {
+    /// Adjust the space between pairs of glyphs, such as "AV", as the font
+    /// asks.
+    pub kerning: bool,
+    /// Replace sequences of glyphs, such as "fi", with the font's ligatures.
+    pub ligatures: bool,
+}
+
+/// A glyph of shaped text.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ShapedGlyph {
+    /// The glyph ID.
+    pub gid: u16,
+    /// Change to the advance before the next glyph, in font design units;
+    /// negative values move the glyphs closer together.
+    pub kerning: i16,
+}
+
+/// Glyph pair adjustments in font design units.
+#[derive(Default)]
+pub(crate) struct Kerning {
+    /// Adjustments for individual pairs, which take precedence.
+    pairs: HashMap<(u16, u16), i16>,
+    /// Adjustments between classes of glyphs, in subtable order.
+    classes: Vec<ClassKerning>,
+}
+
+/// A pair positioning subtable that adjusts classes of glyphs.
+struct ClassKerning {
+    /// The first glyphs the subtable applies to.
+    coverage: HashSet<u16>,
+    /// Classes of first and second glyphs; glyphs not listed are class 0.
+    first: HashMap<u16, u16>,
+    second: HashMap<u16, u16>,
+    second_count: usize,
+    /// Adjustments indexed by first class * `second_count` + second class.
+    values: Vec<i16>,
+}
+
+impl Kerning {
+    /// Reads the `kern` feature of `gpos`, or failing that the `kern` table.
+    pub(crate) fn parse(gpos: Option<&[u8]>, kern: Option<&[u8]>) -> Self {
+        let from_gpos = gpos.map(parse_gpos_kerning).unwrap_or_default();
+        if !from_gpos.pairs.is_empty() || !from_gpos.classes.is_empty() {
+            return from_gpos;
+        }
+        Self {
+            pairs: kern.and_then(parse_kern_table).unwrap_or_default(),
+            classes: Vec::new(),
+        }
+    }
+
+    /// Returns the adjustment between `left` and the `right` glyph after it.
+    pub(crate) fn get(&self, left: u16, right: u16) -> i16 {
+        if let Some(&value) = self.pairs.get(&(left, right)) {
+            return value;
+        }
+        for class in &self.classes {
+            if class.coverage.contains(&left) {
+                let first = class.first.get(&left).copied().unwrap_or(0) as usize;
+                let second = class.second.get(&right).copied().unwrap_or(0) as usize;
+                return class.values.get(first * class.second_count + second).copied().unwrap_or(0);
+            }
+        }
+        0
+    }
+}
+
+/// Ligature substitutions: for each first glyph, the glyphs that must
+/// follow it and the ligature replacing them all, in order of preference.
+#[derive(Default)]
+pub(crate) struct Ligatures(HashMap<u16, Vec<(Vec<u16>, u16)>>);
+
+impl Ligatures {
+    /// Reads the `liga` feature of `gsub`.
+    pub(crate) fn parse(gsub: Option<&[u8]>) -> Self {
+        Self(gsub.and_then(parse_gsub_ligatures).unwrap_or_default())
+    }
+
+    /// Returns the ligature starting `glyphs` and the number of glyphs it
+    /// replaces, if there is one.
+    pub(crate) fn find(&self, glyphs: &[u16]) -> Option<(u16, usize)> {
+        let (first, rest) = glyphs.split_first()?;
+        self.0
+            .get(first)?
+            .iter()
+            .find(|(components, _)| rest.starts_with(components))
+            .map(|(components, ligature)| (*ligature, components.len() + 1))
+    }
+
+    /// Returns every ligature with the full sequence of glyphs it replaces.
+    pub(crate) fn iter(&self) -> impl Iterator<Item = (u16, Vec<u16>)> + '_ {
+        self.0.iter().flat_map(|(&first, entries)| {
+            entries
+                .iter()
+                .map(move |(rest, ligature)| (*ligature, std::iter::once(first).chain(rest.iter().copied()).collect()))
+        })
+    }
+}
+
+/// Lookup type of pair adjustments in `GPOS`.
+const PAIR_ADJUSTMENT: u16 = 2;
+/// Lookup type of extension subtables in `GPOS`, which point at 32-bit offsets.
+const GPOS_EXTENSION: u16 = 9;
+/// Lookup type of ligature substitutions in `GSUB`.
+const LIGATURE_SUBSTITUTION: u16 = 4;
+/// Lookup type of extension subtables in `GSUB`.
+const GSUB_EXTENSION: u16 = 7;
+
+fn parse_gpos_kerning(gpos: &[u8]) -> Kerning {
+    let mut kerning = Kerning::default();
+    let subtables = feature_subtables(gpos, b"kern", GPOS_EXTENSION).unwrap_or_default();
+    for (kind, offset) in subtables {
+        if kind == PAIR_ADJUSTMENT {
+            // A damaged subtable contributes what was read before the damage
+            let _ = parse_pair_adjustment(gpos, offset, &mut kerning);
+        }
+    }
+    kerning
+}
+
+/// Reads a pair adjustment subtable at `s` into `kerning`.
+fn parse_pair_adjustment(t: &[u8], s: usize, kerning: &mut Kerning) -> Option<()> {
+    let coverage = coverage(t, s + u16_at(t, s + 2)? as usize)?;
+    let (format1, format2) = (u16_at(t, s + 4)?, u16_at(t, s + 6)?);
+    let size = value_size(format1) + value_size(format2);
+    match u16_at(t, s)? {
+        1 => {
+            for (i, &first) in coverage.iter().enumerate() {
+                let set = s + u16_at(t, s + 10 + i * 2)? as usize;
+                for j in 0..u16_at(t, set)? as usize {
+                    let record = set + 2 + j * (2 + size);
+                    let second = u16_at(t, record)?;
+                    let value = x_advance(t, record + 2, format1)?;
+                    kerning.pairs.entry((first, second)).or_insert(value);
+                }
+            }
+        }
+        2 => {
+            let first = class_def(t, s + u16_at(t, s + 8)? as usize)?;
+            let second = class_def(t, s + u16_at(t, s + 10)? as usize)?;
+            let (first_count, second_count) = (u16_at(t, s + 12)? as usize, u16_at(t, s + 14)? as usize);
+            let mut values = Vec::with_capacity(first_count * second_count);
+            for i in 0..first_count * second_count {
+                values.push(x_advance(t, s + 16 + i * size, format1)?);
+            }
+            kerning.classes.push(ClassKerning {
+                coverage: coverage.into_iter().collect(),
+                first,
+                second,
+                second_count,
+                values,
+            });
+        }
+        _ => {}
+    }
+    Some(())
+}
+
+/// Returns the size of a value record of `format`, in bytes.
+fn value_size(format: u16) -> usize {
+    format.count_ones() as usize * 2
+}
+
+/// Reads the horizontal advance adjustment of the value record at `offset`.
+fn x_advance(t: &[u8], offset: usize, format: u16) -> Option<i16> {
+    if format & 0x0004 == 0 {
+        return Some(0);
+    }
+    // Placement adjustments come first
+    u16_at(t, offset + value_size(format & 0x0003)).map(|v| v as i16)
+}
+
+/// Reads the horizontal, format 0 subtables of a `kern` table.
+fn parse_kern_table(t: &[u8]) -> Option<HashMap<(u16, u16), i16>> {
+    // Apple's version 1 table starts with a 32-bit version and is not read
+    if u16_at(t, 0)? != 0 {
+        return None;
+    }
+    let mut pairs = HashMap::new();
+    let mut offset = 4;
+    for _ in 0..u16_at(t, 2)? {
+        let length = u16_at(t, offset + 2)? as usize;
+        let coverage = u16_at(t, offset + 4)?;
+        // Horizontal kerning values rather than minimums or cross-stream shifts
+        if coverage >> 8 == 0 && coverage & 0x0007 == 0x0001 {
+            for i in 0..u16_at(t, offset + 6)? as usize {
+                let pair = offset + 14 + i * 6;
+                let key = (u16_at(t, pair)?, u16_at(t, pair + 2)?);
+                pairs.entry(key).or_insert(u16_at(t, pair + 4)? as i16);
+            }
+        }
+        offset += length.max(6);
+    }
+    Some(pairs)
+}
+
+fn parse_gsub_ligatures(t: &[u8]) -> Option<HashMap<u16, Vec<(Vec<u16>, u16)>>> {
+    let mut ligatures: HashMap<u16, Vec<(Vec<u16>, u16)>> = HashMap::new();
+    for (kind, s) in feature_subtables(t, b"liga", GSUB_EXTENSION)? {
+        if kind != LIGATURE_SUBSTITUTION || u16_at(t, s)? != 1 {
+            continue;
+        }
+        let coverage = coverage(t, s + u16_at(t, s + 2)? as usize)?;
+        let set_count = u16_at(t, s + 4)? as usize;
+        for (i, &first) in coverage.iter().enumerate().take(set_count) {
+            let set = s + u16_at(t, s + 6 + i * 2)? as usize;
+            for j in 0..u16_at(t, set)? as usize {
+                let ligature = set + u16_at(t, set + 2 + j * 2)? as usize;
+                let glyph = u16_at(t, ligature)?;
+                let components = (1..u16_at(t, ligature + 2)? as usize)
+                    .map(|k| u16_at(t, ligature + 2 + k * 2))
+                    .collect::<Option<Vec<u16>>>()?;
+                if !components.is_empty() {
+                    ligatures.entry(first).or_default().push((components, glyph));
+                }
+            }
+        }
+    }
+    Some(ligatures)
+}
+
+/// Returns the subtables of the lookups that `table`, a `GSUB` or `GPOS`
+/// table, lists for feature `tag`, in lookup order, as their lookup type and
+/// offset. Subtables of the `extension` lookup type are followed to the
+/// subtable they wrap.
+fn feature_subtables(table: &[u8], tag: &[u8; 4], extension: u16) -> Option<Vec<(u16, usize)>> {
+    let features = u16_at(table, 6)? as usize;
+    let lookups = u16_at(table, 8)? as usize;
+    let mut indices = Vec::new();
+    for i in 0..u16_at(table, features)? as usize {
+        let record = features + 2 + i * 6;
+        if table.get(record..record + 4)? != tag {
+            continue;
+        }
+        let feature = features + u16_at(table, record + 4)? as usize;
+        for j in 0..u16_at(table, feature + 2)? as usize {
+            indices.push(u16_at(table, feature + 4 + j * 2)? as usize);
+        }
+    }
+    indices.sort_unstable();
+    indices.dedup();
+
+    let mut subtables = Vec::new();
+    for index in indices {
+        let lookup = lookups + u16_at(table, lookups + 2 + index * 2)? as usize;
+        let kind = u16_at(table, lookup)?;
+        for k in 0..u16_at(table, lookup + 4)? as usize {
+            let subtable = lookup + u16_at(table, lookup + 6 + k * 2)? as usize;
+            if kind == extension {
+                subtables.push((u16_at(table, subtable + 2)?, subtable + u32_at(table, subtable + 4)? as usize));
+            } else {
+                subtables.push((kind, subtable));
+            }
+        }
+    }
+    Some(subtables)
+}
+
+/// Reads a coverage table as the glyphs it lists, in coverage index order.
+fn coverage(t: &[u8], offset: usize) -> Option<Vec<u16>> {
+    let count = u16_at(t, offset + 2)? as usize;
+    match u16_at(t, offset)? {
+        1 => (0..count).map(|i| u16_at(t, offset + 4 + i * 2)).collect(),
+        2 => {
+            let mut glyphs = Vec::new();
+            for i in 0..count {
+                let range = offset + 4 + i * 6;
+                glyphs.extend(u16_at(t, range)?..=u16_at(t, range + 2)?);
+            }
+            Some(glyphs)
+        }
+        _ => None,
+    }
+}
+
+/// Reads a class definition table as a map from glyph to class.
+fn class_def(t: &[u8], offset: usize) -> Option<HashMap<u16, u16>> {
+    let mut classes = HashMap::new();
+    match u16_at(t, offset)? {
+        1 => {
+            let start = u16_at(t, offset + 2)?;
+            for i in 0..u16_at(t, offset + 4)? {
+                classes.insert(start.checked_add(i)?, u16_at(t, offset + 6 + i as usize * 2)?);
+            }
+        }
+        2 => {
+            for i in 0..u16_at(t, offset + 2)? as usize {
+                let range = offset + 4 + i * 6;
+                let class = u16_at(t, range + 4)?;
+                for gid in u16_at(t, range)?..=u16_at(t, range + 2)? {
+                    classes.insert(gid, class);
+                }
+            }
+        }
+        _ => return None,
+    }
+    Some(classes)
+}
+
+fn u16_at(t: &[u8], offset: usize) -> Option<u16> {
+    let bytes = t.get(offset..offset.checked_add(2)?)?;
+    Some(u16::from_be_bytes([bytes[0], bytes[1]]))
+}
+
+fn u32_at(t: &[u8], offset: usize) -> Option<u32> {
+    let bytes = t.get(offset..offset.checked_add(4)?)?;
+    Some(u32::from_be_bytes([bytes[0], bytes[1], bytes[2], bytes[3]]))
+}
+
+/// Builds a `GSUB` or `GPOS` table with one feature, `tag`, whose single
+/// lookup of `kind` has `subtables`.
+#[cfg(test)]
+pub(crate) fn layout_table(tag: &[u8; 4], kind: u16, subtables: &[Vec<u8>]) -> Vec<u8> {
+    let be = |values: &[u16]| values.iter().flat_map(|v| v.to_be_bytes()).collect::<Vec<u8>>();
+    // Header, an empty script list, then the feature and lookup lists
+    let mut table = be(&[1, 0, 10, 12, 26, 0]);
+    table.extend(be(&[1]));
+    table.extend_from_slice(tag);
+    table.extend(be(&[8, 0, 1, 0]));
+    let mut lookup = be(&[1, 4, kind, 0, subtables.len() as u16]);
+    let mut offset = 6 + subtables.len() * 2;
+    for subtable in subtables {
+        lookup.extend(be(&[offset as u16]));
+        offset += subtable.len();
+    }
+    for subtable in subtables {
+        lookup.extend_from_slice(subtable);
+    }
+    table.extend(lookup);
+    table
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn be(values: &[u16]) -> Vec<u8> {
+        values.iter().flat_map(|v| v.to_be_bytes()).collect()
+    }
+
+    #[test]
+    fn test_gpos_pair_kerning() {
+        // Format 1: A (1) before B (2) moves back 80 units
+        let pairs = be(&[1, 12, 4, 0, 1, 18, 1, 1, 1, 1, 2, (-80i16) as u16]);
+        // Format 2: any glyph of class 1 (A) before class 1 (B, Zhe) moves back 30
+        let mut classes = be(&[2, 24, 4, 0, 30, 38, 2, 2, 0, 0, 0, (-30i16) as u16]);
+        classes.extend(be(&[1, 1, 1]));
+        classes.extend(be(&[1, 1, 1, 1]));
+        classes.extend(be(&[2, 1, 2, 3, 1]));
+        let gpos = layout_table(b"kern", PAIR_ADJUSTMENT, &[pairs, classes]);
+        let kerning = Kerning::parse(Some(&gpos), None);
+        assert_eq!(kerning.get(1, 2), -80);
+        assert_eq!(kerning.get(1, 3), -30);
+        assert_eq!(kerning.get(2, 1), 0);
+        assert_eq!(kerning.get(1, 1), 0);
+    }
+
+    #[test]
+    fn test_kern_table() {
+        let kern = be(&[0, 1, 0, 20, 0x0001, 1, 6, 0, 0, 1, 2, (-50i16) as u16]);
+        let kerning = Kerning::parse(None, Some(&kern));
+        assert_eq!(kerning.get(1, 2), -50);
+        assert_eq!(kerning.get(2, 1), 0);
+        // Vertical subtables are not horizontal kerning
+        let vertical = be(&[0, 1, 0, 20, 0x0000, 1, 6, 0, 0, 1, 2, (-50i16) as u16]);
+        assert_eq!(Kerning::parse(None, Some(&vertical)).get(1, 2), 0);
+    }
+
+    #[test]
+    fn test_gsub_ligatures() {
+        // A B -> Zhe (3), and A A B -> B (2), preferred as it comes first
+        let subtable = be(&[1, 8, 1, 14, 1, 1, 1, 2, 6, 14, 2, 3, 1, 2, 3, 2, 2]);
+        let gsub = layout_table(b"liga", LIGATURE_SUBSTITUTION, &[subtable]);
+        let ligatures = Ligatures::parse(Some(&gsub));
+        assert_eq!(ligatures.find(&[1, 2, 1]), Some((3, 2)));
+        assert_eq!(ligatures.find(&[1, 1, 2]), Some((2, 3)));
+        assert_eq!(ligatures.find(&[1, 1]), None);
+        assert_eq!(ligatures.find(&[2, 1]), None);
+        let mut all: Vec<(u16, Vec<u16>)> = ligatures.iter().collect();
+        all.sort();
+        assert_eq!(all, vec![(2, vec![1, 1, 2]), (3, vec![1, 2])]);
+
+        // Other features are not applied
+        let gsub = layout_table(b"dlig", LIGATURE_SUBSTITUTION, &[be(&[1, 6, 0, 1, 1, 1])]);
+        assert_eq!(Ligatures::parse(Some(&gsub)).find(&[1, 2]), None);
+    }
+}
@@ -12,6 +12,17 @@ pub struct FontMetrics {
     pub descender: i16,
     /// Line gap.
     pub line_gap: i16,
+    /// Height of capital letters above the baseline.
+    pub cap_height: i16,
+    /// Position of the center of an underline relative to the baseline
+    /// (negative below it).
+    pub underline_position: i16,
+    /// Thickness of an underline.
+    pub underline_thickness: i16,
+    /// Position of the center of a strikeout line above the baseline.
+    pub strikeout_position: i16,
+    /// Thickness of a strikeout line.
+    pub strikeout_thickness: i16,
     /// Average character width.
     pub avg_width: u16,
 }
@@ -19,7 +30,10 @@ pub struct FontMetrics {
 impl FontMetrics {
     /// Returns approximate metrics for a standard 14 font.
     ///
-    /// These are simplified metrics suitable for basic text layout.
+    /// These are simplified metrics suitable for basic text layout. The
+    /// symbolic fonts have no capitals, so their cap height is the ascender.
+    /// The AFM files give no strikeout metrics, so strikeout lines sit a
+    /// quarter of the em above the baseline, as thick as underlines.
     pub fn for_standard14(font: Standard14Font) -> Self {
         match font {
             Standard14Font::TimesRoman
@@ -30,6 +44,16 @@ impl FontMetrics {
                 ascender: 683,
                 descender: -217,
                 line_gap: 0,
+                cap_height: match font {
+                    Standard14Font::TimesBold => 676,
+                    Standard14Font::TimesItalic => 653,
+                    Standard14Font::TimesBoldItalic => 669,
+                    _ => 662,
+                },
+                underline_position: -100,
+                underline_thickness: 50,
+                strikeout_position: 250,
+                strikeout_thickness: 50,
                 avg_width: 480,
             },
             Standard14Font::Helvetica
@@ -40,6 +64,11 @@ impl FontMetrics {
                 ascender: 718,
                 descender: -207,
                 line_gap: 0,
+                cap_height: 718,
+                underline_position: -100,
+                underline_thickness: 50,
+                strikeout_position: 250,
+                strikeout_thickness: 50,
                 avg_width: 520,
             },
             Standard14Font::Courier
@@ -50,6 +79,11 @@ impl FontMetrics {
                 ascender: 629,
                 descender: -157,
                 line_gap: 0,
+                cap_height: 562,
+                underline_position: -100,
+                underline_thickness: 50,
+                strikeout_position: 250,
+                strikeout_thickness: 50,
                 avg_width: 600,
             },
             Standard14Font::Symbol | Standard14Font::ZapfDingbats => Self {
@@ -57,6 +91,11 @@ impl FontMetrics {
                 ascender: 800,
                 descender: -200,
                 line_gap: 0,
+                cap_height: 800,
+                underline_position: -100,
+                underline_thickness: 50,
+                strikeout_position: 250,
+                strikeout_thickness: 50,
                 avg_width: 500,
             },
         }
@@ -77,9 +116,11 @@ impl FontMetrics {
     }
 }
 
-/// Simple width table for common ASCII characters in Helvetica.
+/// Width table for the printable ASCII characters in Helvetica, from the
+/// font's AFM metrics.
 ///
-/// Widths are in 1/1000 of a unit (scaled by 1000/units_per_em).
+/// Widths are in 1/1000 of a unit (scaled by 1000/units_per_em). Other
+/// characters use the width of a digit.
 pub fn helvetica_char_width(c: char) -> u16 {
     match c {
         ' ' => 278,
@@ -102,28 +143,22 @@ pub fn helvetica_char_width(c: char) -> u16 {
         '<' | '=' | '>' => 584,
         '?' => 556,
         '@' => 1015,
-        'A' | 'B' | 'C' | 'D' | 'E' | 'F' | 'G' | 'H' | 'I' | 'J' | 'K' | 'L' | 'M' | 'N'
-        | 'O' | 'P' | 'Q' | 'R' | 'S' | 'T' | 'U' | 'V' | 'W' | 'X' | 'Y' | 'Z' => {
-            match c {
-                'I' => 278,
-                'J' => 500,
-                'M' | 'W' => 833,
-                'Q' | 'O' | 'G' | 'C' | 'D' => 722,
-                'A' | 'B' | 'E' | 'F' | 'H' | 'K' | 'L' | 'N' | 'P' | 'R' | 'S' | 'T' | 'U'
-                | 'V' | 'X' | 'Y' | 'Z' => 611,
-                _ => 611,
-            }
-        }
-        'a'..='z' => match c {
-            'i' | 'l' => 222,
-            'j' => 222,
-            'm' => 833,
-            'w' => 722,
-            'f' | 't' => 278,
-            'r' => 333,
-            's' => 500,
-            _ => 556,
-        },
+        'A' | 'B' | 'E' | 'K' | 'P' | 'S' | 'V' | 'X' | 'Y' => 667,
+        'C' | 'D' | 'H' | 'N' | 'R' | 'U' => 722,
+        'F' | 'T' | 'Z' => 611,
+        'G' | 'O' | 'Q' => 778,
+        'I' => 278,
+        'J' => 500,
+        'L' => 556,
+        'M' => 833,
+        'W' => 944,
+        'a' | 'b' | 'd' | 'e' | 'g' | 'h' | 'n' | 'o' | 'p' | 'q' | 'u' => 556,
+        'c' | 'k' | 's' | 'v' | 'x' | 'y' | 'z' => 500,
+        'f' | 't' => 278,
+        'i' | 'j' | 'l' => 222,
+        'm' => 833,
+        'r' => 333,
+        'w' => 722,
         '[' | ']' => 278,
         '\\' => 278,
         '^' => 469,
@@ -142,6 +177,61 @@ pub fn calculate_helvetica_width(text: &str, font_size: f64) -> f64 {
     font_size * total_width as f64 / 1000.0
 }
 
+/// Width table for the printable ASCII characters in Helvetica-Bold, in the
+/// same units as [`helvetica_char_width`].
+pub fn helvetica_bold_char_width(c: char) -> u16 {
+    match c {
+        ' ' => 278,
+        '!' => 333,
+        '"' => 474,
+        '#' | '$' => 556,
+        '%' => 889,
+        '&' => 722,
+        '\'' => 238,
+        '(' | ')' => 333,
+        '*' => 389,
+        '+' => 584,
+        ',' | '.' | '/' => 278,
+        '-' => 333,
+        '0'..='9' => 556,
+        ':' | ';' => 333,
+        '<' | '=' | '>' => 584,
+        '?' => 611,
+        '@' => 975,
+        'A' | 'B' | 'C' | 'D' | 'H' | 'K' | 'N' | 'R' | 'U' => 722,
+        'E' | 'P' | 'S' | 'V' | 'X' | 'Y' => 667,
+        'F' | 'L' | 'T' | 'Z' => 611,
+        'G' | 'O' | 'Q' => 778,
+        'I' => 278,
+        'J' => 556,
+        'M' => 833,
+        'W' => 944,
+        'a' | 'c' | 'e' | 'k' | 's' | 'v' | 'x' | 'y' => 556,
+        'b' | 'd' | 'g' | 'h' | 'n' | 'o' | 'p' | 'q' | 'u' => 611,
+        'f' | 't' => 333,
+        'i' | 'j' | 'l' => 278,
+        'm' => 889,
+        'r' => 389,
+        'w' => 778,
+        'z' => 500,
+        '[' | ']' => 333,
+        '\\' => 278,
+        '^' => 584,
+        '_' => 556,
+        '`' => 333,
+        '{' | '}' => 389,
+        '|' => 280,
+        '~' => 584,
+        _ => 556,
+    }
+}
+
+/// Calculates the exact width of text in Helvetica-Bold.
+pub fn calculate_helvetica_bold_width(text: &str, font_size: f64) -> f64 {
+    let total_width: u32 = text.chars().map(|c| helvetica_bold_char_width(c) as u32).sum();
+    font_size * total_width as f64 / 1000.0
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -152,6 +242,9 @@ mod tests {
         assert_eq!(metrics.units_per_em, 1000);
         assert!(metrics.ascender > 0);
         assert!(metrics.descender < 0);
+        assert_eq!(metrics.cap_height, 718);
+        assert_eq!(FontMetrics::for_standard14(Standard14Font::TimesBold).cap_height, 676);
+        assert_eq!((metrics.underline_position, metrics.underline_thickness), (-100, 50));
     }
 
     #[test]
@@ -166,11 +259,15 @@ mod tests {
         assert_eq!(helvetica_char_width(' '), 278);
         assert_eq!(helvetica_char_width('I'), 278);
         assert_eq!(helvetica_char_width('M'), 833);
+        assert_eq!(helvetica_char_width('W'), 944);
+        assert_eq!(helvetica_char_width('y'), 500);
     }
 
     #[test]
     fn test_calculate_width() {
         let width = calculate_helvetica_width("Hello", 12.0);
         assert!(width > 0.0);
+        assert!(calculate_helvetica_bold_width("Hello", 12.0) > width);
+        assert_eq!(helvetica_bold_char_width('m'), 889);
     }
 }
@@ -1,10 +1,22 @@
 //! Font handling for PDF documents.
 
+pub(crate) mod embed;
+mod layout;
 mod metrics;
 mod standard14;
-
-pub use metrics::{calculate_helvetica_width, helvetica_char_width, FontMetrics};
+mod subset;
+mod truetype;
+
+pub use metrics::{
+    calculate_helvetica_bold_width, calculate_helvetica_width, helvetica_bold_char_width, helvetica_char_width,
+    FontMetrics,
+};
+pub use layout::{ShapedGlyph, Shaping};
 pub use standard14::Standard14Font;
+pub use truetype::{OutlineFormat, TrueTypeFont};
+
+#[cfg(test)]
+pub(crate) use truetype::{shaping_test_font_bytes, test_font_bytes, vertical_test_font_bytes};
 
 use crate::object::PdfDictionary;
 
@@ -13,6 +25,8 @@ use crate::object::PdfDictionary;
 pub enum Font {
     /// One of the 14 standard PDF fonts.
     Standard14(Standard14Font),
+    /// An embedded TrueType or OpenType font, subset to the glyphs used.
+    TrueType(TrueTypeFont),
 }
 
 impl Font {
@@ -40,13 +54,26 @@ impl Font {
     pub fn postscript_name(&self) -> &str {
         match self {
             Font::Standard14(f) => f.postscript_name(),
+            Font::TrueType(f) => f.postscript_name(),
+        }
+    }
+
+    /// Returns the embedded font, if this is a TrueType/OpenType font.
+    pub fn as_truetype(&self) -> Option<&TrueTypeFont> {
+        match self {
+            Font::TrueType(f) => Some(f),
+            _ => None,
         }
     }
 
     /// Converts the font to a PDF dictionary.
+    ///
+    /// For embedded fonts this is the Type 0 font dictionary without its
+    /// descendant font; the document writer adds the embedded objects.
     pub fn to_dictionary(&self) -> PdfDictionary {
         match self {
             Font::Standard14(f) => f.to_dictionary(),
+            Font::TrueType(f) => embed::type0_dictionary(f.postscript_name()),
         }
     }
 
@@ -54,12 +81,18 @@ impl Font {
     pub fn metrics(&self) -> FontMetrics {
         match self {
             Font::Standard14(f) => FontMetrics::for_standard14(*f),
+            Font::TrueType(f) => f.metrics(),
         }
     }
 
     /// Estimates the width of text at a given font size.
+    ///
+    /// Embedded fonts use their actual glyph advance widths.
     pub fn estimate_width(&self, text: &str, font_size: f64) -> f64 {
-        self.metrics().estimate_width(text, font_size)
+        match self {
+            Font::TrueType(f) => f.text_width(text, font_size),
+            _ => self.metrics().estimate_width(text, font_size),
+        }
     }
 }
 
@@ -69,6 +102,12 @@ impl From<Standard14Font> for Font {
     }
 }
 
+impl From<TrueTypeFont> for Font {
+    fn from(f: TrueTypeFont) -> Self {
+        Font::TrueType(f)
+    }
+}
+
 impl Default for Font {
     fn default() -> Self {
         Font::helvetica()
@@ -117,6 +156,15 @@ mod tests {
         assert_eq!(metrics.units_per_em, 1000);
     }
 
+    #[test]
+    fn test_truetype_font() {
+        let font: Font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap().into();
+        assert_eq!(font.postscript_name(), "TestSans");
+        assert!(font.as_truetype().is_some());
+        assert_eq!(font.estimate_width("AB", 10.0), 11.5);
+        assert!(font.to_dictionary().to_pdf_string().contains("/Subtype /Type0"));
+    }
+
     #[test]
     fn test_estimate_width() {
         let font = Font::helvetica();
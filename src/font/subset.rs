@@ -0,0 +1,198 @@
+//! TrueType font subsetting.
+
+use std::collections::BTreeSet;
+
+use super::TrueTypeFont;
+
+/// Tables copied into a subset font program, as required for embedding in PDF.
+const SUBSET_TABLES: [&[u8; 4]; 9] = [
+    b"cvt ", b"fpgm", b"glyf", b"head", b"hhea", b"hmtx", b"loca", b"maxp", b"prep",
+];
+
+/// Returns the given glyphs plus `.notdef` and every glyph referenced by a composite.
+pub(crate) fn glyph_closure(font: &TrueTypeFont, glyphs: &BTreeSet<u16>) -> BTreeSet<u16> {
+    let mut closure = BTreeSet::new();
+    let mut pending: Vec<u16> = glyphs.iter().copied().chain([0]).collect();
+    while let Some(gid) = pending.pop() {
+        if gid >= font.num_glyphs() || !closure.insert(gid) {
+            continue;
+        }
+        pending.extend(composite_components(font.glyph_data(gid)));
+    }
+    closure
+}
+
+/// Returns the glyph IDs referenced by a composite glyph.
+fn composite_components(data: &[u8]) -> Vec<u16> {
+    const ARG_1_AND_2_ARE_WORDS: u16 = 0x0001;
+    const WE_HAVE_A_SCALE: u16 = 0x0008;
+    const MORE_COMPONENTS: u16 = 0x0020;
+    const WE_HAVE_AN_X_AND_Y_SCALE: u16 = 0x0040;
+    const WE_HAVE_A_TWO_BY_TWO: u16 = 0x0080;
+
+    let read = |pos: usize| data.get(pos..pos + 2).map(|b| u16::from_be_bytes([b[0], b[1]]));
+
+    let mut components = Vec::new();
+    match read(0) {
+        Some(contours) if (contours as i16) < 0 => {}
+        _ => return components,
+    }
+
+    let mut pos = 10;
+    while let (Some(flags), Some(gid)) = (read(pos), read(pos + 2)) {
+        components.push(gid);
+        pos += 4;
+        pos += if flags & ARG_1_AND_2_ARE_WORDS != 0 { 4 } else { 2 };
+        if flags & WE_HAVE_A_SCALE != 0 {
+            pos += 2;
+        } else if flags & WE_HAVE_AN_X_AND_Y_SCALE != 0 {
+            pos += 4;
+        } else if flags & WE_HAVE_A_TWO_BY_TWO != 0 {
+            pos += 8;
+        }
+        if flags & MORE_COMPONENTS == 0 {
+            break;
+        }
+    }
+    components
+}
+
+/// Builds a TrueType font program containing only the outlines of `glyphs`.
+///
+/// Glyph IDs are preserved so content streams can keep using the original IDs;
+/// glyphs outside the subset are left empty.
+pub(crate) fn subset_truetype(font: &TrueTypeFont, glyphs: &BTreeSet<u16>) -> Vec<u8> {
+    let mut glyf = Vec::new();
+    let mut loca = Vec::with_capacity((font.num_glyphs() as usize + 1) * 4);
+    for gid in 0..font.num_glyphs() {
+        loca.extend_from_slice(&(glyf.len() as u32).to_be_bytes());
+        if glyphs.contains(&gid) {
+            glyf.extend_from_slice(font.glyph_data(gid));
+            pad4(&mut glyf);
+        }
+    }
+    loca.extend_from_slice(&(glyf.len() as u32).to_be_bytes());
+
+    let mut tables: Vec<([u8; 4], Vec<u8>)> = Vec::new();
+    for tag in SUBSET_TABLES {
+        let data = match tag {
+            b"glyf" => std::mem::take(&mut glyf),
+            b"loca" => std::mem::take(&mut loca),
+            _ => match font.table(tag) {
+                Some(data) => data.to_vec(),
+                None => continue,
+            },
+        };
+        tables.push((*tag, data));
+    }
+
+    // Switch to long loca offsets and clear the checksum adjustment until the file is complete.
+    if let Some((_, head)) = tables.iter_mut().find(|(tag, _)| tag == b"head") {
+        if head.len() >= 52 {
+            head[8..12].copy_from_slice(&[0; 4]);
+            head[50..52].copy_from_slice(&1u16.to_be_bytes());
+        }
+    }
+
+    let mut out = write_sfnt(&tables);
+
+    let head_offset = table_offset(&out, b"head");
+    if let Some(offset) = head_offset {
+        let adjustment = 0xB1B0_AFBAu32.wrapping_sub(checksum(&out));
+        out[offset + 8..offset + 12].copy_from_slice(&adjustment.to_be_bytes());
+    }
+    out
+}
+
+/// Serializes tables into an sfnt font file.
+fn write_sfnt(tables: &[([u8; 4], Vec<u8>)]) -> Vec<u8> {
+    let mut tables: Vec<&([u8; 4], Vec<u8>)> = tables.iter().collect();
+    tables.sort_by_key(|(tag, _)| *tag);
+
+    let num_tables = tables.len() as u16;
+    let entry_selector = 15 - num_tables.max(1).leading_zeros() as u16;
+    let search_range = (1u16 << entry_selector) * 16;
+
+    let mut out = Vec::new();
+    out.extend_from_slice(&0x0001_0000u32.to_be_bytes());
+    out.extend_from_slice(&num_tables.to_be_bytes());
+    out.extend_from_slice(&search_range.to_be_bytes());
+    out.extend_from_slice(&entry_selector.to_be_bytes());
+    out.extend_from_slice(&(num_tables * 16 - search_range).to_be_bytes());
+
+    let mut offset = 12 + tables.len() * 16;
+    for (tag, data) in &tables {
+        out.extend_from_slice(tag);
+        out.extend_from_slice(&checksum(data).to_be_bytes());
+        out.extend_from_slice(&(offset as u32).to_be_bytes());
+        out.extend_from_slice(&(data.len() as u32).to_be_bytes());
+        offset += (data.len() + 3) & !3;
+    }
+    for (_, data) in &tables {
+        out.extend_from_slice(data);
+        pad4(&mut out);
+    }
+    out
+}
+
+/// Finds a table's offset in a serialized sfnt file.
+fn table_offset(font: &[u8], tag: &[u8; 4]) -> Option<usize> {
+    let num_tables = u16::from_be_bytes([font[4], font[5]]) as usize;
+    (0..num_tables).find_map(|i| {
+        let rec = 12 + i * 16;
+        if &font[rec..rec + 4] == tag {
+            let offset = &font[rec + 8..rec + 12];
+            Some(u32::from_be_bytes([offset[0], offset[1], offset[2], offset[3]]) as usize)
+        } else {
+            None
+        }
+    })
+}
+
+/// Computes an sfnt table checksum (sum of big-endian u32 words).
+fn checksum(data: &[u8]) -> u32 {
+    data.chunks(4).fold(0u32, |sum, chunk| {
+        let mut word = [0u8; 4];
+        word[..chunk.len()].copy_from_slice(chunk);
+        sum.wrapping_add(u32::from_be_bytes(word))
+    })
+}
+
+fn pad4(data: &mut Vec<u8>) {
+    while data.len() % 4 != 0 {
+        data.push(0);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::font::truetype::test_font_bytes;
+
+    #[test]
+    fn test_glyph_closure_includes_components() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let used: BTreeSet<u16> = [3].into_iter().collect();
+        let closure = glyph_closure(&font, &used);
+        assert_eq!(closure.into_iter().collect::<Vec<_>>(), vec![0, 1, 3]);
+    }
+
+    #[test]
+    fn test_subset_drops_unused_outlines() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let used: BTreeSet<u16> = [0, 1].into_iter().collect();
+        let subset = subset_truetype(&font, &used);
+
+        let glyf = table_offset(&subset, b"glyf").unwrap();
+        let loca = table_offset(&subset, b"loca").unwrap();
+        let glyph_len = font.glyph_data(1).len() as u32;
+        let offsets: Vec<u32> = subset[loca..loca + 20]
+            .chunks(4)
+            .map(|b| u32::from_be_bytes([b[0], b[1], b[2], b[3]]))
+            .collect();
+        assert_eq!(offsets, vec![0, 0, glyph_len, glyph_len, glyph_len]);
+        assert_eq!(&subset[glyf..glyf + glyph_len as usize], font.glyph_data(1));
+        assert!(table_offset(&subset, b"cmap").is_none());
+        assert_eq!(checksum(&subset), 0xB1B0_AFBA);
+    }
+}
@@ -0,0 +1,883 @@
+//! TrueType and OpenType font parsing.
+
+use std::collections::HashMap;
+use std::fmt;
+use std::path::Path;
+use std::sync::Arc;
+
+use super::layout::{Kerning, Ligatures, ShapedGlyph, Shaping};
+use super::FontMetrics;
+use crate::error::FontError;
+
+/// The glyph outline format of an sfnt font.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum OutlineFormat {
+    /// Quadratic TrueType outlines stored in the `glyf` table.
+    TrueType,
+    /// PostScript outlines stored in the `CFF ` table.
+    Cff,
+}
+
+/// A table directory entry.
+#[derive(Debug, Clone, Copy)]
+pub(crate) struct TableRecord {
+    pub tag: [u8; 4],
+    pub offset: usize,
+    pub length: usize,
+}
+
+/// A parsed TrueType or OpenType font that can be embedded in a PDF.
+///
+/// Cloning is cheap: clones share the same parsed font data.
+#[derive(Clone)]
+pub struct TrueTypeFont {
+    inner: Arc<FontData>,
+}
+
+struct FontData {
+    data: Vec<u8>,
+    tables: Vec<TableRecord>,
+    postscript_name: String,
+    outline_format: OutlineFormat,
+    units_per_em: u16,
+    bbox: [i16; 4],
+    ascender: i16,
+    descender: i16,
+    line_gap: i16,
+    cap_height: i16,
+    underline: (i16, i16),
+    strikeout: (i16, i16),
+    avg_width: u16,
+    italic_angle: f64,
+    is_fixed_pitch: bool,
+    is_bold: bool,
+    is_italic: bool,
+    num_glyphs: u16,
+    advance_widths: Vec<u16>,
+    advance_heights: Vec<u16>,
+    cmap: HashMap<char, u16>,
+    glyph_offsets: Vec<usize>,
+    kerning: Kerning,
+    ligatures: Ligatures,
+}
+
+impl TrueTypeFont {
+    /// Parses a TrueType (`.ttf`) or OpenType (`.otf`) font.
+    ///
+    /// For font collections (`.ttc`), the first font is used.
+    pub fn from_bytes(data: impl Into<Vec<u8>>) -> Result<Self, FontError> {
+        let data = data.into();
+        let inner = FontData::parse(data)?;
+        Ok(Self {
+            inner: Arc::new(inner),
+        })
+    }
+
+    /// Loads and parses a font file.
+    pub fn from_file(path: impl AsRef<Path>) -> Result<Self, FontError> {
+        let data = std::fs::read(path).map_err(|e| FontError::LoadFailed(e.to_string()))?;
+        Self::from_bytes(data)
+    }
+
+    /// Returns the PostScript name of the font.
+    pub fn postscript_name(&self) -> &str {
+        &self.inner.postscript_name
+    }
+
+    /// Returns the outline format of the font.
+    pub fn outline_format(&self) -> OutlineFormat {
+        self.inner.outline_format
+    }
+
+    /// Returns the number of font design units per em.
+    pub fn units_per_em(&self) -> u16 {
+        self.inner.units_per_em
+    }
+
+    /// Returns the number of glyphs in the font.
+    pub fn num_glyphs(&self) -> u16 {
+        self.inner.num_glyphs
+    }
+
+    /// Returns the glyph ID for a character, if the font maps it.
+    pub fn glyph_id(&self, c: char) -> Option<u16> {
+        self.inner.cmap.get(&c).copied().filter(|&gid| gid != 0)
+    }
+
+    /// Returns whether the font has a glyph for a character.
+    pub fn has_glyph(&self, c: char) -> bool {
+        self.glyph_id(c).is_some()
+    }
+
+    /// Returns the advance width of a glyph in font design units.
+    pub fn advance_width(&self, gid: u16) -> u16 {
+        let widths = &self.inner.advance_widths;
+        widths
+            .get(gid as usize)
+            .or_else(|| widths.last())
+            .copied()
+            .unwrap_or(0)
+    }
+
+    /// Returns the vertical advance of a glyph in font design units, from the
+    /// `vmtx` table, or `None` if the font has no vertical metrics.
+    pub fn advance_height(&self, gid: u16) -> Option<u16> {
+        let heights = &self.inner.advance_heights;
+        heights.get(gid as usize).or_else(|| heights.last()).copied()
+    }
+
+    /// Encodes text as big-endian glyph IDs for use with an Identity-H encoding.
+    ///
+    /// Characters the font does not map are encoded as glyph 0 (`.notdef`).
+    pub fn encode(&self, text: &str) -> Vec<u8> {
+        let mut bytes = Vec::with_capacity(text.len() * 2);
+        for c in text.chars() {
+            let gid = self.glyph_id(c).unwrap_or(0);
+            bytes.extend_from_slice(&gid.to_be_bytes());
+        }
+        bytes
+    }
+
+    /// Returns the width of text in points at the given font size.
+    pub fn text_width(&self, text: &str, font_size: f64) -> f64 {
+        let units: u32 = text
+            .chars()
+            .map(|c| self.advance_width(self.glyph_id(c).unwrap_or(0)) as u32)
+            .sum();
+        units as f64 * font_size / self.inner.units_per_em as f64
+    }
+
+    /// Returns the kerning between glyph `left` and the glyph `right` after
+    /// it, in font design units; negative values move them closer together.
+    pub fn kerning(&self, left: u16, right: u16) -> i16 {
+        self.inner.kerning.get(left, right)
+    }
+
+    /// Maps text to glyphs like [`TrueTypeFont::encode`], substituting the
+    /// font's standard ligatures and adding its kerning as `shaping` asks.
+    pub fn shape(&self, text: &str, shaping: Shaping) -> Vec<ShapedGlyph> {
+        let gids: Vec<u16> = text.chars().map(|c| self.glyph_id(c).unwrap_or(0)).collect();
+        let mut glyphs: Vec<ShapedGlyph> = Vec::with_capacity(gids.len());
+        let mut i = 0;
+        while i < gids.len() {
+            let ligature = if shaping.ligatures { self.inner.ligatures.find(&gids[i..]) } else { None };
+            let (gid, len) = ligature.unwrap_or((gids[i], 1));
+            glyphs.push(ShapedGlyph { gid, kerning: 0 });
+            i += len;
+        }
+        if shaping.kerning {
+            for j in 1..glyphs.len() {
+                glyphs[j - 1].kerning = self.kerning(glyphs[j - 1].gid, glyphs[j].gid);
+            }
+        }
+        glyphs
+    }
+
+    /// Returns the width in points of shaped glyphs at the given font size.
+    pub fn shaped_width(&self, glyphs: &[ShapedGlyph], font_size: f64) -> f64 {
+        let units: i64 = glyphs
+            .iter()
+            .map(|g| self.advance_width(g.gid) as i64 + g.kerning as i64)
+            .sum();
+        units as f64 * font_size / self.inner.units_per_em as f64
+    }
+
+    /// Returns font metrics for layout.
+    pub fn metrics(&self) -> FontMetrics {
+        let f = &self.inner;
+        FontMetrics {
+            units_per_em: f.units_per_em,
+            ascender: f.ascender,
+            descender: f.descender,
+            line_gap: f.line_gap,
+            cap_height: f.cap_height,
+            underline_position: f.underline.0,
+            underline_thickness: f.underline.1,
+            strikeout_position: f.strikeout.0,
+            strikeout_thickness: f.strikeout.1,
+            avg_width: f.avg_width,
+        }
+    }
+
+    /// Returns whether two handles refer to the same parsed font.
+    pub fn ptr_eq(&self, other: &TrueTypeFont) -> bool {
+        Arc::ptr_eq(&self.inner, &other.inner)
+    }
+
+    /// Returns the original font file.
+    pub(crate) fn data(&self) -> &[u8] {
+        &self.inner.data
+    }
+
+    /// Returns the raw bytes of a table, if present.
+    pub(crate) fn table(&self, tag: &[u8; 4]) -> Option<&[u8]> {
+        self.inner
+            .tables
+            .iter()
+            .find(|t| &t.tag == tag)
+            .map(|t| &self.inner.data[t.offset..t.offset + t.length])
+    }
+
+    /// Returns the outline data of a glyph from the `glyf` table.
+    pub(crate) fn glyph_data(&self, gid: u16) -> &[u8] {
+        let offsets = &self.inner.glyph_offsets;
+        let glyf = match self.table(b"glyf") {
+            Some(glyf) => glyf,
+            None => return &[],
+        };
+        let (start, end) = match (offsets.get(gid as usize), offsets.get(gid as usize + 1)) {
+            (Some(&start), Some(&end)) if start <= end && end <= glyf.len() => (start, end),
+            _ => return &[],
+        };
+        &glyf[start..end]
+    }
+
+    /// Returns the font bounding box in design units (xMin, yMin, xMax, yMax).
+    pub(crate) fn bbox(&self) -> [i16; 4] {
+        self.inner.bbox
+    }
+
+    /// Returns the capital letter height in design units.
+    pub(crate) fn cap_height(&self) -> i16 {
+        self.inner.cap_height
+    }
+
+    /// Returns the italic angle in degrees.
+    pub(crate) fn italic_angle(&self) -> f64 {
+        self.inner.italic_angle
+    }
+
+    /// Returns whether all glyphs have the same advance width.
+    pub(crate) fn is_fixed_pitch(&self) -> bool {
+        self.inner.is_fixed_pitch
+    }
+
+    /// Returns whether the font is bold.
+    pub(crate) fn is_bold(&self) -> bool {
+        self.inner.is_bold
+    }
+
+    /// Returns whether the font is italic.
+    pub(crate) fn is_italic(&self) -> bool {
+        self.inner.is_italic
+    }
+
+    /// Returns the character-to-glyph mapping.
+    pub(crate) fn char_map(&self) -> &HashMap<char, u16> {
+        &self.inner.cmap
+    }
+
+    /// Returns the standard ligatures with the glyphs each replaces.
+    pub(crate) fn ligatures(&self) -> impl Iterator<Item = (u16, Vec<u16>)> + '_ {
+        self.inner.ligatures.iter()
+    }
+}
+
+impl fmt::Debug for TrueTypeFont {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("TrueTypeFont")
+            .field("postscript_name", &self.inner.postscript_name)
+            .field("outline_format", &self.inner.outline_format)
+            .field("num_glyphs", &self.inner.num_glyphs)
+            .finish()
+    }
+}
+
+impl FontData {
+    fn parse(data: Vec<u8>) -> Result<Self, FontError> {
+        let r = Reader(&data);
+
+        let mut base = 0;
+        if r.tag(0)? == *b"ttcf" {
+            base = r.u32(12)? as usize;
+        }
+
+        let outline_format = match &r.tag(base)? {
+            [0x00, 0x01, 0x00, 0x00] | b"true" => OutlineFormat::TrueType,
+            b"OTTO" => OutlineFormat::Cff,
+            _ => return Err(FontError::InvalidFont("unrecognized font signature".into())),
+        };
+
+        let num_tables = r.u16(base + 4)? as usize;
+        let mut tables = Vec::with_capacity(num_tables);
+        for i in 0..num_tables {
+            let rec = base + 12 + i * 16;
+            let record = TableRecord {
+                tag: r.tag(rec)?,
+                offset: r.u32(rec + 8)? as usize,
+                length: r.u32(rec + 12)? as usize,
+            };
+            if record.offset.checked_add(record.length).map_or(true, |end| end > data.len()) {
+                return Err(FontError::InvalidFont(format!(
+                    "table '{}' out of bounds",
+                    String::from_utf8_lossy(&record.tag)
+                )));
+            }
+            tables.push(record);
+        }
+
+        let find = |tag: &[u8; 4]| tables.iter().find(|t| &t.tag == tag).copied();
+        let require = |tag: &[u8; 4]| {
+            find(tag).ok_or_else(|| {
+                FontError::InvalidFont(format!("missing '{}' table", String::from_utf8_lossy(tag)))
+            })
+        };
+
+        let head = require(b"head")?;
+        let units_per_em = r.u16(head.offset + 18)?;
+        if units_per_em == 0 {
+            return Err(FontError::InvalidFont("unitsPerEm is zero".into()));
+        }
+        let bbox = [
+            r.i16(head.offset + 36)?,
+            r.i16(head.offset + 38)?,
+            r.i16(head.offset + 40)?,
+            r.i16(head.offset + 42)?,
+        ];
+        let mac_style = r.u16(head.offset + 44)?;
+        let long_loca = r.i16(head.offset + 50)? != 0;
+
+        let hhea = require(b"hhea")?;
+        let mut ascender = r.i16(hhea.offset + 4)?;
+        let mut descender = r.i16(hhea.offset + 6)?;
+        let line_gap = r.i16(hhea.offset + 8)?;
+        let num_h_metrics = r.u16(hhea.offset + 34)? as usize;
+
+        let maxp = require(b"maxp")?;
+        let num_glyphs = r.u16(maxp.offset + 4)?;
+
+        let hmtx = require(b"hmtx")?;
+        let mut advance_widths = Vec::with_capacity(num_h_metrics);
+        for i in 0..num_h_metrics.min(num_glyphs.max(1) as usize) {
+            advance_widths.push(r.u16(hmtx.offset + i * 4)?);
+        }
+
+        // Vertical metrics are optional, so a damaged table is ignored
+        let mut advance_heights = Vec::new();
+        if let (Some(vhea), Some(vmtx)) = (find(b"vhea"), find(b"vmtx")) {
+            let num_v_metrics = r.u16(vhea.offset + 34).unwrap_or(0) as usize;
+            for i in 0..num_v_metrics.min(num_glyphs.max(1) as usize) {
+                match r.u16(vmtx.offset + i * 4) {
+                    Ok(height) if vmtx.length >= i * 4 + 2 => advance_heights.push(height),
+                    _ => {
+                        advance_heights.clear();
+                        break;
+                    }
+                }
+            }
+        }
+
+        let mut avg_width = 0;
+        let mut cap_height = 0;
+        let mut weight_class = 400;
+        let mut fs_selection = 0;
+        let mut strikeout = (0, 0);
+        if let Some(os2) = find(b"OS/2") {
+            let version = r.u16(os2.offset)?;
+            strikeout = (r.i16(os2.offset + 28)?, r.i16(os2.offset + 26)?);
+            avg_width = r.i16(os2.offset + 2)?.max(0) as u16;
+            weight_class = r.u16(os2.offset + 4)?;
+            fs_selection = r.u16(os2.offset + 62)?;
+            if ascender == 0 && descender == 0 {
+                ascender = r.i16(os2.offset + 68)?;
+                descender = r.i16(os2.offset + 70)?;
+            }
+            if version >= 2 {
+                cap_height = r.i16(os2.offset + 88).unwrap_or(0);
+            }
+        }
+        if avg_width == 0 && !advance_widths.is_empty() {
+            let total: u32 = advance_widths.iter().map(|&w| w as u32).sum();
+            avg_width = (total / advance_widths.len() as u32) as u16;
+        }
+        if cap_height == 0 {
+            cap_height = (ascender as f64 * 0.7) as i16;
+        }
+
+        let (italic_angle, underline, is_fixed_pitch) = match find(b"post") {
+            Some(post) => (
+                r.u32(post.offset + 4)? as i32 as f64 / 65536.0,
+                (r.i16(post.offset + 8)?, r.i16(post.offset + 10)?),
+                r.u32(post.offset + 12)? != 0,
+            ),
+            None => (0.0, (0, 0), false),
+        };
+        // Lines a font gives no thickness for are placed by fractions of the em
+        let em = units_per_em as i16;
+        let underline = if underline.1 > 0 { underline } else { (-em / 10, em / 20) };
+        let strikeout = if strikeout.1 > 0 { strikeout } else { (em / 4, underline.1) };
+
+        let cmap = parse_cmap(&r, require(b"cmap")?)?;
+
+        let glyph_offsets = match outline_format {
+            OutlineFormat::TrueType => {
+                let loca = require(b"loca")?;
+                require(b"glyf")?;
+                let mut offsets = Vec::with_capacity(num_glyphs as usize + 1);
+                for i in 0..=num_glyphs as usize {
+                    let offset = if long_loca {
+                        r.u32(loca.offset + i * 4)? as usize
+                    } else {
+                        r.u16(loca.offset + i * 2)? as usize * 2
+                    };
+                    offsets.push(offset);
+                }
+                offsets
+            }
+            OutlineFormat::Cff => {
+                require(b"CFF ")?;
+                Vec::new()
+            }
+        };
+
+        let postscript_name = find(b"name")
+            .and_then(|name| parse_postscript_name(&r, name))
+            .unwrap_or_else(|| "EmbeddedFont".to_string());
+
+        let bytes = |tag: &[u8; 4]| find(tag).map(|t| &data[t.offset..t.offset + t.length]);
+        let kerning = Kerning::parse(bytes(b"GPOS"), bytes(b"kern"));
+        let ligatures = Ligatures::parse(bytes(b"GSUB"));
+
+        Ok(Self {
+            tables,
+            postscript_name,
+            outline_format,
+            units_per_em,
+            bbox,
+            ascender,
+            descender,
+            line_gap,
+            cap_height,
+            underline,
+            strikeout,
+            avg_width,
+            italic_angle,
+            is_fixed_pitch,
+            is_bold: weight_class >= 600 || mac_style & 1 != 0,
+            is_italic: fs_selection & 1 != 0 || mac_style & 2 != 0,
+            num_glyphs,
+            advance_widths,
+            advance_heights,
+            cmap,
+            glyph_offsets,
+            kerning,
+            ligatures,
+            data,
+        })
+    }
+}
+
+/// Parses the best available Unicode subtable of the `cmap` table.
+fn parse_cmap(r: &Reader<'_>, cmap: TableRecord) -> Result<HashMap<char, u16>, FontError> {
+    let count = r.u16(cmap.offset + 2)? as usize;
+    let mut best: Option<(u8, usize)> = None;
+    for i in 0..count {
+        let rec = cmap.offset + 4 + i * 8;
+        let platform = r.u16(rec)?;
+        let encoding = r.u16(rec + 2)?;
+        let offset = cmap.offset + r.u32(rec + 4)? as usize;
+        let rank = match (platform, encoding) {
+            (3, 10) => 4,
+            (0, 4) | (0, 6) => 3,
+            (3, 1) => 2,
+            (0, _) => 1,
+            _ => continue,
+        };
+        if best.map_or(true, |(best_rank, _)| rank > best_rank) {
+            best = Some((rank, offset));
+        }
+    }
+
+    let offset = match best {
+        Some((_, offset)) => offset,
+        None => return Err(FontError::InvalidFont("no Unicode cmap subtable".into())),
+    };
+
+    let mut map = HashMap::new();
+    match r.u16(offset)? {
+        4 => {
+            let seg_count = r.u16(offset + 6)? as usize / 2;
+            let ends = offset + 14;
+            let starts = ends + seg_count * 2 + 2;
+            let deltas = starts + seg_count * 2;
+            let range_offsets = deltas + seg_count * 2;
+            for seg in 0..seg_count {
+                let end = r.u16(ends + seg * 2)?;
+                let start = r.u16(starts + seg * 2)?;
+                let delta = r.u16(deltas + seg * 2)?;
+                let range_offset = r.u16(range_offsets + seg * 2)? as usize;
+                if start > end {
+                    continue;
+                }
+                for code in start..=end {
+                    if code == 0xFFFF {
+                        break;
+                    }
+                    let gid = if range_offset == 0 {
+                        code.wrapping_add(delta)
+                    } else {
+                        let addr = range_offsets
+                            + seg * 2
+                            + range_offset
+                            + (code - start) as usize * 2;
+                        match r.u16(addr)? {
+                            0 => 0,
+                            g => g.wrapping_add(delta),
+                        }
+                    };
+                    if let Some(c) = char::from_u32(code as u32) {
+                        if gid != 0 {
+                            map.insert(c, gid);
+                        }
+                    }
+                }
+            }
+        }
+        12 => {
+            let groups = r.u32(offset + 12)? as usize;
+            for i in 0..groups {
+                let group = offset + 16 + i * 12;
+                let start = r.u32(group)?;
+                let end = r.u32(group + 4)?.min(0x10FFFF);
+                let start_gid = r.u32(group + 8)?;
+                if start > end {
+                    continue;
+                }
+                for code in start..=end {
+                    let gid = start_gid + (code - start);
+                    if let (Some(c), Ok(gid)) = (char::from_u32(code), u16::try_from(gid)) {
+                        if gid != 0 {
+                            map.insert(c, gid);
+                        }
+                    }
+                }
+            }
+        }
+        format => {
+            return Err(FontError::UnsupportedFont(format!(
+                "cmap subtable format {}",
+                format
+            )))
+        }
+    }
+    Ok(map)
+}
+
+/// Reads the PostScript name (name ID 6), keeping only characters valid in a PDF name.
+fn parse_postscript_name(r: &Reader<'_>, name: TableRecord) -> Option<String> {
+    let count = r.u16(name.offset + 2).ok()? as usize;
+    let strings = name.offset + r.u16(name.offset + 4).ok()? as usize;
+    for i in 0..count {
+        let rec = name.offset + 6 + i * 12;
+        let platform = r.u16(rec).ok()?;
+        let name_id = r.u16(rec + 6).ok()?;
+        if name_id != 6 {
+            continue;
+        }
+        let length = r.u16(rec + 8).ok()? as usize;
+        let start = strings + r.u16(rec + 10).ok()? as usize;
+        let bytes = r.0.get(start..start + length)?;
+        let raw = match platform {
+            0 | 3 => {
+                let units: Vec<u16> = bytes
+                    .chunks_exact(2)
+                    .map(|pair| u16::from_be_bytes([pair[0], pair[1]]))
+                    .collect();
+                String::from_utf16_lossy(&units)
+            }
+            _ => bytes.iter().map(|&b| b as char).collect(),
+        };
+        let cleaned: String = raw
+            .chars()
+            .filter(|c| c.is_ascii_graphic() && !"[](){}<>/%#".contains(*c))
+            .collect();
+        if !cleaned.is_empty() {
+            return Some(cleaned);
+        }
+    }
+    None
+}
+
+/// Bounds-checked big-endian reader.
+struct Reader<'a>(&'a [u8]);
+
+impl Reader<'_> {
+    fn bytes<const N: usize>(&self, offset: usize) -> Result<[u8; N], FontError> {
+        self.0
+            .get(offset..offset + N)
+            .and_then(|b| b.try_into().ok())
+            .ok_or_else(|| FontError::InvalidFont(format!("unexpected end of data at {}", offset)))
+    }
+
+    fn u16(&self, offset: usize) -> Result<u16, FontError> {
+        self.bytes::<2>(offset).map(u16::from_be_bytes)
+    }
+
+    fn i16(&self, offset: usize) -> Result<i16, FontError> {
+        self.bytes::<2>(offset).map(i16::from_be_bytes)
+    }
+
+    fn u32(&self, offset: usize) -> Result<u32, FontError> {
+        self.bytes::<4>(offset).map(u32::from_be_bytes)
+    }
+
+    fn tag(&self, offset: usize) -> Result<[u8; 4], FontError> {
+        self.bytes::<4>(offset)
+    }
+}
+
+/// Builds a minimal TrueType font with glyphs for "A", "B" and "\u{416}" (Cyrillic Zhe).
+///
+/// Glyph 3 is a composite that references glyph 1.
+#[cfg(test)]
+pub(crate) fn test_font_bytes() -> Vec<u8> {
+    test_font_with_tables(Vec::new())
+}
+
+/// Builds the font of [`test_font_bytes`] with vertical metrics, advancing
+/// glyphs 0 to 2 by 1000, 900 and 800 units, and glyph 3 like the last.
+#[cfg(test)]
+pub(crate) fn vertical_test_font_bytes() -> Vec<u8> {
+    let mut vhea = vec![0u8; 36];
+    vhea[0..4].copy_from_slice(&0x0001_1000u32.to_be_bytes());
+    vhea[34..36].copy_from_slice(&3u16.to_be_bytes());
+    let mut vmtx = Vec::new();
+    for height in [1000u16, 900, 800] {
+        vmtx.extend_from_slice(&height.to_be_bytes());
+        vmtx.extend_from_slice(&0i16.to_be_bytes());
+    }
+    test_font_with_tables(vec![(b"vhea", vhea), (b"vmtx", vmtx)])
+}
+
+/// Builds the font of [`test_font_bytes`] with a ligature of "AB" drawn as
+/// glyph 3, and B after A kerned by -80 units.
+#[cfg(test)]
+pub(crate) fn shaping_test_font_bytes() -> Vec<u8> {
+    let be = |values: &[u16]| values.iter().flat_map(|v| v.to_be_bytes()).collect::<Vec<u8>>();
+    let gsub = super::layout::layout_table(b"liga", 4, &[be(&[1, 8, 1, 14, 1, 1, 1, 1, 4, 3, 2, 2])]);
+    let kern = be(&[0, 1, 0, 20, 1, 1, 6, 0, 0, 1, 2, (-80i16) as u16]);
+    test_font_with_tables(vec![(b"GSUB", gsub), (b"kern", kern)])
+}
+
+#[cfg(test)]
+fn test_font_with_tables(extra: Vec<(&'static [u8; 4], Vec<u8>)>) -> Vec<u8> {
+    fn simple_glyph(x_max: i16) -> Vec<u8> {
+        let mut g = Vec::new();
+        for v in [1i16, 0, 0, x_max, 700] {
+            g.extend_from_slice(&v.to_be_bytes());
+        }
+        g.extend_from_slice(&2u16.to_be_bytes()); // endPtsOfContours
+        g.extend_from_slice(&0u16.to_be_bytes()); // instructionLength
+        g.extend_from_slice(&[0x01, 0x01, 0x01]); // on-curve flags
+        for v in [0i16, x_max / 2, x_max / 2] {
+            g.extend_from_slice(&v.to_be_bytes());
+        }
+        for v in [0i16, 700, -700] {
+            g.extend_from_slice(&v.to_be_bytes());
+        }
+        g
+    }
+
+    let mut composite = Vec::new();
+    for v in [-1i16, 0, 0, 600, 700] {
+        composite.extend_from_slice(&v.to_be_bytes());
+    }
+    composite.extend_from_slice(&0x0002u16.to_be_bytes()); // ARGS_ARE_XY_VALUES
+    composite.extend_from_slice(&1u16.to_be_bytes());
+    composite.extend_from_slice(&[0, 0]);
+
+    let glyphs = vec![Vec::new(), simple_glyph(600), simple_glyph(500), composite];
+    let mut glyf = Vec::new();
+    let mut loca = Vec::new();
+    for g in &glyphs {
+        loca.extend_from_slice(&(glyf.len() as u32).to_be_bytes());
+        glyf.extend_from_slice(g);
+        while glyf.len() % 4 != 0 {
+            glyf.push(0);
+        }
+    }
+    loca.extend_from_slice(&(glyf.len() as u32).to_be_bytes());
+
+    let mut head = vec![0u8; 54];
+    head[0..4].copy_from_slice(&0x0001_0000u32.to_be_bytes());
+    head[12..16].copy_from_slice(&0x5F0F_3CF5u32.to_be_bytes());
+    head[18..20].copy_from_slice(&1000u16.to_be_bytes());
+    head[40..42].copy_from_slice(&600i16.to_be_bytes());
+    head[42..44].copy_from_slice(&700i16.to_be_bytes());
+    head[50..52].copy_from_slice(&1i16.to_be_bytes());
+
+    let mut hhea = vec![0u8; 36];
+    hhea[0..4].copy_from_slice(&0x0001_0000u32.to_be_bytes());
+    hhea[4..6].copy_from_slice(&800i16.to_be_bytes());
+    hhea[6..8].copy_from_slice(&(-200i16).to_be_bytes());
+    hhea[34..36].copy_from_slice(&4u16.to_be_bytes());
+
+    let mut maxp = vec![0u8; 6];
+    maxp[0..4].copy_from_slice(&0x0000_5000u32.to_be_bytes());
+    maxp[4..6].copy_from_slice(&4u16.to_be_bytes());
+
+    let mut hmtx = Vec::new();
+    for adv in [500u16, 600, 550, 700] {
+        hmtx.extend_from_slice(&adv.to_be_bytes());
+        hmtx.extend_from_slice(&0i16.to_be_bytes());
+    }
+
+    // cmap format 4: 'A'..'B' -> 1..2, U+0416 -> 3, terminator segment.
+    let segments: [(u16, u16, u16); 3] = [(0x41, 0x42, 1u16.wrapping_sub(0x41)), (0x416, 0x416, 3u16.wrapping_sub(0x416)), (0xFFFF, 0xFFFF, 1)];
+    let mut sub = Vec::new();
+    let seg_count = segments.len() as u16;
+    for v in [4u16, 0, 0, seg_count * 2, 0, 0, 0] {
+        sub.extend_from_slice(&v.to_be_bytes());
+    }
+    for s in &segments {
+        sub.extend_from_slice(&s.1.to_be_bytes());
+    }
+    sub.extend_from_slice(&0u16.to_be_bytes());
+    for s in &segments {
+        sub.extend_from_slice(&s.0.to_be_bytes());
+    }
+    for s in &segments {
+        sub.extend_from_slice(&s.2.to_be_bytes());
+    }
+    for _ in &segments {
+        sub.extend_from_slice(&0u16.to_be_bytes());
+    }
+    let sub_len = sub.len() as u16;
+    sub[2..4].copy_from_slice(&sub_len.to_be_bytes());
+    let mut cmap = Vec::new();
+    for v in [0u16, 1, 3, 1] {
+        cmap.extend_from_slice(&v.to_be_bytes());
+    }
+    cmap.extend_from_slice(&12u32.to_be_bytes());
+    cmap.extend_from_slice(&sub);
+
+    let name_str: Vec<u8> = "TestSans".encode_utf16().flat_map(|u| u.to_be_bytes()).collect();
+    let mut name = Vec::new();
+    for v in [0u16, 1, 18, 3, 1, 0x409, 6, name_str.len() as u16, 0] {
+        name.extend_from_slice(&v.to_be_bytes());
+    }
+    name.extend_from_slice(&name_str);
+
+    let tables: Vec<(&[u8; 4], Vec<u8>)> = vec![
+        (b"cmap", cmap),
+        (b"glyf", glyf),
+        (b"head", head),
+        (b"hhea", hhea),
+        (b"hmtx", hmtx),
+        (b"loca", loca),
+        (b"maxp", maxp),
+        (b"name", name),
+    ];
+    let tables: Vec<(&[u8; 4], Vec<u8>)> = tables.into_iter().chain(extra).collect();
+
+    let mut out = Vec::new();
+    out.extend_from_slice(&0x0001_0000u32.to_be_bytes());
+    out.extend_from_slice(&(tables.len() as u16).to_be_bytes());
+    out.extend_from_slice(&[0; 6]);
+    let mut offset = 12 + tables.len() * 16;
+    let mut body = Vec::new();
+    for (tag, data) in &tables {
+        out.extend_from_slice(*tag);
+        out.extend_from_slice(&0u32.to_be_bytes());
+        out.extend_from_slice(&(offset as u32).to_be_bytes());
+        out.extend_from_slice(&(data.len() as u32).to_be_bytes());
+        body.extend_from_slice(data);
+        while body.len() % 4 != 0 {
+            body.push(0);
+        }
+        offset = 12 + tables.len() * 16 + body.len();
+    }
+    out.extend_from_slice(&body);
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_font() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        assert_eq!(font.postscript_name(), "TestSans");
+        assert_eq!(font.outline_format(), OutlineFormat::TrueType);
+        assert_eq!(font.units_per_em(), 1000);
+        assert_eq!(font.num_glyphs(), 4);
+        assert_eq!(font.metrics().ascender, 800);
+    }
+
+    #[test]
+    fn test_cmap_lookup() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        assert_eq!(font.glyph_id('A'), Some(1));
+        assert_eq!(font.glyph_id('B'), Some(2));
+        assert_eq!(font.glyph_id('\u{416}'), Some(3));
+        assert_eq!(font.glyph_id('Z'), None);
+        assert!(!font.has_glyph('Z'));
+    }
+
+    #[test]
+    fn test_encode_and_width() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        assert_eq!(font.encode("AZ\u{416}"), vec![0, 1, 0, 0, 0, 3]);
+        assert_eq!(font.text_width("AB", 10.0), 11.5);
+    }
+
+    #[test]
+    fn test_advance_height() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        assert_eq!(font.advance_height(1), None);
+        let font = TrueTypeFont::from_bytes(vertical_test_font_bytes()).unwrap();
+        assert_eq!(font.advance_height(1), Some(900));
+        // Glyphs past the last metric share its advance
+        assert_eq!(font.advance_height(3), Some(800));
+    }
+
+    #[test]
+    fn test_line_metrics() {
+        // Without post and OS/2 tables the lines are placed by fractions of the em
+        let metrics = TrueTypeFont::from_bytes(test_font_bytes()).unwrap().metrics();
+        assert_eq!((metrics.underline_position, metrics.underline_thickness), (-100, 50));
+        assert_eq!((metrics.strikeout_position, metrics.strikeout_thickness), (250, 50));
+
+        let mut post = vec![0u8; 32];
+        post[0..4].copy_from_slice(&0x0003_0000u32.to_be_bytes());
+        post[8..10].copy_from_slice(&(-150i16).to_be_bytes());
+        post[10..12].copy_from_slice(&60i16.to_be_bytes());
+        let font = TrueTypeFont::from_bytes(test_font_with_tables(vec![(b"post", post)])).unwrap();
+        let metrics = font.metrics();
+        assert_eq!((metrics.underline_position, metrics.underline_thickness), (-150, 60));
+        assert_eq!((metrics.strikeout_position, metrics.strikeout_thickness), (250, 60));
+    }
+
+    #[test]
+    fn test_shape() {
+        let font = TrueTypeFont::from_bytes(shaping_test_font_bytes()).unwrap();
+        assert_eq!(font.kerning(1, 2), -80);
+
+        let gids = |glyphs: Vec<ShapedGlyph>| glyphs.iter().map(|g| (g.gid, g.kerning)).collect::<Vec<_>>();
+        assert_eq!(gids(font.shape("ABA", Shaping::default())), vec![(1, 0), (2, 0), (1, 0)]);
+        let kerned = font.shape("ABA", Shaping { kerning: true, ligatures: false });
+        assert_eq!(font.shaped_width(&kerned, 10.0), 16.7);
+        assert_eq!(gids(kerned), vec![(1, -80), (2, 0), (1, 0)]);
+        let both = Shaping { kerning: true, ligatures: true };
+        assert_eq!(gids(font.shape("ABA", both)), vec![(3, 0), (1, 0)]);
+        assert_eq!(gids(font.shape("AAB", both)), vec![(1, 0), (3, 0)]);
+        let ligatures: Vec<(u16, Vec<u16>)> = font.ligatures().collect();
+        assert_eq!(ligatures, vec![(3, vec![1, 2])]);
+    }
+
+    #[test]
+    fn test_invalid_font() {
+        assert!(TrueTypeFont::from_bytes(vec![0u8; 16]).is_err());
+        assert!(TrueTypeFont::from_bytes(b"OTTO".to_vec()).is_err());
+    }
+
+    #[test]
+    fn test_ptr_eq() {
+        let font = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        let clone = font.clone();
+        let other = TrueTypeFont::from_bytes(test_font_bytes()).unwrap();
+        assert!(font.ptr_eq(&clone));
+        assert!(!font.ptr_eq(&other));
+    }
+}
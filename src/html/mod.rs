@@ -0,0 +1,713 @@
+//! Layout of a small subset of HTML.
+//!
+//! [`HtmlRenderer`] flows paragraphs, headings (`h1`–`h3`), line breaks,
+//! bold and italic runs, colored `span`s and bulleted `ul`/`li` lists into a
+//! column, wrapping words at its width. Styling comes only from inline
+//! `style` attributes (`color`, `font-weight` and `font-style`) and the
+//! `color` attribute; there is no stylesheet support. The markup is parsed
+//! leniently: unknown tags are skipped with their text kept, unclosed tags
+//! end with their parent, `ol` lists get bullets like `ul` and `script` and
+//! `style` contents are dropped.
+
+use crate::color::{Color, RgbColor};
+use crate::content::{ContentBuilder, GraphicsBuilder};
+use crate::font::{calculate_helvetica_bold_width, calculate_helvetica_width, FontMetrics, Standard14Font};
+
+/// Font resource names used by rendered content, by (bold, italic) style.
+/// Every page the content is drawn on needs all four in its resources.
+pub const HTML_FONTS: [(&str, Standard14Font); 4] = [
+    ("Helv", Standard14Font::Helvetica),
+    ("HelvB", Standard14Font::HelveticaBold),
+    ("HelvI", Standard14Font::HelveticaOblique),
+    ("HelvBI", Standard14Font::HelveticaBoldOblique),
+];
+
+/// Lays out HTML fragments as page content.
+#[derive(Debug, Clone, PartialEq)]
+pub struct HtmlRenderer {
+    font_size: f64,
+    line_height: f64,
+    color: Color,
+}
+
+impl Default for HtmlRenderer {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl HtmlRenderer {
+    /// Creates a renderer for 12 pt black text with lines 1.2 times the font size apart.
+    pub fn new() -> Self {
+        Self {
+            font_size: 12.0,
+            line_height: 1.2,
+            color: Color::BLACK,
+        }
+    }
+
+    /// Sets the size of body text. Headings scale from it.
+    pub fn font_size(mut self, size: f64) -> Self {
+        self.font_size = size;
+        self
+    }
+
+    /// Sets the distance between baselines as a multiple of the largest font
+    /// size on each line.
+    pub fn line_height(mut self, factor: f64) -> Self {
+        self.line_height = factor;
+        self
+    }
+
+    /// Sets the color of text without a `color` style.
+    pub fn color(mut self, color: Color) -> Self {
+        self.color = color;
+        self
+    }
+
+    /// Lays out `html` in a column `width` wide whose top-left corner is
+    /// (`x`, `y`), returning the content and the y-coordinate below the
+    /// last line.
+    pub fn render(&self, html: &str, x: f64, y: f64, width: f64) -> (ContentBuilder, f64) {
+        let base = TextStyle {
+            bold: false,
+            italic: false,
+            size: self.font_size,
+            color: self.color,
+        };
+        let mut layout = Layout {
+            x,
+            width,
+            top: y,
+            line_height: self.line_height,
+            indent: 0.0,
+            line: Vec::new(),
+            line_width: 0.0,
+            space: false,
+            bullet: None,
+            gap: 0.0,
+            started: false,
+            content: ContentBuilder::new(),
+            color: None,
+        };
+        let mut open: Vec<OpenElement> = Vec::new();
+
+        for token in tokenize(html) {
+            let style = open.last().map_or(base, |element| element.style);
+            match token {
+                Token::Text(text) => layout.text(&text, style),
+                Token::Start { name, attributes } => {
+                    let margin = BLOCK_MARGIN * self.font_size;
+                    let mut inner = style;
+                    match name.as_str() {
+                        "br" => {
+                            layout.line_break(style);
+                            continue;
+                        }
+                        "p" => layout.block(margin),
+                        "h1" | "h2" | "h3" => {
+                            let scale = match name.as_str() {
+                                "h1" => 2.0,
+                                "h2" => 1.5,
+                                _ => 1.17,
+                            };
+                            inner.size = self.font_size * scale;
+                            inner.bold = true;
+                            layout.block(margin * scale);
+                        }
+                        "ul" | "ol" => layout.block(if open.iter().any(|e| e.is_list()) { 0.0 } else { margin }),
+                        "li" => {
+                            layout.block(0.0);
+                            layout.bullet = Some(style);
+                        }
+                        "b" | "strong" => inner.bold = true,
+                        "i" | "em" => inner.italic = true,
+                        "span" => {}
+                        _ => continue,
+                    }
+                    apply_attributes(&mut inner, &attributes);
+                    let indent = layout.indent;
+                    if name == "ul" || name == "ol" {
+                        layout.indent += LIST_INDENT * self.font_size;
+                    }
+                    open.push(OpenElement { name, style: inner, indent });
+                }
+                Token::End(name) => {
+                    // Closing an element also closes anything left open inside it
+                    let pos = match open.iter().rposition(|e| e.name == name) {
+                        Some(pos) => pos,
+                        None => continue,
+                    };
+                    let element = open.remove(pos);
+                    open.truncate(pos);
+                    match name.as_str() {
+                        "p" => layout.block(BLOCK_MARGIN * self.font_size),
+                        "h1" | "h2" | "h3" => layout.block(BLOCK_MARGIN * element.style.size / 2.0),
+                        "ul" | "ol" => {
+                            let nested = open.iter().any(|e| e.is_list());
+                            layout.block(if nested { 0.0 } else { BLOCK_MARGIN * self.font_size })
+                        }
+                        "li" => layout.block(0.0),
+                        _ => {}
+                    }
+                    layout.indent = element.indent;
+                }
+            }
+        }
+        layout.flush();
+        (layout.content, layout.top)
+    }
+}
+
+/// Space above and below paragraphs and top-level lists, in multiples of the
+/// body font size.
+const BLOCK_MARGIN: f64 = 0.5;
+
+/// Indentation of list items, in multiples of the body font size.
+const LIST_INDENT: f64 = 1.5;
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct TextStyle {
+    bold: bool,
+    italic: bool,
+    size: f64,
+    color: Color,
+}
+
+impl TextStyle {
+    fn font_name(&self) -> &'static str {
+        HTML_FONTS[self.bold as usize + 2 * self.italic as usize].0
+    }
+
+    fn measure(&self, text: &str) -> f64 {
+        if self.bold {
+            calculate_helvetica_bold_width(text, self.size)
+        } else {
+            calculate_helvetica_width(text, self.size)
+        }
+    }
+}
+
+struct OpenElement {
+    name: String,
+    style: TextStyle,
+    /// The indentation before the element was opened.
+    indent: f64,
+}
+
+impl OpenElement {
+    fn is_list(&self) -> bool {
+        self.name == "ul" || self.name == "ol"
+    }
+}
+
+/// A run of text in one style on the current line, `x` from its start.
+struct Fragment {
+    x: f64,
+    text: String,
+    style: TextStyle,
+}
+
+struct Layout {
+    x: f64,
+    width: f64,
+    /// Top of the next line.
+    top: f64,
+    line_height: f64,
+    indent: f64,
+    line: Vec<Fragment>,
+    line_width: f64,
+    /// Whether whitespace comes before the next word.
+    space: bool,
+    /// The style of a list item whose bullet goes on the next line.
+    bullet: Option<TextStyle>,
+    /// Vertical space owed before the next line.
+    gap: f64,
+    /// Whether any line has been placed, since the first has no space above.
+    started: bool,
+    content: ContentBuilder,
+    color: Option<Color>,
+}
+
+impl Layout {
+    fn text(&mut self, text: &str, style: TextStyle) {
+        let mut word = String::new();
+        for c in text.chars() {
+            if c.is_whitespace() {
+                if !word.is_empty() {
+                    self.word(&word, style);
+                    word.clear();
+                }
+                self.space = true;
+            } else {
+                word.push(c);
+            }
+        }
+        if !word.is_empty() {
+            self.word(&word, style);
+        }
+    }
+
+    fn word(&mut self, word: &str, style: TextStyle) {
+        let available = self.width - self.indent;
+        let space = self.space && !self.line.is_empty();
+        self.space = false;
+        let mut space_width = if space { style.measure(" ") } else { 0.0 };
+        let word_width = style.measure(word);
+        if space && self.line_width + space_width + word_width > available {
+            self.flush();
+            space_width = 0.0;
+        }
+        if self.line.is_empty() && word_width > available {
+            // Too wide for any line, so split it between characters
+            let mut buf = [0; 4];
+            for c in word.chars() {
+                let c = c.encode_utf8(&mut buf);
+                if !self.line.is_empty() && self.line_width + style.measure(c) > available {
+                    self.flush();
+                }
+                self.push(c, style, 0.0);
+            }
+            return;
+        }
+        if space_width > 0.0 {
+            self.push(" ", style, space_width);
+        }
+        self.push(word, style, word_width);
+    }
+
+    fn push(&mut self, text: &str, style: TextStyle, width: f64) {
+        let width = if width > 0.0 { width } else { style.measure(text) };
+        match self.line.last_mut() {
+            Some(fragment) if fragment.style == style => fragment.text.push_str(text),
+            _ => self.line.push(Fragment {
+                x: self.line_width,
+                text: text.to_string(),
+                style,
+            }),
+        }
+        self.line_width += width;
+    }
+
+    /// Ends the current line, or adds an empty one after a break on an empty line.
+    fn line_break(&mut self, style: TextStyle) {
+        if self.line.is_empty() {
+            self.start_line();
+            self.top -= style.size * self.line_height;
+        } else {
+            self.flush();
+        }
+        self.space = false;
+    }
+
+    /// Ends the current line and asks for `margin` of space before the next.
+    fn block(&mut self, margin: f64) {
+        self.flush();
+        self.space = false;
+        self.gap = self.gap.max(margin);
+    }
+
+    fn start_line(&mut self) {
+        if self.started {
+            self.top -= self.gap;
+        }
+        self.gap = 0.0;
+        self.started = true;
+    }
+
+    /// Draws the current line, if it has any text.
+    fn flush(&mut self) {
+        if self.line.is_empty() {
+            return;
+        }
+        self.start_line();
+        let size = self.line.iter().map(|f| f.style.size).fold(0.0, f64::max);
+        let metrics = FontMetrics::for_standard14(Standard14Font::Helvetica);
+        let ascent = size * metrics.ascender as f64 / metrics.units_per_em as f64;
+        let baseline = self.top - ascent;
+        let left = self.x + self.indent;
+
+        let mut c = std::mem::take(&mut self.content);
+        if let Some(style) = self.bullet.take() {
+            // A dot centered on the x-height, left of the item's text
+            c = self.set_color(c, style.color).graphics(GraphicsBuilder::new().filled_circle(
+                left - style.size * 0.75,
+                baseline + style.size * 0.26,
+                style.size * 0.1,
+            ));
+        }
+        for fragment in std::mem::take(&mut self.line) {
+            let style = fragment.style;
+            c = self
+                .set_color(c, style.color)
+                .text(style.font_name(), style.size, left + fragment.x, baseline, &fragment.text);
+        }
+        self.content = c;
+        self.line_width = 0.0;
+        self.top -= size * self.line_height;
+    }
+
+    fn set_color(&mut self, c: ContentBuilder, color: Color) -> ContentBuilder {
+        if self.color == Some(color) {
+            return c;
+        }
+        self.color = Some(color);
+        c.fill_color(color)
+    }
+}
+
+/// Applies the inline `style` and `color` attributes of an element.
+fn apply_attributes(style: &mut TextStyle, attributes: &[(String, String)]) {
+    for (key, value) in attributes {
+        match key.as_str() {
+            "color" => {
+                if let Some(color) = parse_color(value) {
+                    style.color = color;
+                }
+            }
+            "style" => {
+                for declaration in value.split(';') {
+                    let (property, value) = match declaration.split_once(':') {
+                        Some(pair) => pair,
+                        None => continue,
+                    };
+                    let value = value.trim().to_ascii_lowercase();
+                    match property.trim().to_ascii_lowercase().as_str() {
+                        "color" => {
+                            if let Some(color) = parse_color(&value) {
+                                style.color = color;
+                            }
+                        }
+                        "font-weight" => {
+                            style.bold = value == "bold"
+                                || value == "bolder"
+                                || value.parse::<u32>().map_or(false, |weight| weight >= 600)
+                        }
+                        "font-style" => style.italic = value == "italic" || value == "oblique",
+                        _ => {}
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Parses a color given as `#rgb`, `#rrggbb`, `rgb(r, g, b)` or one of the
+/// basic named colors.
+fn parse_color(value: &str) -> Option<Color> {
+    let value = value.trim().to_ascii_lowercase();
+    if let Some(hex) = value.strip_prefix('#') {
+        let hex = match hex.len() {
+            3 => hex.chars().flat_map(|c| [c, c]).collect(),
+            6 => hex.to_string(),
+            _ => return None,
+        };
+        return RgbColor::from_hex(&hex).ok().map(Color::Rgb);
+    }
+    if let Some(args) = value.strip_prefix("rgb(").and_then(|v| v.strip_suffix(')')) {
+        let parts: Vec<u8> = args.split(',').map(|p| p.trim().parse().ok()).collect::<Option<_>>()?;
+        return match parts[..] {
+            [r, g, b] => Some(Color::rgb_u8(r, g, b)),
+            _ => None,
+        };
+    }
+    let (r, g, b) = match value.as_str() {
+        "black" => (0, 0, 0),
+        "white" => (255, 255, 255),
+        "gray" | "grey" => (128, 128, 128),
+        "silver" => (192, 192, 192),
+        "red" => (255, 0, 0),
+        "maroon" => (128, 0, 0),
+        "orange" => (255, 165, 0),
+        "yellow" => (255, 255, 0),
+        "green" => (0, 128, 0),
+        "lime" => (0, 255, 0),
+        "teal" => (0, 128, 128),
+        "blue" => (0, 0, 255),
+        "navy" => (0, 0, 128),
+        "purple" => (128, 0, 128),
+        _ => return None,
+    };
+    Some(Color::rgb_u8(r, g, b))
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Start {
+        name: String,
+        attributes: Vec<(String, String)>,
+    },
+    End(String),
+    Text(String),
+}
+
+/// Splits markup into tags and text with entities decoded. A `<` that doesn't
+/// start a well-formed tag is kept as text.
+fn tokenize(html: &str) -> Vec<Token> {
+    let mut tokens = Vec::new();
+    let mut text = String::new();
+    let mut rest = html;
+    while let Some(open) = rest.find('<') {
+        text.push_str(&rest[..open]);
+        let after = &rest[open..];
+        if let Some(comment) = after.strip_prefix("<!--") {
+            rest = comment.find("-->").map_or("", |end| &comment[end + 3..]);
+            continue;
+        }
+        if after.starts_with("<!") || after.starts_with("<?") {
+            // Doctypes and processing instructions
+            rest = after.find('>').map_or("", |end| &after[end + 1..]);
+            continue;
+        }
+        let (token, len) = match parse_tag(after) {
+            Some(tag) => tag,
+            None => {
+                text.push('<');
+                rest = &after[1..];
+                continue;
+            }
+        };
+        if !text.is_empty() {
+            tokens.push(Token::Text(decode_entities(&std::mem::take(&mut text))));
+        }
+        rest = &after[len..];
+        match &token {
+            Token::Start { name, .. } if name == "script" || name == "style" => {
+                // Skip to the end tag, which is then ignored as unknown
+                let end = rest.to_ascii_lowercase().find(&format!("</{}", name));
+                rest = end.map_or("", |end| &rest[end..]);
+            }
+            _ => tokens.push(token),
+        }
+    }
+    text.push_str(rest);
+    if !text.is_empty() {
+        tokens.push(Token::Text(decode_entities(&text)));
+    }
+    tokens
+}
+
+/// Parses the tag at the start of `s`, returning it and its length in bytes.
+/// Names and attribute keys are lowercased.
+fn parse_tag(s: &str) -> Option<(Token, usize)> {
+    let bytes = s.as_bytes();
+    let skip_whitespace = |mut i: usize| {
+        while bytes.get(i).map_or(false, u8::is_ascii_whitespace) {
+            i += 1;
+        }
+        i
+    };
+
+    let closing = bytes.get(1) == Some(&b'/');
+    let name_start = if closing { 2 } else { 1 };
+    let mut i = name_start;
+    while bytes.get(i).map_or(false, u8::is_ascii_alphanumeric) {
+        i += 1;
+    }
+    if i == name_start || !bytes[name_start].is_ascii_alphabetic() {
+        return None;
+    }
+    let name = s[name_start..i].to_ascii_lowercase();
+
+    let mut attributes = Vec::new();
+    loop {
+        while bytes.get(i).map_or(false, |&b| b.is_ascii_whitespace() || b == b'/') {
+            i += 1;
+        }
+        match bytes.get(i)? {
+            b'>' => break,
+            b'=' | b'"' | b'\'' => {
+                i += 1;
+                continue;
+            }
+            _ => {}
+        }
+        let key_start = i;
+        while bytes
+            .get(i)
+            .map_or(false, |&b| !b.is_ascii_whitespace() && !matches!(b, b'=' | b'>' | b'/'))
+        {
+            i += 1;
+        }
+        let key = s[key_start..i].to_ascii_lowercase();
+        i = skip_whitespace(i);
+        let mut value = String::new();
+        if bytes.get(i) == Some(&b'=') {
+            i = skip_whitespace(i + 1);
+            match bytes.get(i) {
+                Some(&quote @ (b'"' | b'\'')) => {
+                    let end = i + 1 + s[i + 1..].find(quote as char)?;
+                    value = decode_entities(&s[i + 1..end]);
+                    i = end + 1;
+                }
+                _ => {
+                    let start = i;
+                    while bytes.get(i).map_or(false, |&b| !b.is_ascii_whitespace() && b != b'>') {
+                        i += 1;
+                    }
+                    value = decode_entities(&s[start..i]);
+                }
+            }
+        }
+        attributes.push((key, value));
+    }
+
+    let token = if closing {
+        Token::End(name)
+    } else {
+        Token::Start { name, attributes }
+    };
+    Some((token, i + 1))
+}
+
+/// Replaces character references with the characters they stand for. Unknown
+/// references are left as they are.
+fn decode_entities(text: &str) -> String {
+    let mut decoded = String::with_capacity(text.len());
+    let mut rest = text;
+    while let Some(amp) = rest.find('&') {
+        decoded.push_str(&rest[..amp]);
+        let after = &rest[amp + 1..];
+        let entity = after
+            .find(';')
+            .filter(|&end| end <= 10)
+            .and_then(|end| entity_char(&after[..end]).map(|c| (c, end)));
+        match entity {
+            Some((c, end)) => {
+                decoded.push(c);
+                rest = &after[end + 1..];
+            }
+            None => {
+                decoded.push('&');
+                rest = after;
+            }
+        }
+    }
+    decoded.push_str(rest);
+    decoded
+}
+
+fn entity_char(name: &str) -> Option<char> {
+    match name {
+        "amp" => Some('&'),
+        "lt" => Some('<'),
+        "gt" => Some('>'),
+        "quot" => Some('"'),
+        "apos" => Some('\''),
+        // Treated as an ordinary space, which is close enough for layout
+        "nbsp" => Some(' '),
+        _ => {
+            let number = name.strip_prefix('#')?;
+            let code = match number.strip_prefix('x').or_else(|| number.strip_prefix('X')) {
+                Some(hex) => u32::from_str_radix(hex, 16).ok()?,
+                None => number.parse().ok()?,
+            };
+            char::from_u32(code)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::Operator;
+
+    fn shown_text(content: &ContentBuilder) -> Vec<(String, String)> {
+        let mut font = String::new();
+        let mut shown = Vec::new();
+        for op in content.operators() {
+            match op {
+                Operator::SetFont(name, _) => font = name.clone(),
+                Operator::ShowText(text) => shown.push((font.clone(), text.clone())),
+                _ => {}
+            }
+        }
+        shown
+    }
+
+    #[test]
+    fn test_tokenize() {
+        let tokens = tokenize("<!DOCTYPE html><P Class=x id='a b'>1 &lt; 2 &amp;&bogus; &#65;<br/></p><!-- c -->x");
+        assert_eq!(
+            tokens,
+            vec![
+                Token::Start {
+                    name: "p".to_string(),
+                    attributes: vec![("class".to_string(), "x".to_string()), ("id".to_string(), "a b".to_string())],
+                },
+                Token::Text("1 < 2 &&bogus; A".to_string()),
+                Token::Start {
+                    name: "br".to_string(),
+                    attributes: vec![]
+                },
+                Token::End("p".to_string()),
+                Token::Text("x".to_string()),
+            ]
+        );
+        assert_eq!(tokenize("a < b <3"), vec![Token::Text("a < b <3".to_string())]);
+        assert_eq!(
+            tokenize("<style>p { color: red }</style>ok"),
+            vec![Token::End("style".to_string()), Token::Text("ok".to_string())]
+        );
+        assert_eq!(tokenize("<p unterminated"), vec![Token::Text("<p unterminated".to_string())]);
+    }
+
+    #[test]
+    fn test_parse_color() {
+        assert_eq!(parse_color("#f00"), Some(Color::rgb_u8(255, 0, 0)));
+        assert_eq!(parse_color(" #0000FF"), Some(Color::rgb_u8(0, 0, 255)));
+        assert_eq!(parse_color("rgb(0, 128, 0)"), Some(Color::rgb_u8(0, 128, 0)));
+        assert_eq!(parse_color("Navy"), Some(Color::rgb_u8(0, 0, 128)));
+        assert_eq!(parse_color("rgb(1, 2)"), None);
+        assert_eq!(parse_color("chartreuse"), None);
+    }
+
+    #[test]
+    fn test_render_styles() {
+        let html = "<h1>Title</h1><p>Plain <b>bold <i>both</i></b> <em>it</em> \
+                    <span style=\"color: #ff0000; font-weight: bold\">red</span></p><blink>kept</blink>";
+        let (content, bottom) = HtmlRenderer::new().render(html, 72.0, 720.0, 400.0);
+        assert_eq!(
+            shown_text(&content),
+            vec![
+                ("HelvB".to_string(), "Title".to_string()),
+                ("Helv".to_string(), "Plain".to_string()),
+                ("HelvB".to_string(), " bold".to_string()),
+                ("HelvBI".to_string(), " both".to_string()),
+                ("HelvI".to_string(), " it".to_string()),
+                ("HelvB".to_string(), " red".to_string()),
+                ("Helv".to_string(), "kept".to_string()),
+            ]
+        );
+        assert!(content.operators().contains(&Operator::SetRgbFill(1.0, 0.0, 0.0)));
+        // A 24 pt heading, its margin, the paragraph, the paragraph margin and the last line
+        let expected = 720.0 - 24.0 * 1.2 - 6.0 - 12.0 * 1.2 - 6.0 - 12.0 * 1.2;
+        assert!((bottom - expected).abs() < 1e-9, "{}", bottom);
+    }
+
+    #[test]
+    fn test_render_wraps_and_lists() {
+        let renderer = HtmlRenderer::new().font_size(10.0).line_height(1.5);
+        let (content, bottom) = renderer.render("one two three four", 0.0, 100.0, 50.0);
+        let lines = shown_text(&content);
+        assert!(lines.len() > 1);
+        assert_eq!(lines.iter().map(|(_, t)| t.as_str()).collect::<Vec<_>>().join(" "), "one two three four");
+        assert!((bottom - (100.0 - lines.len() as f64 * 15.0)).abs() < 1e-9);
+
+        let (content, _) = renderer.render("<ul><li>a<li>b</ul>", 0.0, 100.0, 200.0);
+        let bullets = content
+            .operators()
+            .iter()
+            .filter(|op| matches!(op, Operator::Fill))
+            .count();
+        assert_eq!(bullets, 2);
+        assert_eq!(shown_text(&content).len(), 2);
+
+        let (_, bottom) = renderer.render("a<br><br>b", 0.0, 100.0, 200.0);
+        assert!((bottom - (100.0 - 3.0 * 15.0)).abs() < 1e-9);
+        let (content, bottom) = renderer.render("", 0.0, 100.0, 200.0);
+        assert!(content.operators().is_empty());
+        assert_eq!(bottom, 100.0);
+    }
+}
@@ -0,0 +1,152 @@
+//! JPEG header parsing.
+//!
+//! JPEG files are embedded unchanged with the DCTDecode filter, so only the
+//! frame header and the Adobe APP14 segment need to be read. Baseline and
+//! progressive 8-bit JPEGs with 1, 3 or 4 components are supported; lossless,
+//! arithmetic-coded and 12-bit JPEGs are rejected, since PDF readers can't
+//! decode them.
+
+use crate::error::ImageError;
+
+/// The parts of a JPEG header needed to embed it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(super) struct JpegHeader {
+    /// Width in pixels.
+    pub width: u32,
+    /// Height in pixels.
+    pub height: u32,
+    /// Number of color components.
+    pub components: u8,
+    /// Whether the file has an Adobe APP14 segment. Adobe applications
+    /// write the samples of 4-component (CMYK and YCCK) JPEGs inverted.
+    pub adobe: bool,
+}
+
+/// Reads the header of a JPEG, up to the first scan.
+pub(super) fn read_header(bytes: &[u8]) -> Result<JpegHeader, ImageError> {
+    if !bytes.starts_with(&[0xFF, 0xD8]) {
+        return Err(ImageError::UnsupportedFormat("not a JPEG".to_string()));
+    }
+    let truncated = || ImageError::DecodeFailed("truncated JPEG header".to_string());
+
+    let mut frame = None;
+    let mut adobe = false;
+    let mut pos = 2;
+    loop {
+        if bytes.get(pos) != Some(&0xFF) {
+            return Err(ImageError::DecodeFailed(format!("expected a JPEG marker at byte {}", pos)));
+        }
+        // Any number of 0xFF fill bytes may come before a marker
+        while bytes.get(pos + 1) == Some(&0xFF) {
+            pos += 1;
+        }
+        let marker = *bytes.get(pos + 1).ok_or_else(truncated)?;
+        match marker {
+            // Markers without a segment
+            0x01 | 0xD0..=0xD7 => {
+                pos += 2;
+                continue;
+            }
+            0xD9 | 0xDA => break,
+            _ => {}
+        }
+
+        let length = bytes.get(pos + 2..pos + 4).ok_or_else(truncated)?;
+        let length = u16::from_be_bytes([length[0], length[1]]) as usize;
+        let segment = bytes.get(pos + 4..pos + 2 + length.max(2)).ok_or_else(truncated)?;
+        match marker {
+            // Baseline, extended sequential and progressive Huffman frames
+            0xC0..=0xC2 => {
+                if segment.len() < 6 {
+                    return Err(truncated());
+                }
+                if segment[0] != 8 {
+                    return Err(ImageError::UnsupportedFormat(format!(
+                        "{}-bit JPEG, only 8-bit is supported",
+                        segment[0]
+                    )));
+                }
+                frame = Some((
+                    u16::from_be_bytes([segment[3], segment[4]]) as u32,
+                    u16::from_be_bytes([segment[1], segment[2]]) as u32,
+                    segment[5],
+                ));
+            }
+            0xC3 | 0xC7 | 0xCB | 0xCF => {
+                return Err(ImageError::UnsupportedFormat("lossless JPEG".to_string()));
+            }
+            0xC5 | 0xC6 | 0xC9 | 0xCA | 0xCD | 0xCE => {
+                return Err(ImageError::UnsupportedFormat("arithmetic-coded JPEG".to_string()));
+            }
+            0xEE if segment.starts_with(b"Adobe") => adobe = true,
+            _ => {}
+        }
+        pos += 2 + length;
+    }
+
+    let (width, height, components) =
+        frame.ok_or_else(|| ImageError::DecodeFailed("JPEG has no frame header".to_string()))?;
+    if width == 0 || height == 0 {
+        return Err(ImageError::InvalidDimensions { width, height });
+    }
+    if !matches!(components, 1 | 3 | 4) {
+        return Err(ImageError::UnsupportedFormat(format!("JPEG with {} components", components)));
+    }
+    Ok(JpegHeader {
+        width,
+        height,
+        components,
+        adobe,
+    })
+}
+
+/// Builds the header of a JPEG with the given frame marker and components,
+/// with an Adobe APP14 segment when `adobe` is set. There is no image data
+/// after the start of the scan.
+#[cfg(test)]
+pub(crate) fn test_header(frame_marker: u8, components: u8, adobe: bool) -> Vec<u8> {
+    let mut bytes = vec![0xFF, 0xD8];
+    if adobe {
+        // Version 100, no flags, transform 0 (CMYK)
+        bytes.extend_from_slice(&[0xFF, 0xEE, 0x00, 0x0E]);
+        bytes.extend_from_slice(b"Adobe");
+        bytes.extend_from_slice(&[0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00]);
+    }
+    let length = 8 + 3 * components as u16;
+    bytes.extend_from_slice(&[0xFF, frame_marker]);
+    bytes.extend_from_slice(&length.to_be_bytes());
+    // 8-bit, 2 lines of 3 pixels
+    bytes.extend_from_slice(&[0x08, 0x00, 0x02, 0x00, 0x03, components]);
+    for id in 1..=components {
+        bytes.extend_from_slice(&[id, 0x11, 0x00]);
+    }
+    bytes.extend_from_slice(&[0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9]);
+    bytes
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_read_header() {
+        let header = read_header(&test_header(0xC0, 4, true)).unwrap();
+        assert_eq!(
+            header,
+            JpegHeader {
+                width: 3,
+                height: 2,
+                components: 4,
+                adobe: true
+            }
+        );
+        let header = read_header(&test_header(0xC2, 3, false)).unwrap();
+        assert_eq!((header.components, header.adobe), (3, false));
+
+        assert!(matches!(read_header(&test_header(0xC3, 3, false)), Err(ImageError::UnsupportedFormat(_))));
+        assert!(matches!(read_header(&test_header(0xC9, 3, false)), Err(ImageError::UnsupportedFormat(_))));
+        assert!(matches!(read_header(&test_header(0xC0, 2, false)), Err(ImageError::UnsupportedFormat(_))));
+        assert!(matches!(read_header(&test_header(0xC0, 1, false)[..12]), Err(ImageError::DecodeFailed(_))));
+        assert!(matches!(read_header(&[0xFF, 0xD8, 0xFF, 0xD9]), Err(ImageError::DecodeFailed(_))));
+    }
+}
@@ -3,9 +3,12 @@
 //! This module provides support for embedding JPEG and PNG images in PDF documents
 //! as XObject resources.
 
+mod jpeg;
 mod xobject;
 
 pub use xobject::ImageXObject;
+#[cfg(test)]
+pub(crate) use jpeg::test_header as test_jpeg_header;
 
 use crate::error::ImageError;
 use std::path::Path;
@@ -77,6 +80,10 @@ pub struct Image {
     pub data: Vec<u8>,
     /// Optional soft mask (alpha channel) for PNG with transparency.
     pub soft_mask: Option<Box<Image>>,
+    /// Whether the CMYK samples are stored inverted, as Adobe applications
+    /// write CMYK JPEGs. Inverted images are embedded with a /Decode array
+    /// that flips them back.
+    pub inverted: bool,
 }
 
 impl Image {
@@ -97,6 +104,7 @@ impl Image {
             filter,
             data,
             soft_mask: None,
+            inverted: false,
         }
     }
 
@@ -144,41 +152,46 @@ impl Image {
     /// Loads a JPEG image from bytes.
     ///
     /// JPEG images can be embedded directly in PDF using DCTDecode filter,
-    /// so we just need to parse the header to get dimensions.
+    /// so we just need to parse the header to get dimensions. Baseline and
+    /// progressive 8-bit JPEGs are supported: 1 component is embedded as
+    /// grayscale, 3 as RGB and 4 as CMYK (or YCCK, which readers convert to
+    /// CMYK). A 4-component JPEG with an Adobe APP14 marker is treated as
+    /// inverted.
     pub fn from_jpeg_bytes(bytes: &[u8]) -> Result<Self, ImageError> {
-        use image::ImageReader;
-        use std::io::Cursor;
-
-        let reader = ImageReader::new(Cursor::new(bytes))
-            .with_guessed_format()
-            .map_err(|e| ImageError::LoadFailed(e.to_string()))?;
-
-        let img = reader
-            .decode()
-            .map_err(|e| ImageError::DecodeFailed(e.to_string()))?;
-
-        let width = img.width();
-        let height = img.height();
-
-        let color_space = match img.color() {
-            image::ColorType::L8 | image::ColorType::L16 | image::ColorType::La8 | image::ColorType::La16 => {
-                ColorSpace::DeviceGray
-            }
-            _ => ColorSpace::DeviceRGB,
+        let header = jpeg::read_header(bytes)?;
+        let color_space = match header.components {
+            1 => ColorSpace::DeviceGray,
+            3 => ColorSpace::DeviceRGB,
+            _ => ColorSpace::DeviceCMYK,
         };
 
         // For JPEG, we embed the original bytes directly
         Ok(Self {
-            width,
-            height,
+            width: header.width,
+            height: header.height,
             color_space,
             bits_per_component: 8,
             filter: ImageFilter::DCTDecode,
             data: bytes.to_vec(),
             soft_mask: None,
+            inverted: header.components == 4 && header.adobe,
         })
     }
 
+    /// Loads a CMYK JPEG from bytes, for files whose inversion the Adobe
+    /// marker doesn't tell reliably. `inverted` says whether the samples are
+    /// stored inverted. Fails unless the JPEG has 4 components.
+    pub fn from_cmyk_jpeg_bytes(bytes: &[u8], inverted: bool) -> Result<Self, ImageError> {
+        let image = Self::from_jpeg_bytes(bytes)?;
+        if image.color_space != ColorSpace::DeviceCMYK {
+            return Err(ImageError::UnsupportedFormat(format!(
+                "expected a CMYK JPEG, found {} components",
+                image.color_space.components()
+            )));
+        }
+        Ok(Self { inverted, ..image })
+    }
+
     /// Loads a PNG image from a file.
     pub fn from_png_file(path: impl AsRef<Path>) -> Result<Self, ImageError> {
         let bytes =
@@ -273,6 +286,7 @@ impl Image {
                 filter: ImageFilter::FlateDecode,
                 data: compressed_alpha,
                 soft_mask: None,
+                inverted: false,
             }))
         } else {
             None
@@ -286,6 +300,7 @@ impl Image {
             filter: ImageFilter::FlateDecode,
             data: compressed_data,
             soft_mask,
+            inverted: false,
         })
     }
 
@@ -316,4 +331,21 @@ mod tests {
         assert_eq!(ImageFilter::DCTDecode.as_pdf_name(), "DCTDecode");
         assert_eq!(ImageFilter::FlateDecode.as_pdf_name(), "FlateDecode");
     }
+
+    #[test]
+    fn test_cmyk_jpeg() {
+        let adobe = test_jpeg_header(0xC0, 4, true);
+        let image = Image::from_bytes(&adobe).unwrap();
+        assert_eq!(image.color_space, ColorSpace::DeviceCMYK);
+        assert!(image.inverted);
+        assert_eq!(image.data, adobe);
+
+        let plain = Image::from_jpeg_bytes(&test_jpeg_header(0xC2, 4, false)).unwrap();
+        assert!(!plain.inverted);
+        assert!(Image::from_cmyk_jpeg_bytes(&adobe, false).is_ok_and(|image| !image.inverted));
+
+        let rgb = test_jpeg_header(0xC0, 3, false);
+        assert_eq!(Image::from_jpeg_bytes(&rgb).unwrap().color_space, ColorSpace::DeviceRGB);
+        assert!(Image::from_cmyk_jpeg_bytes(&rgb, true).is_err());
+    }
 }
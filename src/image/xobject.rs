@@ -1,6 +1,6 @@
 //! Image XObject creation for PDF.
 
-use super::{ColorSpace, Image, ImageFilter};
+use super::{ColorSpace, Image};
 use crate::object::{Object, PdfDictionary, PdfName, PdfStream};
 use crate::types::ObjectId;
 
@@ -80,9 +80,8 @@ impl ImageXObject {
             dict.set("SMask", Object::Reference(mask_id));
         }
 
-        // For JPEG images, add decode parameters if needed
-        if image.filter == ImageFilter::DCTDecode && image.color_space == ColorSpace::DeviceCMYK {
-            // CMYK JPEGs often need inverted decode
+        // Flip inverted CMYK samples back
+        if image.inverted && image.color_space == ColorSpace::DeviceCMYK {
             let decode = crate::object::PdfArray::from_iter([
                 Object::Integer(1),
                 Object::Integer(0),
@@ -130,6 +129,7 @@ impl ImageXObject {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::image::ImageFilter;
 
     #[test]
     fn test_xobject_from_image() {
@@ -156,4 +156,16 @@ mod tests {
         assert!(dict_str.contains("/ColorSpace /DeviceRGB"));
         assert!(dict_str.contains("/Filter /FlateDecode"));
     }
+
+    #[test]
+    fn test_cmyk_decode() {
+        let mut image = Image::new(3, 2, ColorSpace::DeviceCMYK, 8, ImageFilter::DCTDecode, vec![0; 24]);
+        let dict_str = ImageXObject::from_image(&image).stream.dictionary_to_pdf_string();
+        assert!(dict_str.contains("/ColorSpace /DeviceCMYK"));
+        assert!(!dict_str.contains("/Decode"));
+
+        image.inverted = true;
+        let dict_str = ImageXObject::from_image(&image).stream.dictionary_to_pdf_string();
+        assert!(dict_str.contains("/Decode [1 0 1 0 1 0 1 0]"));
+    }
 }
@@ -66,12 +66,16 @@ pub mod encryption;
 pub mod error;
 pub mod font;
 pub mod forms;
+#[cfg(feature = "html")]
+pub mod html;
 #[cfg(feature = "images")]
 pub mod image;
 pub mod object;
 pub mod page;
 #[cfg(feature = "parser")]
 pub mod parser;
+#[cfg(feature = "render")]
+pub mod render;
 #[cfg(feature = "signatures")]
 pub mod signatures;
 pub mod types;
@@ -81,10 +85,17 @@ pub mod writer;
 pub mod ffi;
 
 // Re-export commonly used types
-pub use color::{CmykColor, Color, GrayColor, RgbColor};
-pub use content::{ContentBuilder, GraphicsBuilder, Operator, TextBuilder, TextElement};
-pub use document::{Document, DocumentBuilder, DocumentInfo, PdfVersion};
-pub use error::{ContentError, DocumentError, FormError, ObjectError, PdfError, PdfResult, WriterError};
+pub use color::{CmykColor, Color, Gradient, GradientGeometry, GradientStop, GrayColor, Overprint, RgbColor};
+pub use content::{
+    Barcode, ContentBuilder, GraphicsBuilder, NumberFormat, Operator, PrinterMarks, QrCode, QrErrorCorrection,
+    Symbology, TextBuilder, TextElement,
+};
+pub use document::{
+    AfRelationship, Bookmark, Document, DocumentBuilder, DocumentInfo, FileAttachment, InitialView, Layer,
+    NamedDestination, PageLayout, PageMode, PageNumberAlignment, PageNumbers, PdfAConformance, PdfVersion,
+    StreamingWriter, StructureElement, StructureKid, StructureType, Watermark, XmpMetadata, Zoom,
+};
+pub use error::{ContentError, DocumentError, FontError, FormError, ObjectError, PdfError, PdfResult, WriterError};
 #[cfg(feature = "compression")]
 pub use error::CompressionError;
 #[cfg(feature = "images")]
@@ -93,13 +104,15 @@ pub use error::ImageError;
 pub use error::ParserError;
 #[cfg(feature = "encryption")]
 pub use error::EncryptionError;
+#[cfg(feature = "render")]
+pub use error::RenderError;
 #[cfg(feature = "signatures")]
 pub use error::SignatureError;
 #[cfg(feature = "encryption")]
 pub use encryption::{EncryptionConfig, EncryptionHandler, Permissions};
 #[cfg(feature = "signatures")]
 pub use signatures::{ByteRange, Certificate, DocumentSigner, PrivateKey, SignatureAlgorithm, SignatureConfig, SignatureInfo};
-pub use font::{Font, FontMetrics, Standard14Font};
+pub use font::{Font, FontMetrics, OutlineFormat, ShapedGlyph, Shaping, Standard14Font, TrueTypeFont};
 pub use forms::{
     AppearanceBuilder, BorderStyle, CheckBox, ComboBox, FieldFlags, FormField, FormFieldTrait,
     FormFieldType, ListBox, PushButton, RadioButton, RadioGroup, TextField,
@@ -112,8 +125,12 @@ pub use object::{
     DictionaryBuilder, Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString,
     StreamBuilder,
 };
-pub use page::{Page, PageBuilder};
-pub use types::{Matrix, ObjectId, Rectangle};
+pub use page::{
+    Annotation, AnnotationKind, Link, LinkTarget, Page, PageBox, PageBuilder, ResourceType, StampName, Thumbnail,
+};
+#[cfg(feature = "parser")]
+pub use page::{ImportedObjects, ImportedPage};
+pub use types::{Margins, Matrix, ObjectId, Rectangle};
 pub use writer::PdfWriter;
 
 /// Prelude module for convenient imports.
@@ -140,7 +157,7 @@ pub mod prelude {
     pub use crate::encryption::{EncryptionConfig, EncryptionHandler, Permissions};
     #[cfg(feature = "signatures")]
     pub use crate::signatures::{ByteRange, Certificate, DocumentSigner, PrivateKey, SignatureAlgorithm, SignatureConfig, SignatureInfo};
-    pub use crate::font::{Font, FontMetrics, Standard14Font};
+    pub use crate::font::{Font, FontMetrics, Standard14Font, TrueTypeFont};
     pub use crate::forms::{
         AppearanceBuilder, BorderStyle, CheckBox, ComboBox, FieldFlags, FormField,
         FormFieldTrait, FormFieldType, ListBox, PushButton, RadioButton, RadioGroup, TextField,
@@ -9,7 +9,7 @@ mod string;
 pub use array::PdfArray;
 pub use dictionary::{DictionaryBuilder, PdfDictionary};
 pub use name::PdfName;
-pub use stream::{PdfStream, StreamBuilder};
+pub use stream::{PdfStream, StreamBuilder, DEFAULT_COMPRESSION_LEVEL};
 pub use string::PdfString;
 
 use crate::types::ObjectId;
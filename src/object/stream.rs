@@ -5,6 +5,9 @@ use super::{Object, PdfDictionary, PdfName};
 #[cfg(feature = "compression")]
 use crate::error::CompressionError;
 
+/// Flate level used unless another is chosen, balancing size against speed.
+pub const DEFAULT_COMPRESSION_LEVEL: u32 = 6;
+
 /// A PDF stream object.
 ///
 /// Streams consist of a dictionary followed by the stream data:
@@ -119,7 +122,14 @@ impl PdfStream {
     /// assert!(compressed.is_compressed());
     /// ```
     #[cfg(feature = "compression")]
-    pub fn with_compression(mut self) -> Result<Self, CompressionError> {
+    pub fn with_compression(self) -> Result<Self, CompressionError> {
+        self.with_compression_level(DEFAULT_COMPRESSION_LEVEL)
+    }
+
+    /// Compresses the stream data using Flate compression at `level`, from
+    /// 0 (stored, fastest) to 9 (smallest). Higher levels are treated as 9.
+    #[cfg(feature = "compression")]
+    pub fn with_compression_level(mut self, level: u32) -> Result<Self, CompressionError> {
         use flate2::write::ZlibEncoder;
         use flate2::Compression;
         use std::io::Write;
@@ -129,7 +139,7 @@ impl PdfStream {
             return Ok(self);
         }
 
-        let mut encoder = ZlibEncoder::new(Vec::new(), Compression::default());
+        let mut encoder = ZlibEncoder::new(Vec::new(), Compression::new(level.min(9)));
         encoder
             .write_all(&self.data)
             .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
@@ -335,6 +345,18 @@ mod tests {
             assert_eq!(String::from_utf8_lossy(&decompressed), original_data);
         }
 
+        #[test]
+        fn test_compression_levels() {
+            let data = "BT /F1 12 Tf 72 700 Td (Compression levels) Tj ET\n".repeat(50);
+            let stored = PdfStream::from_text(&data).with_compression_level(0).unwrap();
+            let smallest = PdfStream::from_text(&data).with_compression_level(9).unwrap();
+
+            assert!(stored.len() > data.len());
+            assert!(smallest.len() < data.len() / 10);
+            assert_eq!(stored.decompress().unwrap(), data.as_bytes());
+            assert_eq!(smallest.decompress().unwrap(), data.as_bytes());
+        }
+
         #[test]
         fn test_double_compression_is_idempotent() {
             let stream = PdfStream::from_text("Some test data for compression");
@@ -33,6 +33,24 @@ impl PdfString {
         Self::Hex(text.into().into_bytes())
     }
 
+    /// Creates a text string for human-readable values such as metadata.
+    ///
+    /// ASCII text is stored as a literal string; anything else is encoded
+    /// as UTF-16BE with a byte order mark, as the PDF specification requires.
+    pub fn text(text: impl AsRef<str>) -> Self {
+        let text = text.as_ref();
+        if text.is_ascii() {
+            return Self::Literal(text.as_bytes().to_vec());
+        }
+
+        let mut bytes = Vec::with_capacity(2 + text.len() * 2);
+        bytes.extend_from_slice(&[0xFE, 0xFF]);
+        for unit in text.encode_utf16() {
+            bytes.extend_from_slice(&unit.to_be_bytes());
+        }
+        Self::Hex(bytes)
+    }
+
     /// Returns the raw bytes of the string.
     pub fn as_bytes(&self) -> &[u8] {
         match self {
@@ -89,8 +107,19 @@ impl PdfString {
     }
 
     /// Attempts to convert the string to a UTF-8 string.
+    ///
+    /// Strings starting with a UTF-16BE byte order mark are decoded as UTF-16.
     pub fn to_string_lossy(&self) -> String {
-        String::from_utf8_lossy(self.as_bytes()).into_owned()
+        match self.as_bytes() {
+            [0xFE, 0xFF, rest @ ..] => {
+                let units: Vec<u16> = rest
+                    .chunks_exact(2)
+                    .map(|pair| u16::from_be_bytes([pair[0], pair[1]]))
+                    .collect();
+                String::from_utf16_lossy(&units)
+            }
+            bytes => String::from_utf8_lossy(bytes).into_owned(),
+        }
     }
 }
 
@@ -110,6 +139,19 @@ impl From<String> for PdfString {
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_text_ascii_is_literal() {
+        let s = PdfString::text("Plain");
+        assert_eq!(s.to_pdf_string(), "(Plain)");
+    }
+
+    #[test]
+    fn test_text_unicode_is_utf16be() {
+        let s = PdfString::text("Jos\u{e9}");
+        assert_eq!(s.to_pdf_string(), "<FEFF004A006F007300E9>");
+        assert_eq!(s.to_string_lossy(), "Jos\u{e9}");
+    }
+
     #[test]
     fn test_literal_simple() {
         let s = PdfString::literal("Hello");
@@ -0,0 +1,333 @@
+//! Markup annotations: highlights, sticky notes and stamps.
+//!
+//! Each annotation is listed in the viewer's comments panel, where readers
+//! can reply to it or delete it. Every annotation carries its own appearance
+//! so viewers that don't draw these subtypes themselves still show it.
+
+use crate::color::{Color, RgbColor};
+use crate::content::ContentBuilder;
+use crate::font::{calculate_helvetica_width, Standard14Font};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
+use crate::types::Rectangle;
+
+/// Width and height of a sticky note's icon.
+pub const NOTE_ICON_SIZE: f64 = 20.0;
+
+/// Font size of a stamp's label.
+const STAMP_FONT_SIZE: f64 = 18.0;
+
+/// Space between a stamp's label and its border.
+const STAMP_PADDING: f64 = 8.0;
+
+/// Font resource name used in stamp appearances.
+const STAMP_FONT_NAME: &str = "Helv";
+
+/// Graphics state resource name used in highlight appearances.
+const HIGHLIGHT_GSTATE_NAME: &str = "GSHighlight";
+
+/// The standard stamps that PDF viewers know by name.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum StampName {
+    /// "Approved"
+    Approved,
+    /// "Experimental"
+    Experimental,
+    /// "Not Approved"
+    NotApproved,
+    /// "As Is"
+    AsIs,
+    /// "Expired"
+    Expired,
+    /// "Not For Public Release"
+    NotForPublicRelease,
+    /// "Confidential"
+    Confidential,
+    /// "Final"
+    Final,
+    /// "Sold"
+    Sold,
+    /// "Departmental"
+    Departmental,
+    /// "For Comment"
+    ForComment,
+    /// "Top Secret"
+    TopSecret,
+    /// "Draft"
+    Draft,
+    /// "For Public Release"
+    ForPublicRelease,
+}
+
+/// Standard stamps with their PDF names and labels.
+const STAMP_NAMES: [(StampName, &str, &str); 14] = [
+    (StampName::Approved, "Approved", "APPROVED"),
+    (StampName::Experimental, "Experimental", "EXPERIMENTAL"),
+    (StampName::NotApproved, "NotApproved", "NOT APPROVED"),
+    (StampName::AsIs, "AsIs", "AS IS"),
+    (StampName::Expired, "Expired", "EXPIRED"),
+    (StampName::NotForPublicRelease, "NotForPublicRelease", "NOT FOR PUBLIC RELEASE"),
+    (StampName::Confidential, "Confidential", "CONFIDENTIAL"),
+    (StampName::Final, "Final", "FINAL"),
+    (StampName::Sold, "Sold", "SOLD"),
+    (StampName::Departmental, "Departmental", "DEPARTMENTAL"),
+    (StampName::ForComment, "ForComment", "FOR COMMENT"),
+    (StampName::TopSecret, "TopSecret", "TOP SECRET"),
+    (StampName::Draft, "Draft", "DRAFT"),
+    (StampName::ForPublicRelease, "ForPublicRelease", "FOR PUBLIC RELEASE"),
+];
+
+impl StampName {
+    /// Returns the stamp with the PDF name `name`, such as `"Approved"`.
+    pub fn from_name(name: &str) -> Option<Self> {
+        STAMP_NAMES.iter().find(|(_, n, _)| *n == name).map(|(s, _, _)| *s)
+    }
+
+    /// Returns the PDF name of the stamp.
+    pub fn name(self) -> &'static str {
+        STAMP_NAMES.iter().find(|(s, _, _)| *s == self).map_or("", |(_, n, _)| n)
+    }
+
+    /// Returns the text printed on the stamp.
+    pub fn label(self) -> &'static str {
+        STAMP_NAMES.iter().find(|(s, _, _)| *s == self).map_or("", |(_, _, l)| l)
+    }
+
+    /// Returns the ink color of the stamp: green for stamps that clear a
+    /// document, red for the rest.
+    pub fn color(self) -> RgbColor {
+        match self {
+            StampName::Approved | StampName::Final | StampName::ForPublicRelease => RgbColor::new_unchecked(0.1, 0.5, 0.1),
+            _ => RgbColor::new_unchecked(0.75, 0.1, 0.1),
+        }
+    }
+}
+
+/// What an annotation is.
+#[derive(Debug, Clone, PartialEq)]
+pub enum AnnotationKind {
+    /// A translucent color over an area, like a highlighter pen.
+    Highlight(RgbColor),
+    /// A sticky note icon that opens to show its contents.
+    Note,
+    /// A rubber stamp.
+    Stamp(StampName),
+}
+
+/// A comment on a page.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Annotation {
+    /// The annotated area, in page coordinates.
+    pub rect: Rectangle,
+    /// What the annotation is.
+    pub kind: AnnotationKind,
+    /// Text of the comment, shown in the comments panel.
+    pub contents: Option<String>,
+}
+
+impl Annotation {
+    /// Creates a highlight over `rect`.
+    pub fn highlight(rect: Rectangle, color: RgbColor) -> Self {
+        Self {
+            rect,
+            kind: AnnotationKind::Highlight(color),
+            contents: None,
+        }
+    }
+
+    /// Creates a sticky note with its icon's lower-left corner at (`x`, `y`).
+    pub fn note(x: f64, y: f64, contents: impl Into<String>) -> Self {
+        Self {
+            rect: Rectangle::new(x, y, x + NOTE_ICON_SIZE, y + NOTE_ICON_SIZE),
+            kind: AnnotationKind::Note,
+            contents: Some(contents.into()),
+        }
+    }
+
+    /// Creates a stamp with its lower-left corner at (`x`, `y`), sized to fit
+    /// its label.
+    pub fn stamp(x: f64, y: f64, stamp: StampName) -> Self {
+        let width = calculate_helvetica_width(stamp.label(), STAMP_FONT_SIZE) + 2.0 * STAMP_PADDING;
+        let height = STAMP_FONT_SIZE + 2.0 * STAMP_PADDING;
+        Self {
+            rect: Rectangle::new(x, y, x + width, y + height),
+            kind: AnnotationKind::Stamp(stamp),
+            contents: None,
+        }
+    }
+
+    /// Sets the text of the comment.
+    pub fn contents(mut self, contents: impl Into<String>) -> Self {
+        self.contents = Some(contents.into());
+        self
+    }
+
+    /// Returns the annotation's rectangle with its corners in order.
+    fn normalized_rect(&self) -> Rectangle {
+        Rectangle::new(
+            self.rect.llx.min(self.rect.urx),
+            self.rect.lly.min(self.rect.ury),
+            self.rect.llx.max(self.rect.urx),
+            self.rect.lly.max(self.rect.ury),
+        )
+    }
+
+    /// Builds the annotation dictionary, with `appearance` as its normal
+    /// appearance stream.
+    pub(crate) fn to_annotation(&self, appearance: Object) -> PdfDictionary {
+        let rect = self.normalized_rect();
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("Annot")));
+        let (subtype, color) = match &self.kind {
+            AnnotationKind::Highlight(color) => ("Highlight", *color),
+            AnnotationKind::Note => ("Text", note_color()),
+            AnnotationKind::Stamp(stamp) => ("Stamp", stamp.color()),
+        };
+        dict.set("Subtype", Object::Name(PdfName::new_unchecked(subtype)));
+        dict.set("Rect", numbers(&rect.to_array()));
+        if let Some(contents) = &self.contents {
+            dict.set("Contents", Object::String(PdfString::text(contents)));
+        }
+        dict.set("C", numbers(&[color.r, color.g, color.b]));
+
+        match &self.kind {
+            AnnotationKind::Highlight(_) => {
+                // Upper left, upper right, lower left, lower right
+                let quad = [rect.llx, rect.ury, rect.urx, rect.ury, rect.llx, rect.lly, rect.urx, rect.lly];
+                dict.set("QuadPoints", numbers(&quad));
+                dict.set("F", Object::Integer(4)); // Print
+            }
+            AnnotationKind::Note => {
+                dict.set("Name", Object::Name(PdfName::new_unchecked("Comment")));
+                dict.set("Open", Object::Boolean(false));
+                dict.set("F", Object::Integer(4 | 8 | 16)); // Print, NoZoom, NoRotate
+            }
+            AnnotationKind::Stamp(stamp) => {
+                dict.set("Name", Object::Name(PdfName::new_unchecked(stamp.name())));
+                dict.set("F", Object::Integer(4)); // Print
+            }
+        }
+
+        let mut ap = PdfDictionary::new();
+        ap.set("N", appearance);
+        dict.set("AP", Object::Dictionary(ap));
+        dict
+    }
+
+    /// Builds the normal appearance stream, drawn over the annotation's
+    /// rectangle.
+    pub(crate) fn appearance(&self) -> PdfStream {
+        let rect = self.normalized_rect();
+        let (width, height) = (rect.width(), rect.height());
+        let mut resources = PdfDictionary::new();
+        let content = match &self.kind {
+            AnnotationKind::Highlight(color) => {
+                let mut gstate = PdfDictionary::new();
+                gstate.set("Type", Object::Name(PdfName::new_unchecked("ExtGState")));
+                gstate.set("BM", Object::Name(PdfName::new_unchecked("Multiply")));
+                let mut gstates = PdfDictionary::new();
+                gstates.set(HIGHLIGHT_GSTATE_NAME, Object::Dictionary(gstate));
+                resources.set("ExtGState", Object::Dictionary(gstates));
+
+                ContentBuilder::new()
+                    .ext_gstate(HIGHLIGHT_GSTATE_NAME)
+                    .fill_color(Color::Rgb(*color))
+                    .rect(0.0, 0.0, width, height)
+                    .fill()
+            }
+            AnnotationKind::Note => {
+                // A folded sheet with three lines of writing
+                let content = ContentBuilder::new()
+                    .fill_color(Color::Rgb(note_color()))
+                    .stroke_color(Color::Rgb(RgbColor::BLACK))
+                    .line_width(0.5)
+                    .rect(0.5, 0.5, width - 1.0, height - 1.0)
+                    .fill_and_stroke();
+                [0.7, 0.5, 0.3].iter().fold(content, |content, &line| {
+                    content.move_to(0.2 * width, line * height).line_to(0.8 * width, line * height)
+                })
+                .stroke()
+            }
+            AnnotationKind::Stamp(stamp) => {
+                let mut fonts = PdfDictionary::new();
+                fonts.set(STAMP_FONT_NAME, Object::Dictionary(Standard14Font::Helvetica.to_dictionary()));
+                resources.set("Font", Object::Dictionary(fonts));
+
+                // Center the capitals, which rise about 0.72 em
+                let baseline = (height - 0.72 * STAMP_FONT_SIZE) / 2.0;
+                ContentBuilder::new()
+                    .stroke_color(Color::Rgb(stamp.color()))
+                    .fill_color(Color::Rgb(stamp.color()))
+                    .line_width(2.0)
+                    .rect(1.0, 1.0, width - 2.0, height - 2.0)
+                    .stroke()
+                    .text(STAMP_FONT_NAME, STAMP_FONT_SIZE, STAMP_PADDING, baseline, stamp.label())
+            }
+        };
+
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("XObject")));
+        dict.set("Subtype", Object::Name(PdfName::new_unchecked("Form")));
+        dict.set("BBox", numbers(&[0.0, 0.0, width, height]));
+        dict.set("Resources", Object::Dictionary(resources));
+        PdfStream::with_dictionary(dict, content.build_string())
+    }
+}
+
+/// Returns the yellow of sticky notes.
+fn note_color() -> RgbColor {
+    RgbColor::new_unchecked(1.0, 0.85, 0.3)
+}
+
+fn numbers(values: &[f64]) -> Object {
+    Object::Array(values.iter().map(|&v| Object::Real(v)).collect::<PdfArray>())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::ObjectId;
+
+    #[test]
+    fn test_stamp_names() {
+        assert_eq!(StampName::from_name("NotApproved"), Some(StampName::NotApproved));
+        assert_eq!(StampName::from_name("approved"), None);
+        for (stamp, name, label) in STAMP_NAMES {
+            assert_eq!(StampName::from_name(name), Some(stamp));
+            assert_eq!(stamp.label(), label);
+        }
+    }
+
+    #[test]
+    fn test_highlight_annotation() {
+        let highlight = Annotation::highlight(Rectangle::new(100.0, 720.0, 10.0, 700.0), RgbColor::new_unchecked(1.0, 1.0, 0.0));
+        let dict = highlight.to_annotation(Object::Reference(ObjectId::new(9))).to_pdf_string();
+        assert!(dict.contains("/Subtype /Highlight /Rect [10 700 100 720]"));
+        assert!(dict.contains("/C [1 1 0] /QuadPoints [10 720 100 720 10 700 100 700] /F 4"));
+        assert!(dict.contains("/AP << /N 9 0 R >>"));
+        assert!(!dict.contains("/Contents"));
+
+        let appearance = highlight.appearance();
+        assert!(appearance.dictionary_to_pdf_string().contains("/BBox [0 0 90 20]"));
+        assert!(appearance.dictionary_to_pdf_string().contains("/BM /Multiply"));
+        assert!(String::from_utf8_lossy(appearance.data()).contains("/GSHighlight gs"));
+    }
+
+    #[test]
+    fn test_note_and_stamp_annotations() {
+        let note = Annotation::note(50.0, 60.0, "Check this figure");
+        let dict = note.to_annotation(Object::Null).to_pdf_string();
+        assert!(dict.contains("/Subtype /Text /Rect [50 60 70 80] /Contents (Check this figure)"));
+        assert!(dict.contains("/Name /Comment /Open false /F 28"));
+
+        let stamp = Annotation::stamp(300.0, 400.0, StampName::Approved).contents("Signed off");
+        let dict = stamp.to_annotation(Object::Null).to_pdf_string();
+        assert!(dict.contains("/Subtype /Stamp"));
+        assert!(dict.contains("/Contents (Signed off)"));
+        assert!(dict.contains("/Name /Approved"));
+        assert_eq!(stamp.rect.height(), 34.0);
+        assert!(stamp.rect.width() > calculate_helvetica_width("APPROVED", STAMP_FONT_SIZE));
+        let appearance = stamp.appearance();
+        assert!(appearance.dictionary_to_pdf_string().contains("/Font << /Helv"));
+        assert!(String::from_utf8_lossy(appearance.data()).contains("(APPROVED) Tj"));
+    }
+}
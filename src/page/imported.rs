@@ -0,0 +1,175 @@
+//! Pages copied from other PDF files.
+
+use std::collections::BTreeMap;
+use std::sync::Arc;
+
+use crate::object::{Object, PdfArray, PdfDictionary, PdfStream};
+use crate::types::{Matrix, ObjectId, Rectangle};
+
+/// Objects copied from another PDF file.
+///
+/// References between the objects are stored as indices into the set and
+/// are mapped to real object numbers when the document is written. Pages
+/// imported from the same file share one set, so resources they have in
+/// common are written once.
+#[derive(Debug, PartialEq)]
+pub struct ImportedObjects {
+    objects: Vec<Object>,
+    /// Indices of objects that were referred to but missing from the source
+    /// file, which hold `null`, with the references they were copied from.
+    missing: BTreeMap<usize, ObjectId>,
+}
+
+impl ImportedObjects {
+    /// Creates a set from objects whose references are indices into
+    /// `objects`, of which those at the keys of `missing` could not be read.
+    pub(crate) fn new(objects: Vec<Object>, missing: BTreeMap<usize, ObjectId>) -> Self {
+        Self { objects, missing }
+    }
+
+    /// Returns the number of objects in the set.
+    pub fn len(&self) -> usize {
+        self.objects.len()
+    }
+
+    /// Returns true if the set is empty.
+    pub fn is_empty(&self) -> bool {
+        self.objects.is_empty()
+    }
+
+    /// Returns the object at `index`.
+    pub(crate) fn get(&self, index: usize) -> Option<&Object> {
+        self.objects.get(index)
+    }
+
+    /// Returns the reference in the source file of the object at `index`
+    /// if it was missing there.
+    pub(crate) fn missing(&self, index: usize) -> Option<ObjectId> {
+        self.missing.get(&index).copied()
+    }
+
+    /// Returns the objects with references replaced by `ids`, where
+    /// `ids[i]` is the output object ID of the object at index `i`.
+    pub(crate) fn renumbered<'a>(&'a self, ids: &'a [ObjectId]) -> impl Iterator<Item = Object> + 'a {
+        self.objects.iter().map(move |object| renumber(object, ids))
+    }
+}
+
+/// A page of another PDF file, stored as a form XObject.
+#[derive(Debug, Clone)]
+pub struct ImportedPage {
+    objects: Arc<ImportedObjects>,
+    form: usize,
+    media_box: Rectangle,
+    upright: Option<Matrix>,
+}
+
+impl ImportedPage {
+    /// Creates a page drawn by the form XObject at index `form` of `objects`,
+    /// which `upright` turns to show in `media_box`.
+    pub(crate) fn new(
+        objects: Arc<ImportedObjects>,
+        form: usize,
+        media_box: Rectangle,
+        upright: Option<Matrix>,
+    ) -> Self {
+        Self { objects, form, media_box, upright }
+    }
+
+    /// Returns the set of objects the page was copied into.
+    pub fn objects(&self) -> &Arc<ImportedObjects> {
+        &self.objects
+    }
+
+    /// Returns the index of the page's form XObject in [`objects`](Self::objects).
+    pub fn form(&self) -> usize {
+        self.form
+    }
+
+    /// Returns the visible area of the page, in the coordinates that
+    /// [`upright`](Self::upright) turns the form into.
+    pub fn media_box(&self) -> Rectangle {
+        self.media_box
+    }
+
+    /// Returns the matrix that turns the form upright when the original
+    /// page is rotated, or `None` if it is not.
+    pub fn upright(&self) -> Option<Matrix> {
+        self.upright
+    }
+
+    /// Returns the matrix that draws the page upright with the lower-left
+    /// corner of its visible area at (`x`, `y`), scaled by `scale`.
+    pub fn placement(&self, x: f64, y: f64, scale: f64) -> Matrix {
+        let (llx, lly) = (self.media_box.llx, self.media_box.lly);
+        let place = Matrix::new(scale, 0.0, 0.0, scale, x - llx * scale, y - lly * scale);
+        match self.upright {
+            Some(upright) => upright.multiply(&place),
+            None => place,
+        }
+    }
+}
+
+/// Replaces every reference in `object` with the matching entry of `ids`.
+///
+/// Indices outside `ids` cannot occur for sets built by the parser; they
+/// are written as `null`, as PDF does for references to missing objects.
+fn renumber(object: &Object, ids: &[ObjectId]) -> Object {
+    match object {
+        Object::Reference(id) => ids
+            .get(id.number as usize)
+            .map_or(Object::Null, |&id| Object::Reference(id)),
+        Object::Array(array) => Object::Array(PdfArray::from_objects(
+            array.iter().map(|o| renumber(o, ids)).collect(),
+        )),
+        Object::Dictionary(dict) => Object::Dictionary(renumber_dictionary(dict, ids)),
+        Object::Stream(stream) => Object::Stream(PdfStream::from_raw(
+            renumber_dictionary(&stream.dictionary, ids),
+            stream.data.clone(),
+        )),
+        other => other.clone(),
+    }
+}
+
+fn renumber_dictionary(dict: &PdfDictionary, ids: &[ObjectId]) -> PdfDictionary {
+    let mut renumbered = PdfDictionary::with_capacity(dict.len());
+    for (key, value) in dict.iter() {
+        renumbered.set(key.clone(), renumber(value, ids));
+    }
+    renumbered
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::object::PdfName;
+
+    #[test]
+    fn test_renumbered() {
+        let mut font = PdfDictionary::new();
+        font.set("Type", Object::Name(PdfName::new_unchecked("Font")));
+        let mut resources = PdfDictionary::new();
+        resources.set("F1", Object::Reference(ObjectId::new(0)));
+        let mut form = PdfDictionary::new();
+        form.set("Resources", Object::Dictionary(resources));
+        let objects = ImportedObjects::new(
+            vec![
+                Object::Dictionary(font),
+                Object::Stream(PdfStream::with_dictionary(form, b"BT ET".to_vec())),
+                Object::Reference(ObjectId::new(5)),
+            ],
+            BTreeMap::new(),
+        );
+
+        let ids = [ObjectId::new(12), ObjectId::new(13), ObjectId::new(14)];
+        let renumbered: Vec<Object> = objects.renumbered(&ids).collect();
+        match &renumbered[1] {
+            Object::Stream(stream) => {
+                assert!(stream.dictionary.to_pdf_string().contains("/F1 12 0 R"));
+                assert_eq!(stream.data(), b"BT ET");
+            }
+            _ => panic!("Expected stream"),
+        }
+        assert_eq!(renumbered[2], Object::Null);
+    }
+}
@@ -0,0 +1,157 @@
+//! Link annotations.
+
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::types::{ObjectId, Rectangle};
+
+/// Where a link goes when clicked.
+#[derive(Debug, Clone, PartialEq)]
+pub enum LinkTarget {
+    /// A web address or other URI.
+    Uri(String),
+    /// A page in the same document.
+    Page {
+        /// Zero-based index of the destination page.
+        page_index: usize,
+        /// Vertical position scrolled to the top of the view, or `None` to
+        /// keep the viewer's current position.
+        y: Option<f64>,
+    },
+    /// A named destination of the document.
+    Named(String),
+}
+
+/// A clickable area on a page.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Link {
+    /// The clickable area, in page coordinates.
+    pub rect: Rectangle,
+    /// The link destination.
+    pub target: LinkTarget,
+}
+
+impl Link {
+    /// Creates a link to a URI.
+    pub fn uri(rect: Rectangle, uri: impl Into<String>) -> Self {
+        Self {
+            rect,
+            target: LinkTarget::Uri(uri.into()),
+        }
+    }
+
+    /// Creates a link to another page of the document.
+    pub fn internal(rect: Rectangle, page_index: usize, y: Option<f64>) -> Self {
+        Self {
+            rect,
+            target: LinkTarget::Page { page_index, y },
+        }
+    }
+
+    /// Creates a link to the named destination `name`, which must exist when
+    /// the document is written.
+    pub fn named(rect: Rectangle, name: impl Into<String>) -> Self {
+        Self {
+            rect,
+            target: LinkTarget::Named(name.into()),
+        }
+    }
+
+    /// Builds the link annotation dictionary.
+    ///
+    /// Returns `None` if the target page is not in `page_ids`.
+    pub(crate) fn to_annotation(&self, page_ids: &[ObjectId]) -> Option<PdfDictionary> {
+        let rect = [
+            self.rect.llx.min(self.rect.urx),
+            self.rect.lly.min(self.rect.ury),
+            self.rect.llx.max(self.rect.urx),
+            self.rect.lly.max(self.rect.ury),
+        ];
+        let mut rect_array = PdfArray::new();
+        for v in rect {
+            rect_array.push(Object::Real(v));
+        }
+        let mut border = PdfArray::new();
+        for _ in 0..3 {
+            border.push(Object::Integer(0));
+        }
+
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("Annot")));
+        dict.set("Subtype", Object::Name(PdfName::new_unchecked("Link")));
+        dict.set("Rect", Object::Array(rect_array));
+        dict.set("Border", Object::Array(border));
+
+        match &self.target {
+            LinkTarget::Uri(uri) => {
+                let mut action = PdfDictionary::new();
+                action.set("S", Object::Name(PdfName::new_unchecked("URI")));
+                action.set("URI", Object::String(PdfString::literal(encode_uri(uri))));
+                dict.set("A", Object::Dictionary(action));
+            }
+            LinkTarget::Page { page_index, y } => {
+                let mut dest = PdfArray::new();
+                dest.push(Object::Reference(*page_ids.get(*page_index)?));
+                dest.push(Object::Name(PdfName::new_unchecked("XYZ")));
+                dest.push(Object::Null);
+                dest.push(y.map_or(Object::Null, Object::Real));
+                dest.push(Object::Null);
+                dict.set("Dest", Object::Array(dest));
+            }
+            LinkTarget::Named(name) => {
+                dict.set("Dest", Object::String(PdfString::text(name)));
+            }
+        }
+        Some(dict)
+    }
+}
+
+/// Percent-encodes the bytes of `uri` that are not printable ASCII.
+///
+/// URIs in PDF are 7-bit ASCII; existing escapes such as `%20` are kept.
+fn encode_uri(uri: &str) -> String {
+    let mut encoded = String::with_capacity(uri.len());
+    for &byte in uri.as_bytes() {
+        if byte.is_ascii_graphic() {
+            encoded.push(byte as char);
+        } else {
+            encoded.push_str(&format!("%{:02X}", byte));
+        }
+    }
+    encoded
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_uri_annotation() {
+        let link = Link::uri(Rectangle::new(10.0, 20.0, 110.0, 40.0), "https://example.com/a (b)");
+        let dict = link.to_annotation(&[]).unwrap().to_pdf_string();
+        assert!(dict.contains("/Subtype /Link"));
+        assert!(dict.contains("/Rect [10 20 110 40]"));
+        assert!(dict.contains("/A << /S /URI /URI (https://example.com/a%20\\(b\\)) >>"));
+    }
+
+    #[test]
+    fn test_internal_annotation() {
+        let link = Link::internal(Rectangle::new(50.0, 60.0, 0.0, 0.0), 1, Some(700.0));
+        let pages = [ObjectId::new(3), ObjectId::new(7)];
+        let dict = link.to_annotation(&pages).unwrap().to_pdf_string();
+        assert!(dict.contains("/Rect [0 0 50 60]"));
+        assert!(dict.contains("/Dest [7 0 R /XYZ null 700 null]"));
+        assert!(link.to_annotation(&pages[..1]).is_none());
+    }
+
+    #[test]
+    fn test_named_annotation() {
+        let link = Link::named(Rectangle::new(0.0, 0.0, 10.0, 10.0), "chapter-2");
+        let dict = link.to_annotation(&[]).unwrap().to_pdf_string();
+        assert!(dict.contains("/Dest (chapter-2)"));
+    }
+
+    #[test]
+    fn test_encode_uri() {
+        assert_eq!(encode_uri("https://example.com/caf\u{e9}?q=a b"), "https://example.com/caf%C3%A9?q=a%20b");
+        assert_eq!(encode_uri("https://example.com/%20"), "https://example.com/%20");
+    }
+}
@@ -1,27 +1,134 @@
 //! PDF Page handling.
 
+mod annotation;
+#[cfg(feature = "parser")]
+mod imported;
+mod link;
+mod thumbnail;
+
+pub use annotation::{Annotation, AnnotationKind, StampName, NOTE_ICON_SIZE};
+
+#[cfg(feature = "parser")]
+pub use imported::{ImportedObjects, ImportedPage};
+pub use link::{Link, LinkTarget};
+pub use thumbnail::Thumbnail;
+
+use crate::color::{Color, Gradient, Overprint, TilingPattern};
 use crate::content::ContentBuilder;
 use crate::font::{Font, Standard14Font};
 use crate::forms::{FormField, FormFieldTrait};
 #[cfg(feature = "images")]
 use crate::image::Image;
-use crate::object::PdfStream;
+use crate::object::{Object, PdfStream};
 use crate::types::Rectangle;
 
+/// A page boundary that print production uses besides the media box.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PageBox {
+    /// The region viewers display and print (/CropBox).
+    Crop,
+    /// The region clipped to when printing, including bleed (/BleedBox).
+    Bleed,
+    /// The intended size of the finished page after trimming (/TrimBox).
+    Trim,
+    /// The extent of the page's meaningful content (/ArtBox).
+    Art,
+}
+
+impl PageBox {
+    /// Every page boundary, in the order they are written.
+    pub const ALL: [PageBox; 4] = [PageBox::Crop, PageBox::Bleed, PageBox::Trim, PageBox::Art];
+
+    /// Returns the page dictionary key of the boundary.
+    pub fn key(self) -> &'static str {
+        match self {
+            PageBox::Crop => "CropBox",
+            PageBox::Bleed => "BleedBox",
+            PageBox::Trim => "TrimBox",
+            PageBox::Art => "ArtBox",
+        }
+    }
+}
+
+/// A category of named resources content refers to, for resources added with
+/// [`Page::add_resource`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ResourceType {
+    /// Graphics state parameter dictionaries (/ExtGState), applied by `gs`.
+    ExtGState,
+    /// Color spaces (/ColorSpace), selected by `cs` and `CS`.
+    ColorSpace,
+    /// Patterns (/Pattern), selected as a `/Pattern` color.
+    Pattern,
+    /// Shadings (/Shading), painted by `sh`.
+    Shading,
+    /// Fonts (/Font), selected by `Tf`.
+    Font,
+    /// Marked-content property lists (/Properties), used by `BDC`.
+    Properties,
+}
+
+impl ResourceType {
+    /// Returns the resource dictionary key of the category.
+    pub fn key(self) -> &'static str {
+        match self {
+            ResourceType::ExtGState => "ExtGState",
+            ResourceType::ColorSpace => "ColorSpace",
+            ResourceType::Pattern => "Pattern",
+            ResourceType::Shading => "Shading",
+            ResourceType::Font => "Font",
+            ResourceType::Properties => "Properties",
+        }
+    }
+}
+
 /// A PDF page.
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct Page {
     /// The page dimensions (MediaBox).
     pub media_box: Rectangle,
+    /// The crop box, if it differs from the media box.
+    pub crop_box: Option<Rectangle>,
+    /// The bleed box, if it differs from the crop box.
+    pub bleed_box: Option<Rectangle>,
+    /// The trim box, if it differs from the crop box.
+    pub trim_box: Option<Rectangle>,
+    /// The art box, if it differs from the crop box.
+    pub art_box: Option<Rectangle>,
+    /// Clockwise rotation in degrees with which viewers display the page
+    /// (/Rotate); a multiple of 90. Content coordinates are unaffected.
+    pub rotation: i32,
+    /// Color filling the media box beneath all other content, whatever
+    /// order it is drawn in.
+    pub background: Option<Color>,
     /// Font resources: (resource name, font).
     pub fonts: Vec<(String, Font)>,
     /// Image resources: (resource name, image).
     #[cfg(feature = "images")]
     pub images: Vec<(String, Image)>,
+    /// Pages of other PDF files: (resource name, page).
+    #[cfg(feature = "parser")]
+    pub imported: Vec<(String, ImportedPage)>,
+    /// Gradient fill patterns: (resource name, gradient).
+    pub patterns: Vec<(String, Gradient)>,
+    /// Repeating fill patterns: (resource name, pattern).
+    pub tiling_patterns: Vec<(String, TilingPattern)>,
+    /// Overprint graphics states, each named by its
+    /// [`Overprint::resource_name`].
+    pub overprints: Vec<Overprint>,
+    /// Resources given as objects of the caller's own: (category, resource
+    /// name, object).
+    pub extra_resources: Vec<(ResourceType, String, Object)>,
     /// The content stream operators.
     pub content: ContentBuilder,
     /// Form fields on this page.
     pub form_fields: Vec<FormField>,
+    /// Link annotations on this page.
+    pub links: Vec<Link>,
+    /// Highlights, notes and stamps on this page.
+    pub annotations: Vec<Annotation>,
+    /// Picture of the page for viewers' navigation panes (/Thumb).
+    pub thumbnail: Option<Thumbnail>,
 }
 
 impl Page {
@@ -29,11 +136,26 @@ impl Page {
     pub fn new(media_box: Rectangle) -> Self {
         Self {
             media_box,
+            crop_box: None,
+            bleed_box: None,
+            trim_box: None,
+            art_box: None,
+            rotation: 0,
+            background: None,
             fonts: Vec::new(),
             #[cfg(feature = "images")]
             images: Vec::new(),
+            #[cfg(feature = "parser")]
+            imported: Vec::new(),
+            patterns: Vec::new(),
+            tiling_patterns: Vec::new(),
+            overprints: Vec::new(),
+            extra_resources: Vec::new(),
             content: ContentBuilder::new(),
             form_fields: Vec::new(),
+            links: Vec::new(),
+            annotations: Vec::new(),
+            thumbnail: None,
         }
     }
 
@@ -47,6 +169,28 @@ impl Page {
         Self::new(Rectangle::letter())
     }
 
+    /// Returns the given boundary, if one is set.
+    pub fn page_box(&self, kind: PageBox) -> Option<Rectangle> {
+        match kind {
+            PageBox::Crop => self.crop_box,
+            PageBox::Bleed => self.bleed_box,
+            PageBox::Trim => self.trim_box,
+            PageBox::Art => self.art_box,
+        }
+    }
+
+    /// Sets or, with `None`, removes the given boundary. It must lie within
+    /// the media box, or writing the document fails.
+    pub fn set_page_box(&mut self, kind: PageBox, rect: Option<Rectangle>) {
+        let field = match kind {
+            PageBox::Crop => &mut self.crop_box,
+            PageBox::Bleed => &mut self.bleed_box,
+            PageBox::Trim => &mut self.trim_box,
+            PageBox::Art => &mut self.art_box,
+        };
+        *field = rect;
+    }
+
     /// Adds a font to the page resources.
     pub fn add_font(&mut self, name: impl Into<String>, font: Font) {
         self.fonts.push((name.into(), font));
@@ -61,11 +205,67 @@ impl Page {
         self.images.push((name.into(), image));
     }
 
+    /// Adds a page of another PDF file to the page resources.
+    ///
+    /// Like an image, it is drawn with the paint_xobject operator. Its
+    /// coordinates are those of the original page.
+    #[cfg(feature = "parser")]
+    pub fn add_imported_page(&mut self, name: impl Into<String>, page: ImportedPage) {
+        self.imported.push((name.into(), page));
+    }
+
+    /// Adds a gradient to the page resources.
+    ///
+    /// Shapes and text are filled with it after the fill_pattern operator
+    /// selects it by the given name.
+    pub fn add_pattern(&mut self, name: impl Into<String>, gradient: Gradient) {
+        self.patterns.push((name.into(), gradient));
+    }
+
+    /// Adds a tiling pattern to the page resources, which the fill_pattern
+    /// operator selects by the given name.
+    pub fn add_tiling_pattern(&mut self, name: impl Into<String>, pattern: TilingPattern) {
+        self.tiling_patterns.push((name.into(), pattern));
+    }
+
+    /// Adds an overprint graphics state to the page resources, unless the page
+    /// already has it. The ext_gstate operator applies it by its resource name.
+    pub fn add_overprint(&mut self, overprint: Overprint) {
+        if !self.overprints.contains(&overprint) {
+            self.overprints.push(overprint);
+        }
+    }
+
+    /// Adds a resource of the caller's own, such as a graphics state with
+    /// settings the content API has no operator for, replacing one of the
+    /// same category and name added before.
+    ///
+    /// `object` must be a direct object: streams and references to other
+    /// objects are not written with it. Resources the page's content needs
+    /// take precedence over one with the same name.
+    pub fn add_resource(&mut self, category: ResourceType, name: impl Into<String>, object: Object) {
+        let name = name.into();
+        self.extra_resources.retain(|(c, n, _)| !(*c == category && *n == name));
+        self.extra_resources.push((category, name, object));
+    }
+
     /// Adds a form field to the page.
     pub fn add_form_field(&mut self, field: FormField) {
         self.form_fields.push(field);
     }
 
+    /// Adds a link annotation to the page.
+    ///
+    /// Links are independent annotations, so overlapping areas are allowed.
+    pub fn add_link(&mut self, link: Link) {
+        self.links.push(link);
+    }
+
+    /// Adds a highlight, note or stamp to the page.
+    pub fn add_annotation(&mut self, annotation: Annotation) {
+        self.annotations.push(annotation);
+    }
+
     /// Returns whether the page has form fields.
     pub fn has_form_fields(&self) -> bool {
         !self.form_fields.is_empty()
@@ -91,9 +291,23 @@ impl Page {
         self.media_box.height()
     }
 
-    /// Builds the content stream for this page.
+    /// Builds the content stream for this page, closing any graphics states
+    /// the content leaves open.
     pub fn build_content_stream(&self) -> PdfStream {
-        PdfStream::from_text(self.content.build_string())
+        PdfStream::from_text(self.content.build_balanced_string())
+    }
+
+    /// Returns the operators painting the background, if the page has one.
+    pub(crate) fn background_content(&self) -> Option<ContentBuilder> {
+        let media = self.media_box;
+        self.background.map(|color| {
+            ContentBuilder::new()
+                .save_state()
+                .fill_color(color)
+                .rect(media.llx, media.lly, media.width(), media.height())
+                .fill()
+                .restore_state()
+        })
     }
 }
 
@@ -107,11 +321,20 @@ impl Default for Page {
 #[derive(Debug, Default)]
 pub struct PageBuilder {
     media_box: Rectangle,
+    boxes: Vec<(PageBox, Rectangle)>,
+    rotation: i32,
+    background: Option<Color>,
     fonts: Vec<(String, Font)>,
     #[cfg(feature = "images")]
     images: Vec<(String, Image)>,
+    patterns: Vec<(String, Gradient)>,
+    tiling_patterns: Vec<(String, TilingPattern)>,
+    overprints: Vec<Overprint>,
     content: Option<ContentBuilder>,
     form_fields: Vec<FormField>,
+    links: Vec<Link>,
+    annotations: Vec<Annotation>,
+    thumbnail: Option<Thumbnail>,
 }
 
 impl PageBuilder {
@@ -119,11 +342,20 @@ impl PageBuilder {
     pub fn new() -> Self {
         Self {
             media_box: Rectangle::a4(),
+            boxes: Vec::new(),
+            rotation: 0,
+            background: None,
             fonts: Vec::new(),
             #[cfg(feature = "images")]
             images: Vec::new(),
+            patterns: Vec::new(),
+            tiling_patterns: Vec::new(),
+            overprints: Vec::new(),
             content: None,
             form_fields: Vec::new(),
+            links: Vec::new(),
+            annotations: Vec::new(),
+            thumbnail: None,
         }
     }
 
@@ -163,6 +395,46 @@ impl PageBuilder {
         self
     }
 
+    /// Sets the crop box, the region viewers display and print.
+    pub fn crop_box(self, rect: Rectangle) -> Self {
+        self.page_box(PageBox::Crop, rect)
+    }
+
+    /// Sets the bleed box, the region clipped to in print production.
+    pub fn bleed_box(self, rect: Rectangle) -> Self {
+        self.page_box(PageBox::Bleed, rect)
+    }
+
+    /// Sets the trim box, the size of the finished page.
+    pub fn trim_box(self, rect: Rectangle) -> Self {
+        self.page_box(PageBox::Trim, rect)
+    }
+
+    /// Sets the art box, the extent of the page's meaningful content.
+    pub fn art_box(self, rect: Rectangle) -> Self {
+        self.page_box(PageBox::Art, rect)
+    }
+
+    /// Sets the given boundary. It must lie within the media box, or
+    /// writing the document fails.
+    pub fn page_box(mut self, kind: PageBox, rect: Rectangle) -> Self {
+        self.boxes.push((kind, rect));
+        self
+    }
+
+    /// Switches the page to landscape orientation.
+    pub fn landscape(mut self) -> Self {
+        self.media_box = self.media_box.landscape();
+        self
+    }
+
+    /// Sets the clockwise rotation, in degrees, with which viewers display
+    /// the page. It must be a multiple of 90, or writing the document fails.
+    pub fn rotation(mut self, degrees: i32) -> Self {
+        self.rotation = degrees;
+        self
+    }
+
     /// Sets the width of the page.
     pub fn width(mut self, width: f64) -> Self {
         self.media_box.urx = self.media_box.llx + width;
@@ -210,6 +482,26 @@ impl PageBuilder {
         self
     }
 
+    /// Adds a gradient with a resource name.
+    pub fn pattern(mut self, name: impl Into<String>, gradient: Gradient) -> Self {
+        self.patterns.push((name.into(), gradient));
+        self
+    }
+
+    /// Adds a tiling pattern with a resource name.
+    pub fn tiling_pattern(mut self, name: impl Into<String>, pattern: TilingPattern) -> Self {
+        self.tiling_patterns.push((name.into(), pattern));
+        self
+    }
+
+    /// Adds an overprint graphics state.
+    pub fn overprint(mut self, overprint: Overprint) -> Self {
+        if !self.overprints.contains(&overprint) {
+            self.overprints.push(overprint);
+        }
+        self
+    }
+
     /// Sets the content for the page.
     pub fn content(mut self, content: ContentBuilder) -> Self {
         self.content = Some(content);
@@ -254,23 +546,65 @@ impl PageBuilder {
         self.form_field(field)
     }
 
+    /// Adds a link annotation to the page.
+    pub fn link(mut self, link: Link) -> Self {
+        self.links.push(link);
+        self
+    }
+
+    /// Adds a highlight, note or stamp to the page.
+    pub fn annotation(mut self, annotation: Annotation) -> Self {
+        self.annotations.push(annotation);
+        self
+    }
+
+    /// Sets the thumbnail viewers show for the page.
+    pub fn thumbnail(mut self, thumbnail: Thumbnail) -> Self {
+        self.thumbnail = Some(thumbnail);
+        self
+    }
+
+    /// Fills the whole page with `color` beneath its content.
+    pub fn background(mut self, color: Color) -> Self {
+        self.background = Some(color);
+        self
+    }
+
     /// Builds the page.
     pub fn build(self) -> Page {
-        Page {
+        let mut page = Page {
             media_box: self.media_box,
+            crop_box: None,
+            bleed_box: None,
+            trim_box: None,
+            art_box: None,
+            rotation: self.rotation,
+            background: self.background,
             fonts: self.fonts,
             #[cfg(feature = "images")]
             images: self.images,
+            #[cfg(feature = "parser")]
+            imported: Vec::new(),
+            patterns: self.patterns,
+            tiling_patterns: self.tiling_patterns,
+            overprints: self.overprints,
+            extra_resources: Vec::new(),
             content: self.content.unwrap_or_default(),
             form_fields: self.form_fields,
+            links: self.links,
+            annotations: self.annotations,
+            thumbnail: self.thumbnail,
+        };
+        for (kind, rect) in self.boxes {
+            page.set_page_box(kind, Some(rect));
         }
+        page
     }
 }
 
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::color::Color;
 
     #[test]
     fn test_page_a4() {
@@ -318,6 +652,44 @@ mod tests {
         assert_eq!(page.height(), 600.0);
     }
 
+    #[test]
+    fn test_page_rotation() {
+        assert_eq!(PageBuilder::a4().build().rotation, 0);
+        let page = PageBuilder::a4().rotation(90).build();
+        assert_eq!(page.rotation, 90);
+        // Rotation does not change the page's coordinates
+        assert_eq!(page.width(), 595.0);
+    }
+
+    #[test]
+    fn test_page_background() {
+        assert!(PageBuilder::a4().build().background_content().is_none());
+        let page = PageBuilder::custom(200.0, 100.0).background(Color::RED).build();
+        let content = page.background_content().unwrap().build_string();
+        assert_eq!(content, "q\n1 0 0 rg\n0 0 200 100 re\nf\nQ");
+    }
+
+    #[test]
+    fn test_add_resource() {
+        let mut page = PageBuilder::a4().build();
+        page.add_resource(ResourceType::ExtGState, "GS1", Object::Integer(1));
+        page.add_resource(ResourceType::Shading, "GS1", Object::Integer(2));
+        page.add_resource(ResourceType::ExtGState, "GS1", Object::Integer(3));
+        assert_eq!(page.extra_resources.len(), 2);
+        assert_eq!(page.extra_resources[1], (ResourceType::ExtGState, "GS1".to_string(), Object::Integer(3)));
+    }
+
+    #[test]
+    fn test_page_boxes() {
+        let trim = Rectangle::new(9.0, 9.0, 603.0, 783.0);
+        let mut page = PageBuilder::letter().trim_box(trim).bleed_box(Rectangle::letter()).build();
+        assert_eq!(page.page_box(PageBox::Trim), Some(trim));
+        assert_eq!(page.bleed_box, Some(Rectangle::letter()));
+        assert_eq!(page.crop_box, None);
+        page.set_page_box(PageBox::Trim, None);
+        assert_eq!(page.trim_box, None);
+    }
+
     #[test]
     fn test_page_builder_shortcuts() {
         let page = PageBuilder::a4().helvetica().build();
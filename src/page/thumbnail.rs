@@ -0,0 +1,66 @@
+//! Page thumbnails.
+
+use crate::object::{Object, PdfName, PdfStream};
+
+/// A small picture of a page that viewers show in their page navigation
+/// pane instead of rendering the page, written as the page's `/Thumb`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Thumbnail {
+    width: u32,
+    height: u32,
+    pixels: Vec<u8>,
+}
+
+impl Thumbnail {
+    /// Creates a thumbnail from rows of RGB triples, top row first.
+    ///
+    /// Returns `None` if either dimension is 0 or `pixels` does not hold
+    /// exactly `width * height` triples.
+    pub fn from_rgb(width: u32, height: u32, pixels: Vec<u8>) -> Option<Self> {
+        let expected = (width as usize).checked_mul(height as usize)?.checked_mul(3)?;
+        (width > 0 && height > 0 && pixels.len() == expected).then_some(Self { width, height, pixels })
+    }
+
+    /// Returns the width in pixels.
+    pub fn width(&self) -> u32 {
+        self.width
+    }
+
+    /// Returns the height in pixels.
+    pub fn height(&self) -> u32 {
+        self.height
+    }
+
+    /// Returns the rows of RGB triples, top row first.
+    pub fn pixels(&self) -> &[u8] {
+        &self.pixels
+    }
+
+    /// Builds the image stream the page's `/Thumb` refers to.
+    pub(crate) fn to_stream(&self) -> PdfStream {
+        let mut stream = PdfStream::new(self.pixels.clone());
+        let dict = &mut stream.dictionary;
+        dict.set("Width", Object::Integer(i64::from(self.width)));
+        dict.set("Height", Object::Integer(i64::from(self.height)));
+        dict.set("ColorSpace", Object::Name(PdfName::new_unchecked("DeviceRGB")));
+        dict.set("BitsPerComponent", Object::Integer(8));
+        stream
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_thumbnail_stream() {
+        assert!(Thumbnail::from_rgb(2, 2, vec![0; 11]).is_none());
+        assert!(Thumbnail::from_rgb(0, 2, Vec::new()).is_none());
+
+        let thumbnail = Thumbnail::from_rgb(2, 1, vec![255, 0, 0, 0, 0, 255]).unwrap();
+        let stream = thumbnail.to_stream();
+        assert_eq!(stream.dictionary.get("Width"), Some(&Object::Integer(2)));
+        assert_eq!(stream.dictionary.get("Height"), Some(&Object::Integer(1)));
+        assert_eq!(stream.data(), thumbnail.pixels());
+    }
+}
@@ -0,0 +1,257 @@
+//! ToUnicode CMaps, which map the character codes of a font to text.
+
+use std::collections::HashMap;
+
+/// The mappings of a ToUnicode CMap.
+#[derive(Debug, Default)]
+pub(crate) struct ToUnicode {
+    /// Byte lengths of the codes, with the ranges of first bytes for each.
+    codespaces: Vec<(usize, Vec<u8>, Vec<u8>)>,
+    map: HashMap<(usize, u32), String>,
+}
+
+impl ToUnicode {
+    /// Parses the `bfchar` and `bfrange` mappings of a CMap. Unreadable
+    /// entries are skipped.
+    pub(crate) fn parse(data: &[u8]) -> Self {
+        let tokens = tokens(data);
+        let mut cmap = Self::default();
+        let mut i = 0;
+        while i < tokens.len() {
+            match tokens[i] {
+                Token::Word(b"begincodespacerange") => {
+                    i += 1;
+                    while let [Token::Hex(low), Token::Hex(high), ..] = &tokens[i..] {
+                        if low.len() == high.len() && !low.is_empty() {
+                            cmap.codespaces.push((low.len(), low.clone(), high.clone()));
+                        }
+                        i += 2;
+                    }
+                }
+                Token::Word(b"beginbfchar") => {
+                    i += 1;
+                    while let [Token::Hex(code), Token::Hex(text), ..] = &tokens[i..] {
+                        cmap.insert(code, utf16(text));
+                        i += 2;
+                    }
+                }
+                Token::Word(b"beginbfrange") => {
+                    i += 1;
+                    loop {
+                        match &tokens[i..] {
+                            [Token::Hex(low), Token::Hex(high), Token::Hex(text), ..] => {
+                                cmap.insert_range(low, high, |offset| {
+                                    let mut text = text.clone();
+                                    add_to_last_unit(&mut text, offset);
+                                    Some(utf16(&text))
+                                });
+                                i += 3;
+                            }
+                            [Token::Hex(low), Token::Hex(high), Token::Open, ..] => {
+                                let end = tokens[i + 3..].iter().position(|t| *t == Token::Close);
+                                let end = end.map_or(tokens.len(), |end| i + 3 + end);
+                                let texts = &tokens[i + 3..end];
+                                cmap.insert_range(low, high, |offset| match texts.get(offset as usize) {
+                                    Some(Token::Hex(text)) => Some(utf16(text)),
+                                    _ => None,
+                                });
+                                i = end + 1;
+                            }
+                            _ => break,
+                        }
+                    }
+                }
+                _ => i += 1,
+            }
+        }
+        cmap
+    }
+
+    /// Returns the text of the character code `code`.
+    pub(crate) fn get(&self, code: &[u8]) -> Option<&str> {
+        self.map.get(&(code.len(), number(code))).map(String::as_str)
+    }
+
+    /// Splits `bytes` into character codes and returns the text of each, or
+    /// `None` for codes the CMap does not map.
+    pub(crate) fn decode<'a>(&'a self, bytes: &'a [u8]) -> impl Iterator<Item = Option<&'a str>> + 'a {
+        let mut rest = bytes;
+        std::iter::from_fn(move || {
+            if rest.is_empty() {
+                return None;
+            }
+            let length = self.code_length(rest).min(rest.len());
+            let (code, tail) = rest.split_at(length);
+            rest = tail;
+            Some(self.get(code))
+        })
+    }
+
+    /// Returns the length of the code `bytes` starts with: that of the
+    /// first codespace range it falls in, or two bytes without ranges.
+    fn code_length(&self, bytes: &[u8]) -> usize {
+        if self.codespaces.is_empty() {
+            return 2;
+        }
+        self.codespaces
+            .iter()
+            .find(|(length, low, high)| {
+                bytes.len() >= *length && (0..*length).all(|i| low[i] <= bytes[i] && bytes[i] <= high[i])
+            })
+            .or_else(|| self.codespaces.iter().min_by_key(|(length, _, _)| *length))
+            .map_or(1, |(length, _, _)| *length)
+    }
+
+    fn insert(&mut self, code: &[u8], text: String) {
+        if !code.is_empty() && code.len() <= 4 {
+            self.map.insert((code.len(), number(code)), text);
+        }
+    }
+
+    /// Maps the codes from `low` to `high` to `text(offset)`. Ranges of more
+    /// than 65536 codes are ignored.
+    fn insert_range(&mut self, low: &[u8], high: &[u8], text: impl Fn(u32) -> Option<String>) {
+        if low.len() != high.len() || low.is_empty() || low.len() > 4 {
+            return;
+        }
+        let (low_code, high_code) = (number(low), number(high));
+        if high_code < low_code || high_code - low_code > 0xFFFF {
+            return;
+        }
+        for offset in 0..=high_code - low_code {
+            if let Some(text) = text(offset) {
+                self.map.insert((low.len(), low_code + offset), text);
+            }
+        }
+    }
+}
+
+#[derive(Debug, PartialEq)]
+enum Token<'a> {
+    Hex(Vec<u8>),
+    Open,
+    Close,
+    Word(&'a [u8]),
+}
+
+/// Splits a CMap into hex strings, array brackets and other words, skipping
+/// dictionaries, literal strings and comments.
+fn tokens(data: &[u8]) -> Vec<Token<'_>> {
+    let mut tokens = Vec::new();
+    let mut i = 0;
+    while i < data.len() {
+        match data[i] {
+            b'<' if data.get(i + 1) == Some(&b'<') => i += 2,
+            b'>' => i += 1,
+            b'<' => {
+                let end = data[i..].iter().position(|&b| b == b'>').map_or(data.len(), |end| i + end);
+                let digits: Vec<u8> = data[i + 1..end].iter().copied().filter(u8::is_ascii_hexdigit).collect();
+                let bytes = digits
+                    .chunks(2)
+                    .map(|pair| {
+                        let hex = |b: u8| (b as char).to_digit(16).unwrap_or(0) as u8;
+                        (hex(pair[0]) << 4) | pair.get(1).map_or(0, |&b| hex(b))
+                    })
+                    .collect();
+                tokens.push(Token::Hex(bytes));
+                i = end + 1;
+            }
+            b'[' => {
+                tokens.push(Token::Open);
+                i += 1;
+            }
+            b']' => {
+                tokens.push(Token::Close);
+                i += 1;
+            }
+            b'(' => {
+                let mut depth = 0;
+                while i < data.len() {
+                    match data[i] {
+                        b'\\' => i += 1,
+                        b'(' => depth += 1,
+                        b')' => depth -= 1,
+                        _ => {}
+                    }
+                    i += 1;
+                    if depth == 0 {
+                        break;
+                    }
+                }
+            }
+            b'%' => {
+                while i < data.len() && data[i] != b'\n' && data[i] != b'\r' {
+                    i += 1;
+                }
+            }
+            b if b.is_ascii_whitespace() || b == b'\0' => i += 1,
+            _ => {
+                let start = i;
+                while i < data.len() && !b"<>[]()% \t\r\n\x0c\0/".contains(&data[i]) {
+                    i += 1;
+                }
+                if i == start {
+                    // A name's slash
+                    i += 1;
+                } else {
+                    tokens.push(Token::Word(&data[start..i]));
+                }
+            }
+        }
+    }
+    tokens
+}
+
+/// Reads big-endian `bytes` as a number.
+fn number(bytes: &[u8]) -> u32 {
+    bytes.iter().fold(0, |n, &b| (n << 8) | b as u32)
+}
+
+/// Decodes UTF-16BE text, as CMaps write the destination of a code.
+fn utf16(bytes: &[u8]) -> String {
+    let units: Vec<u16> = bytes.chunks(2).map(|pair| u16::from_be_bytes([pair[0], *pair.get(1).unwrap_or(&0)])).collect();
+    String::from_utf16_lossy(&units)
+}
+
+/// Adds `offset` to the last UTF-16 code unit of `text`, as a `bfrange`
+/// entry maps successive codes to successive characters.
+fn add_to_last_unit(text: &mut [u8], offset: u32) {
+    if text.len() >= 2 {
+        let at = text.len() - 2;
+        let unit = u16::from_be_bytes([text[at], text[at + 1]]).wrapping_add(offset as u16);
+        text[at..].copy_from_slice(&unit.to_be_bytes());
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn decoded(cmap: &ToUnicode, bytes: &[u8]) -> String {
+        cmap.decode(bytes).map(|text| text.unwrap_or("?")).collect()
+    }
+
+    #[test]
+    fn test_bfchar_and_bfrange() {
+        let cmap = ToUnicode::parse(
+            b"/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n\
+              /CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n\
+              1 begincodespacerange <0000> <FFFF> endcodespacerange\n\
+              2 beginbfchar <0003> <0020> <0024> <00660069> endbfchar\n\
+              2 beginbfrange <0010> <0012> <0041> <0020> <0021> [<00E9> <D83DDE00>] endbfrange\n\
+              endcmap CMapName currentdict /CMap defineresource pop end end",
+        );
+        assert_eq!(decoded(&cmap, &[0, 0x10, 0, 0x12, 0, 3, 0, 0x24]), "AC fi");
+        assert_eq!(decoded(&cmap, &[0, 0x20, 0, 0x21]), "é😀");
+        assert_eq!(decoded(&cmap, &[0, 0x99]), "?");
+    }
+
+    #[test]
+    fn test_mixed_code_lengths() {
+        let cmap = ToUnicode::parse(
+            b"2 begincodespacerange <00> <80> <8140> <9FFC> endcodespacerange\n\
+              1 beginbfchar <41> <0041> endbfchar 1 beginbfchar <8140> <3000> endbfchar",
+        );
+        assert_eq!(decoded(&cmap, &[0x41, 0x81, 0x40, 0x41]), "A\u{3000}A");
+    }
+}
@@ -0,0 +1,245 @@
+//! The encodings of simple fonts, which map one-byte codes to glyph names.
+
+/// A predefined encoding a simple font can be based on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum BaseEncoding {
+    /// The built-in encoding of Latin Type 1 fonts.
+    Standard,
+    /// Windows code page 1252.
+    WinAnsi,
+    /// The Mac OS Roman character set.
+    MacRoman,
+}
+
+impl BaseEncoding {
+    /// Returns the encoding a font's /Encoding or /BaseEncoding names.
+    pub(crate) fn from_name(name: &str) -> Option<Self> {
+        match name {
+            "StandardEncoding" => Some(Self::Standard),
+            "WinAnsiEncoding" => Some(Self::WinAnsi),
+            "MacRomanEncoding" => Some(Self::MacRoman),
+            _ => None,
+        }
+    }
+
+    fn char(self, code: u8) -> Option<char> {
+        match (self, code) {
+            (Self::Standard, b'\'') => Some('\u{2019}'),
+            (Self::Standard, b'`') => Some('\u{2018}'),
+            (_, 0x20..=0x7E) => Some(code as char),
+            (Self::Standard, _) => STANDARD_HIGH.iter().find(|(c, _)| *c == code).map(|(_, ch)| *ch),
+            (Self::WinAnsi, 0x80..=0x9F) => WIN_ANSI_HIGH[code as usize - 0x80],
+            (Self::WinAnsi, 0xA0..=0xFF) => Some(code as char),
+            (Self::MacRoman, 0x80..=0xFF) => MAC_ROMAN_HIGH.chars().nth(code as usize - 0x80),
+            _ => None,
+        }
+    }
+}
+
+/// The characters of the codes of a simple font.
+#[derive(Debug, Clone)]
+pub(crate) struct SimpleEncoding {
+    chars: [Option<char>; 256],
+}
+
+impl SimpleEncoding {
+    /// Creates the encoding `base` defines.
+    pub(crate) fn new(base: BaseEncoding) -> Self {
+        let mut chars = [None; 256];
+        for (code, ch) in chars.iter_mut().enumerate() {
+            *ch = base.char(code as u8);
+        }
+        Self { chars }
+    }
+
+    /// Maps `code` to the glyph `name`, as a /Differences array does.
+    /// Unknown names unmap the code.
+    pub(crate) fn set(&mut self, code: u8, name: &str) {
+        self.chars[code as usize] = glyph_char(name);
+    }
+
+    /// Returns the character of `code`.
+    pub(crate) fn char(&self, code: u8) -> Option<char> {
+        self.chars[code as usize]
+    }
+}
+
+/// Returns the character of a glyph name from the Adobe Glyph List, as far
+/// as Latin text needs, or of a `uniXXXX` or `uXXXX[XX]` name.
+pub(crate) fn glyph_char(name: &str) -> Option<char> {
+    // Suffixes such as .sc name variants of the same character
+    let name = name.split('.').next().unwrap_or(name);
+    if name.is_empty() {
+        return None;
+    }
+    if name.len() == 1 && name.as_bytes()[0].is_ascii_alphabetic() {
+        return name.chars().next();
+    }
+    if let Some(i) = ASCII_NAMES.iter().position(|n| *n == name) {
+        return Some((0x20 + i as u8) as char);
+    }
+    if let Some(i) = LATIN1_NAMES.iter().position(|n| *n == name) {
+        return char::from_u32(0xA1 + i as u32);
+    }
+    if let Some((_, ch)) = OTHER_NAMES.iter().find(|(n, _)| *n == name) {
+        return Some(*ch);
+    }
+    let hex = match (name.strip_prefix("uni"), name.strip_prefix('u')) {
+        (Some(hex), _) if hex.len() >= 4 => &hex[..4],
+        (None, Some(hex)) if (4..=6).contains(&hex.len()) => hex,
+        _ => return None,
+    };
+    u32::from_str_radix(hex, 16).ok().and_then(char::from_u32)
+}
+
+/// Names of the printable ASCII characters from the space on, with letters
+/// left empty since they are named by themselves.
+const ASCII_NAMES: [&str; 95] = [
+    "space", "exclam", "quotedbl", "numbersign", "dollar", "percent", "ampersand", "quotesingle",
+    "parenleft", "parenright", "asterisk", "plus", "comma", "hyphen", "period", "slash", "zero", "one",
+    "two", "three", "four", "five", "six", "seven", "eight", "nine", "colon", "semicolon", "less",
+    "equal", "greater", "question", "at", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "",
+    "", "", "", "", "", "", "", "", "", "", "bracketleft", "backslash", "bracketright", "asciicircum",
+    "underscore", "grave", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "",
+    "", "", "", "", "", "", "", "braceleft", "bar", "braceright", "asciitilde",
+];
+
+/// Names of the Latin-1 characters from U+00A1 on.
+const LATIN1_NAMES: [&str; 95] = [
+    "exclamdown", "cent", "sterling", "currency", "yen", "brokenbar", "section", "dieresis",
+    "copyright", "ordfeminine", "guillemotleft", "logicalnot", "", "registered", "macron", "degree",
+    "plusminus", "twosuperior", "threesuperior", "acute", "mu", "paragraph", "periodcentered",
+    "cedilla", "onesuperior", "ordmasculine", "guillemotright", "onequarter", "onehalf",
+    "threequarters", "questiondown", "Agrave", "Aacute", "Acircumflex", "Atilde", "Adieresis",
+    "Aring", "AE", "Ccedilla", "Egrave", "Eacute", "Ecircumflex", "Edieresis", "Igrave", "Iacute",
+    "Icircumflex", "Idieresis", "Eth", "Ntilde", "Ograve", "Oacute", "Ocircumflex", "Otilde",
+    "Odieresis", "multiply", "Oslash", "Ugrave", "Uacute", "Ucircumflex", "Udieresis", "Yacute",
+    "Thorn", "germandbls", "agrave", "aacute", "acircumflex", "atilde", "adieresis", "aring", "ae",
+    "ccedilla", "egrave", "eacute", "ecircumflex", "edieresis", "igrave", "iacute", "icircumflex",
+    "idieresis", "eth", "ntilde", "ograve", "oacute", "ocircumflex", "otilde", "odieresis", "divide",
+    "oslash", "ugrave", "uacute", "ucircumflex", "udieresis", "yacute", "thorn", "ydieresis",
+];
+
+/// Names of the other characters the base encodings hold.
+const OTHER_NAMES: &[(&str, char)] = &[
+    ("Euro", '\u{20AC}'),
+    ("quotesinglbase", '\u{201A}'),
+    ("florin", '\u{0192}'),
+    ("quotedblbase", '\u{201E}'),
+    ("ellipsis", '\u{2026}'),
+    ("dagger", '\u{2020}'),
+    ("daggerdbl", '\u{2021}'),
+    ("circumflex", '\u{02C6}'),
+    ("perthousand", '\u{2030}'),
+    ("Scaron", '\u{0160}'),
+    ("guilsinglleft", '\u{2039}'),
+    ("OE", '\u{0152}'),
+    ("Zcaron", '\u{017D}'),
+    ("quoteleft", '\u{2018}'),
+    ("quoteright", '\u{2019}'),
+    ("quotedblleft", '\u{201C}'),
+    ("quotedblright", '\u{201D}'),
+    ("bullet", '\u{2022}'),
+    ("endash", '\u{2013}'),
+    ("emdash", '\u{2014}'),
+    ("tilde", '\u{02DC}'),
+    ("trademark", '\u{2122}'),
+    ("scaron", '\u{0161}'),
+    ("guilsinglright", '\u{203A}'),
+    ("oe", '\u{0153}'),
+    ("zcaron", '\u{017E}'),
+    ("Ydieresis", '\u{0178}'),
+    ("fraction", '\u{2044}'),
+    ("fi", '\u{FB01}'),
+    ("fl", '\u{FB02}'),
+    ("dotlessi", '\u{0131}'),
+    ("Lslash", '\u{0141}'),
+    ("lslash", '\u{0142}'),
+    ("breve", '\u{02D8}'),
+    ("dotaccent", '\u{02D9}'),
+    ("ring", '\u{02DA}'),
+    ("hungarumlaut", '\u{02DD}'),
+    ("ogonek", '\u{02DB}'),
+    ("caron", '\u{02C7}'),
+    ("minus", '\u{2212}'),
+    ("notequal", '\u{2260}'),
+    ("infinity", '\u{221E}'),
+    ("lessequal", '\u{2264}'),
+    ("greaterequal", '\u{2265}'),
+    ("partialdiff", '\u{2202}'),
+    ("summation", '\u{2211}'),
+    ("product", '\u{220F}'),
+    ("pi", '\u{03C0}'),
+    ("integral", '\u{222B}'),
+    ("Omega", '\u{2126}'),
+    ("radical", '\u{221A}'),
+    ("approxequal", '\u{2248}'),
+    ("Delta", '\u{2206}'),
+    ("lozenge", '\u{25CA}'),
+    ("nbspace", '\u{00A0}'),
+    ("sfthyphen", '\u{00AD}'),
+];
+
+/// StandardEncoding above 0x7E.
+const STANDARD_HIGH: &[(u8, char)] = &[
+    (0xA1, '¡'), (0xA2, '¢'), (0xA3, '£'), (0xA4, '\u{2044}'), (0xA5, '¥'), (0xA6, 'ƒ'),
+    (0xA7, '§'), (0xA8, '¤'), (0xA9, '\''), (0xAA, '“'), (0xAB, '«'), (0xAC, '‹'), (0xAD, '›'),
+    (0xAE, '\u{FB01}'), (0xAF, '\u{FB02}'), (0xB1, '–'), (0xB2, '†'), (0xB3, '‡'), (0xB4, '·'),
+    (0xB6, '¶'), (0xB7, '•'), (0xB8, '‚'), (0xB9, '„'), (0xBA, '”'), (0xBB, '»'), (0xBC, '…'),
+    (0xBD, '‰'), (0xBF, '¿'), (0xC1, '`'), (0xC2, '´'), (0xC3, 'ˆ'), (0xC4, '˜'), (0xC5, '¯'),
+    (0xC6, '˘'), (0xC7, '˙'), (0xC8, '¨'), (0xCA, '˚'), (0xCB, '¸'), (0xCD, '˝'), (0xCE, '˛'),
+    (0xCF, 'ˇ'), (0xD0, '—'), (0xE1, 'Æ'), (0xE3, 'ª'), (0xE8, 'Ł'), (0xE9, 'Ø'), (0xEA, 'Œ'),
+    (0xEB, 'º'), (0xF1, 'æ'), (0xF5, 'ı'), (0xF8, 'ł'), (0xF9, 'ø'), (0xFA, 'œ'), (0xFB, 'ß'),
+];
+
+/// WinAnsiEncoding from 0x80 to 0x9F, where it differs from Latin-1.
+const WIN_ANSI_HIGH: [Option<char>; 32] = [
+    Some('€'), None, Some('‚'), Some('ƒ'), Some('„'), Some('…'), Some('†'), Some('‡'),
+    Some('ˆ'), Some('‰'), Some('Š'), Some('‹'), Some('Œ'), None, Some('Ž'), None,
+    None, Some('‘'), Some('’'), Some('“'), Some('”'), Some('•'), Some('–'), Some('—'),
+    Some('˜'), Some('™'), Some('š'), Some('›'), Some('œ'), None, Some('ž'), Some('Ÿ'),
+];
+
+/// MacRomanEncoding from 0x80 on.
+const MAC_ROMAN_HIGH: &str = "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø\
+    ¿¡¬√ƒ≈∆«»…\u{A0}ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄¤‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ\u{F8FF}ÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ";
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_base_encodings() {
+        let win = SimpleEncoding::new(BaseEncoding::WinAnsi);
+        assert_eq!(win.char(0xE9), Some('é'));
+        assert_eq!(win.char(0x80), Some('€'));
+        assert_eq!(win.char(0x81), None);
+        let mac = SimpleEncoding::new(BaseEncoding::MacRoman);
+        assert_eq!(MAC_ROMAN_HIGH.chars().count(), 128);
+        assert_eq!(mac.char(0x8E), Some('é'));
+        assert_eq!(mac.char(0xFF), Some('ˇ'));
+        let standard = SimpleEncoding::new(BaseEncoding::Standard);
+        assert_eq!(standard.char(b'\''), Some('’'));
+        assert_eq!(standard.char(0xFB), Some('ß'));
+    }
+
+    #[test]
+    fn test_differences() {
+        let mut encoding = SimpleEncoding::new(BaseEncoding::Standard);
+        encoding.set(1, "eacute");
+        encoding.set(2, "uni20AC");
+        encoding.set(3, "A.sc");
+        encoding.set(b'a', "notaglyph");
+        assert_eq!(encoding.char(1), Some('é'));
+        assert_eq!(encoding.char(2), Some('€'));
+        assert_eq!(encoding.char(3), Some('A'));
+        assert_eq!(encoding.char(b'a'), None);
+        assert_eq!(glyph_char("quotesingle"), Some('\''));
+        assert_eq!(glyph_char("bracketleft"), Some('['));
+        assert_eq!(glyph_char("grave"), Some('`'));
+        assert_eq!(glyph_char("asciitilde"), Some('~'));
+        assert_eq!(glyph_char("registered"), Some('®'));
+        assert_eq!(glyph_char("ydieresis"), Some('ÿ'));
+        assert_eq!(glyph_char("u1F600"), Some('😀'));
+    }
+}
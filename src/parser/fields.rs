@@ -0,0 +1,263 @@
+//! Converting the form fields of a parsed page into editable fields.
+
+use super::import::rectangle;
+use super::PdfReader;
+use crate::color::Color;
+use crate::forms::{FieldFlags, FormField, FormFieldType};
+use crate::object::{Object, PdfDictionary};
+use crate::types::{Matrix, Rectangle};
+
+/// Field attributes a widget inherits from its ancestors in the field tree.
+const INHERITED_FIELD_KEYS: [&str; 5] = ["FT", "Ff", "V", "DV", "DA"];
+
+/// Deepest field hierarchy followed, to stop at cyclic /Parent chains.
+const MAX_FIELD_DEPTH: usize = 32;
+
+impl PdfReader {
+    /// Returns the text fields, checkboxes, combo boxes and list boxes among
+    /// a page's widget annotations, with rectangles mapped through `matrix`
+    /// if the page is placed rotated. Other field types are skipped.
+    pub(crate) fn page_fields(&self, page: &PdfDictionary, matrix: Option<&Matrix>) -> Vec<FormField> {
+        let annots = match page.get("Annots").and_then(|a| self.resolve(a)) {
+            Some(Object::Array(annots)) => annots,
+            _ => return Vec::new(),
+        };
+
+        annots
+            .iter()
+            .filter_map(|annot| match self.resolve(annot) {
+                Some(Object::Dictionary(widget)) => self.widget_field(&widget, matrix),
+                _ => None,
+            })
+            .collect()
+    }
+
+    /// Converts a widget annotation to a field, merging in the attributes of
+    /// its parent fields.
+    fn widget_field(&self, widget: &PdfDictionary, matrix: Option<&Matrix>) -> Option<FormField> {
+        match widget.get("Subtype") {
+            Some(Object::Name(name)) if name.as_str() == "Widget" => {}
+            _ => return None,
+        }
+
+        let mut attributes = widget.clone();
+        let mut names = Vec::new();
+        let mut node = widget.clone();
+        for _ in 0..MAX_FIELD_DEPTH {
+            if let Some(Object::String(name)) = node.get("T") {
+                names.push(name.to_string_lossy());
+            }
+            node = match node.get("Parent").and_then(|p| self.resolve(p)) {
+                Some(Object::Dictionary(parent)) => parent,
+                _ => break,
+            };
+            for key in INHERITED_FIELD_KEYS {
+                if !attributes.contains_key(key) {
+                    if let Some(value) = node.get(key) {
+                        attributes.set(key, value.clone());
+                    }
+                }
+            }
+        }
+        if names.is_empty() {
+            return None;
+        }
+        names.reverse();
+
+        let mut rect = attributes.get("Rect").and_then(|r| self.resolve(r)).and_then(|r| rectangle(&r))?;
+        if let Some(matrix) = matrix {
+            rect = transform_rect(&rect, matrix);
+        }
+        let flags = FieldFlags::from_bits_truncate(self.number(&attributes, "Ff").unwrap_or(0.0) as u32);
+
+        let mut field = FormField {
+            name: names.join("."),
+            rect,
+            flags,
+            ..Default::default()
+        };
+        match attributes.get("FT") {
+            Some(Object::Name(name)) if name.as_str() == "Tx" => {
+                field.field_type = FormFieldType::Text;
+                field.value = self.text(&attributes, "V");
+                field.default_value = self.text(&attributes, "DV");
+                field.max_length = self.number(&attributes, "MaxLen").map(|n| n as u32);
+            }
+            Some(Object::Name(name))
+                if name.as_str() == "Btn" && !flags.intersects(FieldFlags::RADIO | FieldFlags::PUSH_BUTTON) =>
+            {
+                field.field_type = FormFieldType::CheckBox;
+                let state = ["V", "AS"].iter().find_map(|key| match attributes.get(key) {
+                    Some(Object::Name(state)) => Some(state.as_str().to_string()),
+                    _ => None,
+                });
+                field.checked = state.map_or(false, |state| state != "Off");
+                field.export_value = self.on_state(&attributes);
+            }
+            Some(Object::Name(name)) if name.as_str() == "Ch" => {
+                field.field_type = if flags.contains(FieldFlags::COMBO) {
+                    FormFieldType::ComboBox
+                } else {
+                    FormFieldType::ListBox
+                };
+                let (exports, shown) = self.choice_options(&attributes);
+                let selected: Vec<String> = match attributes.get("V").and_then(|v| self.resolve(v)) {
+                    Some(Object::String(value)) => vec![value.to_string_lossy()],
+                    Some(Object::Array(values)) => values
+                        .iter()
+                        .filter_map(|v| match self.resolve(v) {
+                            Some(Object::String(value)) => Some(value.to_string_lossy()),
+                            _ => None,
+                        })
+                        .collect(),
+                    _ => Vec::new(),
+                };
+                field.selected_indices = (0..shown.len()).filter(|&i| selected.contains(&exports[i])).collect();
+                field.options = shown;
+            }
+            _ => return None,
+        }
+
+        if let Some(Object::String(da)) = attributes.get("DA").and_then(|d| self.resolve(d)) {
+            let (size, color) = parse_default_appearance(&da.to_string_lossy());
+            // A size of zero asks the viewer to fit the text, so keep the default.
+            if let Some(size) = size.filter(|&s| s > 0.0) {
+                field.font_size = size;
+            }
+            if let Some(color) = color {
+                field.text_color = color;
+            }
+        }
+        let mk = match attributes.get("MK").and_then(|m| self.resolve(m)) {
+            Some(Object::Dictionary(mk)) => mk,
+            _ => PdfDictionary::new(),
+        };
+        field.background_color = mk.get("BG").and_then(|c| self.resolve(c)).and_then(|c| color(&c));
+        field.border_color = mk.get("BC").and_then(|c| self.resolve(c)).and_then(|c| color(&c));
+        field.border_width = match attributes.get("BS").and_then(|b| self.resolve(b)) {
+            Some(Object::Dictionary(bs)) => self.number(&bs, "W").unwrap_or(1.0),
+            _ => 1.0,
+        };
+        Some(field)
+    }
+
+    /// Returns the name of a checkbox's on state from its normal appearances.
+    fn on_state(&self, widget: &PdfDictionary) -> Option<String> {
+        let ap = match widget.get("AP").and_then(|a| self.resolve(a)) {
+            Some(Object::Dictionary(ap)) => ap,
+            _ => return None,
+        };
+        match ap.get("N").and_then(|n| self.resolve(n)) {
+            Some(Object::Dictionary(states)) => states.iter().map(|(key, _)| key).find(|key| *key != "Off").cloned(),
+            _ => None,
+        }
+    }
+
+    /// Returns the export values and the shown text of a choice field's
+    /// options, which differ for options given as `[export shown]` pairs.
+    fn choice_options(&self, field: &PdfDictionary) -> (Vec<String>, Vec<String>) {
+        let options = match field.get("Opt").and_then(|o| self.resolve(o)) {
+            Some(Object::Array(options)) => options,
+            _ => return (Vec::new(), Vec::new()),
+        };
+        let text = |object: &Object| match self.resolve(object) {
+            Some(Object::String(s)) => Some(s.to_string_lossy()),
+            _ => None,
+        };
+        options
+            .iter()
+            .filter_map(|option| match self.resolve(option) {
+                Some(Object::String(s)) => Some((s.to_string_lossy(), s.to_string_lossy())),
+                Some(Object::Array(pair)) if pair.len() == 2 => {
+                    Some((text(pair.get(0)?)?, text(pair.get(1)?)?))
+                }
+                _ => None,
+            })
+            .unzip()
+    }
+
+    fn text(&self, dict: &PdfDictionary, key: &str) -> Option<String> {
+        match dict.get(key).and_then(|v| self.resolve(v)) {
+            Some(Object::String(s)) => Some(s.to_string_lossy()),
+            _ => None,
+        }
+    }
+
+    fn number(&self, dict: &PdfDictionary, key: &str) -> Option<f64> {
+        dict.get(key).and_then(|v| self.resolve(v)).and_then(|v| v.as_real())
+    }
+}
+
+/// Returns the bounding box of a rectangle transformed by `matrix`.
+fn transform_rect(rect: &Rectangle, matrix: &Matrix) -> Rectangle {
+    let corners = [
+        matrix.transform_point(rect.llx, rect.lly),
+        matrix.transform_point(rect.urx, rect.lly),
+        matrix.transform_point(rect.llx, rect.ury),
+        matrix.transform_point(rect.urx, rect.ury),
+    ];
+    let xs = corners.iter().map(|c| c.0);
+    let ys = corners.iter().map(|c| c.1);
+    Rectangle::new(
+        xs.clone().fold(f64::INFINITY, f64::min),
+        ys.clone().fold(f64::INFINITY, f64::min),
+        xs.fold(f64::NEG_INFINITY, f64::max),
+        ys.fold(f64::NEG_INFINITY, f64::max),
+    )
+}
+
+/// Returns a color from an /MK color array, or `None` for an empty
+/// (transparent) one.
+fn color(object: &Object) -> Option<Color> {
+    let values: Vec<f64> = object.as_array()?.iter().map(|v| v.as_real()).collect::<Option<_>>()?;
+    match values.as_slice() {
+        [g] => Some(Color::gray(*g)),
+        [r, g, b] => Some(Color::rgb(*r, *g, *b)),
+        [c, m, y, k] => Some(Color::cmyk(*c, *m, *y, *k)),
+        _ => None,
+    }
+}
+
+/// Returns the font size and fill color set by a default appearance string
+/// such as `/Helv 12 Tf 0 g`.
+fn parse_default_appearance(da: &str) -> (Option<f64>, Option<Color>) {
+    let tokens: Vec<&str> = da.split_whitespace().collect();
+    let operands = |i: usize, count: usize| -> Option<Vec<f64>> {
+        let start = i.checked_sub(count)?;
+        tokens[start..i].iter().map(|t| t.parse().ok()).collect()
+    };
+
+    let mut size = None;
+    let mut color = None;
+    for (i, token) in tokens.iter().enumerate() {
+        match *token {
+            "Tf" => size = operands(i, 1).map(|v| v[0]),
+            "g" => color = operands(i, 1).map(|v| Color::gray(v[0])),
+            "rg" => color = operands(i, 3).map(|v| Color::rgb(v[0], v[1], v[2])),
+            "k" => color = operands(i, 4).map(|v| Color::cmyk(v[0], v[1], v[2], v[3])),
+            _ => {}
+        }
+    }
+    (size, color)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_default_appearance() {
+        let (size, color) = parse_default_appearance("/Helv 9 Tf 0 0 1 rg");
+        assert_eq!(size, Some(9.0));
+        assert_eq!(color, Some(Color::rgb(0.0, 0.0, 1.0)));
+        assert_eq!(parse_default_appearance("/Helv 0 Tf"), (Some(0.0), None));
+        assert_eq!(parse_default_appearance("Tf g"), (None, None));
+    }
+
+    #[test]
+    fn test_transform_rect() {
+        let rect = Rectangle::new(10.0, 20.0, 110.0, 40.0);
+        let rotated = transform_rect(&rect, &Matrix::new(0.0, -1.0, 1.0, 0.0, 0.0, 400.0));
+        assert_eq!(rotated, Rectangle::new(20.0, 290.0, 40.0, 390.0));
+    }
+}
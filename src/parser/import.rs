@@ -0,0 +1,588 @@
+//! Copying pages out of a parsed document.
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::sync::Arc;
+
+use super::PdfReader;
+use crate::content::ContentBuilder;
+use crate::error::{DocumentError, ParserError, PdfError, PdfResult};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream};
+use crate::page::{ImportedObjects, ImportedPage, Page};
+use crate::types::{Matrix, ObjectId, Rectangle};
+
+/// Resource name of the form XObject holding an imported page.
+pub(crate) const IMPORTED_PAGE_NAME: &str = "ImportedPage";
+
+/// Page attributes a page inherits from its ancestors in the page tree.
+const INHERITED_KEYS: [&str; 4] = ["Resources", "MediaBox", "CropBox", "Rotate"];
+
+impl PdfReader {
+    /// Copies every page of the document, in order.
+    ///
+    /// Each page becomes a form XObject painted on an otherwise empty page of
+    /// the same visible size, so more content can be drawn on top. Objects
+    /// shared between pages, such as fonts and images, are copied once.
+    /// Text fields and checkboxes become form fields of the new page, so they
+    /// can be filled and get fresh appearances when written; other annotations
+    /// and fields, and the outline, are not copied.
+    pub fn import_pages(&self) -> PdfResult<Vec<Page>> {
+        self.import_pages_with_progress(|_| true)
+    }
+
+    /// Imports every page like [`import_pages`](Self::import_pages), calling
+    /// `progress` with the fraction of pages copied before each page. If
+    /// `progress` returns false the import stops with [`PdfError::Cancelled`].
+    pub fn import_pages_with_progress(&self, mut progress: impl FnMut(f64) -> bool) -> PdfResult<Vec<Page>> {
+        let leaves: Vec<PdfDictionary> = self.leaf_pages()?.into_iter().map(|(_, leaf)| leaf).collect();
+        let forms = self.import_forms(&leaves, &mut progress)?;
+        let pages = forms
+            .into_iter()
+            .zip(&leaves)
+            .map(|(form, leaf)| {
+                let mut page = Page::new(form.media_box());
+                page.form_fields = self.page_fields(leaf, form.upright().as_ref());
+                let mut content = ContentBuilder::new().save_state();
+                if let Some(matrix) = form.upright() {
+                    content = content.transform(matrix);
+                }
+                page.content = content.paint_xobject(IMPORTED_PAGE_NAME).restore_state();
+                page.add_imported_page(IMPORTED_PAGE_NAME, form);
+                page
+            })
+            .collect();
+        Ok(pages)
+    }
+
+    /// Copies page `index` into a form XObject, to be drawn on any number
+    /// of pages. Every page drawing it shares one copy of its resources.
+    ///
+    /// Returns an error if there is no such page.
+    pub fn import_page(&self, index: usize) -> PdfResult<ImportedPage> {
+        let mut leaves = self.leaf_pages()?;
+        if index >= leaves.len() {
+            return Err(DocumentError::PageOutOfRange { index, count: leaves.len() }.into());
+        }
+        let leaf = leaves.swap_remove(index).1;
+        Ok(self.import_forms(std::slice::from_ref(&leaf), &mut |_| true)?.remove(0))
+    }
+
+    /// Copies every page of the document onto sheets of `rows` by `cols`
+    /// cells, filled left to right and top to bottom, as for printing
+    /// several pages per sheet.
+    ///
+    /// Each sheet has the visible size of the first page on it. Cells are
+    /// `gutter` points apart and from the edges of the sheet, and each page
+    /// is centered in its cell, shrunk in proportion if it does not fit.
+    /// Objects are shared as in [`import_pages`](Self::import_pages), but
+    /// form fields are not copied.
+    ///
+    /// Returns an error if `rows` or `cols` is 0, or if the gutters leave no
+    /// room for the cells.
+    pub fn import_nup(&self, rows: usize, cols: usize, gutter: f64) -> PdfResult<Vec<Page>> {
+        if rows == 0 || cols == 0 {
+            return Err(DocumentError::InvalidNup(format!("{} by {} cells", rows, cols)).into());
+        }
+        if !(gutter >= 0.0 && gutter.is_finite()) {
+            return Err(DocumentError::InvalidNup(format!("gutter {} is negative", gutter)).into());
+        }
+        let leaves: Vec<PdfDictionary> = self.leaf_pages()?.into_iter().map(|(_, leaf)| leaf).collect();
+        let forms = self.import_forms(&leaves, &mut |_| true)?;
+
+        let mut sheets = Vec::new();
+        for group in forms.chunks(rows * cols) {
+            let first = group[0].media_box();
+            let sheet_box = Rectangle::from_dimensions(first.width(), first.height());
+            let cell_width = (sheet_box.width() - gutter * (cols + 1) as f64) / cols as f64;
+            let cell_height = (sheet_box.height() - gutter * (rows + 1) as f64) / rows as f64;
+            if cell_width <= 0.0 || cell_height <= 0.0 {
+                let message = format!(
+                    "gutters of {} leave no room on a {} by {} sheet",
+                    gutter,
+                    sheet_box.width(),
+                    sheet_box.height()
+                );
+                return Err(DocumentError::InvalidNup(message).into());
+            }
+
+            let mut sheet = Page::new(sheet_box);
+            let mut content = ContentBuilder::new();
+            for (i, form) in group.iter().enumerate() {
+                let name = format!("{}{}", IMPORTED_PAGE_NAME, i + 1);
+                let (width, height) = (form.media_box().width(), form.media_box().height());
+                let scale = (cell_width / width).min(cell_height / height).min(1.0);
+                let (row, col) = (i / cols, i % cols);
+                let x = gutter + col as f64 * (cell_width + gutter) + (cell_width - width * scale) / 2.0;
+                let y = sheet_box.height() - (row + 1) as f64 * (cell_height + gutter)
+                    + (cell_height - height * scale) / 2.0;
+                content = content
+                    .save_state()
+                    .transform(form.placement(x, y, scale))
+                    .paint_xobject(name.clone())
+                    .restore_state();
+                sheet.add_imported_page(name, form.clone());
+            }
+            sheet.content = content;
+            sheets.push(sheet);
+        }
+        Ok(sheets)
+    }
+
+    /// Copies the pages `leaves` into form XObjects sharing one set of
+    /// objects, in order.
+    fn import_forms(
+        &self,
+        leaves: &[PdfDictionary],
+        progress: &mut dyn FnMut(f64) -> bool,
+    ) -> PdfResult<Vec<ImportedPage>> {
+        let mut copier = ObjectCopier::new(self);
+        let mut forms = Vec::with_capacity(leaves.len());
+        for (i, leaf) in leaves.iter().enumerate() {
+            if !progress(i as f64 / leaves.len() as f64) {
+                return Err(PdfError::Cancelled);
+            }
+            forms.push(self.page_form(leaf, &mut copier)?);
+        }
+
+        let objects = Arc::new(ImportedObjects::new(copier.objects, copier.missing));
+        let forms = forms
+            .into_iter()
+            .map(|(form, bbox, rotate)| {
+                let (media_box, upright) = placement(&bbox, rotate);
+                ImportedPage::new(objects.clone(), form, media_box, upright)
+            })
+            .collect();
+        Ok(forms)
+    }
+
+    /// Returns the references and dictionaries of the pages, in order, with
+    /// inherited attributes copied into each page dictionary.
+    pub(crate) fn leaf_pages(&self) -> PdfResult<Vec<(ObjectId, PdfDictionary)>> {
+        let catalog = self
+            .catalog()
+            .ok_or_else(|| ParserError::InvalidPageTree("missing catalog".to_string()))?;
+        let root = match catalog.get("Pages") {
+            Some(Object::Reference(id)) => *id,
+            _ => return Err(ParserError::InvalidPageTree("missing /Pages".to_string()).into()),
+        };
+
+        let mut leaves = Vec::new();
+        self.collect_pages(root, &PdfDictionary::new(), &mut HashSet::new(), &mut leaves)?;
+        Ok(leaves)
+    }
+
+    /// Appends the leaf pages under `id` to `pages` with their references,
+    /// with inherited attributes copied into each page dictionary.
+    fn collect_pages(
+        &self,
+        id: ObjectId,
+        inherited: &PdfDictionary,
+        visited: &mut HashSet<u32>,
+        pages: &mut Vec<(ObjectId, PdfDictionary)>,
+    ) -> Result<(), ParserError> {
+        if !visited.insert(id.number) {
+            let message = format!("object {} appears twice", id.number);
+            return Err(ParserError::InvalidPageTree(message));
+        }
+        let mut node = match self.resolve_reference(id) {
+            Some(Object::Dictionary(dict)) => dict,
+            _ => return Err(ParserError::ObjectNotFound(id.number, id.generation)),
+        };
+
+        match node.get("Kids").cloned() {
+            Some(kids) => {
+                let kids = match self.resolve(&kids) {
+                    Some(Object::Array(kids)) => kids,
+                    _ => {
+                        let message = format!("/Kids of object {} is not an array", id.number);
+                        return Err(ParserError::InvalidPageTree(message));
+                    }
+                };
+                let mut inherited = inherited.clone();
+                for key in INHERITED_KEYS {
+                    if let Some(value) = node.get(key) {
+                        inherited.set(key, value.clone());
+                    }
+                }
+                for kid in kids.iter() {
+                    match kid {
+                        Object::Reference(kid) => self.collect_pages(*kid, &inherited, visited, pages)?,
+                        _ => {
+                            let message = format!("/Kids of object {} holds a direct object", id.number);
+                            return Err(ParserError::InvalidPageTree(message));
+                        }
+                    }
+                }
+            }
+            None => {
+                for (key, value) in inherited.iter() {
+                    if !node.contains_key(key) {
+                        node.set(key.clone(), value.clone());
+                    }
+                }
+                pages.push((id, node));
+            }
+        }
+        Ok(())
+    }
+
+    /// Copies a page's content and resources into a form XObject.
+    ///
+    /// Returns the form's index in the copier, its bounding box and the
+    /// page's rotation in degrees.
+    fn page_form(
+        &self,
+        page: &PdfDictionary,
+        copier: &mut ObjectCopier<'_>,
+    ) -> PdfResult<(usize, Rectangle, i64)> {
+        let bbox = ["CropBox", "MediaBox"]
+            .iter()
+            .find_map(|key| page.get(key).and_then(|b| self.resolve(b)).and_then(|b| rectangle(&b)))
+            .unwrap_or_else(Rectangle::letter);
+        let rotate = match page.get("Rotate").and_then(|r| self.resolve(r)) {
+            Some(Object::Integer(degrees)) => degrees.rem_euclid(360),
+            _ => 0,
+        };
+
+        let streams = match page.get("Contents").and_then(|c| self.resolve(c)) {
+            Some(Object::Stream(stream)) => vec![stream],
+            Some(Object::Array(parts)) => parts
+                .iter()
+                .filter_map(|part| match self.resolve(part) {
+                    Some(Object::Stream(stream)) => Some(stream),
+                    _ => None,
+                })
+                .collect(),
+            _ => Vec::new(),
+        };
+
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("XObject")));
+        dict.set("Subtype", Object::Name(PdfName::new_unchecked("Form")));
+        let mut bbox_array = PdfArray::new();
+        for value in bbox.to_array() {
+            bbox_array.push(Object::Real(value));
+        }
+        dict.set("BBox", Object::Array(bbox_array));
+        let resources = match page.get("Resources") {
+            Some(resources) => copier.copy(resources),
+            None => Object::Dictionary(PdfDictionary::new()),
+        };
+        dict.set("Resources", resources);
+
+        // A single stream keeps its encoding; several are decoded and joined
+        let data = if let [stream] = streams.as_slice() {
+            for key in ["Filter", "DecodeParms"] {
+                if let Some(value) = stream.dictionary.get(key) {
+                    dict.set(key, copier.copy(value));
+                }
+            }
+            stream.data.clone()
+        } else {
+            let mut data = Vec::new();
+            for stream in &streams {
+                data.extend_from_slice(&decode_content(stream)?);
+                data.push(b'\n');
+            }
+            data
+        };
+
+        let form = copier.push(Object::Stream(PdfStream::with_dictionary(dict, data)));
+        Ok((form, bbox, rotate))
+    }
+
+    /// Resolves `object` if it is a reference.
+    pub(super) fn resolve(&self, object: &Object) -> Option<Object> {
+        match object {
+            Object::Reference(id) => self.resolve_reference(*id),
+            other => Some(other.clone()),
+        }
+    }
+}
+
+/// Copies objects out of a reader, renumbering references as indices into
+/// `objects`. Each source object is copied once, however often it is reached.
+struct ObjectCopier<'a> {
+    reader: &'a PdfReader,
+    numbers: HashMap<u32, usize>,
+    objects: Vec<Object>,
+    missing: BTreeMap<usize, ObjectId>,
+}
+
+impl<'a> ObjectCopier<'a> {
+    fn new(reader: &'a PdfReader) -> Self {
+        Self {
+            reader,
+            numbers: HashMap::new(),
+            objects: Vec::new(),
+            missing: BTreeMap::new(),
+        }
+    }
+
+    /// Adds an object and returns its index.
+    fn push(&mut self, object: Object) -> usize {
+        self.objects.push(object);
+        self.objects.len() - 1
+    }
+
+    /// Copies `object` and every object it references.
+    fn copy(&mut self, object: &Object) -> Object {
+        match object {
+            Object::Reference(id) => Object::Reference(ObjectId::new(self.copy_reference(*id) as u32)),
+            Object::Array(array) => Object::Array(PdfArray::from_objects(
+                array.iter().map(|o| self.copy(o)).collect(),
+            )),
+            Object::Dictionary(dict) => Object::Dictionary(self.copy_dictionary(dict)),
+            Object::Stream(stream) => Object::Stream(PdfStream::with_dictionary(
+                self.copy_dictionary(&stream.dictionary),
+                stream.data.clone(),
+            )),
+            other => other.clone(),
+        }
+    }
+
+    fn copy_dictionary(&mut self, dict: &PdfDictionary) -> PdfDictionary {
+        let mut copy = PdfDictionary::with_capacity(dict.len());
+        for (key, value) in dict.iter() {
+            copy.set(key.clone(), self.copy(value));
+        }
+        copy
+    }
+
+    /// Returns the index of the copy of object `id`, copying it on first use.
+    ///
+    /// Missing objects are copied as `null` and recorded in `missing`.
+    fn copy_reference(&mut self, id: ObjectId) -> usize {
+        if let Some(&index) = self.numbers.get(&id.number) {
+            return index;
+        }
+        // Register the index first so reference cycles terminate
+        let index = self.push(Object::Null);
+        self.numbers.insert(id.number, index);
+        match self.reader.resolve_reference(id) {
+            Some(object) => self.objects[index] = self.copy(&object),
+            None => {
+                self.missing.insert(index, id);
+            }
+        }
+        index
+    }
+}
+
+/// Reads a rectangle from a four-number array.
+pub(crate) fn rectangle(object: &Object) -> Option<Rectangle> {
+    let values = match object {
+        Object::Array(array) if array.len() == 4 => array
+            .iter()
+            .map(|v| match v {
+                Object::Integer(n) => Some(*n as f64),
+                Object::Real(r) => Some(*r),
+                _ => None,
+            })
+            .collect::<Option<Vec<f64>>>()?,
+        _ => return None,
+    };
+    Some(Rectangle::new(
+        values[0].min(values[2]),
+        values[1].min(values[3]),
+        values[0].max(values[2]),
+        values[1].max(values[3]),
+    ))
+}
+
+/// Returns the decoded data of a content stream.
+pub(super) fn decode_content(stream: &PdfStream) -> Result<Vec<u8>, ParserError> {
+    match stream.dictionary.get("Filter") {
+        None => Ok(stream.data.clone()),
+        #[cfg(feature = "compression")]
+        Some(Object::Name(name))
+            if name.as_str() == "FlateDecode" && stream.dictionary.get("DecodeParms").is_none() =>
+        {
+            Ok(stream.decompress()?)
+        }
+        Some(filter) => Err(ParserError::UnsupportedFeature(format!(
+            "content stream filter {}",
+            filter.to_pdf_string()
+        ))),
+    }
+}
+
+/// Returns the media box of the new page and, for a rotated page, the
+/// matrix that turns the form upright on it.
+fn placement(bbox: &Rectangle, rotate: i64) -> (Rectangle, Option<Matrix>) {
+    let (width, height) = (bbox.width(), bbox.height());
+    let (llx, lly) = (bbox.llx, bbox.lly);
+    match rotate {
+        90 => (
+            Rectangle::from_dimensions(height, width),
+            Some(Matrix::new(0.0, -1.0, 1.0, 0.0, -lly, width + llx)),
+        ),
+        180 => (
+            Rectangle::from_dimensions(width, height),
+            Some(Matrix::new(-1.0, 0.0, 0.0, -1.0, width + llx, height + lly)),
+        ),
+        270 => (
+            Rectangle::from_dimensions(height, width),
+            Some(Matrix::new(0.0, 1.0, -1.0, 0.0, height + lly, -llx)),
+        ),
+        _ => (*bbox, None),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Builds a PDF from object bodies numbered from 1, with a valid xref table.
+    fn build_pdf(objects: &[&str]) -> Vec<u8> {
+        let mut pdf = b"%PDF-1.7\n".to_vec();
+        let mut offsets = Vec::new();
+        for (i, body) in objects.iter().enumerate() {
+            offsets.push(pdf.len());
+            pdf.extend_from_slice(format!("{} 0 obj\n{}\nendobj\n", i + 1, body).as_bytes());
+        }
+        let xref = pdf.len();
+        pdf.extend_from_slice(format!("xref\n0 {}\n0000000000 65535 f \n", objects.len() + 1).as_bytes());
+        for offset in offsets {
+            pdf.extend_from_slice(format!("{:010} 00000 n \n", offset).as_bytes());
+        }
+        pdf.extend_from_slice(
+            format!(
+                "trailer\n<< /Size {} /Root 1 0 R >>\nstartxref\n{}\n%%EOF\n",
+                objects.len() + 1,
+                xref
+            )
+            .as_bytes(),
+        );
+        pdf
+    }
+
+    fn form_dictionary(page: &Page) -> String {
+        let (_, imported) = &page.imported[0];
+        let objects: Vec<Object> = imported
+            .objects()
+            .renumbered(&(0..imported.objects().len() as u32).map(ObjectId::new).collect::<Vec<_>>())
+            .collect();
+        match &objects[imported.form()] {
+            Object::Stream(stream) => stream.dictionary.to_pdf_string(),
+            _ => panic!("Expected stream"),
+        }
+    }
+
+    #[test]
+    fn test_import_shares_resources() {
+        let pdf = build_pdf(&[
+            "<< /Type /Catalog /Pages 2 0 R >>",
+            "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 300 400] /Resources << /Font << /F1 5 0 R >> >> >>",
+            "<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+            "<< /Type /Page /Parent 2 0 R /Contents [6 0 R 7 0 R] /MediaBox [0 0 200 100] >>",
+            "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+            "<< /Length 5 >>\nstream\nBT ET\nendstream",
+            "<< /Length 8 0 R >>\nstream\n0 0 m\nendstream",
+            "7",
+        ]);
+        let pages = PdfReader::from_bytes(pdf).unwrap().import_pages().unwrap();
+        assert_eq!(pages.len(), 2);
+        assert_eq!(pages[0].media_box, Rectangle::new(0.0, 0.0, 300.0, 400.0));
+        assert_eq!(pages[1].media_box, Rectangle::new(0.0, 0.0, 200.0, 100.0));
+        assert_eq!(pages[0].content.build_string(), "q\n/ImportedPage Do\nQ");
+
+        // The font is copied once and both forms refer to it
+        let objects = pages[0].imported[0].1.objects();
+        assert!(Arc::ptr_eq(objects, pages[1].imported[0].1.objects()));
+        assert_eq!(objects.len(), 3);
+        assert!(form_dictionary(&pages[0]).contains("/Resources << /Font << /F1 0 0 R >> >>"));
+        assert!(form_dictionary(&pages[1]).contains("/Resources << /Font << /F1 0 0 R >> >>"));
+        assert!(form_dictionary(&pages[1]).contains("/BBox [0 0 200 100]"));
+
+        let ids = [ObjectId::new(1), ObjectId::new(2), ObjectId::new(3)];
+        let joined = objects.renumbered(&ids).nth(2);
+        match joined {
+            Some(Object::Stream(stream)) => assert_eq!(stream.data(), b"BT ET\n0 0 m\n"),
+            _ => panic!("Expected stream"),
+        }
+    }
+
+    #[test]
+    fn test_import_page() {
+        let pdf = build_pdf(&[
+            "<< /Type /Catalog /Pages 2 0 R >>",
+            "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+            "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 300 400] >>",
+            "<< /Type /Page /Parent 2 0 R /MediaBox [50 50 250 150] /Rotate 90 >>",
+        ]);
+        let reader = PdfReader::from_bytes(pdf).unwrap();
+        assert!(reader.import_page(2).is_err());
+
+        let page = reader.import_page(1).unwrap();
+        assert_eq!(page.objects().len(), 1);
+        assert_eq!(page.media_box(), Rectangle::new(0.0, 0.0, 100.0, 200.0));
+        // Turned clockwise, the lower-right corner of the original is placed
+        // at (10, 20) and the upper-left one half its size away
+        let matrix = page.placement(10.0, 20.0, 0.5);
+        assert_eq!(matrix.transform_point(250.0, 50.0), (10.0, 20.0));
+        assert_eq!(matrix.transform_point(50.0, 150.0), (60.0, 120.0));
+        let unrotated = reader.import_page(0).unwrap();
+        assert_eq!(unrotated.placement(10.0, 20.0, 0.5), Matrix::new(0.5, 0.0, 0.0, 0.5, 10.0, 20.0));
+    }
+
+    #[test]
+    fn test_import_nup() {
+        let pdf = build_pdf(&[
+            "<< /Type /Catalog /Pages 2 0 R >>",
+            "<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R 6 0 R 7 0 R] /Count 5 /MediaBox [0 0 300 400] >>",
+            "<< /Type /Page /Parent 2 0 R >>",
+            "<< /Type /Page /Parent 2 0 R >>",
+            "<< /Type /Page /Parent 2 0 R >>",
+            "<< /Type /Page /Parent 2 0 R >>",
+            "<< /Type /Page /Parent 2 0 R >>",
+        ]);
+        let reader = PdfReader::from_bytes(pdf).unwrap();
+        assert!(reader.import_nup(0, 2, 10.0).is_err());
+        assert!(reader.import_nup(2, 2, 150.0).is_err());
+
+        let sheets = reader.import_nup(2, 2, 10.0).unwrap();
+        assert_eq!(sheets.len(), 2);
+        assert_eq!(sheets[0].media_box, Rectangle::new(0.0, 0.0, 300.0, 400.0));
+        assert_eq!(sheets[0].imported.len(), 4);
+        assert_eq!(sheets[1].imported.len(), 1);
+        // Cells are 135 by 185, and each page is scaled to the cell width and centered
+        let content = sheets[0].content.build_string();
+        assert!(content.starts_with("q\n0.45 0 0 0.45 10 207.5 cm\n/ImportedPage1 Do\nQ"));
+        assert!(content.ends_with("q\n0.45 0 0 0.45 155 12.5 cm\n/ImportedPage4 Do\nQ"));
+    }
+
+    #[test]
+    fn test_import_rotated_page() {
+        let pdf = build_pdf(&[
+            "<< /Type /Catalog /Pages 2 0 R >>",
+            "<< /Type /Pages /Kids [3 0 R] /Count 1 /Rotate 90 >>",
+            "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 300 400] >>",
+        ]);
+        let pages = PdfReader::from_bytes(pdf).unwrap().import_pages().unwrap();
+        assert_eq!(pages[0].media_box, Rectangle::new(0.0, 0.0, 400.0, 300.0));
+        assert!(pages[0].content.build_string().contains("0 -1 1 0 0 300 cm"));
+    }
+
+    #[test]
+    fn test_import_rejects_cyclic_page_tree() {
+        let pdf = build_pdf(&[
+            "<< /Type /Catalog /Pages 2 0 R >>",
+            "<< /Type /Pages /Kids [2 0 R] /Count 1 >>",
+        ]);
+        let result = PdfReader::from_bytes(pdf).unwrap().import_pages();
+        assert!(matches!(
+            result,
+            Err(crate::error::PdfError::Parser(ParserError::InvalidPageTree(_)))
+        ));
+    }
+
+    #[test]
+    fn test_placement() {
+        let bbox = Rectangle::new(10.0, 20.0, 110.0, 220.0);
+        let (media_box, matrix) = placement(&bbox, 270);
+        assert_eq!(media_box, Rectangle::new(0.0, 0.0, 200.0, 100.0));
+        // The top-left corner of the source page ends up at the bottom left
+        assert_eq!(matrix.unwrap().transform_point(10.0, 220.0), (0.0, 0.0));
+        assert_eq!(placement(&bbox, 0), (bbox, None));
+    }
+}
@@ -11,12 +11,23 @@
 //! println!("Page count: {}", reader.page_count());
 //! ```
 
+mod cmap;
+mod encoding;
+mod fields;
+mod import;
 mod lexer;
 mod objects;
+mod recovery;
+mod source;
+mod text;
 mod trailer;
+mod update;
 mod xref;
 
+pub(crate) use import::rectangle;
+pub(crate) use objects::parse_object;
 pub use trailer::Trailer;
+pub(crate) use update::IncrementalWriter;
 pub use xref::{XrefEntry, XrefTable};
 
 use crate::document::PdfVersion;
@@ -24,7 +35,8 @@ use crate::error::{ParserError, PdfResult};
 use crate::object::{Object, PdfDictionary};
 use crate::types::ObjectId;
 use objects::parse_indirect_object;
-use std::collections::HashMap;
+use source::Source;
+use std::collections::{HashMap, HashSet};
 use std::fs;
 use std::path::Path;
 use trailer::parse_trailer;
@@ -36,15 +48,19 @@ use xref::{find_startxref, parse_xref_table};
 #[derive(Debug)]
 pub struct PdfReader {
     /// Raw PDF data.
-    data: Vec<u8>,
+    data: Source,
     /// PDF version.
     version: PdfVersion,
     /// Cross-reference table.
     xref: XrefTable,
     /// Trailer information.
     trailer: Trailer,
+    /// Offset of the most recent cross-reference section.
+    startxref: u64,
     /// Object cache.
     object_cache: HashMap<ObjectId, Object>,
+    /// Whether the cross-reference table was rebuilt because it was damaged.
+    recovered: bool,
 }
 
 impl PdfReader {
@@ -54,16 +70,61 @@ impl PdfReader {
         Self::from_bytes(data)
     }
 
+    /// Opens a PDF file like [`from_file`](Self::from_file), but maps it
+    /// into memory instead of reading it, so only the parts of the file
+    /// holding the objects that are parsed are loaded. The file is read
+    /// where memory maps are not supported.
+    ///
+    /// # Safety
+    /// The file must not be changed or truncated while the reader exists.
+    pub unsafe fn from_mapped_file(path: impl AsRef<Path>) -> PdfResult<Self> {
+        Self::open(Source::map(path)?, false)
+    }
+
+    /// Maps a PDF file into memory like
+    /// [`from_mapped_file`](Self::from_mapped_file), recovering a damaged
+    /// cross-reference table like
+    /// [`from_bytes_with_recovery`](Self::from_bytes_with_recovery).
+    ///
+    /// # Safety
+    /// The file must not be changed or truncated while the reader exists.
+    pub unsafe fn from_mapped_file_with_recovery(path: impl AsRef<Path>) -> PdfResult<Self> {
+        Self::open(Source::map(path)?, true)
+    }
+
     /// Opens a PDF from bytes.
     pub fn from_bytes(data: Vec<u8>) -> PdfResult<Self> {
+        Self::open(data.into(), false)
+    }
+
+    /// Opens a PDF from bytes like [`from_bytes`](Self::from_bytes), but
+    /// rebuilds the cross-reference table by scanning the file for objects
+    /// if it cannot be read or does not match the objects' offsets.
+    /// [`was_recovered`](Self::was_recovered) tells whether it was.
+    pub fn from_bytes_with_recovery(data: Vec<u8>) -> PdfResult<Self> {
+        Self::open(data.into(), true)
+    }
+
+    /// Reads the header, cross-reference table and trailer of `data`.
+    fn open(data: Source, recover: bool) -> PdfResult<Self> {
         // Parse header
         let version = Self::parse_header(&data)?;
 
-        // Find startxref
-        let xref_offset = find_startxref(&data)?;
-
-        // Parse xref table and trailer
-        let (xref, trailer) = Self::parse_xref_and_trailer(&data, xref_offset)?;
+        // Find startxref, then parse xref table and trailer
+        let parsed = find_startxref(&data).and_then(|offset| {
+            let (xref, trailer) = Self::parse_xref_and_trailer(&data, offset)?;
+            Ok((xref, trailer, offset))
+        });
+        let (xref, trailer, xref_offset, recovered) = match parsed {
+            Ok((xref, trailer, offset)) if !recover || recovery::xref_matches(&data, &xref) => {
+                (xref, trailer, offset, false)
+            }
+            Err(e) if !recover => return Err(e.into()),
+            _ => {
+                let (xref, dict) = recovery::rebuild(&data)?;
+                (xref, Trailer::from_dictionary(dict)?, 0, true)
+            }
+        };
 
         // Check for encryption
         if trailer.encrypt.is_some() {
@@ -75,7 +136,9 @@ impl PdfReader {
             version,
             xref,
             trailer,
+            startxref: xref_offset,
             object_cache: HashMap::new(),
+            recovered,
         })
     }
 
@@ -104,35 +167,25 @@ impl PdfReader {
     ) -> Result<(XrefTable, Trailer), ParserError> {
         let mut combined_xref = XrefTable::new();
         let mut final_trailer: Option<Trailer> = None;
+        let mut visited = HashSet::new();
 
         // Follow the chain of xref tables (for incremental updates)
-        loop {
-            let xref_data = &data[xref_offset as usize..];
+        while visited.insert(xref_offset) {
+            let xref_data = data
+                .get(xref_offset as usize..)
+                .ok_or(ParserError::InvalidXref)?;
 
             // Check if this is a traditional xref table or xref stream
-            if xref_data.starts_with(b"xref") {
+            let trailer_dict = if xref_data.starts_with(b"xref") {
                 // Traditional xref table
                 let (remaining, xref) = parse_xref_table(xref_data)
                     .map_err(|_| ParserError::InvalidXref)?;
 
                 combined_xref.merge(xref);
 
-                // Parse trailer
                 let (_, trailer_dict) = parse_trailer(remaining)
                     .map_err(|_| ParserError::InvalidTrailer)?;
-
-                let trailer = Trailer::from_dictionary(trailer_dict)?;
-
-                // Save the first trailer (most recent) as final
-                if final_trailer.is_none() {
-                    let prev = trailer.prev;
-                    final_trailer = Some(trailer);
-
-                    if let Some(prev_offset) = prev {
-                        xref_offset = prev_offset;
-                        continue;
-                    }
-                }
+                trailer_dict
             } else {
                 // Could be an xref stream (PDF 1.5+)
                 // For now, try to parse as an indirect object
@@ -146,23 +199,26 @@ impl PdfReader {
                         combined_xref.merge(xref);
 
                         // Get trailer info from stream dictionary
-                        let trailer = Trailer::from_dictionary(stream.dictionary.clone())?;
-
-                        if final_trailer.is_none() {
-                            let prev = trailer.prev;
-                            final_trailer = Some(trailer);
-
-                            if let Some(prev_offset) = prev {
-                                xref_offset = prev_offset;
-                                continue;
-                            }
-                        }
+                        stream.dictionary
                     }
                     _ => return Err(ParserError::InvalidXrefStream),
                 }
-            }
+            };
 
-            break;
+            // The first trailer (most recent) is the final one. Older ones
+            // may leave out /Root, like the main trailer of a linearized file,
+            // so only their /Prev is read.
+            let prev = match trailer_dict.get("Prev") {
+                Some(Object::Integer(offset)) => u64::try_from(*offset).ok(),
+                _ => None,
+            };
+            if final_trailer.is_none() {
+                final_trailer = Some(Trailer::from_dictionary(trailer_dict)?);
+            }
+            match prev {
+                Some(prev_offset) => xref_offset = prev_offset,
+                None => break,
+            }
         }
 
         let trailer = final_trailer.ok_or(ParserError::InvalidTrailer)?;
@@ -297,6 +353,11 @@ impl PdfReader {
         self.get_page_count_from_tree().unwrap_or(0)
     }
 
+    /// Returns the references of the page objects, in order.
+    pub fn page_ids(&self) -> PdfResult<Vec<ObjectId>> {
+        Ok(self.leaf_pages()?.into_iter().map(|(id, _)| id).collect())
+    }
+
     /// Gets the page count from the page tree.
     fn get_page_count_from_tree(&self) -> Option<usize> {
         let root = self.resolve_reference(self.trailer.root)?;
@@ -364,7 +425,7 @@ impl PdfReader {
         match entry {
             XrefEntry::InUse { offset, .. } => {
                 // Parse object at offset
-                let data = &self.data[*offset as usize..];
+                let data = self.data.get(*offset as usize..)?;
                 let (_, (_, _, obj)) = parse_indirect_object(data).ok()?;
                 Some(obj)
             }
@@ -385,7 +446,7 @@ impl PdfReader {
         let stream_entry = self.xref.get(stream_num)?;
         let offset = stream_entry.offset()?;
 
-        let data = &self.data[offset as usize..];
+        let data = self.data.get(offset as usize..)?;
         let (_, (_, _, stream_obj)) = parse_indirect_object(data).ok()?;
 
         let stream = match stream_obj {
@@ -416,7 +477,7 @@ impl PdfReader {
         let stream_data = stream.data().to_vec();
 
         // Parse the header (N pairs of obj_num, offset)
-        let header = &stream_data[..first];
+        let header = stream_data.get(..first)?;
         let objects_data = &stream_data[first..];
 
         // Find the offset for our object
@@ -434,13 +495,13 @@ impl PdfReader {
 
         // Find end offset (next object's offset or end of data)
         let next_offset = if (index as usize + 1) < num_objects {
-            nums[(index as usize + 1) * 2 + 1] as usize
+            *nums.get((index as usize + 1) * 2 + 1)? as usize
         } else {
             objects_data.len()
         };
 
         // Parse the object
-        let obj_data = &objects_data[obj_offset..next_offset];
+        let obj_data = objects_data.get(obj_offset..next_offset)?;
         let (_, obj) = objects::parse_object(obj_data).ok()?;
 
         Some(obj)
@@ -460,6 +521,18 @@ impl PdfReader {
     pub fn trailer(&self) -> &Trailer {
         &self.trailer
     }
+
+    /// Returns the byte offset of the most recent cross-reference section,
+    /// as given after `startxref`, or 0 if the table was rebuilt.
+    pub fn startxref(&self) -> u64 {
+        self.startxref
+    }
+
+    /// Returns whether the file was damaged and its cross-reference table
+    /// rebuilt by [`from_bytes_with_recovery`](Self::from_bytes_with_recovery).
+    pub fn was_recovered(&self) -> bool {
+        self.recovered
+    }
 }
 
 #[cfg(test)]
@@ -528,6 +601,38 @@ mod tests {
         assert!(info.get("Title").is_some());
     }
 
+    /// Appends an incremental update that adds object `id`.
+    fn append_update(pdf: &mut Vec<u8>, id: u32, object: &str) {
+        let reader = PdfReader::from_bytes(pdf.clone()).unwrap();
+        let offset = pdf.len();
+        pdf.extend_from_slice(format!("{} 0 obj\n{}\nendobj\n", id, object).as_bytes());
+        let xref = pdf.len();
+        let root = reader.trailer().root.reference_string();
+        let trailer = format!("<< /Size {} /Root {} /Prev {} >>", id + 1, root, reader.startxref());
+        let section = format!("xref\n{} 1\n{:010} 00000 n \ntrailer\n{}\nstartxref\n{}\n%%EOF\n", id, offset, trailer, xref);
+        pdf.extend_from_slice(section.as_bytes());
+    }
+
+    #[test]
+    fn test_incremental_updates() {
+        let mut pdf = create_simple_pdf();
+        let size = PdfReader::from_bytes(pdf.clone()).unwrap().trailer().size;
+        append_update(&mut pdf, size, "(first)");
+        append_update(&mut pdf, size + 1, "(second)");
+
+        // Every section of the chain is read, back to the original one
+        let reader = PdfReader::from_bytes(pdf).unwrap();
+        assert_eq!(reader.trailer().size, size + 2);
+        assert_eq!(reader.page_count(), 1);
+        assert_eq!(reader.page_ids().unwrap().len(), 1);
+        for (number, text) in [(size, "first"), (size + 1, "second")] {
+            match reader.resolve_reference(ObjectId::new(number)) {
+                Some(Object::String(s)) => assert_eq!(s.as_bytes(), text.as_bytes()),
+                other => panic!("unexpected {:?}", other),
+            }
+        }
+    }
+
     #[test]
     fn test_roundtrip_multi_page() {
         use crate::prelude::*;
@@ -553,4 +658,49 @@ mod tests {
 
         assert_eq!(reader.page_count(), 2);
     }
+
+    #[test]
+    fn test_recover_damaged_xref() {
+        let pdf = create_simple_pdf();
+        let reader = PdfReader::from_bytes_with_recovery(pdf.clone()).unwrap();
+        assert!(!reader.was_recovered());
+        assert_ne!(reader.startxref(), 0);
+
+        // The table is cut off
+        let xref = pdf.windows(5).rposition(|w| w == b"xref\n").unwrap();
+        let mut truncated = pdf[..xref + 12].to_vec();
+        truncated.extend_from_slice(b"\nstartxref\n999999\n%%EOF\n");
+        assert!(PdfReader::from_bytes(truncated.clone()).is_err());
+        let reader = PdfReader::from_bytes_with_recovery(truncated).unwrap();
+        assert!(reader.was_recovered());
+        assert_eq!(reader.page_count(), 1);
+        assert!(reader.info().unwrap().get("Title").is_some());
+
+        // The table reads but every offset is off by a comment line
+        let mut shifted = pdf[..9].to_vec();
+        shifted.extend_from_slice(b"% inserted by a broken tool\n");
+        shifted.extend_from_slice(&pdf[9..]);
+        let reader = PdfReader::from_bytes_with_recovery(shifted).unwrap();
+        assert!(reader.was_recovered());
+        assert_eq!(reader.page_count(), 1);
+
+        assert!(PdfReader::from_bytes_with_recovery(b"%PDF-1.7\nno objects here".to_vec()).is_err());
+    }
+
+    #[test]
+    fn test_recover_object_streams() {
+        use crate::prelude::*;
+
+        let mut doc = DocumentBuilder::new().title("Packed").page(PageBuilder::a4().build()).build().unwrap();
+        doc.object_streams = true;
+        let pdf = doc.save_to_bytes().unwrap();
+        let startxref = pdf.windows(9).rposition(|w| w == b"startxref").unwrap();
+        let mut damaged = pdf[..startxref].to_vec();
+        damaged.extend_from_slice(b"startxref\n0\n%%EOF\n");
+
+        let reader = PdfReader::from_bytes_with_recovery(damaged).unwrap();
+        assert!(reader.was_recovered());
+        assert_eq!(reader.page_count(), 1);
+        assert!(reader.info().unwrap().get("Title").is_some());
+    }
 }
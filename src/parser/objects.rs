@@ -133,12 +133,17 @@ fn parse_dictionary_or_stream(input: &[u8]) -> IResult<&[u8], Object> {
         let length = match dict.get("Length") {
             Some(Object::Integer(len)) => *len as usize,
             _ => {
-                // Length might be a reference, which we can't resolve here
-                // Return error for now
-                return Err(nom::Err::Error(nom::error::Error::new(
-                    stream_input,
-                    nom::error::ErrorKind::Verify,
-                )));
+                // Length might be a reference, which we can't resolve here,
+                // so fall back to the position of the endstream keyword
+                match find_endstream(stream_input) {
+                    Some(len) => len,
+                    None => {
+                        return Err(nom::Err::Error(nom::error::Error::new(
+                            stream_input,
+                            nom::error::ErrorKind::Verify,
+                        )));
+                    }
+                }
             }
         };
 
@@ -165,6 +170,19 @@ fn parse_dictionary_or_stream(input: &[u8]) -> IResult<&[u8], Object> {
     }
 }
 
+/// Returns the length of stream data that ends at the next `endstream`,
+/// excluding the end-of-line marker before it.
+fn find_endstream(input: &[u8]) -> Option<usize> {
+    let end = input.windows(9).position(|w| w == b"endstream")?;
+    let data = &input[..end];
+    let trimmed = data
+        .strip_suffix(b"\r\n")
+        .or_else(|| data.strip_suffix(b"\n"))
+        .or_else(|| data.strip_suffix(b"\r"))
+        .unwrap_or(data);
+    Some(trimmed.len())
+}
+
 /// Parse an indirect object definition (n n obj ... endobj).
 pub fn parse_indirect_object(input: &[u8]) -> IResult<&[u8], (u32, u16, Object)> {
     let (input, _) = skip_whitespace(input)?;
@@ -269,4 +287,14 @@ mod tests {
             _ => panic!("Expected dictionary"),
         }
     }
+
+    #[test]
+    fn test_parse_stream_with_indirect_length() {
+        let input = b"<< /Length 8 0 R >>\nstream\nBT ET\nendstream";
+        let (_, obj) = parse_object(input).unwrap();
+        match obj {
+            Object::Stream(stream) => assert_eq!(stream.data(), b"BT ET"),
+            _ => panic!("Expected stream"),
+        }
+    }
 }
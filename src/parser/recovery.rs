@@ -0,0 +1,184 @@
+//! Rebuilding the cross-reference table of a damaged file.
+//!
+//! Files whose xref table is missing, truncated or points at the wrong
+//! offsets can still be read by scanning for the `N G obj` header of every
+//! object, as viewers do. Objects defined more than once, as in a file
+//! with incremental updates, take the last definition.
+
+use super::objects::parse_indirect_object;
+use super::trailer::parse_trailer;
+use super::xref::{XrefEntry, XrefTable};
+use crate::error::ParserError;
+use crate::object::{Object, PdfDictionary, PdfStream};
+use crate::types::ObjectId;
+
+/// Returns whether every object in use in `xref` starts at its offset, with
+/// the number and generation it is listed under.
+pub(super) fn xref_matches(data: &[u8], xref: &XrefTable) -> bool {
+    xref.iter().all(|(number, entry)| match entry {
+        XrefEntry::InUse { offset, generation } => {
+            let header = usize::try_from(*offset).ok().and_then(|offset| object_header(data, offset));
+            header.map(|(found, generation_found, _)| (found, generation_found)) == Some((*number, *generation))
+        }
+        _ => true,
+    })
+}
+
+/// Rebuilds the cross-reference table and trailer dictionary of `data` from
+/// the objects found in it.
+///
+/// The trailer is the last `trailer` dictionary, or cross-reference stream
+/// dictionary, whose `/Root` is among the objects found; failing that, one
+/// is made up that points at the last catalog.
+pub(super) fn rebuild(data: &[u8]) -> Result<(XrefTable, PdfDictionary), ParserError> {
+    let mut xref = XrefTable::new();
+    let mut size = 0;
+    let mut offset = 0;
+    while offset < data.len() {
+        let at_line_start = offset == 0 || matches!(data[offset - 1], b'\n' | b'\r');
+        if at_line_start {
+            if let Some((number, generation, end)) = object_header(data, offset) {
+                xref.insert(
+                    number,
+                    XrefEntry::InUse {
+                        offset: offset as u64,
+                        generation,
+                    },
+                );
+                size = size.max(number + 1);
+                offset = end;
+                continue;
+            }
+        }
+        offset += 1;
+    }
+    if xref.is_empty() {
+        return Err(ParserError::InvalidXref);
+    }
+
+    let mut trailers: Vec<PdfDictionary> = Vec::new();
+    let mut catalog = None;
+    let mut entries: Vec<(u32, u64)> = xref.iter().filter_map(|(n, e)| Some((*n, e.offset()?))).collect();
+    entries.sort_by_key(|&(_, offset)| offset);
+    for &(number, offset) in &entries {
+        let (generation, object) = match parse_indirect_object(&data[offset as usize..]) {
+            Ok((_, (_, generation, object))) => (generation, object),
+            Err(_) => continue,
+        };
+        let dict = match &object {
+            Object::Dictionary(dict) => dict,
+            Object::Stream(stream) => &stream.dictionary,
+            _ => continue,
+        };
+        match dict.get("Type") {
+            Some(Object::Name(name)) if name.as_str() == "Catalog" => {
+                catalog = Some(ObjectId::with_generation(number, generation))
+            }
+            Some(Object::Name(name)) if name.as_str() == "XRef" => trailers.push(dict.clone()),
+            Some(Object::Name(name)) if name.as_str() == "ObjStm" => {
+                if let Object::Stream(stream) = &object {
+                    add_compressed_objects(&mut xref, &mut size, number, stream);
+                }
+            }
+            _ => {}
+        }
+    }
+    let mut position = 0;
+    while let Some(found) = data[position..].windows(7).position(|w| w == b"trailer") {
+        let start = position + found;
+        if let Ok((_, dict)) = parse_trailer(&data[start..]) {
+            trailers.push(dict);
+        }
+        position = start + 7;
+    }
+
+    let known = |dict: &PdfDictionary| match dict.get("Root") {
+        Some(Object::Reference(id)) => xref.get(id.number).is_some(),
+        _ => false,
+    };
+    let mut trailer = match trailers.into_iter().rev().find(known) {
+        Some(trailer) => trailer,
+        None => {
+            let root = catalog.ok_or(ParserError::InvalidTrailer)?;
+            let mut dict = PdfDictionary::new();
+            dict.set("Root", Object::Reference(root));
+            dict
+        }
+    };
+    // Cross-reference stream entries and an older /Prev do not apply to
+    // the rebuilt table
+    for key in ["Prev", "Type", "W", "Index", "Length", "Filter", "DecodeParms", "XRefStm"] {
+        trailer.remove(key);
+    }
+    trailer.set("Size", Object::Integer(i64::from(size)));
+    Ok((xref, trailer))
+}
+
+/// Adds the objects stored in object stream `number` to `xref`, unless they
+/// are also defined directly in the file.
+fn add_compressed_objects(xref: &mut XrefTable, size: &mut u32, number: u32, stream: &PdfStream) {
+    let first = match stream.dictionary.get("First") {
+        Some(Object::Integer(first)) => usize::try_from(*first).unwrap_or(0),
+        _ => return,
+    };
+    #[cfg(feature = "compression")]
+    let decoded = if stream.is_compressed() {
+        match stream.decompress() {
+            Ok(decoded) => decoded,
+            Err(_) => return,
+        }
+    } else {
+        stream.data().to_vec()
+    };
+    #[cfg(not(feature = "compression"))]
+    let decoded = stream.data().to_vec();
+
+    let header = match decoded.get(..first).and_then(|header| std::str::from_utf8(header).ok()) {
+        Some(header) => header,
+        None => return,
+    };
+    let numbers: Vec<u32> = header.split_whitespace().step_by(2).filter_map(|n| n.parse().ok()).collect();
+    for (index, object) in numbers.into_iter().enumerate() {
+        if xref.get(object).is_none() {
+            xref.insert(
+                object,
+                XrefEntry::Compressed {
+                    object_stream: number,
+                    index: index as u32,
+                },
+            );
+            *size = (*size).max(object + 1);
+        }
+    }
+}
+
+/// Reads an `N G obj` header at `offset`, returning the object number, the
+/// generation and the offset just past `obj`.
+fn object_header(data: &[u8], offset: usize) -> Option<(u32, u16, usize)> {
+    let input = data.get(offset..)?;
+    let (number, rest) = digits(input)?;
+    let rest = after_whitespace(rest)?;
+    let (generation, rest) = digits(rest)?;
+    let rest = after_whitespace(rest)?;
+    let rest = rest.strip_prefix(b"obj")?;
+    match rest.first() {
+        Some(c) if !(c.is_ascii_whitespace() || b"<[(/%".contains(c)) => return None,
+        _ => {}
+    }
+    Some((number.parse().ok()?, generation.parse().ok()?, data.len() - rest.len()))
+}
+
+/// Splits a run of decimal digits off the front of `input`.
+fn digits(input: &[u8]) -> Option<(&str, &[u8])> {
+    let end = input.iter().position(|c| !c.is_ascii_digit()).unwrap_or(input.len());
+    if end == 0 || end > 10 {
+        return None;
+    }
+    Some((std::str::from_utf8(&input[..end]).ok()?, &input[end..]))
+}
+
+/// Skips the whitespace, of which there must be some, at the front of `input`.
+fn after_whitespace(input: &[u8]) -> Option<&[u8]> {
+    let start = input.iter().position(|c| !c.is_ascii_whitespace())?;
+    (start > 0).then(|| &input[start..])
+}
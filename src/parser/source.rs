@@ -0,0 +1,136 @@
+//! The bytes of a PDF being read, held in memory or mapped from a file.
+
+use std::fs::File;
+use std::io;
+use std::ops::Deref;
+use std::path::Path;
+
+/// The bytes a [`PdfReader`](super::PdfReader) parses.
+#[derive(Debug)]
+pub(crate) enum Source {
+    /// Bytes the reader owns.
+    Owned(Vec<u8>),
+    /// A file mapped into memory, whose pages the system loads as they are
+    /// first read.
+    #[cfg(unix)]
+    Mapped(MappedFile),
+}
+
+impl Source {
+    /// Maps the file at `path` into memory, or reads it where memory maps
+    /// are not supported.
+    ///
+    /// # Safety
+    /// The file must not be changed or truncated while the source exists,
+    /// as its bytes would change under the reader.
+    pub(crate) unsafe fn map(path: impl AsRef<Path>) -> io::Result<Self> {
+        #[cfg(unix)]
+        {
+            let file = File::open(path)?;
+            let len = usize::try_from(file.metadata()?.len())
+                .map_err(|_| io::Error::new(io::ErrorKind::InvalidInput, "file is too large to map"))?;
+            // Empty mappings are not allowed
+            if len == 0 {
+                return Ok(Source::Owned(Vec::new()));
+            }
+            MappedFile::new(&file, len).map(Source::Mapped)
+        }
+        #[cfg(not(unix))]
+        {
+            std::fs::read(path).map(Source::Owned)
+        }
+    }
+}
+
+impl From<Vec<u8>> for Source {
+    fn from(data: Vec<u8>) -> Self {
+        Source::Owned(data)
+    }
+}
+
+impl Deref for Source {
+    type Target = [u8];
+
+    fn deref(&self) -> &[u8] {
+        match self {
+            Source::Owned(data) => data,
+            #[cfg(unix)]
+            Source::Mapped(file) => file.bytes(),
+        }
+    }
+}
+
+/// A read-only, private memory map of a whole file, unmapped when dropped.
+#[cfg(unix)]
+#[derive(Debug)]
+pub(crate) struct MappedFile {
+    ptr: *mut libc::c_void,
+    len: usize,
+}
+
+// SAFETY: the mapping is read-only and owned by the value, so it can be moved
+// to and read from other threads like a `Vec<u8>`.
+#[cfg(unix)]
+unsafe impl Send for MappedFile {}
+#[cfg(unix)]
+unsafe impl Sync for MappedFile {}
+
+#[cfg(unix)]
+impl MappedFile {
+    /// Maps the first `len` bytes of `file`, which must not be 0.
+    unsafe fn new(file: &File, len: usize) -> io::Result<Self> {
+        use std::os::unix::io::AsRawFd;
+
+        let ptr = libc::mmap(
+            std::ptr::null_mut(),
+            len,
+            libc::PROT_READ,
+            libc::MAP_PRIVATE,
+            file.as_raw_fd(),
+            0,
+        );
+        if ptr == libc::MAP_FAILED {
+            return Err(io::Error::last_os_error());
+        }
+        Ok(Self { ptr, len })
+    }
+
+    fn bytes(&self) -> &[u8] {
+        // SAFETY: `ptr` is a readable mapping of `len` bytes that lives as
+        // long as `self`.
+        unsafe { std::slice::from_raw_parts(self.ptr as *const u8, self.len) }
+    }
+}
+
+#[cfg(unix)]
+impl Drop for MappedFile {
+    fn drop(&mut self) {
+        // SAFETY: `ptr` and `len` describe a mapping made by `new` that
+        // nothing refers to any more.
+        unsafe {
+            libc::munmap(self.ptr, self.len);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_map_file() {
+        let file = tempfile::NamedTempFile::new().unwrap();
+        std::fs::write(file.path(), b"%PDF-1.7\n%%EOF\n").unwrap();
+        let source = unsafe { Source::map(file.path()) }.unwrap();
+        assert_eq!(&*source, b"%PDF-1.7\n%%EOF\n");
+        #[cfg(unix)]
+        assert!(matches!(source, Source::Mapped(_)));
+        drop(source);
+
+        std::fs::write(file.path(), b"").unwrap();
+        assert!(unsafe { Source::map(file.path()) }.unwrap().is_empty());
+        let path = file.path().to_path_buf();
+        file.close().unwrap();
+        assert!(unsafe { Source::map(&path) }.is_err());
+    }
+}
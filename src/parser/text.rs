@@ -0,0 +1,588 @@
+//! Extracting the text of pages from their content streams.
+
+use super::cmap::ToUnicode;
+use super::encoding::{BaseEncoding, SimpleEncoding};
+use super::import::decode_content;
+use super::lexer::{parse_hex_string, parse_literal_string, skip_whitespace};
+use super::PdfReader;
+use crate::error::{DocumentError, ParserError, PdfResult};
+use crate::object::{Object, PdfDictionary};
+use std::collections::HashMap;
+use std::rc::Rc;
+
+/// How deeply form XObjects may nest before their content is skipped.
+const MAX_FORM_DEPTH: usize = 16;
+
+/// TJ adjustments that move the next glyph further right than this, in
+/// thousandths of an em, separate words.
+const WORD_GAP: f64 = 250.0;
+
+/// Lines whose baselines are closer than this, in text space units, are
+/// taken to be the same line.
+const LINE_TOLERANCE: f64 = 1.0;
+
+const IDENTITY: [f64; 6] = [1.0, 0.0, 0.0, 1.0, 0.0, 0.0];
+
+impl PdfReader {
+    /// Returns the text of page `page_index`, in the order the content
+    /// stream shows it.
+    ///
+    /// Text on a new baseline starts a new line, and repositioning along the
+    /// same baseline or a wide TJ adjustment inserts a space. Codes a font
+    /// gives no character for become U+FFFD.
+    pub fn extract_text(&self, page_index: usize) -> PdfResult<String> {
+        let mut pages = self.leaf_pages()?;
+        if page_index >= pages.len() {
+            return Err(DocumentError::PageOutOfRange { index: page_index, count: pages.len() }.into());
+        }
+        let (_, page) = pages.swap_remove(page_index);
+
+        let streams = match page.get("Contents").and_then(|c| self.resolve(c)) {
+            Some(Object::Stream(stream)) => vec![stream],
+            Some(Object::Array(parts)) => parts
+                .iter()
+                .filter_map(|part| match self.resolve(part) {
+                    Some(Object::Stream(stream)) => Some(stream),
+                    _ => None,
+                })
+                .collect(),
+            _ => Vec::new(),
+        };
+        let mut content = Vec::new();
+        for stream in &streams {
+            content.extend_from_slice(&decode_content(stream)?);
+            content.push(b'\n');
+        }
+        let resources = dictionary(self, page.get("Resources")).unwrap_or_default();
+
+        let mut extractor = Extractor::new(self);
+        extractor.run(&content, &resources, 0)?;
+        Ok(extractor.text)
+    }
+}
+
+/// Resolves `object` if it is a dictionary or a reference to one.
+fn dictionary(reader: &PdfReader, object: Option<&Object>) -> Option<PdfDictionary> {
+    match object.and_then(|o| reader.resolve(o)) {
+        Some(Object::Dictionary(dict)) => Some(dict),
+        _ => None,
+    }
+}
+
+/// Maps the codes a font shows to text.
+#[derive(Debug)]
+struct Font {
+    to_unicode: Option<ToUnicode>,
+    /// The encoding of a simple font, or `None` for a composite one.
+    encoding: Option<SimpleEncoding>,
+}
+
+impl Font {
+    fn load(reader: &PdfReader, dict: &PdfDictionary) -> Self {
+        let to_unicode = match dict.get("ToUnicode").and_then(|t| reader.resolve(t)) {
+            Some(Object::Stream(stream)) => decode_content(&stream).ok().map(|data| ToUnicode::parse(&data)),
+            _ => None,
+        };
+        let subtype = match dict.get("Subtype") {
+            Some(Object::Name(name)) => name.as_str(),
+            _ => "Type1",
+        };
+        if subtype == "Type0" {
+            return Self { to_unicode, encoding: None };
+        }
+
+        // Nonsymbolic TrueType fonts without an encoding are in practice
+        // written for Windows
+        let default = if subtype == "TrueType" { BaseEncoding::WinAnsi } else { BaseEncoding::Standard };
+        let base = |name: Option<&Object>| match name {
+            Some(Object::Name(name)) => BaseEncoding::from_name(name.as_str()).unwrap_or(default),
+            _ => default,
+        };
+        let encoding = match dict.get("Encoding").and_then(|e| reader.resolve(e)) {
+            Some(Object::Dictionary(encoding)) => {
+                let mut simple = SimpleEncoding::new(base(encoding.get("BaseEncoding")));
+                if let Some(Object::Array(differences)) = encoding.get("Differences").and_then(|d| reader.resolve(d)) {
+                    let mut code = 0;
+                    for item in differences.iter() {
+                        match item {
+                            Object::Integer(n) => code = *n,
+                            Object::Name(name) => {
+                                if let Ok(byte) = u8::try_from(code) {
+                                    simple.set(byte, name.as_str());
+                                }
+                                code += 1;
+                            }
+                            _ => {}
+                        }
+                    }
+                }
+                simple
+            }
+            other => SimpleEncoding::new(base(other.as_ref())),
+        };
+        Self { to_unicode, encoding: Some(encoding) }
+    }
+
+    /// Appends the text of the codes in `bytes` to `text`.
+    fn decode(&self, bytes: &[u8], text: &mut String) {
+        match (&self.encoding, &self.to_unicode) {
+            (Some(encoding), to_unicode) => {
+                for &code in bytes {
+                    match to_unicode.as_ref().and_then(|map| map.get(&[code])) {
+                        Some(mapped) => text.push_str(mapped),
+                        None => text.push(encoding.char(code).unwrap_or(char::REPLACEMENT_CHARACTER)),
+                    }
+                }
+            }
+            (None, Some(to_unicode)) => {
+                for mapped in to_unicode.decode(bytes) {
+                    text.push_str(mapped.unwrap_or("\u{FFFD}"));
+                }
+            }
+            // Without a ToUnicode CMap the codes of a composite font are
+            // glyph numbers, which mean nothing on their own
+            (None, None) => text.extend(bytes.chunks(2).map(|_| char::REPLACEMENT_CHARACTER)),
+        }
+    }
+}
+
+/// What separates the next text shown from the text before it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum Gap {
+    None,
+    Space,
+    Line,
+}
+
+/// The parts of the graphics state that `q` and `Q` save and restore.
+#[derive(Debug, Clone, Default)]
+struct State {
+    font: Option<Rc<Font>>,
+    leading: f64,
+}
+
+struct Extractor<'a> {
+    reader: &'a PdfReader,
+    /// Fonts loaded so far, by object number.
+    fonts: HashMap<u32, Rc<Font>>,
+    state: State,
+    /// The text line matrix.
+    line: [f64; 6],
+    /// Whether the line matrix moved since text was last shown.
+    moved: bool,
+    /// Baseline of the text shown last.
+    last_y: Option<f64>,
+    gap: Gap,
+    text: String,
+}
+
+impl<'a> Extractor<'a> {
+    fn new(reader: &'a PdfReader) -> Self {
+        Self {
+            reader,
+            fonts: HashMap::new(),
+            state: State::default(),
+            line: IDENTITY,
+            moved: false,
+            last_y: None,
+            gap: Gap::None,
+            text: String::new(),
+        }
+    }
+
+    /// Interprets a content stream whose names refer to `resources`.
+    fn run(&mut self, content: &[u8], resources: &PdfDictionary, depth: usize) -> Result<(), ParserError> {
+        let mut saved = Vec::new();
+        for (operator, operands) in Operations::new(content) {
+            match operator {
+                b"q" => saved.push(self.state.clone()),
+                b"Q" => {
+                    if let Some(state) = saved.pop() {
+                        self.state = state;
+                    }
+                }
+                b"BT" => {
+                    self.line = IDENTITY;
+                    self.moved = true;
+                }
+                b"Tf" => {
+                    if let [.., Operand::Name(name), _] = operands.as_slice() {
+                        self.state.font = self.font(resources, name);
+                    }
+                }
+                b"TL" => {
+                    if let Some([leading]) = numbers(&operands) {
+                        self.state.leading = leading;
+                    }
+                }
+                b"Tm" => {
+                    if let Some(matrix) = numbers(&operands) {
+                        self.line = matrix;
+                        self.moved = true;
+                    }
+                }
+                b"Td" | b"TD" => {
+                    if let Some([tx, ty]) = numbers(&operands) {
+                        if operator == b"TD" {
+                            self.state.leading = -ty;
+                        }
+                        self.translate(tx, ty);
+                    }
+                }
+                b"T*" => self.translate(0.0, -self.state.leading),
+                b"Tj" | b"'" | b"\"" => {
+                    if operator != b"Tj" {
+                        self.translate(0.0, -self.state.leading);
+                    }
+                    if let Some(Operand::String(bytes)) = operands.last() {
+                        self.show(bytes);
+                    }
+                }
+                b"TJ" => {
+                    if let Some(Operand::Array(items)) = operands.last() {
+                        for item in items {
+                            match item {
+                                Operand::String(bytes) => self.show(bytes),
+                                Operand::Number(n) if *n < -WORD_GAP => self.gap = self.gap.max(Gap::Space),
+                                _ => {}
+                            }
+                        }
+                    }
+                }
+                b"Do" if depth < MAX_FORM_DEPTH => {
+                    if let Some(Operand::Name(name)) = operands.last() {
+                        self.form(resources, name, depth)?;
+                    }
+                }
+                _ => {}
+            }
+        }
+        Ok(())
+    }
+
+    /// Moves the line matrix by (`tx`, `ty`) in its own coordinates.
+    fn translate(&mut self, tx: f64, ty: f64) {
+        let [a, b, c, d, e, f] = self.line;
+        self.line = [a, b, c, d, e + tx * a + ty * c, f + tx * b + ty * d];
+        self.moved = true;
+    }
+
+    fn show(&mut self, bytes: &[u8]) {
+        let mut shown = String::new();
+        match &self.state.font {
+            Some(font) => font.decode(bytes, &mut shown),
+            None => shown.extend(bytes.iter().map(|&b| b as char)),
+        }
+        if shown.is_empty() {
+            return;
+        }
+
+        let y = self.line[5];
+        match self.last_y {
+            Some(last) if (last - y).abs() > LINE_TOLERANCE => self.gap = Gap::Line,
+            Some(_) if self.moved => self.gap = self.gap.max(Gap::Space),
+            _ => {}
+        }
+        self.last_y = Some(y);
+        self.moved = false;
+
+        match std::mem::replace(&mut self.gap, Gap::None) {
+            Gap::Line if !self.text.is_empty() && !self.text.ends_with('\n') => self.text.push('\n'),
+            Gap::Space
+                if !self.text.is_empty()
+                    && !self.text.ends_with(char::is_whitespace)
+                    && !shown.starts_with(char::is_whitespace) =>
+            {
+                self.text.push(' ')
+            }
+            _ => {}
+        }
+        self.text.push_str(&shown);
+    }
+
+    /// Returns the font `name` in `resources`.
+    fn font(&mut self, resources: &PdfDictionary, name: &str) -> Option<Rc<Font>> {
+        let fonts = dictionary(self.reader, resources.get("Font"))?;
+        match fonts.get(name)? {
+            Object::Reference(id) => {
+                if let Some(font) = self.fonts.get(&id.number) {
+                    return Some(font.clone());
+                }
+                let font = Rc::new(Font::load(self.reader, &dictionary(self.reader, fonts.get(name))?));
+                self.fonts.insert(id.number, font.clone());
+                Some(font)
+            }
+            Object::Dictionary(dict) => Some(Rc::new(Font::load(self.reader, dict))),
+            _ => None,
+        }
+    }
+
+    /// Interprets the form XObject `name` in `resources`, if that is what it is.
+    fn form(&mut self, resources: &PdfDictionary, name: &str, depth: usize) -> Result<(), ParserError> {
+        let xobject = dictionary(self.reader, resources.get("XObject"))
+            .and_then(|xobjects| xobjects.get(name).and_then(|x| self.reader.resolve(x)));
+        let form = match xobject {
+            Some(Object::Stream(stream))
+                if matches!(stream.dictionary.get("Subtype"), Some(Object::Name(n)) if n.as_str() == "Form") =>
+            {
+                stream
+            }
+            _ => return Ok(()),
+        };
+        let content = decode_content(&form)?;
+        let form_resources = dictionary(self.reader, form.dictionary.get("Resources"));
+        let state = self.state.clone();
+        self.run(&content, form_resources.as_ref().unwrap_or(resources), depth + 1)?;
+        self.state = state;
+        Ok(())
+    }
+}
+
+/// Returns the last `N` operands, if they are all numbers.
+fn numbers<const N: usize>(operands: &[Operand]) -> Option<[f64; N]> {
+    let start = operands.len().checked_sub(N)?;
+    let mut values = [0.0; N];
+    for (value, operand) in values.iter_mut().zip(&operands[start..]) {
+        match operand {
+            Operand::Number(n) => *value = *n,
+            _ => return None,
+        }
+    }
+    Some(values)
+}
+
+/// An operand of a content stream operator.
+#[derive(Debug, Clone, PartialEq)]
+enum Operand {
+    Number(f64),
+    String(Vec<u8>),
+    Name(String),
+    Array(Vec<Operand>),
+    /// Dictionaries, booleans and the like, which text extraction ignores.
+    Other,
+}
+
+/// Splits a content stream into operators and their operands. Inline image
+/// data is skipped, and a malformed string ends the stream.
+struct Operations<'a> {
+    input: &'a [u8],
+}
+
+impl<'a> Operations<'a> {
+    fn new(input: &'a [u8]) -> Self {
+        Self { input }
+    }
+
+    /// Skips the data of an inline image, up to and including `EI`.
+    fn skip_image_data(&mut self) {
+        let data = self.input.get(1..).unwrap_or_default();
+        let is_space = |b: Option<&u8>| b.map_or(true, |b| b.is_ascii_whitespace() || *b == b'\0');
+        let end = (0..data.len().saturating_sub(1)).find(|&i| {
+            &data[i..i + 2] == b"EI" && (i == 0 || is_space(data.get(i - 1))) && is_space(data.get(i + 2))
+        });
+        self.input = match end {
+            Some(i) => &data[i + 2..],
+            None => &[],
+        };
+    }
+}
+
+impl<'a> Iterator for Operations<'a> {
+    type Item = (&'a [u8], Vec<Operand>);
+
+    fn next(&mut self) -> Option<Self::Item> {
+        let mut operands = Vec::new();
+        // Operands of the enclosing arrays while an array is read
+        let mut outer: Vec<Vec<Operand>> = Vec::new();
+        loop {
+            let input = skip_whitespace(self.input).map_or(self.input, |(rest, _)| rest);
+            let (rest, operand) = match *input.first()? {
+                b'(' => match parse_literal_string(input) {
+                    Ok((rest, bytes)) => (rest, Operand::String(bytes)),
+                    Err(_) => {
+                        self.input = &[];
+                        return None;
+                    }
+                },
+                b'<' if input.get(1) == Some(&b'<') => (&input[2..], Operand::Other),
+                b'>' => (&input[1..], Operand::Other),
+                b'<' => match parse_hex_string(input) {
+                    Ok((rest, bytes)) => (rest, Operand::String(bytes)),
+                    Err(_) => {
+                        self.input = &[];
+                        return None;
+                    }
+                },
+                b'/' => {
+                    let length = input[1..].iter().position(|&b| is_delimiter(b)).unwrap_or(input.len() - 1);
+                    let name = String::from_utf8_lossy(&input[1..1 + length]).into_owned();
+                    (&input[1 + length..], Operand::Name(name))
+                }
+                b'[' => {
+                    outer.push(std::mem::take(&mut operands));
+                    self.input = &input[1..];
+                    continue;
+                }
+                b']' => {
+                    let array = std::mem::replace(&mut operands, outer.pop().unwrap_or_default());
+                    (&input[1..], Operand::Array(array))
+                }
+                b'+' | b'-' | b'.' | b'0'..=b'9' => {
+                    let length = input.iter().position(|b| !b"+-.0123456789".contains(b)).unwrap_or(input.len());
+                    let number = std::str::from_utf8(&input[..length]).ok().and_then(|s| s.parse().ok());
+                    (&input[length..], Operand::Number(number.unwrap_or(0.0)))
+                }
+                _ => {
+                    let length = input.iter().position(|&b| is_delimiter(b)).unwrap_or(input.len());
+                    if length == 0 {
+                        // A stray delimiter
+                        (&input[1..], Operand::Other)
+                    } else {
+                        let keyword = &input[..length];
+                        self.input = &input[length..];
+                        if !outer.is_empty() || matches!(keyword, b"true" | b"false" | b"null") {
+                            operands.push(Operand::Other);
+                            continue;
+                        }
+                        if keyword == b"ID" {
+                            self.skip_image_data();
+                        }
+                        return Some((keyword, operands));
+                    }
+                }
+            };
+            operands.push(operand);
+            self.input = rest;
+        }
+    }
+}
+
+fn is_delimiter(b: u8) -> bool {
+    b.is_ascii_whitespace() || b"()<>[]{}/%\0\x0c".contains(&b)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Builds a one-page PDF showing `content` with the fonts `fonts`, each
+    /// given as the body of an object, numbered from 5 on.
+    fn pdf(content: &str, fonts: &[&str]) -> Vec<u8> {
+        let font_refs: String = (0..fonts.len()).map(|i| format!("/F{} {} 0 R ", i + 1, i + 5)).collect();
+        let mut objects = vec![
+            "<< /Type /Catalog /Pages 2 0 R >>".to_string(),
+            "<< /Type /Pages /Kids [3 0 R] /Count 1 >>".to_string(),
+            format!(
+                "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << {}>> >> /Contents 4 0 R >>",
+                font_refs
+            ),
+            format!("<< /Length {} >>\nstream\n{}\nendstream", content.len() + 1, content),
+        ];
+        objects.extend(fonts.iter().map(|f| f.to_string()));
+
+        let mut data = b"%PDF-1.4\n".to_vec();
+        let mut offsets = Vec::new();
+        for (i, object) in objects.iter().enumerate() {
+            offsets.push(data.len());
+            data.extend_from_slice(format!("{} 0 obj\n{}\nendobj\n", i + 1, object).as_bytes());
+        }
+        let xref = data.len();
+        data.extend_from_slice(format!("xref\n0 {}\n0000000000 65535 f \n", objects.len() + 1).as_bytes());
+        for offset in offsets {
+            data.extend_from_slice(format!("{:010} 00000 n \n", offset).as_bytes());
+        }
+        data.extend_from_slice(
+            format!("trailer\n<< /Size {} /Root 1 0 R >>\nstartxref\n{}\n%%EOF\n", objects.len() + 1, xref).as_bytes(),
+        );
+        data
+    }
+
+    fn extract(data: Vec<u8>) -> String {
+        PdfReader::from_bytes(data).unwrap().extract_text(0).unwrap()
+    }
+
+    #[test]
+    fn test_win_ansi_text() {
+        let font = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>";
+        let content = "BT /F1 12 Tf 72 720 Td (Caf\\351 cr\\350me) Tj 0 -14 Td (\\200 5) Tj ET";
+        assert_eq!(extract(pdf(content, &[font])), "Café crème\n€ 5");
+    }
+
+    #[test]
+    fn test_differences() {
+        let font = "<< /Type /Font /Subtype /Type1 /BaseFont /Times-Roman \
+                    /Encoding << /Type /Encoding /Differences [1 /eacute /germandbls 39 /quotesingle] >> >>";
+        let content = "BT /F1 12 Tf 72 720 Td <0102> Tj (') Tj (`) Tj ET";
+        assert_eq!(extract(pdf(content, &[font])), "éß'‘");
+    }
+
+    #[test]
+    fn test_word_gaps_and_lines() {
+        let font = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>";
+        let content = "BT /F1 12 Tf 14 TL 72 720 Td [(Hel) -20 (lo) -300 (world)] TJ T* (next) Tj \
+                       (line) ' ET BT /F1 12 Tf 200 692 Td (same) Tj ET \
+                       BI /W 2 /H 1 /BPC 8 /CS /G ID \x01EI EI BT /F1 12 Tf 1 0 0 1 72 600 Tm (last) Tj ET";
+        assert_eq!(extract(pdf(content, &[font])), "Hello world\nnext\nline same\nlast");
+    }
+
+    #[test]
+    fn test_type0_to_unicode() {
+        let cmap = "1 begincodespacerange <0000> <FFFF> endcodespacerange \
+                    1 beginbfrange <0001> <0003> <00E0> endbfrange";
+        let fonts = [
+            "<< /Type /Font /Subtype /Type0 /BaseFont /Test /Encoding /Identity-H /ToUnicode 6 0 R >>".to_string(),
+            format!("<< /Length {} >>\nstream\n{}\nendstream", cmap.len(), cmap),
+        ];
+        let font_refs: Vec<&str> = fonts.iter().map(String::as_str).collect();
+        let content = "BT /F1 12 Tf 72 720 Td <000100020003 0009> Tj ET";
+        assert_eq!(extract(pdf(content, &font_refs)), "àáâ\u{FFFD}");
+    }
+
+    #[test]
+    fn test_generated_documents() {
+        use crate::content::{ContentBuilder, TextBuilder};
+        use crate::font::{Font as PdfFont, TrueTypeFont};
+        use crate::prelude::*;
+
+        let font = TrueTypeFont::from_bytes(crate::font::test_font_bytes()).unwrap();
+        let plain = PageBuilder::a4()
+            .helvetica()
+            .content(ContentBuilder::new().text("F1", 12.0, 72.0, 720.0, "Plain text"))
+            .build();
+        let block = TextBuilder::new().font("TT1", 12.0).position(72.0, 700.0).show_hex(font.encode("A\u{416}B"));
+        let embedded = PageBuilder::a4()
+            .font("TT1", PdfFont::TrueType(font))
+            .content(ContentBuilder::new().text_block(block))
+            .build();
+        let doc = DocumentBuilder::new().page(plain).page(embedded).build().unwrap();
+        let reader = PdfReader::from_bytes(doc.save_to_bytes().unwrap()).unwrap();
+        assert_eq!(reader.extract_text(0).unwrap(), "Plain text");
+        assert_eq!(reader.extract_text(1).unwrap(), "A\u{416}B");
+        assert!(reader.extract_text(2).is_err());
+    }
+
+    #[test]
+    fn test_shaped_text() {
+        use crate::content::{ContentBuilder, TextBuilder};
+        use crate::font::{Font as PdfFont, Shaping, TrueTypeFont};
+        use crate::prelude::*;
+
+        // Kerned glyphs are shown from a TJ array, and the ligature of "AB"
+        // extracts as both letters
+        let font = TrueTypeFont::from_bytes(crate::font::shaping_test_font_bytes()).unwrap();
+        let kerned = font.shape("ABA", Shaping { kerning: true, ligatures: false });
+        let ligature = font.shape("AB", Shaping { kerning: false, ligatures: true });
+        let block = TextBuilder::new()
+            .font("TT1", 12.0)
+            .position(72.0, 700.0)
+            .show_shaped(&kerned, font.units_per_em())
+            .show_shaped(&ligature, font.units_per_em());
+        let page = PageBuilder::a4()
+            .font("TT1", PdfFont::TrueType(font))
+            .content(ContentBuilder::new().text_block(block))
+            .build();
+        let bytes = DocumentBuilder::new().page(page).build().unwrap().save_to_bytes().unwrap();
+        let reader = PdfReader::from_bytes(bytes).unwrap();
+        assert_eq!(reader.extract_text(0).unwrap(), "ABAAB");
+    }
+}
@@ -0,0 +1,199 @@
+//! Appending objects to a parsed document as an incremental update.
+
+use super::PdfReader;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream};
+use crate::types::ObjectId;
+use crate::writer::Serializer;
+use std::io;
+
+/// Writes new objects, and new revisions of existing ones, after the bytes of
+/// a parsed file, then a cross-reference section pointing back to the last
+/// one. The original bytes are kept unchanged.
+pub(crate) struct IncrementalWriter {
+    /// The original file, ending with a line break.
+    data: Vec<u8>,
+    update: Serializer<Vec<u8>>,
+    /// Where each object written so far starts in the finished file.
+    offsets: Vec<(ObjectId, u64)>,
+    /// Number of the next new object.
+    next: u32,
+    /// Entries carried over from the last trailer.
+    trailer: PdfDictionary,
+    /// Whether the last section is a cross-reference stream rather than a table.
+    xref_stream: bool,
+}
+
+impl IncrementalWriter {
+    /// Starts an update of the file `reader` has parsed.
+    pub(crate) fn new(reader: &PdfReader) -> Self {
+        let mut data = reader.raw_data().to_vec();
+        if !data.ends_with(b"\n") && !data.ends_with(b"\r") {
+            data.push(b'\n');
+        }
+        let last = reader.trailer();
+        let mut trailer = PdfDictionary::new();
+        trailer.set("Root", Object::Reference(last.root));
+        if let Some(info) = last.info {
+            trailer.set("Info", Object::Reference(info));
+        }
+        if let Some(id) = last.dict.get("ID") {
+            trailer.set("ID", id.clone());
+        }
+        trailer.set("Prev", Object::Integer(reader.startxref() as i64));
+        Self {
+            data,
+            update: Serializer::new(Vec::new()),
+            offsets: Vec::new(),
+            next: last.size,
+            trailer,
+            xref_stream: !reader.raw_data()[reader.startxref() as usize..].starts_with(b"xref"),
+        }
+    }
+
+    /// Allocates the number of a new object.
+    pub(crate) fn allocate(&mut self) -> ObjectId {
+        self.next += 1;
+        ObjectId::new(self.next - 1)
+    }
+
+    /// Returns the offset in the finished file of the next byte written.
+    pub(crate) fn position(&self) -> u64 {
+        self.data.len() as u64 + self.update.position()
+    }
+
+    /// Writes `object` as object `id`, replacing any earlier revision.
+    pub(crate) fn write_object(&mut self, id: ObjectId, object: &Object) -> io::Result<()> {
+        let offset = self.position();
+        self.update.write_object(id, object)?;
+        self.offsets.push((id, offset));
+        Ok(())
+    }
+
+    /// Starts object `id`, whose body and `endobj` the caller writes with
+    /// `write_bytes`.
+    #[cfg(feature = "signatures")]
+    pub(crate) fn begin_object(&mut self, id: ObjectId) -> io::Result<()> {
+        self.offsets.push((id, self.position()));
+        self.update.write_str(&format!("{}\n", id.definition_string()))
+    }
+
+    /// Writes raw bytes.
+    #[cfg(feature = "signatures")]
+    pub(crate) fn write_bytes(&mut self, bytes: &[u8]) -> io::Result<()> {
+        self.update.write_bytes(bytes)
+    }
+
+    /// Points the trailer at a new document information dictionary.
+    pub(crate) fn set_info(&mut self, id: ObjectId) {
+        self.trailer.set("Info", Object::Reference(id));
+    }
+
+    /// Writes the cross-reference section and returns the whole file.
+    ///
+    /// The section is of the same kind as the last one, since readers that
+    /// only know tables cannot follow a stream, and a table cannot point to
+    /// the objects in object streams.
+    pub(crate) fn finish(mut self) -> io::Result<Vec<u8>> {
+        let xref_offset = self.position();
+        let mut trailer = std::mem::take(&mut self.trailer);
+        if self.xref_stream {
+            let xref_id = self.allocate();
+            self.offsets.push((xref_id, xref_offset));
+        }
+        self.offsets.sort_by_key(|(id, _)| id.number);
+        // A later revision of an object replaces the earlier one
+        self.offsets.reverse();
+        self.offsets.dedup_by_key(|(id, _)| id.number);
+        self.offsets.reverse();
+        trailer.set("Size", Object::Integer(self.next as i64));
+
+        if !self.xref_stream {
+            let mut table = String::from("xref\n");
+            for run in runs(&self.offsets) {
+                table.push_str(&format!("{} {}\n", run[0].0.number, run.len()));
+                for (id, offset) in run {
+                    table.push_str(&format!("{:010} {:05} n \n", offset, id.generation));
+                }
+            }
+            self.update.write_str(&table)?;
+            self.update.write_str(&format!("trailer\n{}\n", trailer.to_pdf_string()))?;
+        } else {
+            let width = if xref_offset > u32::MAX as u64 { 8 } else { 4 };
+            let mut index = PdfArray::new();
+            let mut rows = Vec::new();
+            for run in runs(&self.offsets) {
+                index.push(Object::Integer(run[0].0.number as i64));
+                index.push(Object::Integer(run.len() as i64));
+                for (id, offset) in run {
+                    rows.push(1);
+                    rows.extend_from_slice(&offset.to_be_bytes()[8 - width..]);
+                    rows.extend_from_slice(&id.generation.to_be_bytes());
+                }
+            }
+            trailer.set("Type", Object::Name(PdfName::new_unchecked("XRef")));
+            let widths = [1, width as i64, 2].into_iter().map(Object::Integer).collect();
+            trailer.set("W", Object::Array(widths));
+            trailer.set("Index", Object::Array(index));
+            let xref_id = ObjectId::new(self.next - 1);
+            let stream = PdfStream::with_dictionary(trailer, rows);
+            self.update.write_object(xref_id, &Object::Stream(stream))?;
+        }
+        self.update.write_startxref(xref_offset)?;
+
+        self.data.extend_from_slice(&self.update.into_inner());
+        Ok(self.data)
+    }
+}
+
+/// Splits entries sorted by object number into runs of consecutive numbers,
+/// as cross-reference subsections list them.
+fn runs(offsets: &[(ObjectId, u64)]) -> Vec<&[(ObjectId, u64)]> {
+    let mut runs = Vec::new();
+    let mut start = 0;
+    for i in 1..=offsets.len() {
+        if i == offsets.len() || offsets[i].0.number != offsets[i - 1].0.number + 1 {
+            runs.push(&offsets[start..i]);
+            start = i;
+        }
+    }
+    runs
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::prelude::*;
+
+    #[test]
+    fn test_incremental_writer() {
+        for object_streams in [false, true] {
+            let page = PageBuilder::a4().build();
+            let doc = DocumentBuilder::new().page(page).object_streams(object_streams).build().unwrap();
+            let original = doc.save_to_bytes().unwrap();
+            let reader = PdfReader::from_bytes(original.clone()).unwrap();
+
+            let mut update = IncrementalWriter::new(&reader);
+            let id = update.allocate();
+            assert_eq!(id.number, reader.trailer().size);
+            update.write_object(id, &Object::Integer(42)).unwrap();
+            let root = reader.trailer().root;
+            let mut catalog = reader.catalog().unwrap();
+            catalog.set("Answer", Object::Reference(id));
+            update.write_object(root, &Object::Dictionary(catalog)).unwrap();
+            let updated = update.finish().unwrap();
+            assert!(updated.starts_with(&original));
+
+            let reader = PdfReader::from_bytes(updated).unwrap();
+            assert_eq!(reader.catalog().unwrap().get("Answer"), Some(&Object::Reference(id)));
+            assert_eq!(reader.resolve_reference(id), Some(Object::Integer(42)));
+            assert_eq!(reader.page_count(), 1);
+        }
+    }
+
+    #[test]
+    fn test_runs() {
+        let entries: Vec<(ObjectId, u64)> = [3, 4, 5, 9, 11, 12].iter().map(|&n| (ObjectId::new(n), 0)).collect();
+        let lengths: Vec<usize> = runs(&entries).iter().map(|run| run.len()).collect();
+        assert_eq!(lengths, [3, 1, 2]);
+    }
+}
@@ -0,0 +1,257 @@
+//! Glyph outlines from the `glyf` table of TrueType fonts.
+
+use super::raster::{curve_to, Point, Subpath};
+use crate::font::TrueTypeFont;
+
+/// Composite glyphs nested deeper than this are not drawn.
+const MAX_DEPTH: usize = 8;
+
+/// An outline point in font units, and whether it is on the curve.
+type OutlinePoint = ((f64, f64), bool);
+
+/// Returns the outline of a glyph as device-space subpaths, mapping font
+/// units through `transform`. Glyphs with CFF outlines, or none, are empty.
+pub(super) fn outline(font: &TrueTypeFont, gid: u16, transform: impl Fn(f64, f64) -> Point) -> Vec<Subpath> {
+    let mut contours = Vec::new();
+    collect(font, gid, [1.0, 0.0, 0.0, 1.0, 0.0, 0.0], 0, &mut contours);
+    contours
+        .iter()
+        .filter(|contour| !contour.is_empty())
+        .map(|contour| to_subpath(contour, &transform))
+        .collect()
+}
+
+/// Appends the contours of a glyph, mapped through the affine `matrix`.
+fn collect(font: &TrueTypeFont, gid: u16, matrix: [f64; 6], depth: usize, contours: &mut Vec<Vec<OutlinePoint>>) {
+    let data = font.glyph_data(gid);
+    if data.len() < 10 || depth > MAX_DEPTH {
+        return;
+    }
+    let count = i16::from_be_bytes([data[0], data[1]]);
+    if count >= 0 {
+        if let Some(simple) = simple_glyph(data, count as usize) {
+            let [a, b, c, d, e, f] = matrix;
+            contours.extend(simple.into_iter().map(|contour| {
+                contour
+                    .into_iter()
+                    .map(|((x, y), on)| ((a * x + c * y + e, b * x + d * y + f), on))
+                    .collect()
+            }));
+        }
+        return;
+    }
+    for (component, local) in components(data) {
+        let [a, b, c, d, e, f] = local;
+        let [ma, mb, mc, md, me, mf] = matrix;
+        let combined = [
+            a * ma + b * mc,
+            a * mb + b * md,
+            c * ma + d * mc,
+            c * mb + d * md,
+            e * ma + f * mc + me,
+            e * mb + f * md + mf,
+        ];
+        collect(font, component, combined, depth + 1, contours);
+    }
+}
+
+/// Reads a big-endian u16 at `pos`, advancing it.
+fn read_u16(data: &[u8], pos: &mut usize) -> Option<u16> {
+    let bytes = data.get(*pos..*pos + 2)?;
+    *pos += 2;
+    Some(u16::from_be_bytes([bytes[0], bytes[1]]))
+}
+
+/// Parses the contours of a simple glyph.
+fn simple_glyph(data: &[u8], count: usize) -> Option<Vec<Vec<OutlinePoint>>> {
+    const ON_CURVE: u8 = 0x01;
+    const X_SHORT: u8 = 0x02;
+    const Y_SHORT: u8 = 0x04;
+    const REPEAT: u8 = 0x08;
+    const X_SAME_OR_POSITIVE: u8 = 0x10;
+    const Y_SAME_OR_POSITIVE: u8 = 0x20;
+
+    let mut pos = 10;
+    let mut ends = Vec::with_capacity(count);
+    for _ in 0..count {
+        ends.push(read_u16(data, &mut pos)? as usize);
+    }
+    let points = match ends.last() {
+        Some(&last) => last + 1,
+        None => return Some(Vec::new()),
+    };
+    pos += read_u16(data, &mut pos)? as usize;
+
+    let mut flags = Vec::with_capacity(points);
+    while flags.len() < points {
+        let flag = *data.get(pos)?;
+        pos += 1;
+        flags.push(flag);
+        if flag & REPEAT != 0 {
+            let repeat = *data.get(pos)?;
+            pos += 1;
+            flags.extend(std::iter::repeat_n(flag, repeat as usize));
+        }
+    }
+    flags.truncate(points);
+
+    let mut coordinates = |short: u8, same: u8| -> Option<Vec<f64>> {
+        let mut value = 0i32;
+        let mut values = Vec::with_capacity(points);
+        for &flag in &flags {
+            if flag & short != 0 {
+                let delta = *data.get(pos)? as i32;
+                pos += 1;
+                value += if flag & same != 0 { delta } else { -delta };
+            } else if flag & same == 0 {
+                value += read_u16(data, &mut pos)? as i16 as i32;
+            }
+            values.push(value as f64);
+        }
+        Some(values)
+    };
+    let xs = coordinates(X_SHORT, X_SAME_OR_POSITIVE)?;
+    let ys = coordinates(Y_SHORT, Y_SAME_OR_POSITIVE)?;
+
+    let mut contours = Vec::with_capacity(count);
+    let mut start = 0;
+    for end in ends {
+        if end < start || end >= points {
+            return None;
+        }
+        contours.push((start..=end).map(|i| ((xs[i], ys[i]), flags[i] & ON_CURVE != 0)).collect());
+        start = end + 1;
+    }
+    Some(contours)
+}
+
+/// Returns the glyphs a composite glyph is built from, each with the affine
+/// matrix that places it.
+fn components(data: &[u8]) -> Vec<(u16, [f64; 6])> {
+    const ARG_1_AND_2_ARE_WORDS: u16 = 0x0001;
+    const ARGS_ARE_XY_VALUES: u16 = 0x0002;
+    const WE_HAVE_A_SCALE: u16 = 0x0008;
+    const MORE_COMPONENTS: u16 = 0x0020;
+    const WE_HAVE_AN_X_AND_Y_SCALE: u16 = 0x0040;
+    const WE_HAVE_A_TWO_BY_TWO: u16 = 0x0080;
+
+    let f2dot14 = |v: u16| v as i16 as f64 / 16384.0;
+    let mut result = Vec::new();
+    let mut pos = 10;
+    while let (Some(flags), Some(gid)) = (read_u16(data, &mut pos), read_u16(data, &mut pos)) {
+        let (dx, dy) = if flags & ARG_1_AND_2_ARE_WORDS != 0 {
+            match (read_u16(data, &mut pos), read_u16(data, &mut pos)) {
+                (Some(x), Some(y)) => (x as i16 as f64, y as i16 as f64),
+                _ => break,
+            }
+        } else {
+            match data.get(pos..pos + 2) {
+                Some(args) => {
+                    pos += 2;
+                    (args[0] as i8 as f64, args[1] as i8 as f64)
+                }
+                None => break,
+            }
+        };
+        let mut next = || read_u16(data, &mut pos).map(f2dot14).unwrap_or(0.0);
+        let [a, b, c, d] = if flags & WE_HAVE_A_SCALE != 0 {
+            let scale = next();
+            [scale, 0.0, 0.0, scale]
+        } else if flags & WE_HAVE_AN_X_AND_Y_SCALE != 0 {
+            let (x, y) = (next(), next());
+            [x, 0.0, 0.0, y]
+        } else if flags & WE_HAVE_A_TWO_BY_TWO != 0 {
+            [next(), next(), next(), next()]
+        } else {
+            [1.0, 0.0, 0.0, 1.0]
+        };
+        // Components aligned by point numbers are drawn unshifted
+        let (e, f) = if flags & ARGS_ARE_XY_VALUES != 0 { (dx, dy) } else { (0.0, 0.0) };
+        result.push((gid, [a, b, c, d, e, f]));
+        if flags & MORE_COMPONENTS == 0 {
+            break;
+        }
+    }
+    result
+}
+
+/// Converts a contour of quadratic B-spline points to a flattened subpath.
+fn to_subpath(contour: &[OutlinePoint], transform: &impl Fn(f64, f64) -> Point) -> Subpath {
+    let midpoint = |a: (f64, f64), b: (f64, f64)| ((a.0 + b.0) / 2.0, (a.1 + b.1) / 2.0);
+    let device = |p: (f64, f64)| transform(p.0, p.1);
+
+    // Start on the curve: at the first on-curve point, or between two
+    // off-curve points when there is none
+    let first_on = contour.iter().position(|&(_, on)| on);
+    let (start, rotation) = match first_on {
+        Some(i) => (contour[i].0, i),
+        None => (midpoint(contour[0].0, contour[contour.len() - 1].0), 0),
+    };
+    let mut points = vec![device(start)];
+    let mut current = start;
+    let mut control: Option<(f64, f64)> = None;
+    let n = contour.len();
+    for k in 1..=n {
+        let (p, on) = contour[(rotation + k) % n];
+        let p = if first_on.is_none() && k == n { start } else { p };
+        let on = on || (first_on.is_none() && k == n);
+        match (control, on) {
+            (None, true) => {
+                points.push(device(p));
+                current = p;
+            }
+            (None, false) => control = Some(p),
+            (Some(c), true) => {
+                quad_to(&mut points, current, c, p, &device);
+                current = p;
+                control = None;
+            }
+            (Some(c), false) => {
+                let mid = midpoint(c, p);
+                quad_to(&mut points, current, c, mid, &device);
+                current = mid;
+                control = Some(p);
+            }
+        }
+    }
+    if let Some(c) = control {
+        quad_to(&mut points, current, c, start, &device);
+    }
+    Subpath { points, closed: true }
+}
+
+/// Appends a quadratic curve, as the equivalent cubic.
+fn quad_to(points: &mut Vec<Point>, from: (f64, f64), control: (f64, f64), to: (f64, f64), device: &impl Fn((f64, f64)) -> Point) {
+    let c1 = (from.0 + 2.0 / 3.0 * (control.0 - from.0), from.1 + 2.0 / 3.0 * (control.1 - from.1));
+    let c2 = (to.0 + 2.0 / 3.0 * (control.0 - to.0), to.1 + 2.0 / 3.0 * (control.1 - to.1));
+    curve_to(points, device(c1), device(c2), device(to));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_outline() {
+        let font = TrueTypeFont::from_bytes(crate::font::test_font_bytes()).unwrap();
+        // The test font's A is a triangle 600 units wide and 700 tall
+        let a = outline(&font, 1, |x, y| (x, y));
+        assert_eq!(a.len(), 1);
+        assert_eq!(a[0].points, [(0.0, 0.0), (300.0, 700.0), (600.0, 0.0), (0.0, 0.0)]);
+
+        // Zhe is a composite of A, so it has the same outline
+        let zhe = outline(&font, 3, |x, y| (x / 10.0, -y / 10.0));
+        assert_eq!(zhe[0].points, [(0.0, 0.0), (30.0, -70.0), (60.0, 0.0), (0.0, 0.0)]);
+        assert!(outline(&font, 0, |x, y| (x, y)).is_empty());
+    }
+
+    #[test]
+    fn test_quadratic_contour() {
+        // An on-curve point between two off-curve points on each side
+        let contour = [((0.0, 0.0), true), ((10.0, 0.0), false), ((10.0, 10.0), false)];
+        let subpath = to_subpath(&contour, &|x, y| (x, y));
+        assert_eq!(subpath.points[0], (0.0, 0.0));
+        assert!(subpath.points.contains(&(10.0, 5.0)));
+        assert_eq!(*subpath.points.last().unwrap(), (0.0, 0.0));
+    }
+}
@@ -0,0 +1,836 @@
+//! Rendering pages to PNG images.
+//!
+//! The renderer draws the content stream of a [`Page`] built with this
+//! crate: paths, colors, clipping, dashes, images and text in embedded
+//! TrueType fonts. The standard 14 fonts have no outlines to draw, so their
+//! text is shown as grey bars the width of each word, which is enough for
+//! thumbnails. Form fields, links, transparency, gradients and imported
+//! pages are not drawn, nor are the watermarks and page numbers a
+//! [`Document`] adds when it is written. Every layer is drawn, whether or not
+//! it is shown.
+//!
+//! [`Document`]: crate::Document
+
+mod glyph;
+mod png;
+mod raster;
+
+use std::rc::Rc;
+
+use crate::color::Color;
+use crate::content::{Operator, TextElement};
+use crate::error::{PdfError, PdfResult, RenderError};
+use crate::font::{helvetica_char_width, Font, Standard14Font, TrueTypeFont};
+use crate::page::{Page, Thumbnail};
+use crate::types::Matrix;
+use raster::{LineCap, Mask, Subpath};
+
+/// The largest image, in pixels, that will be rendered.
+pub const MAX_PIXELS: u64 = 1 << 25;
+
+/// Number of operators drawn between calls to the progress callback of
+/// [`render_page_with_progress`].
+pub const PROGRESS_INTERVAL: usize = 256;
+
+/// How strongly greeked text is drawn, relative to its fill color.
+const GREEKING_OPACITY: f32 = 0.5;
+
+/// Renders a page at `dpi` pixels per inch and returns it as a PNG file.
+///
+/// # Example
+///
+/// ```rust
+/// use rust_pdf::prelude::*;
+///
+/// let page = PageBuilder::a4()
+///     .content(ContentBuilder::new().rect(72.0, 72.0, 144.0, 72.0).fill())
+///     .build();
+/// let png = rust_pdf::render::render_page(&page, 36.0).unwrap();
+/// assert!(png.starts_with(b"\x89PNG"));
+/// ```
+pub fn render_page(page: &Page, dpi: f64) -> PdfResult<Vec<u8>> {
+    render_page_with_progress(page, dpi, |_| true)
+}
+
+/// Renders a page like [`render_page`], calling `progress` with the fraction
+/// of the content stream drawn every [`PROGRESS_INTERVAL`] operators. If
+/// `progress` returns false rendering stops with [`PdfError::Cancelled`].
+pub fn render_page_with_progress(page: &Page, dpi: f64, progress: impl FnMut(f64) -> bool) -> PdfResult<Vec<u8>> {
+    if !(dpi > 0.0 && dpi.is_finite()) {
+        return Err(RenderError::InvalidResolution(dpi).into());
+    }
+    let scale = dpi / 72.0;
+    let (page_width, page_height) = displayed_size(page);
+    let width = (page_width * scale).ceil().max(1.0) as u64;
+    let height = (page_height * scale).ceil().max(1.0) as u64;
+    let pixels = rasterize(page, scale, width, height, progress)?;
+    Ok(png::encode(width as u32, height as u32, &pixels, dpi)?)
+}
+
+/// Renders a page as its [`Thumbnail`], scaled so that its longer side is
+/// `max_dimension` pixels long.
+pub fn render_thumbnail(page: &Page, max_dimension: u32) -> PdfResult<Thumbnail> {
+    let (page_width, page_height) = displayed_size(page);
+    let longest = page_width.max(page_height);
+    if max_dimension == 0 || !(longest > 0.0) {
+        return Err(RenderError::InvalidThumbnailSize(max_dimension).into());
+    }
+    let scale = f64::from(max_dimension) / longest;
+    let size = |length: f64| (length * scale).round().clamp(1.0, f64::from(max_dimension)) as u64;
+    let (width, height) = (size(page_width), size(page_height));
+    let pixels = rasterize(page, scale, width, height, |_| true)?;
+    Thumbnail::from_rgb(width as u32, height as u32, pixels)
+        .ok_or_else(|| RenderError::InvalidThumbnailSize(max_dimension).into())
+}
+
+/// Returns the width and height of a page as a viewer displays it, turned
+/// by its rotation.
+fn displayed_size(page: &Page) -> (f64, f64) {
+    let media = page.media_box;
+    match page.rotation.rem_euclid(360) {
+        90 | 270 => (media.height(), media.width()),
+        _ => (media.width(), media.height()),
+    }
+}
+
+/// Draws a page `scale` pixels per point into a `width` by `height` image,
+/// returning its rows of RGB triples from the top.
+fn rasterize(
+    page: &Page,
+    scale: f64,
+    width: u64,
+    height: u64,
+    mut progress: impl FnMut(f64) -> bool,
+) -> PdfResult<Vec<u8>> {
+    if width.saturating_mul(height) > MAX_PIXELS {
+        return Err(RenderError::TooLarge { width, height, limit: MAX_PIXELS }.into());
+    }
+    let media = page.media_box;
+    let rotation = page.rotation.rem_euclid(360);
+
+    let mut renderer = Renderer::new(page, width as usize, height as usize);
+    // The page is turned clockwise as a viewer displays it
+    renderer.state.ctm = match rotation {
+        90 => Matrix::new(0.0, scale, scale, 0.0, -media.lly * scale, -media.llx * scale),
+        180 => Matrix::new(-scale, 0.0, 0.0, scale, media.urx * scale, -media.lly * scale),
+        270 => Matrix::new(0.0, -scale, -scale, 0.0, media.ury * scale, media.urx * scale),
+        _ => Matrix::new(scale, 0.0, 0.0, -scale, -media.llx * scale, media.ury * scale),
+    };
+    if let Some(background) = page.background_content() {
+        for operator in background.operators() {
+            renderer.run(operator);
+        }
+    }
+    let operators = page.content.operators();
+    for (i, operator) in operators.iter().enumerate() {
+        if i % PROGRESS_INTERVAL == 0 && !progress(i as f64 / operators.len() as f64) {
+            return Err(PdfError::Cancelled);
+        }
+        renderer.run(operator);
+    }
+    Ok(renderer.pixels)
+}
+
+/// The graphics state saved and restored by `q` and `Q`.
+#[derive(Debug, Clone)]
+struct State {
+    /// Maps user space to device pixels.
+    ctm: Matrix,
+    fill: [f32; 3],
+    stroke: [f32; 3],
+    line_width: f64,
+    line_cap: LineCap,
+    dash: (Vec<f64>, f64),
+    /// Coverage of each pixel by the clipping path, or `None` for the whole page.
+    clip: Option<Rc<Vec<f32>>>,
+    font: Option<String>,
+    font_size: f64,
+    char_spacing: f64,
+    word_spacing: f64,
+    horizontal_scaling: f64,
+    leading: f64,
+    rise: f64,
+    render_mode: i32,
+}
+
+impl Default for State {
+    fn default() -> Self {
+        Self {
+            ctm: Matrix::identity(),
+            fill: [0.0; 3],
+            stroke: [0.0; 3],
+            line_width: 1.0,
+            line_cap: LineCap::Butt,
+            dash: (Vec::new(), 0.0),
+            clip: None,
+            font: None,
+            font_size: 0.0,
+            char_spacing: 0.0,
+            word_spacing: 0.0,
+            horizontal_scaling: 1.0,
+            leading: 0.0,
+            rise: 0.0,
+            render_mode: 0,
+        }
+    }
+}
+
+/// Runs content stream operators, painting into an RGB buffer.
+struct Renderer<'a> {
+    page: &'a Page,
+    width: usize,
+    height: usize,
+    /// Rows of RGB triples from the top, initially white.
+    pixels: Vec<u8>,
+    state: State,
+    saved: Vec<State>,
+    /// The path under construction, in device space.
+    path: Vec<Subpath>,
+    /// The clipping rule requested by `W` or `W*` for the current path.
+    pending_clip: Option<bool>,
+    text_matrix: Matrix,
+    line_matrix: Matrix,
+}
+
+impl<'a> Renderer<'a> {
+    fn new(page: &'a Page, width: usize, height: usize) -> Self {
+        Self {
+            page,
+            width,
+            height,
+            pixels: vec![255; width * height * 3],
+            state: State::default(),
+            saved: Vec::new(),
+            path: Vec::new(),
+            pending_clip: None,
+            text_matrix: Matrix::identity(),
+            line_matrix: Matrix::identity(),
+        }
+    }
+
+    fn run(&mut self, operator: &Operator) {
+        match operator {
+            Operator::SaveState => self.saved.push(self.state.clone()),
+            Operator::RestoreState => {
+                if let Some(state) = self.saved.pop() {
+                    self.state = state;
+                }
+            }
+            &Operator::ConcatMatrix(a, b, c, d, e, f) => {
+                self.state.ctm = Matrix::new(a, b, c, d, e, f).multiply(&self.state.ctm);
+            }
+            &Operator::SetLineWidth(width) => self.state.line_width = width,
+            &Operator::SetLineCap(cap) => {
+                self.state.line_cap = match cap {
+                    1 => LineCap::Round,
+                    2 => LineCap::Square,
+                    _ => LineCap::Butt,
+                }
+            }
+            Operator::SetDashPattern(array, phase) => self.state.dash = (array.clone(), *phase),
+
+            &Operator::SetGrayFill(g) => self.state.fill = rgb(Color::gray(g)),
+            &Operator::SetGrayStroke(g) => self.state.stroke = rgb(Color::gray(g)),
+            &Operator::SetRgbFill(r, g, b) => self.state.fill = rgb(Color::rgb(r, g, b)),
+            &Operator::SetRgbStroke(r, g, b) => self.state.stroke = rgb(Color::rgb(r, g, b)),
+            &Operator::SetCmykFill(c, m, y, k) => self.state.fill = rgb(Color::cmyk(c, m, y, k)),
+            &Operator::SetCmykStroke(c, m, y, k) => self.state.stroke = rgb(Color::cmyk(c, m, y, k)),
+
+            &Operator::MoveTo(x, y) => {
+                let point = self.state.ctm.transform_point(x, y);
+                self.path.push(Subpath { points: vec![point], closed: false });
+            }
+            &Operator::LineTo(x, y) => {
+                let point = self.state.ctm.transform_point(x, y);
+                if let Some(subpath) = self.path.last_mut() {
+                    subpath.points.push(point);
+                }
+            }
+            &Operator::CurveTo(x1, y1, x2, y2, x3, y3) => self.curve(Some((x1, y1)), Some((x2, y2)), (x3, y3)),
+            &Operator::CurveToV(x2, y2, x3, y3) => self.curve(None, Some((x2, y2)), (x3, y3)),
+            &Operator::CurveToY(x1, y1, x3, y3) => self.curve(Some((x1, y1)), None, (x3, y3)),
+            Operator::ClosePath => self.close_path(),
+            &Operator::Rectangle(x, y, w, h) => {
+                let ctm = self.state.ctm;
+                let corners = [(x, y), (x + w, y), (x + w, y + h), (x, y + h)];
+                self.path.push(Subpath {
+                    points: corners.iter().map(|&(x, y)| ctm.transform_point(x, y)).collect(),
+                    closed: true,
+                });
+            }
+
+            Operator::Stroke => self.paint(None, true),
+            Operator::CloseAndStroke => {
+                self.close_path();
+                self.paint(None, true);
+            }
+            Operator::Fill => self.paint(Some(false), false),
+            Operator::FillEvenOdd => self.paint(Some(true), false),
+            Operator::FillAndStroke => self.paint(Some(false), true),
+            Operator::FillAndStrokeEvenOdd => self.paint(Some(true), true),
+            Operator::CloseFillAndStroke => {
+                self.close_path();
+                self.paint(Some(false), true);
+            }
+            Operator::CloseFillAndStrokeEvenOdd => {
+                self.close_path();
+                self.paint(Some(true), true);
+            }
+            Operator::EndPath => self.paint(None, false),
+            Operator::Clip => self.pending_clip = Some(false),
+            Operator::ClipEvenOdd => self.pending_clip = Some(true),
+
+            Operator::BeginText => {
+                self.text_matrix = Matrix::identity();
+                self.line_matrix = Matrix::identity();
+            }
+            Operator::EndText => {}
+            &Operator::SetCharacterSpacing(spacing) => self.state.char_spacing = spacing,
+            &Operator::SetWordSpacing(spacing) => self.state.word_spacing = spacing,
+            &Operator::SetHorizontalScaling(scale) => self.state.horizontal_scaling = scale / 100.0,
+            &Operator::SetLeading(leading) => self.state.leading = leading,
+            Operator::SetFont(name, size) => {
+                self.state.font = Some(name.clone());
+                self.state.font_size = *size;
+            }
+            &Operator::SetTextRenderingMode(mode) => self.state.render_mode = mode,
+            &Operator::SetTextRise(rise) => self.state.rise = rise,
+            &Operator::MoveText(tx, ty) => self.move_text(tx, ty),
+            &Operator::MoveTextSetLeading(tx, ty) => {
+                self.state.leading = -ty;
+                self.move_text(tx, ty);
+            }
+            &Operator::SetTextMatrix(a, b, c, d, e, f) => {
+                self.text_matrix = Matrix::new(a, b, c, d, e, f);
+                self.line_matrix = self.text_matrix;
+            }
+            Operator::NextLine => self.move_text(0.0, -self.state.leading),
+            Operator::ShowText(text) => self.show_text(text),
+            Operator::ShowHexText(bytes) => self.show_glyphs(bytes),
+            Operator::ShowTextPositioned(elements) => {
+                for element in elements {
+                    match element {
+                        TextElement::Text(text) => self.show_text(text),
+                        TextElement::Hex(bytes) => self.show_glyphs(bytes),
+                        &TextElement::Position(adjust) => {
+                            let shift = -adjust / 1000.0 * self.state.font_size * self.state.horizontal_scaling;
+                            self.advance(shift);
+                        }
+                    }
+                }
+            }
+            Operator::NextLineShowText(text) => {
+                self.move_text(0.0, -self.state.leading);
+                self.show_text(text);
+            }
+            Operator::NextLineShowTextSpacing(word, char, text) => {
+                self.state.word_spacing = *word;
+                self.state.char_spacing = *char;
+                self.move_text(0.0, -self.state.leading);
+                self.show_text(text);
+            }
+            Operator::PaintXObject(name) => self.paint_xobject(name),
+
+            // Gradients are not drawn, so shapes filled with one keep the previous fill color
+            Operator::SetFillColorSpace(_) | Operator::SetFillPattern(_) => {}
+            // Marked content only labels what it encloses, so layers are drawn whether or not they are shown
+            Operator::BeginMarkedContent(_)
+            | Operator::BeginMarkedContentId(..)
+            | Operator::BeginMarkedContentProperties(..)
+            | Operator::EndMarkedContent => {}
+            // Line joins and the miter limit do not change the outline enough
+            // to matter at thumbnail sizes; graphics state resources and raw
+            // operators are not interpreted
+            Operator::SetLineJoin(_) | Operator::SetMiterLimit(_) | Operator::SetExtGState(_) | Operator::Raw(_) => {}
+        }
+    }
+
+    /// Appends a cubic curve. A missing first control point is the current
+    /// point (for `v`), and a missing second one the end point (for `y`).
+    fn curve(&mut self, c1: Option<(f64, f64)>, c2: Option<(f64, f64)>, end: (f64, f64)) {
+        let ctm = self.state.ctm;
+        let subpath = match self.path.last_mut() {
+            Some(subpath) => subpath,
+            None => return,
+        };
+        let current = match subpath.points.last() {
+            Some(&p) => p,
+            None => return,
+        };
+        let device = |(x, y): (f64, f64)| ctm.transform_point(x, y);
+        let c1 = c1.map(device).unwrap_or(current);
+        let c2 = device(c2.unwrap_or(end));
+        raster::curve_to(&mut subpath.points, c1, c2, device(end));
+    }
+
+    fn close_path(&mut self) {
+        if let Some(subpath) = self.path.last_mut() {
+            subpath.closed = true;
+        }
+    }
+
+    /// Fills and/or strokes the current path, applies a pending clip and
+    /// starts a new path. `fill` holds the even-odd flag when filling.
+    fn paint(&mut self, fill: Option<bool>, stroke: bool) {
+        let path = std::mem::take(&mut self.path);
+        if let Some(even_odd) = fill {
+            let mask = raster::fill(&path, even_odd, self.width, self.height);
+            self.composite(&mask, self.state.fill, 1.0);
+        }
+        if stroke {
+            let ctm = self.state.ctm;
+            let scale = (ctm.a * ctm.d - ctm.b * ctm.c).abs().sqrt();
+            // Zero-width and hairline strokes are drawn one pixel wide
+            let width = (self.state.line_width * scale).max(1.0);
+            let dash: Vec<f64> = self.state.dash.0.iter().map(|d| d * scale).collect();
+            let outline = raster::stroke(&path, width, self.state.line_cap, &dash, self.state.dash.1 * scale);
+            let mask = raster::fill(&outline, false, self.width, self.height);
+            self.composite(&mask, self.state.stroke, 1.0);
+        }
+        if let Some(even_odd) = self.pending_clip.take() {
+            let mask = raster::fill(&path, even_odd, self.width, self.height);
+            let mut clip = vec![0f32; self.width * self.height];
+            for y in mask.y..mask.y + mask.height {
+                for x in mask.x..mask.x + mask.width {
+                    let i = y * self.width + x;
+                    let outer = self.state.clip.as_ref().map_or(1.0, |clip| clip[i]);
+                    clip[i] = mask.at(x, y) * outer;
+                }
+            }
+            self.state.clip = Some(Rc::new(clip));
+        }
+    }
+
+    /// Blends `color` into the pixels covered by `mask`, inside the clip.
+    fn composite(&mut self, mask: &Mask, color: [f32; 3], opacity: f32) {
+        let clip = self.state.clip.clone();
+        for row in 0..mask.height {
+            let y = mask.y + row;
+            for column in 0..mask.width {
+                let x = mask.x + column;
+                let mut alpha = mask.coverage[row * mask.width + column] * opacity;
+                if let Some(clip) = &clip {
+                    alpha *= clip[y * self.width + x];
+                }
+                if alpha > 0.0 {
+                    self.blend(x, y, color, alpha);
+                }
+            }
+        }
+    }
+
+    /// Mixes `color`, with components from 0 to 1, into a pixel.
+    fn blend(&mut self, x: usize, y: usize, color: [f32; 3], alpha: f32) {
+        let pixel = &mut self.pixels[(y * self.width + x) * 3..][..3];
+        for (channel, &value) in pixel.iter_mut().zip(&color) {
+            let current = *channel as f32;
+            *channel = (current + (value * 255.0 - current) * alpha).round().clamp(0.0, 255.0) as u8;
+        }
+    }
+
+    fn move_text(&mut self, tx: f64, ty: f64) {
+        self.line_matrix = Matrix::translate(tx, ty).multiply(&self.line_matrix);
+        self.text_matrix = self.line_matrix;
+    }
+
+    /// Moves the text position along the baseline by `tx` text space units.
+    fn advance(&mut self, tx: f64) {
+        self.text_matrix = Matrix::translate(tx, 0.0).multiply(&self.text_matrix);
+    }
+
+    /// Returns the font set with `Tf`, if the page has it.
+    fn font(&self) -> Option<&'a Font> {
+        let name = self.state.font.as_deref()?;
+        self.page.fonts.iter().find(|(n, _)| n == name).map(|(_, font)| font)
+    }
+
+    /// Shows a literal string: glyph outlines for TrueType fonts, greeked
+    /// words for the standard 14 fonts.
+    fn show_text(&mut self, text: &str) {
+        match self.font() {
+            Some(Font::TrueType(font)) => {
+                let bytes = font.encode(text);
+                self.show_glyphs(&bytes);
+            }
+            Some(&Font::Standard14(font)) => self.show_greeked(text, font),
+            None => {}
+        }
+    }
+
+    /// Draws a grey bar over each word of standard-font text.
+    fn show_greeked(&mut self, text: &str, font: Standard14Font) {
+        let size = self.state.font_size;
+        let scaling = self.state.horizontal_scaling;
+        let average = font.average_width();
+        let helvetica = matches!(
+            font,
+            Standard14Font::Helvetica
+                | Standard14Font::HelveticaBold
+                | Standard14Font::HelveticaOblique
+                | Standard14Font::HelveticaBoldOblique
+        );
+        let mut word_start: Option<f64> = None;
+        let mut x = 0.0;
+        let mut bars = Vec::new();
+        for c in text.chars() {
+            let width = if helvetica { helvetica_char_width(c) as f64 } else { average };
+            let spacing = self.state.char_spacing + if c == ' ' { self.state.word_spacing } else { 0.0 };
+            if c.is_whitespace() {
+                if let Some(start) = word_start.take() {
+                    bars.push((start, x));
+                }
+            } else if word_start.is_none() {
+                word_start = Some(x);
+            }
+            x += (width / 1000.0 * size + spacing) * scaling;
+        }
+        if let Some(start) = word_start {
+            bars.push((start, x));
+        }
+
+        if self.state.render_mode != 3 && self.state.render_mode != 7 {
+            let matrix = self.text_matrix.multiply(&self.state.ctm);
+            let rise = self.state.rise;
+            let subpaths: Vec<Subpath> = bars
+                .into_iter()
+                .map(|(left, right)| {
+                    let corners = [(left, rise), (right, rise), (right, rise + size * 0.5), (left, rise + size * 0.5)];
+                    Subpath {
+                        points: corners.iter().map(|&(x, y)| matrix.transform_point(x, y)).collect(),
+                        closed: true,
+                    }
+                })
+                .collect();
+            let mask = raster::fill(&subpaths, false, self.width, self.height);
+            self.composite(&mask, self.state.fill, GREEKING_OPACITY);
+        }
+        self.advance(x);
+    }
+
+    /// Shows two-byte glyph IDs in the current TrueType font.
+    fn show_glyphs(&mut self, bytes: &[u8]) {
+        let font: &TrueTypeFont = match self.font() {
+            Some(Font::TrueType(font)) => font,
+            _ => return,
+        };
+        let size = self.state.font_size;
+        let scaling = self.state.horizontal_scaling;
+        let units = font.units_per_em() as f64;
+        let visible = self.state.render_mode != 3 && self.state.render_mode != 7;
+        let mut outline = Vec::new();
+        for pair in bytes.chunks_exact(2) {
+            let gid = u16::from_be_bytes([pair[0], pair[1]]);
+            if visible {
+                let matrix = self.text_matrix.multiply(&self.state.ctm);
+                let rise = self.state.rise;
+                outline.extend(glyph::outline(font, gid, |x, y| {
+                    matrix.transform_point(x / units * size * scaling, y / units * size + rise)
+                }));
+            }
+            let width = font.advance_width(gid) as f64 / units * size;
+            self.advance((width + self.state.char_spacing) * scaling);
+        }
+        let mask = raster::fill(&outline, false, self.width, self.height);
+        self.composite(&mask, self.state.fill, 1.0);
+    }
+
+    #[cfg(feature = "images")]
+    fn paint_xobject(&mut self, name: &str) {
+        let image = match self.page.images.iter().find(|(n, _)| n == name) {
+            Some((_, image)) => image,
+            None => return,
+        };
+        let (pixels, alpha) = match images::decode(image) {
+            Some(decoded) => decoded,
+            None => return,
+        };
+        let (iw, ih) = (image.width as usize, image.height as usize);
+        let clip = self.state.clip.clone();
+
+        // The image fills the unit square of user space
+        let ctm = self.state.ctm;
+        let det = ctm.a * ctm.d - ctm.b * ctm.c;
+        if det == 0.0 || iw == 0 || ih == 0 {
+            return;
+        }
+        let corners = [(0.0, 0.0), (1.0, 0.0), (0.0, 1.0), (1.0, 1.0)].map(|(x, y)| ctm.transform_point(x, y));
+        let bound = |v: f64, max: usize| v.max(0.0).min(max as f64) as usize;
+        let left = bound(corners.iter().map(|p| p.0).fold(f64::MAX, f64::min).floor(), self.width);
+        let right = bound(corners.iter().map(|p| p.0).fold(f64::MIN, f64::max).ceil(), self.width);
+        let top = bound(corners.iter().map(|p| p.1).fold(f64::MAX, f64::min).floor(), self.height);
+        let bottom = bound(corners.iter().map(|p| p.1).fold(f64::MIN, f64::max).ceil(), self.height);
+
+        for y in top..bottom {
+            for x in left..right {
+                let (dx, dy) = (x as f64 + 0.5 - ctm.e, y as f64 + 0.5 - ctm.f);
+                let u = (ctm.d * dx - ctm.c * dy) / det;
+                let v = (ctm.a * dy - ctm.b * dx) / det;
+                if !((0.0..1.0).contains(&u) && (0.0..1.0).contains(&v)) {
+                    continue;
+                }
+                let index = ((1.0 - v) * ih as f64).min(ih as f64 - 1.0) as usize * iw + (u * iw as f64) as usize;
+                let mut a = alpha.as_ref().map_or(1.0, |alpha| alpha[index] as f32 / 255.0);
+                if let Some(clip) = &clip {
+                    a *= clip[y * self.width + x];
+                }
+                if a > 0.0 {
+                    let [r, g, b] = pixels[index];
+                    self.blend(x, y, [r as f32 / 255.0, g as f32 / 255.0, b as f32 / 255.0], a);
+                }
+            }
+        }
+    }
+
+    #[cfg(not(feature = "images"))]
+    fn paint_xobject(&mut self, _name: &str) {}
+}
+
+/// Converts a color to RGB components from 0 to 1.
+fn rgb(color: Color) -> [f32; 3] {
+    let [r, g, b] = match color {
+        Color::Rgb(c) => [c.r, c.g, c.b],
+        Color::Gray(c) => [c.level; 3],
+        Color::Cmyk(c) => [
+            (1.0 - c.c) * (1.0 - c.k),
+            (1.0 - c.m) * (1.0 - c.k),
+            (1.0 - c.y) * (1.0 - c.k),
+        ],
+    };
+    [r as f32, g as f32, b as f32]
+}
+
+#[cfg(feature = "images")]
+mod images {
+    use crate::image::{ColorSpace, Image, ImageFilter};
+
+    /// RGB pixels and optional alpha, rows from the top.
+    pub(super) type Decoded = (Vec<[u8; 3]>, Option<Vec<u8>>);
+
+    /// Decodes an image, or returns None for encodings not supported.
+    pub(super) fn decode(image: &Image) -> Option<Decoded> {
+        let count = image.width as usize * image.height as usize;
+        let pixels: Vec<[u8; 3]> = match image.filter {
+            ImageFilter::DCTDecode => {
+                let decoded = image::load_from_memory(&image.data).ok()?;
+                decoded.to_rgb8().into_raw().chunks_exact(3).map(|p| [p[0], p[1], p[2]]).collect()
+            }
+            ImageFilter::FlateDecode => {
+                let samples = inflate(&image.data)?;
+                if image.bits_per_component != 8 {
+                    return None;
+                }
+                match image.color_space {
+                    ColorSpace::DeviceGray => samples.iter().map(|&g| [g; 3]).collect(),
+                    ColorSpace::DeviceRGB => samples.chunks_exact(3).map(|p| [p[0], p[1], p[2]]).collect(),
+                    ColorSpace::DeviceCMYK => samples
+                        .chunks_exact(4)
+                        .map(|p| {
+                            let k = 255 - p[3] as u32;
+                            [p[0], p[1], p[2]].map(|c| ((255 - c as u32) * k / 255) as u8)
+                        })
+                        .collect(),
+                }
+            }
+        };
+        if pixels.len() < count {
+            return None;
+        }
+        let alpha = match &image.soft_mask {
+            Some(mask) if mask.filter == ImageFilter::FlateDecode => inflate(&mask.data).filter(|a| a.len() >= count),
+            _ => None,
+        };
+        Some((pixels, alpha))
+    }
+
+    fn inflate(data: &[u8]) -> Option<Vec<u8>> {
+        use flate2::read::ZlibDecoder;
+        use std::io::Read;
+
+        let mut out = Vec::new();
+        ZlibDecoder::new(data).read_to_end(&mut out).ok()?;
+        Some(out)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::content::{ContentBuilder, TextBuilder};
+    use crate::page::PageBuilder;
+    use crate::types::Rectangle;
+
+    /// Renders a page and returns the RGB pixels, decoded from the PNG.
+    fn render(page: &Page, dpi: f64) -> (usize, usize, Vec<u8>) {
+        use flate2::read::ZlibDecoder;
+        use std::io::Read;
+
+        let png = render_page(page, dpi).unwrap();
+        let width = u32::from_be_bytes(png[16..20].try_into().unwrap()) as usize;
+        let height = u32::from_be_bytes(png[20..24].try_into().unwrap()) as usize;
+        let idat = png.windows(4).position(|w| w == b"IDAT").unwrap();
+        let length = u32::from_be_bytes(png[idat - 4..idat].try_into().unwrap()) as usize;
+        let mut rows = Vec::new();
+        ZlibDecoder::new(&png[idat + 4..idat + 4 + length]).read_to_end(&mut rows).unwrap();
+
+        // Undo the Up filter on every row
+        let stride = width * 3;
+        let mut pixels = vec![0u8; stride * height];
+        for y in 0..height {
+            let row = &rows[y * (stride + 1)..(y + 1) * (stride + 1)];
+            assert_eq!(row[0], 2);
+            for x in 0..stride {
+                let above = if y == 0 { 0 } else { pixels[(y - 1) * stride + x] };
+                pixels[y * stride + x] = row[x + 1].wrapping_add(above);
+            }
+        }
+        (width, height, pixels)
+    }
+
+    fn pixel(image: &(usize, usize, Vec<u8>), x: usize, y: usize) -> [u8; 3] {
+        let i = (y * image.0 + x) * 3;
+        [image.2[i], image.2[i + 1], image.2[i + 2]]
+    }
+
+    #[test]
+    fn test_render_paths() {
+        // A red square in the lower-left quarter of a 100pt page, and a
+        // 2pt blue line across the top
+        let content = ContentBuilder::new()
+            .fill_color(Color::rgb(1.0, 0.0, 0.0))
+            .rect(0.0, 0.0, 50.0, 50.0)
+            .fill()
+            .stroke_color(Color::rgb(0.0, 0.0, 1.0))
+            .line_width(2.0)
+            .move_to(0.0, 90.0)
+            .line_to(100.0, 90.0)
+            .stroke();
+        let page = PageBuilder::custom(100.0, 100.0).content(content).build();
+
+        let image = render(&page, 144.0);
+        assert_eq!((image.0, image.1), (200, 200));
+        assert_eq!(pixel(&image, 10, 190), [255, 0, 0]);
+        assert_eq!(pixel(&image, 150, 150), [255, 255, 255]);
+        assert_eq!(pixel(&image, 50, 20), [0, 0, 255]);
+        assert_eq!(pixel(&image, 50, 25), [255, 255, 255]);
+
+        // A background shows only where nothing else is painted
+        let mut page = page;
+        page.background = Some(Color::rgb(0.0, 1.0, 0.0));
+        let image = render(&page, 144.0);
+        assert_eq!(pixel(&image, 10, 190), [255, 0, 0]);
+        assert_eq!(pixel(&image, 150, 150), [0, 255, 0]);
+    }
+
+    #[test]
+    fn test_render_rotated() {
+        // A red square in the lower-left corner of a landscape page
+        let content = ContentBuilder::new().fill_color(Color::rgb(1.0, 0.0, 0.0)).rect(0.0, 0.0, 50.0, 50.0).fill();
+        let page = PageBuilder::custom(200.0, 100.0).content(content).build();
+        let corners = [(90, (100, 200), (10, 10)), (180, (200, 100), (190, 10)), (270, (100, 200), (90, 190))];
+        for (degrees, size, red) in corners {
+            let mut page = page.clone();
+            page.rotation = degrees;
+            let image = render(&page, 72.0);
+            assert_eq!((image.0, image.1), size);
+            assert_eq!(pixel(&image, red.0, red.1), [255, 0, 0]);
+            assert_eq!(pixel(&image, size.0 - 1 - red.0, size.1 - 1 - red.1), [255, 255, 255]);
+        }
+    }
+
+    #[test]
+    fn test_render_clip_and_state() {
+        // The clip stops the fill at the left half and ends with the saved state
+        let content = ContentBuilder::new()
+            .save_state()
+            .rect(0.0, 0.0, 50.0, 100.0)
+            .clip()
+            .end_path()
+            .fill_color(Color::gray(0.0))
+            .rect(0.0, 0.0, 100.0, 50.0)
+            .fill()
+            .restore_state()
+            .fill_color(Color::rgb(0.0, 1.0, 0.0))
+            .rect(0.0, 75.0, 100.0, 25.0)
+            .fill();
+        let page = PageBuilder::custom(100.0, 100.0).content(content).build();
+
+        let image = render(&page, 72.0);
+        assert_eq!(pixel(&image, 25, 75), [0, 0, 0]);
+        assert_eq!(pixel(&image, 75, 75), [255, 255, 255]);
+        assert_eq!(pixel(&image, 75, 10), [0, 255, 0]);
+    }
+
+    #[test]
+    fn test_render_progress() {
+        let mut content = ContentBuilder::new();
+        for i in 0..PROGRESS_INTERVAL * 2 {
+            content = content.rect(i as f64 % 100.0, 0.0, 1.0, 1.0);
+        }
+        let page = PageBuilder::custom(100.0, 100.0).content(content.fill()).build();
+        let mut fractions = Vec::new();
+        render_page_with_progress(&page, 72.0, |f| {
+            fractions.push(f);
+            true
+        })
+        .unwrap();
+        assert_eq!(fractions.len(), 3);
+        assert_eq!(fractions[0], 0.0);
+        assert!(fractions[2] > 0.99 && fractions[2] < 1.0);
+        assert!(matches!(render_page_with_progress(&page, 72.0, |f| f == 0.0), Err(PdfError::Cancelled)));
+    }
+
+    #[test]
+    fn test_render_text() {
+        let font = TrueTypeFont::from_bytes(crate::font::test_font_bytes()).unwrap();
+        let glyphs = TextBuilder::new().font("TT1", 100.0).move_to(0.0, 0.0).show_hex(font.encode("A"));
+        let greeked = TextBuilder::new().font("F1", 20.0).move_to(0.0, 150.0).show("Hi there");
+        let page = PageBuilder::new()
+            .media_box(Rectangle::from_dimensions(200.0, 200.0))
+            .font("F1", Standard14Font::Helvetica)
+            .font("TT1", font)
+            .content(ContentBuilder::new().text_block(glyphs).text_block(greeked))
+            .build();
+
+        // The triangle of A is 60pt wide at its base and 70pt tall
+        let image = render(&page, 72.0);
+        assert_eq!(pixel(&image, 30, 198), [0, 0, 0]);
+        assert_eq!(pixel(&image, 30, 120), [255, 255, 255]);
+        assert_eq!(pixel(&image, 2, 140), [255, 255, 255]);
+
+        // "Hi" is 18.9pt wide and half the font size tall, at half strength
+        assert_eq!(pixel(&image, 8, 45), [128, 128, 128]);
+        assert_eq!(pixel(&image, 19, 45), [255, 255, 255]);
+        assert_eq!(pixel(&image, 8, 35), [255, 255, 255]);
+    }
+
+    #[test]
+    fn test_render_page_errors() {
+        let page = PageBuilder::a4().build();
+        assert!(render_page(&page, 0.0).is_err());
+        assert!(render_page(&page, f64::NAN).is_err());
+        assert!(matches!(
+            render_page(&page, 100_000.0),
+            Err(crate::PdfError::Render(RenderError::TooLarge { .. }))
+        ));
+    }
+
+    #[test]
+    fn test_render_thumbnail() {
+        // The red square fills the left half of a landscape page
+        let content = ContentBuilder::new().fill_color(Color::rgb(1.0, 0.0, 0.0)).rect(0.0, 0.0, 100.0, 100.0).fill();
+        let page = PageBuilder::custom(200.0, 100.0).content(content).build();
+        let thumbnail = render_thumbnail(&page, 64).unwrap();
+        assert_eq!((thumbnail.width(), thumbnail.height()), (64, 32));
+        assert_eq!(&thumbnail.pixels()[..3], [255, 0, 0]);
+        assert_eq!(&thumbnail.pixels()[thumbnail.pixels().len() - 3..], [255, 255, 255]);
+
+        let mut page = page;
+        page.rotation = 90;
+        let thumbnail = render_thumbnail(&page, 64).unwrap();
+        assert_eq!((thumbnail.width(), thumbnail.height()), (32, 64));
+        assert!(matches!(
+            render_thumbnail(&page, 0),
+            Err(crate::PdfError::Render(RenderError::InvalidThumbnailSize(0)))
+        ));
+    }
+}
@@ -0,0 +1,90 @@
+//! Minimal PNG encoder for 8-bit RGB images.
+
+use crate::error::CompressionError;
+
+/// Encodes `pixels`, rows of RGB triples from the top, as a PNG file that
+/// records `dpi` as its physical pixel density.
+pub(super) fn encode(width: u32, height: u32, pixels: &[u8], dpi: f64) -> Result<Vec<u8>, CompressionError> {
+    use flate2::write::ZlibEncoder;
+    use flate2::Compression;
+    use std::io::Write;
+
+    let row = width as usize * 3;
+    let mut encoder = ZlibEncoder::new(Vec::new(), Compression::default());
+    let mut previous = vec![0u8; row];
+    let mut filtered = Vec::with_capacity(row + 1);
+    for line in pixels.chunks(row) {
+        // The Up filter makes the unchanged rows of a mostly blank page cheap
+        filtered.clear();
+        filtered.push(2);
+        filtered.extend(line.iter().zip(&previous).map(|(&x, &above)| x.wrapping_sub(above)));
+        encoder
+            .write_all(&filtered)
+            .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+        previous.copy_from_slice(line);
+    }
+    let data = encoder
+        .finish()
+        .map_err(|e| CompressionError::CompressionFailed(e.to_string()))?;
+
+    let mut png = b"\x89PNG\r\n\x1a\n".to_vec();
+    let mut header = Vec::with_capacity(13);
+    header.extend_from_slice(&width.to_be_bytes());
+    header.extend_from_slice(&height.to_be_bytes());
+    header.extend_from_slice(&[8, 2, 0, 0, 0]); // 8-bit RGB, no interlacing
+    chunk(&mut png, b"IHDR", &header);
+
+    let per_metre = ((dpi / 0.0254).round() as u32).to_be_bytes();
+    let mut density = Vec::with_capacity(9);
+    density.extend_from_slice(&per_metre);
+    density.extend_from_slice(&per_metre);
+    density.push(1);
+    chunk(&mut png, b"pHYs", &density);
+
+    chunk(&mut png, b"IDAT", &data);
+    chunk(&mut png, b"IEND", &[]);
+    Ok(png)
+}
+
+/// Appends a chunk with its length and CRC.
+fn chunk(png: &mut Vec<u8>, kind: &[u8; 4], data: &[u8]) {
+    png.extend_from_slice(&(data.len() as u32).to_be_bytes());
+    let start = png.len();
+    png.extend_from_slice(kind);
+    png.extend_from_slice(data);
+    let crc = crc32(&png[start..]);
+    png.extend_from_slice(&crc.to_be_bytes());
+}
+
+/// CRC-32 as used by PNG (ISO 3309).
+fn crc32(data: &[u8]) -> u32 {
+    let mut crc = !0u32;
+    for &byte in data {
+        crc ^= byte as u32;
+        for _ in 0..8 {
+            crc = if crc & 1 != 0 { (crc >> 1) ^ 0xEDB8_8320 } else { crc >> 1 };
+        }
+    }
+    !crc
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_crc32() {
+        assert_eq!(crc32(b"IEND"), 0xAE42_6082);
+        assert_eq!(crc32(b"123456789"), 0xCBF4_3926);
+    }
+
+    #[test]
+    fn test_encode() {
+        let pixels = [255, 0, 0, 0, 0, 255];
+        let png = encode(2, 1, &pixels, 72.0).unwrap();
+        assert!(png.starts_with(b"\x89PNG\r\n\x1a\n\0\0\0\x0dIHDR\0\0\0\x02\0\0\0\x01\x08\x02"));
+        assert!(png.ends_with(b"IEND\xae\x42\x60\x82"));
+        assert_eq!(&png[37..41], b"pHYs");
+        assert_eq!(&png[41..45], &2835u32.to_be_bytes());
+    }
+}
@@ -0,0 +1,385 @@
+//! Scanline rasterization of filled and stroked paths.
+
+use std::f64::consts::PI;
+
+/// Sub-scanlines per pixel row. Coverage along each sub-scanline is exact,
+/// so this only limits anti-aliasing of nearly horizontal edges.
+const SAMPLES: usize = 4;
+
+/// A point in device space, in pixels from the top-left corner of the image.
+pub(super) type Point = (f64, f64);
+
+/// A connected run of points. Filling closes it implicitly.
+#[derive(Debug, Clone, Default)]
+pub(super) struct Subpath {
+    pub points: Vec<Point>,
+    pub closed: bool,
+}
+
+/// How the ends of open strokes are drawn, as set by the `J` operator.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(super) enum LineCap {
+    Butt,
+    Round,
+    Square,
+}
+
+/// Coverage, from 0 to 1, of the pixels within a rectangle of the image.
+#[derive(Debug, Clone)]
+pub(super) struct Mask {
+    pub x: usize,
+    pub y: usize,
+    pub width: usize,
+    pub height: usize,
+    pub coverage: Vec<f32>,
+}
+
+impl Mask {
+    /// Returns a mask that covers nothing.
+    pub fn empty() -> Self {
+        Self { x: 0, y: 0, width: 0, height: 0, coverage: Vec::new() }
+    }
+
+    /// Returns the coverage at a pixel of the image, 0 outside the mask.
+    pub fn at(&self, x: usize, y: usize) -> f32 {
+        if x < self.x || y < self.y || x >= self.x + self.width || y >= self.y + self.height {
+            return 0.0;
+        }
+        self.coverage[(y - self.y) * self.width + (x - self.x)]
+    }
+}
+
+/// Appends a cubic Bézier curve from the last point of `points`, flattened
+/// into line segments no longer than a pixel or so.
+pub(super) fn curve_to(points: &mut Vec<Point>, c1: Point, c2: Point, end: Point) {
+    let start = match points.last() {
+        Some(&p) => p,
+        None => return,
+    };
+    let length = distance(start, c1) + distance(c1, c2) + distance(c2, end);
+    let steps = (length / 2.0).ceil().clamp(1.0, 256.0) as usize;
+    for i in 1..=steps {
+        let t = i as f64 / steps as f64;
+        let u = 1.0 - t;
+        let (a, b, c, d) = (u * u * u, 3.0 * u * u * t, 3.0 * u * t * t, t * t * t);
+        points.push((
+            a * start.0 + b * c1.0 + c * c2.0 + d * end.0,
+            a * start.1 + b * c1.1 + c * c2.1 + d * end.1,
+        ));
+    }
+}
+
+fn distance(a: Point, b: Point) -> f64 {
+    ((b.0 - a.0).powi(2) + (b.1 - a.1).powi(2)).sqrt()
+}
+
+/// A polygon edge from top to bottom, with +1 or -1 winding.
+struct Edge {
+    top: Point,
+    bottom: Point,
+    winding: i32,
+}
+
+/// Returns the coverage of the area inside `subpaths` within an image of
+/// `width` by `height` pixels, by the nonzero or even-odd rule.
+pub(super) fn fill(subpaths: &[Subpath], even_odd: bool, width: usize, height: usize) -> Mask {
+    let mut edges = Vec::new();
+    for subpath in subpaths {
+        let points = &subpath.points;
+        for (i, &from) in points.iter().enumerate() {
+            let to = points[(i + 1) % points.len()];
+            if from.1 == to.1 || !(from.1.is_finite() && to.1.is_finite() && from.0.is_finite() && to.0.is_finite())
+            {
+                continue;
+            }
+            edges.push(if from.1 < to.1 {
+                Edge { top: from, bottom: to, winding: 1 }
+            } else {
+                Edge { top: to, bottom: from, winding: -1 }
+            });
+        }
+    }
+    if edges.is_empty() {
+        return Mask::empty();
+    }
+
+    let bound = |v: f64, max: usize| v.max(0.0).min(max as f64);
+    let (mut left, mut right, mut top, mut bottom) = (f64::MAX, f64::MIN, f64::MAX, f64::MIN);
+    for edge in &edges {
+        left = left.min(edge.top.0.min(edge.bottom.0));
+        right = right.max(edge.top.0.max(edge.bottom.0));
+        top = top.min(edge.top.1);
+        bottom = bottom.max(edge.bottom.1);
+    }
+    let (x0, x1) = (bound(left, width).floor() as usize, bound(right, width).ceil() as usize);
+    let (y0, y1) = (bound(top, height).floor() as usize, bound(bottom, height).ceil() as usize);
+    if x0 >= x1 || y0 >= y1 {
+        return Mask::empty();
+    }
+
+    let mask_width = x1 - x0;
+    let mut coverage = vec![0f32; mask_width * (y1 - y0)];
+    edges.sort_by(|a, b| a.top.1.total_cmp(&b.top.1));
+    let mut next = 0;
+    let mut active: Vec<usize> = Vec::new();
+    let mut crossings: Vec<(f64, i32)> = Vec::new();
+    let weight = 1.0 / SAMPLES as f32;
+
+    for row in y0..y1 {
+        let line = &mut coverage[(row - y0) * mask_width..(row - y0 + 1) * mask_width];
+        for sample in 0..SAMPLES {
+            let y = row as f64 + (sample as f64 + 0.5) / SAMPLES as f64;
+            while next < edges.len() && edges[next].top.1 <= y {
+                active.push(next);
+                next += 1;
+            }
+            active.retain(|&i| edges[i].bottom.1 > y);
+
+            crossings.clear();
+            for &i in &active {
+                let edge = &edges[i];
+                if edge.top.1 > y {
+                    continue;
+                }
+                let t = (y - edge.top.1) / (edge.bottom.1 - edge.top.1);
+                crossings.push((edge.top.0 + t * (edge.bottom.0 - edge.top.0), edge.winding));
+            }
+            crossings.sort_by(|a, b| a.0.total_cmp(&b.0));
+
+            let mut winding = 0;
+            for pair in crossings.windows(2) {
+                winding += pair[0].1;
+                let inside = if even_odd { winding % 2 != 0 } else { winding != 0 };
+                if inside {
+                    add_span(line, pair[0].0 - x0 as f64, pair[1].0 - x0 as f64, weight);
+                }
+            }
+        }
+    }
+
+    for c in &mut coverage {
+        *c = c.min(1.0);
+    }
+    Mask { x: x0, y: y0, width: mask_width, height: y1 - y0, coverage }
+}
+
+/// Adds `weight` times the covered fraction of each pixel between `from` and `to`.
+fn add_span(line: &mut [f32], from: f64, to: f64, weight: f32) {
+    let from = from.max(0.0);
+    let to = to.min(line.len() as f64);
+    if from >= to {
+        return;
+    }
+    let (first, last) = (from.floor() as usize, (to.ceil() as usize).min(line.len()) - 1);
+    if first == last {
+        line[first] += (to - from) as f32 * weight;
+        return;
+    }
+    line[first] += (first as f64 + 1.0 - from) as f32 * weight;
+    for c in &mut line[first + 1..last] {
+        *c += weight;
+    }
+    line[last] += (to - last as f64) as f32 * weight;
+}
+
+/// Returns the outline of `subpaths` stroked with lines `width` pixels wide,
+/// as polygons to fill by the nonzero rule. Joins are always round.
+pub(super) fn stroke(subpaths: &[Subpath], width: f64, cap: LineCap, dash: &[f64], phase: f64) -> Vec<Subpath> {
+    let radius = width / 2.0;
+    let mut outline = Vec::new();
+    for subpath in subpaths {
+        let mut points = subpath.points.clone();
+        points.dedup();
+        if subpath.closed && points.len() > 1 {
+            points.push(points[0]);
+        }
+        let pieces = if dash.iter().any(|&d| d > 0.0) {
+            apply_dash(&points, dash, phase)
+        } else {
+            vec![points]
+        };
+        for piece in pieces {
+            stroke_polyline(&piece, radius, cap, subpath.closed && dash.is_empty(), &mut outline);
+        }
+    }
+    outline
+}
+
+fn stroke_polyline(points: &[Point], radius: f64, cap: LineCap, closed: bool, outline: &mut Vec<Subpath>) {
+    if points.is_empty() {
+        return;
+    }
+    if points.len() == 1 {
+        // A zero-length segment only shows with round or square caps
+        match cap {
+            LineCap::Round => outline.push(circle(points[0], radius)),
+            LineCap::Square => {
+                let (x, y) = points[0];
+                outline.push(polygon(vec![
+                    (x - radius, y - radius),
+                    (x + radius, y - radius),
+                    (x + radius, y + radius),
+                    (x - radius, y + radius),
+                ]));
+            }
+            LineCap::Butt => {}
+        }
+        return;
+    }
+
+    let last = points.len() - 2;
+    for (i, pair) in points.windows(2).enumerate() {
+        let (mut a, mut b) = (pair[0], pair[1]);
+        let length = distance(a, b);
+        if length == 0.0 {
+            continue;
+        }
+        let (dx, dy) = ((b.0 - a.0) / length, (b.1 - a.1) / length);
+        if cap == LineCap::Square && !closed {
+            if i == 0 {
+                a = (a.0 - dx * radius, a.1 - dy * radius);
+            }
+            if i == last {
+                b = (b.0 + dx * radius, b.1 + dy * radius);
+            }
+        }
+        let (nx, ny) = (-dy * radius, dx * radius);
+        outline.push(polygon(vec![
+            (a.0 + nx, a.1 + ny),
+            (b.0 + nx, b.1 + ny),
+            (b.0 - nx, b.1 - ny),
+            (a.0 - nx, a.1 - ny),
+        ]));
+    }
+
+    let joins = if closed { points } else { &points[1..points.len() - 1] };
+    outline.extend(joins.iter().map(|&p| circle(p, radius)));
+    if cap == LineCap::Round && !closed {
+        outline.push(circle(points[0], radius));
+        outline.push(circle(points[points.len() - 1], radius));
+    }
+}
+
+/// Splits a polyline into the pieces a dash pattern draws.
+fn apply_dash(points: &[Point], dash: &[f64], phase: f64) -> Vec<Vec<Point>> {
+    let cycle: f64 = dash.iter().sum();
+    let mut index = 0;
+    let mut remaining = dash[0];
+    let mut offset = phase % cycle;
+    while offset > 0.0 {
+        if offset >= remaining {
+            offset -= remaining;
+            index = (index + 1) % dash.len();
+            remaining = dash[index];
+        } else {
+            remaining -= offset;
+            offset = 0.0;
+        }
+    }
+
+    let mut pieces = Vec::new();
+    let mut current = vec![points[0]];
+    for pair in points.windows(2) {
+        let (mut a, b) = (pair[0], pair[1]);
+        let mut length = distance(a, b);
+        while length > 0.0 {
+            let step = remaining.min(length);
+            let t = step / length;
+            let p = (a.0 + (b.0 - a.0) * t, a.1 + (b.1 - a.1) * t);
+            if index % 2 == 0 {
+                current.push(p);
+            }
+            remaining -= step;
+            length -= step;
+            a = p;
+            if remaining <= 0.0 {
+                if index % 2 == 0 {
+                    pieces.push(std::mem::take(&mut current));
+                }
+                index = (index + 1) % dash.len();
+                remaining = dash[index];
+                if index % 2 == 0 {
+                    current = vec![a];
+                }
+            }
+        }
+    }
+    if index % 2 == 0 && current.len() > 1 {
+        pieces.push(current);
+    }
+    pieces
+}
+
+/// Returns a polygon approximating a circle.
+fn circle(center: Point, radius: f64) -> Subpath {
+    let steps = (radius * 2.0).ceil().clamp(8.0, 64.0) as usize;
+    polygon(
+        (0..steps)
+            .map(|i| {
+                let angle = 2.0 * PI * i as f64 / steps as f64;
+                (center.0 + radius * angle.cos(), center.1 + radius * angle.sin())
+            })
+            .collect(),
+    )
+}
+
+/// Returns a closed subpath wound clockwise on screen, so that overlapping
+/// stroke pieces add up instead of cancelling under the nonzero rule.
+fn polygon(mut points: Vec<Point>) -> Subpath {
+    let area: f64 = points
+        .iter()
+        .zip(points.iter().cycle().skip(1))
+        .map(|(a, b)| a.0 * b.1 - b.0 * a.1)
+        .sum();
+    if area < 0.0 {
+        points.reverse();
+    }
+    Subpath { points, closed: true }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn square(x: f64, y: f64, size: f64) -> Subpath {
+        Subpath {
+            points: vec![(x, y), (x + size, y), (x + size, y + size), (x, y + size)],
+            closed: true,
+        }
+    }
+
+    #[test]
+    fn test_fill_coverage() {
+        let mask = fill(&[square(1.5, 1.0, 2.0)], false, 10, 10);
+        assert_eq!((mask.x, mask.y, mask.width, mask.height), (1, 1, 3, 2));
+        assert_eq!(mask.at(1, 1), 0.5);
+        assert_eq!(mask.at(2, 2), 1.0);
+        assert_eq!(mask.at(3, 1), 0.5);
+        assert_eq!(mask.at(5, 5), 0.0);
+
+        // A square inside another is a hole by the even-odd rule only
+        let nested = [square(0.0, 0.0, 6.0), square(2.0, 2.0, 2.0)];
+        assert_eq!(fill(&nested, false, 10, 10).at(3, 3), 1.0);
+        assert_eq!(fill(&nested, true, 10, 10).at(3, 3), 0.0);
+
+        // Shapes are clipped to the image
+        assert!(fill(&[square(-5.0, -5.0, 2.0)], false, 10, 10).coverage.is_empty());
+    }
+
+    #[test]
+    fn test_stroke_and_dash() {
+        let line = Subpath { points: vec![(0.0, 5.0), (10.0, 5.0)], closed: false };
+        let mask = fill(&stroke(&[line.clone()], 2.0, LineCap::Butt, &[], 0.0), false, 20, 20);
+        assert_eq!((mask.x, mask.y, mask.width, mask.height), (0, 4, 10, 2));
+        assert!(mask.coverage.iter().all(|&c| c == 1.0));
+
+        let square_cap = fill(&stroke(&[line.clone()], 2.0, LineCap::Square, &[], 0.0), false, 20, 20);
+        assert_eq!(square_cap.width, 11);
+
+        // Dashes of 2 with gaps of 3: pixels 0-1, 5-6 and 10 stay off
+        let dashed = fill(&stroke(&[line], 2.0, LineCap::Butt, &[2.0, 3.0], 0.0), false, 20, 20);
+        assert_eq!(dashed.at(1, 4), 1.0);
+        assert_eq!(dashed.at(3, 4), 0.0);
+        assert_eq!(dashed.at(6, 4), 1.0);
+    }
+}
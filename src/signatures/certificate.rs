@@ -1,7 +1,7 @@
 //! X.509 certificate and private key handling.
 
 use crate::error::SignatureError;
-use super::SignatureResult;
+use super::{SignatureAlgorithm, SignatureResult};
 use std::fs;
 use std::path::Path;
 
@@ -172,7 +172,7 @@ impl PrivateKey {
     }
 
     /// Loads a private key from PKCS#8 DER bytes.
-    fn from_pkcs8_der(der_bytes: &[u8]) -> SignatureResult<Self> {
+    pub fn from_pkcs8_der(der_bytes: &[u8]) -> SignatureResult<Self> {
         use pkcs8::PrivateKeyInfo;
         use der::Decode;
 
@@ -215,18 +215,37 @@ impl PrivateKey {
         &self.der_bytes
     }
 
-    /// Signs data using this private key.
+    /// Signs data using this private key, with SHA-256.
     pub fn sign(&self, data: &[u8]) -> SignatureResult<Vec<u8>> {
         match self.key_type {
-            KeyType::Rsa => self.sign_rsa(data),
-            KeyType::EcdsaP256 => self.sign_ecdsa(data),
+            KeyType::Rsa => self.sign_with(data, SignatureAlgorithm::RsaSha256),
+            KeyType::EcdsaP256 => self.sign_with(data, SignatureAlgorithm::EcdsaP256Sha256),
         }
     }
 
-    /// Signs data with RSA-SHA256.
-    fn sign_rsa(&self, data: &[u8]) -> SignatureResult<Vec<u8>> {
+    /// Signs data using this private key and `algorithm`, which must suit
+    /// the key type.
+    pub fn sign_with(&self, data: &[u8], algorithm: SignatureAlgorithm) -> SignatureResult<Vec<u8>> {
+        use sha2::{Sha256, Sha384, Sha512};
+
+        match (self.key_type, algorithm) {
+            (KeyType::Rsa, SignatureAlgorithm::RsaSha256) => self.sign_rsa::<Sha256>(data),
+            (KeyType::Rsa, SignatureAlgorithm::RsaSha384) => self.sign_rsa::<Sha384>(data),
+            (KeyType::Rsa, SignatureAlgorithm::RsaSha512) => self.sign_rsa::<Sha512>(data),
+            (KeyType::EcdsaP256, SignatureAlgorithm::EcdsaP256Sha256) => self.sign_ecdsa(data),
+            (key_type, algorithm) => Err(SignatureError::UnsupportedAlgorithm(format!(
+                "{:?} with a {:?} key",
+                algorithm, key_type
+            ))),
+        }
+    }
+
+    /// Signs data with RSA PKCS#1 v1.5 over the digest `D`.
+    fn sign_rsa<D>(&self, data: &[u8]) -> SignatureResult<Vec<u8>>
+    where
+        D: sha2::Digest + const_oid::AssociatedOid,
+    {
         use rsa::{RsaPrivateKey, pkcs1v15::SigningKey};
-        use sha2::Sha256;
         use signature::{Signer, SignatureEncoding};
         use pkcs8::DecodePrivateKey;
 
@@ -234,7 +253,7 @@ impl PrivateKey {
             SignatureError::SigningFailed(format!("Failed to parse RSA key: {}", e))
         })?;
 
-        let signing_key = SigningKey::<Sha256>::new(private_key);
+        let signing_key = SigningKey::<D>::new(private_key);
         let signature = signing_key.sign(data);
 
         Ok(signature.to_bytes().to_vec())
@@ -0,0 +1,297 @@
+//! Adding a signature field to a finished PDF as an incremental update.
+//!
+//! The original bytes are kept unchanged; the update appends the signature
+//! dictionary, its field, and new revisions of the objects that refer to the
+//! field.
+
+use super::{ByteRange, SignatureConfig, SignatureResult};
+use crate::error::{ParserError, PdfError, SignatureError};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfString};
+use crate::parser::{IncrementalWriter, PdfReader};
+use std::collections::HashSet;
+use std::ops::Range;
+
+/// Room reserved for the /ByteRange array, which is written last.
+const BYTE_RANGE_WIDTH: usize = 36;
+
+/// Annotation flags of the signature widget: Print and Locked.
+const WIDGET_FLAGS: i64 = 4 | 128;
+
+/// A PDF with a signature whose /Contents is still a placeholder.
+#[derive(Debug)]
+pub(super) struct Prepared {
+    /// The whole file, update included.
+    data: Vec<u8>,
+    /// The hexadecimal digits of /Contents, between the angle brackets.
+    contents: Range<usize>,
+}
+
+impl Prepared {
+    /// Returns the bytes the signature covers: everything but /Contents.
+    pub(super) fn signed_data(&self) -> Vec<u8> {
+        let gap = self.contents.start - 1..self.contents.end + 1;
+        [&self.data[..gap.start], &self.data[gap.end..]].concat()
+    }
+
+    /// Writes the DER-encoded `signature` into /Contents and returns the
+    /// signed PDF.
+    pub(super) fn embed(mut self, signature: &[u8]) -> SignatureResult<Vec<u8>> {
+        let hex: String = signature.iter().map(|b| format!("{:02X}", b)).collect();
+        if hex.len() > self.contents.len() {
+            return Err(SignatureError::SigningFailed(format!(
+                "Signature of {} bytes does not fit the {} bytes reserved",
+                signature.len(),
+                self.contents.len() / 2
+            )));
+        }
+        let start = self.contents.start;
+        self.data[start..start + hex.len()].copy_from_slice(hex.as_bytes());
+        Ok(self.data)
+    }
+}
+
+/// Appends an invisible signature field on the first page to `pdf`, with
+/// room for a signature of up to `contents_size` bytes.
+///
+/// `signing_time` is the /M date, such as `D:20250120120000Z`.
+pub(super) fn prepare(
+    pdf: Vec<u8>,
+    config: &SignatureConfig,
+    contents_size: usize,
+    signing_time: &str,
+) -> SignatureResult<Prepared> {
+    // The reader refuses a file whose trailer has /Encrypt
+    let reader = match PdfReader::from_bytes(pdf) {
+        Ok(reader) => reader,
+        Err(PdfError::Parser(ParserError::EncryptedPdf)) => {
+            return Err(SignatureError::SigningFailed("Encrypted files cannot be signed".to_string()))
+        }
+        Err(e) => return Err(SignatureError::SigningFailed(format!("Failed to read document: {}", e))),
+    };
+    let mut catalog = reader
+        .catalog()
+        .ok_or_else(|| SignatureError::SigningFailed("Document has no catalog".to_string()))?;
+    let page_id = reader
+        .page_ids()
+        .map_err(|e| SignatureError::SigningFailed(format!("Failed to read document: {}", e)))?
+        .first()
+        .copied()
+        .ok_or_else(|| SignatureError::SigningFailed("Document has no pages".to_string()))?;
+    let mut page = match reader.resolve_reference(page_id) {
+        Some(Object::Dictionary(page)) => page,
+        _ => return Err(SignatureError::SigningFailed("First page is not a dictionary".to_string())),
+    };
+
+    let mut update = IncrementalWriter::new(&reader);
+    let sig_id = update.allocate();
+    let field_id = update.allocate();
+    let mut objects = Vec::new();
+
+    // The form may be a direct dictionary in the catalog or an object of its own
+    let (form_id, mut form) = match catalog.get("AcroForm") {
+        Some(Object::Reference(id)) => match reader.resolve_reference(*id) {
+            Some(Object::Dictionary(form)) => (Some(*id), form),
+            _ => (Some(*id), PdfDictionary::new()),
+        },
+        Some(Object::Dictionary(form)) => (None, form.clone()),
+        _ => (None, PdfDictionary::new()),
+    };
+    let mut fields = match form.get("Fields").and_then(|f| resolve(&reader, f)) {
+        Some(Object::Array(fields)) => fields,
+        _ => PdfArray::new(),
+    };
+    let name = field_name(&reader, &fields);
+    fields.push(Object::Reference(field_id));
+    form.set("Fields", Object::Array(fields));
+    // SignaturesExist and AppendOnly
+    form.set("SigFlags", Object::Integer(3));
+    match form_id {
+        Some(id) => objects.push((id, Object::Dictionary(form))),
+        None => {
+            catalog.set("AcroForm", Object::Dictionary(form));
+            objects.push((reader.trailer().root, Object::Dictionary(catalog)));
+        }
+    }
+
+    let mut annots = match page.get("Annots").and_then(|a| resolve(&reader, a)) {
+        Some(Object::Array(annots)) => annots,
+        _ => PdfArray::new(),
+    };
+    annots.push(Object::Reference(field_id));
+    page.set("Annots", Object::Array(annots));
+    objects.push((page_id, Object::Dictionary(page)));
+
+    let mut field = PdfDictionary::new();
+    field.set("Type", Object::Name(PdfName::new_unchecked("Annot")));
+    field.set("Subtype", Object::Name(PdfName::new_unchecked("Widget")));
+    field.set("FT", Object::Name(PdfName::new_unchecked("Sig")));
+    field.set("T", Object::String(PdfString::text(name)));
+    field.set("V", Object::Reference(sig_id));
+    field.set("F", Object::Integer(WIDGET_FLAGS));
+    let rect = [0, 0, 0, 0].into_iter().map(Object::Integer).collect();
+    field.set("Rect", Object::Array(rect));
+    field.set("P", Object::Reference(page_id));
+    objects.push((field_id, Object::Dictionary(field)));
+
+    let io = |e: std::io::Error| SignatureError::SigningFailed(e.to_string());
+
+    // The signature dictionary is written by hand to know where its
+    // placeholders are
+    update.begin_object(sig_id).map_err(io)?;
+    update
+        .write_bytes(b"<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /adbe.pkcs7.detached /ByteRange ")
+        .map_err(io)?;
+    let byte_range_at = update.position() as usize;
+    update.write_bytes(format!("{:<1$}", "[0 0 0 0]", BYTE_RANGE_WIDTH).as_bytes()).map_err(io)?;
+    update.write_bytes(b" /Contents <").map_err(io)?;
+    let contents_start = update.position() as usize;
+    update.write_bytes(&vec![b'0'; contents_size * 2]).map_err(io)?;
+    let contents = contents_start..update.position() as usize;
+    update.write_bytes(b">").map_err(io)?;
+    let entries = [
+        ("Name", &config.name),
+        ("Reason", &config.reason),
+        ("Location", &config.location),
+        ("ContactInfo", &config.contact_info),
+    ];
+    for (key, value) in entries {
+        if let Some(value) = value {
+            let entry = format!(" /{} {}", key, PdfString::text(value).to_pdf_string());
+            update.write_bytes(entry.as_bytes()).map_err(io)?;
+        }
+    }
+    let date = format!(" /M {} >>\nendobj\n", PdfString::literal(signing_time).to_pdf_string());
+    update.write_bytes(date.as_bytes()).map_err(io)?;
+
+    for (id, object) in &objects {
+        update.write_object(*id, object).map_err(io)?;
+    }
+    let mut data = update.finish().map_err(io)?;
+
+    let byte_range = ByteRange::new(
+        0,
+        contents.start as i64 - 1,
+        contents.end as i64 + 1,
+        (data.len() - contents.end - 1) as i64,
+    );
+    let array = format!(
+        "[0 {} {} {}]",
+        byte_range.length1, byte_range.offset2, byte_range.length2
+    );
+    if array.len() > BYTE_RANGE_WIDTH {
+        return Err(SignatureError::ByteRangeError("Document is too large".to_string()));
+    }
+    data[byte_range_at..byte_range_at + array.len()].copy_from_slice(array.as_bytes());
+
+    Ok(Prepared { data, contents })
+}
+
+/// Resolves `object` if it is a reference.
+fn resolve(reader: &PdfReader, object: &Object) -> Option<Object> {
+    match object {
+        Object::Reference(id) => reader.resolve_reference(*id),
+        other => Some(other.clone()),
+    }
+}
+
+/// Returns a field name of the form `SignatureN` that no field in `fields`
+/// already has.
+fn field_name(reader: &PdfReader, fields: &PdfArray) -> String {
+    let taken: HashSet<Vec<u8>> = fields
+        .iter()
+        .filter_map(|field| match resolve(reader, field) {
+            Some(Object::Dictionary(field)) => match field.get("T") {
+                Some(Object::String(name)) => Some(name.as_bytes().to_vec()),
+                _ => None,
+            },
+            _ => None,
+        })
+        .collect();
+    (1..)
+        .map(|n| format!("Signature{}", n))
+        .find(|name| !taken.contains(name.as_bytes()))
+        .expect("names are unbounded")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::prelude::*;
+
+    fn document(object_streams: bool) -> Vec<u8> {
+        let page = PageBuilder::a4().build();
+        let doc = DocumentBuilder::new().page(page).object_streams(object_streams).build().unwrap();
+        doc.save_to_bytes().unwrap()
+    }
+
+    #[test]
+    fn test_prepare_rejects_encrypted() {
+        let mut doc = DocumentBuilder::new().page(PageBuilder::a4().build()).build().unwrap();
+        doc.encryption = Some(crate::encryption::EncryptionConfig::aes256().user_password("secret"));
+        let encrypted = doc.save_to_bytes().unwrap();
+        let result = prepare(encrypted, &SignatureConfig::new(), 16, "D:20250120120000Z");
+        assert!(matches!(result, Err(SignatureError::SigningFailed(m)) if m == "Encrypted files cannot be signed"));
+    }
+
+    #[test]
+    fn test_prepare() {
+        for object_streams in [false, true] {
+            let original = document(object_streams);
+            let config = SignatureConfig::new().reason("Approval ✓");
+            let prepared = prepare(original.clone(), &config, 16, "D:20250120120000Z").unwrap();
+            assert!(prepared.data.starts_with(&original));
+            assert_eq!(prepared.contents.len(), 32);
+            assert_eq!(prepared.signed_data().len(), prepared.data.len() - 34);
+
+            // The ranges cover all but /Contents, angle brackets included
+            let (gap, len) = (prepared.contents.start - 1..prepared.contents.end + 1, prepared.data.len());
+            let signed = prepared.embed(&[0xAB; 3]).unwrap();
+            let text = String::from_utf8_lossy(&signed);
+            let array = format!("/ByteRange [0 {} {} {}]", gap.start, gap.end, len - gap.end);
+            assert!(text.contains(&array));
+            assert_eq!(&signed[gap.start..gap.start + 10], b"<ABABAB000");
+
+            let reader = PdfReader::from_bytes(signed.to_vec()).unwrap();
+            let form = match reader.catalog().unwrap().get("AcroForm") {
+                Some(Object::Dictionary(form)) => form.clone(),
+                other => panic!("unexpected {:?}", other),
+            };
+            assert_eq!(form.get("SigFlags"), Some(&Object::Integer(3)));
+            let field = match form.get("Fields") {
+                Some(Object::Array(fields)) => fields.get(0).cloned().unwrap(),
+                other => panic!("unexpected {:?}", other),
+            };
+            let field = match resolve(&reader, &field) {
+                Some(Object::Dictionary(field)) => field,
+                other => panic!("unexpected {:?}", other),
+            };
+            assert_eq!(field.get("T"), Some(&Object::String(PdfString::literal("Signature1"))));
+            let sig = match field.get("V").and_then(|v| resolve(&reader, v)) {
+                Some(Object::Dictionary(sig)) => sig,
+                other => panic!("unexpected {:?}", other),
+            };
+            assert_eq!(sig.get("Reason"), Some(&Object::String(PdfString::text("Approval ✓"))));
+            let page = reader.resolve_reference(reader.page_ids().unwrap()[0]).unwrap();
+            assert!(matches!(page, Object::Dictionary(page) if page.contains_key("Annots")));
+        }
+    }
+
+    #[test]
+    fn test_second_signature() {
+        let config = SignatureConfig::new();
+        let once = prepare(document(false), &config, 8, "D:20250120120000Z").unwrap().embed(&[1]).unwrap();
+        let twice = prepare(once.clone(), &config, 8, "D:20250120120000Z").unwrap().embed(&[2]).unwrap();
+        assert!(twice.starts_with(&once));
+
+        let reader = PdfReader::from_bytes(twice).unwrap();
+        let text = String::from_utf8_lossy(reader.raw_data());
+        assert!(text.contains("(Signature2)"));
+        assert_eq!(text.matches("%%EOF").count(), 3);
+    }
+
+    #[test]
+    fn test_signature_too_large() {
+        let prepared = prepare(document(false), &SignatureConfig::new(), 2, "D:20250120120000Z").unwrap();
+        assert!(prepared.embed(&[0; 3]).is_err());
+    }
+}
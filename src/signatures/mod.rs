@@ -6,7 +6,8 @@
 //! # Features
 //!
 //! - Sign PDF documents with RSA or ECDSA keys
-//! - Support for X.509 certificates in PEM format
+//! - Support for X.509 certificates in PEM format, or PKCS#12 files
+//! - Signing finished PDFs with an incremental update
 //! - PKCS#7 (CMS) signature containers
 //! - Signature validation and verification
 //!
@@ -35,11 +36,14 @@
 
 mod certificate;
 mod config;
+mod incremental;
+mod pkcs12;
 mod pkcs7;
 mod signer;
 
-pub use certificate::{Certificate, PrivateKey};
+pub use certificate::{Certificate, KeyType, PrivateKey};
 pub use config::SignatureConfig;
+pub use pkcs12::Pkcs12;
 pub use pkcs7::Pkcs7Builder;
 pub use signer::{ByteRange, DocumentSigner, SignatureInfo};
 
@@ -0,0 +1,651 @@
+//! Reading PKCS#12 (.p12/.pfx) files.
+//!
+//! Files protected with PBES2, PBKDF2 with an HMAC-SHA-2 and AES-CBC, and a
+//! SHA-2 MAC are supported. That is the default of OpenSSL 3 and the
+//! "AES256-SHA256" choice of the Windows certificate export. The legacy RC2
+//! and Triple DES schemes are reported as unsupported.
+
+use super::{Certificate, PrivateKey, SignatureResult};
+use crate::error::SignatureError;
+use sha2::{Digest, Sha256, Sha384, Sha512};
+
+const SEQUENCE: u8 = 0x30;
+const SET: u8 = 0x31;
+const INTEGER: u8 = 0x02;
+const OCTET_STRING: u8 = 0x04;
+const OID: u8 = 0x06;
+const CONTEXT_0: u8 = 0xA0;
+const CONTEXT_0_PRIMITIVE: u8 = 0x80;
+
+const DATA: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x07, 0x01];
+const ENCRYPTED_DATA: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x07, 0x06];
+const KEY_BAG: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x0C, 0x0A, 0x01, 0x01];
+const SHROUDED_KEY_BAG: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x0C, 0x0A, 0x01, 0x02];
+const CERT_BAG: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x0C, 0x0A, 0x01, 0x03];
+const X509_CERTIFICATE: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x09, 0x16, 0x01];
+const LOCAL_KEY_ID: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x09, 0x15];
+const PBES2: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x05, 0x0D];
+const PBKDF2: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x05, 0x0C];
+/// Prefix of the password-based encryption schemes PKCS#12 itself defines.
+const LEGACY_PBE: &[u8] = &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x01, 0x0C, 0x01];
+const AES_CBC: [(&[u8], usize); 3] = [
+    (&[0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x01, 0x02], 16),
+    (&[0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x01, 0x16], 24),
+    (&[0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x01, 0x2A], 32),
+];
+
+/// Iteration counts above this are refused rather than spent minutes on.
+const MAX_ITERATIONS: u64 = 10_000_000;
+
+/// The signing identity read from a PKCS#12 file.
+#[derive(Debug, Clone)]
+pub struct Pkcs12 {
+    /// The certificate of the private key.
+    pub certificate: Certificate,
+    /// The other certificates in the file, such as intermediate authorities.
+    pub chain: Vec<Certificate>,
+    /// The private key.
+    pub private_key: PrivateKey,
+}
+
+impl Pkcs12 {
+    /// Reads a PKCS#12 file, decrypting it with `password`.
+    ///
+    /// The certificate whose local key ID matches the key's is the signer's;
+    /// without IDs, the first certificate is.
+    pub fn from_der(data: &[u8], password: &str) -> SignatureResult<Self> {
+        let contents = decode(data, password)?;
+        let (key, key_id) = contents
+            .keys
+            .into_iter()
+            .next()
+            .ok_or_else(|| SignatureError::Pkcs12Error("file holds no private key".to_string()))?;
+        if contents.certificates.is_empty() {
+            return Err(SignatureError::Pkcs12Error("file holds no certificate".to_string()));
+        }
+        let signer = contents
+            .certificates
+            .iter()
+            .position(|(_, id)| key_id.is_some() && *id == key_id)
+            .unwrap_or(0);
+
+        let mut certificate = None;
+        let mut chain = Vec::new();
+        for (i, (der, _)) in contents.certificates.iter().enumerate() {
+            let cert = Certificate::from_der(der)?;
+            if i == signer {
+                certificate = Some(cert);
+            } else {
+                chain.push(cert);
+            }
+        }
+        Ok(Self {
+            certificate: certificate.expect("signer is an index into the certificates"),
+            chain,
+            private_key: PrivateKey::from_pkcs8_der(&key)?,
+        })
+    }
+}
+
+/// An item paired with its local key ID attribute, if it has one.
+type Bag = (Vec<u8>, Option<Vec<u8>>);
+
+/// The decrypted contents of a PKCS#12 file, as DER.
+#[derive(Debug, Default)]
+struct Contents {
+    /// PKCS#8 private keys.
+    keys: Vec<Bag>,
+    /// X.509 certificates.
+    certificates: Vec<Bag>,
+}
+
+/// Checks the MAC of a PKCS#12 file and decrypts its contents.
+fn decode(data: &[u8], password: &str) -> SignatureResult<Contents> {
+    let mut pfx = Reader::new(data).expect(SEQUENCE)?.reader();
+    let version = pfx.expect(INTEGER)?.integer()?;
+    if version != 3 {
+        return Err(SignatureError::Pkcs12Error(format!("unsupported version {}", version)));
+    }
+    let (content_type, content) = content_info(pfx.expect(SEQUENCE)?)?;
+    if content_type != DATA {
+        return Err(SignatureError::UnsupportedAlgorithm("public-key protected PKCS#12".to_string()));
+    }
+    let safe = content.reader().expect(OCTET_STRING)?.octets()?;
+    if let Some(mac) = pfx.optional(SEQUENCE)? {
+        verify_mac(mac, &safe, password)?;
+    }
+
+    let mut contents = Contents::default();
+    let mut infos = Reader::new(&safe).expect(SEQUENCE)?.reader();
+    while !infos.is_empty() {
+        let (content_type, content) = content_info(infos.expect(SEQUENCE)?)?;
+        if content_type == DATA {
+            let bags = content.reader().expect(OCTET_STRING)?.octets()?;
+            read_bags(&bags, password, &mut contents)?;
+        } else if content_type == ENCRYPTED_DATA {
+            let mut encrypted = content.reader().expect(SEQUENCE)?.reader();
+            encrypted.expect(INTEGER)?;
+            let mut info = encrypted.expect(SEQUENCE)?.reader();
+            info.expect(OID)?;
+            let algorithm = info.expect(SEQUENCE)?;
+            let ciphertext = match info.optional(CONTEXT_0_PRIMITIVE)? {
+                Some(element) => element.content.to_vec(),
+                None => info.expect(CONTEXT_0)?.octets()?,
+            };
+            let bags = decrypt(algorithm, &ciphertext, password)?;
+            read_bags(&bags, password, &mut contents)?;
+        } else {
+            return Err(SignatureError::UnsupportedAlgorithm("public-key protected PKCS#12".to_string()));
+        }
+    }
+    Ok(contents)
+}
+
+/// Splits a ContentInfo into its type and its explicitly tagged content.
+fn content_info(info: Element<'_>) -> SignatureResult<(&[u8], Element<'_>)> {
+    let mut info = info.reader();
+    let content_type = info.expect(OID)?.content;
+    Ok((content_type, info.expect(CONTEXT_0)?))
+}
+
+/// Adds the keys and certificates in a SafeContents to `contents`.
+fn read_bags(data: &[u8], password: &str, contents: &mut Contents) -> SignatureResult<()> {
+    let mut bags = Reader::new(data).expect(SEQUENCE)?.reader();
+    while !bags.is_empty() {
+        let mut bag = bags.expect(SEQUENCE)?.reader();
+        let kind = bag.expect(OID)?.content;
+        let value = bag.expect(CONTEXT_0)?.reader().next()?;
+        let key_id = match bag.optional(SET)? {
+            Some(attributes) => local_key_id(attributes)?,
+            None => None,
+        };
+
+        if kind == KEY_BAG {
+            contents.keys.push((value.raw.to_vec(), key_id));
+        } else if kind == SHROUDED_KEY_BAG {
+            let mut info = value.reader();
+            let algorithm = info.expect(SEQUENCE)?;
+            let ciphertext = info.expect(OCTET_STRING)?.octets()?;
+            contents.keys.push((decrypt(algorithm, &ciphertext, password)?, key_id));
+        } else if kind == CERT_BAG {
+            let mut cert = value.reader();
+            if cert.expect(OID)?.content == X509_CERTIFICATE {
+                let der = cert.expect(CONTEXT_0)?.reader().expect(OCTET_STRING)?.octets()?;
+                contents.certificates.push((der, key_id));
+            }
+        }
+        // CRLs, secrets and nested SafeContents are not needed for signing
+    }
+    Ok(())
+}
+
+/// Returns the localKeyId value in a bag's attributes.
+fn local_key_id(attributes: Element<'_>) -> SignatureResult<Option<Vec<u8>>> {
+    let mut attributes = attributes.reader();
+    while !attributes.is_empty() {
+        let mut attribute = attributes.expect(SEQUENCE)?.reader();
+        if attribute.expect(OID)?.content == LOCAL_KEY_ID {
+            let value = attribute.expect(SET)?.reader().expect(OCTET_STRING)?.octets()?;
+            return Ok(Some(value));
+        }
+    }
+    Ok(None)
+}
+
+/// Checks the MacData of a PKCS#12 file against its authenticated content.
+fn verify_mac(mac_data: Element<'_>, content: &[u8], password: &str) -> SignatureResult<()> {
+    let mut mac_data = mac_data.reader();
+    let mut digest_info = mac_data.expect(SEQUENCE)?.reader();
+    let algorithm = digest_info.expect(SEQUENCE)?.reader().expect(OID)?.content;
+    let hash = Hash::from_digest_oid(algorithm).ok_or_else(|| {
+        SignatureError::UnsupportedAlgorithm(
+            "legacy PKCS#12 MAC (not SHA-2); re-export the file with AES-256 and a SHA-256 MAC".to_string(),
+        )
+    })?;
+    let expected = digest_info.expect(OCTET_STRING)?.content;
+    let salt = mac_data.expect(OCTET_STRING)?.content;
+    let iterations = match mac_data.optional(INTEGER)? {
+        Some(count) => iterations(count)?,
+        None => 1,
+    };
+
+    // PKCS#12 passwords are NUL-terminated UTF-16; some writers encode an
+    // empty password as no bytes at all
+    let mut bmp: Vec<u8> = password.encode_utf16().flat_map(u16::to_be_bytes).collect();
+    bmp.extend_from_slice(&[0, 0]);
+    let candidates: &[&[u8]] = if password.is_empty() { &[&bmp, &[]] } else { &[&bmp] };
+    for candidate in candidates {
+        let key = pkcs12_kdf(hash, candidate, salt, 3, iterations, hash.output_size());
+        if hmac(hash, &key, content) == expected {
+            return Ok(());
+        }
+    }
+    Err(SignatureError::Pkcs12Error("wrong password, or the file is damaged".to_string()))
+}
+
+/// Decrypts data protected by the AlgorithmIdentifier `algorithm`.
+fn decrypt(algorithm: Element<'_>, ciphertext: &[u8], password: &str) -> SignatureResult<Vec<u8>> {
+    let mut algorithm = algorithm.reader();
+    let scheme = algorithm.expect(OID)?.content;
+    if scheme.starts_with(LEGACY_PBE) {
+        return Err(SignatureError::UnsupportedAlgorithm(
+            "legacy PKCS#12 encryption (RC2 or Triple DES); re-export the file with AES-256".to_string(),
+        ));
+    }
+    if scheme != PBES2 {
+        return Err(SignatureError::UnsupportedAlgorithm("PKCS#12 encryption scheme".to_string()));
+    }
+    let mut params = algorithm.expect(SEQUENCE)?.reader();
+
+    let mut kdf = params.expect(SEQUENCE)?.reader();
+    if kdf.expect(OID)?.content != PBKDF2 {
+        return Err(SignatureError::UnsupportedAlgorithm("PBES2 key derivation other than PBKDF2".to_string()));
+    }
+    let mut kdf = kdf.expect(SEQUENCE)?.reader();
+    let salt = kdf.expect(OCTET_STRING)?.content;
+    let iterations = iterations(kdf.expect(INTEGER)?)?;
+    kdf.optional(INTEGER)?;
+    let prf = match kdf.optional(SEQUENCE)? {
+        Some(prf) => Hash::from_hmac_oid(prf.reader().expect(OID)?.content),
+        None => None, // HMAC-SHA-1
+    };
+    let prf = prf.ok_or_else(|| {
+        SignatureError::UnsupportedAlgorithm("PBKDF2 with HMAC-SHA-1; re-export the file with AES-256".to_string())
+    })?;
+
+    let mut cipher = params.expect(SEQUENCE)?.reader();
+    let cipher_oid = cipher.expect(OID)?.content;
+    let key_len = AES_CBC
+        .iter()
+        .find(|(oid, _)| *oid == cipher_oid)
+        .map(|&(_, len)| len)
+        .ok_or_else(|| SignatureError::UnsupportedAlgorithm("PBES2 cipher other than AES-CBC".to_string()))?;
+    let iv = cipher.expect(OCTET_STRING)?.content;
+
+    let key = pbkdf2(prf, password.as_bytes(), salt, iterations, key_len);
+    let plaintext = match key_len {
+        16 => aes_cbc_decrypt::<aes::Aes128>(&key, iv, ciphertext),
+        24 => aes_cbc_decrypt::<aes::Aes192>(&key, iv, ciphertext),
+        _ => aes_cbc_decrypt::<aes::Aes256>(&key, iv, ciphertext),
+    };
+    plaintext.ok_or_else(|| SignatureError::Pkcs12Error("wrong password, or the file is damaged".to_string()))
+}
+
+/// AES-CBC decryption with PKCS#7 padding. Returns `None` if the key or IV
+/// has the wrong length or the padding is invalid.
+fn aes_cbc_decrypt<C>(key: &[u8], iv: &[u8], ciphertext: &[u8]) -> Option<Vec<u8>>
+where
+    cbc::Decryptor<C>: aes::cipher::KeyIvInit + aes::cipher::BlockDecryptMut,
+{
+    use aes::cipher::{block_padding::Pkcs7, BlockDecryptMut, KeyIvInit};
+
+    let decryptor = cbc::Decryptor::<C>::new_from_slices(key, iv).ok()?;
+    let mut buf = ciphertext.to_vec();
+    let len = decryptor.decrypt_padded_mut::<Pkcs7>(&mut buf).ok()?.len();
+    buf.truncate(len);
+    Some(buf)
+}
+
+/// Reads an iteration count, refusing unreasonable ones.
+fn iterations(count: Element<'_>) -> SignatureResult<u64> {
+    match count.integer()? {
+        0 => Err(SignatureError::Pkcs12Error("iteration count is zero".to_string())),
+        n if n > MAX_ITERATIONS => Err(SignatureError::Pkcs12Error(format!("iteration count {} is too large", n))),
+        n => Ok(n),
+    }
+}
+
+/// The hash functions PKCS#12 MACs and PBKDF2 may use.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Hash {
+    Sha256,
+    Sha384,
+    Sha512,
+}
+
+impl Hash {
+    /// Returns the hash identified by a digest algorithm OID.
+    fn from_digest_oid(oid: &[u8]) -> Option<Self> {
+        match oid {
+            [0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01] => Some(Hash::Sha256),
+            [0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02] => Some(Hash::Sha384),
+            [0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03] => Some(Hash::Sha512),
+            _ => None,
+        }
+    }
+
+    /// Returns the hash of an HMAC identified by its OID.
+    fn from_hmac_oid(oid: &[u8]) -> Option<Self> {
+        match oid {
+            [0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x02, 0x09] => Some(Hash::Sha256),
+            [0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x02, 0x0A] => Some(Hash::Sha384),
+            [0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x02, 0x0B] => Some(Hash::Sha512),
+            _ => None,
+        }
+    }
+
+    fn block_size(self) -> usize {
+        match self {
+            Hash::Sha256 => 64,
+            Hash::Sha384 | Hash::Sha512 => 128,
+        }
+    }
+
+    fn output_size(self) -> usize {
+        match self {
+            Hash::Sha256 => 32,
+            Hash::Sha384 => 48,
+            Hash::Sha512 => 64,
+        }
+    }
+
+    /// Hashes the concatenation of `parts`.
+    fn digest(self, parts: &[&[u8]]) -> Vec<u8> {
+        fn run<D: Digest>(parts: &[&[u8]]) -> Vec<u8> {
+            let mut hasher = D::new();
+            for part in parts {
+                hasher.update(part);
+            }
+            hasher.finalize().to_vec()
+        }
+        match self {
+            Hash::Sha256 => run::<Sha256>(parts),
+            Hash::Sha384 => run::<Sha384>(parts),
+            Hash::Sha512 => run::<Sha512>(parts),
+        }
+    }
+}
+
+/// HMAC (RFC 2104).
+fn hmac(hash: Hash, key: &[u8], message: &[u8]) -> Vec<u8> {
+    let mut block = if key.len() > hash.block_size() { hash.digest(&[key]) } else { key.to_vec() };
+    block.resize(hash.block_size(), 0);
+    let inner_pad: Vec<u8> = block.iter().map(|b| b ^ 0x36).collect();
+    let outer_pad: Vec<u8> = block.iter().map(|b| b ^ 0x5C).collect();
+    let inner = hash.digest(&[&inner_pad, message]);
+    hash.digest(&[&outer_pad, &inner])
+}
+
+/// PBKDF2 (RFC 8018) with HMAC over `hash`.
+fn pbkdf2(hash: Hash, password: &[u8], salt: &[u8], iterations: u64, len: usize) -> Vec<u8> {
+    let mut key = Vec::with_capacity(len);
+    let mut block = 1u32;
+    while key.len() < len {
+        let mut u = hmac(hash, password, &[salt, &block.to_be_bytes()].concat());
+        let mut t = u.clone();
+        for _ in 1..iterations {
+            u = hmac(hash, password, &u);
+            t.iter_mut().zip(&u).for_each(|(t, u)| *t ^= u);
+        }
+        key.extend_from_slice(&t);
+        block += 1;
+    }
+    key.truncate(len);
+    key
+}
+
+/// The key derivation of PKCS#12 (RFC 7292 appendix B.2), for purpose `id`.
+fn pkcs12_kdf(hash: Hash, password: &[u8], salt: &[u8], id: u8, iterations: u64, len: usize) -> Vec<u8> {
+    let v = hash.block_size();
+    let fill = |data: &[u8]| -> Vec<u8> {
+        let n = data.len().div_ceil(v) * v;
+        data.iter().copied().cycle().take(n).collect()
+    };
+    let diversifier = vec![id; v];
+    let mut input = fill(salt);
+    input.extend(fill(password));
+
+    let mut key = Vec::with_capacity(len);
+    loop {
+        let mut a = hash.digest(&[&diversifier, &input]);
+        for _ in 1..iterations {
+            a = hash.digest(&[&a]);
+        }
+        key.extend_from_slice(&a);
+        if key.len() >= len {
+            key.truncate(len);
+            return key;
+        }
+        // Add A, repeated to a block, plus one to each block of the input
+        let b: Vec<u8> = a.iter().copied().cycle().take(v).collect();
+        for chunk in input.chunks_mut(v) {
+            let mut carry = 1u16;
+            for (x, y) in chunk.iter_mut().zip(&b).rev() {
+                let sum = *x as u16 + *y as u16 + carry;
+                *x = sum as u8;
+                carry = sum >> 8;
+            }
+        }
+    }
+}
+
+/// A BER element.
+#[derive(Debug, Clone, Copy)]
+struct Element<'a> {
+    tag: u8,
+    /// The content octets.
+    content: &'a [u8],
+    /// The whole encoding, tag and length included.
+    raw: &'a [u8],
+}
+
+impl<'a> Element<'a> {
+    /// Returns a reader over the elements in a constructed element.
+    fn reader(&self) -> Reader<'a> {
+        Reader::new(self.content)
+    }
+
+    /// Returns the value of a primitive or constructed string.
+    fn octets(&self) -> SignatureResult<Vec<u8>> {
+        if self.tag & 0x20 == 0 {
+            return Ok(self.content.to_vec());
+        }
+        let mut octets = Vec::new();
+        let mut segments = self.reader();
+        while !segments.is_empty() {
+            octets.extend(segments.next()?.octets()?);
+        }
+        Ok(octets)
+    }
+
+    /// Returns the value of a non-negative INTEGER that fits in 64 bits.
+    fn integer(&self) -> SignatureResult<u64> {
+        let digits = match self.content {
+            [0, rest @ ..] => rest,
+            digits => digits,
+        };
+        if self.content.is_empty() || self.content[0] & 0x80 != 0 || digits.len() > 8 {
+            return Err(malformed());
+        }
+        Ok(digits.iter().fold(0, |n, &d| n << 8 | d as u64))
+    }
+}
+
+/// Reads BER elements one after another.
+struct Reader<'a> {
+    data: &'a [u8],
+}
+
+impl<'a> Reader<'a> {
+    fn new(data: &'a [u8]) -> Self {
+        Self { data }
+    }
+
+    fn is_empty(&self) -> bool {
+        self.data.is_empty()
+    }
+
+    /// Reads the next element, of any tag.
+    fn next(&mut self) -> SignatureResult<Element<'a>> {
+        let data = self.data;
+        let (&tag, rest) = data.split_first().ok_or_else(malformed)?;
+        if tag & 0x1F == 0x1F {
+            return Err(malformed());
+        }
+        let (&first, rest) = rest.split_first().ok_or_else(malformed)?;
+        let header = data.len() - rest.len();
+        let (content, len) = if first == 0x80 {
+            // Indefinite length: the content runs up to an end-of-contents marker
+            if tag & 0x20 == 0 {
+                return Err(malformed());
+            }
+            let mut inner = Reader::new(rest);
+            while !inner.data.starts_with(&[0, 0]) {
+                inner.next()?;
+            }
+            let content_len = rest.len() - inner.data.len();
+            (&rest[..content_len], header + content_len + 2)
+        } else if first & 0x80 == 0 {
+            let len = first as usize;
+            (rest.get(..len).ok_or_else(malformed)?, header + len)
+        } else {
+            let count = (first & 0x7F) as usize;
+            if count > 4 {
+                return Err(malformed());
+            }
+            let bytes = rest.get(..count).ok_or_else(malformed)?;
+            let len = bytes.iter().fold(0usize, |n, &b| n << 8 | b as usize);
+            let content = rest.get(count..).and_then(|r| r.get(..len)).ok_or_else(malformed)?;
+            (content, header + count + len)
+        };
+        self.data = &data[len..];
+        Ok(Element { tag, content, raw: &data[..len] })
+    }
+
+    /// Reads the next element, which must have tag `tag`.
+    fn expect(&mut self, tag: u8) -> SignatureResult<Element<'a>> {
+        match self.optional(tag)? {
+            Some(element) => Ok(element),
+            None => Err(malformed()),
+        }
+    }
+
+    /// Reads the next element if it has tag `tag`.
+    fn optional(&mut self, tag: u8) -> SignatureResult<Option<Element<'a>>> {
+        match self.data.first() {
+            Some(&next) if next == tag => self.next().map(Some),
+            _ => Ok(None),
+        }
+    }
+}
+
+fn malformed() -> SignatureError {
+    SignatureError::Pkcs12Error("malformed file".to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn tlv(tag: u8, content: &[u8]) -> Vec<u8> {
+        let mut out = vec![tag];
+        match content.len() {
+            len if len < 0x80 => out.push(len as u8),
+            len if len < 0x100 => out.extend_from_slice(&[0x81, len as u8]),
+            len => out.extend_from_slice(&[0x82, (len >> 8) as u8, len as u8]),
+        }
+        out.extend_from_slice(content);
+        out
+    }
+
+    fn seq(parts: &[&[u8]]) -> Vec<u8> {
+        tlv(SEQUENCE, &parts.concat())
+    }
+
+    /// Encrypts `plaintext` with PBES2, PBKDF2-HMAC-SHA-256 and AES-256-CBC,
+    /// returning the AlgorithmIdentifier and the ciphertext.
+    fn encrypt(plaintext: &[u8], password: &str) -> (Vec<u8>, Vec<u8>) {
+        use aes::cipher::{block_padding::Pkcs7, BlockEncryptMut, KeyIvInit};
+
+        let (salt, iv) = ([7u8; 8], [9u8; 16]);
+        let key = pbkdf2(Hash::Sha256, password.as_bytes(), &salt, 2, 32);
+        let mut buf = plaintext.to_vec();
+        buf.resize(plaintext.len() / 16 * 16 + 16, 0);
+        let ciphertext = cbc::Encryptor::<aes::Aes256>::new_from_slices(&key, &iv)
+            .unwrap()
+            .encrypt_padded_mut::<Pkcs7>(&mut buf, plaintext.len())
+            .unwrap()
+            .to_vec();
+
+        let prf = seq(&[&tlv(OID, &[0x2A, 0x86, 0x48, 0x86, 0xF7, 0x0D, 0x02, 0x09]), &[0x05, 0x00]]);
+        let kdf_params = seq(&[&tlv(OCTET_STRING, &salt), &tlv(INTEGER, &[2]), &prf]);
+        let kdf = seq(&[&tlv(OID, PBKDF2), &kdf_params]);
+        let cipher = seq(&[&tlv(OID, AES_CBC[2].0), &tlv(OCTET_STRING, &iv)]);
+        (seq(&[&tlv(OID, PBES2), &seq(&[&kdf, &cipher])]), ciphertext)
+    }
+
+    /// Builds a PKCS#12 file the way OpenSSL 3 lays one out: certificates in
+    /// an encrypted content, then a shrouded key, under a SHA-256 MAC.
+    fn build_pfx(key: &[u8], certificates: &[(&[u8], &[u8])], password: &str) -> Vec<u8> {
+        let attributes = |id: &[u8]| tlv(SET, &seq(&[&tlv(OID, LOCAL_KEY_ID), &tlv(SET, &tlv(OCTET_STRING, id))]));
+        let cert_bags: Vec<u8> = certificates
+            .iter()
+            .flat_map(|&(der, id)| {
+                let value = seq(&[&tlv(OID, X509_CERTIFICATE), &tlv(CONTEXT_0, &tlv(OCTET_STRING, der))]);
+                let attrs = if id.is_empty() { Vec::new() } else { attributes(id) };
+                seq(&[&tlv(OID, CERT_BAG), &tlv(CONTEXT_0, &value), &attrs])
+            })
+            .collect();
+        let (algorithm, ciphertext) = encrypt(&tlv(SEQUENCE, &cert_bags), password);
+        let encrypted_info = seq(&[&tlv(OID, DATA), &algorithm, &tlv(CONTEXT_0_PRIMITIVE, &ciphertext)]);
+        let encrypted = seq(&[&tlv(INTEGER, &[0]), &encrypted_info]);
+        let certs_info = seq(&[&tlv(OID, ENCRYPTED_DATA), &tlv(CONTEXT_0, &encrypted)]);
+
+        let (algorithm, ciphertext) = encrypt(key, password);
+        let shrouded = seq(&[&algorithm, &tlv(OCTET_STRING, &ciphertext)]);
+        let key_bag = seq(&[&tlv(OID, SHROUDED_KEY_BAG), &tlv(CONTEXT_0, &shrouded), &attributes(b"id1")]);
+        let key_info = seq(&[&tlv(OID, DATA), &tlv(CONTEXT_0, &tlv(OCTET_STRING, &seq(&[&key_bag])))]);
+
+        let safe = seq(&[&certs_info, &key_info]);
+        let mut bmp: Vec<u8> = password.encode_utf16().flat_map(u16::to_be_bytes).collect();
+        bmp.extend_from_slice(&[0, 0]);
+        let salt = [3u8; 8];
+        let mac_key = pkcs12_kdf(Hash::Sha256, &bmp, &salt, 3, 2, 32);
+        let mac = hmac(Hash::Sha256, &mac_key, &safe);
+        let sha256 = tlv(OID, &[0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01]);
+        let mac_data = seq(&[&seq(&[&seq(&[&sha256, &[0x05, 0x00]]), &tlv(OCTET_STRING, &mac)]), &tlv(OCTET_STRING, &salt), &tlv(INTEGER, &[2])]);
+
+        let auth_safe = seq(&[&tlv(OID, DATA), &tlv(CONTEXT_0, &tlv(OCTET_STRING, &safe))]);
+        seq(&[&tlv(INTEGER, &[3]), &auth_safe, &mac_data])
+    }
+
+    fn hex(bytes: &[u8]) -> String {
+        bytes.iter().map(|b| format!("{:02x}", b)).collect()
+    }
+
+    #[test]
+    fn test_hmac_and_pbkdf2() {
+        // RFC 4231 test case 2 and the PBKDF2-HMAC-SHA-256 vector of RFC 7914
+        let mac = hmac(Hash::Sha256, b"Jefe", b"what do ya want for nothing?");
+        assert_eq!(hex(&mac), "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843");
+        let key = pbkdf2(Hash::Sha256, b"passwd", b"salt", 1, 64);
+        assert_eq!(
+            hex(&key),
+            "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc\
+             49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+        );
+    }
+
+    #[test]
+    fn test_decode() {
+        let key = seq(&[&tlv(INTEGER, &[0])]);
+        let pfx = build_pfx(&key, &[(b"\x30\x01\x01", b""), (b"\x30\x01\x02", b"id1")], "s3cret");
+        let contents = decode(&pfx, "s3cret").unwrap();
+        assert_eq!(contents.keys, [(key, Some(b"id1".to_vec()))]);
+        assert_eq!(contents.certificates.len(), 2);
+        assert_eq!(contents.certificates[1], (b"\x30\x01\x02".to_vec(), Some(b"id1".to_vec())));
+
+        assert!(matches!(decode(&pfx, "wrong"), Err(SignatureError::Pkcs12Error(_))));
+        assert!(matches!(decode(&pfx[..40], "s3cret"), Err(SignatureError::Pkcs12Error(_))));
+    }
+
+    #[test]
+    fn test_indefinite_length() {
+        // An OCTET STRING split into two segments, inside an indefinite SEQUENCE
+        let data = [0x30, 0x80, 0x24, 0x80, 0x04, 0x01, b'a', 0x04, 0x01, b'b', 0, 0, 0, 0];
+        let mut reader = Reader::new(&data);
+        let outer = reader.expect(SEQUENCE).unwrap();
+        assert!(reader.is_empty());
+        assert_eq!(outer.reader().next().unwrap().octets().unwrap(), b"ab");
+    }
+}
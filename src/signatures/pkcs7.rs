@@ -65,7 +65,7 @@ impl Pkcs7Builder {
 
         // Create the signed attributes and sign them
         let signed_attrs = self.build_signed_attributes(&digest)?;
-        let signature = private_key.sign(&signed_attrs)?;
+        let signature = private_key.sign_with(&signed_attrs, self.algorithm)?;
 
         // Build the CMS SignedData structure
         let cms_data = self.build_cms_signed_data(cert, &digest, &signature)?;
@@ -245,10 +245,10 @@ impl Pkcs7Builder {
         // DigestAlgorithm
         signer_info.extend_from_slice(&self.build_digest_algorithm_identifier());
 
-        // SignedAttrs [0] IMPLICIT
-        let signed_attrs = self.build_signed_attributes(digest)?;
-        let signed_attrs_implicit = build_context_specific(0, &signed_attrs[1..], true); // Skip SET tag
-        signer_info.extend_from_slice(&signed_attrs_implicit);
+        // SignedAttrs [0] IMPLICIT: the signed SET with its tag replaced
+        let mut signed_attrs = self.build_signed_attributes(digest)?;
+        signed_attrs[0] = 0xA0;
+        signer_info.extend_from_slice(&signed_attrs);
 
         // SignatureAlgorithm
         signer_info.extend_from_slice(&self.build_signature_algorithm_identifier());
@@ -282,7 +282,10 @@ impl Pkcs7Builder {
 
         let mut alg_id = Vec::new();
         alg_id.extend_from_slice(&build_oid(&oid_bytes));
-        alg_id.extend_from_slice(&[0x05, 0x00]); // NULL parameters
+        if self.algorithm != SignatureAlgorithm::EcdsaP256Sha256 {
+            // NULL parameters; ECDSA identifiers have none (RFC 5758)
+            alg_id.extend_from_slice(&[0x05, 0x00]);
+        }
 
         build_sequence(&alg_id)
     }
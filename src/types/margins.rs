@@ -0,0 +1,71 @@
+//! Page margins.
+
+use super::Rectangle;
+
+/// Space in points between the page edges and the content area.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Margins {
+    /// Space below the top edge.
+    pub top: f64,
+    /// Space inside the right edge.
+    pub right: f64,
+    /// Space above the bottom edge.
+    pub bottom: f64,
+    /// Space inside the left edge.
+    pub left: f64,
+}
+
+impl Margins {
+    /// Creates margins in CSS order: top, right, bottom, left.
+    pub fn new(top: f64, right: f64, bottom: f64, left: f64) -> Self {
+        Self {
+            top,
+            right,
+            bottom,
+            left,
+        }
+    }
+
+    /// Creates equal margins on all four sides.
+    pub fn uniform(margin: f64) -> Self {
+        Self::new(margin, margin, margin, margin)
+    }
+
+    /// Returns the area of `page` inside the margins.
+    ///
+    /// Margins wider or taller than the page leave an empty rectangle rather
+    /// than an inverted one.
+    pub fn content_rect(&self, page: &Rectangle) -> Rectangle {
+        let llx = page.llx + self.left;
+        let lly = page.lly + self.bottom;
+        Rectangle::new(
+            llx,
+            lly,
+            (page.urx - self.right).max(llx),
+            (page.ury - self.top).max(lly),
+        )
+    }
+}
+
+impl Default for Margins {
+    /// One inch on every side.
+    fn default() -> Self {
+        Self::uniform(72.0)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_content_rect() {
+        let margins = Margins::new(72.0, 36.0, 54.0, 18.0);
+        let rect = margins.content_rect(&Rectangle::letter());
+        assert_eq!(rect, Rectangle::new(18.0, 54.0, 576.0, 720.0));
+
+        let rect = Margins::uniform(400.0).content_rect(&Rectangle::letter());
+        assert_eq!(rect.width(), 0.0);
+        assert_eq!(rect.height(), 0.0);
+    }
+}
@@ -1,9 +1,11 @@
 //! Basic PDF types used throughout the library.
 
+mod margins;
 mod matrix;
 mod object_id;
 mod rectangle;
 
+pub use margins::Margins;
 pub use matrix::Matrix;
 pub use object_id::ObjectId;
 pub use rectangle::Rectangle;
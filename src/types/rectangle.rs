@@ -83,6 +83,49 @@ impl Rectangle {
     pub fn with_origin(&self) -> Self {
         Self::from_dimensions(self.width(), self.height())
     }
+
+    /// Returns whether `other` lies entirely within this rectangle.
+    pub fn contains(&self, other: &Rectangle) -> bool {
+        other.llx >= self.llx && other.lly >= self.lly && other.urx <= self.urx && other.ury <= self.ury
+    }
+
+    /// Returns whether the rectangle is wider than it is tall.
+    pub fn is_landscape(&self) -> bool {
+        self.width() > self.height()
+    }
+
+    /// Returns the rectangle in landscape orientation (width >= height).
+    ///
+    /// The lower-left corner is kept; a rectangle that is already landscape
+    /// is returned unchanged.
+    pub fn landscape(&self) -> Self {
+        if self.is_landscape() {
+            *self
+        } else {
+            self.swapped()
+        }
+    }
+
+    /// Returns the rectangle in portrait orientation (height >= width).
+    ///
+    /// The lower-left corner is kept; a rectangle that is already portrait
+    /// is returned unchanged.
+    pub fn portrait(&self) -> Self {
+        if self.is_landscape() {
+            self.swapped()
+        } else {
+            *self
+        }
+    }
+
+    fn swapped(&self) -> Self {
+        Self::new(
+            self.llx,
+            self.lly,
+            self.llx + self.height(),
+            self.lly + self.width(),
+        )
+    }
 }
 
 impl Default for Rectangle {
@@ -134,6 +177,31 @@ mod tests {
         assert_eq!(rect.height(), 792.0);
     }
 
+    #[test]
+    fn test_landscape_portrait() {
+        let rect = Rectangle::letter().landscape();
+        assert_eq!(rect.width(), 792.0);
+        assert_eq!(rect.height(), 612.0);
+        assert!(rect.is_landscape());
+        assert_eq!(rect.landscape(), rect);
+
+        let rect = rect.portrait();
+        assert_eq!(rect, Rectangle::letter());
+        assert_eq!(rect.portrait(), rect);
+
+        let offset = Rectangle::new(10.0, 20.0, 110.0, 220.0).landscape();
+        assert_eq!(offset, Rectangle::new(10.0, 20.0, 210.0, 120.0));
+    }
+
+    #[test]
+    fn test_contains() {
+        let page = Rectangle::letter();
+        assert!(page.contains(&Rectangle::new(9.0, 9.0, 603.0, 783.0)));
+        assert!(page.contains(&page));
+        assert!(!page.contains(&Rectangle::new(-9.0, 0.0, 612.0, 792.0)));
+        assert!(!page.contains(&Rectangle::new(0.0, 0.0, 612.0, 800.0)));
+    }
+
     #[test]
     fn test_to_array() {
         let rect = Rectangle::new(1.0, 2.0, 3.0, 4.0);
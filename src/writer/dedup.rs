@@ -0,0 +1,204 @@
+//! Merging of identical objects.
+//!
+//! A document that draws the same image or uses the same font on several
+//! pages otherwise carries a copy for each. Objects that may be shared are
+//! compared by their serialized form, with dictionary keys sorted and
+//! references to merged objects pointing at the copy kept. Merging repeats
+//! until nothing changes, since two forms drawing two copies of an image
+//! only match once the copies have been merged.
+
+use std::collections::hash_map::Entry;
+use std::collections::HashMap;
+
+use super::linearize::Buffered;
+use super::Serializer;
+use crate::error::{PdfResult, WriterError};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfStream};
+use crate::types::ObjectId;
+
+/// The objects left after merging, renumbered from 1 in the order they were
+/// written, and the new number of every object written.
+pub(super) struct Merged {
+    pub objects: Vec<Buffered>,
+    pub numbers: HashMap<u32, u32>,
+}
+
+/// Merges identical shareable objects, replacing every reference to a
+/// merged object with one to the copy kept.
+pub(super) fn deduplicate(objects: Vec<Buffered>) -> PdfResult<Merged> {
+    // The object each merged one is replaced with
+    let mut merged: HashMap<u32, u32> = HashMap::new();
+    loop {
+        let mut kept: HashMap<(bool, Vec<u8>), u32> = HashMap::new();
+        let mut changed = false;
+        for b in &objects {
+            if !shareable(&b.object) || merged.contains_key(&b.id.number) {
+                continue;
+            }
+            let mut serializer = Serializer::new(Vec::new());
+            serializer
+                .write_object(ObjectId::new(0), &normalize(&b.object, &merged))
+                .map_err(|e| WriterError::Structure(e.to_string()))?;
+            match kept.entry((b.encrypt, serializer.into_inner())) {
+                Entry::Occupied(entry) => {
+                    merged.insert(b.id.number, *entry.get());
+                    changed = true;
+                }
+                Entry::Vacant(entry) => {
+                    entry.insert(b.id.number);
+                }
+            }
+        }
+        if !changed {
+            break;
+        }
+        // An object kept this round may itself have been merged in a later one
+        merged = merged.keys().map(|&number| (number, resolve(&merged, number))).collect();
+    }
+
+    let objects: Vec<Buffered> = objects.into_iter().filter(|b| !merged.contains_key(&b.id.number)).collect();
+    let mut numbers: HashMap<u32, u32> =
+        objects.iter().enumerate().map(|(i, b)| (b.id.number, i as u32 + 1)).collect();
+    for (number, copy) in &merged {
+        numbers.insert(*number, numbers[copy]);
+    }
+    let objects = objects
+        .into_iter()
+        .map(|b| Buffered {
+            id: ObjectId::new(numbers[&b.id.number]),
+            object: rewrite(&b.object, &|number| numbers.get(&number).copied(), false),
+            encrypt: b.encrypt,
+        })
+        .collect();
+    Ok(Merged { objects, numbers })
+}
+
+/// Returns whether `object` may be shared by everything that refers to it.
+/// Pages, annotations, form fields, outline items and the like each need
+/// their own object even when they look alike.
+fn shareable(object: &Object) -> bool {
+    match object {
+        Object::Stream(_) => true,
+        Object::Dictionary(dict) => {
+            matches!(dict.get("Type"), Some(Object::Name(name)) if matches!(name.as_str(), "Font" | "FontDescriptor" | "ExtGState"))
+                || dict.contains_key("PatternType")
+                || dict.contains_key("ShadingType")
+                || dict.contains_key("FunctionType")
+        }
+        _ => false,
+    }
+}
+
+/// Follows `merged` from `number` to the copy that was kept.
+fn resolve(merged: &HashMap<u32, u32>, mut number: u32) -> u32 {
+    while let Some(&copy) = merged.get(&number) {
+        number = copy;
+    }
+    number
+}
+
+/// Returns `object` as it is compared: keys sorted and merged objects
+/// replaced.
+fn normalize(object: &Object, merged: &HashMap<u32, u32>) -> Object {
+    rewrite(object, &|number| merged.get(&number).copied(), true)
+}
+
+/// Replaces the references `renumber` gives a new number for, and sorts
+/// dictionary keys if `sort` is set.
+fn rewrite(object: &Object, renumber: &dyn Fn(u32) -> Option<u32>, sort: bool) -> Object {
+    match object {
+        Object::Reference(id) => match renumber(id.number) {
+            Some(number) => Object::Reference(ObjectId::new(number)),
+            None => object.clone(),
+        },
+        Object::Array(array) => {
+            Object::Array(array.iter().map(|o| rewrite(o, renumber, sort)).collect::<PdfArray>())
+        }
+        Object::Dictionary(dict) => Object::Dictionary(rewrite_dictionary(dict, renumber, sort)),
+        Object::Stream(stream) => Object::Stream(PdfStream::from_raw(
+            rewrite_dictionary(&stream.dictionary, renumber, sort),
+            stream.data.clone(),
+        )),
+        other => other.clone(),
+    }
+}
+
+fn rewrite_dictionary(dict: &PdfDictionary, renumber: &dyn Fn(u32) -> Option<u32>, sort: bool) -> PdfDictionary {
+    let mut entries: Vec<_> = dict.iter().collect();
+    if sort {
+        entries.sort_by(|a, b| a.0.cmp(b.0));
+    }
+    let mut rewritten = PdfDictionary::with_capacity(entries.len());
+    for (key, value) in entries {
+        rewritten.set(key.clone(), rewrite(value, renumber, sort));
+    }
+    rewritten
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::object::PdfName;
+
+    fn buffered(number: u32, object: Object) -> Buffered {
+        Buffered {
+            id: ObjectId::new(number),
+            object,
+            encrypt: true,
+        }
+    }
+
+    fn image(data: &[u8]) -> Object {
+        let mut dict = PdfDictionary::new();
+        dict.set("Subtype", Object::Name(PdfName::new_unchecked("Image")));
+        Object::Stream(PdfStream::from_raw(dict, data.to_vec()))
+    }
+
+    fn form(image: u32) -> Object {
+        let mut xobjects = PdfDictionary::new();
+        xobjects.set("Im1", Object::Reference(ObjectId::new(image)));
+        let mut resources = PdfDictionary::new();
+        resources.set("XObject", Object::Dictionary(xobjects));
+        let mut dict = PdfDictionary::new();
+        dict.set("Resources", Object::Dictionary(resources));
+        Object::Stream(PdfStream::from_raw(dict, b"/Im1 Do".to_vec()))
+    }
+
+    #[test]
+    fn test_deduplicate() {
+        let mut page = PdfDictionary::new();
+        page.set("Type", Object::Name(PdfName::new_unchecked("Page")));
+        page.set("Contents", Object::Reference(ObjectId::new(4)));
+        let objects = vec![
+            buffered(1, image(b"pixels")),
+            buffered(2, image(b"pixels")),
+            buffered(3, form(1)),
+            buffered(4, form(2)),
+            buffered(5, Object::Dictionary(page.clone())),
+            buffered(6, Object::Dictionary(page)),
+            buffered(7, image(b"other")),
+        ];
+
+        let merged = deduplicate(objects).unwrap();
+        // Both copies of the image, then both forms, are merged; the pages stay apart
+        let numbers: Vec<u32> = merged.objects.iter().map(|b| b.id.number).collect();
+        assert_eq!(numbers, [1, 2, 3, 4, 5]);
+        assert_eq!([1, 2, 3, 4, 5, 6, 7].map(|n| merged.numbers[&n]), [1, 1, 2, 2, 3, 4, 5]);
+        match &merged.objects[2].object {
+            Object::Dictionary(page) => assert_eq!(page.get("Contents"), Some(&Object::Reference(ObjectId::new(2)))),
+            other => panic!("expected the page, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_normalize_sorts_keys() {
+        let mut a = PdfDictionary::new();
+        a.set("Type", Object::Name(PdfName::new_unchecked("ExtGState")));
+        a.set("CA", Object::Real(0.5));
+        let mut b = PdfDictionary::new();
+        b.set("CA", Object::Real(0.5));
+        b.set("Type", Object::Name(PdfName::new_unchecked("ExtGState")));
+        let merged = deduplicate(vec![buffered(1, Object::Dictionary(a)), buffered(2, Object::Dictionary(b))]).unwrap();
+        assert_eq!(merged.objects.len(), 1);
+    }
+}
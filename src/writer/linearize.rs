@@ -0,0 +1,474 @@
+//! Linearized ("fast web view") file layout.
+//!
+//! A linearized file starts with everything needed to show the first page,
+//! so a viewer reading it over the network can render that page before the
+//! rest arrives. The objects are buffered, sorted into the parts of PDF 1.7
+//! Annex F and renumbered so the first-page objects can have their own
+//! cross-reference section, then written in one pass with offsets computed
+//! up front.
+
+use std::collections::{HashMap, HashSet};
+
+use super::Serializer;
+use crate::error::{PdfResult, WriterError};
+use crate::object::{Object, PdfArray, PdfDictionary, PdfStream};
+use crate::types::ObjectId;
+
+/// An object waiting to be written, and whether it may be encrypted.
+pub(super) struct Buffered {
+    pub id: ObjectId,
+    pub object: Object,
+    pub encrypt: bool,
+}
+
+/// Trailer entries of the document being written.
+pub(super) struct TrailerIds<'a> {
+    pub root: ObjectId,
+    pub info: Option<ObjectId>,
+    pub encrypt: Option<ObjectId>,
+    pub file_id: Option<&'a [u8]>,
+}
+
+/// Object numbers sorted into the parts of a linearized file.
+#[derive(Debug, Default, PartialEq)]
+struct Layout {
+    /// Part 4: the catalog and the encryption dictionary.
+    document: Vec<u32>,
+    /// Part 6: every object the first page needs, the page object first.
+    first_page: Vec<u32>,
+    /// Part 7: the objects only one later page needs, the page object first.
+    pages: Vec<Vec<u32>>,
+    /// Part 8: objects needed by several later pages but not the first.
+    shared: Vec<u32>,
+    /// Part 9: everything else, such as the page tree and outlines.
+    other: Vec<u32>,
+    /// Objects from parts 6 and 8 each later page needs.
+    page_shared: Vec<Vec<u32>>,
+}
+
+/// Lays out `objects` for a file whose header is `header_len` bytes long,
+/// returning the rest of the file.
+///
+/// `encrypt` encrypts an object under its final number.
+pub(super) fn linearize(
+    objects: Vec<Buffered>,
+    trailer: TrailerIds<'_>,
+    header_len: u64,
+    encrypt: impl Fn(&Object, ObjectId) -> PdfResult<Object>,
+) -> PdfResult<Vec<u8>> {
+    let io = |e: std::io::Error| WriterError::Structure(e.to_string());
+    let objects: HashMap<u32, Buffered> = objects.into_iter().map(|b| (b.id.number, b)).collect();
+    let layout = layout(&objects, &trailer);
+
+    // The rest of the file is numbered from 1, then the first-page section
+    // follows: the linearization dictionary, part 4, the hint stream and part 6.
+    let main: Vec<u32> = layout.pages.iter().flatten().chain(&layout.shared).chain(&layout.other).copied().collect();
+    let first_number = main.len() as u32 + 1;
+    let mut numbers: HashMap<u32, u32> = HashMap::new();
+    for (i, old) in main.iter().enumerate() {
+        numbers.insert(*old, i as u32 + 1);
+    }
+    let hint_number = first_number + 1 + layout.document.len() as u32;
+    for (i, old) in layout.document.iter().enumerate() {
+        numbers.insert(*old, first_number + 1 + i as u32);
+    }
+    for (i, old) in layout.first_page.iter().enumerate() {
+        numbers.insert(*old, hint_number + 1 + i as u32);
+    }
+    let size = hint_number + 1 + layout.first_page.len() as u32;
+    let new_id = |old: u32| ObjectId::new(numbers[&old]);
+
+    let mut bodies: HashMap<u32, Vec<u8>> = HashMap::with_capacity(objects.len());
+    for (old, buffered) in &objects {
+        let id = new_id(*old);
+        let object = renumber(&buffered.object, &numbers);
+        let object = if buffered.encrypt { encrypt(&object, id)? } else { object };
+        bodies.insert(*old, serialize(id, &object).map_err(io)?);
+    }
+    let length = |olds: &[u32]| olds.iter().map(|o| bodies[o].len() as u64).sum::<u64>();
+
+    // Fixed-width numbers let the first two parts be sized before the offsets are known
+    let linearization_len = linearization_dict(first_number, [0; 5], numbers[&layout.first_page[0]], layout.page_count()).len();
+    let first_xref_len = first_page_xref(first_number, size, 0, &[], &trailer, &numbers).len()
+        + 20 * (size - first_number) as usize;
+
+    // Offsets in the hint tables are measured as if the hint stream were absent
+    let document_start = header_len + (linearization_len + first_xref_len) as u64;
+    let first_page_start = document_start + length(&layout.document);
+    let mut absent_offsets: HashMap<u32, u64> = HashMap::new();
+    let mut position = first_page_start;
+    for old in layout.first_page.iter().chain(&main) {
+        absent_offsets.insert(*old, position);
+        position += bodies[old].len() as u64;
+    }
+    let (hint_data, shared_table) = hint_tables(&layout, &bodies, &absent_offsets, &numbers, first_page_start);
+    let mut hint_dict = PdfDictionary::new();
+    hint_dict.set("S", Object::Integer(shared_table as i64));
+    let hint = Object::Stream(PdfStream::with_dictionary(hint_dict, hint_data));
+    let hint_id = ObjectId::new(hint_number);
+    let hint = serialize(hint_id, &encrypt(&hint, hint_id)?).map_err(io)?;
+
+    let hint_offset = first_page_start;
+    let shift = |offset: u64| offset + hint.len() as u64;
+    let first_page_end = shift(first_page_start + length(&layout.first_page));
+    let main_xref_offset = shift(position);
+    let mut main_xref = format!("xref\n0 {}", first_number);
+    let main_entries_offset = main_xref_offset + main_xref.len() as u64;
+    main_xref.push('\n');
+    main_xref.push_str("0000000000 65535 f \n");
+    for old in &main {
+        main_xref.push_str(&format!("{:010} 00000 n \n", shift(absent_offsets[old])));
+    }
+    main_xref.push_str(&format!("trailer\n<< /Size {} >>\nstartxref\n{}\n%%EOF\n", first_number, header_len + linearization_len as u64));
+    let file_len = main_xref_offset + main_xref.len() as u64;
+
+    let mut first_offsets = vec![header_len];
+    let mut position = document_start;
+    for old in &layout.document {
+        first_offsets.push(position);
+        position += bodies[old].len() as u64;
+    }
+    first_offsets.push(hint_offset);
+    for old in &layout.first_page {
+        first_offsets.push(shift(absent_offsets[old]));
+    }
+    let first_xref = first_page_xref(first_number, size, main_xref_offset, &first_offsets, &trailer, &numbers);
+    let linearization = linearization_dict(
+        first_number,
+        [file_len, hint_offset, hint.len() as u64, first_page_end, main_entries_offset],
+        numbers[&layout.first_page[0]],
+        layout.page_count(),
+    );
+    debug_assert_eq!(linearization.len(), linearization_len);
+    debug_assert_eq!(first_xref.len(), first_xref_len);
+
+    let mut file = Vec::with_capacity((file_len - header_len) as usize);
+    file.extend_from_slice(linearization.as_bytes());
+    file.extend_from_slice(first_xref.as_bytes());
+    for old in &layout.document {
+        file.extend_from_slice(&bodies[old]);
+    }
+    file.extend_from_slice(&hint);
+    for old in layout.first_page.iter().chain(&main) {
+        file.extend_from_slice(&bodies[old]);
+    }
+    file.extend_from_slice(main_xref.as_bytes());
+    Ok(file)
+}
+
+impl Layout {
+    fn page_count(&self) -> usize {
+        self.pages.len() + 1
+    }
+}
+
+/// Sorts the objects into the parts of a linearized file.
+fn layout(objects: &HashMap<u32, Buffered>, trailer: &TrailerIds<'_>) -> Layout {
+    let catalog = dictionary(objects, trailer.root.number);
+    let mut tree_nodes = HashSet::new();
+    let mut pages = Vec::new();
+    if let Some(Object::Reference(root)) = catalog.and_then(|c| c.get("Pages")) {
+        collect_pages(objects, root.number, &mut tree_nodes, &mut pages);
+    }
+
+    let mut document = vec![trailer.root.number];
+    document.extend(trailer.encrypt.map(|id| id.number));
+    let mut stops: HashSet<u32> = tree_nodes.iter().chain(&pages).chain(&document).copied().collect();
+    stops.extend(trailer.info.map(|id| id.number));
+
+    let reached: Vec<Vec<u32>> = pages.iter().map(|&page| reachable(objects, page, &stops)).collect();
+    let mut layout = Layout {
+        document,
+        ..Default::default()
+    };
+    let first_page: HashSet<u32> = reached.first().into_iter().flatten().copied().collect();
+    let mut users: HashMap<u32, usize> = HashMap::new();
+    for page in reached.iter().skip(1) {
+        for old in page.iter().filter(|o| !first_page.contains(o)) {
+            *users.entry(*old).or_default() += 1;
+        }
+    }
+
+    let mut shared = HashSet::new();
+    for page in reached.iter().skip(1) {
+        layout.pages.push(page.iter().copied().filter(|o| !first_page.contains(o) && users[o] == 1).collect());
+        layout.page_shared.push(page.iter().copied().filter(|o| first_page.contains(o) || users[o] > 1).collect());
+        for old in page.iter().filter(|o| users.get(o).map_or(false, |&n| n > 1)) {
+            if shared.insert(*old) {
+                layout.shared.push(*old);
+            }
+        }
+    }
+    layout.first_page = reached.into_iter().next().unwrap_or_default();
+
+    let placed: HashSet<u32> = layout
+        .document
+        .iter()
+        .chain(&layout.first_page)
+        .chain(layout.pages.iter().flatten())
+        .chain(&layout.shared)
+        .copied()
+        .collect();
+    layout.other = objects.keys().copied().filter(|o| !placed.contains(o)).collect();
+    layout.other.sort_unstable();
+    layout
+}
+
+fn dictionary(objects: &HashMap<u32, Buffered>, number: u32) -> Option<&PdfDictionary> {
+    match objects.get(&number).map(|b| &b.object) {
+        Some(Object::Dictionary(dict)) => Some(dict),
+        Some(Object::Stream(stream)) => Some(&stream.dictionary),
+        _ => None,
+    }
+}
+
+/// Collects the page objects under a page tree node in order, and the tree
+/// nodes themselves.
+fn collect_pages(objects: &HashMap<u32, Buffered>, node: u32, tree_nodes: &mut HashSet<u32>, pages: &mut Vec<u32>) {
+    let dict = match dictionary(objects, node) {
+        Some(dict) => dict,
+        None => return,
+    };
+    match dict.get("Kids") {
+        Some(Object::Array(kids)) if tree_nodes.insert(node) => {
+            for kid in kids.iter() {
+                if let Object::Reference(kid) = kid {
+                    collect_pages(objects, kid.number, tree_nodes, pages);
+                }
+            }
+        }
+        Some(_) => {}
+        None => pages.push(node),
+    }
+}
+
+/// Returns the objects reachable from `page` in depth-first order, without
+/// following /Parent links or entering any object in `stops` — which holds
+/// the other pages, so links between pages do not merge them.
+fn reachable(objects: &HashMap<u32, Buffered>, page: u32, stops: &HashSet<u32>) -> Vec<u32> {
+    let mut order = vec![page];
+    let mut seen: HashSet<u32> = HashSet::from([page]);
+    let mut stack = Vec::new();
+    if let Some(buffered) = objects.get(&page) {
+        push_references(&buffered.object, &mut stack);
+    }
+    while let Some(number) = stack.pop() {
+        if stops.contains(&number) || !objects.contains_key(&number) || !seen.insert(number) {
+            continue;
+        }
+        order.push(number);
+        push_references(&objects[&number].object, &mut stack);
+    }
+    order
+}
+
+/// Pushes the objects `object` refers to, in reverse so they pop in order.
+fn push_references(object: &Object, stack: &mut Vec<u32>) {
+    fn collect(object: &Object, found: &mut Vec<u32>) {
+        match object {
+            Object::Reference(id) => found.push(id.number),
+            Object::Array(array) => array.iter().for_each(|o| collect(o, found)),
+            Object::Dictionary(dict) => collect_dictionary(dict, found),
+            Object::Stream(stream) => collect_dictionary(&stream.dictionary, found),
+            _ => {}
+        }
+    }
+    fn collect_dictionary(dict: &PdfDictionary, found: &mut Vec<u32>) {
+        for (_, value) in dict.iter().filter(|(key, _)| key.as_str() != "Parent") {
+            collect(value, found);
+        }
+    }
+    let mut found = Vec::new();
+    collect(object, &mut found);
+    stack.extend(found.into_iter().rev());
+}
+
+/// Replaces every reference with its new number. References to objects that
+/// were never written are left alone.
+fn renumber(object: &Object, numbers: &HashMap<u32, u32>) -> Object {
+    match object {
+        Object::Reference(id) => match numbers.get(&id.number) {
+            Some(&number) => Object::Reference(ObjectId::new(number)),
+            None => object.clone(),
+        },
+        Object::Array(array) => Object::Array(array.iter().map(|o| renumber(o, numbers)).collect::<PdfArray>()),
+        Object::Dictionary(dict) => Object::Dictionary(renumber_dictionary(dict, numbers)),
+        Object::Stream(stream) => Object::Stream(PdfStream::from_raw(
+            renumber_dictionary(&stream.dictionary, numbers),
+            stream.data.clone(),
+        )),
+        other => other.clone(),
+    }
+}
+
+fn renumber_dictionary(dict: &PdfDictionary, numbers: &HashMap<u32, u32>) -> PdfDictionary {
+    let mut renumbered = PdfDictionary::with_capacity(dict.len());
+    for (key, value) in dict.iter() {
+        renumbered.set(key.clone(), renumber(value, numbers));
+    }
+    renumbered
+}
+
+fn serialize(id: ObjectId, object: &Object) -> std::io::Result<Vec<u8>> {
+    let mut serializer = Serializer::new(Vec::new());
+    serializer.write_object(id, object)?;
+    Ok(serializer.into_inner())
+}
+
+/// Builds the linearization parameter dictionary from the file length, the
+/// hint stream offset and length, the end of the first page and the offset
+/// of the main cross-reference entries.
+fn linearization_dict(number: u32, values: [u64; 5], first_page: u32, pages: usize) -> String {
+    let [file_len, hint_offset, hint_len, first_page_end, main_entries] = values;
+    format!(
+        "{} 0 obj\n<< /Linearized 1 /L {:10} /H [{:10} {:10}] /O {} /E {:10} /N {} /T {:10} >>\nendobj\n",
+        number, file_len, hint_offset, hint_len, first_page, first_page_end, pages, main_entries
+    )
+}
+
+/// Builds the first-page cross-reference section and trailer.
+fn first_page_xref(
+    first: u32,
+    size: u32,
+    prev: u64,
+    offsets: &[u64],
+    trailer: &TrailerIds<'_>,
+    numbers: &HashMap<u32, u32>,
+) -> String {
+    let reference = |id: ObjectId| numbers.get(&id.number).map_or(id, |&n| ObjectId::new(n)).reference_string();
+    let mut xref = format!("xref\n{} {}\n", first, size - first);
+    for offset in offsets {
+        xref.push_str(&format!("{:010} 00000 n \n", offset));
+    }
+    xref.push_str(&format!("trailer\n<< /Size {} /Prev {:10} /Root {} ", size, prev, reference(trailer.root)));
+    if let Some(info) = trailer.info {
+        xref.push_str(&format!("/Info {} ", reference(info)));
+    }
+    if let Some(encrypt) = trailer.encrypt {
+        xref.push_str(&format!("/Encrypt {} ", reference(encrypt)));
+    }
+    if let Some(id) = trailer.file_id {
+        let hex: String = id.iter().map(|b| format!("{:02X}", b)).collect();
+        xref.push_str(&format!("/ID [<{}> <{}>] ", hex, hex));
+    }
+    xref.push_str(">>\nstartxref\n0\n%%EOF\n");
+    xref
+}
+
+/// Builds the page offset and shared object hint tables, returning the
+/// stream data and the offset of the shared object table within it.
+fn hint_tables(
+    layout: &Layout,
+    bodies: &HashMap<u32, Vec<u8>>,
+    offsets: &HashMap<u32, u64>,
+    numbers: &HashMap<u32, u32>,
+    first_page_start: u64,
+) -> (Vec<u8>, usize) {
+    let length = |olds: &[u32]| olds.iter().map(|o| bodies[o].len() as u64).sum::<u64>();
+    let sections: Vec<&[u32]> = std::iter::once(layout.first_page.as_slice())
+        .chain(layout.pages.iter().map(Vec::as_slice))
+        .collect();
+    let object_counts: Vec<u64> = sections.iter().map(|s| s.len() as u64).collect();
+    let page_lengths: Vec<u64> = sections.iter().map(|s| length(s)).collect();
+
+    // Shared object groups, one object each: the first page's, then part 8
+    let groups: Vec<u32> = layout.first_page.iter().chain(&layout.shared).copied().collect();
+    let group_ids: HashMap<u32, u64> = groups.iter().enumerate().map(|(i, o)| (*o, i as u64)).collect();
+    let page_shared: Vec<Vec<u64>> = std::iter::once(Vec::new())
+        .chain(layout.page_shared.iter().map(|s| s.iter().map(|o| group_ids[o]).collect()))
+        .collect();
+
+    let least = |values: &[u64]| values.iter().copied().min().unwrap_or(0);
+    let spread = |values: &[u64]| bits(values.iter().copied().max().unwrap_or(0) - least(values));
+    let shared_count_bits = bits(page_shared.iter().map(|s| s.len() as u64).max().unwrap_or(0));
+    let shared_id_bits = bits(groups.len().saturating_sub(1) as u64);
+
+    // Page offset hint table. Content stream offsets and lengths are not
+    // used by viewers, so every page is described as one content stream.
+    let mut table = BitWriter::default();
+    table.write(least(&object_counts), 32);
+    table.write(first_page_start, 32);
+    table.write(spread(&object_counts) as u64, 16);
+    table.write(least(&page_lengths), 32);
+    table.write(spread(&page_lengths) as u64, 16);
+    table.write(0, 32);
+    table.write(0, 16);
+    table.write(least(&page_lengths), 32);
+    table.write(spread(&page_lengths) as u64, 16);
+    table.write(shared_count_bits as u64, 16);
+    table.write(shared_id_bits as u64, 16);
+    table.write(0, 16);
+    table.write(1, 16);
+    let items: [(&[u64], u32); 2] = [(&object_counts, spread(&object_counts)), (&page_lengths, spread(&page_lengths))];
+    for (values, width) in items {
+        for value in values {
+            table.write(value - least(values), width);
+        }
+        table.pad();
+    }
+    for shared in &page_shared {
+        table.write(shared.len() as u64, shared_count_bits);
+    }
+    table.pad();
+    for id in page_shared.iter().flatten() {
+        table.write(*id, shared_id_bits);
+    }
+    table.pad();
+    for length in &page_lengths {
+        table.write(length - least(&page_lengths), spread(&page_lengths));
+    }
+    table.pad();
+    let shared_table = table.bytes.len();
+
+    // Shared object hint table
+    let group_lengths: Vec<u64> = groups.iter().map(|o| bodies[o].len() as u64).collect();
+    let first_shared = layout.shared.first();
+    table.write(first_shared.map_or(0, |o| numbers[o] as u64), 32);
+    table.write(first_shared.map_or(0, |o| offsets[o]), 32);
+    table.write(layout.first_page.len() as u64, 32);
+    table.write(groups.len() as u64, 32);
+    table.write(0, 16);
+    table.write(least(&group_lengths), 32);
+    table.write(spread(&group_lengths) as u64, 16);
+    for length in &group_lengths {
+        table.write(length - least(&group_lengths), spread(&group_lengths));
+    }
+    table.pad();
+    for _ in &groups {
+        table.write(0, 1);
+    }
+    table.pad();
+    (table.bytes, shared_table)
+}
+
+/// Returns the number of bits needed to store `value`.
+fn bits(value: u64) -> u32 {
+    64 - value.leading_zeros()
+}
+
+/// Packs values into bytes, most significant bit first.
+#[derive(Default)]
+struct BitWriter {
+    bytes: Vec<u8>,
+    /// Bits of the last byte already used, 0 if it is complete.
+    used: u32,
+}
+
+impl BitWriter {
+    fn write(&mut self, value: u64, width: u32) {
+        for bit in (0..width).rev() {
+            if self.used == 0 {
+                self.bytes.push(0);
+            }
+            if value >> bit & 1 == 1 {
+                *self.bytes.last_mut().unwrap() |= 0x80 >> self.used;
+            }
+            self.used = (self.used + 1) % 8;
+        }
+    }
+
+    /// Moves to the next byte boundary.
+    fn pad(&mut self) {
+        self.used = 0;
+    }
+}
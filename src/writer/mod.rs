@@ -1,5 +1,7 @@
 //! PDF file writing functionality.
 
+mod dedup;
+mod linearize;
 mod serializer;
 mod xref;
 
@@ -7,7 +9,7 @@ pub use serializer::Serializer;
 pub use xref::{XrefEntry, XrefTable};
 
 use crate::error::{PdfResult, WriterError};
-use crate::object::Object;
+use crate::object::{Object, PdfArray, PdfDictionary, PdfName, PdfStream, PdfString};
 use crate::types::ObjectId;
 use std::fs::File;
 use std::io::{BufWriter, Write};
@@ -16,16 +18,33 @@ use std::path::Path;
 #[cfg(feature = "encryption")]
 use crate::encryption::EncryptionHandler;
 
+/// Most objects packed into one object stream, so a reader looking up one
+/// object does not have to decompress a huge stream.
+const OBJECTS_PER_STREAM: usize = 100;
+
 /// A PDF writer that manages object allocation and file output.
 pub struct PdfWriter<W: Write> {
     serializer: Serializer<W>,
     xref: XrefTable,
     next_object_number: u32,
     version: String,
+    object_streams: Option<ObjectStreams>,
+    /// Objects held back until the trailer, when writing a linearized file.
+    linearized: Option<Vec<linearize::Buffered>>,
+    /// Objects held back until the trailer, when merging identical objects.
+    deduplicated: Option<Vec<linearize::Buffered>>,
     #[cfg(feature = "encryption")]
     encryption_handler: Option<EncryptionHandler>,
 }
 
+/// Objects waiting to be packed into the next object stream.
+struct ObjectStreams {
+    /// Flate level for the object and cross-reference streams, if compressed.
+    compression_level: Option<u32>,
+    /// Serialized objects, in the order they were written.
+    pending: Vec<(ObjectId, String)>,
+}
+
 impl<W: Write> PdfWriter<W> {
     /// Creates a new PDF writer with the given output.
     pub fn new(writer: W, version: &str) -> Self {
@@ -34,11 +53,40 @@ impl<W: Write> PdfWriter<W> {
             xref: XrefTable::new(),
             next_object_number: 1,
             version: version.to_string(),
+            object_streams: None,
+            linearized: None,
+            deduplicated: None,
             #[cfg(feature = "encryption")]
             encryption_handler: None,
         }
     }
 
+    /// Lays the file out for fast web view: the objects are held back until
+    /// the trailer, then written with the first page and hint tables at the
+    /// front. Takes precedence over object streams.
+    pub fn linearize(&mut self) {
+        self.linearized = Some(Vec::new());
+    }
+
+    /// Holds the objects back until the trailer, then writes identical
+    /// streams, fonts and graphics states once, with every reference pointing
+    /// at the copy kept. Works with linearization and object streams, which
+    /// see only the objects left.
+    pub fn deduplicate(&mut self) {
+        self.deduplicated = Some(Vec::new());
+    }
+
+    /// Packs objects other than streams into object streams and ends the file
+    /// with a cross-reference stream instead of a classic table, both PDF 1.5
+    /// features. Both streams are Flate compressed at `compression_level` if
+    /// given and the `compression` feature is enabled.
+    pub fn use_object_streams(&mut self, compression_level: Option<u32>) {
+        self.object_streams = Some(ObjectStreams {
+            compression_level,
+            pending: Vec::new(),
+        });
+    }
+
     /// Sets the encryption handler for encrypting streams and strings.
     #[cfg(feature = "encryption")]
     pub fn set_encryption_handler(&mut self, handler: EncryptionHandler) {
@@ -86,8 +134,38 @@ impl<W: Write> PdfWriter<W> {
     }
 
     /// Internal method for writing objects with optional encryption.
-    #[allow(unused_variables)]
+    ///
+    /// With object streams, objects that may be packed are queued instead;
+    /// the object stream they end up in is encrypted as a whole.
     fn write_object_internal(&mut self, id: ObjectId, object: &Object, encrypt: bool) -> PdfResult<()> {
+        if let Some(ref mut objects) = self.deduplicated {
+            objects.push(linearize::Buffered {
+                id,
+                object: object.clone(),
+                encrypt,
+            });
+            return Ok(());
+        }
+        // Linearized files are encrypted once the final object numbers are known
+        if let Some(ref mut objects) = self.linearized {
+            objects.push(linearize::Buffered {
+                id,
+                object: object.clone(),
+                encrypt,
+            });
+            return Ok(());
+        }
+        if let Some(ref mut streams) = self.object_streams {
+            // The encryption dictionary, written unencrypted, must stay outside
+            if encrypt && id.generation == 0 && !matches!(object, Object::Stream(_)) {
+                streams.pending.push((id, object.to_pdf_string()));
+                if streams.pending.len() >= OBJECTS_PER_STREAM {
+                    self.flush_object_stream()?;
+                }
+                return Ok(());
+            }
+        }
+
         // Encrypt the object if encryption is enabled and requested
         #[cfg(feature = "encryption")]
         let object = if encrypt {
@@ -112,6 +190,34 @@ impl<W: Write> PdfWriter<W> {
         Ok(())
     }
 
+    /// Writes the queued objects as one object stream.
+    fn flush_object_stream(&mut self) -> PdfResult<()> {
+        let (pending, compression_level) = match self.object_streams {
+            Some(ref mut streams) if !streams.pending.is_empty() => {
+                (std::mem::take(&mut streams.pending), streams.compression_level)
+            }
+            _ => return Ok(()),
+        };
+
+        let stream_id = self.allocate_id();
+        let mut offsets = String::new();
+        let mut objects = String::new();
+        for (index, (id, object)) in pending.iter().enumerate() {
+            offsets.push_str(&format!("{} {} ", id.number, objects.len()));
+            objects.push_str(object);
+            objects.push('\n');
+            self.xref.add_compressed(*id, stream_id.number, index as u32);
+        }
+
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("ObjStm")));
+        dict.set("N", Object::Integer(pending.len() as i64));
+        dict.set("First", Object::Integer(offsets.len() as i64));
+        let stream = PdfStream::with_dictionary(dict, offsets + &objects);
+        let stream = compress(stream, compression_level)?;
+        self.write_object_internal(stream_id, &Object::Stream(stream), true)
+    }
+
     /// Encrypts an object (streams and strings) using the encryption handler.
     #[cfg(feature = "encryption")]
     fn encrypt_object(
@@ -166,6 +272,16 @@ impl<W: Write> PdfWriter<W> {
         }
     }
 
+    /// Encrypts an object of a linearized file under its final number.
+    #[cfg_attr(not(feature = "encryption"), allow(unused_variables))]
+    fn encrypt_for_linearized(&self, object: &Object, id: ObjectId) -> PdfResult<Object> {
+        #[cfg(feature = "encryption")]
+        if let Some(ref handler) = self.encryption_handler {
+            return self.encrypt_object(object, id, handler);
+        }
+        Ok(object.clone())
+    }
+
     /// Writes the trailer, xref table, and EOF.
     pub fn write_trailer(
         &mut self,
@@ -183,6 +299,42 @@ impl<W: Write> PdfWriter<W> {
         encrypt_id: Option<ObjectId>,
         file_id: Option<&[u8]>,
     ) -> PdfResult<()> {
+        if let Some(objects) = self.deduplicated.take() {
+            let merged = dedup::deduplicate(objects)?;
+            let renumber = |id: ObjectId| ObjectId::new(merged.numbers.get(&id.number).copied().unwrap_or(id.number));
+            self.next_object_number = merged.objects.len() as u32 + 1;
+            for b in &merged.objects {
+                self.write_object_internal(b.id, &b.object, b.encrypt)?;
+            }
+            return self.write_trailer_with_encryption(
+                renumber(root_id),
+                info_id.map(renumber),
+                encrypt_id.map(renumber),
+                file_id,
+            );
+        }
+        if let Some(objects) = self.linearized.take() {
+            let trailer = linearize::TrailerIds {
+                root: root_id,
+                info: info_id,
+                encrypt: encrypt_id,
+                file_id,
+            };
+            let file = linearize::linearize(objects, trailer, self.serializer.position(), |object, id| {
+                self.encrypt_for_linearized(object, id)
+            })?;
+            self.serializer
+                .write_bytes(&file)
+                .map_err(|e| WriterError::Structure(e.to_string()))?;
+            self.serializer
+                .flush()
+                .map_err(|e| WriterError::Structure(e.to_string()))?;
+            return Ok(());
+        }
+        if self.object_streams.is_some() {
+            return self.write_xref_stream(root_id, info_id, encrypt_id, file_id);
+        }
+
         // Write the xref table
         let xref_content = self.xref.to_xref_string();
         let xref_offset = self.serializer.position();
@@ -208,12 +360,73 @@ impl<W: Write> PdfWriter<W> {
         Ok(())
     }
 
+    /// Writes the remaining object stream, the cross-reference stream (which
+    /// also takes the place of the trailer), and EOF.
+    fn write_xref_stream(
+        &mut self,
+        root_id: ObjectId,
+        info_id: Option<ObjectId>,
+        encrypt_id: Option<ObjectId>,
+        file_id: Option<&[u8]>,
+    ) -> PdfResult<()> {
+        self.flush_object_stream()?;
+        let compression_level = self.object_streams.as_ref().and_then(|s| s.compression_level);
+
+        // The cross-reference stream lists itself
+        let xref_id = self.allocate_id();
+        let xref_offset = self.serializer.position();
+        self.xref.add_object(xref_id, xref_offset);
+        let (data, widths) = self.xref.to_stream_data();
+
+        let mut dict = PdfDictionary::new();
+        dict.set("Type", Object::Name(PdfName::new_unchecked("XRef")));
+        dict.set("Size", Object::Integer(self.xref.size() as i64));
+        let widths = widths.iter().map(|&w| Object::Integer(w as i64)).collect();
+        dict.set("W", Object::Array(widths));
+        dict.set("Root", Object::Reference(root_id));
+        if let Some(info_id) = info_id {
+            dict.set("Info", Object::Reference(info_id));
+        }
+        if let Some(encrypt_id) = encrypt_id {
+            dict.set("Encrypt", Object::Reference(encrypt_id));
+        }
+        if let Some(id) = file_id {
+            let mut ids = PdfArray::new();
+            ids.push(Object::String(PdfString::Hex(id.to_vec())));
+            ids.push(Object::String(PdfString::Hex(id.to_vec())));
+            dict.set("ID", Object::Array(ids));
+        }
+        let stream = compress(PdfStream::with_dictionary(dict, data), compression_level)?;
+
+        // Cross-reference streams are never encrypted
+        self.serializer
+            .write_object(xref_id, &Object::Stream(stream))
+            .map_err(|e| WriterError::Structure(e.to_string()))?;
+        self.serializer
+            .write_startxref(xref_offset)
+            .map_err(|e| WriterError::Structure(e.to_string()))?;
+        self.serializer
+            .flush()
+            .map_err(|e| WriterError::Structure(e.to_string()))?;
+        Ok(())
+    }
+
     /// Returns the underlying writer.
     pub fn into_inner(self) -> W {
         self.serializer.into_inner()
     }
 }
 
+/// Compresses a stream the writer builds itself, if a level is given.
+#[cfg_attr(not(feature = "compression"), allow(unused_variables))]
+fn compress(stream: PdfStream, compression_level: Option<u32>) -> PdfResult<PdfStream> {
+    #[cfg(feature = "compression")]
+    if let Some(level) = compression_level {
+        return Ok(stream.with_compression_level(level)?);
+    }
+    Ok(stream)
+}
+
 impl PdfWriter<BufWriter<File>> {
     /// Creates a new PDF writer that writes to a file.
     pub fn create_file(path: impl AsRef<Path>, version: &str) -> PdfResult<Self> {
@@ -19,6 +19,14 @@ pub enum XrefEntry {
         /// Generation number.
         generation: u16,
     },
+    /// An object stored in an object stream, which only a cross-reference
+    /// stream can describe.
+    Compressed {
+        /// Object number of the object stream.
+        object_stream: u32,
+        /// Index of the object within the stream.
+        index: u32,
+    },
 }
 
 impl XrefEntry {
@@ -35,7 +43,23 @@ impl XrefEntry {
         }
     }
 
+    /// Creates a new entry for an object in an object stream.
+    pub fn compressed(object_stream: u32, index: u32) -> Self {
+        XrefEntry::Compressed { object_stream, index }
+    }
+
+    /// Returns the type and the two fields of the entry in a cross-reference stream.
+    pub fn stream_fields(&self) -> (u8, u64, u64) {
+        match *self {
+            XrefEntry::Free { next_free, generation } => (0, next_free.into(), generation.into()),
+            XrefEntry::InUse { offset, generation } => (1, offset, generation.into()),
+            XrefEntry::Compressed { object_stream, index } => (2, object_stream.into(), index.into()),
+        }
+    }
+
     /// Formats the entry for the xref table (20 bytes including newline).
+    ///
+    /// A compressed entry has no classic form and is written as free.
     pub fn to_xref_line(&self) -> String {
         match self {
             XrefEntry::Free {
@@ -47,6 +71,7 @@ impl XrefEntry {
             XrefEntry::InUse { offset, generation } => {
                 format!("{:010} {:05} n \n", offset, generation)
             }
+            XrefEntry::Compressed { .. } => XrefEntry::free(0, 65535).to_xref_line(),
         }
     }
 }
@@ -71,6 +96,12 @@ impl XrefTable {
             .push((id.number, XrefEntry::in_use(offset, id.generation)));
     }
 
+    /// Adds an entry for an object stored in an object stream.
+    pub fn add_compressed(&mut self, id: ObjectId, object_stream: u32, index: u32) {
+        self.entries
+            .push((id.number, XrefEntry::compressed(object_stream, index)));
+    }
+
     /// Returns the number of entries (including object 0).
     pub fn size(&self) -> u32 {
         if self.entries.is_empty() {
@@ -108,6 +139,34 @@ impl XrefTable {
         result
     }
 
+    /// Generates the data of a cross-reference stream covering objects 0 to
+    /// `size() - 1`, and the `/W` field widths it uses.
+    pub fn to_stream_data(&self) -> (Vec<u8>, [usize; 3]) {
+        let size = self.size();
+        let entry_map: std::collections::HashMap<u32, &XrefEntry> =
+            self.entries.iter().map(|(n, e)| (*n, e)).collect();
+        let free = XrefEntry::free(0, 65535);
+        let fields: Vec<(u8, u64, u64)> = (0..size)
+            .map(|n| entry_map.get(&n).copied().unwrap_or(&free).stream_fields())
+            .collect();
+
+        // Use as few bytes per field as the largest value allows
+        let width = |max: u64| ((64 - max.leading_zeros() as usize + 7) / 8).max(1);
+        let widths = [
+            1,
+            width(fields.iter().map(|f| f.1).max().unwrap_or(0)),
+            width(fields.iter().map(|f| f.2).max().unwrap_or(0)),
+        ];
+
+        let mut data = Vec::with_capacity(fields.len() * widths.iter().sum::<usize>());
+        for (kind, field2, field3) in fields {
+            data.push(kind);
+            data.extend_from_slice(&field2.to_be_bytes()[8 - widths[1]..]);
+            data.extend_from_slice(&field3.to_be_bytes()[8 - widths[2]..]);
+        }
+        (data, widths)
+    }
+
     /// Returns an iterator over the entries.
     pub fn iter(&self) -> impl Iterator<Item = &(u32, XrefEntry)> {
         self.entries.iter()
@@ -142,6 +201,17 @@ mod tests {
         assert_eq!(table.size(), 6); // Objects 0 through 5
     }
 
+    #[test]
+    fn test_xref_table_to_stream_data() {
+        let mut table = XrefTable::new();
+        table.add_object(ObjectId::new(1), 300);
+        table.add_compressed(ObjectId::new(2), 1, 4);
+
+        let (data, widths) = table.to_stream_data();
+        assert_eq!(widths, [1, 2, 2]);
+        assert_eq!(data, [0, 0, 0, 0xff, 0xff, 1, 0x01, 0x2c, 0, 0, 2, 0, 1, 0, 4]);
+    }
+
     #[test]
     fn test_xref_table_to_string() {
         let mut table = XrefTable::new();